@@ -2,6 +2,7 @@ package lxd
 
 import (
 	"io"
+	"time"
 
 	"github.com/gorilla/websocket"
 
@@ -43,6 +44,11 @@ type ContainerServer interface {
 	GetServer() (server *api.Server, ETag string, err error)
 	UpdateServer(server api.ServerPut, ETag string) (err error)
 	HasExtension(extension string) bool
+	GetTransfers() (transfers []api.Transfer, err error)
+	RelayMigration(transfer api.TransfersPost) (op *Operation, err error)
+	GetMigrationCapabilities() (capabilities *api.MigrationCapabilities, err error)
+	GetPartialMigrationNames() (names []string, err error)
+	DeletePartialMigrations() (op *Operation, err error)
 
 	// Certificate functions
 	GetCertificateFingerprints() (fingerprints []string, err error)
@@ -62,6 +68,10 @@ type ContainerServer interface {
 	UpdateContainer(name string, container api.ContainerPut, ETag string) (op *Operation, err error)
 	RenameContainer(name string, container api.ContainerPost) (op *Operation, err error)
 	MigrateContainer(name string, container api.ContainerPost) (op *Operation, err error)
+	CheckContainerMigration(name string, check api.ContainerMigrationCheckPost) (result *api.ContainerMigrationCheck, err error)
+	RebuildContainer(name string, container api.ContainerRebuildPost) (op *Operation, err error)
+	GetContainerReplication(name string) (replication *api.ContainerReplication, err error)
+	ReplicateContainer(name string) (op *Operation, err error)
 	DeleteContainer(name string) (op *Operation, err error)
 
 	ExecContainer(containerName string, exec api.ContainerExecPost, args *ContainerExecArgs) (*Operation, error)
@@ -79,6 +89,15 @@ type ContainerServer interface {
 	MigrateContainerSnapshot(containerName string, name string, container api.ContainerSnapshotPost) (op *Operation, err error)
 	DeleteContainerSnapshot(containerName string, name string) (op *Operation, err error)
 
+	GetContainerBackupNames(containerName string) (names []string, err error)
+	GetContainerBackups(containerName string) (backups []api.ContainerBackup, err error)
+	GetContainerBackup(containerName string, name string) (backup *api.ContainerBackup, ETag string, err error)
+	CreateContainerBackup(containerName string, backup api.ContainerBackupsPost) (op *Operation, err error)
+	DeleteContainerBackup(containerName string, name string) (op *Operation, err error)
+	GetContainerBackupFile(containerName string, name string) (content io.ReadCloser, err error)
+	GetContainerBackupSignedURL(containerName string, name string, expiresAt time.Time) (signedURL *api.SignedURL, err error)
+	CreateContainerFromBackup(args ContainerBackupArgs) (op *Operation, err error)
+
 	GetContainerState(name string) (state *api.ContainerState, ETag string, err error)
 	UpdateContainerState(name string, state api.ContainerStatePut, ETag string) (op *Operation, err error)
 
@@ -96,6 +115,7 @@ type ContainerServer interface {
 	DeleteImage(fingerprint string) (op *Operation, err error)
 	RefreshImage(fingerprint string) (op *Operation, err error)
 	CreateImageSecret(fingerprint string) (op *Operation, err error)
+	GetImageSignedURL(fingerprint string, expiresAt time.Time) (signedURL *api.SignedURL, err error)
 	CreateImageAlias(alias api.ImageAliasesPost) (err error)
 	UpdateImageAlias(name string, alias api.ImageAliasesEntryPut, ETag string) (err error)
 	RenameImageAlias(name string, alias api.ImageAliasesEntryPost) (err error)
@@ -124,6 +144,9 @@ type ContainerServer interface {
 	RenameProfile(name string, profile api.ProfilePost) (err error)
 	DeleteProfile(name string) (err error)
 
+	// Batch functions ("config_batch" API extension)
+	ApplyBatch(batch api.BatchPost) (op *Operation, err error)
+
 	// Storage pool functions ("storage" API extension)
 	GetStoragePoolNames() (names []string, err error)
 	GetStoragePools() (pools []api.StoragePool, err error)
@@ -131,6 +154,10 @@ type ContainerServer interface {
 	CreateStoragePool(pool api.StoragePoolsPost) (err error)
 	UpdateStoragePool(name string, pool api.StoragePoolPut, ETag string) (err error)
 	DeleteStoragePool(name string) (err error)
+	ForceDeleteStoragePool(name string) (err error)
+	VerifyStoragePool(name string, fix bool) (op *Operation, err error)
+	GetStorageSources() (sources []api.StorageSource, err error)
+	GetStoragePoolResources(name string) (resources *api.StoragePoolResources, err error)
 
 	// Storage volume functions ("storage" API extension)
 	GetStoragePoolVolumeNames(pool string) (names []string, err error)
@@ -138,6 +165,8 @@ type ContainerServer interface {
 	GetStoragePoolVolume(pool string, volType string, name string) (volume *api.StorageVolume, ETag string, err error)
 	CreateStoragePoolVolume(pool string, volume api.StorageVolumesPost) (err error)
 	UpdateStoragePoolVolume(pool string, volType string, name string, volume api.StorageVolumePut, ETag string) (err error)
+	RenameStoragePoolVolume(pool string, volType string, name string, volume api.StorageVolumePost) (err error)
+	GetStoragePoolVolumeState(pool string, volType string, name string) (state *api.StorageVolumeState, err error)
 	DeleteStoragePoolVolume(pool string, volType string, name string) (err error)
 
 	// Internal functions (for internal use)
@@ -193,6 +222,9 @@ type ImageFileRequest struct {
 	// Writer for the rootfs file
 	RootfsFile io.WriteSeeker
 
+	// Writer for the ZFS stream of the image, if the server has one
+	ZfsStreamFile io.WriteSeeker
+
 	// Progress handler (called whenever some progress is made)
 	ProgressHandler func(progress ProgressData)
 
@@ -213,6 +245,9 @@ type ImageFileResponse struct {
 
 	// Size of the rootfs file
 	RootfsSize int64
+
+	// Size of the ZFS stream (0 if the server had none)
+	ZfsStreamSize int64
 }
 
 // The ImageCopyArgs struct is used to pass additional options during image copy
@@ -243,6 +278,32 @@ type ContainerCopyArgs struct {
 
 	// The transfer mode, can be "pull" (default), "push" or "relay"
 	Mode string
+
+	// If set, an existing copy of the container will be brought up to date
+	// rather than failing (API extension: container_refresh)
+	Refresh bool
+
+	// If set, a running source container is frozen and its filesystem
+	// synced while it's copied (API extension: container_copy_quiesce)
+	Quiesce bool
+
+	// What to do with the storage left on the target under the name of the
+	// container, can be "fail" (default), "overwrite" or "rename"
+	// (API extension: migration_conflict_policy)
+	Conflict string
+
+	// If set, the copy is stored on this storage pool of the target
+	// (API extension: container_pool_move)
+	Pool string
+}
+
+// The ContainerBackupArgs struct is used when creating a container from a backup
+type ContainerBackupArgs struct {
+	// The backup tarball
+	BackupFile io.Reader
+
+	// Storage pool to use if the one of the backup doesn't exist
+	PoolName string
 }
 
 // The ContainerSnapshotCopyArgs struct is used to pass additional options during container copy
@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 
@@ -231,6 +232,40 @@ func (r *ProtocolLXD) CopyContainer(source ContainerServer, container api.Contai
 			return nil, fmt.Errorf("The source server is missing the required \"container_push_target\" API extension")
 		}
 
+		if args.Refresh {
+			if !r.HasExtension("container_refresh") {
+				return nil, fmt.Errorf("The target server is missing the required \"container_refresh\" API extension")
+			}
+
+			if r != source {
+				if !r.HasExtension("migration_refresh") {
+					return nil, fmt.Errorf("The target server is missing the required \"migration_refresh\" API extension")
+				}
+
+				if !source.HasExtension("migration_refresh") {
+					return nil, fmt.Errorf("The source server is missing the required \"migration_refresh\" API extension")
+				}
+			}
+		}
+
+		if args.Quiesce {
+			if !r.HasExtension("container_copy_quiesce") {
+				return nil, fmt.Errorf("The target server is missing the required \"container_copy_quiesce\" API extension")
+			}
+
+			if r != source {
+				return nil, fmt.Errorf("Containers can only be quiesced when copied within the same server")
+			}
+		}
+
+		if args.Conflict != "" && !r.HasExtension("migration_conflict_policy") {
+			return nil, fmt.Errorf("The target server is missing the required \"migration_conflict_policy\" API extension")
+		}
+
+		if args.Pool != "" && !r.HasExtension("container_pool_move") {
+			return nil, fmt.Errorf("The target server is missing the required \"container_pool_move\" API extension")
+		}
+
 		// Allow overriding the target name
 		if args.Name != "" {
 			req.Name = args.Name
@@ -238,6 +273,10 @@ func (r *ProtocolLXD) CopyContainer(source ContainerServer, container api.Contai
 
 		req.Source.Live = args.Live
 		req.Source.ContainerOnly = args.ContainerOnly
+		req.Source.Refresh = args.Refresh
+		req.Source.Quiesce = args.Quiesce
+		req.Source.Conflict = args.Conflict
+		req.Source.Pool = args.Pool
 	}
 
 	if req.Source.Live {
@@ -477,6 +516,10 @@ func (r *ProtocolLXD) RenameContainer(name string, container api.ContainerPost)
 		return nil, fmt.Errorf("Can't ask for a migration through RenameContainer")
 	}
 
+	if container.Pool != "" && !r.HasExtension("container_pool_move") {
+		return nil, fmt.Errorf("The server is missing the required \"container_pool_move\" API extension")
+	}
+
 	// Send the request
 	op, _, err := r.queryOperation("POST", fmt.Sprintf("/containers/%s", name), container, "")
 	if err != nil {
@@ -558,6 +601,73 @@ func (r *ProtocolLXD) MigrateContainer(name string, container api.ContainerPost)
 	return op, nil
 }
 
+// CheckContainerMigration checks whether the container can be migrated to the
+// target described by the capabilities it returned, without migrating it
+func (r *ProtocolLXD) CheckContainerMigration(name string, check api.ContainerMigrationCheckPost) (*api.ContainerMigrationCheck, error) {
+	if !r.HasExtension("migration_check") {
+		return nil, fmt.Errorf("The server is missing the required \"migration_check\" API extension")
+	}
+
+	result := api.ContainerMigrationCheck{}
+
+	// Send the request
+	_, err := r.queryStruct("POST", fmt.Sprintf("/containers/%s/migration-check", name), check, "", &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// RebuildContainer requests that LXD re-initializes the rootfs of a stopped
+// container from an image, keeping its configuration
+func (r *ProtocolLXD) RebuildContainer(name string, container api.ContainerRebuildPost) (*Operation, error) {
+	if !r.HasExtension("container_rebuild") {
+		return nil, fmt.Errorf("The server is missing the required \"container_rebuild\" API extension")
+	}
+
+	// Send the request
+	op, _, err := r.queryOperation("POST", fmt.Sprintf("/containers/%s/rebuild", name), container, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
+
+// GetContainerReplication returns the replication status of the container
+func (r *ProtocolLXD) GetContainerReplication(name string) (*api.ContainerReplication, error) {
+	if !r.HasExtension("container_replication") {
+		return nil, fmt.Errorf("The server is missing the required \"container_replication\" API extension")
+	}
+
+	replication := api.ContainerReplication{}
+
+	// Fetch the raw value
+	_, err := r.queryStruct("GET", fmt.Sprintf("/containers/%s/replication", name), nil, "", &replication)
+	if err != nil {
+		return nil, err
+	}
+
+	return &replication, nil
+}
+
+// ReplicateContainer requests that LXD replicates the container to its
+// target now, regardless of its schedule
+func (r *ProtocolLXD) ReplicateContainer(name string) (*Operation, error) {
+	if !r.HasExtension("container_replication") {
+		return nil, fmt.Errorf("The server is missing the required \"container_replication\" API extension")
+	}
+
+	// Send the request
+	op, _, err := r.queryOperation("POST", fmt.Sprintf("/containers/%s/replication", name), nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
+
 // DeleteContainer requests that LXD deletes the container
 func (r *ProtocolLXD) DeleteContainer(name string) (*Operation, error) {
 	// Send the request
@@ -1261,3 +1371,198 @@ func (r *ProtocolLXD) DeleteContainerLogfile(name string, filename string) error
 
 	return nil
 }
+
+// GetContainerBackupNames returns a list of backup names for the container
+func (r *ProtocolLXD) GetContainerBackupNames(containerName string) ([]string, error) {
+	if !r.HasExtension("container_backup") {
+		return nil, fmt.Errorf("The server is missing the required \"container_backup\" API extension")
+	}
+
+	urls := []string{}
+
+	// Fetch the raw value
+	_, err := r.queryStruct("GET", fmt.Sprintf("/containers/%s/backups", containerName), nil, "", &urls)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse it
+	names := []string{}
+	for _, url := range urls {
+		fields := strings.Split(url, fmt.Sprintf("/containers/%s/backups/", containerName))
+		names = append(names, fields[len(fields)-1])
+	}
+
+	return names, nil
+}
+
+// GetContainerBackups returns a list of backups for the container
+func (r *ProtocolLXD) GetContainerBackups(containerName string) ([]api.ContainerBackup, error) {
+	if !r.HasExtension("container_backup") {
+		return nil, fmt.Errorf("The server is missing the required \"container_backup\" API extension")
+	}
+
+	backups := []api.ContainerBackup{}
+
+	// Fetch the raw value
+	_, err := r.queryStruct("GET", fmt.Sprintf("/containers/%s/backups?recursion=1", containerName), nil, "", &backups)
+	if err != nil {
+		return nil, err
+	}
+
+	return backups, nil
+}
+
+// GetContainerBackup returns a Backup struct for the provided container and backup names
+func (r *ProtocolLXD) GetContainerBackup(containerName string, name string) (*api.ContainerBackup, string, error) {
+	if !r.HasExtension("container_backup") {
+		return nil, "", fmt.Errorf("The server is missing the required \"container_backup\" API extension")
+	}
+
+	backup := api.ContainerBackup{}
+
+	// Fetch the raw value
+	etag, err := r.queryStruct("GET", fmt.Sprintf("/containers/%s/backups/%s", containerName, name), nil, "", &backup)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &backup, etag, nil
+}
+
+// CreateContainerBackup requests that LXD creates a new backup for the container
+func (r *ProtocolLXD) CreateContainerBackup(containerName string, backup api.ContainerBackupsPost) (*Operation, error) {
+	if !r.HasExtension("container_backup") {
+		return nil, fmt.Errorf("The server is missing the required \"container_backup\" API extension")
+	}
+
+	// Send the request
+	op, _, err := r.queryOperation("POST", fmt.Sprintf("/containers/%s/backups", containerName), backup, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
+
+// DeleteContainerBackup requests that LXD deletes the container backup
+func (r *ProtocolLXD) DeleteContainerBackup(containerName string, name string) (*Operation, error) {
+	if !r.HasExtension("container_backup") {
+		return nil, fmt.Errorf("The server is missing the required \"container_backup\" API extension")
+	}
+
+	// Send the request
+	op, _, err := r.queryOperation("DELETE", fmt.Sprintf("/containers/%s/backups/%s", containerName, name), nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
+
+// GetContainerBackupFile returns the tarball of the container backup
+func (r *ProtocolLXD) GetContainerBackupFile(containerName string, name string) (io.ReadCloser, error) {
+	if !r.HasExtension("container_backup") {
+		return nil, fmt.Errorf("The server is missing the required \"container_backup\" API extension")
+	}
+
+	// Prepare the HTTP request
+	url := fmt.Sprintf("%s/1.0/containers/%s/backups/%s/export", r.httpHost, containerName, name)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set the user agent
+	if r.httpUserAgent != "" {
+		req.Header.Set("User-Agent", r.httpUserAgent)
+	}
+
+	// Send the request
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the return value for a cleaner error
+	if resp.StatusCode != http.StatusOK {
+		_, _, err := r.parseResponse(resp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resp.Body, err
+}
+
+// GetContainerBackupSignedURL returns a URL the tarball of the container
+// backup can be downloaded from without a trusted certificate until the given
+// time
+func (r *ProtocolLXD) GetContainerBackupSignedURL(containerName string, name string, expiresAt time.Time) (*api.SignedURL, error) {
+	if !r.HasExtension("signed_urls") {
+		return nil, fmt.Errorf("The server is missing the required \"signed_urls\" API extension")
+	}
+
+	signedURL := api.SignedURL{}
+
+	// Send the request
+	_, err := r.queryStruct("POST", fmt.Sprintf("/containers/%s/backups/%s/signed-url", containerName, name), api.SignedURLPost{ExpiresAt: expiresAt}, "", &signedURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signedURL, nil
+}
+
+// CreateContainerFromBackup creates the container stored in a backup tarball
+func (r *ProtocolLXD) CreateContainerFromBackup(args ContainerBackupArgs) (*Operation, error) {
+	if !r.HasExtension("container_backup") {
+		return nil, fmt.Errorf("The server is missing the required \"container_backup\" API extension")
+	}
+
+	// Prepare the HTTP request
+	reqURL := fmt.Sprintf("%s/1.0/containers", r.httpHost)
+	req, err := http.NewRequest("POST", reqURL, args.BackupFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Setup the headers
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if args.PoolName != "" {
+		req.Header.Set("X-LXD-pool", args.PoolName)
+	}
+
+	// Set the user agent
+	if r.httpUserAgent != "" {
+		req.Header.Set("User-Agent", r.httpUserAgent)
+	}
+
+	// Send the request
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Handle errors
+	response, _, err := r.parseResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get to the operation
+	respOperation, err := response.MetadataAsOperation()
+	if err != nil {
+		return nil, err
+	}
+
+	// Setup an Operation wrapper
+	op := Operation{
+		Operation: *respOperation,
+		r:         r,
+		chActive:  make(chan bool),
+	}
+
+	return &op, nil
+}
@@ -11,6 +11,7 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/api"
@@ -203,6 +204,11 @@ func (r *ProtocolLXD) GetPrivateImageFile(fingerprint string, secret string, req
 			return nil, fmt.Errorf("Image fingerprint doesn't match. Got %s expected %s", hash, fingerprint)
 		}
 
+		err = r.getPrivateImageZfsStream(fingerprint, secret, req, &resp)
+		if err != nil {
+			return nil, err
+		}
+
 		return &resp, nil
 	}
 
@@ -230,9 +236,66 @@ func (r *ProtocolLXD) GetPrivateImageFile(fingerprint string, secret string, req
 		return nil, fmt.Errorf("Image fingerprint doesn't match. Got %s expected %s", hash, fingerprint)
 	}
 
+	err = r.getPrivateImageZfsStream(fingerprint, secret, req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
 	return &resp, nil
 }
 
+// getPrivateImageZfsStream downloads the ZFS stream of an image into
+// req.ZfsStreamFile, if one was requested and the server has it
+func (r *ProtocolLXD) getPrivateImageZfsStream(fingerprint string, secret string, req ImageFileRequest, resp *ImageFileResponse) error {
+	if req.ZfsStreamFile == nil || !r.HasExtension("storage_zfs_image_streams") {
+		return nil
+	}
+
+	// Build the URL
+	url := fmt.Sprintf("%s/1.0/images/%s/export?type=zfs", r.httpHost, fingerprint)
+	if secret != "" {
+		url = fmt.Sprintf("%s&secret=%s", url, secret)
+	}
+
+	// Prepare the download request
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	if r.httpUserAgent != "" {
+		request.Header.Set("User-Agent", r.httpUserAgent)
+	}
+
+	// Start the request
+	response, doneCh, err := cancel.CancelableDownload(req.Canceler, r.http, request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	defer close(doneCh)
+
+	// Most images don't come with a stream
+	if response.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	if response.StatusCode != http.StatusOK {
+		_, _, err := r.parseResponse(response)
+		if err != nil {
+			return err
+		}
+	}
+
+	size, err := io.Copy(req.ZfsStreamFile, response.Body)
+	if err != nil {
+		return err
+	}
+	resp.ZfsStreamSize = size
+
+	return nil
+}
+
 // GetImageAliases returns the list of available aliases as ImageAliasesEntry structs
 func (r *ProtocolLXD) GetImageAliases() ([]api.ImageAliasesEntry, error) {
 	aliases := []api.ImageAliasesEntry{}
@@ -287,6 +350,12 @@ func (r *ProtocolLXD) CreateImage(image api.ImagesPost, args *ImageCreateArgs) (
 		}
 	}
 
+	if image.Source != nil && image.Source.Type == "build" {
+		if !r.HasExtension("image_build") {
+			return nil, fmt.Errorf("The server is missing the required \"image_build\" API extension")
+		}
+	}
+
 	// Send the JSON based request
 	if args == nil {
 		op, _, err := r.queryOperation("POST", "/images", image, "")
@@ -615,6 +684,24 @@ func (r *ProtocolLXD) CreateImageSecret(fingerprint string) (*Operation, error)
 	return op, nil
 }
 
+// GetImageSignedURL returns a URL the image can be downloaded from without a
+// trusted certificate until the given time
+func (r *ProtocolLXD) GetImageSignedURL(fingerprint string, expiresAt time.Time) (*api.SignedURL, error) {
+	if !r.HasExtension("signed_urls") {
+		return nil, fmt.Errorf("The server is missing the required \"signed_urls\" API extension")
+	}
+
+	signedURL := api.SignedURL{}
+
+	// Send the request
+	_, err := r.queryStruct("POST", fmt.Sprintf("/images/%s/signed-url", fingerprint), api.SignedURLPost{ExpiresAt: expiresAt}, "", &signedURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signedURL, nil
+}
+
 // CreateImageAlias sets up a new image alias
 func (r *ProtocolLXD) CreateImageAlias(alias api.ImageAliasesPost) error {
 	// Send the request
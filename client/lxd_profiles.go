@@ -98,3 +98,19 @@ func (r *ProtocolLXD) DeleteProfile(name string) error {
 
 	return nil
 }
+
+// ApplyBatch changes several profiles and containers at once, reverting all
+// the changes if any of them fails
+func (r *ProtocolLXD) ApplyBatch(batch api.BatchPost) (*Operation, error) {
+	if !r.HasExtension("config_batch") {
+		return nil, fmt.Errorf("The server is missing the required \"config_batch\" API extension")
+	}
+
+	// Send the request
+	op, _, err := r.queryOperation("POST", "/batch", batch, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
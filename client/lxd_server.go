@@ -1,6 +1,9 @@
 package lxd
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/api"
 )
@@ -53,3 +56,95 @@ func (r *ProtocolLXD) HasExtension(extension string) bool {
 
 	return false
 }
+
+// GetTransfers returns the migrations and copies of containers in flight
+func (r *ProtocolLXD) GetTransfers() ([]api.Transfer, error) {
+	if !r.HasExtension("shutdown_transfers") {
+		return nil, fmt.Errorf("The server is missing the required \"shutdown_transfers\" API extension")
+	}
+
+	transfers := []api.Transfer{}
+
+	// Fetch the raw value
+	_, err := r.queryStruct("GET", "/transfers", nil, "", &transfers)
+	if err != nil {
+		return nil, err
+	}
+
+	return transfers, nil
+}
+
+// GetMigrationCapabilities returns what the server supports as the target of
+// a migration
+func (r *ProtocolLXD) GetMigrationCapabilities() (*api.MigrationCapabilities, error) {
+	if !r.HasExtension("migration_check") {
+		return nil, fmt.Errorf("The server is missing the required \"migration_check\" API extension")
+	}
+
+	capabilities := api.MigrationCapabilities{}
+
+	// Fetch the raw value
+	_, err := r.queryStruct("GET", "/migration", nil, "", &capabilities)
+	if err != nil {
+		return nil, err
+	}
+
+	return &capabilities, nil
+}
+
+// GetPartialMigrationNames returns the names of the containers left behind by
+// migrations which never completed
+func (r *ProtocolLXD) GetPartialMigrationNames() ([]string, error) {
+	if !r.HasExtension("migration_partial") {
+		return nil, fmt.Errorf("The server is missing the required \"migration_partial\" API extension")
+	}
+
+	urls := []string{}
+
+	// Fetch the raw value
+	_, err := r.queryStruct("GET", "/migration/partial", nil, "", &urls)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse it
+	names := []string{}
+	for _, url := range urls {
+		fields := strings.Split(url, "/containers/")
+		names = append(names, fields[len(fields)-1])
+	}
+
+	return names, nil
+}
+
+// DeletePartialMigrations requests that LXD deletes the containers left behind
+// by migrations which never completed
+func (r *ProtocolLXD) DeletePartialMigrations() (*Operation, error) {
+	if !r.HasExtension("migration_partial") {
+		return nil, fmt.Errorf("The server is missing the required \"migration_partial\" API extension")
+	}
+
+	// Send the request
+	op, _, err := r.queryOperation("DELETE", "/migration/partial", nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
+
+// RelayMigration requests that LXD relays a migration between two servers
+// which can't reach each other
+func (r *ProtocolLXD) RelayMigration(transfer api.TransfersPost) (*Operation, error) {
+	if !r.HasExtension("migration_relay") {
+		return nil, fmt.Errorf("The server is missing the required \"migration_relay\" API extension")
+	}
+
+	// Send the request
+	op, _, err := r.queryOperation("POST", "/transfers", transfer, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
@@ -95,3 +95,71 @@ func (r *ProtocolLXD) DeleteStoragePool(name string) error {
 
 	return nil
 }
+
+// ForceDeleteStoragePool deletes a storage pool along with the images cached
+// on it
+func (r *ProtocolLXD) ForceDeleteStoragePool(name string) error {
+	if !r.HasExtension("storage_delete_force") {
+		return fmt.Errorf("The server is missing the required \"storage_delete_force\" API extension")
+	}
+
+	// Send the request
+	_, _, err := r.query("DELETE", fmt.Sprintf("/storage-pools/%s?force=1", name), nil, "")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// VerifyStoragePool checks the on-disk state of a storage pool against the
+// database, optionally fixing the divergences which are safe to fix
+func (r *ProtocolLXD) VerifyStoragePool(name string, fix bool) (*Operation, error) {
+	if !r.HasExtension("storage_pool_verify") {
+		return nil, fmt.Errorf("The server is missing the required \"storage_pool_verify\" API extension")
+	}
+
+	// Send the request
+	op, _, err := r.queryOperation("POST", fmt.Sprintf("/storage-pools/%s/verify", name), api.StoragePoolVerifyPost{Fix: fix}, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
+
+// GetStorageSources returns the block devices, zpools and volume groups new
+// storage pools can be created on
+func (r *ProtocolLXD) GetStorageSources() ([]api.StorageSource, error) {
+	if !r.HasExtension("storage_sources") {
+		return nil, fmt.Errorf("The server is missing the required \"storage_sources\" API extension")
+	}
+
+	sources := []api.StorageSource{}
+
+	// Fetch the raw value
+	_, err := r.queryStruct("GET", "/storage-sources", nil, "", &sources)
+	if err != nil {
+		return nil, err
+	}
+
+	return sources, nil
+}
+
+// GetStoragePoolResources returns the space used on a storage pool and the
+// forecast of its growth
+func (r *ProtocolLXD) GetStoragePoolResources(name string) (*api.StoragePoolResources, error) {
+	if !r.HasExtension("storage_pool_forecast") {
+		return nil, fmt.Errorf("The server is missing the required \"storage_pool_forecast\" API extension")
+	}
+
+	resources := api.StoragePoolResources{}
+
+	// Fetch the raw value
+	_, err := r.queryStruct("GET", fmt.Sprintf("/storage-pools/%s/resources", name), nil, "", &resources)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resources, nil
+}
@@ -61,6 +61,10 @@ func (r *ProtocolLXD) GetStoragePoolVolume(pool string, volType string, name str
 
 // CreateStoragePoolVolume defines a new storage volume
 func (r *ProtocolLXD) CreateStoragePoolVolume(pool string, volume api.StorageVolumesPost) error {
+	if volume.Source.Name != "" && !r.HasExtension("storage_volume_copy") {
+		return fmt.Errorf("The server is missing the required \"storage_volume_copy\" API extension")
+	}
+
 	// Send the request
 	_, _, err := r.query("POST", fmt.Sprintf("/storage-pools/%s/volumes/%s", pool, volume.Type), volume, "")
 	if err != nil {
@@ -81,6 +85,38 @@ func (r *ProtocolLXD) UpdateStoragePoolVolume(pool string, volType string, name
 	return nil
 }
 
+// RenameStoragePoolVolume renames a storage volume
+func (r *ProtocolLXD) RenameStoragePoolVolume(pool string, volType string, name string, volume api.StorageVolumePost) error {
+	if !r.HasExtension("storage_volume_rename") {
+		return fmt.Errorf("The server is missing the required \"storage_volume_rename\" API extension")
+	}
+
+	// Send the request
+	_, _, err := r.query("POST", fmt.Sprintf("/storage-pools/%s/volumes/%s/%s", pool, volType, name), volume, "")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetStoragePoolVolumeState returns the space used by a storage volume
+func (r *ProtocolLXD) GetStoragePoolVolumeState(pool string, volType string, name string) (*api.StorageVolumeState, error) {
+	if !r.HasExtension("storage_volume_state") {
+		return nil, fmt.Errorf("The server is missing the required \"storage_volume_state\" API extension")
+	}
+
+	state := api.StorageVolumeState{}
+
+	// Fetch the raw value
+	_, err := r.queryStruct("GET", fmt.Sprintf("/storage-pools/%s/volumes/%s/%s/state", pool, volType, name), nil, "", &state)
+	if err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
 // DeleteStoragePoolVolume deletes a storage pool
 func (r *ProtocolLXD) DeleteStoragePoolVolume(pool string, volType string, name string) error {
 	// Send the request
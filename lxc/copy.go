@@ -17,6 +17,10 @@ type copyCmd struct {
 	ephem         bool
 	containerOnly bool
 	mode          string
+	refresh       bool
+	quiesce       bool
+	conflict      string
+	storage       string
 }
 
 func (c *copyCmd) showByDefault() bool {
@@ -25,9 +29,22 @@ func (c *copyCmd) showByDefault() bool {
 
 func (c *copyCmd) usage() string {
 	return i18n.G(
-		`Usage: lxc copy [<remote>:]<source>[/<snapshot>] [[<remote>:]<destination>] [--ephemeral|e] [--profile|-p <profile>...] [--config|-c <key=value>...] [--container-only]
+		`Usage: lxc copy [<remote>:]<source>[/<snapshot>] [[<remote>:]<destination>] [--ephemeral|e] [--profile|-p <profile>...] [--config|-c <key=value>...] [--container-only] [--refresh] [--quiesce] [--conflict=fail|overwrite|rename] [--storage|-s <pool>]
 
-Copy containers within or in between LXD instances.`)
+Copy containers within or in between LXD instances.
+
+With --refresh, an existing copy of the container is brought up to date by
+only sending what changed since the latest snapshot they share.
+
+With --quiesce, a running container is frozen and its filesystem synced while
+it's copied within the same LXD instance, for a consistent copy.
+
+With --conflict, the storage left on the target LXD instance under the name of
+the container is deleted ("overwrite") or set aside under another name
+("rename") rather than failing the copy ("fail").
+
+With --storage, the copy is stored on the given storage pool of the target LXD
+instance rather than on the one of its root disk device.`)
 }
 
 func (c *copyCmd) flags() {
@@ -39,6 +56,11 @@ func (c *copyCmd) flags() {
 	gnuflag.BoolVar(&c.ephem, "e", false, i18n.G("Ephemeral container"))
 	gnuflag.StringVar(&c.mode, "mode", "pull", i18n.G("Transfer mode. One of pull (default), push or relay."))
 	gnuflag.BoolVar(&c.containerOnly, "container-only", false, i18n.G("Copy the container without its snapshots"))
+	gnuflag.BoolVar(&c.refresh, "refresh", false, i18n.G("Update an existing copy of the container"))
+	gnuflag.BoolVar(&c.quiesce, "quiesce", false, i18n.G("Freeze a running container while copying it"))
+	gnuflag.StringVar(&c.conflict, "conflict", "", i18n.G("What to do with the storage left under the container's name on the target. One of fail (default), overwrite or rename."))
+	gnuflag.StringVar(&c.storage, "storage", "", i18n.G("Storage pool name"))
+	gnuflag.StringVar(&c.storage, "s", "", i18n.G("Storage pool name"))
 }
 
 func (c *copyCmd) copyContainer(conf *config.Config, sourceResource string, destResource string, keepVolatile bool, ephemeral int, stateful bool, containerOnly bool, mode string) error {
@@ -142,6 +164,10 @@ func (c *copyCmd) copyContainer(conf *config.Config, sourceResource string, dest
 			Live:          stateful,
 			ContainerOnly: containerOnly,
 			Mode:          mode,
+			Refresh:       c.refresh,
+			Quiesce:       c.quiesce,
+			Conflict:      c.conflict,
+			Pool:          c.storage,
 		}
 
 		// Copy of a container into a new container
@@ -115,6 +115,9 @@ func (c *infoCmd) containerInfo(d lxd.ContainerServer, remote config.Remote, nam
 	fmt.Printf(i18n.G("Profiles: %s")+"\n", strings.Join(ct.Profiles, ", "))
 	if cs.Pid != 0 {
 		fmt.Printf(i18n.G("Pid: %d")+"\n", cs.Pid)
+		if cs.Init != "" {
+			fmt.Printf(i18n.G("Init: %s")+"\n", cs.Init)
+		}
 
 		// IP addresses
 		ipInfo := ""
@@ -155,6 +158,27 @@ func (c *infoCmd) containerInfo(d lxd.ContainerServer, remote config.Remote, nam
 			fmt.Printf(diskInfo)
 		}
 
+		// Disk I/O
+		diskIOInfo := ""
+		if cs.Disk != nil {
+			for entry, disk := range cs.Disk {
+				if disk.ReadBytes == 0 && disk.WriteBytes == 0 && disk.ReadOps == 0 && disk.WriteOps == 0 {
+					continue
+				}
+
+				diskIOInfo += fmt.Sprintf("    %s:\n", entry)
+				diskIOInfo += fmt.Sprintf("      %s: %s\n", i18n.G("Bytes read"), shared.GetByteSizeString(disk.ReadBytes, 2))
+				diskIOInfo += fmt.Sprintf("      %s: %s\n", i18n.G("Bytes written"), shared.GetByteSizeString(disk.WriteBytes, 2))
+				diskIOInfo += fmt.Sprintf("      %s: %d\n", i18n.G("Read operations"), disk.ReadOps)
+				diskIOInfo += fmt.Sprintf("      %s: %d\n", i18n.G("Write operations"), disk.WriteOps)
+			}
+		}
+
+		if diskIOInfo != "" {
+			fmt.Println(fmt.Sprintf("  %s", i18n.G("Disk I/O:")))
+			fmt.Printf(diskIOInfo)
+		}
+
 		// CPU usage
 		cpuInfo := ""
 		if cs.CPU.Usage != 0 {
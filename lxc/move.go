@@ -13,6 +13,8 @@ import (
 type moveCmd struct {
 	containerOnly bool
 	mode          string
+	conflict      string
+	storage       string
 }
 
 func (c *moveCmd) showByDefault() bool {
@@ -21,7 +23,7 @@ func (c *moveCmd) showByDefault() bool {
 
 func (c *moveCmd) usage() string {
 	return i18n.G(
-		`Usage: lxc move [<remote>:]<container>[/<snapshot>] [<remote>:][<container>[/<snapshot>]] [--container-only]
+		`Usage: lxc move [<remote>:]<container>[/<snapshot>] [<remote>:][<container>[/<snapshot>]] [--container-only] [--conflict=fail|overwrite|rename] [--storage|-s <pool>]
 
 Move containers within or in between LXD instances.
 
@@ -31,6 +33,9 @@ lxc move [<remote>:]<source container> [<remote>:][<destination container>] [--c
 lxc move <old name> <new name> [--container-only]
     Rename a local container.
 
+lxc move <container> [<new name>] --storage <pool>
+    Move a stopped container and its snapshots to another storage pool of the same LXD instance.
+
 lxc move <container>/<old snapshot name> <container>/<new snapshot name>
     Rename a snapshot.`)
 }
@@ -38,9 +43,17 @@ lxc move <container>/<old snapshot name> <container>/<new snapshot name>
 func (c *moveCmd) flags() {
 	gnuflag.BoolVar(&c.containerOnly, "container-only", false, i18n.G("Move the container without its snapshots"))
 	gnuflag.StringVar(&c.mode, "mode", "pull", i18n.G("Transfer mode. One of pull (default), push or relay."))
+	gnuflag.StringVar(&c.conflict, "conflict", "", i18n.G("What to do with the storage left under the container's name on the target. One of fail (default), overwrite or rename."))
+	gnuflag.StringVar(&c.storage, "storage", "", i18n.G("Storage pool name"))
+	gnuflag.StringVar(&c.storage, "s", "", i18n.G("Storage pool name"))
 }
 
 func (c *moveCmd) run(conf *config.Config, args []string) error {
+	// Moving to another storage pool keeps the name by default.
+	if len(args) == 1 && c.storage != "" {
+		args = append(args, args[0])
+	}
+
 	if len(args) != 2 {
 		return errArgs
 	}
@@ -85,8 +98,9 @@ func (c *moveCmd) run(conf *config.Config, args []string) error {
 			return op.Wait()
 		}
 
-		// Container rename
-		op, err := source.RenameContainer(sourceName, api.ContainerPost{Name: destName})
+		// Container rename, possibly along with a move to another
+		// storage pool
+		op, err := source.RenameContainer(sourceName, api.ContainerPost{Name: destName, Pool: c.storage})
 		if err != nil {
 			return err
 		}
@@ -94,7 +108,7 @@ func (c *moveCmd) run(conf *config.Config, args []string) error {
 		return op.Wait()
 	}
 
-	cpy := copyCmd{}
+	cpy := copyCmd{conflict: c.conflict, storage: c.storage}
 
 	// A move is just a copy followed by a delete; however, we want to
 	// keep the volatile entries around since we are moving the container.
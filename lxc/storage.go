@@ -16,11 +16,14 @@ import (
 	"github.com/lxc/lxd/lxc/config"
 	"github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/gnuflag"
 	"github.com/lxc/lxd/shared/i18n"
 	"github.com/lxc/lxd/shared/termios"
 )
 
 type storageCmd struct {
+	force   bool
+	flatten bool
 }
 
 func (c *storageCmd) showByDefault() bool {
@@ -71,6 +74,9 @@ lxc storage list [<remote>:]
 lxc storage show [<remote>:]<pool>
     Show details of a storage pool.
 
+lxc storage info [<remote>:]<pool>
+    Show the space used on a storage pool and how fast it grows.
+
 lxc storage create [<remote>:]<pool> <driver> [key=value]...
     Create a storage pool.
 
@@ -83,8 +89,8 @@ lxc storage set [<remote>:]<pool> <key> <value>
 lxc storage unset [<remote>:]<pool> <key>
     Unset storage pool configuration.
 
-lxc storage delete [<remote>:]<pool>
-    Delete a storage pool.
+lxc storage delete [<remote>:]<pool> [--force]
+    Delete a storage pool. The images cached on it are only removed along with it if forced.
 
 lxc storage edit [<remote>:]<pool>
     Edit storage pool, either by launching external editor or reading STDIN.
@@ -96,6 +102,9 @@ lxc storage volume list [<remote>:]<pool>
 lxc storage volume show [<remote>:]<pool> <volume>
     Show details of a storage volume on a storage pool.
 
+lxc storage volume info [<remote>:]<pool> <volume>
+    Show the space used by a storage volume and its snapshots.
+
 lxc storage volume create [<remote>:]<pool> <volume> [key=value]...
     Create a storage volume on a storage pool.
 
@@ -111,6 +120,13 @@ lxc storage volume unset [<remote>:]<pool> <volume> <key>
 lxc storage volume delete [<remote>:]<pool> <volume>
     Delete a storage volume on a storage pool.
 
+lxc storage volume rename [<remote>:]<pool> <old name> <new name>
+    Rename a storage volume on a storage pool, along with the devices using it.
+
+lxc storage volume copy [<remote>:]<pool> <source> <target> [--flatten]
+    Copy a storage volume to a new one on the same storage pool.
+    Unless flattened, the copy shares its data with the source where the driver allows it.
+
 lxc storage volume edit [<remote>:]<pool> <volume>
     Edit storage pool, either by launching external editor or reading STDIN.
 
@@ -142,7 +158,10 @@ lxc storage volume show default container/data
     Will show the properties of the filesystem for a container called "data" in the "default" pool.`)
 }
 
-func (c *storageCmd) flags() {}
+func (c *storageCmd) flags() {
+	gnuflag.BoolVar(&c.force, "force", false, i18n.G("Remove the images cached on the storage pool along with it"))
+	gnuflag.BoolVar(&c.flatten, "flatten", false, i18n.G("Make the copy independent from its source"))
+}
 
 func (c *storageCmd) run(conf *config.Config, args []string) error {
 	if len(args) < 1 {
@@ -183,6 +202,13 @@ func (c *storageCmd) run(conf *config.Config, args []string) error {
 			pool := args[2]
 			volume := args[3]
 			return c.doStoragePoolVolumeAttachProfile(client, pool, volume, args[4:])
+		case "copy":
+			if len(args) != 5 {
+				return errArgs
+			}
+			pool := args[2]
+			volume := args[3]
+			return c.doStoragePoolVolumeCopy(client, pool, volume, args[4])
 		case "create":
 			if len(args) < 4 {
 				return errArgs
@@ -218,6 +244,13 @@ func (c *storageCmd) run(conf *config.Config, args []string) error {
 			pool := args[2]
 			volume := args[3]
 			return c.doStoragePoolVolumeEdit(client, pool, volume)
+		case "info":
+			if len(args) != 4 {
+				return errArgs
+			}
+			pool := args[2]
+			volume := args[3]
+			return c.doStoragePoolVolumeInfo(client, pool, volume)
 		case "get":
 			if len(args) < 4 {
 				return errArgs
@@ -231,6 +264,13 @@ func (c *storageCmd) run(conf *config.Config, args []string) error {
 			}
 			pool := args[2]
 			return c.doStoragePoolVolumesList(conf, remote, pool, args)
+		case "rename":
+			if len(args) != 5 {
+				return errArgs
+			}
+			pool := args[2]
+			volume := args[3]
+			return c.doStoragePoolVolumeRename(client, pool, volume, args[4])
 		case "set":
 			if len(args) < 4 {
 				return errArgs
@@ -288,6 +328,8 @@ func (c *storageCmd) run(conf *config.Config, args []string) error {
 				return errArgs
 			}
 			return c.doStoragePoolShow(client, pool)
+		case "info":
+			return c.doStoragePoolInfo(client, pool)
 		default:
 			return errArgs
 		}
@@ -519,7 +561,12 @@ func (c *storageCmd) doStoragePoolVolumeDetachProfile(client lxd.ContainerServer
 }
 
 func (c *storageCmd) doStoragePoolDelete(client lxd.ContainerServer, name string) error {
-	err := client.DeleteStoragePool(name)
+	var err error
+	if c.force {
+		err = client.ForceDeleteStoragePool(name)
+	} else {
+		err = client.DeleteStoragePool(name)
+	}
 	if err != nil {
 		return err
 	}
@@ -722,6 +769,40 @@ func (c *storageCmd) doStoragePoolShow(client lxd.ContainerServer, name string)
 	return nil
 }
 
+func (c *storageCmd) doStoragePoolInfo(client lxd.ContainerServer, name string) error {
+	if name == "" {
+		return errArgs
+	}
+
+	resources, err := client.GetStoragePoolResources(name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf(i18n.G("Space used: %s")+"\n", shared.GetByteSizeString(int64(resources.Space.Used), 2))
+	fmt.Printf(i18n.G("Total space: %s")+"\n", shared.GetByteSizeString(int64(resources.Space.Total), 2))
+
+	forecast := resources.Forecast
+	if forecast == nil {
+		fmt.Println(i18n.G("Forecast: not enough samples yet"))
+		return nil
+	}
+
+	rate := shared.GetByteSizeString(forecast.GrowthRate, 2)
+	if forecast.GrowthRate < 0 {
+		rate = "-" + shared.GetByteSizeString(-forecast.GrowthRate, 2)
+	}
+
+	fmt.Printf(i18n.G("Growth rate: %s per day")+"\n", rate)
+	if forecast.DaysUntilFull < 0 {
+		fmt.Println(i18n.G("Days until full: never at this pace"))
+	} else {
+		fmt.Printf(i18n.G("Days until full: %.1f")+"\n", forecast.DaysUntilFull)
+	}
+
+	return nil
+}
+
 func (c *storageCmd) doStoragePoolVolumesList(conf *config.Config, remote string, pool string, args []string) error {
 	client, err := conf.GetContainerServer(remote)
 	if err != nil {
@@ -784,6 +865,24 @@ func (c *storageCmd) doStoragePoolVolumeCreate(client lxd.ContainerServer, pool
 	return nil
 }
 
+func (c *storageCmd) doStoragePoolVolumeCopy(client lxd.ContainerServer, pool string, volume string, newName string) error {
+	// Create the storage volume entry
+	vol := api.StorageVolumesPost{}
+	vol.Name = newName
+	vol.Type = "custom"
+	vol.Source.Name = volume
+	vol.Source.Flatten = c.flatten
+
+	err := client.CreateStoragePoolVolume(pool, vol)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf(i18n.G("Storage volume %s copied to %s")+"\n", volume, newName)
+
+	return nil
+}
+
 func (c *storageCmd) doStoragePoolVolumeDelete(client lxd.ContainerServer, pool string, volume string) error {
 	// Parse the input
 	volName, volType := c.parseVolume(volume)
@@ -799,6 +898,21 @@ func (c *storageCmd) doStoragePoolVolumeDelete(client lxd.ContainerServer, pool
 	return nil
 }
 
+func (c *storageCmd) doStoragePoolVolumeRename(client lxd.ContainerServer, pool string, volume string, newName string) error {
+	// Parse the input
+	volName, volType := c.parseVolume(volume)
+
+	// Rename the volume
+	err := client.RenameStoragePoolVolume(pool, volType, volName, api.StorageVolumePost{Name: newName})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf(i18n.G("Storage volume %s renamed to %s")+"\n", volume, newName)
+
+	return nil
+}
+
 func (c *storageCmd) doStoragePoolVolumeGet(client lxd.ContainerServer, pool string, volume string, args []string) error {
 	if len(args) != 2 {
 		return errArgs
@@ -885,6 +999,26 @@ func (c *storageCmd) doStoragePoolVolumeShow(client lxd.ContainerServer, pool st
 	return nil
 }
 
+func (c *storageCmd) doStoragePoolVolumeInfo(client lxd.ContainerServer, pool string, volume string) error {
+	// Parse the input
+	volName, volType := c.parseVolume(volume)
+
+	state, err := client.GetStoragePoolVolumeState(pool, volType, volName)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf(i18n.G("Space used: %s")+"\n", shared.GetByteSizeString(state.Usage, 2))
+	fmt.Printf(i18n.G("Space used by snapshots: %s")+"\n", shared.GetByteSizeString(state.SnapshotsUsage, 2))
+	if state.Quota > 0 {
+		fmt.Printf(i18n.G("Quota: %s")+"\n", shared.GetByteSizeString(state.Quota, 2))
+	} else {
+		fmt.Println(i18n.G("Quota: none"))
+	}
+
+	return nil
+}
+
 func (c *storageCmd) doStoragePoolVolumeEdit(client lxd.ContainerServer, pool string, volume string) error {
 	// Parse the input
 	volName, volType := c.parseVolume(volume)
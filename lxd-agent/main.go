@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/lxc/lxd/shared/agent"
+	"github.com/lxc/lxd/shared/version"
+)
+
+func help(me string, status int) {
+	fmt.Printf("Usage: %s [socket]\n", me)
+	fmt.Printf("  Serve the requests of LXD on socket, %s by default.\n", agent.SocketPath)
+	fmt.Printf("  LXD starts it in the containers with security.agent set to true.\n")
+	os.Exit(status)
+}
+
+func main() {
+	if len(os.Args) > 1 && (os.Args[1] == "-h" || os.Args[1] == "--help" || os.Args[1] == "help") {
+		help(os.Args[0], 0)
+	}
+
+	if len(os.Args) > 2 {
+		help(os.Args[0], 1)
+	}
+
+	path := agent.SocketPath
+	if len(os.Args) == 2 {
+		path = os.Args[1]
+	}
+
+	if err := run(path); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(path string) error {
+	// A previous agent may have left its socket behind.
+	os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	// Only root, that is LXD, may talk to the agent.
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		return err
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+
+			c := agent.NewConn(conn)
+			req := agent.Request{}
+			err := c.Receive(&req)
+			if err != nil {
+				return
+			}
+
+			c.Send(handle(c, req))
+		}()
+	}
+}
+
+func handle(c *agent.Conn, req agent.Request) agent.Response {
+	var err error
+	resp := agent.Response{}
+
+	switch req.Type {
+	case agent.RequestPing:
+		resp.Version = version.Version
+	case agent.RequestExec:
+		resp.Return, err = execCommand(c, req)
+	case agent.RequestFileGet:
+		// The response goes out ahead of the content of the file.
+		return fileGet(c, req)
+	case agent.RequestFilePut:
+		err = filePut(c, req)
+	default:
+		err = fmt.Errorf("Unknown request: %s", req.Type)
+	}
+
+	if err != nil {
+		resp.Error = err.Error()
+		resp.NotFound = os.IsNotExist(err)
+	}
+
+	return resp
+}
+
+// readStream reads what LXD sends on stdin up to the end of the stream.
+func readStream(c *agent.Conn, w io.Writer) error {
+	for {
+		channel, data, err := c.ReadFrame()
+		if err != nil {
+			return err
+		}
+
+		if channel != agent.ChannelStdin {
+			return fmt.Errorf("Unexpected frame on channel %d", channel)
+		}
+
+		if len(data) == 0 {
+			return nil
+		}
+
+		_, err = w.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func execCommand(c *agent.Conn, req agent.Request) (int, error) {
+	if len(req.Command) == 0 {
+		return -1, fmt.Errorf("No command provided")
+	}
+
+	cmd := exec.Command(req.Command[0], req.Command[1:]...)
+	cmd.Dir = req.Cwd
+	cmd.Env = []string{}
+	for k, v := range req.Environment {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return -1, err
+	}
+
+	stdout := c.Writer(agent.ChannelStdout)
+	stderr := c.Writer(agent.ChannelStderr)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err = cmd.Start()
+	if err != nil {
+		return -1, err
+	}
+
+	go func() {
+		readStream(c, stdin)
+		stdin.Close()
+	}()
+
+	err = cmd.Wait()
+	stdout.Close()
+	stderr.Close()
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return -1, err
+		}
+
+		status, ok := exitErr.Sys().(syscall.WaitStatus)
+		if !ok {
+			return -1, err
+		}
+
+		if status.Signaled() {
+			// 128 + n == Fatal error signal "n"
+			return 128 + int(status.Signal()), nil
+		}
+
+		return status.ExitStatus(), nil
+	}
+
+	return 0, nil
+}
+
+func fileGet(c *agent.Conn, req agent.Request) agent.Response {
+	resp := agent.Response{}
+
+	fail := func(err error) agent.Response {
+		resp.Error = err.Error()
+		resp.NotFound = os.IsNotExist(err)
+		return resp
+	}
+
+	info, err := os.Lstat(req.Path)
+	if err != nil {
+		return fail(err)
+	}
+
+	stat := info.Sys().(*syscall.Stat_t)
+	resp.UID = int64(stat.Uid)
+	resp.GID = int64(stat.Gid)
+	resp.Mode = int(info.Mode().Perm())
+
+	var content io.Reader
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		resp.FileType = "symlink"
+		target, err := os.Readlink(req.Path)
+		if err != nil {
+			return fail(err)
+		}
+
+		content = bytes.NewBufferString(target)
+	case info.IsDir():
+		resp.FileType = "directory"
+		entries, err := ioutil.ReadDir(req.Path)
+		if err != nil {
+			return fail(err)
+		}
+
+		for _, entry := range entries {
+			resp.Entries = append(resp.Entries, entry.Name())
+		}
+
+		content = &bytes.Buffer{}
+	default:
+		resp.FileType = "file"
+		f, err := os.Open(req.Path)
+		if err != nil {
+			return fail(err)
+		}
+		defer f.Close()
+
+		content = f
+	}
+
+	err = c.Send(resp)
+	if err != nil {
+		return resp
+	}
+
+	stdout := c.Writer(agent.ChannelStdout)
+	_, err = io.Copy(stdout, content)
+	stdout.Close()
+	if err != nil {
+		return fail(err)
+	}
+
+	return agent.Response{}
+}
+
+func filePut(c *agent.Conn, req agent.Request) error {
+	_, err := os.Lstat(req.Path)
+	exists := err == nil
+
+	// Files are written as they come, symlinks only come with their target.
+	content := &bytes.Buffer{}
+	if req.FileType != "file" {
+		err := readStream(c, content)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch req.FileType {
+	case "file":
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if req.Write == "append" {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+
+		f, err := os.OpenFile(req.Path, flags, 0640)
+		if err != nil {
+			return err
+		}
+
+		err = readStream(c, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	case "directory":
+		if !exists {
+			err := os.Mkdir(req.Path, 0750)
+			if err != nil {
+				return err
+			}
+		}
+	case "symlink":
+		if exists {
+			return fmt.Errorf("%s already exists", req.Path)
+		}
+
+		err := os.Symlink(content.String(), req.Path)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Unknown file type: %s", req.FileType)
+	}
+
+	if req.Mode >= 0 && req.FileType != "symlink" {
+		err := os.Chmod(req.Path, os.FileMode(req.Mode)&os.ModePerm)
+		if err != nil {
+			return err
+		}
+	}
+
+	if req.UID >= 0 || req.GID >= 0 {
+		err := os.Lchown(req.Path, int(req.UID), int(req.GID))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -25,14 +25,24 @@ var api10 = []Command{
 	containerLogCmd,
 	containerSnapshotsCmd,
 	containerSnapshotCmd,
+	containerBackupsCmd,
+	containerBackupCmd,
+	containerBackupExportCmd,
+	containerBackupSignedURLCmd,
 	containerExecCmd,
+	containerDiffCmd,
+	containerMigrationCheckCmd,
+	containerRebuildCmd,
+	containerReplicationCmd,
 	aliasCmd,
 	aliasesCmd,
 	eventsCmd,
 	imageCmd,
 	imagesCmd,
+	imageServersCmd,
 	imagesExportCmd,
 	imagesSecretCmd,
+	imagesSignedURLCmd,
 	imagesRefreshCmd,
 	operationsCmd,
 	operationCmd,
@@ -49,7 +59,23 @@ var api10 = []Command{
 	storagePoolCmd,
 	storagePoolVolumesCmd,
 	storagePoolVolumesTypeCmd,
+	storagePoolVolumeSnapshotsCmd,
+	storagePoolVolumeSnapshotCmd,
+	storagePoolVolumeStateCmd,
 	storagePoolVolumeTypeCmd,
+	storagePoolHistoryCmd,
+	storagePoolVerifyCmd,
+	storagePoolResourcesCmd,
+	storageHistoryCmd,
+	storageAuditCmd,
+	storageSourcesCmd,
+	selfTestCmd,
+	metricsCmd,
+	containerSnapshotsBulkCmd,
+	batchCmd,
+	transfersCmd,
+	migrationCmd,
+	migrationPartialCmd,
 }
 
 func api10Get(d *Daemon, r *http.Request) Response {
@@ -112,6 +138,94 @@ func api10Get(d *Daemon, r *http.Request) Response {
 			"id_map_base",
 			"file_symlinks",
 			"container_push_target",
+			"storage_zfs_pool_guid",
+			"storage_operation_history",
+			"server_self_test",
+			"storage_zfs_dataset_cache",
+			"image_default_servers",
+			"storage_zfs_sync",
+			"container_snapshot_bulk",
+			"storage_zfs_health",
+			"storage_zfs_cache_tuning",
+			"storage_volume_snapshots",
+			"storage_zfs_images_pool",
+			"container_file_monitor",
+			"storage_volume_shared",
+			"container_privilege_requirements",
+			"container_ready_state",
+			"container_kernel_modules_allowed",
+			"storage_zfs_reservation",
+			"storage_zfs_disk_limits",
+			"operation_command_log",
+			"storage_lvm_block_migration",
+			"container_diff",
+			"storage_zfs_copy_parallelism",
+			"labels",
+			"storage_zfs_bwlimit",
+			"storage_zfs_migration_compression",
+			"metrics_network",
+			"storage_zfs_migration_checksum",
+			"container_architecture_personality",
+			"container_refresh",
+			"container_backup",
+			"storage_pool_verify",
+			"snapshot_expiry",
+			"container_copy_quiesce",
+			"storage_zfs_clone_restore",
+			"storage_sources",
+			"container_schedule",
+			"migration_conflict_policy",
+			"storage_volume_zfs_defaults",
+			"cloud_init_data",
+			"storage_zfs_image_streams",
+			"storage_zfs_images_gc",
+			"storage_pool_forecast",
+			"container_disk_io",
+			"storage_zfs_images_quota",
+			"config_batch",
+			"container_init_system",
+			"image_build",
+			"storage_dir_nfs",
+			"storage_delete_force",
+			"storage_block",
+			"signed_urls",
+			"storage_external",
+			"storage_volume_rename",
+			"container_agent",
+			"container_backup_schedule",
+			"storage_volume_state",
+			"storage_volume_copy",
+			"dry_run",
+			"container_config_events",
+			"storage_pool_auto_grow",
+			"shutdown_transfers",
+			"storage_rsync_options",
+			"image_security_policy",
+			"idmap_shift_exclude",
+			"storage_audit",
+			"storage_dry_run",
+			"image_public_server",
+			"metrics_zfs",
+			"storage_busy_retry",
+			"operation_stages",
+			"storage_error_codes",
+			"migration_pre_copy",
+			"migration_relay",
+			"migration_strategy",
+			"migration_queue",
+			"migration_check",
+			"migration_zfs_properties",
+			"migration_refresh",
+			"migration_partial",
+			"snapshot_stateful_restore",
+			"container_rebuild",
+			"container_pool_move",
+			"container_scratch_volumes",
+			"storage_hooks",
+			"container_replication",
+			"container_disk_alert",
+			"storage_pool_quota",
+			"image_security_apparmor_allowed",
 		},
 		APIStatus:  "stable",
 		APIVersion: version.APIVersion,
@@ -199,7 +313,8 @@ func api10Get(d *Daemon, r *http.Request) Response {
 		KernelVersion:          kernelVersion,
 		Server:                 "lxd",
 		ServerPid:              os.Getpid(),
-		ServerVersion:          version.Version}
+		ServerVersion:          version.Version,
+		Capabilities:           selfTestCapabilities()}
 
 	drivers := readStoragePoolDriversCache()
 	for _, driver := range drivers {
@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"gopkg.in/yaml.v2"
@@ -26,6 +28,8 @@ var apiInternal = []Command{
 	internalContainerOnStartCmd,
 	internalContainerOnStopCmd,
 	internalContainersCmd,
+	internalStoragePoolRenameCmd,
+	internalStorageFreezeCmd,
 }
 
 func internalReady(d *Daemon, r *http.Request) Response {
@@ -50,7 +54,9 @@ func internalWaitReady(d *Daemon, r *http.Request) Response {
 }
 
 func internalShutdown(d *Daemon, r *http.Request) Response {
-	d.shutdownChan <- true
+	// The value tells whether to go ahead without waiting for the
+	// transfers in flight.
+	d.shutdownChan <- shared.IsTrue(r.FormValue("force"))
 
 	return EmptySyncResponse
 }
@@ -437,6 +443,7 @@ func internalImport(d *Daemon, r *http.Request) Response {
 			Config:       snap.Config,
 			CreationDate: snap.CreationDate,
 			LastUsedDate: snap.LastUsedDate,
+			ExpiryDate:   snap.ExpiresAt,
 			Ctype:        cTypeSnapshot,
 			Devices:      snap.Devices,
 			Ephemeral:    snap.Ephemeral,
@@ -453,3 +460,175 @@ func internalImport(d *Daemon, r *http.Request) Response {
 }
 
 var internalContainersCmd = Command{name: "containers", post: internalImport}
+
+type internalStoragePoolRenamePost struct {
+	PoolName string `json:"pool_name" yaml:"pool_name"`
+	DryRun   bool   `json:"dry_run" yaml:"dry_run"`
+}
+
+// internalStoragePoolRename moves all LXD managed datasets of a ZFS storage
+// pool below a new dataset root (e.g. to introduce a project prefix) and
+// updates the "zfs.pool_name" property of the pool accordingly.
+func internalStoragePoolRename(d *Daemon, r *http.Request) Response {
+	poolName := mux.Vars(r)["name"]
+
+	req := internalStoragePoolRenamePost{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	if req.PoolName == "" {
+		return BadRequest(fmt.Errorf("No new ZFS pool name provided"))
+	}
+
+	_, pool, err := dbStoragePoolGet(d.db, poolName)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	if pool.Driver != "zfs" {
+		return BadRequest(fmt.Errorf("Renaming datasets is only supported for ZFS storage pools"))
+	}
+
+	oldRoot := pool.Config["zfs.pool_name"]
+	if oldRoot == "" {
+		oldRoot = poolName
+	}
+	newRoot := strings.Trim(req.PoolName, "/")
+
+	existing, err := zfsPoolListDatasets(strings.Split(oldRoot, "/")[0])
+	if err != nil {
+		return SmartError(err)
+	}
+
+	plan, err := zfsDatasetRenamePlan(oldRoot, newRoot, existing)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	parents := zfsDatasetRenameParents(plan, existing)
+
+	metadata := shared.Jmap{
+		"plan":     plan,
+		"rollback": zfsDatasetRenameRollback(plan),
+		"created":  parents,
+	}
+
+	if req.DryRun {
+		return SyncResponse(true, metadata)
+	}
+
+	run := func(op *operation) error {
+		// Renaming a dataset remounts it, so refuse to touch the pool
+		// while any of its volumes are in use by a running container.
+		// It's checked once the operation runs, so that containers
+		// started in the meantime aren't missed.
+		containers, err := dbContainersList(d.db, cTypeRegular)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range containers {
+			c, err := containerLoadByName(d, name)
+			if err != nil {
+				return err
+			}
+
+			if !c.IsRunning() {
+				continue
+			}
+
+			for _, dev := range c.ExpandedDevices() {
+				if dev["type"] == "disk" && dev["pool"] == poolName {
+					return fmt.Errorf("The container \"%s\" is using the storage pool and is running", name)
+				}
+			}
+		}
+
+		err = zfsDatasetRenameApply(plan, parents, func(done int, total int) {
+			metadata["progress"] = fmt.Sprintf("%d/%d", done, total)
+			op.UpdateMetadata(metadata)
+		})
+		if err != nil {
+			return err
+		}
+
+		pool.Config["zfs.pool_name"] = newRoot
+
+		// A source pointing to a dataset (rather than to a zpool, loop
+		// file or block device) needs to follow the datasets.
+		if pool.Config["source"] == oldRoot && strings.Contains(oldRoot, "/") {
+			pool.Config["source"] = newRoot
+		}
+
+		err = dbStoragePoolUpdate(d.db, poolName, pool.Description, pool.Config)
+		if err != nil {
+			zfsDatasetRenameUndo(plan, parents)
+			return err
+		}
+
+		return nil
+	}
+
+	resources := map[string][]string{}
+	resources["storage-pools"] = []string{poolName}
+
+	op, err := operationCreate(operationClassTask, resources, metadata, run, nil, nil)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return OperationResponse(op)
+}
+
+var internalStoragePoolRenameCmd = Command{name: "storage-pools/{name}/rename", post: internalStoragePoolRename}
+
+type internalStorageFreezePut struct {
+	Timeout int `json:"timeout" yaml:"timeout"`
+}
+
+type internalStorageFreezeState struct {
+	Frozen bool      `json:"frozen" yaml:"frozen"`
+	Until  time.Time `json:"until" yaml:"until"`
+}
+
+func internalStorageFreezeGet(d *Daemon, r *http.Request) Response {
+	until := storageFrozenUntil()
+
+	return SyncResponse(true, internalStorageFreezeState{Frozen: !until.IsZero(), Until: until})
+}
+
+// internalStorageFreeze freezes the storage for a host backup, or extends the
+// current freeze. It returns once the storage pools are synced.
+func internalStorageFreeze(d *Daemon, r *http.Request) Response {
+	req := internalStorageFreezePut{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	if req.Timeout < 0 {
+		return BadRequest(fmt.Errorf("Invalid timeout: %d", req.Timeout))
+	}
+
+	timeout := storageFreezeDefaultTimeout
+	if req.Timeout > 0 {
+		timeout = time.Duration(req.Timeout) * time.Second
+	}
+
+	until, err := storageFreeze(d, timeout)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return SyncResponse(true, internalStorageFreezeState{Frozen: true, Until: until})
+}
+
+func internalStorageThaw(d *Daemon, r *http.Request) Response {
+	storageThaw()
+
+	return EmptySyncResponse
+}
+
+var internalStorageFreezeCmd = Command{name: "storage/freeze", get: internalStorageFreezeGet, put: internalStorageFreeze, delete: internalStorageThaw}
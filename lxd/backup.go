@@ -0,0 +1,505 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/lxc/lxd/lxd/types"
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/osarch"
+)
+
+/* A backup is a gzip compressed tarball kept in ${LXD_DIR}/backups/<container>
+ * with the following layout:
+ *
+ * backup/index.yaml            the backupInfo
+ * backup/container/            the container's directory
+ * backup/snapshots/<name>/     the snapshots' directories, oldest first
+ *
+ * Optimized backups replace the directories with the native streams of the
+ * storage driver, backup/container.bin and backup/snapshots/<name>.bin.
+ */
+
+// backupArgs are the properties of a container backup.
+type backupArgs struct {
+	ContainerName    string
+	Name             string
+	CreationDate     time.Time
+	ContainerOnly    bool
+	OptimizedStorage bool
+}
+
+// backupInfo is the index of a backup tarball.
+type backupInfo struct {
+	Name       string   `yaml:"name"`
+	Backend    string   `yaml:"backend"`
+	Pool       string   `yaml:"pool"`
+	Privileged bool     `yaml:"privileged"`
+	Optimized  bool     `yaml:"optimized"`
+	Snapshots  []string `yaml:"snapshots,omitempty"`
+
+	// Checksums indexes the files of the backup other than index.yaml.
+	Checksums map[string]streamIndexEntry `yaml:"checksums,omitempty"`
+}
+
+func backupPath(containerName string, name string) string {
+	return shared.VarPath("backups", containerName, name)
+}
+
+func backupValidName(name string) error {
+	if name == "" {
+		return fmt.Errorf("The backup name can't be empty")
+	}
+
+	if strings.Contains(name, "/") {
+		return fmt.Errorf("The backup name can't contain a \"/\"")
+	}
+
+	return nil
+}
+
+func backupRender(args backupArgs) *api.ContainerBackup {
+	return &api.ContainerBackup{
+		Name:             args.Name,
+		CreationDate:     args.CreationDate,
+		ContainerOnly:    args.ContainerOnly,
+		OptimizedStorage: args.OptimizedStorage,
+	}
+}
+
+// backupInfoParse parses and checks the index of a backup tarball.
+func backupInfoParse(data []byte) (*backupInfo, error) {
+	info := backupInfo{}
+	err := yaml.Unmarshal(data, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Name == "" || !shared.ValidHostname(info.Name) {
+		return nil, fmt.Errorf("Invalid container name in the backup: \"%s\"", info.Name)
+	}
+
+	if info.Pool == "" {
+		return nil, fmt.Errorf("The backup doesn't specify a storage pool")
+	}
+
+	for _, snapName := range info.Snapshots {
+		if snapName == "" || strings.Contains(snapName, "/") || strings.HasPrefix(snapName, ".") {
+			return nil, fmt.Errorf("Invalid snapshot name in the backup: \"%s\"", snapName)
+		}
+	}
+
+	return &info, nil
+}
+
+// backupDump copies the container and its snapshots to the unoptimized
+// layout of a backup.
+func backupDump(source container, snapshots []container, path string) error {
+	for _, snap := range snapshots {
+		_, snapOnlyName, _ := containerGetParentAndSnapshotName(snap.Name())
+
+		ourStart, err := snap.StorageStart()
+		if err != nil {
+			return err
+		}
+
+		output, err := rsyncLocalCopy(snap.Path(), filepath.Join(path, "snapshots", snapOnlyName), "")
+		if ourStart {
+			snap.StorageStop()
+		}
+
+		if err != nil {
+			return fmt.Errorf("Failed to copy snapshot \"%s\": %s", snapOnlyName, output)
+		}
+	}
+
+	output, err := rsyncLocalCopy(source.Path(), filepath.Join(path, "container"), "")
+	if err != nil {
+		return fmt.Errorf("Failed to copy the container: %s", output)
+	}
+
+	return nil
+}
+
+// backupCreate creates a backup of the source container, reporting the
+// progress of its stages to op if set.
+func backupCreate(d *Daemon, args backupArgs, source container, op *operation) error {
+	args.ContainerName = source.Name()
+	args.CreationDate = time.Now().UTC()
+
+	err := dbContainerBackupCreate(d.db, args)
+	if err != nil {
+		return err
+	}
+
+	stages := operationStagesSet(op, "dump", "compress")
+	defer stages.fail()
+
+	revert := true
+	defer func() {
+		if !revert {
+			return
+		}
+
+		dbContainerBackupRemove(d.db, args.ContainerName, args.Name)
+	}()
+
+	ourStart, err := source.StorageStart()
+	if err != nil {
+		return err
+	}
+	if ourStart {
+		defer source.StorageStop()
+	}
+
+	// Make sure the backup file describes the current configuration, it's
+	// what the container is restored from.
+	err = writeBackupFile(source)
+	if err != nil {
+		return err
+	}
+
+	snapshots := []container{}
+	if !args.ContainerOnly {
+		snapshots, err = source.Snapshots()
+		if err != nil {
+			return err
+		}
+	}
+
+	poolName, err := source.StoragePool()
+	if err != nil {
+		return err
+	}
+
+	info := backupInfo{
+		Name:       source.Name(),
+		Backend:    source.Storage().GetStorageTypeName(),
+		Pool:       poolName,
+		Privileged: source.IsPrivileged(),
+		Optimized:  args.OptimizedStorage,
+	}
+
+	for _, snap := range snapshots {
+		_, snapOnlyName, _ := containerGetParentAndSnapshotName(snap.Name())
+		info.Snapshots = append(info.Snapshots, snapOnlyName)
+	}
+
+	tmpPath, err := ioutil.TempDir(shared.VarPath("backups"), "backup_")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpPath)
+
+	path := filepath.Join(tmpPath, "backup")
+	err = os.MkdirAll(path, 0711)
+	if err != nil {
+		return err
+	}
+
+	stages.start("dump", 0)
+	if args.OptimizedStorage {
+		err = source.Storage().ContainerBackupCreate(args, source, path)
+	} else {
+		err = backupDump(source, snapshots, path)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The index is written last, to record the size and checksum of
+	// everything else.
+	info.Checksums, err = streamIndexBuild(path, "index.yaml")
+	if err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(&info)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(path, "index.yaml"), data, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(shared.VarPath("backups", source.Name()), 0700)
+	if err != nil {
+		return err
+	}
+
+	stages.start("compress", 0)
+	target := backupPath(source.Name(), args.Name)
+	output, err := shared.RunCommand("tar", "-czpf", target, "--numeric-owner", "--xattrs", "-C", tmpPath, "backup")
+	if err != nil {
+		os.Remove(target)
+		return fmt.Errorf("Failed to create the backup tarball: %s", output)
+	}
+
+	stages.finish(nil)
+	revert = false
+	return nil
+}
+
+// backupDelete removes a backup and its database record.
+func backupDelete(d *Daemon, containerName string, name string) error {
+	err := os.Remove(backupPath(containerName, name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return dbContainerBackupRemove(d.db, containerName, name)
+}
+
+// backupInfoRead reads the index of a backup tarball without unpacking it.
+func backupInfoRead(tarball string) (*backupInfo, error) {
+	data, err := exec.Command("tar", "-xzf", tarball, "-O", "backup/index.yaml").Output()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the index of the backup: %v", err)
+	}
+
+	return backupInfoParse(data)
+}
+
+// backupUnpack extracts a backup tarball to a temporary directory.
+func backupUnpack(tarball string) (string, error) {
+	tmpPath, err := ioutil.TempDir(shared.VarPath("backups"), "backup_")
+	if err != nil {
+		return "", err
+	}
+
+	output, err := shared.RunCommand("tar", "-xzpf", tarball, "--numeric-owner", "--xattrs", "-C", tmpPath)
+	if err != nil {
+		os.RemoveAll(tmpPath)
+		return "", fmt.Errorf("Failed to unpack the backup: %s", output)
+	}
+
+	return tmpPath, nil
+}
+
+// backupVerify checks the files of an unpacked backup against the sizes and
+// checksums of its index. Backups made before those were recorded aren't
+// checked.
+func backupVerify(info backupInfo, path string) error {
+	if info.Checksums == nil {
+		return nil
+	}
+
+	err := streamIndexVerify(path, info.Checksums, "index.yaml")
+	if err != nil {
+		return fmt.Errorf("The backup is corrupted: %v", err)
+	}
+
+	return nil
+}
+
+// backupPool returns the storage pool to load a backup into: the one it was
+// backed up from if it exists, the requested one otherwise, falling back to
+// the pool of the root disk device of the default profile.
+func backupPool(d *Daemon, info backupInfo, requested string) (string, error) {
+	_, err := dbStoragePoolGetID(d.db, info.Pool)
+	if err == nil {
+		return info.Pool, nil
+	}
+
+	if err != NoSuchObjectError {
+		return "", err
+	}
+
+	if requested != "" {
+		_, err := dbStoragePoolGetID(d.db, requested)
+		if err != nil {
+			if err == NoSuchObjectError {
+				return "", fmt.Errorf("The \"%s\" storage pool doesn't exist", requested)
+			}
+
+			return "", err
+		}
+
+		return requested, nil
+	}
+
+	pool, err := profilesRootDiskPool(d, []string{"default"})
+	if err != nil {
+		return "", err
+	}
+
+	if pool == "" {
+		return "", fmt.Errorf("The \"%s\" storage pool of the backup doesn't exist and no other one was requested", info.Pool)
+	}
+
+	return pool, nil
+}
+
+// profilesRootDiskPool returns the storage pool of the root disk device the
+// profiles give a container, if any.
+func profilesRootDiskPool(d *Daemon, profiles []string) (string, error) {
+	pool := ""
+	for _, name := range profiles {
+		_, profile, err := dbProfileGet(d.db, name)
+		if err != nil {
+			return "", err
+		}
+
+		// The last profile in the chain wins
+		k, v, _ := containerGetRootDiskDevice(profile.Devices)
+		if k != "" && v["pool"] != "" {
+			pool = v["pool"]
+		}
+	}
+
+	return pool, nil
+}
+
+// backupRootDiskPoolSet makes sure that a container or snapshot loaded from a
+// backup ends up on the storage pool, giving it a local root disk device if
+// its profiles would put it on another one.
+func backupRootDiskPoolSet(d *Daemon, args *containerArgs, pool string) error {
+	k, _, _ := containerGetRootDiskDevice(args.Devices)
+	if k != "" {
+		args.Devices[k]["pool"] = pool
+		return nil
+	}
+
+	profilesPool, err := profilesRootDiskPool(d, args.Profiles)
+	if err != nil {
+		return err
+	}
+
+	if profilesPool == pool {
+		return nil
+	}
+
+	if args.Devices == nil {
+		args.Devices = types.Devices{}
+	}
+
+	rootDevName := "root"
+	for i := 0; args.Devices[rootDevName] != nil; i++ {
+		rootDevName = fmt.Sprintf("root%d", i)
+	}
+
+	args.Devices[rootDevName] = types.Device{"type": "disk", "path": "/", "pool": pool}
+	return nil
+}
+
+// backupContainerArgs returns the arguments to recreate the container
+// described by the backup.yaml of a backup on the storage pool.
+func backupContainerArgs(d *Daemon, ct *api.Container, pool string) (containerArgs, error) {
+	arch, err := osarch.ArchitectureId(ct.Architecture)
+	if err != nil {
+		return containerArgs{}, err
+	}
+
+	args := containerArgs{
+		Architecture: arch,
+		BaseImage:    ct.Config["volatile.base_image"],
+		Config:       ct.Config,
+		CreationDate: ct.CreatedAt,
+		LastUsedDate: ct.LastUsedAt,
+		Ctype:        cTypeRegular,
+		Description:  ct.Description,
+		Devices:      ct.Devices,
+		Ephemeral:    ct.Ephemeral,
+		Labels:       ct.Labels,
+		Name:         ct.Name,
+		Profiles:     ct.Profiles,
+		Stateful:     ct.Stateful,
+	}
+
+	err = backupRootDiskPoolSet(d, &args, pool)
+	if err != nil {
+		return containerArgs{}, err
+	}
+
+	return args, nil
+}
+
+// backupSnapshotArgs returns the arguments to recreate a snapshot described
+// by the backup.yaml of a backup on the storage pool.
+func backupSnapshotArgs(d *Daemon, containerName string, snap *api.ContainerSnapshot, pool string) (containerArgs, error) {
+	arch, err := osarch.ArchitectureId(snap.Architecture)
+	if err != nil {
+		return containerArgs{}, err
+	}
+
+	_, snapName, _ := containerGetParentAndSnapshotName(snap.Name)
+	args := containerArgs{
+		Architecture: arch,
+		BaseImage:    snap.Config["volatile.base_image"],
+		Config:       snap.Config,
+		CreationDate: snap.CreationDate,
+		LastUsedDate: snap.LastUsedDate,
+		ExpiryDate:   snap.ExpiresAt,
+		Ctype:        cTypeSnapshot,
+		Devices:      snap.Devices,
+		Ephemeral:    snap.Ephemeral,
+		Name:         fmt.Sprintf("%s%s%s", containerName, shared.SnapshotDelimiter, snapName),
+		Profiles:     snap.Profiles,
+		Stateful:     snap.Stateful,
+	}
+
+	err = backupRootDiskPoolSet(d, &args, pool)
+	if err != nil {
+		return containerArgs{}, err
+	}
+
+	return args, nil
+}
+
+// backupFileRepool points the backup.yaml of a container, which still
+// describes the storage pool it was backed up from, to the one it was loaded
+// into so that it can be imported there.
+func backupFileRepool(d *Daemon, containerName string, poolName string) error {
+	_, pool, err := dbStoragePoolGet(d.db, poolName)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(getContainerMountPoint(poolName, containerName), "backup.yaml")
+	backup, err := slurpBackupFile(path)
+	if err != nil {
+		return err
+	}
+
+	if backup.Pool != nil && backup.Pool.Name == poolName {
+		return nil
+	}
+
+	backup.Pool = pool
+	if backup.Container != nil {
+		k, _, _ := containerGetRootDiskDevice(backup.Container.Devices)
+		if k != "" {
+			backup.Container.Devices[k]["pool"] = poolName
+		}
+	}
+
+	for _, snap := range backup.Snapshots {
+		k, _, _ := containerGetRootDiskDevice(snap.Devices)
+		if k != "" {
+			snap.Devices[k]["pool"] = poolName
+		}
+	}
+
+	data, err := yaml.Marshal(backup)
+	if err != nil {
+		return err
+	}
+
+	// backup.yaml is read-only
+	err = os.Remove(path)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0400)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// backupsScheduleInterval is how often the "backups.schedule" key of the
+// containers is checked.
+const backupsScheduleInterval = time.Minute
+
+// backupScheduledPrefix starts the names of the scheduled backups, which are
+// the only ones subject to "backups.retention".
+const backupScheduledPrefix = "scheduled-"
+
+// backupsScheduler backs the containers up on their schedule.
+var backupsScheduler = newScheduler("backup", "backups.schedule.timezone", backupScheduleRun, "backups.schedule")
+
+// backupsExpired returns the backups to remove so that only the keep most
+// recent ones remain. The names of the scheduled backups sort by date.
+func backupsExpired(names []string, keep int) []string {
+	scheduled := []string{}
+	for _, name := range names {
+		if strings.HasPrefix(name, backupScheduledPrefix) {
+			scheduled = append(scheduled, name)
+		}
+	}
+
+	if keep <= 0 || len(scheduled) <= keep {
+		return []string{}
+	}
+
+	sort.Strings(scheduled)
+	return scheduled[:len(scheduled)-keep]
+}
+
+// backupScheduleShip uploads a backup to the configured target, if any.
+func backupScheduleShip(target backupTarget, containerName string, name string) error {
+	if target == nil {
+		return nil
+	}
+
+	return target.Upload(fmt.Sprintf("%s/%s.tar.gz", containerName, name), backupPath(containerName, name))
+}
+
+// backupSchedulePrune applies the retention policy of a container to its
+// scheduled backups, both on the host and on the target.
+func backupSchedulePrune(d *Daemon, c container, target backupTarget) error {
+	keep, err := strconv.Atoi(c.ExpandedConfig()["backups.retention"])
+	if err != nil || keep <= 0 {
+		return nil
+	}
+
+	names, err := dbContainerGetBackups(d.db, c.Name())
+	if err != nil {
+		return err
+	}
+
+	for _, name := range backupsExpired(names, keep) {
+		err := backupDelete(d, c.Name(), name)
+		if err != nil {
+			return err
+		}
+	}
+
+	if target == nil {
+		return nil
+	}
+
+	objects, err := target.List(c.Name())
+	if err != nil {
+		return err
+	}
+
+	names = []string{}
+	for _, object := range objects {
+		if strings.HasSuffix(object, ".tar.gz") {
+			names = append(names, object)
+		}
+	}
+
+	for _, name := range backupsExpired(names, keep) {
+		err := target.Delete(fmt.Sprintf("%s/%s", c.Name(), name))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// backupScheduleRun backs a container up, ships the backup off the host and
+// prunes the old ones, reporting the outcome as a "container" event.
+func backupScheduleRun(d *Daemon, c container, key string) {
+	name := backupScheduledPrefix + time.Now().UTC().Format("20060102-150405")
+
+	var target backupTarget
+	var err error
+
+	// The storage of a container whose pool went away can't be backed up.
+	st := c.Storage()
+	if st == nil {
+		err = fmt.Errorf("The storage of the container isn't available")
+	}
+
+	if err == nil {
+		target, err = backupTargetLoad(d)
+	}
+
+	if err == nil {
+		args := backupArgs{
+			Name:             name,
+			OptimizedStorage: st.GetStorageType() == storageTypeZfs,
+		}
+
+		storageFreezeEnter()
+		err = backupCreate(d, args, c, nil)
+		storageFreezeLeave()
+	}
+
+	if err == nil {
+		err = backupScheduleShip(target, c.Name(), name)
+	}
+
+	if err == nil {
+		storageFreezeEnter()
+		err = backupSchedulePrune(d, c, target)
+		storageFreezeLeave()
+	}
+
+	ctx := log.Ctx{"container": c.Name(), "backup": name}
+	event := shared.Jmap{
+		"action":    "scheduled-backup",
+		"container": c.Name(),
+		"backup":    name,
+	}
+
+	if err != nil {
+		ctx["err"] = err
+		event["error"] = err.Error()
+		logger.Error("Failed to run a scheduled backup", ctx)
+	} else {
+		logger.Info("Ran a scheduled backup", ctx)
+	}
+
+	eventSend("container", event)
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+)
+
+// backupTarget is where the scheduled backups are shipped off the host. The
+// objects are named "<container>/<backup>.tar.gz".
+type backupTarget interface {
+	// Upload stores the file at path as the object name.
+	Upload(name string, path string) error
+
+	// List returns the names of the objects in dir, without the dir.
+	List(dir string) ([]string, error)
+
+	// Delete removes the object name.
+	Delete(name string) error
+}
+
+// daemonConfigValidateBackupsTarget checks "backups.target", either an
+// absolute path or an s3://<bucket>[/<prefix>] URL.
+func daemonConfigValidateBackupsTarget(d *Daemon, key string, value string) error {
+	if value == "" {
+		return nil
+	}
+
+	if strings.HasPrefix(value, "s3://") {
+		bucket, _ := backupTargetS3Parse(value)
+		if bucket == "" {
+			return fmt.Errorf("No bucket specified in \"%s\"", value)
+		}
+
+		return nil
+	}
+
+	if !filepath.IsAbs(value) {
+		return fmt.Errorf("The backup target must be an absolute path or an s3:// URL")
+	}
+
+	return nil
+}
+
+// backupTargetLoad returns the target configured in "backups.target", nil if
+// the backups are kept on the host only.
+func backupTargetLoad(d *Daemon) (backupTarget, error) {
+	value := daemonConfig["backups.target"].Get()
+	if value == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(value, "s3://") {
+		return &backupTargetDir{path: value}, nil
+	}
+
+	client, err := d.httpClient("")
+	if err != nil {
+		return nil, err
+	}
+
+	bucket, prefix := backupTargetS3Parse(value)
+	return &backupTargetS3{
+		client:    client,
+		endpoint:  strings.TrimSuffix(daemonConfig["backups.s3.endpoint"].Get(), "/"),
+		region:    daemonConfig["backups.s3.region"].Get(),
+		bucket:    bucket,
+		prefix:    prefix,
+		accessKey: daemonConfig["backups.s3.access_key"].Get(),
+		secretKey: daemonConfig["backups.s3.secret_key"].Get(),
+	}, nil
+}
+
+// backupTargetDir keeps the backups in a directory, typically a network
+// filesystem mounted on the host.
+type backupTargetDir struct {
+	path string
+}
+
+func (t *backupTargetDir) Upload(name string, path string) error {
+	target := filepath.Join(t.path, name)
+	err := os.MkdirAll(filepath.Dir(target), 0700)
+	if err != nil {
+		return err
+	}
+
+	// Don't leave a partial backup behind if the copy fails.
+	err = shared.FileCopy(path, target+".part")
+	if err != nil {
+		os.Remove(target + ".part")
+		return err
+	}
+
+	return os.Rename(target+".part", target)
+}
+
+func (t *backupTargetDir) List(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(t.path, dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".part") {
+			continue
+		}
+
+		names = append(names, entry.Name())
+	}
+
+	return names, nil
+}
+
+func (t *backupTargetDir) Delete(name string) error {
+	err := os.Remove(filepath.Join(t.path, name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// backupTargetS3Parse splits an s3://<bucket>[/<prefix>] URL.
+func backupTargetS3Parse(value string) (string, string) {
+	fields := strings.SplitN(strings.TrimPrefix(value, "s3://"), "/", 2)
+	if len(fields) == 1 {
+		return fields[0], ""
+	}
+
+	prefix := strings.Trim(fields[1], "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return fields[0], prefix
+}
+
+// backupTargetS3 keeps the backups in a bucket of an S3-compatible object
+// storage, addressed with path-style URLs so it works with other
+// implementations than Amazon's.
+type backupTargetS3 struct {
+	client    *http.Client
+	endpoint  string
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+}
+
+// s3Escape escapes a string as required by the signature of the requests,
+// everything but the unreserved characters being encoded.
+func s3Escape(s string, keepSlash bool) string {
+	result := ""
+	for _, b := range []byte(s) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') ||
+			b == '-' || b == '_' || b == '.' || b == '~' || (b == '/' && keepSlash) {
+			result += string(b)
+			continue
+		}
+
+		result += fmt.Sprintf("%%%02X", b)
+	}
+
+	return result
+}
+
+// s3Sign signs a request with the version 4 of the AWS signature, covering
+// its host and all the headers already set.
+func s3Sign(req *http.Request, region string, accessKey string, secretKey string, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", amzDate[:8], region)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		trimmed := []string{}
+		for _, value := range values {
+			trimmed = append(trimmed, strings.TrimSpace(value))
+		}
+
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += fmt.Sprintf("%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	keys := []string{}
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	params := []string{}
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			params = append(params, s3Escape(key, false)+"="+s3Escape(value, false))
+		}
+	}
+
+	uri := req.URL.EscapedPath()
+	if uri == "" {
+		uri = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uri,
+		strings.Join(params, "&"),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+
+	key := mac([]byte("AWS4"+secretKey), amzDate[:8])
+	key = mac(key, region)
+	key = mac(key, "s3")
+	key = mac(key, "aws4_request")
+	signature := hex.EncodeToString(mac(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+// do sends a signed request for an object, or for the bucket if key is
+// empty, and checks that it succeeded.
+func (t *backupTargetS3) do(method string, key string, query string, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	u, err := url.Parse(t.endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Path = "/" + t.bucket
+	u.RawPath = "/" + s3Escape(t.bucket, false)
+	if key != "" {
+		u.Path += "/" + key
+		u.RawPath += "/" + s3Escape(key, true)
+	}
+	u.RawQuery = query
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+
+	s3Sign(req, t.region, t.accessKey, t.secretKey, payloadHash, time.Now())
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+
+		s3Err := struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}{}
+
+		data, _ := ioutil.ReadAll(resp.Body)
+		if xml.Unmarshal(data, &s3Err) == nil && s3Err.Code != "" {
+			return nil, fmt.Errorf("%s: %s", s3Err.Code, s3Err.Message)
+		}
+
+		return nil, fmt.Errorf("Unexpected response from the object storage: %s", resp.Status)
+	}
+
+	return resp, nil
+}
+
+// s3PartSize is the size of the parts of the backups uploaded in several
+// requests, which a single request can't store past 5GB. A variable so that
+// the tests don't need such files.
+var s3PartSize int64 = 512 * 1024 * 1024
+
+// s3PartsMax is the number of parts an upload can't go past.
+const s3PartsMax = 10000
+
+// s3PayloadHash returns the checksum of the n bytes of f at offset, as the
+// payload is signed too.
+func s3PayloadHash(f *os.File, offset int64, n int64) (string, error) {
+	h := sha256.New()
+	_, err := io.Copy(h, io.NewSectionReader(f, offset, n))
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (t *backupTargetS3) Upload(name string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	if size > s3PartSize {
+		return t.uploadParts(t.prefix+name, f, size)
+	}
+
+	payloadHash, err := s3PayloadHash(f, 0, size)
+	if err != nil {
+		return err
+	}
+
+	resp, err := t.do("PUT", t.prefix+name, "", io.NewSectionReader(f, 0, size), size, payloadHash)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// uploadParts stores f as the object key with a multipart upload, aborted if
+// any of the parts fails so that the storage doesn't keep them.
+func (t *backupTargetS3) uploadParts(key string, f *os.File, size int64) error {
+	emptyHash := sha256.Sum256(nil)
+
+	resp, err := t.do("POST", key, "uploads=", nil, 0, hex.EncodeToString(emptyHash[:]))
+	if err != nil {
+		return err
+	}
+
+	initiated := struct {
+		UploadID string `xml:"UploadId"`
+	}{}
+
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	if initiated.UploadID == "" {
+		return fmt.Errorf("No upload ID returned by the object storage")
+	}
+	uploadQuery := "uploadId=" + s3Escape(initiated.UploadID, false)
+
+	err = t.uploadPartsComplete(key, uploadQuery, f, size)
+	if err != nil {
+		resp, abortErr := t.do("DELETE", key, uploadQuery, nil, 0, hex.EncodeToString(emptyHash[:]))
+		if abortErr == nil {
+			resp.Body.Close()
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// uploadPartsComplete uploads the parts of f for the multipart upload
+// identified by uploadQuery, then assembles them.
+func (t *backupTargetS3) uploadPartsComplete(key string, uploadQuery string, f *os.File, size int64) error {
+	partSize := s3PartSize
+	if (size+partSize-1)/partSize > s3PartsMax {
+		partSize = (size + s3PartsMax - 1) / s3PartsMax
+	}
+
+	type part struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+
+	complete := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+
+	for offset := int64(0); offset < size; offset += partSize {
+		n := partSize
+		if offset+n > size {
+			n = size - offset
+		}
+
+		payloadHash, err := s3PayloadHash(f, offset, n)
+		if err != nil {
+			return err
+		}
+
+		number := len(complete.Parts) + 1
+		query := fmt.Sprintf("partNumber=%d&%s", number, uploadQuery)
+		resp, err := t.do("PUT", key, query, io.NewSectionReader(f, offset, n), n, payloadHash)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		complete.Parts = append(complete.Parts, part{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	bodyHash := sha256.Sum256(body)
+
+	resp, err := t.do("POST", key, uploadQuery, strings.NewReader(string(body)), int64(len(body)), hex.EncodeToString(bodyHash[:]))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The assembly can fail after the response started, in which case the
+	// error comes with a successful status.
+	result := struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}{}
+
+	err = xml.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return err
+	}
+
+	if result.XMLName.Local == "Error" {
+		return fmt.Errorf("%s: %s", result.Code, result.Message)
+	}
+
+	return nil
+}
+
+func (t *backupTargetS3) List(dir string) ([]string, error) {
+	prefix := t.prefix + strings.Trim(dir, "/") + "/"
+	emptyHash := sha256.Sum256(nil)
+
+	names := []string{}
+	token := ""
+	for {
+		query := "list-type=2&prefix=" + s3Escape(prefix, false)
+		if token != "" {
+			query += "&continuation-token=" + s3Escape(token, false)
+		}
+
+		resp, err := t.do("GET", "", query, nil, 0, hex.EncodeToString(emptyHash[:]))
+		if err != nil {
+			return nil, err
+		}
+
+		result := struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}{}
+
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range result.Contents {
+			name := strings.TrimPrefix(entry.Key, prefix)
+			if name == "" || strings.Contains(name, "/") {
+				continue
+			}
+
+			names = append(names, name)
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return names, nil
+		}
+
+		token = result.NextContinuationToken
+	}
+}
+
+func (t *backupTargetS3) Delete(name string) error {
+	emptyHash := sha256.Sum256(nil)
+
+	resp, err := t.do("DELETE", t.prefix+name, "", nil, 0, hex.EncodeToString(emptyHash[:]))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
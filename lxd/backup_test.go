@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBackupInfoParse(t *testing.T) {
+	info, err := backupInfoParse([]byte(`name: c1
+backend: zfs
+pool: default
+optimized: true
+snapshots:
+- snap0
+- snap1
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse a valid index: %v", err)
+	}
+
+	if info.Name != "c1" || info.Backend != "zfs" || info.Pool != "default" || !info.Optimized {
+		t.Errorf("Unexpected index: %+v", info)
+	}
+
+	if len(info.Snapshots) != 2 || info.Snapshots[0] != "snap0" || info.Snapshots[1] != "snap1" {
+		t.Errorf("Unexpected snapshots: %v", info.Snapshots)
+	}
+
+	invalid := []string{
+		"pool: default\n",
+		"name: ../c1\npool: default\n",
+		"name: c1\n",
+		"name: c1\npool: default\nsnapshots:\n- ../snap0\n",
+		"name: c1\npool: default\nsnapshots:\n- \"\"\n",
+	}
+
+	for _, data := range invalid {
+		_, err := backupInfoParse([]byte(data))
+		if err == nil {
+			t.Errorf("Expected the index to be rejected: %q", data)
+		}
+	}
+}
+
+func TestBackupsExpired(t *testing.T) {
+	names := []string{
+		"scheduled-20171017-030000",
+		"manual",
+		"scheduled-20171015-030000",
+		"scheduled-20171016-030000",
+	}
+
+	expired := backupsExpired(names, 2)
+	if len(expired) != 1 || expired[0] != "scheduled-20171015-030000" {
+		t.Errorf("Unexpected expired backups: %v", expired)
+	}
+
+	if len(backupsExpired(names, 3)) != 0 {
+		t.Errorf("Backups expired while within the retention")
+	}
+
+	if len(backupsExpired(names, 0)) != 0 {
+		t.Errorf("Backups expired without a retention")
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/lxc/lxd/lxd/types"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+var batchCmd = Command{name: "batch", post: batchPost}
+
+// batch holds what a batch changes, loaded and validated before anything is
+// applied, along with what's needed to revert it.
+type batch struct {
+	profiles    []api.BatchProfile
+	profileIDs  map[string]int64
+	oldProfiles map[string]*api.Profile
+
+	// All the containers the batch changes, directly or through their
+	// profiles, sorted by name.
+	names      []string
+	containers map[string]container
+	oldArgs    map[string]containerArgs
+	changes    map[string]api.BatchContainer
+}
+
+// batchContainerArgs returns the current arguments of a container, as given
+// to containerLXC.Update.
+func batchContainerArgs(c container) containerArgs {
+	return containerArgs{
+		Architecture: c.Architecture(),
+		Description:  c.Description(),
+		Labels:       c.Labels(),
+		Config:       c.LocalConfig(),
+		Devices:      c.LocalDevices(),
+		Ephemeral:    c.IsEphemeral(),
+		Profiles:     c.Profiles(),
+	}
+}
+
+// batchContainerMerge sets the config keys and devices of a batch entry on
+// top of the arguments of a container. Empty values remove the key or the
+// device.
+func batchContainerMerge(args containerArgs, change api.BatchContainer) containerArgs {
+	config := map[string]string{}
+	for key, value := range args.Config {
+		config[key] = value
+	}
+
+	for key, value := range change.Config {
+		if value == "" {
+			delete(config, key)
+			continue
+		}
+
+		config[key] = value
+	}
+
+	devices := types.Devices{}
+	for name, device := range args.Devices {
+		devices[name] = device
+	}
+
+	for name, device := range change.Devices {
+		if len(device) == 0 {
+			delete(devices, name)
+			continue
+		}
+
+		devices[name] = device
+	}
+
+	args.Config = config
+	args.Devices = devices
+
+	return args
+}
+
+// batchLoad checks the profiles and containers of a batch exist and that
+// the new profiles are valid, and loads all the containers it affects.
+func batchLoad(d *Daemon, req api.BatchPost) (*batch, error) {
+	b := batch{
+		profiles:    req.Profiles,
+		profileIDs:  map[string]int64{},
+		oldProfiles: map[string]*api.Profile{},
+		names:       []string{},
+		containers:  map[string]container{},
+		oldArgs:     map[string]containerArgs{},
+		changes:     map[string]api.BatchContainer{},
+	}
+
+	add := func(c container) {
+		_, ok := b.containers[c.Name()]
+		if ok {
+			return
+		}
+
+		b.names = append(b.names, c.Name())
+		b.containers[c.Name()] = c
+		b.oldArgs[c.Name()] = batchContainerArgs(c)
+	}
+
+	for _, profile := range req.Profiles {
+		_, ok := b.profileIDs[profile.Name]
+		if ok {
+			return nil, fmt.Errorf("Profile '%s' is listed more than once", profile.Name)
+		}
+
+		id, old, err := dbProfileGet(d.db, profile.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		err = containerValidConfig(d, profile.Config, true, false)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid config for profile '%s': %s", profile.Name, err)
+		}
+
+		err = containerValidDevices(d, profile.Devices, true, false)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid devices for profile '%s': %s", profile.Name, err)
+		}
+
+		b.profileIDs[profile.Name] = id
+		b.oldProfiles[profile.Name] = old
+
+		for _, c := range getContainersWithProfile(d, profile.Name) {
+			add(c)
+		}
+	}
+
+	for _, change := range req.Containers {
+		_, ok := b.changes[change.Name]
+		if ok {
+			return nil, fmt.Errorf("Container '%s' is listed more than once", change.Name)
+		}
+
+		c, err := containerLoadByName(d, change.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.IsSnapshot() {
+			return nil, fmt.Errorf("Snapshots can't be changed by a batch")
+		}
+
+		add(c)
+		b.changes[change.Name] = change
+	}
+
+	sort.Strings(b.names)
+
+	return &b, nil
+}
+
+// revert puts the profiles back in the database, then updates the given
+// containers back to their previous arguments, which also re-applies the
+// previous profiles.
+func (b *batch) revert(d *Daemon, profiles []api.BatchProfile, names []string) error {
+	var failure error
+
+	for i := len(profiles) - 1; i >= 0; i-- {
+		profile := profiles[i]
+		current := &api.Profile{ProfilePut: profile.ProfilePut, Name: profile.Name}
+
+		err := doProfileUpdateDb(d, b.profileIDs[profile.Name], current, b.oldProfiles[profile.Name].ProfilePut)
+		if err != nil {
+			logger.Error("Failed to revert profile", log.Ctx{"profile": profile.Name, "err": err})
+			failure = err
+		}
+	}
+
+	for i := len(names) - 1; i >= 0; i-- {
+		args := b.oldArgs[names[i]]
+		args.UpdateSource = containerUpdateSourceBatch
+
+		err := b.containers[names[i]].Update(args, true)
+		if err != nil {
+			logger.Error("Failed to revert container", log.Ctx{"container": names[i], "err": err})
+			failure = err
+		}
+	}
+
+	return failure
+}
+
+// apply writes the profiles to the database and then updates the
+// containers, reverting everything done so far on the first failure.
+func (b *batch) apply(d *Daemon) error {
+	fail := func(err error, profiles []api.BatchProfile, names []string) error {
+		revertErr := b.revert(d, profiles, names)
+		if revertErr != nil {
+			return fmt.Errorf("%s (failed to revert the batch: %s)", err, revertErr)
+		}
+
+		return fmt.Errorf("%s (the batch was reverted)", err)
+	}
+
+	for i, profile := range b.profiles {
+		err := doProfileUpdateDb(d, b.profileIDs[profile.Name], b.oldProfiles[profile.Name], profile.ProfilePut)
+		if err != nil {
+			return fail(fmt.Errorf("Failed to update profile '%s': %s", profile.Name, err), b.profiles[:i], nil)
+		}
+	}
+
+	// Must be done after the profiles are committed due to DB lock.
+	for i, name := range b.names {
+		args := b.oldArgs[name]
+		change, ok := b.changes[name]
+		if ok {
+			args = batchContainerMerge(args, change)
+		}
+		args.UpdateSource = containerUpdateSourceBatch
+
+		err := b.containers[name].Update(args, true)
+		if err != nil {
+			return fail(fmt.Errorf("Failed to update container '%s': %s", name, err), b.profiles, b.names[:i])
+		}
+	}
+
+	return nil
+}
+
+// /1.0/batch
+// Change profiles and containers all at once, or not at all.
+func batchPost(d *Daemon, r *http.Request) Response {
+	req := api.BatchPost{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	if len(req.Profiles) == 0 && len(req.Containers) == 0 {
+		return BadRequest(fmt.Errorf("The batch is empty"))
+	}
+
+	b, err := batchLoad(d, req)
+	if err != nil {
+		if err == NoSuchObjectError || err == sql.ErrNoRows {
+			return SmartError(err)
+		}
+
+		return BadRequest(err)
+	}
+
+	run := func(op *operation) error {
+		return b.apply(d)
+	}
+
+	resources := map[string][]string{}
+	resources["containers"] = b.names
+	resources["profiles"] = []string{}
+	for _, profile := range req.Profiles {
+		resources["profiles"] = append(resources["profiles"], profile.Name)
+	}
+
+	op, err := operationCreate(operationClassTask, resources, nil, run, nil, nil)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return OperationResponse(op)
+}
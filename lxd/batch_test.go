@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lxc/lxd/lxd/types"
+	"github.com/lxc/lxd/shared/api"
+)
+
+func TestBatchContainerMerge(t *testing.T) {
+	args := containerArgs{
+		Config: map[string]string{
+			"limits.cpu":    "2",
+			"limits.memory": "1GB",
+		},
+		Devices: types.Devices{
+			"eth0": {"type": "nic", "nictype": "bridged", "parent": "lxdbr0"},
+			"data": {"type": "disk", "source": "/srv/data", "path": "/data"},
+		},
+		Profiles: []string{"default"},
+	}
+
+	change := api.BatchContainer{
+		Config: map[string]string{
+			"limits.cpu":    "4",
+			"limits.memory": "",
+		},
+		Devices: map[string]map[string]string{
+			"data": nil,
+			"logs": {"type": "disk", "source": "/srv/logs", "path": "/logs"},
+		},
+	}
+
+	merged := batchContainerMerge(args, change)
+
+	if !reflect.DeepEqual(merged.Config, map[string]string{"limits.cpu": "4"}) {
+		t.Errorf("Unexpected config: %v", merged.Config)
+	}
+
+	expected := types.Devices{
+		"eth0": {"type": "nic", "nictype": "bridged", "parent": "lxdbr0"},
+		"logs": {"type": "disk", "source": "/srv/logs", "path": "/logs"},
+	}
+
+	if !reflect.DeepEqual(merged.Devices, expected) {
+		t.Errorf("Unexpected devices: %v", merged.Devices)
+	}
+
+	if !reflect.DeepEqual(merged.Profiles, []string{"default"}) {
+		t.Errorf("Unexpected profiles: %v", merged.Profiles)
+	}
+
+	// The original arguments are kept for reverting.
+	if args.Config["limits.memory"] != "1GB" || args.Devices["data"] == nil {
+		t.Errorf("The original arguments were modified")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"gopkg.in/lxc/go-lxc.v2"
@@ -14,7 +15,10 @@ import (
 	"github.com/lxc/lxd/lxd/types"
 	"github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/logger"
 	"github.com/lxc/lxd/shared/osarch"
+
+	log "gopkg.in/inconshreveable/log15.v2"
 )
 
 // Helper functions
@@ -63,6 +67,10 @@ func containerValidConfigKey(d *Daemon, key string, value string) error {
 	if key == "raw.lxc" {
 		return lxcValidConfig(value)
 	}
+	if key == "linux.kernel_modules" {
+		_, err := kernelModulesParse(value)
+		return err
+	}
 	if key == "security.syscalls.blacklist_compat" {
 		for _, arch := range d.architectures {
 			if arch == osarch.ARCH_64BIT_INTEL_X86 ||
@@ -154,6 +162,10 @@ func containerValidDeviceConfigKey(t, k string) bool {
 			return true
 		case "pool":
 			return true
+		case "pool.selector":
+			return true
+		case "scratch":
+			return true
 		default:
 			return false
 		}
@@ -241,6 +253,35 @@ func containerValidConfig(d *Daemon, config map[string]string, profile bool, exp
 	return nil
 }
 
+// containerValidPrivileges checks the expanded configuration of a container
+// against the "core.unprivileged_only" server setting and the privilege
+// requirements declared by its image.
+func containerValidPrivileges(config map[string]string) error {
+	privileged := shared.IsTrue(config["security.privileged"])
+	unprivilegedOnly := daemonConfig["core.unprivileged_only"].GetBool()
+
+	switch config["image.requirements.privileged"] {
+	case "true":
+		if unprivilegedOnly {
+			return fmt.Errorf("The image requires a privileged container but this server only allows unprivileged containers.")
+		}
+
+		if !privileged {
+			return fmt.Errorf("The image requires a privileged container, set security.privileged to true.")
+		}
+	case "false":
+		if privileged {
+			return fmt.Errorf("The image doesn't support privileged containers, unset security.privileged.")
+		}
+	}
+
+	if privileged && unprivilegedOnly {
+		return fmt.Errorf("This server only allows unprivileged containers (core.unprivileged_only).")
+	}
+
+	return nil
+}
+
 func isRootDiskDevice(device types.Device) bool {
 	if device["type"] == "disk" && device["path"] == "/" && device["source"] == "" {
 		return true
@@ -271,6 +312,51 @@ func containerGetRootDiskDevice(devices types.Devices) (string, types.Device, er
 	return "", types.Device{}, fmt.Errorf("No root device could be found.")
 }
 
+// containerValidArchitecture checks that the host can run containers of the
+// architecture, either natively or through a personality.
+func containerValidArchitecture(d *Daemon, architecture int) error {
+	name, err := osarch.ArchitectureName(architecture)
+	if err != nil {
+		return err
+	}
+
+	if shared.IntInSlice(architecture, d.architectures) {
+		return nil
+	}
+
+	supported := []string{}
+	for _, arch := range d.architectures {
+		archName, _ := osarch.ArchitectureName(arch)
+		supported = append(supported, archName)
+	}
+
+	return fmt.Errorf("The %s architecture isn't supported by this host (supported: %s)", name, strings.Join(supported, ", "))
+}
+
+// containerImageArchitecture returns the architecture of a container created
+// from an image of the given architecture. The container may instead request
+// one of its personalities, e.g. i686 (linux32) for a x86_64 image.
+func containerImageArchitecture(d *Daemon, image int, requested int) (int, error) {
+	architecture := image
+	if requested != 0 && requested != image {
+		personalities, _ := osarch.ArchitecturePersonalities(image)
+		if !shared.IntInSlice(requested, personalities) {
+			imageName, _ := osarch.ArchitectureName(image)
+			requestedName, _ := osarch.ArchitectureName(requested)
+			return 0, fmt.Errorf("Containers created from %s images can't use the %s architecture", imageName, requestedName)
+		}
+
+		architecture = requested
+	}
+
+	err := containerValidArchitecture(d, architecture)
+	if err != nil {
+		return 0, err
+	}
+
+	return architecture, nil
+}
+
 func containerValidDevices(d *Daemon, devices types.Devices, profile bool, expanded bool) error {
 	// Empty device list
 	if devices == nil {
@@ -317,7 +403,18 @@ func containerValidDevices(d *Daemon, devices types.Devices, profile bool, expan
 				return fmt.Errorf("Disk entry is missing the required \"path\" property.")
 			}
 
-			if m["source"] == "" && m["path"] != "/" {
+			scratch := shared.IsTrue(m["scratch"])
+			if scratch {
+				if m["path"] == "/" {
+					return fmt.Errorf("The root disk can't be a scratch volume.")
+				}
+
+				if m["pool"] == "" || m["source"] != "" {
+					return fmt.Errorf("Scratch disk entries require the \"pool\" property and may not have a \"source\".")
+				}
+			}
+
+			if m["source"] == "" && m["path"] != "/" && !scratch {
 				return fmt.Errorf("Disk entry is missing the required \"source\" property.")
 			}
 
@@ -325,8 +422,8 @@ func containerValidDevices(d *Daemon, devices types.Devices, profile bool, expan
 				return fmt.Errorf("Root disk entry may not have a \"source\" property set.")
 			}
 
-			if m["size"] != "" && m["path"] != "/" {
-				return fmt.Errorf("Only the root disk may have a size quota.")
+			if m["size"] != "" && m["path"] != "/" && !scratch {
+				return fmt.Errorf("Only the root disk and scratch disks may have a size quota.")
 			}
 
 			if (m["path"] == "/" || !shared.IsDir(m["source"])) && m["recursive"] != "" {
@@ -402,17 +499,23 @@ type containerArgs struct {
 	Id int
 
 	Description  string
+	Labels       map[string]string
 	Architecture int
 	BaseImage    string
 	Config       map[string]string
 	CreationDate time.Time
 	LastUsedDate time.Time
+	ExpiryDate   time.Time
 	Ctype        containerType
 	Devices      types.Devices
 	Ephemeral    bool
 	Name         string
 	Profiles     []string
 	Stateful     bool
+
+	// What an update comes from, reported along with its changes, see
+	// containerChangesEvent.
+	UpdateSource string
 }
 
 // The container interface
@@ -425,11 +528,11 @@ type container interface {
 	Unfreeze() error
 
 	// Snapshots & migration
-	Restore(sourceContainer container) error
+	Restore(sourceContainer container, stateful bool) error
 	/* actionScript here is a script called action.sh in the stateDir, to
 	 * be passed to CRIU as --action-script
 	 */
-	Migrate(cmd uint, stateDir string, function string, stop bool, actionScript bool) error
+	Migrate(cmd uint, stateDir string, function string, stop bool, actionScript bool, preDumpDir string) error
 	Snapshots() ([]container, error)
 
 	// Config handling
@@ -483,9 +586,11 @@ type container interface {
 	Id() int
 	Name() string
 	Description() string
+	Labels() map[string]string
 	Architecture() int
 	CreationDate() time.Time
 	LastUsedDate() time.Time
+	ExpiryDate() time.Time
 	ExpandedConfig() map[string]string
 	ExpandedDevices() types.Devices
 	LocalConfig() map[string]string
@@ -509,6 +614,10 @@ type container interface {
 	StorageStart() (bool, error)
 	StorageStop() (bool, error)
 	Storage() storage
+
+	// StorageAuditSet sets the audit entry of the destructive storage
+	// operation the container is subject to, e.g. its deletion.
+	StorageAuditSet(audit *storageAudit)
 	IdmapSet() (*shared.IdmapSet, error)
 	LastIdmapSet() (*shared.IdmapSet, error)
 	TemplateApply(trigger string) error
@@ -569,6 +678,12 @@ func containerCreateFromImage(d *Daemon, args containerArgs, hash string) (conta
 		}
 	}
 
+	// Apply the security policy shipped with the image
+	err = imageSecurityPolicyApply(d, &args, hash)
+	if err != nil {
+		return nil, err
+	}
+
 	// Set the BaseImage field (regardless of previous value)
 	args.BaseImage = hash
 
@@ -598,6 +713,30 @@ func containerCreateFromImage(d *Daemon, args containerArgs, hash string) (conta
 	return c, nil
 }
 
+// containerQuiesce freezes a running container and flushes its pending writes
+// to disk so that it can be copied consistently. The returned function thaws
+// it again, unless it was already frozen.
+func containerQuiesce(c container) (func(), error) {
+	if !c.IsRunning() || c.IsFrozen() {
+		return func() {}, nil
+	}
+
+	err := c.Freeze()
+	if err != nil {
+		return nil, err
+	}
+
+	// Nothing inside the container can write anymore, flush what it wrote.
+	syscall.Sync()
+
+	return func() {
+		err := c.Unfreeze()
+		if err != nil {
+			logger.Error("Failed to unfreeze quiesced container", log.Ctx{"name": c.Name(), "err": err})
+		}
+	}, nil
+}
+
 func containerCreateAsCopy(d *Daemon, args containerArgs, sourceContainer container, containerOnly bool) (container, error) {
 	// Create the container.
 	ct, err := containerCreateInternal(d, args)
@@ -622,6 +761,7 @@ func containerCreateAsCopy(d *Daemon, args containerArgs, sourceContainer contai
 				Ctype:        cTypeSnapshot,
 				Devices:      snap.LocalDevices(),
 				Ephemeral:    snap.IsEphemeral(),
+				ExpiryDate:   snap.ExpiryDate(),
 				Name:         newSnapName,
 				Profiles:     snap.Profiles(),
 			}
@@ -663,6 +803,135 @@ func containerCreateAsCopy(d *Daemon, args containerArgs, sourceContainer contai
 	return ct, nil
 }
 
+// containerRefreshAsCopy brings a previous copy of the source container up to
+// date by only sending what changed since the latest snapshot they share. The
+// target's snapshots which the source doesn't have are deleted.
+func containerRefreshAsCopy(d *Daemon, target container, source container, containerOnly bool) error {
+	if target.IsRunning() {
+		return fmt.Errorf("The container \"%s\" must be stopped to be refreshed", target.Name())
+	}
+
+	// Check that the storage can do it before touching the target.
+	if target.Storage().GetStorageType() != storageTypeZfs {
+		return fmt.Errorf("Refreshing containers is only supported on ZFS storage pools")
+	}
+
+	_, sourcePool := source.Storage().GetContainerPoolInfo()
+	_, targetPool := target.Storage().GetContainerPoolInfo()
+	if sourcePool != targetPool {
+		return fmt.Errorf("Refreshing containers between different storage pools isn't supported")
+	}
+
+	sourceSnapshots, err := source.Snapshots()
+	if err != nil {
+		return err
+	}
+
+	targetSnapshots, err := target.Snapshots()
+	if err != nil {
+		return err
+	}
+
+	targetSnapshotNames := map[string]container{}
+	for _, snap := range targetSnapshots {
+		_, snapOnlyName, _ := containerGetParentAndSnapshotName(snap.Name())
+		targetSnapshotNames[snapOnlyName] = snap
+	}
+
+	// Find the latest snapshot of the source which the target also has.
+	base := -1
+	sourceSnapshotNames := []string{}
+	for i, snap := range sourceSnapshots {
+		_, snapOnlyName, _ := containerGetParentAndSnapshotName(snap.Name())
+		sourceSnapshotNames = append(sourceSnapshotNames, snapOnlyName)
+
+		if targetSnapshotNames[snapOnlyName] != nil {
+			base = i
+		}
+	}
+
+	if base < 0 {
+		return fmt.Errorf("The container \"%s\" doesn't share any snapshot with \"%s\", it must be copied again", target.Name(), source.Name())
+	}
+
+	// The incremental streams apply on top of the base snapshot, so the
+	// snapshots the target made on its own have to go.
+	for snapOnlyName, snap := range targetSnapshotNames {
+		if shared.StringInSlice(snapOnlyName, sourceSnapshotNames) {
+			continue
+		}
+
+		err := snap.Delete()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Create the snapshots the target is missing.
+	snapshots := []container{}
+	csList := []container{}
+	if !containerOnly {
+		snapshots = sourceSnapshots[base+1:]
+
+		for _, snap := range snapshots {
+			_, snapOnlyName, _ := containerGetParentAndSnapshotName(snap.Name())
+			newSnapName := fmt.Sprintf("%s/%s", target.Name(), snapOnlyName)
+			csArgs := containerArgs{
+				Architecture: snap.Architecture(),
+				Config:       snap.LocalConfig(),
+				Ctype:        cTypeSnapshot,
+				Devices:      snap.LocalDevices(),
+				Ephemeral:    snap.IsEphemeral(),
+				ExpiryDate:   snap.ExpiryDate(),
+				Name:         newSnapName,
+				Profiles:     snap.Profiles(),
+			}
+
+			cs, err := containerCreateInternal(d, csArgs)
+			if err != nil {
+				for _, cs := range csList {
+					cs.Delete()
+				}
+
+				return err
+			}
+
+			csList = append(csList, cs)
+		}
+	}
+
+	// Now refresh the storage.
+	err = target.Storage().ContainerRefresh(target, source, sourceSnapshots[base], snapshots)
+	if err != nil {
+		for _, cs := range csList {
+			cs.Delete()
+		}
+
+		return err
+	}
+
+	// The files now come from the source.
+	err = target.TemplateApply("copy")
+	if err != nil {
+		return err
+	}
+
+	// Apply any post-storage configuration.
+	err = containerConfigureInternal(target)
+	if err != nil {
+		return err
+	}
+
+	for _, cs := range csList {
+		err = containerConfigureInternal(cs)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func containerCreateAsSnapshot(d *Daemon, args containerArgs, sourceContainer container) (container, error) {
 	// Deal with state
 	if args.Stateful {
@@ -691,7 +960,7 @@ func containerCreateAsSnapshot(d *Daemon, args containerArgs, sourceContainer co
 		 * after snapshotting will fail.
 		 */
 
-		err = sourceContainer.Migrate(lxc.MIGRATE_DUMP, stateDir, "snapshot", false, false)
+		err = sourceContainer.Migrate(lxc.MIGRATE_DUMP, stateDir, "snapshot", false, false, "")
 		if err != nil {
 			os.RemoveAll(sourceContainer.StatePath())
 			return nil, err
@@ -750,6 +1019,10 @@ func containerCreateInternal(d *Daemon, args containerArgs) (container, error) {
 		args.Devices = types.Devices{}
 	}
 
+	if args.Labels == nil {
+		args.Labels = map[string]string{}
+	}
+
 	if args.Architecture == 0 {
 		args.Architecture = d.architectures[0]
 	}
@@ -768,6 +1041,18 @@ func containerCreateInternal(d *Daemon, args containerArgs) (container, error) {
 		return nil, err
 	}
 
+	// Validate container labels
+	err = shared.ValidLabels(args.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pick a storage pool for the root disk device if needed
+	err = containerPlaceRootDisk(d, &args)
+	if err != nil {
+		return nil, err
+	}
+
 	// Validate container devices
 	err = containerValidDevices(d, args.Devices, false, false)
 	if err != nil {
@@ -775,15 +1060,11 @@ func containerCreateInternal(d *Daemon, args containerArgs) (container, error) {
 	}
 
 	// Validate architecture
-	_, err = osarch.ArchitectureName(args.Architecture)
+	err = containerValidArchitecture(d, args.Architecture)
 	if err != nil {
 		return nil, err
 	}
 
-	if !shared.IntInSlice(args.Architecture, d.architectures) {
-		return nil, fmt.Errorf("Requested architecture isn't supported by this host")
-	}
-
 	// Validate profiles
 	profiles, err := dbProfiles(d.db)
 	if err != nil {
@@ -831,6 +1112,48 @@ func containerCreateInternal(d *Daemon, args containerArgs) (container, error) {
 	return c, nil
 }
 
+// containerPlaceRootDisk sets the storage pool of the root disk device of a
+// new container if the device only has a "pool.selector", picking the pool
+// matching it which holds the fewest containers.
+func containerPlaceRootDisk(d *Daemon, args *containerArgs) error {
+	rootDiskDeviceKey, rootDiskDevice, _ := containerGetRootDiskDevice(args.Devices)
+	if rootDiskDeviceKey == "" {
+		// Keep going as we want the last one in the profile chain
+		for _, pName := range args.Profiles {
+			_, p, err := dbProfileGet(d.db, pName)
+			if err != nil {
+				return err
+			}
+
+			k, v, _ := containerGetRootDiskDevice(p.Devices)
+			if k != "" {
+				rootDiskDeviceKey = k
+				rootDiskDevice = v
+			}
+		}
+	}
+
+	if rootDiskDeviceKey == "" || rootDiskDevice["pool"] != "" || rootDiskDevice["pool.selector"] == "" {
+		return nil
+	}
+
+	pool, err := storagePoolSelect(d, rootDiskDevice["pool.selector"])
+	if err != nil {
+		return err
+	}
+
+	// Give the container its own copy of the root disk device, with the
+	// pool set.
+	rootDev := map[string]string{}
+	for k, v := range rootDiskDevice {
+		rootDev[k] = v
+	}
+	rootDev["pool"] = pool
+	args.Devices[rootDiskDeviceKey] = rootDev
+
+	return nil
+}
+
 func containerConfigureInternal(c container) error {
 	// Find the root device
 	_, rootDiskDevice, err := containerGetRootDiskDevice(c.ExpandedDevices())
@@ -894,5 +1217,8 @@ func containerLoadByName(d *Daemon, name string) (container, error) {
 		return nil, err
 	}
 
+	// Include the changes of volatile keys which aren't committed yet
+	d.volatile.Apply(args.Id, args.Config)
+
 	return containerLXCLoad(d, args)
 }
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/agent"
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// containerAgentPath is where the lxd-agent binary of the host shows up in the
+// containers with "security.agent" set.
+const containerAgentPath = "/dev/lxd-agent"
+
+// containerAgentTimeout is how long LXD waits for the agent to accept a
+// connection before falling back to its own mechanisms.
+const containerAgentTimeout = time.Second
+
+// containerAgentEnabled returns whether a container runs the guest agent.
+func containerAgentEnabled(c container) bool {
+	return shared.IsTrue(c.ExpandedConfig()["security.agent"])
+}
+
+// containerAgentBinary returns the path of the lxd-agent binary on the host,
+// looked for next to the LXD binary first.
+func containerAgentBinary() (string, error) {
+	path := filepath.Join(filepath.Dir(execPath), "lxd-agent")
+	if shared.PathExists(path) {
+		return path, nil
+	}
+
+	return exec.LookPath("lxd-agent")
+}
+
+// containerAgentConnect connects to the guest agent of a running container.
+// Its socket is reached through the root of the init process, so no device
+// has to be set up for it.
+func containerAgentConnect(c container) (*agent.Conn, net.Conn, error) {
+	pid := c.InitPID()
+	if pid <= 0 {
+		return nil, nil, fmt.Errorf("The container isn't running")
+	}
+
+	path := filepath.Join("/proc", strconv.Itoa(pid), "root", agent.SocketPath)
+	conn, err := net.DialTimeout("unix", path, containerAgentTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return agent.NewConn(conn), conn, nil
+}
+
+// containerAgentResponseError turns the error reported by the agent into
+// the one the namespace-based mechanisms would have returned.
+func containerAgentResponseError(resp agent.Response) error {
+	if resp.NotFound {
+		return os.ErrNotExist
+	}
+
+	if resp.Error != "" {
+		return fmt.Errorf("%s", resp.Error)
+	}
+
+	return nil
+}
+
+// containerAgentAvailable returns whether the guest agent of a container is
+// enabled and answering, in which case it's used rather than the
+// namespace-based mechanisms.
+func containerAgentAvailable(c container) bool {
+	if !containerAgentEnabled(c) || !c.IsRunning() {
+		return false
+	}
+
+	return containerAgentPing(c) == nil
+}
+
+// containerAgentPing checks that the guest agent of a container is ready.
+func containerAgentPing(c container) error {
+	ac, conn, err := containerAgentConnect(c)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(containerAgentTimeout))
+
+	err = ac.Send(agent.Request{Type: agent.RequestPing})
+	if err != nil {
+		return err
+	}
+
+	resp := agent.Response{}
+	err = ac.Receive(&resp)
+	if err != nil {
+		return err
+	}
+
+	return containerAgentResponseError(resp)
+}
+
+// containerAgentStart starts the guest agent in a container which was just
+// started and waits for it to be ready.
+func containerAgentStart(c container) {
+	ctx := log.Ctx{"container": c.Name()}
+
+	// The agent may have been started by the container itself.
+	if containerAgentPing(c) == nil {
+		return
+	}
+
+	cmd, _, _, err := c.Exec([]string{containerAgentPath, agent.SocketPath}, map[string]string{}, nil, nil, nil, false)
+	if err != nil {
+		ctx["err"] = err
+		logger.Error("Failed to start the guest agent", ctx)
+		return
+	}
+
+	// The agent runs as long as the container does.
+	go cmd.Wait()
+
+	for i := 0; i < 10; i++ {
+		if containerAgentPing(c) == nil {
+			logger.Debug("The guest agent is ready", ctx)
+			return
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	logger.Warn("The guest agent didn't answer, falling back to the namespace-based mechanisms", ctx)
+}
+
+// containerAgentExec runs a command in a container through its guest agent
+// and returns its exit status.
+func containerAgentExec(c container, command []string, env map[string]string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+	ac, conn, err := containerAgentConnect(c)
+	if err != nil {
+		return -1, err
+	}
+	defer conn.Close()
+
+	req := agent.Request{
+		Type:        agent.RequestExec,
+		Command:     command,
+		Environment: env,
+		Cwd:         env["HOME"],
+	}
+
+	err = ac.Send(req)
+	if err != nil {
+		return -1, err
+	}
+
+	go func() {
+		w := ac.Writer(agent.ChannelStdin)
+		if stdin != nil {
+			io.Copy(w, stdin)
+		}
+		w.Close()
+	}()
+
+	for {
+		channel, data, err := ac.ReadFrame()
+		if err != nil {
+			return -1, err
+		}
+
+		switch channel {
+		case agent.ChannelStdout:
+			if stdout != nil {
+				stdout.Write(data)
+			}
+		case agent.ChannelStderr:
+			if stderr != nil {
+				stderr.Write(data)
+			}
+		case agent.ChannelControl:
+			resp := agent.Response{}
+			err := json.Unmarshal(data, &resp)
+			if err != nil {
+				return -1, err
+			}
+
+			err = containerAgentResponseError(resp)
+			if err != nil {
+				return -1, err
+			}
+
+			return resp.Return, nil
+		}
+	}
+}
+
+// containerAgentFilePull copies a file out of a container through its guest
+// agent, like containerLXC.FilePull.
+func containerAgentFilePull(c container, srcpath string, dstpath string) (int64, int64, os.FileMode, string, []string, error) {
+	ac, conn, err := containerAgentConnect(c)
+	if err != nil {
+		return -1, -1, 0, "", nil, err
+	}
+	defer conn.Close()
+
+	err = ac.Send(agent.Request{Type: agent.RequestFileGet, Path: srcpath})
+	if err != nil {
+		return -1, -1, 0, "", nil, err
+	}
+
+	resp := agent.Response{}
+	err = ac.Receive(&resp)
+	if err != nil {
+		return -1, -1, 0, "", nil, err
+	}
+
+	err = containerAgentResponseError(resp)
+	if err != nil {
+		return -1, -1, 0, "", nil, err
+	}
+
+	f, err := os.OpenFile(dstpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return -1, -1, 0, "", nil, err
+	}
+	defer f.Close()
+
+	for {
+		channel, data, err := ac.ReadFrame()
+		if err != nil {
+			return -1, -1, 0, "", nil, err
+		}
+
+		if channel != agent.ChannelStdout {
+			return -1, -1, 0, "", nil, fmt.Errorf("Unexpected frame on channel %d", channel)
+		}
+
+		if len(data) == 0 {
+			break
+		}
+
+		_, err = f.Write(data)
+		if err != nil {
+			return -1, -1, 0, "", nil, err
+		}
+	}
+
+	// The agent reports errors hit while sending the file last.
+	final := agent.Response{}
+	err = ac.Receive(&final)
+	if err != nil {
+		return -1, -1, 0, "", nil, err
+	}
+
+	err = containerAgentResponseError(final)
+	if err != nil {
+		return -1, -1, 0, "", nil, err
+	}
+
+	return resp.UID, resp.GID, os.FileMode(resp.Mode), resp.FileType, resp.Entries, nil
+}
+
+// containerAgentFilePush copies a file into a container through its guest
+// agent, like containerLXC.FilePush. For symlinks, srcpath is the target.
+func containerAgentFilePush(c container, type_ string, srcpath string, dstpath string, uid int64, gid int64, mode int, write string) error {
+	ac, conn, err := containerAgentConnect(c)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := agent.Request{
+		Type:     agent.RequestFilePut,
+		Path:     dstpath,
+		FileType: type_,
+		UID:      uid,
+		GID:      gid,
+		Mode:     mode,
+		Write:    write,
+	}
+
+	err = ac.Send(req)
+	if err != nil {
+		return err
+	}
+
+	// The agent may give up early, its response then tells why.
+	w := ac.Writer(agent.ChannelStdin)
+	switch type_ {
+	case "file":
+		f, err := os.Open(srcpath)
+		if err != nil {
+			return err
+		}
+
+		io.Copy(w, f)
+		f.Close()
+	case "symlink":
+		w.Write([]byte(srcpath))
+	}
+	w.Close()
+
+	resp := agent.Response{}
+	err = ac.Receive(&resp)
+	if err != nil {
+		return err
+	}
+
+	return containerAgentResponseError(resp)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/version"
+)
+
+func containerBackupsGet(d *Daemon, r *http.Request) Response {
+	recursionStr := r.FormValue("recursion")
+	recursion, err := strconv.Atoi(recursionStr)
+	if err != nil {
+		recursion = 0
+	}
+
+	cname := mux.Vars(r)["name"]
+	_, err = containerLoadByName(d, cname)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	names, err := dbContainerGetBackups(d.db, cname)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	resultString := []string{}
+	resultMap := []*api.ContainerBackup{}
+
+	for _, name := range names {
+		if recursion == 0 {
+			url := fmt.Sprintf("/%s/containers/%s/backups/%s", version.APIVersion, cname, name)
+			resultString = append(resultString, url)
+		} else {
+			args, err := dbContainerBackupGet(d.db, cname, name)
+			if err != nil {
+				continue
+			}
+
+			resultMap = append(resultMap, backupRender(args))
+		}
+	}
+
+	if recursion == 0 {
+		return SyncResponse(true, resultString)
+	}
+
+	return SyncResponse(true, resultMap)
+}
+
+func containerBackupsPost(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+
+	c, err := containerLoadByName(d, name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	req := api.ContainerBackupsPost{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return BadRequest(err)
+	}
+
+	if req.Name == "" {
+		// come up with a name
+		existing, err := dbContainerGetBackups(d.db, name)
+		if err != nil {
+			return SmartError(err)
+		}
+
+		for i := 0; ; i++ {
+			req.Name = fmt.Sprintf("backup%d", i)
+			if !shared.StringInSlice(req.Name, existing) {
+				break
+			}
+		}
+	}
+
+	err = backupValidName(req.Name)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	_, err = dbContainerBackupGet(d.db, name, req.Name)
+	if err == nil {
+		return Conflict
+	}
+
+	if err != NoSuchObjectError {
+		return SmartError(err)
+	}
+
+	if req.OptimizedStorage && c.Storage().GetStorageType() != storageTypeZfs {
+		return BadRequest(fmt.Errorf("Optimized backups are only supported on ZFS storage pools"))
+	}
+
+	backup := func(op *operation) error {
+		storageFreezeEnter()
+		defer storageFreezeLeave()
+
+		args := backupArgs{
+			Name:             req.Name,
+			ContainerOnly:    req.ContainerOnly,
+			OptimizedStorage: req.OptimizedStorage,
+		}
+
+		return backupCreate(d, args, c, op)
+	}
+
+	resources := map[string][]string{}
+	resources["containers"] = []string{name}
+
+	op, err := operationCreate(operationClassTask, resources, nil, backup, nil, nil)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return OperationResponse(op)
+}
+
+func containerBackupGet(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+	backupName := mux.Vars(r)["backupName"]
+
+	args, err := dbContainerBackupGet(d.db, name, backupName)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	return SyncResponse(true, backupRender(args))
+}
+
+func containerBackupDelete(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+	backupName := mux.Vars(r)["backupName"]
+
+	_, err := dbContainerBackupGet(d.db, name, backupName)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	remove := func(op *operation) error {
+		return backupDelete(d, name, backupName)
+	}
+
+	resources := map[string][]string{}
+	resources["containers"] = []string{name}
+
+	op, err := operationCreate(operationClassTask, resources, nil, remove, nil, nil)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return OperationResponse(op)
+}
+
+func containerBackupExportGet(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+	backupName := mux.Vars(r)["backupName"]
+
+	if !d.isTrustedClient(r) && !signedURLValid(r) {
+		return Forbidden
+	}
+
+	_, err := dbContainerBackupGet(d.db, name, backupName)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	ent := fileResponseEntry{
+		path:     backupPath(name, backupName),
+		filename: fmt.Sprintf("%s-%s.tar.gz", name, backupName),
+	}
+
+	return FileResponse(r, []fileResponseEntry{ent}, nil, false)
+}
+
+func containerBackupSignedURL(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+	backupName := mux.Vars(r)["backupName"]
+
+	_, err := dbContainerBackupGet(d.db, name, backupName)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	return signedURLResponse(r, fmt.Sprintf("/%s/containers/%s/backups/%s/export", version.APIVersion, name, backupName))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"reflect"
+
+	"github.com/lxc/lxd/lxd/types"
+	"github.com/lxc/lxd/shared"
+)
+
+// The sources of container updates, reported in the "config-changed" events.
+const (
+	containerUpdateSourceAPI     = "api"
+	containerUpdateSourceProfile = "profile"
+	containerUpdateSourceBatch   = "batch"
+	containerUpdateSourceRestore = "restore"
+	containerUpdateSourceStorage = "storage"
+	containerUpdateSourceRebuild = "rebuild"
+	containerUpdateSourceLXD     = "internal"
+)
+
+// containerValueChange is the old and new values of a configuration key or
+// device, nil when it didn't exist.
+type containerValueChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// containerConfigDiff returns the keys whose value differs between two
+// configurations.
+func containerConfigDiff(oldConfig map[string]string, newConfig map[string]string) map[string]containerValueChange {
+	diff := map[string]containerValueChange{}
+
+	value := func(config map[string]string, key string) interface{} {
+		v, ok := config[key]
+		if !ok {
+			return nil
+		}
+
+		return v
+	}
+
+	for key := range oldConfig {
+		if oldConfig[key] != newConfig[key] {
+			diff[key] = containerValueChange{Old: value(oldConfig, key), New: value(newConfig, key)}
+		}
+	}
+
+	for key := range newConfig {
+		_, ok := oldConfig[key]
+		if !ok {
+			diff[key] = containerValueChange{Old: nil, New: newConfig[key]}
+		}
+	}
+
+	return diff
+}
+
+// containerDevicesDiff returns the devices which were added, removed or
+// changed between two sets of devices.
+func containerDevicesDiff(oldDevices types.Devices, newDevices types.Devices) map[string]containerValueChange {
+	diff := map[string]containerValueChange{}
+
+	for name, oldDevice := range oldDevices {
+		newDevice, ok := newDevices[name]
+		if !ok {
+			diff[name] = containerValueChange{Old: oldDevice, New: nil}
+			continue
+		}
+
+		if !reflect.DeepEqual(oldDevice, newDevice) {
+			diff[name] = containerValueChange{Old: oldDevice, New: newDevice}
+		}
+	}
+
+	for name, newDevice := range newDevices {
+		_, ok := oldDevices[name]
+		if !ok {
+			diff[name] = containerValueChange{Old: nil, New: newDevice}
+		}
+	}
+
+	return diff
+}
+
+// containerChangesEvent sends a "config-changed" container event listing the
+// changes of the expanded configuration, devices and profiles of a container,
+// unless nothing changed.
+func containerChangesEvent(c container, source string, oldConfig map[string]string, oldDevices types.Devices, oldProfiles []string) {
+	if source == "" {
+		source = containerUpdateSourceLXD
+	}
+
+	config := containerConfigDiff(oldConfig, c.ExpandedConfig())
+	devices := containerDevicesDiff(oldDevices, c.ExpandedDevices())
+	profilesChanged := !reflect.DeepEqual(oldProfiles, c.Profiles())
+
+	if len(config) == 0 && len(devices) == 0 && !profilesChanged {
+		return
+	}
+
+	event := shared.Jmap{
+		"action":    "config-changed",
+		"container": c.Name(),
+		"source":    source,
+		"config":    config,
+		"devices":   devices,
+	}
+
+	if profilesChanged {
+		event["profiles"] = containerValueChange{Old: oldProfiles, New: c.Profiles()}
+	}
+
+	eventSend("container", event)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lxc/lxd/lxd/types"
+)
+
+func TestContainerConfigDiff(t *testing.T) {
+	oldConfig := map[string]string{
+		"limits.cpu":     "2",
+		"limits.memory":  "1GB",
+		"boot.autostart": "true",
+	}
+
+	newConfig := map[string]string{
+		"limits.cpu":       "4",
+		"boot.autostart":   "true",
+		"security.nesting": "true",
+	}
+
+	expected := map[string]containerValueChange{
+		"limits.cpu":       {Old: "2", New: "4"},
+		"limits.memory":    {Old: "1GB", New: nil},
+		"security.nesting": {Old: nil, New: "true"},
+	}
+
+	diff := containerConfigDiff(oldConfig, newConfig)
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Unexpected diff: %v", diff)
+	}
+
+	if len(containerConfigDiff(oldConfig, oldConfig)) != 0 {
+		t.Errorf("Unexpected diff of identical configurations")
+	}
+}
+
+func TestContainerDevicesDiff(t *testing.T) {
+	oldDevices := types.Devices{
+		"eth0": {"type": "nic", "nictype": "bridged", "parent": "lxdbr0"},
+		"data": {"type": "disk", "source": "/srv/data", "path": "/data"},
+		"root": {"type": "disk", "pool": "default", "path": "/"},
+	}
+
+	newDevices := types.Devices{
+		"eth0": {"type": "nic", "nictype": "bridged", "parent": "lxdbr1"},
+		"logs": {"type": "disk", "source": "/srv/logs", "path": "/logs"},
+		"root": {"type": "disk", "pool": "default", "path": "/"},
+	}
+
+	diff := containerDevicesDiff(oldDevices, newDevices)
+	if len(diff) != 3 {
+		t.Fatalf("Expected 3 changed devices, got %d: %v", len(diff), diff)
+	}
+
+	if !reflect.DeepEqual(diff["eth0"], containerValueChange{Old: oldDevices["eth0"], New: newDevices["eth0"]}) {
+		t.Errorf("Unexpected change of eth0: %v", diff["eth0"])
+	}
+
+	if diff["data"].New != nil || diff["data"].Old == nil {
+		t.Errorf("Expected data to be removed: %v", diff["data"])
+	}
+
+	if diff["logs"].Old != nil || diff["logs"].New == nil {
+		t.Errorf("Expected logs to be added: %v", diff["logs"])
+	}
+}
@@ -18,8 +18,25 @@ func containerDelete(d *Daemon, r *http.Request) Response {
 		return BadRequest(fmt.Errorf("container is running"))
 	}
 
+	if dryRunRequested(r) {
+		plan, err := containerDeletePlan(d, c)
+		if err != nil {
+			return SmartError(err)
+		}
+
+		return SyncResponse(true, plan)
+	}
+
 	rmct := func(op *operation) error {
-		return c.Delete()
+		storageFreezeEnter()
+		defer storageFreezeLeave()
+
+		poolName, _ := c.StoragePool()
+		audit := storageAuditStart(r, poolName, "container_delete", name)
+		c.StorageAuditSet(audit)
+		err := c.Delete()
+		audit.finish(d, err)
+		return err
 	}
 
 	resources := map[string][]string{}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lxc/lxd/shared"
+)
+
+// /1.0/containers/{name}/diff?from=<snapshot>[&to=<snapshot>]
+// List the files which changed between a snapshot of the container and
+// either a later snapshot or the current state of the container.
+func containerDiffGet(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+	from := r.FormValue("from")
+	to := r.FormValue("to")
+
+	if from == "" {
+		return BadRequest(fmt.Errorf("No snapshot to compare from provided"))
+	}
+
+	c, err := containerLoadByName(d, name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	dataset, mountpoint, ok := zfsContainerDataset(c)
+	if !ok {
+		return BadRequest(fmt.Errorf("Listing changes is only supported for containers on ZFS storage pools"))
+	}
+
+	snapshotDataset := func(snapshotName string) (string, error) {
+		_, err := containerLoadByName(d, name+shared.SnapshotDelimiter+snapshotName)
+		if err != nil {
+			return "", err
+		}
+
+		return zfsContainerSnapshotFullName(c.Storage(), name, snapshotName)
+	}
+
+	source, err := snapshotDataset(from)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	target := dataset
+	if to != "" {
+		target, err = snapshotDataset(to)
+		if err != nil {
+			return SmartError(err)
+		}
+	}
+
+	// "zfs diff" only works on mounted filesystems.
+	ourStart, err := c.StorageStart()
+	if err != nil {
+		return SmartError(err)
+	}
+	if ourStart {
+		defer c.StorageStop()
+	}
+
+	output, err := shared.RunCommand("zfs", "diff", "-H", "-F", source, target)
+	if err != nil {
+		return InternalError(fmt.Errorf("Failed to compute ZFS diff: %s", output))
+	}
+
+	return SyncResponse(true, zfsParseDiff(output, mountpoint))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// containerDiskAlertInterval is how often the disk usage of the running
+// containers with "limits.disk.alert" set is checked.
+const containerDiskAlertInterval = 5 * time.Minute
+
+// containerDiskAlerted records the containers a disk alert was sent for, so
+// that it's only sent again once their usage went back below the threshold.
+var containerDiskAlerted = map[string]bool{}
+var containerDiskAlertLock sync.Mutex
+
+// containerDiskAlertThreshold returns the usage in bytes of the root disk of a
+// container above which "limits.disk.alert" is crossed, given the size of the
+// root disk. A percentage of a root disk without size never is, 0 being
+// returned.
+func containerDiskAlertThreshold(alert string, size string) (int64, error) {
+	if alert == "" {
+		return 0, nil
+	}
+
+	if strings.HasSuffix(alert, "%") {
+		percent, err := strconv.ParseInt(strings.TrimSuffix(alert, "%"), 10, 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return 0, fmt.Errorf("Invalid disk alert percentage \"%s\"", alert)
+		}
+
+		if size == "" {
+			return 0, nil
+		}
+
+		quota, err := shared.ParseByteSizeString(size)
+		if err != nil {
+			return 0, err
+		}
+
+		return quota * percent / 100, nil
+	}
+
+	return shared.ParseByteSizeString(alert)
+}
+
+// containerDiskAlertCrossed returns whether the given usage of the root disk
+// of a container crossed its "limits.disk.alert".
+func containerDiskAlertCrossed(c container, root map[string]string, usage int64) bool {
+	threshold, err := containerDiskAlertThreshold(c.ExpandedConfig()["limits.disk.alert"], root["size"])
+	if err != nil || threshold <= 0 {
+		return false
+	}
+
+	return usage >= threshold
+}
+
+// containersDiskAlertCheck checks the disk usage of the running containers
+// and sends a "container" event whenever one crosses its "limits.disk.alert"
+// or goes back below it.
+func containersDiskAlertCheck(d *Daemon) {
+	names, err := dbContainersList(d.db, cTypeRegular)
+	if err != nil {
+		logger.Error("Unable to retrieve the list of containers", log.Ctx{"err": err})
+		return
+	}
+
+	containerDiskAlertLock.Lock()
+	defer containerDiskAlertLock.Unlock()
+
+	// Forget about containers which are gone.
+	for name := range containerDiskAlerted {
+		if !shared.StringInSlice(name, names) {
+			delete(containerDiskAlerted, name)
+		}
+	}
+
+	for _, name := range names {
+		c, err := containerLoadByName(d, name)
+		if err != nil {
+			logger.Error("Failed to load container", log.Ctx{"container": name, "err": err})
+			continue
+		}
+
+		alert := c.ExpandedConfig()["limits.disk.alert"]
+		if alert == "" {
+			delete(containerDiskAlerted, name)
+			continue
+		}
+
+		// The usage of a stopped container doesn't change.
+		if !c.IsRunning() || c.Storage() == nil {
+			continue
+		}
+
+		_, root, err := containerGetRootDiskDevice(c.ExpandedDevices())
+		if err != nil {
+			continue
+		}
+
+		usage, err := c.Storage().ContainerGetUsage(c)
+		if err != nil {
+			logger.Debugf("Failed to get the disk usage of container \"%s\": %s.", name, err)
+			continue
+		}
+
+		crossed := containerDiskAlertCrossed(c, root, usage)
+		if crossed == containerDiskAlerted[name] {
+			continue
+		}
+
+		containerDiskAlerted[name] = crossed
+
+		action := "disk-alert-cleared"
+		if crossed {
+			action = "disk-alert"
+			logger.Warn("Container disk usage crossed its alert threshold", log.Ctx{"container": name, "usage": usage, "alert": alert})
+		}
+
+		eventSend("container", shared.Jmap{
+			"action":    action,
+			"container": name,
+			"usage":     usage,
+			"alert":     alert,
+			"size":      root["size"],
+		})
+	}
+}
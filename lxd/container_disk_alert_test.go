@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestContainerDiskAlertThreshold(t *testing.T) {
+	tests := []struct {
+		alert    string
+		size     string
+		expected int64
+	}{
+		{"", "10GB", 0},
+		{"90%", "", 0},
+		{"90%", "10GB", 9663676416},
+		{"50%", "1GB", 536870912},
+		{"100%", "1GB", 1073741824},
+		{"8GB", "", 8589934592},
+		{"8GB", "10GB", 8589934592},
+	}
+
+	for _, test := range tests {
+		threshold, err := containerDiskAlertThreshold(test.alert, test.size)
+		if err != nil {
+			t.Errorf("Failed to get the threshold of \"%s\" for \"%s\": %s", test.alert, test.size, err)
+			continue
+		}
+
+		if threshold != test.expected {
+			t.Errorf("Expected the threshold of \"%s\" for \"%s\" to be %d, got %d", test.alert, test.size, test.expected, threshold)
+		}
+	}
+
+	for _, alert := range []string{"0%", "101%", "abc%", "lots"} {
+		_, err := containerDiskAlertThreshold(alert, "10GB")
+		if err == nil {
+			t.Errorf("Expected \"%s\" to be refused", alert)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// containerInitSystem describes how to cleanly shut down a container
+// running a given init system.
+type containerInitSystem struct {
+	// Signal the init system halts the container on.
+	signal string
+
+	// How long to wait for the init system to halt the container when
+	// "boot.host_shutdown_timeout" isn't set.
+	timeout time.Duration
+}
+
+// containerInitSystems are the init systems LXD knows how to shut down. Those
+// it can't tell apart get the "unknown" behavior, which is what LXC does.
+var containerInitSystems = map[string]containerInitSystem{
+	// systemd only starts sigpwr.target on SIGPWR, which isn't a shutdown
+	// on all distributions, while SIGRTMIN+3 always halts. Stopping all
+	// the units can take a while.
+	"systemd": {signal: "SIGRTMIN+3", timeout: 90 * time.Second},
+
+	// sysvinit runs the "powerfail" entry of inittab on SIGPWR.
+	"sysvinit": {signal: "SIGPWR", timeout: 30 * time.Second},
+
+	// busybox ignores SIGPWR and powers off on SIGUSR2.
+	"busybox": {signal: "SIGUSR2", timeout: 10 * time.Second},
+
+	"unknown": {signal: "SIGPWR", timeout: 30 * time.Second},
+}
+
+// containerInitFromExe tells the init system of a container from the path
+// of the executable of its init process and its command name.
+func containerInitFromExe(exe string, comm string) string {
+	exe = strings.TrimSuffix(exe, " (deleted)")
+	comm = strings.TrimSpace(comm)
+
+	switch filepath.Base(exe) {
+	case "systemd":
+		return "systemd"
+	case "busybox":
+		return "busybox"
+	case "init":
+		// Some distributions ship systemd as /sbin/init rather than
+		// as a symlink to it.
+		if comm == "systemd" {
+			return "systemd"
+		}
+
+		return "sysvinit"
+	}
+
+	if comm == "systemd" {
+		return "systemd"
+	}
+
+	return "unknown"
+}
+
+// containerInitSystemDetect returns the init system of a running container,
+// or an empty string if it's not running.
+func containerInitSystemDetect(c container) string {
+	pid := c.InitPID()
+	if pid <= 0 {
+		return ""
+	}
+
+	procPath := filepath.Join("/proc", strconv.Itoa(pid))
+
+	// The executable is resolved within the container, so symlinks like
+	// /sbin/init are already followed.
+	exe, err := os.Readlink(filepath.Join(procPath, "exe"))
+	if err != nil {
+		return "unknown"
+	}
+
+	comm, _ := ioutil.ReadFile(filepath.Join(procPath, "comm"))
+
+	return containerInitFromExe(exe, string(comm))
+}
+
+// containerInitSystemGet returns how to shut down a container running the
+// given init system.
+func containerInitSystemGet(name string) containerInitSystem {
+	system, ok := containerInitSystems[name]
+	if !ok {
+		return containerInitSystems["unknown"]
+	}
+
+	return system
+}
+
+// containerShutdownTimeout returns how long to wait for a container to shut
+// down cleanly when LXD stops it on its own, that is
+// "boot.host_shutdown_timeout" if set or what its init system usually needs.
+func containerShutdownTimeout(c container) time.Duration {
+	value, ok := c.ExpandedConfig()["boot.host_shutdown_timeout"]
+	if ok {
+		timeoutSeconds, _ := strconv.Atoi(value)
+		return time.Duration(timeoutSeconds) * time.Second
+	}
+
+	return containerInitSystemGet(containerInitSystemDetect(c)).timeout
+}
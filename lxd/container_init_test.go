@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestContainerInitFromExe(t *testing.T) {
+	tests := []struct {
+		exe      string
+		comm     string
+		expected string
+	}{
+		{"/lib/systemd/systemd", "systemd\n", "systemd"},
+		{"/usr/lib/systemd/systemd (deleted)", "systemd\n", "systemd"},
+		{"/sbin/init", "systemd\n", "systemd"},
+		{"/sbin/init", "init\n", "sysvinit"},
+		{"/bin/busybox", "init\n", "busybox"},
+		{"/usr/bin/tini", "tini\n", "unknown"},
+	}
+
+	for _, test := range tests {
+		system := containerInitFromExe(test.exe, test.comm)
+		if system != test.expected {
+			t.Errorf("Expected %s for %s (%s), got %s", test.expected, test.exe, test.comm, system)
+		}
+	}
+
+	if containerInitSystemGet("openrc").signal != "SIGPWR" {
+		t.Errorf("Expected unknown init systems to be sent SIGPWR")
+	}
+}
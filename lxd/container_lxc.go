@@ -242,12 +242,14 @@ func containerLXCCreate(d *Daemon, args containerArgs) (container, error) {
 		id:           args.Id,
 		name:         args.Name,
 		description:  args.Description,
+		labels:       args.Labels,
 		ephemeral:    args.Ephemeral,
 		architecture: args.Architecture,
 		cType:        args.Ctype,
 		stateful:     args.Stateful,
 		creationDate: args.CreationDate,
 		lastUsedDate: args.LastUsedDate,
+		expiryDate:   args.ExpiryDate,
 		profiles:     args.Profiles,
 		localConfig:  args.Config,
 		localDevices: args.Devices,
@@ -281,6 +283,23 @@ func containerLXCCreate(d *Daemon, args containerArgs) (container, error) {
 		return nil, err
 	}
 
+	err = storagePoolVolumeAttachCheck(d, c.name, c.expandedDevices)
+	if err != nil {
+		c.Delete()
+		logger.Error("Failed creating container", ctxMap)
+		return nil, err
+	}
+
+	// Snapshots keep the privileges of their container
+	if c.cType == cTypeRegular {
+		err = containerValidPrivileges(c.expandedConfig)
+		if err != nil {
+			c.Delete()
+			logger.Error("Failed creating container", ctxMap)
+			return nil, err
+		}
+	}
+
 	// Retrieve the container's storage pool
 	_, rootDiskDevice, err := containerGetRootDiskDevice(c.expandedDevices)
 	if err != nil {
@@ -309,6 +328,26 @@ func containerLXCCreate(d *Daemon, args containerArgs) (container, error) {
 		return nil, err
 	}
 
+	quota := rootDiskDevice["size"]
+	if quota == "" {
+		quota = volumeConfig["size"]
+	}
+
+	size := int64(0)
+	if quota != "" {
+		size, err = shared.ParseByteSizeString(quota)
+		if err != nil {
+			c.Delete()
+			return nil, err
+		}
+	}
+
+	err = storagePoolQuotaCheck(d, storagePool, size)
+	if err != nil {
+		c.Delete()
+		return nil, err
+	}
+
 	// Create a new database entry for the container's storage volume
 	_, err = dbStoragePoolVolumeCreate(d.db, args.Name, "", storagePoolVolumeTypeContainer, poolID, volumeConfig)
 	if err != nil {
@@ -393,6 +432,23 @@ func containerLXCCreate(d *Daemon, args containerArgs) (container, error) {
 		return nil, err
 	}
 
+	// Run through initLXC to generate the volatile keys of the devices,
+	// like the MAC addresses, and commit them along with the rest of the
+	// container rather than whenever the volatile store gets flushed.
+	err = c.initLXC()
+	if err != nil {
+		c.Delete()
+		logger.Error("Failed creating container", ctxMap)
+		return nil, err
+	}
+
+	err = d.volatile.Flush()
+	if err != nil {
+		c.Delete()
+		logger.Error("Failed creating container", ctxMap)
+		return nil, err
+	}
+
 	// Update lease files
 	networkUpdateStatic(d, "")
 
@@ -408,17 +464,24 @@ func containerLXCLoad(d *Daemon, args containerArgs) (container, error) {
 		id:           args.Id,
 		name:         args.Name,
 		description:  args.Description,
+		labels:       args.Labels,
 		ephemeral:    args.Ephemeral,
 		architecture: args.Architecture,
 		cType:        args.Ctype,
 		creationDate: args.CreationDate,
 		lastUsedDate: args.LastUsedDate,
+		expiryDate:   args.ExpiryDate,
 		profiles:     args.Profiles,
 		localConfig:  args.Config,
 		localDevices: args.Devices,
 		stateful:     args.Stateful,
 	}
 
+	// Containers without labels have an empty set of them
+	if c.labels == nil {
+		c.labels = map[string]string{}
+	}
+
 	// Load the config.
 	err := c.init()
 	if err != nil {
@@ -435,10 +498,12 @@ type containerLXC struct {
 	cType        containerType
 	creationDate time.Time
 	lastUsedDate time.Time
+	expiryDate   time.Time
 	ephemeral    bool
 	id           int
 	name         string
 	description  string
+	labels       map[string]string
 	stateful     bool
 
 	// Config
@@ -456,6 +521,7 @@ type containerLXC struct {
 
 	// Storage
 	storage storage
+	audit   *storageAudit
 }
 
 func (c *containerLXC) createOperation(action string, reusable bool, reuse bool) (*lxcContainerOperation, error) {
@@ -988,6 +1054,19 @@ func (c *containerLXC) initLXC() error {
 		return err
 	}
 
+	// Setup the guest agent, LXD falls back to its own mechanisms without it
+	if containerAgentEnabled(c) {
+		agentPath, err := containerAgentBinary()
+		if err != nil {
+			logger.Warn("Couldn't find lxd-agent, not setting up the guest agent", log.Ctx{"container": c.Name(), "err": err})
+		} else {
+			err = lxcSetConfigItem(cc, "lxc.mount.entry", fmt.Sprintf("%s %s none bind,ro,create=file 0 0", agentPath, strings.TrimPrefix(containerAgentPath, "/")))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	// Setup AppArmor
 	if aaAvailable {
 		if aaConfined || !aaAdmin {
@@ -1594,6 +1673,12 @@ func (c *containerLXC) startCommon() (string, error) {
 		return "", fmt.Errorf("The container is already running")
 	}
 
+	// Forget about the readiness of a previous run
+	err = containerReadyReset(c.daemon, c.id, c.localConfig)
+	if err != nil {
+		return "", err
+	}
+
 	// Sanity checks for devices
 	for name, m := range c.expandedDevices {
 		switch m["type"] {
@@ -1622,15 +1707,9 @@ func (c *containerLXC) startCommon() (string, error) {
 	}
 
 	// Load any required kernel modules
-	kernelModules := c.expandedConfig["linux.kernel_modules"]
-	if kernelModules != "" {
-		for _, module := range strings.Split(kernelModules, ",") {
-			module = strings.TrimPrefix(module, " ")
-			err := loadModule(module)
-			if err != nil {
-				return "", fmt.Errorf("Failed to load kernel module '%s': %s", module, err)
-			}
-		}
+	err = kernelModulesLoad(c.expandedConfig["linux.kernel_modules"])
+	if err != nil {
+		return "", err
 	}
 
 	var ourStart bool
@@ -1650,15 +1729,11 @@ func (c *containerLXC) startCommon() (string, error) {
 			return "", err
 		}
 
-		// Remove the volatile key from the DB
-		err = dbContainerConfigRemove(c.daemon.db, c.id, "volatile.apply_quota")
+		// Remove the volatile key
+		err = c.volatileSet("volatile.apply_quota", "")
 		if err != nil {
 			return "", err
 		}
-
-		// Remove the volatile key from the in-memory configs
-		delete(c.localConfig, "volatile.apply_quota")
-		delete(c.expandedConfig, "volatile.apply_quota")
 	}
 
 	/* Deal with idmap changes */
@@ -1683,16 +1758,25 @@ func (c *containerLXC) startCommon() (string, error) {
 		jsonIdmap = "[]"
 	}
 
-	if !reflect.DeepEqual(idmap, lastIdmap) {
+	// Changing the paths left alone also takes a remap.
+	excludes := idmapShiftExcludes(c.expandedConfig)
+	lastExcludes := idmapShiftLastExcludes(c.expandedConfig)
+
+	if !reflect.DeepEqual(idmap, lastIdmap) || !reflect.DeepEqual(excludes, lastExcludes) {
 		logger.Debugf("Container idmap changed, remapping")
 
+		err = storageShiftCheck(c.storage)
+		if err != nil {
+			return "", err
+		}
+
 		ourStart, err = c.StorageStart()
 		if err != nil {
 			return "", err
 		}
 
 		if lastIdmap != nil {
-			err = lastIdmap.UnshiftRootfs(c.RootfsPath())
+			err = lastIdmap.UnshiftRootfs(c.RootfsPath(), idmapShiftSkipper(lastExcludes))
 			if err != nil {
 				if ourStart {
 					c.StorageStop()
@@ -1702,7 +1786,7 @@ func (c *containerLXC) startCommon() (string, error) {
 		}
 
 		if idmap != nil {
-			err = idmap.ShiftRootfs(c.RootfsPath())
+			err = idmap.ShiftRootfs(c.RootfsPath(), idmapShiftSkipper(excludes))
 			if err != nil {
 				if ourStart {
 					c.StorageStop()
@@ -1747,6 +1831,16 @@ func (c *containerLXC) startCommon() (string, error) {
 		return "", err
 	}
 
+	jsonExcludes, err := idmapShiftExcludesToJSON(excludes)
+	if err != nil {
+		return "", err
+	}
+
+	err = c.ConfigKeySet("volatile.last_state.idmap_shift_exclude", jsonExcludes)
+	if err != nil {
+		return "", err
+	}
+
 	// Generate the Seccomp profile
 	if err := SeccompCreateProfile(c); err != nil {
 		return "", err
@@ -1980,9 +2074,17 @@ func (c *containerLXC) startCommon() (string, error) {
 	return configPath, nil
 }
 
-func (c *containerLXC) Start(stateful bool) error {
+func (c *containerLXC) Start(stateful bool) (err error) {
 	var ctxMap log.Ctx
 
+	// Don't leave anyone waiting for the container to be ready if it
+	// failed to start
+	defer func() {
+		if err != nil {
+			containerReadyCancel(c.Name())
+		}
+	}()
+
 	// Setup a new operation
 	op, err := c.createOperation("start", false, false)
 	if err != nil {
@@ -2022,7 +2124,7 @@ func (c *containerLXC) Start(stateful bool) error {
 			return fmt.Errorf("Container has no existing state to restore.")
 		}
 
-		err := c.Migrate(lxc.MIGRATE_RESTORE, c.StatePath(), "snapshot", false, false)
+		err := c.Migrate(lxc.MIGRATE_RESTORE, c.StatePath(), "snapshot", false, false, "")
 		if err != nil && !c.IsRunning() {
 			return err
 		}
@@ -2104,6 +2206,10 @@ func (c *containerLXC) Start(stateful bool) error {
 
 	logger.Info("Started container", ctxMap)
 
+	if containerAgentEnabled(c) {
+		go containerAgentStart(c)
+	}
+
 	return nil
 }
 
@@ -2139,8 +2245,8 @@ func (c *containerLXC) OnStart() error {
 			return err
 		}
 
-		// Remove the volatile key from the DB
-		err := dbContainerConfigRemove(c.daemon.db, c.id, key)
+		// Remove the volatile key
+		err := c.volatileSet(key, "")
 		if err != nil {
 			AADestroy(c)
 			if ourStart {
@@ -2194,7 +2300,7 @@ func (c *containerLXC) OnStart() error {
 	}
 
 	// Record current state
-	err = dbContainerSetState(c.daemon.db, c.id, "RUNNING")
+	err = c.volatileSet("volatile.last_state.power", "RUNNING")
 	if err != nil {
 		return err
 	}
@@ -2240,7 +2346,7 @@ func (c *containerLXC) Stop(stateful bool) error {
 		}
 
 		// Checkpoint
-		err = c.Migrate(lxc.MIGRATE_DUMP, stateDir, "snapshot", true, false)
+		err = c.Migrate(lxc.MIGRATE_DUMP, stateDir, "snapshot", true, false, "")
 		if err != nil {
 			op.Done(err)
 			logger.Error("Failed stopping container", ctxMap)
@@ -2328,6 +2434,21 @@ func (c *containerLXC) Shutdown(timeout time.Duration) error {
 		return err
 	}
 
+	// Send the halt signal the init system of the container expects,
+	// unless one was set through raw.lxc.
+	rawLxc := c.expandedConfig["raw.lxc"]
+	if !strings.Contains(rawLxc, "lxc.signal.halt") && !strings.Contains(rawLxc, "lxc.haltsignal") {
+		system := containerInitSystemDetect(c)
+		ctxMap["init"] = system
+
+		err = lxcSetConfigItem(c.c, "lxc.signal.halt", containerInitSystemGet(system).signal)
+		if err != nil {
+			op.Done(err)
+			logger.Error("Failed shutting down container", ctxMap)
+			return err
+		}
+	}
+
 	if err := c.c.Shutdown(timeout); err != nil {
 		op.Done(err)
 		logger.Error("Failed shutting down container", ctxMap)
@@ -2419,6 +2540,18 @@ func (c *containerLXC) OnStop(target string) error {
 			logger.Error("Unable to remove network filters", log.Ctx{"container": c.Name(), "err": err})
 		}
 
+		// The container isn't ready anymore
+		err = containerReadyReset(c.daemon, c.id, c.localConfig)
+		if err != nil {
+			logger.Error("Unable to reset the ready state", log.Ctx{"container": c.Name(), "err": err})
+		}
+
+		// Those waiting for it to be ready would wait in vain, unless
+		// it's coming back up
+		if target != "reboot" {
+			containerReadyCancel(c.Name())
+		}
+
 		// Reboot the container
 		if target == "reboot" {
 			// Start the container again
@@ -2430,7 +2563,7 @@ func (c *containerLXC) OnStop(target string) error {
 		deviceTaskSchedulerTrigger("container", c.name, "stopped")
 
 		// Record current state
-		err = dbContainerSetState(c.daemon.db, c.id, "STOPPED")
+		err = c.volatileSet("volatile.last_state.power", "STOPPED")
 		if err != nil {
 			logger.Error("Failed to set container state", log.Ctx{"container": c.Name(), "err": err})
 		}
@@ -2552,7 +2685,7 @@ func (c *containerLXC) Render() (interface{}, interface{}, error) {
 	architectureName, _ := osarch.ArchitectureName(c.architecture)
 
 	// Prepare the ETag
-	etag := []interface{}{c.architecture, c.localConfig, c.localDevices, c.ephemeral, c.profiles}
+	etag := []interface{}{c.architecture, c.localConfig, c.localDevices, c.ephemeral, c.profiles, c.labels}
 
 	if c.IsSnapshot() {
 		return &api.ContainerSnapshot{
@@ -2564,6 +2697,7 @@ func (c *containerLXC) Render() (interface{}, interface{}, error) {
 			ExpandedConfig:  c.expandedConfig,
 			ExpandedDevices: c.expandedDevices,
 			LastUsedDate:    c.lastUsedDate,
+			ExpiresAt:       c.expiryDate,
 			Name:            c.name,
 			Profiles:        c.profiles,
 			Stateful:        c.stateful,
@@ -2585,6 +2719,7 @@ func (c *containerLXC) Render() (interface{}, interface{}, error) {
 		}
 
 		ct.Description = c.Description()
+		ct.Labels = c.Labels()
 		ct.Architecture = architectureName
 		ct.Config = c.localConfig
 		ct.CreatedAt = c.creationDate
@@ -2623,6 +2758,8 @@ func (c *containerLXC) RenderState() (*api.ContainerState, error) {
 		status.Network = c.networkState()
 		status.Pid = int64(pid)
 		status.Processes = c.processesState()
+		status.Ready = containerIsReady(c)
+		status.Init = containerInitSystemDetect(c)
 	}
 
 	return &status, nil
@@ -2649,7 +2786,7 @@ func (c *containerLXC) Snapshots() ([]container, error) {
 	return containers, nil
 }
 
-func (c *containerLXC) Restore(sourceContainer container) error {
+func (c *containerLXC) Restore(sourceContainer container, stateful bool) error {
 	var ctxMap log.Ctx
 
 	// Initialize storage interface for the container.
@@ -2675,7 +2812,11 @@ func (c *containerLXC) Restore(sourceContainer container) error {
 	/* let's also check for CRIU if necessary, before doing a bunch of
 	 * filesystem manipulations
 	 */
-	if shared.PathExists(c.StatePath()) {
+	if stateful {
+		if !sourceContainer.IsStateful() {
+			return fmt.Errorf("The snapshot \"%s\" doesn't have any running state to restore", sourceContainer.Name())
+		}
+
 		_, err := exec.LookPath("criu")
 		if err != nil {
 			return fmt.Errorf("Failed to restore container state. CRIU isn't installed.")
@@ -2725,6 +2866,7 @@ func (c *containerLXC) Restore(sourceContainer container) error {
 		Devices:      sourceContainer.LocalDevices(),
 		Ephemeral:    sourceContainer.IsEphemeral(),
 		Profiles:     sourceContainer.Profiles(),
+		UpdateSource: containerUpdateSourceRestore,
 	}
 
 	err = c.Update(args, false)
@@ -2741,11 +2883,20 @@ func (c *containerLXC) Restore(sourceContainer container) error {
 		return err
 	}
 
-	// If the container wasn't running but was stateful, should we restore
-	// it as running?
-	if shared.PathExists(c.StatePath()) {
+	// The running state stored with the snapshot only gets restored when
+	// asked for, the container being started afresh otherwise.
+	if !stateful && shared.PathExists(c.StatePath()) {
+		err := os.RemoveAll(c.StatePath())
+		if err != nil {
+			logger.Error("Failed to delete snapshot state", log.Ctx{"path": c.StatePath(), "err": err})
+		}
+	}
+
+	// Resume the container from the running state stored with the
+	// snapshot.
+	if stateful && shared.PathExists(c.StatePath()) {
 		logger.Debug("Performing stateful restore", ctxMap)
-		err := c.Migrate(lxc.MIGRATE_RESTORE, c.StatePath(), "snapshot", false, false)
+		err := c.Migrate(lxc.MIGRATE_RESTORE, c.StatePath(), "snapshot", false, false, "")
 		if err != nil {
 			return err
 		}
@@ -2805,6 +2956,9 @@ func (c *containerLXC) Delete() error {
 
 	// Attempt to initialize storage interface for the container.
 	c.initStorage()
+	if c.storage != nil {
+		c.storage.StorageAuditSet(c.audit)
+	}
 
 	if c.IsSnapshot() {
 		// Remove the snapshot
@@ -2816,7 +2970,7 @@ func (c *containerLXC) Delete() error {
 		}
 	} else {
 		// Remove all snapshot
-		if err := containerDeleteSnapshots(c.daemon, c.Name()); err != nil {
+		if err := containerDeleteSnapshots(c.daemon, c.Name(), c.audit); err != nil {
 			logger.Warn("Failed to delete snapshots", log.Ctx{"name": c.Name(), "err": err})
 			return err
 		}
@@ -2831,9 +2985,14 @@ func (c *containerLXC) Delete() error {
 				return err
 			}
 		}
+
+		// Remove the backups, their database records go with the
+		// container's.
+		os.RemoveAll(shared.VarPath("backups", c.Name()))
 	}
 
 	// Remove the database record
+	c.daemon.volatile.Forget(c.id)
 	if err := dbContainerRemove(c.daemon.db, c.Name()); err != nil {
 		logger.Error("Failed deleting container entry", log.Ctx{"name": c.Name(), "err": err})
 		return err
@@ -2911,6 +3070,15 @@ func (c *containerLXC) Rename(newName string) error {
 		}
 	}
 
+	// Rename the backups path
+	if !c.IsSnapshot() && shared.PathExists(shared.VarPath("backups", oldName)) {
+		err := os.Rename(shared.VarPath("backups", oldName), shared.VarPath("backups", newName))
+		if err != nil {
+			logger.Error("Failed renaming container", ctxMap)
+			return err
+		}
+	}
+
 	// Rename the storage entry
 	if c.IsSnapshot() {
 		err := c.storage.ContainerSnapshotRename(c, newName)
@@ -3020,6 +3188,12 @@ func (c *containerLXC) CGroupSet(key string, value string) error {
 }
 
 func (c *containerLXC) ConfigKeySet(key string, value string) error {
+	// Volatile keys are only ever set by LXD itself, so they skip the
+	// validation and go through the volatile store.
+	if strings.HasPrefix(key, "volatile.") {
+		return c.volatileSet(key, value)
+	}
+
 	c.localConfig[key] = value
 
 	args := containerArgs{
@@ -3033,6 +3207,29 @@ func (c *containerLXC) ConfigKeySet(key string, value string) error {
 	return c.Update(args, false)
 }
 
+// volatileSet sets or, when value is empty, removes a volatile key.
+func (c *containerLXC) volatileSet(key string, value string) error {
+	if value == "" {
+		err := c.daemon.volatile.Remove(c.id, key)
+		if err != nil {
+			return err
+		}
+
+		delete(c.localConfig, key)
+		delete(c.expandedConfig, key)
+		return nil
+	}
+
+	err := c.daemon.volatile.Set(c.id, key, value)
+	if err != nil {
+		return err
+	}
+
+	c.localConfig[key] = value
+	c.expandedConfig[key] = value
+	return nil
+}
+
 type backupFile struct {
 	Container *api.Container           `yaml:"container"`
 	Snapshots []*api.ContainerSnapshot `yaml:"snapshots"`
@@ -3153,6 +3350,12 @@ func (c *containerLXC) Update(args containerArgs, userRequested bool) error {
 		return err
 	}
 
+	// Validate the new labels
+	err = shared.ValidLabels(args.Labels)
+	if err != nil {
+		return err
+	}
+
 	// Validate the new profiles
 	profiles, err := dbProfiles(c.daemon.db)
 	if err != nil {
@@ -3171,6 +3374,13 @@ func (c *containerLXC) Update(args containerArgs, userRequested bool) error {
 		if err != nil {
 			return fmt.Errorf("Invalid architecture id: %s", err)
 		}
+
+		if args.Architecture != c.architecture {
+			err = containerValidArchitecture(c.daemon, args.Architecture)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	// Check that volatile and image keys weren't modified
@@ -3198,6 +3408,7 @@ func (c *containerLXC) Update(args containerArgs, userRequested bool) error {
 
 	// Get a copy of the old configuration
 	oldDescription := c.Description()
+	oldLabels := c.Labels()
 	oldArchitecture := 0
 	err = shared.DeepCopy(&c.architecture, &oldArchitecture)
 	if err != nil {
@@ -3248,6 +3459,7 @@ func (c *containerLXC) Update(args containerArgs, userRequested bool) error {
 	defer func() {
 		if undoChanges {
 			c.description = oldDescription
+			c.labels = oldLabels
 			c.architecture = oldArchitecture
 			c.ephemeral = oldEphemeral
 			c.expandedConfig = oldExpandedConfig
@@ -3261,8 +3473,11 @@ func (c *containerLXC) Update(args containerArgs, userRequested bool) error {
 		}
 	}()
 
-	// Apply the various changes
+	// Apply the various changes, keeping the labels if none were passed
 	c.description = args.Description
+	if args.Labels != nil {
+		c.labels = args.Labels
+	}
 	c.architecture = args.Architecture
 	c.ephemeral = args.Ephemeral
 	c.localConfig = args.Config
@@ -3307,12 +3522,26 @@ func (c *containerLXC) Update(args containerArgs, userRequested bool) error {
 		return err
 	}
 
+	// Only check the privileges when they change, core.unprivileged_only
+	// mustn't prevent updating existing privileged containers.
+	if shared.StringInSlice("security.privileged", changedConfig) || shared.StringInSlice("image.requirements.privileged", changedConfig) {
+		err = containerValidPrivileges(c.expandedConfig)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Do some validation of the devices diff
 	err = containerValidDevices(c.daemon, c.expandedDevices, false, true)
 	if err != nil {
 		return err
 	}
 
+	err = storagePoolVolumeAttachCheck(c.daemon, c.name, c.expandedDevices)
+	if err != nil {
+		return err
+	}
+
 	// Run through initLXC to catch anything we missed
 	c.c = nil
 	err = c.initLXC()
@@ -3464,12 +3693,9 @@ func (c *containerLXC) Update(args containerArgs, userRequested bool) error {
 					return err
 				}
 			} else if key == "linux.kernel_modules" && value != "" {
-				for _, module := range strings.Split(value, ",") {
-					module = strings.TrimPrefix(module, " ")
-					err := loadModule(module)
-					if err != nil {
-						return fmt.Errorf("Failed to load kernel module '%s': %s", module, err)
-					}
+				err := kernelModulesLoad(value)
+				if err != nil {
+					return err
 				}
 			} else if key == "limits.disk.priority" {
 				if !cgBlkioController {
@@ -4014,6 +4240,12 @@ func (c *containerLXC) Update(args containerArgs, userRequested bool) error {
 		return err
 	}
 
+	err = dbContainerLabelsSet(tx, c.id, c.labels)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	if err := txCommit(tx); err != nil {
 		return err
 	}
@@ -4045,6 +4277,8 @@ func (c *containerLXC) Update(args containerArgs, userRequested bool) error {
 	// Success, update the closure to mark that the changes should be kept.
 	undoChanges = false
 
+	containerChangesEvent(c, args.UpdateSource, oldExpandedConfig, oldExpandedDevices, oldProfiles)
+
 	return nil
 }
 
@@ -4078,12 +4312,13 @@ func (c *containerLXC) Export(w io.Writer, properties map[string]string) error {
 	}
 
 	if idmap != nil {
-		if err := idmap.UnshiftRootfs(c.RootfsPath()); err != nil {
+		skipper := idmapShiftSkipper(idmapShiftLastExcludes(c.expandedConfig))
+		if err := idmap.UnshiftRootfs(c.RootfsPath(), skipper); err != nil {
 			logger.Error("Failed exporting container", ctxMap)
 			return err
 		}
 
-		defer idmap.ShiftRootfs(c.RootfsPath())
+		defer idmap.ShiftRootfs(c.RootfsPath(), skipper)
 	}
 
 	// Create the tarball
@@ -4303,13 +4538,17 @@ func getCRIULogErrors(imagesDir string, method string) (string, error) {
 	return strings.Join(ret, "\n"), nil
 }
 
-func (c *containerLXC) Migrate(cmd uint, stateDir string, function string, stop bool, actionScript bool) error {
+// Migrate runs a CRIU command on the container with its images in stateDir.
+// preDumpDir is the name of the pre-dump, next to stateDir, a dump or
+// pre-dump builds on, only writing the memory changed since it.
+func (c *containerLXC) Migrate(cmd uint, stateDir string, function string, stop bool, actionScript bool, preDumpDir string) error {
 	ctxMap := log.Ctx{"name": c.name,
 		"created":      c.creationDate,
 		"ephemeral":    c.ephemeral,
 		"used":         c.lastUsedDate,
 		"statedir":     stateDir,
 		"actionscript": actionScript,
+		"predumpdir":   preDumpDir,
 		"stop":         stop}
 
 	_, err := exec.LookPath("criu")
@@ -4376,7 +4615,14 @@ func (c *containerLXC) Migrate(cmd uint, stateDir string, function string, stop
 				return err
 			}
 
-			err = idmapset.ShiftRootfs(stateDir)
+			// Images dumped on pre-dumps point to them
+			// through a "parent" link, shift them all.
+			shiftDir := stateDir
+			if shared.PathExists(filepath.Join(stateDir, "parent")) {
+				shiftDir = filepath.Dir(stateDir)
+			}
+
+			err = idmapset.ShiftRootfs(shiftDir, nil)
 			if ourStart {
 				_, err2 := c.StorageStop()
 				if err != nil {
@@ -4435,6 +4681,11 @@ func (c *containerLXC) Migrate(cmd uint, stateDir string, function string, stop
 			GhostLimit:      ghostLimit,
 		}
 
+		// CRIU takes the previous images relative to the new ones.
+		if preDumpDir != "" {
+			opts.PredumpDir = filepath.Join("..", preDumpDir)
+		}
+
 		migrateErr = c.c.Migrate(cmd, opts)
 	}
 
@@ -4600,14 +4851,20 @@ func (c *containerLXC) templateApplyNow(trigger string) error {
 			return pongo2.AsValue(strings.TrimRight(val, "\r\n"))
 		}
 
+		cloudInit := cloudInitData(c.name, c.expandedConfig)
+		cloudInitGet := func(key *pongo2.Value) *pongo2.Value {
+			return pongo2.AsValue(cloudInit[key.String()])
+		}
+
 		// Render the template
 		tpl.ExecuteWriter(pongo2.Context{"trigger": trigger,
-			"path":       templatePath,
-			"container":  containerMeta,
-			"config":     c.expandedConfig,
-			"devices":    c.expandedDevices,
-			"properties": template.Properties,
-			"config_get": configGet}, w)
+			"path":           templatePath,
+			"container":      containerMeta,
+			"config":         c.expandedConfig,
+			"devices":        c.expandedDevices,
+			"properties":     template.Properties,
+			"config_get":     configGet,
+			"cloud_init_get": cloudInitGet}, w)
 	}
 
 	return nil
@@ -4660,6 +4917,10 @@ func (c *containerLXC) FileExists(path string) error {
 }
 
 func (c *containerLXC) FilePull(srcpath string, dstpath string) (int64, int64, os.FileMode, string, []string, error) {
+	if containerAgentAvailable(c) {
+		return containerAgentFilePull(c, srcpath, dstpath)
+	}
+
 	var ourStart bool
 	var err error
 	// Setup container storage if needed
@@ -4781,6 +5042,10 @@ func (c *containerLXC) FilePull(srcpath string, dstpath string) (int64, int64, o
 }
 
 func (c *containerLXC) FilePush(type_ string, srcpath string, dstpath string, uid int64, gid int64, mode int, write string) error {
+	if containerAgentAvailable(c) {
+		return containerAgentFilePush(c, type_, srcpath, dstpath, uid, gid, mode, write)
+	}
+
 	var rootUid int64
 	var rootGid int64
 	var errStr string
@@ -4928,6 +5193,30 @@ func (c *containerLXC) FileRemove(path string) error {
 }
 
 func (c *containerLXC) Exec(command []string, env map[string]string, stdin *os.File, stdout *os.File, stderr *os.File, wait bool) (*exec.Cmd, int, int, error) {
+	// Commands which are waited for can go through the guest agent, the
+	// others need a process to signal.
+	if wait && containerAgentAvailable(c) {
+		var agentStdin io.Reader
+		var agentStdout io.Writer
+		var agentStderr io.Writer
+		if stdin != nil {
+			agentStdin = stdin
+		}
+		if stdout != nil {
+			agentStdout = stdout
+		}
+		if stderr != nil {
+			agentStderr = stderr
+		}
+
+		ret, err := containerAgentExec(c, command, env, agentStdin, agentStdout, agentStderr)
+		if err != nil {
+			return nil, -1, -1, err
+		}
+
+		return nil, ret, -1, nil
+	}
+
 	envSlice := []string{}
 
 	for k, v := range env {
@@ -5026,27 +5315,80 @@ func (c *containerLXC) diskState() map[string]api.ContainerStateDisk {
 		return disk
 	}
 
+	blockIO := c.blockIOState()
+
 	for _, name := range c.expandedDevices.DeviceNames() {
 		d := c.expandedDevices[name]
 		if d["type"] != "disk" {
 			continue
 		}
 
-		if d["path"] != "/" {
-			continue
+		state := api.ContainerStateDisk{}
+		found := false
+
+		if d["path"] == "/" {
+			usage, err := c.storage.ContainerGetUsage(c)
+			if err == nil {
+				state.Usage = usage
+				state.Alert = containerDiskAlertCrossed(c, d, usage)
+				found = true
+			}
 		}
 
-		usage, err := c.storage.ContainerGetUsage(c)
-		if err != nil {
-			continue
+		// Devices sharing block devices, like those on the same storage
+		// pool, report the same I/O.
+		blocks, _ := c.getDiskBlocks(d)
+		counted := []string{}
+		for _, block := range blocks {
+			counters, ok := blockIO[block]
+			if !ok {
+				// The I/O on partitions is accounted to their disk.
+				block = fmt.Sprintf("%s:0", strings.SplitN(block, ":", 2)[0])
+				counters, ok = blockIO[block]
+			}
+
+			if !ok || shared.StringInSlice(block, counted) {
+				continue
+			}
+			counted = append(counted, block)
+			found = true
+
+			state.ReadBytes += counters.readBytes
+			state.WriteBytes += counters.writeBytes
+			state.ReadOps += counters.readOps
+			state.WriteOps += counters.writeOps
 		}
 
-		disk[name] = api.ContainerStateDisk{Usage: usage}
+		if found {
+			disk[name] = state
+		}
 	}
 
 	return disk
 }
 
+// blockIOState returns the I/O done by the container on each block device,
+// keyed by "major:minor".
+func (c *containerLXC) blockIOState() map[string]deviceBlockIO {
+	if cgBlkioController {
+		serviceBytes, err := c.CGroupGet("blkio.throttle.io_service_bytes")
+		serviced, err1 := c.CGroupGet("blkio.throttle.io_serviced")
+		if err == nil && err1 == nil {
+			return deviceParseBlkioStats(serviceBytes, serviced)
+		}
+
+		return map[string]deviceBlockIO{}
+	}
+
+	// The unified hierarchy has io.stat instead.
+	stat, err := c.CGroupGet("io.stat")
+	if err != nil {
+		return map[string]deviceBlockIO{}
+	}
+
+	return deviceParseIoStat(stat)
+}
+
 func (c *containerLXC) memoryState() api.ContainerStateMemory {
 	memory := api.ContainerStateMemory{}
 
@@ -5261,6 +5603,10 @@ func (c *containerLXC) Storage() storage {
 	return c.storage
 }
 
+func (c *containerLXC) StorageAuditSet(audit *storageAudit) {
+	c.audit = audit
+}
+
 func (c *containerLXC) StorageStart() (bool, error) {
 	// Initialize storage interface for the container.
 	err := c.initStorage()
@@ -5927,26 +6273,6 @@ func (c *containerLXC) fillNetworkDevice(name string, m types.Device) (types.Dev
 		}
 	}
 
-	updateKey := func(key string, value string) error {
-		tx, err := dbBegin(c.daemon.db)
-		if err != nil {
-			return err
-		}
-
-		err = dbContainerConfigInsert(tx, c.id, map[string]string{key: value})
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-
-		err = txCommit(tx)
-		if err != nil {
-			return err
-		}
-
-		return nil
-	}
-
 	// Fill in the MAC address
 	if m["nictype"] != "physical" && m["hwaddr"] == "" {
 		configKey := fmt.Sprintf("volatile.%s.hwaddr", name)
@@ -5958,21 +6284,14 @@ func (c *containerLXC) fillNetworkDevice(name string, m types.Device) (types.Dev
 				return nil, err
 			}
 
-			// Update the database
-			err = updateKey(configKey, volatileHwaddr)
+			// Record it, unless something else filled it in behind our back
+			volatileHwaddr, err = c.daemon.volatile.SetDefault(c.id, configKey, volatileHwaddr)
 			if err != nil {
-				// Check if something else filled it in behind our back
-				value, err1 := dbContainerConfigGet(c.daemon.db, c.id, configKey)
-				if err1 != nil || value == "" {
-					return nil, err
-				}
-
-				c.localConfig[configKey] = value
-				c.expandedConfig[configKey] = value
-			} else {
-				c.localConfig[configKey] = volatileHwaddr
-				c.expandedConfig[configKey] = volatileHwaddr
+				return nil, err
 			}
+
+			c.localConfig[configKey] = volatileHwaddr
+			c.expandedConfig[configKey] = volatileHwaddr
 		}
 		newDevice["hwaddr"] = volatileHwaddr
 	}
@@ -5988,21 +6307,14 @@ func (c *containerLXC) fillNetworkDevice(name string, m types.Device) (types.Dev
 				return nil, err
 			}
 
-			// Update the database
-			err = updateKey(configKey, volatileName)
+			// Record it, unless something else filled it in behind our back
+			volatileName, err = c.daemon.volatile.SetDefault(c.id, configKey, volatileName)
 			if err != nil {
-				// Check if something else filled it in behind our back
-				value, err1 := dbContainerConfigGet(c.daemon.db, c.id, configKey)
-				if err1 != nil || value == "" {
-					return nil, err
-				}
-
-				c.localConfig[configKey] = value
-				c.expandedConfig[configKey] = value
-			} else {
-				c.localConfig[configKey] = volatileName
-				c.expandedConfig[configKey] = volatileName
+				return nil, err
 			}
+
+			c.localConfig[configKey] = volatileName
+			c.expandedConfig[configKey] = volatileName
 		}
 		newDevice["name"] = volatileName
 	}
@@ -6185,7 +6497,13 @@ func (c *containerLXC) createDiskDevice(name string, m types.Device) (string, er
 	isRecursive := shared.IsTrue(m["recursive"])
 
 	isFile := false
-	if m["pool"] == "" {
+	if shared.IsTrue(m["scratch"]) {
+		var err error
+		srcPath, err = c.scratchVolumeCreate(name, m)
+		if err != nil {
+			return "", fmt.Errorf("Failed to create the scratch volume of device \"%s\" on storage pool \"%s\": %s", name, m["pool"], err)
+		}
+	} else if m["pool"] == "" {
 		isFile = !shared.IsDir(srcPath) && !deviceIsBlockdev(srcPath)
 	} else {
 		// Deal with mounting storage volumes created via the storage
@@ -6226,28 +6544,20 @@ func (c *containerLXC) createDiskDevice(name string, m types.Device) (string, er
 			return "", fmt.Errorf("Unknown storage type prefix \"%s\" found.", volumeTypeName)
 		}
 
-		// Initialize a new storage interface and check if the
-		// pool/volume is mounted. If it is not, mount it.
+		// Initialize a new storage interface, which mounts the
+		// pool/volume unless it already is.
 		volumeType, _ := storagePoolVolumeTypeNameToType(volumeTypeName)
-		s, err := storagePoolVolumeAttachInit(c.daemon, m["pool"], volumeName, volumeType, c)
+		_, err := storagePoolVolumeAttachInit(c.daemon, m["pool"], volumeName, volumeType, c)
 		if err != nil && !isOptional {
 			return "", fmt.Errorf("Failed to initialize storage volume \"%s\" of type \"%s\" on storage pool \"%s\": %s.",
 				volumeName,
 				volumeTypeName,
 				m["pool"], err)
-		} else if err == nil {
-			_, err = s.StoragePoolVolumeMount()
-			if err != nil {
-				msg := fmt.Sprintf("Could not mount storage volume \"%s\" of type \"%s\" on storage pool \"%s\": %s.",
-					volumeName,
-					volumeTypeName,
-					m["pool"], err)
-				if !isOptional {
-					logger.Errorf(msg)
-					return "", err
-				}
-				logger.Warnf(msg)
-			}
+		} else if err != nil {
+			logger.Warnf("Could not mount storage volume \"%s\" of type \"%s\" on storage pool \"%s\": %s.",
+				volumeName,
+				volumeTypeName,
+				m["pool"], err)
 		}
 	}
 
@@ -6397,9 +6707,91 @@ func (c *containerLXC) removeDiskDevice(name string, m types.Device) error {
 		return err
 	}
 
+	// Release the storage volume
+	c.storagePoolVolumeDetach(m)
+	c.scratchVolumeDelete(name, m)
+
 	return nil
 }
 
+// scratchVolumeCreate creates the scratch volume of a disk device on its
+// storage pool, owned by the root user of the container, and returns where
+// it's mounted on the host.
+func (c *containerLXC) scratchVolumeCreate(name string, m types.Device) (string, error) {
+	size := int64(0)
+	if m["size"] != "" {
+		var err error
+		size, err = shared.ParseByteSizeString(m["size"])
+		if err != nil {
+			return "", err
+		}
+	}
+
+	s, err := storagePoolInit(c.daemon, m["pool"])
+	if err != nil {
+		return "", err
+	}
+
+	path, err := s.ScratchVolumeCreate(c, name, size)
+	if err != nil {
+		return "", err
+	}
+
+	if !c.IsPrivileged() {
+		idmapset, err := c.IdmapSet()
+		if err != nil {
+			return "", err
+		}
+
+		if idmapset != nil {
+			uid, gid := idmapset.ShiftIntoNs(0, 0)
+			err = os.Chown(path, int(uid), int(gid))
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return path, nil
+}
+
+// scratchVolumeDelete destroys the scratch volume of a disk device, if it's
+// one of those.
+func (c *containerLXC) scratchVolumeDelete(name string, m types.Device) {
+	if m["type"] != "disk" || !shared.IsTrue(m["scratch"]) {
+		return
+	}
+
+	s, err := storagePoolInit(c.daemon, m["pool"])
+	if err == nil {
+		err = s.ScratchVolumeDelete(c, name)
+	}
+
+	if err != nil {
+		logger.Warn("Failed to delete scratch volume", log.Ctx{"container": c.Name(), "device": name, "pool": m["pool"], "err": err})
+	}
+}
+
+// storagePoolVolumeDetach releases the reference held by a disk device on its
+// custom storage volume, unmounting it if no other container uses it.
+func (c *containerLXC) storagePoolVolumeDetach(m types.Device) {
+	volumeName, ok := storagePoolVolumeDeviceName(m)
+	if !ok {
+		return
+	}
+
+	s, err := storagePoolVolumeInit(c.daemon, m["pool"], volumeName, storagePoolVolumeTypeCustom)
+	if err != nil {
+		logger.Warn("Failed to initialize storage volume", log.Ctx{"container": c.Name(), "pool": m["pool"], "volume": volumeName, "err": err})
+		return
+	}
+
+	_, err = s.StoragePoolVolumeUmount()
+	if err != nil {
+		logger.Warn("Failed to unmount storage volume", log.Ctx{"container": c.Name(), "pool": m["pool"], "volume": volumeName, "err": err})
+	}
+}
+
 func (c *containerLXC) removeDiskDevices() error {
 	// Check that we indeed have devices to remove
 	if !shared.PathExists(c.DevicesPath()) {
@@ -6412,6 +6804,17 @@ func (c *containerLXC) removeDiskDevices() error {
 		return err
 	}
 
+	// Map the host side entries back to the storage volumes
+	volumes := map[string]string{}
+	for name, m := range c.expandedDevices {
+		if m["type"] != "disk" || m["pool"] == "" {
+			continue
+		}
+
+		tgtPath := strings.TrimPrefix(m["path"], "/")
+		volumes[fmt.Sprintf("disk.%s", strings.Replace(tgtPath, "/", "-", -1))] = name
+	}
+
 	// Go through all the unix devices
 	for _, f := range dents {
 		// Skip non-Unix devices
@@ -6427,6 +6830,14 @@ func (c *containerLXC) removeDiskDevices() error {
 		err := os.Remove(diskPath)
 		if err != nil {
 			logger.Error("Failed to remove disk device path", log.Ctx{"err": err, "path": diskPath})
+			continue
+		}
+
+		// Release the storage volume
+		name, ok := volumes[f.Name()]
+		if ok {
+			c.storagePoolVolumeDetach(c.expandedDevices[name])
+			c.scratchVolumeDelete(name, c.expandedDevices[name])
 		}
 	}
 
@@ -6434,6 +6845,46 @@ func (c *containerLXC) removeDiskDevices() error {
 }
 
 // Block I/O limits
+// getDiskBlocks returns the block devices (major:minor) backing a disk device.
+func (c *containerLXC) getDiskBlocks(m types.Device) ([]string, error) {
+	source := m["source"]
+
+	if m["pool"] != "" {
+		_, pool, err := dbStoragePoolGet(c.daemon.db, m["pool"])
+		if err != nil {
+			return nil, err
+		}
+
+		// ZFS datasets don't sit on a block device of their own, use the
+		// vdevs of the zpool instead. Those are known whether or not the
+		// dataset is currently mounted.
+		if pool.Driver == "zfs" {
+			zpool := pool.Config["zfs.pool_name"]
+			if zpool == "" {
+				zpool = pool.Name
+			}
+
+			return zfsPoolVdevBlocks(strings.SplitN(zpool, "/", 2)[0])
+		}
+
+		if m["path"] != "/" {
+			source = getStoragePoolVolumeMountPoint(m["pool"], source)
+		}
+	}
+
+	// Set the source path
+	if source == "" {
+		source = c.RootfsPath()
+	}
+
+	// Don't try to resolve the block device behind a non-existing path
+	if !shared.PathExists(source) {
+		return nil, nil
+	}
+
+	return deviceGetParentBlocks(source)
+}
+
 func (c *containerLXC) getDiskLimits() (map[string]deviceBlockLimit, error) {
 	result := map[string]deviceBlockLimit{}
 
@@ -6483,19 +6934,8 @@ func (c *containerLXC) getDiskLimits() (map[string]deviceBlockLimit, error) {
 			return nil, err
 		}
 
-		// Set the source path
-		source := m["source"]
-		if source == "" {
-			source = c.RootfsPath()
-		}
-
-		// Don't try to resolve the block device behind a non-existing path
-		if !shared.PathExists(source) {
-			continue
-		}
-
 		// Get the backing block devices (major:minor)
-		blocks, err := deviceGetParentBlocks(source)
+		blocks, err := c.getDiskBlocks(m)
 		if err != nil {
 			if readBps == 0 && readIops == 0 && writeBps == 0 && writeIops == 0 {
 				// If the device doesn't exist, there is no limit to clear so ignore the failure
@@ -6802,6 +7242,9 @@ func (c *containerLXC) CreationDate() time.Time {
 func (c *containerLXC) LastUsedDate() time.Time {
 	return c.lastUsedDate
 }
+func (c *containerLXC) ExpiryDate() time.Time {
+	return c.expiryDate
+}
 func (c *containerLXC) ExpandedConfig() map[string]string {
 	return c.expandedConfig
 }
@@ -6890,6 +7333,10 @@ func (c *containerLXC) Description() string {
 	return c.description
 }
 
+func (c *containerLXC) Labels() map[string]string {
+	return c.labels
+}
+
 func (c *containerLXC) Profiles() []string {
 	return c.profiles
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pborman/uuid"
+
+	"github.com/lxc/lxd/lxd/types"
+	"github.com/lxc/lxd/shared/logger"
+)
+
+// containerDevicesWithPool returns the local devices of a container with its
+// root disk device on the given storage pool. A root disk device coming from
+// a profile is overridden by a local one.
+func containerDevicesWithPool(localDevices types.Devices, expandedDevices types.Devices, pool string) (types.Devices, error) {
+	key, root, _ := containerGetRootDiskDevice(localDevices)
+	if key == "" {
+		var err error
+		key, root, err = containerGetRootDiskDevice(expandedDevices)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	devices := types.Devices{}
+	for name, dev := range localDevices {
+		devices[name] = dev
+	}
+
+	newRoot := map[string]string{}
+	for k, v := range root {
+		newRoot[k] = v
+	}
+	newRoot["pool"] = pool
+	devices[key] = newRoot
+
+	return devices, nil
+}
+
+// containerMoveToPool moves a stopped container along with its snapshots to
+// another storage pool of this host. It's copied there under a temporary
+// name, the original is deleted and the copy takes its name.
+func containerMoveToPool(d *Daemon, c container, pool string) error {
+	if c.IsRunning() {
+		return fmt.Errorf("The container must be stopped to be moved to another storage pool")
+	}
+
+	_, err := dbStoragePoolGetID(d.db, pool)
+	if err != nil {
+		return fmt.Errorf("The \"%s\" storage pool doesn't exist", pool)
+	}
+
+	currentPool, err := c.StoragePool()
+	if err != nil {
+		return err
+	}
+
+	if currentPool == pool {
+		return fmt.Errorf("The container is already on the \"%s\" storage pool", pool)
+	}
+
+	devices, err := containerDevicesWithPool(c.LocalDevices(), c.ExpandedDevices(), pool)
+	if err != nil {
+		return err
+	}
+
+	name := c.Name()
+	args := containerArgs{
+		Architecture: c.Architecture(),
+		Config:       c.LocalConfig(),
+		Ctype:        cTypeRegular,
+		Description:  c.Description(),
+		Devices:      devices,
+		Ephemeral:    c.IsEphemeral(),
+		Labels:       c.Labels(),
+		Name:         fmt.Sprintf("move-%s", uuid.NewRandom().String()[0:8]),
+		Profiles:     c.Profiles(),
+	}
+
+	moved, err := containerCreateAsCopy(d, args, c, false)
+	if err != nil {
+		return err
+	}
+
+	err = c.Delete()
+	if err != nil {
+		moved.Delete()
+		return err
+	}
+
+	err = moved.Rename(name)
+	if err != nil {
+		logger.Errorf("Failed to rename the moved container \"%s\" back to \"%s\": %s.", moved.Name(), name, err)
+		return fmt.Errorf("The container was moved to the \"%s\" storage pool but kept the name \"%s\": %s", pool, moved.Name(), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/lxc/lxd/lxd/types"
+)
+
+func TestContainerDevicesWithPool(t *testing.T) {
+	profileRoot := types.Devices{
+		"root": {"type": "disk", "path": "/", "pool": "default"},
+	}
+
+	// The root disk device of the profile is overridden locally.
+	local := types.Devices{
+		"eth0": {"type": "nic", "nictype": "bridged", "parent": "lxdbr0"},
+	}
+
+	devices, err := containerDevicesWithPool(local, profileRoot, "fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if devices["root"]["pool"] != "fast" || devices["root"]["path"] != "/" {
+		t.Errorf("Expected a local root disk device on the fast pool, got %v", devices["root"])
+	}
+
+	if devices["eth0"] == nil {
+		t.Errorf("Expected the other local devices to be kept, got %v", devices)
+	}
+
+	if profileRoot["root"]["pool"] != "default" {
+		t.Errorf("Expected the profile devices to be left alone, got %v", profileRoot["root"])
+	}
+
+	// A local root disk device keeps its name and properties.
+	local = types.Devices{
+		"rootfs": {"type": "disk", "path": "/", "pool": "default", "size": "10GB"},
+	}
+
+	devices, err = containerDevicesWithPool(local, profileRoot, "fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if devices["rootfs"]["pool"] != "fast" || devices["rootfs"]["size"] != "10GB" || devices["root"] != nil {
+		t.Errorf("Expected the local root disk device to be moved to the fast pool, got %v", devices)
+	}
+
+	if local["rootfs"]["pool"] != "default" {
+		t.Errorf("Expected the local devices to be left alone, got %v", local["rootfs"])
+	}
+
+	// Without any root disk device.
+	_, err = containerDevicesWithPool(types.Devices{}, types.Devices{}, "fast")
+	if err == nil {
+		t.Errorf("Expected an error without a root disk device")
+	}
+}
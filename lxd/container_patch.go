@@ -98,14 +98,26 @@ func containerPatch(d *Daemon, r *http.Request) Response {
 		}
 	}
 
+	// Check if labels were passed
+	if req.Labels != nil {
+		for k, v := range c.Labels() {
+			_, ok := req.Labels[k]
+			if !ok {
+				req.Labels[k] = v
+			}
+		}
+	}
+
 	// Update container configuration
 	args := containerArgs{
 		Architecture: architecture,
 		Description:  req.Description,
+		Labels:       req.Labels,
 		Config:       req.Config,
 		Devices:      req.Devices,
 		Ephemeral:    req.Ephemeral,
-		Profiles:     req.Profiles}
+		Profiles:     req.Profiles,
+		UpdateSource: containerUpdateSourceAPI}
 
 	err = c.Update(args, false)
 	if err != nil {
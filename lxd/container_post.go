@@ -47,6 +47,15 @@ func containerPost(d *Daemon, r *http.Request) Response {
 	}
 
 	if req.Migration {
+		if dryRunRequested(r) {
+			plan, err := containerMigratePlan(d, c, stateful, req.ContainerOnly)
+			if err != nil {
+				return SmartError(err)
+			}
+
+			return SyncResponse(true, plan)
+		}
+
 		ws, err := NewMigrationSource(c, stateful, req.ContainerOnly)
 		if err != nil {
 			return InternalError(err)
@@ -79,6 +88,51 @@ func containerPost(d *Daemon, r *http.Request) Response {
 		return OperationResponse(op)
 	}
 
+	if req.Pool != "" {
+		newName := req.Name
+		if newName == "" {
+			newName = name
+		}
+
+		if newName != name {
+			id, _ := dbContainerId(d.db, newName)
+			if id > 0 {
+				return Conflict
+			}
+		}
+
+		run := func(*operation) error {
+			storageFreezeEnter()
+			defer storageFreezeLeave()
+
+			err := containerMoveToPool(d, c, req.Pool)
+			if err != nil {
+				return err
+			}
+
+			if newName == name {
+				return nil
+			}
+
+			moved, err := containerLoadByName(d, name)
+			if err != nil {
+				return err
+			}
+
+			return moved.Rename(newName)
+		}
+
+		resources := map[string][]string{}
+		resources["containers"] = []string{name}
+
+		op, err := operationCreate(operationClassTask, resources, nil, run, nil, nil)
+		if err != nil {
+			return InternalError(err)
+		}
+
+		return OperationResponse(op)
+	}
+
 	// Check that the name isn't already in use
 	id, _ := dbContainerId(d.db, req.Name)
 	if id > 0 {
@@ -53,10 +53,12 @@ func containerPut(d *Daemon, r *http.Request) Response {
 			args := containerArgs{
 				Architecture: architecture,
 				Description:  configRaw.Description,
+				Labels:       configRaw.Labels,
 				Config:       configRaw.Config,
 				Devices:      configRaw.Devices,
 				Ephemeral:    configRaw.Ephemeral,
-				Profiles:     configRaw.Profiles}
+				Profiles:     configRaw.Profiles,
+				UpdateSource: containerUpdateSourceAPI}
 
 			// FIXME: should set to true when not migrating
 			err = c.Update(args, false)
@@ -67,9 +69,31 @@ func containerPut(d *Daemon, r *http.Request) Response {
 			return nil
 		}
 	} else {
+		if dryRunRequested(r) {
+			snap := configRaw.Restore
+			if !shared.IsSnapshot(snap) {
+				snap = name + shared.SnapshotDelimiter + snap
+			}
+
+			source, err := containerLoadByName(d, snap)
+			if err != nil {
+				return SmartError(err)
+			}
+
+			plan, err := containerRestorePlan(d, c, source, configRaw.Stateful)
+			if err != nil {
+				return SmartError(err)
+			}
+
+			return SyncResponse(true, plan)
+		}
+
 		// Snapshot Restore
 		do = func(op *operation) error {
-			return containerSnapRestore(d, name, configRaw.Restore)
+			storageFreezeEnter()
+			defer storageFreezeLeave()
+
+			return containerSnapRestore(d, name, configRaw.Restore, configRaw.Stateful)
 		}
 	}
 
@@ -84,7 +108,7 @@ func containerPut(d *Daemon, r *http.Request) Response {
 	return OperationResponse(op)
 }
 
-func containerSnapRestore(d *Daemon, name string, snap string) error {
+func containerSnapRestore(d *Daemon, name string, snap string, stateful bool) error {
 	// normalize snapshot name
 	if !shared.IsSnapshot(snap) {
 		snap = name + shared.SnapshotDelimiter + snap
@@ -116,7 +140,7 @@ func containerSnapRestore(d *Daemon, name string, snap string) error {
 		}
 	}
 
-	err = c.Restore(source)
+	err = c.Restore(source, stateful)
 	if err != nil {
 		return err
 	}
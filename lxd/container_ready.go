@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+)
+
+// containerReadyKey records that the workload of a running container reported
+// itself as ready through /dev/lxd/sock.
+const containerReadyKey = "volatile.last_state.ready"
+
+// containerReadyMaxWait caps how long a request waits for a container to be
+// ready, so that waiters can't pile up forever.
+const containerReadyMaxWait = time.Hour
+
+var containerReadyLock sync.Mutex
+var containerReadyWaiters = map[string][]chan error{}
+
+// containerIsReady returns whether the container is running and reported
+// itself as ready since it was started.
+func containerIsReady(c container) bool {
+	return c.IsRunning() && shared.IsTrue(c.LocalConfig()[containerReadyKey])
+}
+
+// containerReadySet marks the container as ready and wakes up anyone waiting
+// for it.
+func containerReadySet(c container) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("The container isn't running")
+	}
+
+	if !shared.IsTrue(c.LocalConfig()[containerReadyKey]) {
+		err := c.ConfigKeySet(containerReadyKey, "true")
+		if err != nil {
+			return err
+		}
+	}
+
+	containerReadyWake(c.Name(), nil)
+
+	eventSend("container", shared.Jmap{
+		"action":    "ready",
+		"container": c.Name(),
+	})
+
+	return nil
+}
+
+// containerReadyWake wakes up anyone waiting for the container to be ready,
+// with err as the outcome of the wait.
+func containerReadyWake(name string, err error) {
+	containerReadyLock.Lock()
+	defer containerReadyLock.Unlock()
+
+	for _, ch := range containerReadyWaiters[name] {
+		ch <- err
+	}
+	delete(containerReadyWaiters, name)
+}
+
+// containerReadyCancel fails the waits for a container which stopped or
+// failed to start, as it won't report itself as ready anymore.
+func containerReadyCancel(name string) {
+	containerReadyWake(name, fmt.Errorf("The container stopped before being ready"))
+}
+
+// containerReadyReset clears the ready state of a container which is being
+// started or stopped. It only touches the volatile store so it's safe to call
+// from the container hooks.
+func containerReadyReset(d *Daemon, id int, localConfig map[string]string) error {
+	_, ok := localConfig[containerReadyKey]
+	if !ok {
+		return nil
+	}
+
+	delete(localConfig, containerReadyKey)
+	return d.volatile.Remove(id, containerReadyKey)
+}
+
+// containerWaitReady waits for up to timeout for the container to report
+// itself as ready. A negative timeout waits for as long as allowed, up to
+// containerReadyMaxWait.
+func containerWaitReady(d *Daemon, name string, timeout time.Duration) error {
+	if timeout < 0 || timeout > containerReadyMaxWait {
+		timeout = containerReadyMaxWait
+	}
+
+	// Buffered so that waking up a waiter which timed out doesn't block.
+	ch := make(chan error, 1)
+
+	containerReadyLock.Lock()
+	containerReadyWaiters[name] = append(containerReadyWaiters[name], ch)
+	containerReadyLock.Unlock()
+
+	defer func() {
+		containerReadyLock.Lock()
+		defer containerReadyLock.Unlock()
+
+		waiters := containerReadyWaiters[name]
+		for i, waiter := range waiters {
+			if waiter == ch {
+				containerReadyWaiters[name] = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+
+		if len(containerReadyWaiters[name]) == 0 {
+			delete(containerReadyWaiters, name)
+		}
+	}()
+
+	// Check after registering so that we can't miss the notification.
+	c, err := containerLoadByName(d, name)
+	if err != nil {
+		return err
+	}
+
+	if containerIsReady(c) {
+		return nil
+	}
+
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("Timeout waiting for the container to be ready")
+	}
+}
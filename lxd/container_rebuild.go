@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/osarch"
+)
+
+// /1.0/containers/{name}/rebuild
+// Re-initialize the rootfs of a stopped container from an image, keeping its
+// name, configuration, profiles and devices.
+func containerRebuildPost(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+
+	req := api.ContainerRebuildPost{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	if req.Source.Type != "" && req.Source.Type != "image" {
+		return BadRequest(fmt.Errorf("Containers can only be rebuilt from images"))
+	}
+
+	c, err := containerLoadByName(d, name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	if c.IsRunning() {
+		return BadRequest(fmt.Errorf("The container must be stopped to be rebuilt"))
+	}
+
+	hash := req.Source.Fingerprint
+	if hash == "" && req.Source.Alias != "" {
+		if req.Source.Server != "" {
+			hash = req.Source.Alias
+		} else {
+			_, alias, err := dbImageAliasGet(d.db, req.Source.Alias, true)
+			if err != nil {
+				return SmartError(err)
+			}
+
+			hash = alias.Target
+		}
+	}
+
+	// Without an image, the container is rebuilt from the one it was
+	// created from.
+	if hash == "" {
+		if req.Source.Server != "" {
+			return BadRequest(fmt.Errorf("Must specify an alias or a fingerprint to rebuild from a remote image"))
+		}
+
+		hash = c.LocalConfig()["volatile.base_image"]
+		if hash == "" {
+			return BadRequest(fmt.Errorf("Must specify an alias or a fingerprint as the container wasn't created from an image"))
+		}
+	}
+
+	run := func(op *operation) error {
+		var info *api.Image
+		if req.Source.Server != "" {
+			info, err = d.ImageDownload(
+				op, req.Source.Server, req.Source.Protocol, req.Source.Certificate, req.Source.Secret,
+				hash, true, daemonConfig["images.auto_update_cached"].GetBool(), "", true)
+			if err != nil {
+				return err
+			}
+		} else {
+			_, info, err = dbImageGet(d.db, hash, false, false)
+			if err != nil {
+				return err
+			}
+		}
+
+		return containerRebuild(d, c, info)
+	}
+
+	resources := map[string][]string{}
+	resources["containers"] = []string{name}
+
+	op, err := operationCreate(operationClassTask, resources, nil, run, nil, nil)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return OperationResponse(op)
+}
+
+// containerRebuild replaces the rootfs of a container by one created from the
+// image, updating the "image.*" keys and volatile.base_image to match it.
+func containerRebuild(d *Daemon, c container, img *api.Image) error {
+	if c.IsRunning() {
+		return fmt.Errorf("The container must be stopped to be rebuilt")
+	}
+
+	// The snapshots would be lost along with the current rootfs.
+	snapshots, err := c.Snapshots()
+	if err != nil {
+		return err
+	}
+
+	if len(snapshots) > 0 {
+		return fmt.Errorf("Containers with snapshots can't be rebuilt")
+	}
+
+	imageArchitecture, err := osarch.ArchitectureId(img.Architecture)
+	if err != nil {
+		return err
+	}
+
+	_, err = containerImageArchitecture(d, imageArchitecture, c.Architecture())
+	if err != nil {
+		return err
+	}
+
+	err = c.Storage().ContainerRebuild(c, img.Fingerprint)
+	if err != nil {
+		return err
+	}
+
+	err = dbImageLastAccessUpdate(d.db, img.Fingerprint, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("Error updating image last use date: %s", err)
+	}
+
+	config := map[string]string{}
+	for k, v := range c.LocalConfig() {
+		if strings.HasPrefix(k, "image.") {
+			continue
+		}
+
+		config[k] = v
+	}
+
+	for k, v := range img.Properties {
+		config[fmt.Sprintf("image.%s", k)] = v
+	}
+
+	config["volatile.base_image"] = img.Fingerprint
+
+	// The new rootfs was shifted to the map the container will start
+	// with, not to the one the previous rootfs had on disk.
+	idmapset, err := c.IdmapSet()
+	if err != nil {
+		return err
+	}
+
+	config["volatile.last_state.idmap"] = "[]"
+	if idmapset != nil {
+		idmapBytes, err := json.Marshal(idmapset.Idmap)
+		if err != nil {
+			return err
+		}
+
+		config["volatile.last_state.idmap"] = string(idmapBytes)
+	}
+
+	args := containerArgs{
+		Architecture: c.Architecture(),
+		Config:       config,
+		Description:  c.Description(),
+		Devices:      c.LocalDevices(),
+		Ephemeral:    c.IsEphemeral(),
+		Labels:       c.Labels(),
+		Profiles:     c.Profiles(),
+		UpdateSource: containerUpdateSourceRebuild,
+	}
+
+	err = c.Update(args, false)
+	if err != nil {
+		return err
+	}
+
+	return containerConfigureInternal(c)
+}
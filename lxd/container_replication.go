@@ -0,0 +1,384 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lxc/lxd/client"
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/logger"
+	"github.com/lxc/lxd/shared/osarch"
+	"github.com/lxc/lxd/shared/version"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// replicationScheduleInterval is how often the "replication.schedule" key of
+// the containers is checked.
+const replicationScheduleInterval = time.Minute
+
+// replicationSnapshotPrefix starts the names of the snapshots taken to
+// replicate a container. The latest of them is kept as the base the next
+// replication sends the changes from.
+const replicationSnapshotPrefix = "replication-"
+
+// replicationScheduler replicates the containers on their schedule. It also
+// keeps those replicated on request from being replicated twice at once.
+var replicationScheduler = newScheduler("replication", "replication.schedule.timezone", replicationScheduleRun, "replication.schedule")
+
+// replicationTargetParse splits "replication.target" into the URL of the
+// target server and the storage pool the copy of the container goes to.
+func replicationTargetParse(value string) (string, string, error) {
+	fields := strings.Split(strings.TrimPrefix(value, "https://"), "/")
+	if len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+		return "", "", fmt.Errorf("Invalid replication target \"%s\", expected <address>/<pool>", value)
+	}
+
+	address := fields[0]
+	_, _, err := net.SplitHostPort(address)
+	if err != nil {
+		address = net.JoinHostPort(strings.Trim(address, "[]"), shared.DefaultPort)
+	}
+
+	return fmt.Sprintf("https://%s", address), fields[1], nil
+}
+
+// replicationTargetConfig returns the configuration of the copy of a
+// container on the target. The copy is kept stopped for the next replication
+// to refresh it, so it doesn't replicate itself, autostart nor follow the
+// start and stop schedules of the container.
+func replicationTargetConfig(config map[string]string) map[string]string {
+	result := map[string]string{}
+	for key, value := range config {
+		if strings.HasPrefix(key, "replication.") || strings.HasPrefix(key, "volatile.replication.") || strings.HasPrefix(key, "boot.schedule.") {
+			continue
+		}
+
+		result[key] = value
+	}
+
+	result["boot.autostart"] = "false"
+
+	return result
+}
+
+// replicationTargetCertificate fetches the certificate of the target server,
+// checking it against the fingerprint it's expected to have.
+func replicationTargetCertificate(url string, fingerprint string) (string, error) {
+	conn, err := tls.Dial("tcp", strings.TrimPrefix(url, "https://"), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", fmt.Errorf("The replication target didn't send any certificate")
+	}
+
+	if shared.CertFingerprint(certs[0]) != strings.ToLower(fingerprint) {
+		return "", fmt.Errorf("The certificate of the replication target doesn't match replication.target.fingerprint")
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certs[0].Raw})), nil
+}
+
+// replicationConnect connects to the target server with the certificate of
+// this server, which the target must trust.
+func replicationConnect(d *Daemon, url string, fingerprint string) (lxd.ContainerServer, error) {
+	// Without a fingerprint, the target must be trusted by the system CA.
+	certificate := ""
+	if fingerprint != "" {
+		var err error
+		certificate, err = replicationTargetCertificate(url, fingerprint)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	certf, keyf, err := readMyCert()
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := ioutil.ReadFile(certf)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ioutil.ReadFile(keyf)
+	if err != nil {
+		return nil, err
+	}
+
+	return lxd.ConnectLXD(url, &lxd.ConnectionArgs{
+		TLSServerCert: certificate,
+		TLSClientCert: string(cert),
+		TLSClientKey:  string(key),
+		UserAgent:     version.UserAgent,
+		Proxy:         d.proxy,
+	})
+}
+
+// replicationSend pushes the container to the target, refreshing its
+// existing copy there if any.
+func replicationSend(c container, target lxd.ContainerServer, url string, pool string, refresh bool, op *operation) error {
+	architecture, err := osarch.ArchitectureName(c.Architecture())
+	if err != nil {
+		return err
+	}
+
+	req := api.ContainersPost{
+		Name: c.Name(),
+		ContainerPut: api.ContainerPut{
+			Architecture: architecture,
+			Config:       replicationTargetConfig(c.LocalConfig()),
+			Devices:      c.LocalDevices(),
+			Ephemeral:    c.IsEphemeral(),
+			Profiles:     c.Profiles(),
+		},
+	}
+
+	req.Source.Type = "migration"
+	req.Source.Mode = "push"
+	req.Source.BaseImage = c.LocalConfig()["volatile.base_image"]
+	req.Source.Refresh = refresh
+	req.Source.Pool = pool
+
+	info, err := target.GetConnectionInfo()
+	if err != nil {
+		return err
+	}
+
+	targetOp, err := target.CreateContainer(req)
+	if err != nil {
+		return err
+	}
+
+	secrets := map[string]string{}
+	for k, v := range targetOp.Metadata {
+		secrets[k], _ = v.(string)
+	}
+
+	source, err := NewMigrationSource(c, false, false)
+	if err != nil {
+		targetOp.Cancel()
+		return err
+	}
+
+	err = source.ConnectTarget(api.ContainerPostTarget{
+		Certificate: info.Certificate,
+		Operation:   fmt.Sprintf("%s/1.0/operations/%s", url, targetOp.ID),
+		Websockets:  secrets,
+	})
+	if err != nil {
+		targetOp.Cancel()
+		return err
+	}
+
+	err = source.Do(op)
+	if err != nil {
+		return err
+	}
+
+	return targetOp.Wait()
+}
+
+// replicationRun snapshots the container and sends what changed since the
+// last replicated snapshot to its target, recording the outcome in the
+// volatile.replication.* keys.
+func replicationRun(d *Daemon, c container, op *operation) error {
+	name, err := replicationRunSnapshot(d, c, op)
+	if err != nil {
+		c.ConfigKeySet("volatile.replication.last_error", err.Error())
+		return err
+	}
+
+	c.ConfigKeySet("volatile.replication.last_error", "")
+	c.ConfigKeySet("volatile.replication.last_snapshot", name)
+	c.ConfigKeySet("volatile.replication.last_time", time.Now().UTC().Format(time.RFC3339))
+
+	return nil
+}
+
+func replicationRunSnapshot(d *Daemon, c container, op *operation) (string, error) {
+	config := c.ExpandedConfig()
+	if config["replication.target"] == "" {
+		return "", fmt.Errorf("The container \"%s\" has no replication target", c.Name())
+	}
+
+	// Only ZFS can send the changes since a snapshot the target has.
+	_, _, ok := zfsContainerDataset(c)
+	if !ok {
+		return "", fmt.Errorf("Replicating containers is only supported on ZFS storage pools")
+	}
+
+	url, pool, err := replicationTargetParse(config["replication.target"])
+	if err != nil {
+		return "", err
+	}
+
+	target, err := replicationConnect(d, url, config["replication.target.fingerprint"])
+	if err != nil {
+		return "", err
+	}
+
+	for _, extension := range []string{"container_push", "container_refresh", "migration_refresh", "container_pool_move"} {
+		if !target.HasExtension(extension) {
+			return "", fmt.Errorf("The replication target is missing the required \"%s\" API extension", extension)
+		}
+	}
+
+	names, err := target.GetContainerNames()
+	if err != nil {
+		return "", err
+	}
+
+	name := replicationSnapshotPrefix + time.Now().UTC().Format("20060102-150405")
+	err = containerSnapshotCreate(d, c, name, false)
+	if err != nil {
+		return "", err
+	}
+
+	err = replicationSend(c, target, url, pool, shared.StringInSlice(c.Name(), names), op)
+	if err != nil {
+		// The target drops the snapshots the container doesn't have
+		// anymore on the next replication.
+		snap, loadErr := containerLoadByName(d, c.Name()+shared.SnapshotDelimiter+name)
+		if loadErr == nil {
+			snap.Delete()
+		}
+
+		return "", err
+	}
+
+	// The previous replication snapshots aren't needed as bases anymore.
+	snaps, err := c.Snapshots()
+	if err != nil {
+		return "", err
+	}
+
+	for _, snap := range snaps {
+		_, snapName, _ := containerGetParentAndSnapshotName(snap.Name())
+		if !strings.HasPrefix(snapName, replicationSnapshotPrefix) || snapName == name {
+			continue
+		}
+
+		err := snap.Delete()
+		if err != nil {
+			logger.Warn("Failed to delete previous replication snapshot", log.Ctx{"container": c.Name(), "snapshot": snapName, "err": err})
+		}
+	}
+
+	return name, nil
+}
+
+// replicationScheduleRun replicates a container on its schedule, reporting
+// the outcome as a "container" event.
+func replicationScheduleRun(d *Daemon, c container, key string) {
+	if c.ExpandedConfig()["replication.target"] == "" {
+		return
+	}
+
+	resources := map[string][]string{}
+	resources["containers"] = []string{c.Name()}
+
+	run := func(op *operation) error {
+		return replicationRun(d, c, op)
+	}
+
+	op, err := operationCreate(operationClassTask, resources, nil, run, nil, nil)
+	if err == nil {
+		var chErr chan error
+		chErr, err = op.Run()
+		if err == nil {
+			err = <-chErr
+		}
+	}
+
+	ctx := log.Ctx{"container": c.Name(), "target": c.ExpandedConfig()["replication.target"]}
+	event := shared.Jmap{
+		"action":    "scheduled-replication",
+		"container": c.Name(),
+	}
+
+	if err != nil {
+		ctx["err"] = err
+		event["error"] = err.Error()
+		logger.Error("Failed to run a scheduled replication", ctx)
+	} else {
+		logger.Info("Ran a scheduled replication", ctx)
+	}
+
+	eventSend("container", event)
+}
+
+// /1.0/containers/{name}/replication
+// Status of the replication of the container.
+func containerReplicationGet(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+	c, err := containerLoadByName(d, name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	config := c.ExpandedConfig()
+	status := api.ContainerReplication{
+		Target:       config["replication.target"],
+		Schedule:     config["replication.schedule"],
+		Running:      replicationScheduler.isRunning(name),
+		LastSnapshot: config["volatile.replication.last_snapshot"],
+		LastError:    config["volatile.replication.last_error"],
+	}
+
+	if config["volatile.replication.last_time"] != "" {
+		status.LastReplicatedAt, err = time.Parse(time.RFC3339, config["volatile.replication.last_time"])
+		if err != nil {
+			return InternalError(err)
+		}
+	}
+
+	return SyncResponse(true, status)
+}
+
+// Replicate the container now, regardless of its schedule.
+func containerReplicationPost(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+	c, err := containerLoadByName(d, name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	if c.ExpandedConfig()["replication.target"] == "" {
+		return BadRequest(fmt.Errorf("The container \"%s\" has no replication target", name))
+	}
+
+	if !replicationScheduler.start(name) {
+		return BadRequest(fmt.Errorf("The container \"%s\" is already being replicated", name))
+	}
+
+	run := func(op *operation) error {
+		defer replicationScheduler.done(name)
+		return replicationRun(d, c, op)
+	}
+
+	resources := map[string][]string{}
+	resources["containers"] = []string{name}
+
+	op, err := operationCreate(operationClassTask, resources, nil, run, nil, nil)
+	if err != nil {
+		replicationScheduler.done(name)
+		return InternalError(err)
+	}
+
+	return OperationResponse(op)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestReplicationTargetParse(t *testing.T) {
+	tests := map[string][2]string{
+		"10.0.0.2/tank":                 {"https://10.0.0.2:8443", "tank"},
+		"10.0.0.2:9443/tank":            {"https://10.0.0.2:9443", "tank"},
+		"https://dr.example.com/backup": {"https://dr.example.com:8443", "backup"},
+		"[fd00::2]/tank":                {"https://[fd00::2]:8443", "tank"},
+		"[fd00::2]:9443/tank":           {"https://[fd00::2]:9443", "tank"},
+	}
+
+	for value, expected := range tests {
+		url, pool, err := replicationTargetParse(value)
+		if err != nil {
+			t.Errorf("Failed to parse \"%s\": %s", value, err)
+			continue
+		}
+
+		if url != expected[0] || pool != expected[1] {
+			t.Errorf("Expected \"%s\" to be %v, got [%s %s]", value, expected, url, pool)
+		}
+	}
+
+	for _, value := range []string{"10.0.0.2", "10.0.0.2/", "/tank", "10.0.0.2/tank/extra"} {
+		_, _, err := replicationTargetParse(value)
+		if err == nil {
+			t.Errorf("Expected \"%s\" to be refused", value)
+		}
+	}
+}
+
+func TestReplicationTargetConfig(t *testing.T) {
+	config := replicationTargetConfig(map[string]string{
+		"boot.autostart":                     "true",
+		"boot.schedule.start":                "06:00 daily",
+		"limits.memory":                      "2GB",
+		"replication.schedule":               "01:00 daily",
+		"replication.target":                 "10.0.0.2/tank",
+		"volatile.base_image":                "abcd",
+		"volatile.replication.last_snapshot": "replication-20171017-010000",
+	})
+
+	expected := map[string]string{
+		"boot.autostart":      "false",
+		"limits.memory":       "2GB",
+		"volatile.base_image": "abcd",
+	}
+
+	if len(config) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, config)
+	}
+
+	for key, value := range expected {
+		if config[key] != value {
+			t.Errorf("Expected \"%s\" to be \"%s\", got \"%s\"", key, value, config[key])
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"time"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// containersScheduleInterval is how often the "boot.schedule.start" and
+// "boot.schedule.stop" keys of the containers are checked.
+const containersScheduleInterval = time.Minute
+
+// containersScheduler starts and stops the containers on their schedule.
+// When both come due at once, the container ends up stopped.
+var containersScheduler = newScheduler("container action", "boot.schedule.timezone", containerScheduleRun, "boot.schedule.start", "boot.schedule.stop")
+
+// containerScheduleStop shuts a container down, giving it as long as on host
+// shutdown to do so cleanly before killing it.
+func containerScheduleStop(c container) error {
+	err := c.Shutdown(containerShutdownTimeout(c))
+	if err == nil {
+		return nil
+	}
+
+	return c.Stop(false)
+}
+
+// containerScheduleRun starts or stops a container, unless it already is,
+// and reports the outcome as a "container" event.
+func containerScheduleRun(d *Daemon, c container, key string) {
+	action := "scheduled-stop"
+	if key == "boot.schedule.start" {
+		action = "scheduled-start"
+	}
+
+	if (action == "scheduled-start") == c.IsRunning() {
+		return
+	}
+
+	var err error
+	if action == "scheduled-start" {
+		err = c.Start(false)
+	} else {
+		err = containerScheduleStop(c)
+	}
+
+	ctx := log.Ctx{"container": c.Name(), "action": action}
+	event := shared.Jmap{
+		"action":    action,
+		"container": c.Name(),
+	}
+
+	if err != nil {
+		ctx["err"] = err
+		event["error"] = err.Error()
+		logger.Error("Failed to run a scheduled container action", ctx)
+	} else {
+		logger.Info("Ran a scheduled container action", ctx)
+	}
+
+	eventSend("container", event)
+}
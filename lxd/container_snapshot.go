@@ -7,12 +7,16 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 
 	"github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/logger"
 	"github.com/lxc/lxd/shared/version"
+
+	log "gopkg.in/inconshreveable/log15.v2"
 )
 
 func containerSnapshotsGet(d *Daemon, r *http.Request) Response {
@@ -127,29 +131,8 @@ func containerSnapshotsPost(d *Daemon, r *http.Request) Response {
 		req.Name = fmt.Sprintf("snap%d", i)
 	}
 
-	fullName := name +
-		shared.SnapshotDelimiter +
-		req.Name
-
 	snapshot := func(op *operation) error {
-		args := containerArgs{
-			Name:         fullName,
-			Ctype:        cTypeSnapshot,
-			Config:       c.LocalConfig(),
-			Profiles:     c.Profiles(),
-			Ephemeral:    c.IsEphemeral(),
-			BaseImage:    c.ExpandedConfig()["volatile.base_image"],
-			Architecture: c.Architecture(),
-			Devices:      c.LocalDevices(),
-			Stateful:     req.Stateful,
-		}
-
-		_, err := containerCreateAsSnapshot(d, args, c)
-		if err != nil {
-			return err
-		}
-
-		return nil
+		return containerSnapshotCreate(d, c, req.Name, req.Stateful)
 	}
 
 	resources := map[string][]string{}
@@ -163,6 +146,69 @@ func containerSnapshotsPost(d *Daemon, r *http.Request) Response {
 	return OperationResponse(op)
 }
 
+// containerSnapshotCreate creates a snapshot of the container with the given
+// snapshot name.
+func containerSnapshotCreate(d *Daemon, c container, snapName string, stateful bool) error {
+	storageFreezeEnter()
+	defer storageFreezeLeave()
+
+	expiry, err := shared.GetSnapshotExpiry(time.Now(), c.ExpandedConfig()["snapshots.expiry"])
+	if err != nil {
+		return err
+	}
+
+	args := containerArgs{
+		Name:         c.Name() + shared.SnapshotDelimiter + snapName,
+		Ctype:        cTypeSnapshot,
+		Config:       c.LocalConfig(),
+		Profiles:     c.Profiles(),
+		Ephemeral:    c.IsEphemeral(),
+		BaseImage:    c.ExpandedConfig()["volatile.base_image"],
+		Architecture: c.Architecture(),
+		Devices:      c.LocalDevices(),
+		Stateful:     stateful,
+		ExpiryDate:   expiry,
+	}
+
+	_, err = containerCreateAsSnapshot(d, args, c)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// snapshotsExpiryInterval is how often the expired snapshots are pruned.
+const snapshotsExpiryInterval = time.Minute
+
+// pruneExpiredSnapshots deletes the snapshots whose "snapshots.expiry" date,
+// set when they were taken, has passed.
+func pruneExpiredSnapshots(d *Daemon) {
+	names, err := dbContainerGetExpiredSnapshots(d.db, time.Now())
+	if err != nil {
+		logger.Error("Unable to retrieve the list of expired snapshots", log.Ctx{"err": err})
+		return
+	}
+
+	for _, name := range names {
+		sc, err := containerLoadByName(d, name)
+		if err != nil {
+			logger.Error("Failed to load expired snapshot", log.Ctx{"snapshot": name, "err": err})
+			continue
+		}
+
+		storageFreezeEnter()
+		err = sc.Delete()
+		storageFreezeLeave()
+		if err != nil {
+			logger.Error("Failed to delete expired snapshot", log.Ctx{"snapshot": name, "err": err})
+			continue
+		}
+
+		logger.Info("Deleted expired snapshot", log.Ctx{"snapshot": name})
+	}
+}
+
 func snapshotHandler(d *Daemon, r *http.Request) Response {
 	containerName := mux.Vars(r)["name"]
 	snapshotName := mux.Vars(r)["snapshotName"]
@@ -182,7 +228,7 @@ func snapshotHandler(d *Daemon, r *http.Request) Response {
 	case "POST":
 		return snapshotPost(d, r, sc, containerName)
 	case "DELETE":
-		return snapshotDelete(sc, snapshotName)
+		return snapshotDelete(d, r, sc, snapshotName)
 	default:
 		return NotFound
 	}
@@ -279,9 +325,26 @@ func snapshotPost(d *Daemon, r *http.Request, sc container, containerName string
 	return OperationResponse(op)
 }
 
-func snapshotDelete(sc container, name string) Response {
+func snapshotDelete(d *Daemon, r *http.Request, sc container, name string) Response {
+	if dryRunRequested(r) {
+		plan, err := containerDeletePlan(d, sc)
+		if err != nil {
+			return SmartError(err)
+		}
+
+		return SyncResponse(true, plan)
+	}
+
 	remove := func(op *operation) error {
-		return sc.Delete()
+		storageFreezeEnter()
+		defer storageFreezeLeave()
+
+		poolName, _ := sc.StoragePool()
+		audit := storageAuditStart(r, poolName, "snapshot_delete", sc.Name())
+		sc.StorageAuditSet(audit)
+		err := sc.Delete()
+		audit.finish(d, err)
+		return err
 	}
 
 	resources := map[string][]string{}
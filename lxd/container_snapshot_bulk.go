@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+)
+
+var containerSnapshotsBulkCmd = Command{
+	name: "snapshots",
+	post: containerSnapshotsBulkPost,
+}
+
+// containerSnapshotsBulkSelect returns the containers matching the filter,
+// sorted by name.
+func containerSnapshotsBulkSelect(d *Daemon, filter api.ContainerSnapshotsBulkFilter) ([]string, error) {
+	names, err := dbContainersList(d.db, cTypeRegular)
+	if err != nil {
+		return nil, err
+	}
+
+	var members []string
+	if filter.Profile != "" {
+		_, _, err := dbProfileGet(d.db, filter.Profile)
+		if err != nil {
+			return nil, err
+		}
+
+		members, err = dbProfileContainersGet(d.db, filter.Profile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	selected := []string{}
+	for _, name := range names {
+		if filter.Profile != "" && !shared.StringInSlice(name, members) {
+			continue
+		}
+
+		if filter.Name != "" {
+			match, _ := path.Match(filter.Name, name)
+			if !match {
+				continue
+			}
+		}
+
+		selected = append(selected, name)
+	}
+
+	sort.Strings(selected)
+	return selected, nil
+}
+
+func containerSnapshotsBulkCreate(d *Daemon, name string, snapName string, stateful bool) error {
+	c, err := containerLoadByName(d, name)
+	if err != nil {
+		return err
+	}
+
+	id, _ := dbContainerId(d.db, name+shared.SnapshotDelimiter+snapName)
+	if id > 0 {
+		return fmt.Errorf("Snapshot '%s' already exists", snapName)
+	}
+
+	ourStart, err := c.StorageStart()
+	if err != nil {
+		return err
+	}
+	if ourStart {
+		defer c.StorageStop()
+	}
+
+	return containerSnapshotCreate(d, c, snapName, stateful)
+}
+
+func containerSnapshotsBulkDelete(d *Daemon, name string, snapName string) error {
+	fullName := name + shared.SnapshotDelimiter + snapName
+
+	id, _ := dbContainerId(d.db, fullName)
+	if id <= 0 {
+		return NoSuchObjectError
+	}
+
+	sc, err := containerLoadByName(d, fullName)
+	if err != nil {
+		return err
+	}
+
+	storageFreezeEnter()
+	defer storageFreezeLeave()
+
+	return sc.Delete()
+}
+
+// containerSnapshotsBulkValidate checks a bulk snapshot request.
+func containerSnapshotsBulkValidate(req api.ContainerSnapshotsBulkPost) error {
+	if req.Action != "create" && req.Action != "delete" {
+		return fmt.Errorf("Invalid action '%s', must be one of: create, delete", req.Action)
+	}
+
+	if req.Action == "delete" && req.Name == "" {
+		return fmt.Errorf("A snapshot name is required to delete snapshots")
+	}
+
+	// Deleting the snapshots of every container takes asking for it.
+	if req.Action == "delete" && req.Filter.Profile == "" && req.Filter.Name == "" && !req.Filter.All {
+		return fmt.Errorf("A filter or \"all\" is required to delete snapshots")
+	}
+
+	if req.Name != "" && shared.IsSnapshot(req.Name) {
+		return fmt.Errorf("Invalid snapshot name '%s'", req.Name)
+	}
+
+	if req.Filter.Name != "" {
+		_, err := path.Match(req.Filter.Name, "")
+		if err != nil {
+			return fmt.Errorf("Invalid name pattern '%s': %s", req.Filter.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// /1.0/snapshots
+// Create or delete a snapshot of all the containers matching a filter.
+func containerSnapshotsBulkPost(d *Daemon, r *http.Request) Response {
+	req := api.ContainerSnapshotsBulkPost{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	err = containerSnapshotsBulkValidate(req)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	names, err := containerSnapshotsBulkSelect(d, req.Filter)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	run := func(op *operation) error {
+		results := []api.ContainerSnapshotsBulkResult{}
+		failures := 0
+
+		for _, name := range names {
+			result := api.ContainerSnapshotsBulkResult{
+				Container: name,
+				Snapshot:  req.Name,
+				Result:    "success",
+			}
+
+			var err error
+
+			if req.Action == "create" {
+				if result.Snapshot == "" {
+					result.Snapshot = fmt.Sprintf("snap%d", nextSnapshot(d, name))
+				}
+
+				err = containerSnapshotsBulkCreate(d, name, result.Snapshot, req.Stateful)
+			} else {
+				err = containerSnapshotsBulkDelete(d, name, req.Name)
+				if err == NoSuchObjectError {
+					// Containers without that snapshot are skipped.
+					result.Result = "skipped"
+					err = nil
+				}
+			}
+
+			if err != nil {
+				result.Result = "failure"
+				result.Error = err.Error()
+				failures++
+			}
+
+			results = append(results, result)
+			op.UpdateMetadata(map[string]interface{}{"results": results})
+		}
+
+		if failures > 0 {
+			return fmt.Errorf("Failed to %s the snapshot of %d out of %d containers", req.Action, failures, len(names))
+		}
+
+		return nil
+	}
+
+	resources := map[string][]string{}
+	resources["containers"] = names
+
+	op, err := operationCreate(operationClassTask, resources, nil, run, nil, nil)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return OperationResponse(op)
+}
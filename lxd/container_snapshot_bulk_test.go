@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/lxc/lxd/shared/api"
+)
+
+func TestContainerSnapshotsBulkValidate(t *testing.T) {
+	tests := []struct {
+		req   api.ContainerSnapshotsBulkPost
+		valid bool
+	}{
+		{api.ContainerSnapshotsBulkPost{Action: "create"}, true},
+		{api.ContainerSnapshotsBulkPost{Action: "rename"}, false},
+		{api.ContainerSnapshotsBulkPost{Action: "delete", Filter: api.ContainerSnapshotsBulkFilter{All: true}}, false},
+		{api.ContainerSnapshotsBulkPost{Action: "delete", Name: "snap0"}, false},
+		{api.ContainerSnapshotsBulkPost{Action: "delete", Name: "snap0", Filter: api.ContainerSnapshotsBulkFilter{All: true}}, true},
+		{api.ContainerSnapshotsBulkPost{Action: "delete", Name: "snap0", Filter: api.ContainerSnapshotsBulkFilter{Profile: "default"}}, true},
+		{api.ContainerSnapshotsBulkPost{Action: "delete", Name: "snap0", Filter: api.ContainerSnapshotsBulkFilter{Name: "web-*"}}, true},
+		{api.ContainerSnapshotsBulkPost{Action: "create", Name: "c1/snap0"}, false},
+		{api.ContainerSnapshotsBulkPost{Action: "create", Filter: api.ContainerSnapshotsBulkFilter{Name: "web-["}}, false},
+	}
+
+	for i, test := range tests {
+		err := containerSnapshotsBulkValidate(test.req)
+		if test.valid && err != nil {
+			t.Errorf("Expected request %d to be valid, got: %s", i, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("Expected request %d to be rejected", i)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -14,6 +15,21 @@ import (
 
 func containerState(d *Daemon, r *http.Request) Response {
 	name := mux.Vars(r)["name"]
+
+	// Optionally wait for the container to report itself as ready
+	waitReady := r.FormValue("wait_ready")
+	if waitReady != "" {
+		timeout, err := strconv.Atoi(waitReady)
+		if err != nil {
+			return BadRequest(fmt.Errorf("Invalid wait_ready value '%s'", waitReady))
+		}
+
+		err = containerWaitReady(d, name, time.Duration(timeout)*time.Second)
+		if err != nil {
+			return SmartError(err)
+		}
+	}
+
 	c, err := containerLoadByName(d, name)
 	if err != nil {
 		return SmartError(err)
@@ -53,6 +69,12 @@ func containerStatePut(d *Daemon, r *http.Request) Response {
 	case shared.Start:
 		do = func(op *operation) error {
 			if err = c.Start(raw.Stateful); err != nil {
+				// Let clients know which kernel modules are missing
+				modulesErr, ok := err.(kernelModulesError)
+				if ok {
+					op.UpdateMetadata(map[string]interface{}{"missing_kernel_modules": modulesErr.Modules})
+				}
+
 				return err
 			}
 			return nil
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/lxc/lxd/lxd/types"
 	"github.com/lxc/lxd/shared"
@@ -134,6 +135,28 @@ func (suite *containerTestSuite) TestContainer_LoadFromDB() {
 		"The loaded container isn't excactly the same as the created one.")
 }
 
+func (suite *containerTestSuite) TestContainer_VolatileKeysCommitted() {
+	args := containerArgs{
+		Ctype: cTypeRegular,
+		Devices: types.Devices{
+			"eth0": types.Device{
+				"type":    "nic",
+				"nictype": "bridged",
+				"parent":  "unknownbr0"}},
+		Name: "testFoo",
+	}
+
+	c, err := containerCreateInternal(suite.d, args)
+	suite.Req.Nil(err)
+	defer c.Delete()
+
+	// The generated keys don't wait for the volatile store to be flushed
+	hwaddr, err := dbContainerConfigGet(suite.d.db, c.Id(), "volatile.eth0.hwaddr")
+	suite.Req.Nil(err)
+	suite.Req.Equal(c.LocalConfig()["volatile.eth0.hwaddr"], hwaddr)
+	suite.Req.NotEmpty(hwaddr)
+}
+
 func (suite *containerTestSuite) TestContainer_Path_Regular() {
 	// Regular
 	args := containerArgs{
@@ -390,6 +413,67 @@ func (suite *containerTestSuite) TestContainer_findIdmap_maxed() {
 	}
 }
 
+func (suite *containerTestSuite) TestContainer_BackupPool() {
+	_, err := dbStoragePoolCreate(suite.d.db, "other", "", "mock", map[string]string{})
+	suite.Req.Nil(err)
+
+	// The pool of the backup is used if it exists.
+	pool, err := backupPool(suite.d, backupInfo{Pool: "other"}, lxdTestSuiteDefaultStoragePool)
+	suite.Req.Nil(err)
+	suite.Req.Equal("other", pool)
+
+	// Then the requested one.
+	pool, err = backupPool(suite.d, backupInfo{Pool: "missing"}, "other")
+	suite.Req.Nil(err)
+	suite.Req.Equal("other", pool)
+
+	_, err = backupPool(suite.d, backupInfo{Pool: "missing"}, "missing2")
+	suite.Req.NotNil(err)
+
+	// Then the one of the default profile.
+	pool, err = backupPool(suite.d, backupInfo{Pool: "missing"}, "")
+	suite.Req.Nil(err)
+	suite.Req.Equal(lxdTestSuiteDefaultStoragePool, pool)
+
+	// Containers get a local root disk device unless their profiles
+	// already put them on the pool.
+	args := containerArgs{Profiles: []string{"default"}}
+	suite.Req.Nil(backupRootDiskPoolSet(suite.d, &args, lxdTestSuiteDefaultStoragePool))
+	suite.Req.Empty(args.Devices)
+
+	suite.Req.Nil(backupRootDiskPoolSet(suite.d, &args, "other"))
+	suite.Req.Equal("other", args.Devices["root"]["pool"])
+}
+
 func TestContainerTestSuite(t *testing.T) {
 	suite.Run(t, new(containerTestSuite))
 }
+
+func (suite *containerTestSuite) TestContainer_WaitReadyCancel() {
+	args := containerArgs{
+		Ctype:     cTypeRegular,
+		Ephemeral: false,
+		Name:      "testFoo",
+	}
+
+	c, err := containerCreateInternal(suite.d, args)
+	suite.Req.Nil(err)
+	defer c.Delete()
+
+	result := make(chan error)
+	go func() {
+		result <- containerWaitReady(suite.d, c.Name(), -1)
+	}()
+
+	// Cancel until the waiter got registered.
+	for {
+		containerReadyCancel(c.Name())
+
+		select {
+		case err := <-result:
+			suite.Req.NotNil(err, "The wait should fail once the container stopped")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -53,11 +53,55 @@ var containerSnapshotCmd = Command{
 	delete: snapshotHandler,
 }
 
+var containerBackupsCmd = Command{
+	name: "containers/{name}/backups",
+	get:  containerBackupsGet,
+	post: containerBackupsPost,
+}
+
+var containerBackupCmd = Command{
+	name:   "containers/{name}/backups/{backupName}",
+	get:    containerBackupGet,
+	delete: containerBackupDelete,
+}
+
+var containerBackupExportCmd = Command{
+	name:         "containers/{name}/backups/{backupName}/export",
+	untrustedGet: true,
+	get:          containerBackupExportGet,
+}
+
+var containerBackupSignedURLCmd = Command{
+	name: "containers/{name}/backups/{backupName}/signed-url",
+	post: containerBackupSignedURL,
+}
+
 var containerExecCmd = Command{
 	name: "containers/{name}/exec",
 	post: containerExecPost,
 }
 
+var containerDiffCmd = Command{
+	name: "containers/{name}/diff",
+	get:  containerDiffGet,
+}
+
+var containerMigrationCheckCmd = Command{
+	name: "containers/{name}/migration-check",
+	post: containerMigrationCheckPost,
+}
+
+var containerRebuildCmd = Command{
+	name: "containers/{name}/rebuild",
+	post: containerRebuildPost,
+}
+
+var containerReplicationCmd = Command{
+	name: "containers/{name}/replication",
+	get:  containerReplicationGet,
+	post: containerReplicationPost,
+}
+
 type containerAutostartList []container
 
 func (slice containerAutostartList) Len() int {
@@ -154,18 +198,12 @@ func containersShutdown(d *Daemon) error {
 		// Stop the container
 		if c.IsRunning() {
 			// Determinate how long to wait for the container to shutdown cleanly
-			var timeoutSeconds int
-			value, ok := c.ExpandedConfig()["boot.host_shutdown_timeout"]
-			if ok {
-				timeoutSeconds, _ = strconv.Atoi(value)
-			} else {
-				timeoutSeconds = 30
-			}
+			timeout := containerShutdownTimeout(c)
 
 			// Stop the container
 			wg.Add(1)
 			go func() {
-				c.Shutdown(time.Second * time.Duration(timeoutSeconds))
+				c.Shutdown(timeout)
 				c.Stop(false)
 				c.ConfigKeySet("volatile.last_state.power", lastState)
 
@@ -180,7 +218,7 @@ func containersShutdown(d *Daemon) error {
 	return nil
 }
 
-func containerDeleteSnapshots(d *Daemon, cname string) error {
+func containerDeleteSnapshots(d *Daemon, cname string, audit *storageAudit) error {
 	logger.Debug("containerDeleteSnapshots",
 		log.Ctx{"container": cname})
 
@@ -199,6 +237,7 @@ func containerDeleteSnapshots(d *Daemon, cname string) error {
 			continue
 		}
 
+		sc.StorageAuditSet(audit)
 		if err := sc.Delete(); err != nil {
 			logger.Error(
 				"containerDeleteSnapshots: Failed to delete a snapshotcontainer",
@@ -5,14 +5,20 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/api"
 	"github.com/lxc/lxd/shared/logger"
 	"github.com/lxc/lxd/shared/version"
 )
 
 func containersGet(d *Daemon, r *http.Request) Response {
+	selector, err := shared.ParseLabelSelector(r.FormValue("labels"))
+	if err != nil {
+		return BadRequest(err)
+	}
+
 	for i := 0; i < 100; i++ {
-		result, err := doContainersGet(d, d.isRecursionRequest(r))
+		result, err := doContainersGet(d, d.isRecursionRequest(r), selector)
 		if err == nil {
 			return SyncResponse(true, result)
 		}
@@ -30,7 +36,7 @@ func containersGet(d *Daemon, r *http.Request) Response {
 	return InternalError(fmt.Errorf("DB is locked"))
 }
 
-func doContainersGet(d *Daemon, recursion bool) (interface{}, error) {
+func doContainersGet(d *Daemon, recursion bool, selector shared.LabelSelector) (interface{}, error) {
 	result, err := dbContainersList(d.db, cTypeRegular)
 	if err != nil {
 		return nil, err
@@ -43,6 +49,22 @@ func doContainersGet(d *Daemon, recursion bool) (interface{}, error) {
 	}
 
 	for _, container := range result {
+		if len(selector) > 0 {
+			id, err := dbContainerId(d.db, container)
+			if err != nil {
+				return nil, err
+			}
+
+			labels, err := dbContainerLabels(d.db, id)
+			if err != nil {
+				return nil, err
+			}
+
+			if !selector.Matches(labels) {
+				continue
+			}
+		}
+
 		if !recursion {
 			url := fmt.Sprintf("/%s/containers/%s", version.APIVersion, container)
 			resultString = append(resultString, url)
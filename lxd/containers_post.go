@@ -1,15 +1,22 @@
 package main
 
 import (
+	"bytes"
 	"crypto/x509"
+	"database/sql"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/dustinkirkland/golang-petname"
 	"github.com/gorilla/websocket"
+	"github.com/pborman/uuid"
 
 	"github.com/lxc/lxd/lxd/types"
 	"github.com/lxc/lxd/shared"
@@ -24,6 +31,15 @@ func createFromImage(d *Daemon, req *api.ContainersPost) Response {
 	var hash string
 	var err error
 
+	// The container may request a personality of the image's architecture.
+	requestedArchitecture := 0
+	if req.Architecture != "" {
+		requestedArchitecture, err = osarch.ArchitectureId(req.Architecture)
+		if err != nil {
+			return BadRequest(err)
+		}
+	}
+
 	if req.Source.Fingerprint != "" {
 		hash = req.Source.Fingerprint
 	} else if req.Source.Alias != "" {
@@ -47,7 +63,19 @@ func createFromImage(d *Daemon, req *api.ContainersPost) Response {
 			return SmartError(err)
 		}
 
+		// The host's own architecture comes first.
+		architectureRank := func(arch int) int {
+			for i, entry := range d.architectures {
+				if entry == arch {
+					return i
+				}
+			}
+
+			return len(d.architectures)
+		}
+
 		var image *api.Image
+		var unsupported *api.Image
 
 		for _, imageHash := range hashes {
 			_, img, err := dbImageGet(d.db, imageHash, false, true)
@@ -55,10 +83,6 @@ func createFromImage(d *Daemon, req *api.ContainersPost) Response {
 				continue
 			}
 
-			if image != nil && img.CreatedAt.Before(image.CreatedAt) {
-				continue
-			}
-
 			match := true
 			for key, value := range req.Source.Properties {
 				if img.Properties[key] != value {
@@ -71,9 +95,31 @@ func createFromImage(d *Daemon, req *api.ContainersPost) Response {
 				continue
 			}
 
+			// Skip the images the host can't run and prefer those
+			// of its own architecture to those of a personality.
+			arch, err := osarch.ArchitectureId(img.Architecture)
+			if err != nil || !shared.IntInSlice(arch, d.architectures) {
+				unsupported = img
+				continue
+			}
+
+			if image != nil {
+				rank := architectureRank(arch)
+				imageArch, _ := osarch.ArchitectureId(image.Architecture)
+				imageRank := architectureRank(imageArch)
+				if rank > imageRank || (rank == imageRank && img.CreatedAt.Before(image.CreatedAt)) {
+					continue
+				}
+			}
+
 			image = img
 		}
 
+		if image == nil && unsupported != nil {
+			arch, _ := osarch.ArchitectureId(unsupported.Architecture)
+			return BadRequest(containerValidArchitecture(d, arch))
+		}
+
 		if image == nil {
 			return BadRequest(fmt.Errorf("No matching image could be found"))
 		}
@@ -83,12 +129,29 @@ func createFromImage(d *Daemon, req *api.ContainersPost) Response {
 		return BadRequest(fmt.Errorf("Must specify one of alias, fingerprint or properties for init from image"))
 	}
 
+	// Reject local images the host can't run before doing anything.
+	if req.Source.Server == "" {
+		_, info, err := dbImageGet(d.db, hash, false, false)
+		if err == nil {
+			arch, err := osarch.ArchitectureId(info.Architecture)
+			if err != nil {
+				return BadRequest(err)
+			}
+
+			_, err = containerImageArchitecture(d, arch, requestedArchitecture)
+			if err != nil {
+				return BadRequest(err)
+			}
+		}
+	}
+
 	run := func(op *operation) error {
 		args := containerArgs{
 			Config:    req.Config,
 			Ctype:     cTypeRegular,
 			Devices:   req.Devices,
 			Ephemeral: req.Ephemeral,
+			Labels:    req.Labels,
 			Name:      req.Name,
 			Profiles:  req.Profiles,
 		}
@@ -108,7 +171,12 @@ func createFromImage(d *Daemon, req *api.ContainersPost) Response {
 			}
 		}
 
-		args.Architecture, err = osarch.ArchitectureId(info.Architecture)
+		imageArchitecture, err := osarch.ArchitectureId(info.Architecture)
+		if err != nil {
+			return err
+		}
+
+		args.Architecture, err = containerImageArchitecture(d, imageArchitecture, requestedArchitecture)
 		if err != nil {
 			return err
 		}
@@ -134,6 +202,7 @@ func createFromNone(d *Daemon, req *api.ContainersPost) Response {
 		Ctype:     cTypeRegular,
 		Devices:   req.Devices,
 		Ephemeral: req.Ephemeral,
+		Labels:    req.Labels,
 		Name:      req.Name,
 		Profiles:  req.Profiles,
 	}
@@ -162,12 +231,104 @@ func createFromNone(d *Daemon, req *api.ContainersPost) Response {
 	return OperationResponse(op)
 }
 
+// migrationConflictPolicies are the ways of dealing with the storage left
+// behind on the target under the name of a container being received.
+var migrationConflictPolicies = []string{"fail", "overwrite", "rename"}
+
+// migrationConflictResolve applies the conflict policy of a migration to the
+// storage left on the target pool under the name of the container when no
+// such container exists. This runs before anything gets created or received
+// so that the data of a same-named container can't be destroyed by accident.
+func migrationConflictResolve(d *Daemon, args containerArgs, conflict string) Response {
+	// Containers which do exist are refused when creating them.
+	_, err := dbContainerId(d.db, args.Name)
+	if err == nil {
+		return nil
+	}
+
+	leftover, err := containerLXCLoad(d, args)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	_, rootDiskDevice, err := containerGetRootDiskDevice(leftover.ExpandedDevices())
+	if err != nil {
+		return InternalError(err)
+	}
+
+	s, err := storagePoolInit(d, rootDiskDevice["pool"])
+	if err != nil {
+		return InternalError(err)
+	}
+
+	if !s.ContainerStorageReady(args.Name) {
+		return nil
+	}
+
+	ctx := log.Ctx{"container": args.Name, "conflict": conflict}
+	switch conflict {
+	case "overwrite":
+		logger.Warn("Deleting the storage left under the name of the received container", ctx)
+		err = s.ContainerDelete(leftover)
+	case "rename":
+		// The new name has to stay a valid container name.
+		newName := args.Name
+		if len(newName) > 54 {
+			newName = newName[:54]
+		}
+		newName = fmt.Sprintf("%s-%s", strings.TrimRight(newName, "-"), strings.Split(uuid.NewRandom().String(), "-")[0])
+
+		ctx["new_name"] = newName
+		logger.Warn("Renaming the storage left under the name of the received container", ctx)
+		err = s.ContainerRename(leftover, newName)
+	default:
+		return BadRequest(fmt.Errorf("Storage for a container named \"%s\" already exists on the target, pick another conflict policy than \"fail\" to replace or rename it", args.Name))
+	}
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return nil
+}
+
 func createFromMigration(d *Daemon, req *api.ContainersPost) Response {
 	// Validate migration mode
 	if req.Source.Mode != "pull" && req.Source.Mode != "push" {
 		return NotImplemented
 	}
 
+	// Validate the conflict policy
+	conflict := req.Source.Conflict
+	if conflict == "" {
+		conflict = "fail"
+	}
+
+	if !shared.StringInSlice(conflict, migrationConflictPolicies) {
+		return BadRequest(fmt.Errorf("Invalid conflict policy: %s", conflict))
+	}
+
+	// Refresh the target instead if it's an existing copy of the container.
+	if req.Source.Refresh {
+		c, err := containerLoadByName(d, req.Name)
+		if err == nil {
+			if req.Source.Live {
+				return BadRequest(fmt.Errorf("Containers can't be refreshed live"))
+			}
+
+			if req.Source.ContainerOnly {
+				return BadRequest(fmt.Errorf("Refreshing a container needs its snapshots, it can't be container only"))
+			}
+
+			if c.IsRunning() {
+				return BadRequest(fmt.Errorf("The container \"%s\" must be stopped to be refreshed", c.Name()))
+			}
+
+			return createFromMigrationSink(d, req, c, true)
+		} else if err != sql.ErrNoRows {
+			return SmartError(err)
+		}
+	}
+
 	var c container
 
 	// Parse the architecture name
@@ -184,6 +345,7 @@ func createFromMigration(d *Daemon, req *api.ContainersPost) Response {
 		Ctype:        cTypeRegular,
 		Devices:      req.Devices,
 		Ephemeral:    req.Ephemeral,
+		Labels:       req.Labels,
 		Name:         req.Name,
 		Profiles:     req.Profiles,
 	}
@@ -208,6 +370,20 @@ func createFromMigration(d *Daemon, req *api.ContainersPost) Response {
 		}
 	}
 
+	// The storage pool asked for overrides the one of the root disk
+	// device.
+	if req.Source.Pool != "" {
+		_, err := dbStoragePoolGetID(d.db, req.Source.Pool)
+		if err != nil {
+			return BadRequest(fmt.Errorf("The \"%s\" storage pool doesn't exist", req.Source.Pool))
+		}
+
+		storagePool = req.Source.Pool
+		if localRootDiskDeviceKey != "" {
+			localRootDiskDevice["pool"] = storagePool
+		}
+	}
+
 	// If we don't have a valid pool yet, look through profiles
 	if storagePool == "" {
 		for _, pName := range req.Profiles {
@@ -272,6 +448,17 @@ func createFromMigration(d *Daemon, req *api.ContainersPost) Response {
 		args.Devices[localRootDiskDeviceKey]["pool"] = storagePool
 	}
 
+	resp := migrationConflictResolve(d, args, conflict)
+	if resp != nil {
+		return resp
+	}
+
+	// Mark the container until it's fully received.
+	if args.Config == nil {
+		args.Config = map[string]string{}
+	}
+	args.Config[migrationPartialKey] = "true"
+
 	/* Only create a container from an image if we're going to
 	 * rsync over the top of it. In the case of a better file
 	 * transfer mechanism, let's just use that.
@@ -327,24 +514,37 @@ func createFromMigration(d *Daemon, req *api.ContainersPost) Response {
 		}
 	}
 
+	return createFromMigrationSink(d, req, c, false)
+}
+
+// createFromMigrationSink receives a container created for a migration, or an
+// existing copy of it when refreshing it, which then isn't deleted on failure.
+func createFromMigrationSink(d *Daemon, req *api.ContainersPost, c container, refresh bool) Response {
+	discard := func() {
+		if !refresh {
+			c.Delete()
+		}
+	}
+
+	var err error
 	var cert *x509.Certificate
 	if req.Source.Certificate != "" {
 		certBlock, _ := pem.Decode([]byte(req.Source.Certificate))
 		if certBlock == nil {
-			c.Delete()
+			discard()
 			return InternalError(fmt.Errorf("Invalid certificate"))
 		}
 
 		cert, err = x509.ParseCertificate(certBlock.Bytes)
 		if err != nil {
-			c.Delete()
+			discard()
 			return InternalError(err)
 		}
 	}
 
 	config, err := shared.GetTLSConfig("", "", "", cert)
 	if err != nil {
-		c.Delete()
+		discard()
 		return InternalError(err)
 	}
 
@@ -363,11 +563,12 @@ func createFromMigration(d *Daemon, req *api.ContainersPost) Response {
 		Push:          push,
 		Live:          req.Source.Live,
 		ContainerOnly: req.Source.ContainerOnly,
+		Refresh:       refresh,
 	}
 
 	sink, err := NewMigrationSink(&migrationArgs)
 	if err != nil {
-		c.Delete()
+		discard()
 		return InternalError(err)
 	}
 
@@ -376,17 +577,27 @@ func createFromMigration(d *Daemon, req *api.ContainersPost) Response {
 		err = sink.Do(op)
 		if err != nil {
 			logger.Error("Error during migration sink", log.Ctx{"err": err})
-			c.Delete()
-			return fmt.Errorf("Error transferring container data: %s", err)
+			discard()
+			return errorWrapf(err, "Error transferring container data: %s", err)
 		}
 
 		err = c.TemplateApply("copy")
 		if err != nil {
-			c.Delete()
+			discard()
 			return err
 		}
 
-		return nil
+		// Apply any post-storage configuration to a refreshed copy.
+		if refresh {
+			err = containerConfigureInternal(c)
+			if err != nil {
+				return err
+			}
+
+			return nil
+		}
+
+		return c.ConfigKeySet(migrationPartialKey, "")
 	}
 
 	resources := map[string][]string{}
@@ -408,6 +619,16 @@ func createFromMigration(d *Daemon, req *api.ContainersPost) Response {
 	return OperationResponse(op)
 }
 
+// containerCopyStages returns the stages of the copy or refresh of a
+// container, quiescing the source first if requested.
+func containerCopyStages(quiesce bool, stage string) []string {
+	if quiesce {
+		return []string{"quiesce", stage}
+	}
+
+	return []string{stage}
+}
+
 func createFromCopy(d *Daemon, req *api.ContainersPost) Response {
 	if req.Source.Source == "" {
 		return BadRequest(fmt.Errorf("must specify a source container"))
@@ -418,6 +639,46 @@ func createFromCopy(d *Daemon, req *api.ContainersPost) Response {
 		return SmartError(err)
 	}
 
+	// Refresh the target instead if it's an existing copy of the source.
+	if req.Source.Refresh {
+		target, err := containerLoadByName(d, req.Name)
+		if err == nil {
+			run := func(op *operation) error {
+				storageFreezeEnter()
+				defer storageFreezeLeave()
+
+				stages := operationStagesSet(op, containerCopyStages(req.Source.Quiesce, "refresh")...)
+				defer stages.fail()
+
+				if req.Source.Quiesce {
+					stages.start("quiesce", 0)
+					thaw, err := containerQuiesce(source)
+					if err != nil {
+						return err
+					}
+					defer thaw()
+				}
+
+				stages.start("refresh", 0)
+				err := containerRefreshAsCopy(d, target, source, req.Source.ContainerOnly)
+				stages.finish(err)
+				return err
+			}
+
+			resources := map[string][]string{}
+			resources["containers"] = []string{req.Name, req.Source.Source}
+
+			op, err := operationCreate(operationClassTask, resources, nil, run, nil, nil)
+			if err != nil {
+				return InternalError(err)
+			}
+
+			return OperationResponse(op)
+		} else if err != sql.ErrNoRows {
+			return SmartError(err)
+		}
+	}
+
 	// Config override
 	sourceConfig := source.LocalConfig()
 
@@ -461,6 +722,25 @@ func createFromCopy(d *Daemon, req *api.ContainersPost) Response {
 		req.Profiles = source.Profiles()
 	}
 
+	// Storage pool override, the copy getting a local root disk device
+	// if the source's comes from a profile.
+	if req.Source.Pool != "" {
+		_, err := dbStoragePoolGetID(d.db, req.Source.Pool)
+		if err != nil {
+			return BadRequest(fmt.Errorf("The \"%s\" storage pool doesn't exist", req.Source.Pool))
+		}
+
+		req.Devices, err = containerDevicesWithPool(req.Devices, source.ExpandedDevices(), req.Source.Pool)
+		if err != nil {
+			return SmartError(err)
+		}
+	}
+
+	// Labels override
+	if req.Labels == nil {
+		req.Labels = source.Labels()
+	}
+
 	args := containerArgs{
 		Architecture: source.Architecture(),
 		BaseImage:    req.Source.BaseImage,
@@ -468,12 +748,36 @@ func createFromCopy(d *Daemon, req *api.ContainersPost) Response {
 		Ctype:        cTypeRegular,
 		Devices:      req.Devices,
 		Ephemeral:    req.Ephemeral,
+		Labels:       req.Labels,
 		Name:         req.Name,
 		Profiles:     req.Profiles,
 	}
 
 	run := func(op *operation) error {
-		_, err := containerCreateAsCopy(d, args, source, req.Source.ContainerOnly)
+		storageFreezeEnter()
+		defer storageFreezeLeave()
+
+		done, err := transferStart(transferCopy, req.Name)
+		if err != nil {
+			return err
+		}
+		defer done()
+
+		stages := operationStagesSet(op, containerCopyStages(req.Source.Quiesce, "copy")...)
+		defer stages.fail()
+
+		if req.Source.Quiesce {
+			stages.start("quiesce", 0)
+			thaw, err := containerQuiesce(source)
+			if err != nil {
+				return err
+			}
+			defer thaw()
+		}
+
+		stages.start("copy", 0)
+		_, err = containerCreateAsCopy(d, args, source, req.Source.ContainerOnly)
+		stages.finish(err)
 		if err != nil {
 			return err
 		}
@@ -491,9 +795,123 @@ func createFromCopy(d *Daemon, req *api.ContainersPost) Response {
 	return OperationResponse(op)
 }
 
+// createFromBackup creates the container stored in a backup tarball.
+func createFromBackup(d *Daemon, data io.Reader, pool string) Response {
+	// Store the backup on disk, it gets unpacked by the operation.
+	f, err := ioutil.TempFile(shared.VarPath("backups"), "lxd_backup_")
+	if err != nil {
+		return InternalError(err)
+	}
+	tarball := f.Name()
+
+	_, err = io.Copy(f, data)
+	f.Close()
+	if err != nil {
+		os.Remove(tarball)
+		return InternalError(err)
+	}
+
+	info, err := backupInfoRead(tarball)
+	if err != nil {
+		os.Remove(tarball)
+		return BadRequest(err)
+	}
+
+	_, err = dbContainerId(d.db, info.Name)
+	if err == nil {
+		os.Remove(tarball)
+		return BadRequest(fmt.Errorf("The container \"%s\" already exists", info.Name))
+	}
+
+	if err != sql.ErrNoRows {
+		os.Remove(tarball)
+		return SmartError(err)
+	}
+
+	pool, err = backupPool(d, *info, pool)
+	if err != nil {
+		os.Remove(tarball)
+		return BadRequest(err)
+	}
+
+	run := func(op *operation) error {
+		defer os.Remove(tarball)
+
+		storageFreezeEnter()
+		defer storageFreezeLeave()
+
+		s, err := storagePoolInit(d, pool)
+		if err != nil {
+			return err
+		}
+
+		path, err := backupUnpack(tarball)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(path)
+
+		err = backupVerify(*info, filepath.Join(path, "backup"))
+		if err != nil {
+			return err
+		}
+
+		err = s.ContainerBackupLoad(*info, filepath.Join(path, "backup"))
+		if err != nil {
+			return err
+		}
+
+		// Drivers without a native way of loading backups create the
+		// database records along with the storage.
+		_, err = dbContainerId(d.db, info.Name)
+		if err == nil {
+			return nil
+		}
+
+		if err != sql.ErrNoRows {
+			return err
+		}
+
+		// Otherwise the database records are recreated from the
+		// container's backup.yaml, as done by "lxd import".
+		err = backupFileRepool(d, info.Name, pool)
+		if err != nil {
+			return err
+		}
+
+		body, err := json.Marshal(&internalImportPost{Name: info.Name, Force: true})
+		if err != nil {
+			return err
+		}
+
+		resp := internalImport(d, &http.Request{Body: ioutil.NopCloser(bytes.NewReader(body))})
+		if resp.String() != "success" {
+			return fmt.Errorf("Failed to import the container: %s", resp.String())
+		}
+
+		return nil
+	}
+
+	resources := map[string][]string{}
+	resources["containers"] = []string{info.Name}
+
+	op, err := operationCreate(operationClassTask, resources, nil, run, nil, nil)
+	if err != nil {
+		os.Remove(tarball)
+		return InternalError(err)
+	}
+
+	return OperationResponse(op)
+}
+
 func containersPost(d *Daemon, r *http.Request) Response {
 	logger.Debugf("Responding to container create")
 
+	// Backups are uploaded as is rather than described in JSON.
+	if r.Header.Get("Content-Type") == "application/octet-stream" {
+		return createFromBackup(d, r.Body, r.Header.Get("X-LXD-pool"))
+	}
+
 	req := api.ContainersPost{}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		return BadRequest(err)
@@ -80,10 +80,14 @@ type Daemon struct {
 	pruneChan           chan bool
 	shutdownChan        chan bool
 	resetAutoUpdateChan chan bool
+	volatile            *volatileStore
 
 	TCPSocket  *Socket
 	UnixSocket *Socket
 
+	// PublicTCPSocket is the listener of the public image server.
+	PublicTCPSocket *Socket
+
 	devlxd *net.UnixListener
 
 	MockMode  bool
@@ -709,6 +713,9 @@ func (d *Daemon) Init() error {
 	if err := os.MkdirAll(shared.VarPath(), 0711); err != nil {
 		return err
 	}
+	if err := os.MkdirAll(shared.VarPath("backups"), 0700); err != nil {
+		return err
+	}
 	if err := os.MkdirAll(shared.CachePath(), 0700); err != nil {
 		return err
 	}
@@ -825,6 +832,9 @@ func (d *Daemon) Init() error {
 			return err
 		}
 
+		/* Run the environment self-test */
+		selfTestRun()
+
 		/* Restore simplestreams cache */
 		err = imageLoadStreamCache(d)
 		if err != nil {
@@ -954,7 +964,7 @@ func (d *Daemon) Init() error {
 		logger.Infof("LXD is socket activated")
 
 		for _, listener := range listeners {
-			if shared.PathExists(listener.Addr().String()) {
+			if listener.Addr().Network() == "unix" {
 				d.UnixSocket = &Socket{Socket: listener, CloseOnExit: false}
 			} else {
 				tlsListener := tls.NewListener(listener, d.tlsConfig)
@@ -963,7 +973,10 @@ func (d *Daemon) Init() error {
 		}
 	} else {
 		logger.Infof("LXD isn't socket activated")
+	}
 
+	// Only the HTTPS listener may have been passed by systemd.
+	if d.UnixSocket == nil {
 		localSocketPath := shared.VarPath("unix.socket")
 
 		// If the socket exists, let's try to connect to it and see if there's
@@ -1045,6 +1058,14 @@ func (d *Daemon) Init() error {
 		d.tomb.Go(func() error { return http.Serve(d.TCPSocket.Socket, &lxdHttpServer{d.mux, d}) })
 	}
 
+	publicAddr := daemonConfig["core.https_public_address"].Get()
+	if publicAddr != "" && !d.MockMode {
+		err := d.UpdateHTTPsPublicPort(publicAddr)
+		if err != nil {
+			logger.Error("cannot listen on public https socket, skipping...", log.Ctx{"err": err})
+		}
+	}
+
 	// Run the post initialization actions
 	if !d.MockMode && !d.SetupMode {
 		err := d.Ready()
@@ -1107,6 +1128,97 @@ func (d *Daemon) Ready() error {
 		}
 	}()
 
+	/* Check the default image servers which are down */
+	go func() {
+		for {
+			time.Sleep(imageServerRetryInterval)
+			imageServersHealthCheck(d)
+		}
+	}()
+
+	/* Watch the health of the ZFS storage pools */
+	go func() {
+		for {
+			zfsPoolHealthCheck(d)
+			time.Sleep(zfsPoolHealthInterval)
+		}
+	}()
+
+	/* Prune the expired snapshots */
+	go func() {
+		for {
+			pruneExpiredSnapshots(d)
+			time.Sleep(snapshotsExpiryInterval)
+		}
+	}()
+
+	/* Sample the usage of the storage pools */
+	go func() {
+		for {
+			storagePoolsUsageSample(d)
+			time.Sleep(storagePoolUsageInterval)
+		}
+	}()
+
+	/* Grow the loop-backed storage pools running out of space */
+	go func() {
+		for {
+			storagePoolsAutoGrowCheck(d)
+			time.Sleep(storagePoolAutoGrowInterval)
+		}
+	}()
+
+	/* Garbage collect the unused image datasets */
+	go func() {
+		for {
+			zfsImagesGCCheck(d)
+			time.Sleep(time.Hour)
+		}
+	}()
+
+	/* Start and stop the containers on their schedule */
+	go func() {
+		for {
+			containersScheduler.check(d)
+			time.Sleep(containersScheduleInterval)
+		}
+	}()
+
+	/* Back the containers up on their schedule */
+	go func() {
+		for {
+			backupsScheduler.check(d)
+			time.Sleep(backupsScheduleInterval)
+		}
+	}()
+
+	/* Watch the disk usage of the containers */
+	go func() {
+		for {
+			containersDiskAlertCheck(d)
+			time.Sleep(containerDiskAlertInterval)
+		}
+	}()
+
+	/* Replicate the containers on their schedule */
+	go func() {
+		for {
+			replicationScheduler.check(d)
+			time.Sleep(replicationScheduleInterval)
+		}
+	}()
+
+	/* Report file changes in the monitored ZFS containers */
+	go func() {
+		for {
+			zfsFileMonitorCheck(d)
+			time.Sleep(zfsFileMonitorTick)
+		}
+	}()
+
+	/* Account for the storage volumes used by running containers */
+	storagePoolVolumeRefsInit(d)
+
 	/* Restore containers */
 	containersRestart(d)
 
@@ -1164,7 +1276,7 @@ func (d *Daemon) Stop() error {
 
 	d.tomb.Kill(errStop)
 	logger.Infof("Stopping REST API handler:")
-	for _, socket := range []*Socket{d.TCPSocket, d.UnixSocket} {
+	for _, socket := range []*Socket{d.TCPSocket, d.PublicTCPSocket, d.UnixSocket} {
 		if socket == nil {
 			continue
 		}
@@ -1194,6 +1306,9 @@ func (d *Daemon) Stop() error {
 	}
 
 	logger.Infof("Closing the database")
+	if err := d.volatile.Close(); err != nil {
+		logger.Errorf("Failed to commit the volatile keys: %v", err)
+	}
 	d.db.Close()
 
 	logger.Infof("Saving simplestreams cache")
@@ -1268,6 +1383,16 @@ func (d *Daemon) ExpireLogs() error {
 	}
 
 	for _, entry := range entries {
+		// Operation logs expire on their own schedule
+		if entry.Name() == operationLogDir {
+			err := operationLogsExpire()
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
 		// Check if the container still exists
 		if shared.StringInSlice(entry.Name(), result) {
 			// Remove any log file which wasn't modified in the past 48 hours
@@ -1439,5 +1564,17 @@ func initializeDbObject(d *Daemon, path string) error {
 		return err
 	}
 
+	// Commit whatever volatile keys were left in the journal, it lives next
+	// to the database.
+	journalPath := ""
+	if path != ":memory:" {
+		journalPath = fmt.Sprintf("%s.volatile", path)
+	}
+
+	d.volatile, err = volatileStoreInit(d.db, journalPath)
+	if err != nil {
+		return fmt.Errorf("Error replaying the volatile keys journal: %s", err)
+	}
+
 	return nil
 }
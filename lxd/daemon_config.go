@@ -174,20 +174,49 @@ func (k *daemonConfigKey) GetInt64() int64 {
 func daemonConfigInit(db *sql.DB) error {
 	// Set all the keys
 	daemonConfig = map[string]*daemonConfigKey{
-		"core.https_address":             {valueType: "string", setter: daemonConfigSetAddress},
-		"core.https_allowed_headers":     {valueType: "string"},
-		"core.https_allowed_methods":     {valueType: "string"},
-		"core.https_allowed_origin":      {valueType: "string"},
-		"core.https_allowed_credentials": {valueType: "bool"},
-		"core.proxy_http":                {valueType: "string", setter: daemonConfigSetProxy},
-		"core.proxy_https":               {valueType: "string", setter: daemonConfigSetProxy},
-		"core.proxy_ignore_hosts":        {valueType: "string", setter: daemonConfigSetProxy},
-		"core.trust_password":            {valueType: "string", hiddenValue: true, setter: daemonConfigSetPassword},
-
-		"images.auto_update_cached":    {valueType: "bool", defaultValue: "true"},
-		"images.auto_update_interval":  {valueType: "int", defaultValue: "6"},
-		"images.compression_algorithm": {valueType: "string", validator: daemonConfigValidateCompression, defaultValue: "gzip"},
-		"images.remote_cache_expiry":   {valueType: "int", defaultValue: "10", trigger: daemonConfigTriggerExpiry},
+		"backups.s3.access_key": {valueType: "string"},
+		"backups.s3.endpoint":   {valueType: "string", defaultValue: "https://s3.amazonaws.com"},
+		"backups.s3.region":     {valueType: "string", defaultValue: "us-east-1"},
+		"backups.s3.secret_key": {valueType: "string", hiddenValue: true},
+		"backups.target":        {valueType: "string", validator: daemonConfigValidateBackupsTarget},
+
+		"core.https_address":              {valueType: "string", setter: daemonConfigSetAddress},
+		"core.https_public_address":       {valueType: "string", setter: daemonConfigSetPublicAddress},
+		"core.https_allowed_headers":      {valueType: "string"},
+		"core.https_allowed_methods":      {valueType: "string"},
+		"core.https_allowed_origin":       {valueType: "string"},
+		"core.https_allowed_credentials":  {valueType: "bool"},
+		"core.kernel_modules_allowed":     {valueType: "string", validator: daemonConfigValidateKernelModules},
+		"core.proxy_http":                 {valueType: "string", setter: daemonConfigSetProxy},
+		"core.proxy_https":                {valueType: "string", setter: daemonConfigSetProxy},
+		"core.proxy_ignore_hosts":         {valueType: "string", setter: daemonConfigSetProxy},
+		"core.shutdown_inhibit":           {valueType: "string", defaultValue: "block", validator: daemonConfigValidateShutdownInhibit},
+		"core.shutdown_transfers_timeout": {valueType: "int", defaultValue: "600"},
+		"core.trust_password":             {valueType: "string", hiddenValue: true, setter: daemonConfigSetPassword},
+		"core.unprivileged_only":          {valueType: "bool"},
+
+		"images.auto_update_cached":        {valueType: "bool", defaultValue: "true"},
+		"images.auto_update_interval":      {valueType: "int", defaultValue: "6"},
+		"images.compression_algorithm":     {valueType: "string", validator: daemonConfigValidateCompression, defaultValue: "gzip"},
+		"images.default_servers":           {valueType: "string", validator: daemonConfigValidateImageServers},
+		"images.remote_cache_expiry":       {valueType: "int", defaultValue: "10", trigger: daemonConfigTriggerExpiry},
+		"images.security.apparmor":         {valueType: "bool"},
+		"images.security.apparmor_allowed": {valueType: "string"},
+		"images.security.syscalls_allowed": {valueType: "string", validator: daemonConfigValidateImageSyscalls},
+
+		"storage.audit_retention":        {valueType: "int", defaultValue: "365"},
+		"storage.busy_retries":           {valueType: "int", defaultValue: "8"},
+		"storage.busy_retry_max_delay":   {valueType: "int", defaultValue: "5"},
+		"storage.forecast_horizon":       {valueType: "int", defaultValue: "30"},
+		"storage.history_size":           {valueType: "int", defaultValue: "100"},
+		"storage.hooks.post_copy":        {valueType: "string", validator: daemonConfigValidateStorageHook},
+		"storage.hooks.post_snapshot":    {valueType: "string", validator: daemonConfigValidateStorageHook},
+		"storage.hooks.pre_delete":       {valueType: "string", validator: daemonConfigValidateStorageHook},
+		"storage.migrations_incoming":    {valueType: "int", defaultValue: "0"},
+		"storage.migrations_outgoing":    {valueType: "int", defaultValue: "0"},
+		"storage.zfs_images_pool":        {valueType: "string", validator: daemonConfigValidateZfsImagesPool},
+		"storage.zfs_image_streams":      {valueType: "bool"},
+		"storage.zfs_images_gc_interval": {valueType: "int", defaultValue: "24"},
 
 		// Keys deprecated since the implementation of the storage api.
 		"storage.lvm_fstype":           {valueType: "string", defaultValue: "ext4", validValues: []string{"ext4", "xfs"}, validator: storageDeprecatedKeys},
@@ -313,6 +342,10 @@ func daemonConfigValidateCompression(d *Daemon, key string, value string) error
 	return err
 }
 
+func daemonConfigValidateShutdownInhibit(d *Daemon, key string, value string) error {
+	return shared.IsOneOf(value, []string{"block", "delay", "none"})
+}
+
 func storageDeprecatedKeys(d *Daemon, key string, value string) error {
 	if value == "" || daemonConfig[key].defaultValue == value {
 		return nil
@@ -91,8 +91,8 @@ func imageLoadStreamCache(d *Daemon) error {
 	return nil
 }
 
-// ImageDownload resolves the image fingerprint and if not in the database, downloads it
-func (d *Daemon) ImageDownload(op *operation, server string, protocol string, certificate string, secret string, alias string, forContainer bool, autoUpdate bool, storagePool string, preferCached bool) (*api.Image, error) {
+// imageDownload resolves the image fingerprint and if not in the database, downloads it from a single server
+func (d *Daemon) imageDownload(op *operation, server string, protocol string, certificate string, secret string, alias string, forContainer bool, autoUpdate bool, storagePool string, preferCached bool) (*api.Image, error) {
 	var err error
 	var ctxMap log.Ctx
 
@@ -267,7 +267,10 @@ func (d *Daemon) ImageDownload(op *operation, server string, protocol string, ce
 		// Import the image in the pool
 		logger.Debugf("Image does not exist on storage pool \"%s\".", storagePool)
 
+		stages := operationStagesSet(op, "unpack")
+		stages.start("unpack", 0)
 		err = imageCreateInPool(d, info, storagePool)
+		stages.finish(err)
 		if err != nil {
 			logger.Debugf("Failed to create image on storage pool \"%s\": %s.", storagePool, err)
 			return nil, err
@@ -326,6 +329,14 @@ func (d *Daemon) ImageDownload(op *operation, server string, protocol string, ce
 	}
 	logger.Info("Downloading image", ctxMap)
 
+	stageNames := []string{"download"}
+	if storagePool != "" {
+		stageNames = append(stageNames, "unpack")
+	}
+	stages := operationStagesSet(op, stageNames...)
+	defer stages.fail()
+	stages.start("download", 0)
+
 	// Cleanup any leftover from a past attempt
 	destDir := shared.VarPath("images")
 	destName := filepath.Join(destDir, fp)
@@ -335,6 +346,7 @@ func (d *Daemon) ImageDownload(op *operation, server string, protocol string, ce
 		if failure {
 			os.Remove(destName)
 			os.Remove(destName + ".rootfs")
+			os.Remove(zfsImageStreamPath(fp))
 		}
 	}
 	defer cleanup()
@@ -404,6 +416,19 @@ func (d *Daemon) ImageDownload(op *operation, server string, protocol string, ce
 			Canceler:        canceler,
 		}
 
+		// Only use the ZFS stream of images from LXD servers when streams
+		// are enabled here too.
+		var destStream *os.File
+		if protocol == "lxd" && zfsImageStreamsEnabled() {
+			destStream, err = os.Create(zfsImageStreamPath(fp))
+			if err != nil {
+				return nil, err
+			}
+			defer destStream.Close()
+
+			request.ZfsStreamFile = io.WriteSeeker(destStream)
+		}
+
 		if secret != "" {
 			resp, err = remote.GetPrivateImageFile(fp, secret, request)
 		} else {
@@ -420,6 +445,13 @@ func (d *Daemon) ImageDownload(op *operation, server string, protocol string, ce
 				return nil, err
 			}
 		}
+
+		if destStream != nil && resp.ZfsStreamSize == 0 {
+			err := os.Remove(zfsImageStreamPath(fp))
+			if err != nil {
+				return nil, err
+			}
+		}
 	} else if protocol == "direct" {
 		// Setup HTTP client
 		httpClient, err := d.httpClient(certificate)
@@ -452,6 +484,7 @@ func (d *Daemon) ImageDownload(op *operation, server string, protocol string, ce
 				Length: raw.ContentLength,
 				Handler: func(percent int64, speed int64) {
 					progress(lxd.ProgressData{Text: fmt.Sprintf("%d%% (%s/s)", percent, shared.GetByteSizeString(speed, 2))})
+					stages.progress(percent*raw.ContentLength/100, raw.ContentLength, speed)
 				},
 			},
 		}
@@ -512,6 +545,12 @@ func (d *Daemon) ImageDownload(op *operation, server string, protocol string, ce
 	// Image is in the DB now, don't wipe on-disk files on failure
 	failure = false
 
+	// Record its security policy, reading it from its metadata
+	_, err = imageSecurityPolicyGet(d, fp)
+	if err != nil {
+		return nil, err
+	}
+
 	if alias != fp {
 		id, _, err := dbImageGet(d.db, fp, false, true)
 		if err != nil {
@@ -526,12 +565,15 @@ func (d *Daemon) ImageDownload(op *operation, server string, protocol string, ce
 
 	// Import into the requested storage pool
 	if storagePool != "" {
+		stages.start("unpack", 0)
 		err = imageCreateInPool(d, info, storagePool)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	stages.finish(nil)
+
 	// Mark the image as "cached" if downloading for a container
 	if forContainer {
 		err := dbImageLastAccessInit(d.db, fp)
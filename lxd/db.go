@@ -51,8 +51,19 @@ CREATE TABLE IF NOT EXISTS containers (
     stateful INTEGER NOT NULL DEFAULT 0,
     creation_date DATETIME,
     last_use_date DATETIME,
+    expiry_date DATETIME,
     UNIQUE (name)
 );
+CREATE TABLE IF NOT EXISTS containers_backups (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    container_id INTEGER NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    creation_date DATETIME,
+    container_only INTEGER NOT NULL default 0,
+    optimized_storage INTEGER NOT NULL default 0,
+    FOREIGN KEY (container_id) REFERENCES containers (id) ON DELETE CASCADE,
+    UNIQUE (container_id, name)
+);
 CREATE TABLE IF NOT EXISTS containers_config (
     id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
     container_id INTEGER NOT NULL,
@@ -77,6 +88,14 @@ CREATE TABLE IF NOT EXISTS containers_devices_config (
     FOREIGN KEY (container_device_id) REFERENCES containers_devices (id) ON DELETE CASCADE,
     UNIQUE (container_device_id, key)
 );
+CREATE TABLE IF NOT EXISTS containers_labels (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    container_id INTEGER NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    value TEXT,
+    UNIQUE (container_id, key),
+    FOREIGN KEY (container_id) REFERENCES containers (id) ON DELETE CASCADE
+);
 CREATE TABLE IF NOT EXISTS containers_profiles (
     id INTEGER primary key AUTOINCREMENT NOT NULL,
     container_id INTEGER NOT NULL,
@@ -117,6 +136,14 @@ CREATE TABLE IF NOT EXISTS images_properties (
     value TEXT,
     FOREIGN KEY (image_id) REFERENCES images (id) ON DELETE CASCADE
 );
+CREATE TABLE IF NOT EXISTS images_security (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    image_id INTEGER NOT NULL,
+    syscalls TEXT NOT NULL,
+    apparmor TEXT NOT NULL,
+    UNIQUE (image_id),
+    FOREIGN KEY (image_id) REFERENCES images (id) ON DELETE CASCADE
+);
 CREATE TABLE IF NOT EXISTS images_source (
     id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
     image_id INTEGER NOT NULL,
@@ -182,6 +209,17 @@ CREATE TABLE IF NOT EXISTS schema (
     updated_at DATETIME NOT NULL,
     UNIQUE (version)
 );
+CREATE TABLE IF NOT EXISTS storage_audit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    date DATETIME NOT NULL,
+    pool VARCHAR(255) NOT NULL,
+    action VARCHAR(255) NOT NULL,
+    target VARCHAR(255) NOT NULL,
+    requestor VARCHAR(255) NOT NULL,
+    commands TEXT NOT NULL,
+    result VARCHAR(255) NOT NULL,
+    error TEXT
+);
 CREATE TABLE IF NOT EXISTS storage_pools (
     id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
     name VARCHAR(255) NOT NULL,
@@ -197,6 +235,14 @@ CREATE TABLE IF NOT EXISTS storage_pools_config (
     UNIQUE (storage_pool_id, key),
     FOREIGN KEY (storage_pool_id) REFERENCES storage_pools (id) ON DELETE CASCADE
 );
+CREATE TABLE IF NOT EXISTS storage_pools_labels (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    storage_pool_id INTEGER NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    value TEXT,
+    UNIQUE (storage_pool_id, key),
+    FOREIGN KEY (storage_pool_id) REFERENCES storage_pools (id) ON DELETE CASCADE
+);
 CREATE TABLE IF NOT EXISTS storage_volumes (
     id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
     name VARCHAR(255) NOT NULL,
@@ -213,6 +259,14 @@ CREATE TABLE IF NOT EXISTS storage_volumes_config (
     value TEXT,
     UNIQUE (storage_volume_id, key),
     FOREIGN KEY (storage_volume_id) REFERENCES storage_volumes (id) ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS storage_volumes_labels (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    storage_volume_id INTEGER NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    value TEXT,
+    UNIQUE (storage_volume_id, key),
+    FOREIGN KEY (storage_volume_id) REFERENCES storage_volumes (id) ON DELETE CASCADE
 );`
 
 func enableForeignKeys(conn *sqlite3.SQLiteConn) error {
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+/* Backups are keyed on the id of their container so that they follow it
+ * when it's renamed, their names are only unique per container.
+ */
+
+func dbContainerBackupCreate(db *sql.DB, args backupArgs) error {
+	containerID, err := dbContainerId(db, args.ContainerName)
+	if err != nil {
+		return err
+	}
+
+	containerOnlyInt := 0
+	if args.ContainerOnly {
+		containerOnlyInt = 1
+	}
+
+	optimizedStorageInt := 0
+	if args.OptimizedStorage {
+		optimizedStorageInt = 1
+	}
+
+	_, err = dbExec(db, "INSERT INTO containers_backups (container_id, name, creation_date, container_only, optimized_storage) VALUES (?, ?, ?, ?, ?)",
+		containerID, args.Name, args.CreationDate.Unix(), containerOnlyInt, optimizedStorageInt)
+	return err
+}
+
+func dbContainerBackupGet(db *sql.DB, containerName string, name string) (backupArgs, error) {
+	var created time.Time
+	containerOnlyInt := -1
+	optimizedStorageInt := -1
+
+	args := backupArgs{ContainerName: containerName, Name: name}
+
+	q := `SELECT containers_backups.creation_date, containers_backups.container_only, containers_backups.optimized_storage
+FROM containers_backups JOIN containers ON containers.id=containers_backups.container_id
+WHERE containers.name=? AND containers_backups.name=?`
+	arg1 := []interface{}{containerName, name}
+	arg2 := []interface{}{&created, &containerOnlyInt, &optimizedStorageInt}
+	err := dbQueryRowScan(db, q, arg1, arg2)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return args, NoSuchObjectError
+		}
+
+		return args, err
+	}
+
+	args.CreationDate = created
+	args.ContainerOnly = containerOnlyInt == 1
+	args.OptimizedStorage = optimizedStorageInt == 1
+
+	return args, nil
+}
+
+// dbContainerGetBackups returns the names of the backups of a container.
+func dbContainerGetBackups(db *sql.DB, containerName string) ([]string, error) {
+	result := []string{}
+
+	q := `SELECT containers_backups.name
+FROM containers_backups JOIN containers ON containers.id=containers_backups.container_id
+WHERE containers.name=? ORDER BY containers_backups.creation_date`
+	inargs := []interface{}{containerName}
+	outfmt := []interface{}{containerName}
+	dbResults, err := dbQueryScan(db, q, inargs, outfmt)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range dbResults {
+		result = append(result, r[0].(string))
+	}
+
+	return result, nil
+}
+
+func dbContainerBackupRemove(db *sql.DB, containerName string, name string) error {
+	containerID, err := dbContainerId(db, containerName)
+	if err != nil {
+		return err
+	}
+
+	_, err = dbExec(db, "DELETE FROM containers_backups WHERE container_id=? AND name=?", containerID, name)
+	return err
+}
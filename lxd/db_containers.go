@@ -53,6 +53,7 @@ func dbContainerId(db *sql.DB, name string) (int, error) {
 
 func dbContainerGet(db *sql.DB, name string) (containerArgs, error) {
 	var used *time.Time // Hold the db-returned time
+	var expiry *time.Time
 	description := sql.NullString{}
 
 	args := containerArgs{}
@@ -60,9 +61,9 @@ func dbContainerGet(db *sql.DB, name string) (containerArgs, error) {
 
 	ephemInt := -1
 	statefulInt := -1
-	q := "SELECT id, description, architecture, type, ephemeral, stateful, creation_date, last_use_date FROM containers WHERE name=?"
+	q := "SELECT id, description, architecture, type, ephemeral, stateful, creation_date, last_use_date, expiry_date FROM containers WHERE name=?"
 	arg1 := []interface{}{name}
-	arg2 := []interface{}{&args.Id, &description, &args.Architecture, &args.Ctype, &ephemInt, &statefulInt, &args.CreationDate, &used}
+	arg2 := []interface{}{&args.Id, &description, &args.Architecture, &args.Ctype, &ephemInt, &statefulInt, &args.CreationDate, &used, &expiry}
 	err := dbQueryRowScan(db, q, arg1, arg2)
 	if err != nil {
 		return args, err
@@ -88,12 +89,22 @@ func dbContainerGet(db *sql.DB, name string) (containerArgs, error) {
 		args.LastUsedDate = time.Unix(0, 0).UTC()
 	}
 
+	if expiry != nil {
+		args.ExpiryDate = *expiry
+	}
+
 	config, err := dbContainerConfig(db, args.Id)
 	if err != nil {
 		return args, err
 	}
 	args.Config = config
 
+	labels, err := dbContainerLabels(db, args.Id)
+	if err != nil {
+		return args, err
+	}
+	args.Labels = labels
+
 	profiles, err := dbContainerProfiles(db, args.Id)
 	if err != nil {
 		return args, err
@@ -138,14 +149,20 @@ func dbContainerCreate(db *sql.DB, args containerArgs) (int, error) {
 	args.CreationDate = time.Now().UTC()
 	args.LastUsedDate = time.Unix(0, 0).UTC()
 
-	str := fmt.Sprintf("INSERT INTO containers (name, architecture, type, ephemeral, creation_date, last_use_date, stateful) VALUES (?, ?, ?, ?, ?, ?, ?)")
+	// Only snapshots expire, leave the date unset otherwise.
+	var expiryDate interface{}
+	if !args.ExpiryDate.IsZero() {
+		expiryDate = args.ExpiryDate.Unix()
+	}
+
+	str := fmt.Sprintf("INSERT INTO containers (name, architecture, type, ephemeral, creation_date, last_use_date, expiry_date, stateful) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
 	stmt, err := tx.Prepare(str)
 	if err != nil {
 		tx.Rollback()
 		return 0, err
 	}
 	defer stmt.Close()
-	result, err := stmt.Exec(args.Name, args.Architecture, args.Ctype, ephemInt, args.CreationDate.Unix(), args.LastUsedDate.Unix(), statefulInt)
+	result, err := stmt.Exec(args.Name, args.Architecture, args.Ctype, ephemInt, args.CreationDate.Unix(), args.LastUsedDate.Unix(), expiryDate, statefulInt)
 	if err != nil {
 		tx.Rollback()
 		return 0, err
@@ -168,6 +185,11 @@ func dbContainerCreate(db *sql.DB, args containerArgs) (int, error) {
 		return 0, err
 	}
 
+	if err := dbContainerLabelsSet(tx, id, args.Labels); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
 	if err := dbDevicesAdd(tx, "container", int64(id), args.Devices); err != nil {
 		tx.Rollback()
 		return 0, err
@@ -334,43 +356,6 @@ func dbContainersList(db *sql.DB, cType containerType) ([]string, error) {
 	return ret, nil
 }
 
-func dbContainerSetState(db *sql.DB, id int, state string) error {
-	tx, err := dbBegin(db)
-	if err != nil {
-		return err
-	}
-
-	// Clear any existing entry
-	str := fmt.Sprintf("DELETE FROM containers_config WHERE container_id = ? AND key = 'volatile.last_state.power'")
-	stmt, err := tx.Prepare(str)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	defer stmt.Close()
-
-	if _, err := stmt.Exec(id); err != nil {
-		tx.Rollback()
-		return err
-	}
-
-	// Insert the new one
-	str = fmt.Sprintf("INSERT INTO containers_config (container_id, key, value) VALUES (?, 'volatile.last_state.power', ?)")
-	stmt, err = tx.Prepare(str)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	defer stmt.Close()
-
-	if _, err = stmt.Exec(id, state); err != nil {
-		tx.Rollback()
-		return err
-	}
-
-	return txCommit(tx)
-}
-
 func dbContainerRename(db *sql.DB, oldName string, newName string) error {
 	tx, err := dbBegin(db)
 	if err != nil {
@@ -445,6 +430,26 @@ func dbContainerGetSnapshots(db *sql.DB, name string) ([]string, error) {
 	return result, nil
 }
 
+// Get the names of the snapshots which expired by the given date.
+func dbContainerGetExpiredSnapshots(db *sql.DB, date time.Time) ([]string, error) {
+	result := []string{}
+
+	q := "SELECT name FROM containers WHERE type=? AND expiry_date IS NOT NULL AND expiry_date <= ? ORDER BY name"
+	inargs := []interface{}{cTypeSnapshot, date.Unix()}
+	var name string
+	outfmt := []interface{}{name}
+	dbResults, err := dbQueryScan(db, q, inargs, outfmt)
+	if err != nil {
+		return result, err
+	}
+
+	for _, r := range dbResults {
+		result = append(result, r[0].(string))
+	}
+
+	return result, nil
+}
+
 // Get the storage pool of a given container.
 func dbContainerPool(db *sql.DB, containerName string) (string, error) {
 	// Get container storage volume. Since container names are globally
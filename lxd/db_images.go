@@ -3,6 +3,7 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -105,6 +106,51 @@ func dbImageSourceGet(db *sql.DB, imageId int) (int, api.ImageSource, error) {
 
 }
 
+// dbImageSecurityPolicyGet returns the security policy recorded for an image,
+// nil if it has none and NoSuchObjectError if none was recorded.
+func dbImageSecurityPolicyGet(db *sql.DB, imageID int) (*imageSecurityPolicy, error) {
+	q := `SELECT syscalls, apparmor FROM images_security WHERE image_id=?`
+
+	syscalls := ""
+	apparmor := ""
+
+	arg1 := []interface{}{imageID}
+	arg2 := []interface{}{&syscalls, &apparmor}
+	err := dbQueryRowScan(db, q, arg1, arg2)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, NoSuchObjectError
+		}
+
+		return nil, err
+	}
+
+	if syscalls == "" && apparmor == "" {
+		return nil, nil
+	}
+
+	policy := &imageSecurityPolicy{Apparmor: apparmor}
+	if syscalls != "" {
+		policy.Syscalls = strings.Split(syscalls, ",")
+	}
+
+	return policy, nil
+}
+
+// dbImageSecurityPolicySet records the security policy of an image, policy
+// being nil if it has none.
+func dbImageSecurityPolicySet(db *sql.DB, imageID int, policy *imageSecurityPolicy) error {
+	syscalls := ""
+	apparmor := ""
+	if policy != nil {
+		syscalls = strings.Join(policy.Syscalls, ",")
+		apparmor = policy.Apparmor
+	}
+
+	_, err := dbExec(db, `INSERT OR REPLACE INTO images_security (image_id, syscalls, apparmor) VALUES (?, ?, ?)`, imageID, syscalls, apparmor)
+	return err
+}
+
 // Try to find a source entry of a locally cached image that matches
 // the given remote details (server, protocol and alias). Return the
 // fingerprint linked to the matching entry, if any.
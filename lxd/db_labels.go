@@ -0,0 +1,99 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+/* Labels are kept in one table per kind of entity (containers_labels,
+ * storage_pools_labels and storage_volumes_labels), each keyed on the id of
+ * the entity in the given column.
+ */
+
+// dbLabelsGet returns the labels of an entity.
+func dbLabelsGet(db *sql.DB, table string, column string, id int64) (map[string]string, error) {
+	var key, value string
+	query := fmt.Sprintf("SELECT key, value FROM %s WHERE %s=?", table, column)
+	inargs := []interface{}{id}
+	outargs := []interface{}{key, value}
+
+	results, err := dbQueryScan(db, query, inargs, outargs)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := map[string]string{}
+	for _, r := range results {
+		key = r[0].(string)
+		value = r[1].(string)
+
+		labels[key] = value
+	}
+
+	return labels, nil
+}
+
+// dbLabelsSet replaces the labels of an entity.
+func dbLabelsSet(tx *sql.Tx, table string, column string, id int64, labels map[string]string) error {
+	_, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s=?", table, column), id)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s, key, value) VALUES (?, ?, ?)", table, column))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for k, v := range labels {
+		_, err = stmt.Exec(id, k, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dbLabelsUpdate replaces the labels of an entity in their own transaction.
+func dbLabelsUpdate(db *sql.DB, table string, column string, id int64, labels map[string]string) error {
+	tx, err := dbBegin(db)
+	if err != nil {
+		return err
+	}
+
+	err = dbLabelsSet(tx, table, column, id, labels)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return txCommit(tx)
+}
+
+func dbContainerLabels(db *sql.DB, id int) (map[string]string, error) {
+	return dbLabelsGet(db, "containers_labels", "container_id", int64(id))
+}
+
+func dbContainerLabelsSet(tx *sql.Tx, id int, labels map[string]string) error {
+	return dbLabelsSet(tx, "containers_labels", "container_id", int64(id), labels)
+}
+
+func dbStoragePoolLabels(db *sql.DB, poolID int64) (map[string]string, error) {
+	return dbLabelsGet(db, "storage_pools_labels", "storage_pool_id", poolID)
+}
+
+func dbStoragePoolLabelsUpdate(db *sql.DB, poolID int64, labels map[string]string) error {
+	return dbLabelsUpdate(db, "storage_pools_labels", "storage_pool_id", poolID, labels)
+}
+
+func dbStorageVolumeLabels(db *sql.DB, volumeID int64) (map[string]string, error) {
+	return dbLabelsGet(db, "storage_volumes_labels", "storage_volume_id", volumeID)
+}
+
+func dbStorageVolumeLabelsUpdate(db *sql.DB, volumeID int64, labels map[string]string) error {
+	return dbLabelsUpdate(db, "storage_volumes_labels", "storage_volume_id", volumeID, labels)
+}
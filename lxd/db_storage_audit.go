@@ -0,0 +1,70 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/lxc/lxd/shared/api"
+)
+
+/* The audit entries aren't tied to the storage pools, they're kept after the
+ * pool they're about is deleted.
+ */
+
+func dbStorageAuditAdd(db *sql.DB, entry api.StorageAuditEntry) error {
+	commands, err := json.Marshal(entry.Commands)
+	if err != nil {
+		return err
+	}
+
+	_, err = dbExec(db, "INSERT INTO storage_audit (date, pool, action, target, requestor, commands, result, error) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		entry.Date.Unix(), entry.Pool, entry.Action, entry.Target, entry.Requestor, string(commands), entry.Result, entry.Error)
+	return err
+}
+
+// dbStorageAuditGet returns the audit entries of a storage pool, of all of
+// them if poolName is empty, most recent first.
+func dbStorageAuditGet(db *sql.DB, poolName string) ([]api.StorageAuditEntry, error) {
+	result := []api.StorageAuditEntry{}
+
+	q := `SELECT id, date, pool, action, target, requestor, commands, result, error
+FROM storage_audit WHERE ?='' OR pool=? ORDER BY id DESC`
+	inargs := []interface{}{poolName, poolName}
+	outfmt := []interface{}{int64(0), int64(0), "", "", "", "", "", "", ""}
+	dbResults, err := dbQueryScan(db, q, inargs, outfmt)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range dbResults {
+		entry := api.StorageAuditEntry{
+			ID:        r[0].(int64),
+			Date:      time.Unix(r[1].(int64), 0).UTC(),
+			Pool:      r[2].(string),
+			Action:    r[3].(string),
+			Target:    r[4].(string),
+			Requestor: r[5].(string),
+			Commands:  []string{},
+			Result:    r[7].(string),
+			Error:     r[8].(string),
+		}
+
+		err := json.Unmarshal([]byte(r[6].(string)), &entry.Commands)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, entry)
+	}
+
+	return result, nil
+}
+
+// dbStorageAuditPrune removes the audit entries older than before.
+func dbStorageAuditPrune(db *sql.DB, before time.Time) error {
+	_, err := dbExec(db, "DELETE FROM storage_audit WHERE date < ?", before.Unix())
+	return err
+}
@@ -97,12 +97,18 @@ func dbStoragePoolGet(db *sql.DB, poolName string) (int64, *api.StoragePool, err
 		return -1, nil, err
 	}
 
+	labels, err := dbStoragePoolLabels(db, poolID)
+	if err != nil {
+		return -1, nil, err
+	}
+
 	storagePool := api.StoragePool{
 		Name:   poolName,
 		Driver: poolDriver,
 	}
 	storagePool.Description = description.String
 	storagePool.Config = config
+	storagePool.Labels = labels
 
 	return poolID, &storagePool, nil
 }
@@ -337,6 +343,27 @@ func dbStoragePoolVolumesGetType(db *sql.DB, volumeType int, poolID int64) ([]st
 	return response, nil
 }
 
+// Get the names of the snapshots of a custom storage volume, oldest first.
+func dbStoragePoolVolumeSnapshotsGet(db *sql.DB, volumeName string, poolID int64) ([]string, error) {
+	var name string
+	prefix := volumeName + shared.SnapshotDelimiter
+	query := "SELECT name FROM storage_volumes WHERE storage_pool_id=? AND type=? AND SUBSTR(name,1,?)=? ORDER BY id"
+	inargs := []interface{}{poolID, storagePoolVolumeTypeCustom, len(prefix), prefix}
+	outargs := []interface{}{name}
+
+	result, err := dbQueryScan(db, query, inargs, outargs)
+	if err != nil {
+		return []string{}, err
+	}
+
+	response := []string{}
+	for _, r := range result {
+		response = append(response, r[0].(string))
+	}
+
+	return response, nil
+}
+
 // Get a single storage volume attached to a given storage pool of a given type.
 func dbStoragePoolVolumeGetType(db *sql.DB, volumeName string, volumeType int, poolID int64) (int64, *api.StorageVolume, error) {
 	volumeID, err := dbStoragePoolVolumeGetTypeID(db, volumeName, volumeType, poolID)
@@ -354,6 +381,11 @@ func dbStoragePoolVolumeGetType(db *sql.DB, volumeName string, volumeType int, p
 		return -1, nil, err
 	}
 
+	volumeLabels, err := dbStorageVolumeLabels(db, volumeID)
+	if err != nil {
+		return -1, nil, err
+	}
+
 	volumeTypeName, err := storagePoolVolumeTypeToName(volumeType)
 	if err != nil {
 		return -1, nil, err
@@ -365,6 +397,7 @@ func dbStoragePoolVolumeGetType(db *sql.DB, volumeName string, volumeType int, p
 	storageVolume.Name = volumeName
 	storageVolume.Description = volumeDescription
 	storageVolume.Config = volumeConfig
+	storageVolume.Labels = volumeLabels
 
 	return volumeID, &storageVolume, nil
 }
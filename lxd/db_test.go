@@ -423,6 +423,29 @@ func (s *dbTestSuite) Test_dbImageSourceGetCachedFingerprint_no_match() {
 	s.Equal(err, NoSuchObjectError)
 }
 
+func (s *dbTestSuite) Test_dbImageSecurityPolicy() {
+	imageID, _, err := dbImageGet(s.db, "fingerprint", false, false)
+	s.Nil(err)
+
+	_, err = dbImageSecurityPolicyGet(s.db, imageID)
+	s.Equal(err, NoSuchObjectError)
+
+	err = dbImageSecurityPolicySet(s.db, imageID, nil)
+	s.Nil(err)
+
+	policy, err := dbImageSecurityPolicyGet(s.db, imageID)
+	s.Nil(err)
+	s.Nil(policy)
+
+	err = dbImageSecurityPolicySet(s.db, imageID, &imageSecurityPolicy{Syscalls: []string{"kexec_load", "open_by_handle_at"}, Apparmor: "mount fstype=nfs,\n"})
+	s.Nil(err)
+
+	policy, err = dbImageSecurityPolicyGet(s.db, imageID)
+	s.Nil(err)
+	s.Equal(policy.Syscalls, []string{"kexec_load", "open_by_handle_at"})
+	s.Equal(policy.Apparmor, "mount fstype=nfs,\n")
+}
+
 func (s *dbTestSuite) Test_dbContainerConfig() {
 	var err error
 	var result map[string]string
@@ -442,6 +465,26 @@ func (s *dbTestSuite) Test_dbContainerConfig() {
 	}
 }
 
+func (s *dbTestSuite) Test_dbContainerLabels() {
+	tx, err := dbBegin(s.db)
+	s.Nil(err)
+
+	err = dbContainerLabelsSet(tx, 1, map[string]string{"ssd": "true", "env": ""})
+	s.Nil(err)
+	s.Nil(txCommit(tx))
+
+	tx, err = dbBegin(s.db)
+	s.Nil(err)
+
+	err = dbContainerLabelsSet(tx, 1, map[string]string{"ssd": "false"})
+	s.Nil(err)
+	s.Nil(txCommit(tx))
+
+	result, err := dbContainerLabels(s.db, 1)
+	s.Nil(err)
+	s.Equal(map[string]string{"ssd": "false"}, result)
+}
+
 func (s *dbTestSuite) Test_dbProfileConfig() {
 	var err error
 	var result map[string]string
@@ -66,6 +66,11 @@ var dbUpdates = []dbUpdate{
 	{version: 34, run: dbUpdateFromV33},
 	{version: 35, run: dbUpdateFromV34},
 	{version: 36, run: dbUpdateFromV35},
+	{version: 37, run: dbUpdateFromV36},
+	{version: 38, run: dbUpdateFromV37},
+	{version: 39, run: dbUpdateFromV38},
+	{version: 40, run: dbUpdateFromV39},
+	{version: 41, run: dbUpdateFromV40},
 }
 
 type dbUpdate struct {
@@ -135,6 +140,89 @@ func dbUpdatesApplyAll(db *sql.DB, doBackup bool, postApply func(int) error) err
 }
 
 // Schema updates begin here
+func dbUpdateFromV40(currentVersion int, version int, db *sql.DB) error {
+	stmt := `
+CREATE TABLE IF NOT EXISTS images_security (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    image_id INTEGER NOT NULL,
+    syscalls TEXT NOT NULL,
+    apparmor TEXT NOT NULL,
+    UNIQUE (image_id),
+    FOREIGN KEY (image_id) REFERENCES images (id) ON DELETE CASCADE
+);
+`
+	_, err := db.Exec(stmt)
+	return err
+}
+
+func dbUpdateFromV39(currentVersion int, version int, db *sql.DB) error {
+	stmt := `
+CREATE TABLE IF NOT EXISTS storage_audit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    date DATETIME NOT NULL,
+    pool VARCHAR(255) NOT NULL,
+    action VARCHAR(255) NOT NULL,
+    target VARCHAR(255) NOT NULL,
+    requestor VARCHAR(255) NOT NULL,
+    commands TEXT NOT NULL,
+    result VARCHAR(255) NOT NULL,
+    error TEXT
+);`
+	_, err := db.Exec(stmt)
+	return err
+}
+
+func dbUpdateFromV38(currentVersion int, version int, db *sql.DB) error {
+	_, err := db.Exec("ALTER TABLE containers ADD COLUMN expiry_date DATETIME;")
+	return err
+}
+
+func dbUpdateFromV37(currentVersion int, version int, db *sql.DB) error {
+	stmt := `
+CREATE TABLE IF NOT EXISTS containers_backups (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    container_id INTEGER NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    creation_date DATETIME,
+    container_only INTEGER NOT NULL default 0,
+    optimized_storage INTEGER NOT NULL default 0,
+    FOREIGN KEY (container_id) REFERENCES containers (id) ON DELETE CASCADE,
+    UNIQUE (container_id, name)
+);`
+	_, err := db.Exec(stmt)
+	return err
+}
+
+func dbUpdateFromV36(currentVersion int, version int, db *sql.DB) error {
+	stmt := `
+CREATE TABLE IF NOT EXISTS containers_labels (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    container_id INTEGER NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    value TEXT,
+    UNIQUE (container_id, key),
+    FOREIGN KEY (container_id) REFERENCES containers (id) ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS storage_pools_labels (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    storage_pool_id INTEGER NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    value TEXT,
+    UNIQUE (storage_pool_id, key),
+    FOREIGN KEY (storage_pool_id) REFERENCES storage_pools (id) ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS storage_volumes_labels (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    storage_volume_id INTEGER NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    value TEXT,
+    UNIQUE (storage_volume_id, key),
+    FOREIGN KEY (storage_volume_id) REFERENCES storage_volumes (id) ON DELETE CASCADE
+);`
+	_, err := db.Exec(stmt)
+	return err
+}
+
 func dbUpdateFromV35(currentVersion int, version int, db *sql.DB) error {
 	stmts := `
 CREATE TABLE tmp (
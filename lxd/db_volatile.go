@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+/* The volatile.* keys of containers are written by LXD itself, often several
+ * of them every time a container starts or stops. Rather than committing each
+ * of them to the database on its own, their changes are appended to a journal
+ * next to the database and committed together in a single transaction a moment
+ * later. The journal is replayed when the database is opened, so changes which
+ * hadn't been committed when LXD went away aren't lost.
+ *
+ * Containers loaded before a change is committed see it through Apply.
+ */
+
+// volatileFlushDelay is how long changes are collected before being committed.
+const volatileFlushDelay = 500 * time.Millisecond
+
+// volatileChange is a change of a volatile key, as written to the journal.
+type volatileChange struct {
+	ID     int    `json:"id"`
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Remove bool   `json:"remove,omitempty"`
+}
+
+type volatileStore struct {
+	db      *sql.DB
+	journal *os.File
+
+	lock    sync.Mutex
+	pending map[int]map[string]volatileChange
+	timer   *time.Timer
+}
+
+// volatileJournalParse returns the changes recorded in a journal. A trailing
+// partial or corrupted entry, left by a crash in the middle of a write, and
+// anything after it is ignored.
+func volatileJournalParse(data []byte) []volatileChange {
+	changes := []volatileChange{}
+
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+
+		change := volatileChange{}
+		err := json.Unmarshal(data[:i], &change)
+		if err != nil || change.Key == "" {
+			break
+		}
+
+		changes = append(changes, change)
+		data = data[i+1:]
+	}
+
+	return changes
+}
+
+// volatileStoreInit sets up the volatile store of the database, committing
+// whatever was left in its journal at path. An empty path disables the
+// journal, for in-memory databases.
+func volatileStoreInit(db *sql.DB, path string) (*volatileStore, error) {
+	s := &volatileStore{
+		db:      db,
+		pending: map[int]map[string]volatileChange{},
+	}
+
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	s.journal, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := volatileJournalParse(data)
+	if len(changes) > 0 {
+		logger.Debugf("Replaying %d volatile key changes from the journal", len(changes))
+	}
+
+	for _, change := range changes {
+		s.record(change)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	err = s.flush()
+	if err != nil {
+		s.journal.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// record adds a change to the pending ones, the lock must be held.
+func (s *volatileStore) record(change volatileChange) {
+	keys, ok := s.pending[change.ID]
+	if !ok {
+		keys = map[string]volatileChange{}
+		s.pending[change.ID] = keys
+	}
+
+	keys[change.Key] = change
+}
+
+// add journals a change and schedules its commit, the lock must be held.
+func (s *volatileStore) add(change volatileChange) error {
+	if s.journal != nil {
+		data, err := json.Marshal(&change)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.journal.Write(append(data, '\n'))
+		if err != nil {
+			return err
+		}
+
+		err = s.journal.Sync()
+		if err != nil {
+			return err
+		}
+	}
+
+	s.record(change)
+
+	if s.timer == nil {
+		s.timer = time.AfterFunc(volatileFlushDelay, s.flushTimer)
+	}
+
+	return nil
+}
+
+// Set sets a volatile key of a container.
+func (s *volatileStore) Set(id int, key string, value string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.add(volatileChange{ID: id, Key: key, Value: value})
+}
+
+// Remove removes a volatile key of a container.
+func (s *volatileStore) Remove(id int, key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.add(volatileChange{ID: id, Key: key, Remove: true})
+}
+
+// SetDefault sets a volatile key of a container unless something else already
+// set it, returning the value the key ends up with.
+func (s *volatileStore) SetDefault(id int, key string, value string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	change, ok := s.pending[id][key]
+	if ok && !change.Remove && change.Value != "" {
+		return change.Value, nil
+	}
+
+	if !ok {
+		current, err := dbContainerConfigGet(s.db, id, key)
+		if err == nil && current != "" {
+			return current, nil
+		}
+	}
+
+	err := s.add(volatileChange{ID: id, Key: key, Value: value})
+	if err != nil {
+		return "", err
+	}
+
+	return value, nil
+}
+
+// Apply applies the pending changes of a container to its configuration as
+// loaded from the database.
+func (s *volatileStore) Apply(id int, config map[string]string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for key, change := range s.pending[id] {
+		if change.Remove {
+			delete(config, key)
+		} else {
+			config[key] = change.Value
+		}
+	}
+}
+
+// Forget drops the pending changes of a container which is being deleted.
+func (s *volatileStore) Forget(id int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.pending, id)
+}
+
+// Flush commits the pending changes to the database.
+func (s *volatileStore) Flush() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.flush()
+}
+
+func (s *volatileStore) flushTimer() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.timer = nil
+
+	err := s.flush()
+	if err != nil {
+		logger.Warn("Failed to commit the volatile keys, will retry", log.Ctx{"err": err})
+		s.timer = time.AfterFunc(volatileFlushDelay, s.flushTimer)
+	}
+}
+
+// flush commits the pending changes and empties the journal, the lock must be
+// held. On failure the changes are kept for the next attempt.
+func (s *volatileStore) flush() error {
+	if len(s.pending) > 0 {
+		tx, err := dbBegin(s.db)
+		if err != nil {
+			return err
+		}
+
+		for id, keys := range s.pending {
+			for key, change := range keys {
+				if change.Remove {
+					_, err = tx.Exec("DELETE FROM containers_config WHERE container_id=? AND key=?", id, key)
+				} else {
+					// The container may have gone away since the change was
+					// made, in which case there's nothing to update.
+					_, err = tx.Exec("INSERT OR REPLACE INTO containers_config (container_id, key, value) SELECT id, ?, ? FROM containers WHERE id=?", key, change.Value, id)
+				}
+				if err != nil {
+					tx.Rollback()
+					return err
+				}
+			}
+		}
+
+		err = txCommit(tx)
+		if err != nil {
+			return err
+		}
+
+		s.pending = map[int]map[string]volatileChange{}
+	}
+
+	if s.journal != nil {
+		err := s.journal.Truncate(0)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close commits the pending changes and closes the journal.
+func (s *volatileStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+
+	err := s.flush()
+
+	if s.journal != nil {
+		s.journal.Close()
+		s.journal = nil
+	}
+
+	return err
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestVolatileJournalParse(t *testing.T) {
+	changes := volatileJournalParse([]byte(`{"id":1,"key":"volatile.eth0.hwaddr","value":"00:16:3e:00:00:01"}
+{"id":1,"key":"volatile.apply_template","remove":true}
+{"id":2,"key":"volatile.last_state.power","value":"RUNNING"}
+`))
+
+	if len(changes) != 3 {
+		t.Fatalf("Expected 3 changes, got %d", len(changes))
+	}
+
+	if changes[0].ID != 1 || changes[0].Key != "volatile.eth0.hwaddr" || changes[0].Value != "00:16:3e:00:00:01" || changes[0].Remove {
+		t.Errorf("Unexpected change: %+v", changes[0])
+	}
+
+	if changes[1].Key != "volatile.apply_template" || !changes[1].Remove {
+		t.Errorf("Unexpected change: %+v", changes[1])
+	}
+
+	if changes[2].ID != 2 || changes[2].Value != "RUNNING" {
+		t.Errorf("Unexpected change: %+v", changes[2])
+	}
+
+	torn := []string{
+		`{"id":1,"key":"volatile.eth0.name","value":"eth0"}` + "\n" + `{"id":1,"key":"vol`,
+		`{"id":1,"key":"volatile.eth0.name","value":"eth0"}` + "\n" + `{"id":1,"key":"vol` + "\n" + `{"id":1,"key":"volatile.eth0.name","value":"eth1"}` + "\n",
+		`{"id":1,"key":"volatile.eth0.name","value":"eth0"}` + "\n" + `{"id":1}` + "\n",
+	}
+
+	for _, data := range torn {
+		changes := volatileJournalParse([]byte(data))
+		if len(changes) != 1 || changes[0].Value != "eth0" {
+			t.Errorf("Expected only the first change of %q, got %+v", data, changes)
+		}
+	}
+}
@@ -1167,6 +1167,27 @@ func deviceGetParentBlocks(path string) ([]string, error) {
 	return devices, nil
 }
 
+// deviceGetBlockDisk returns the disk (major:minor) holding the given
+// partition or the block device itself if it isn't a partition.
+func deviceGetBlockDisk(block string) string {
+	sysPath := fmt.Sprintf("/sys/dev/block/%s", block)
+	if !shared.PathExists(filepath.Join(sysPath, "partition")) {
+		return block
+	}
+
+	devPath, err := filepath.EvalSymlinks(sysPath)
+	if err != nil {
+		return block
+	}
+
+	dev, err := ioutil.ReadFile(filepath.Join(filepath.Dir(devPath), "dev"))
+	if err != nil {
+		return block
+	}
+
+	return strings.TrimSpace(string(dev))
+}
+
 func deviceParseDiskLimit(readSpeed string, writeSpeed string) (int64, int64, int64, int64, error) {
 	parseValue := func(value string) (int64, int64, error) {
 		var err error
@@ -1206,6 +1227,94 @@ func deviceParseDiskLimit(readSpeed string, writeSpeed string) (int64, int64, in
 	return readBps, readIops, writeBps, writeIops, nil
 }
 
+// deviceBlockIO is the I/O done by a container on a block device.
+type deviceBlockIO struct {
+	readBytes  int64
+	writeBytes int64
+	readOps    int64
+	writeOps   int64
+}
+
+// deviceParseBlkioStats parses the "major:minor Read|Write|... value" lines
+// of the blkio.throttle.io_service_bytes and blkio.throttle.io_serviced
+// cgroup files into the I/O done on each block device.
+func deviceParseBlkioStats(serviceBytes string, serviced string) map[string]deviceBlockIO {
+	result := map[string]deviceBlockIO{}
+
+	for i, content := range []string{serviceBytes, serviced} {
+		for _, line := range strings.Split(content, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 3 || !strings.Contains(fields[0], ":") {
+				continue
+			}
+
+			value, err := strconv.ParseInt(fields[2], 10, 64)
+			if err != nil {
+				continue
+			}
+
+			counters := result[fields[0]]
+			switch {
+			case fields[1] == "Read" && i == 0:
+				counters.readBytes = value
+			case fields[1] == "Write" && i == 0:
+				counters.writeBytes = value
+			case fields[1] == "Read":
+				counters.readOps = value
+			case fields[1] == "Write":
+				counters.writeOps = value
+			default:
+				continue
+			}
+			result[fields[0]] = counters
+		}
+	}
+
+	return result
+}
+
+// deviceParseIoStat parses the "major:minor rbytes=... wbytes=... rios=...
+// wios=..." lines of the unified hierarchy's io.stat cgroup file into the I/O
+// done on each block device.
+func deviceParseIoStat(content string) map[string]deviceBlockIO {
+	result := map[string]deviceBlockIO{}
+
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.Contains(fields[0], ":") {
+			continue
+		}
+
+		counters := deviceBlockIO{}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+
+			value, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil {
+				continue
+			}
+
+			switch kv[0] {
+			case "rbytes":
+				counters.readBytes = value
+			case "wbytes":
+				counters.writeBytes = value
+			case "rios":
+				counters.readOps = value
+			case "wios":
+				counters.writeOps = value
+			}
+		}
+
+		result[fields[0]] = counters
+	}
+
+	return result
+}
+
 const USB_PATH = "/sys/bus/usb/devices"
 
 func loadRawValues(p string) (map[string]string, error) {
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDeviceParseBlkioStats(t *testing.T) {
+	serviceBytes := `8:16 Read 4096
+8:16 Write 8192
+8:16 Sync 12288
+8:16 Async 0
+8:16 Total 12288
+8:0 Read 1024
+8:0 Write 0
+8:0 Total 1024
+Total 13312`
+
+	serviced := `8:16 Read 1
+8:16 Write 2
+8:16 Total 3
+8:0 Read 1
+Total 4`
+
+	expected := map[string]deviceBlockIO{
+		"8:16": {readBytes: 4096, writeBytes: 8192, readOps: 1, writeOps: 2},
+		"8:0":  {readBytes: 1024, readOps: 1},
+	}
+
+	result := deviceParseBlkioStats(serviceBytes, serviced)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}
+
+func TestDeviceParseIoStat(t *testing.T) {
+	stat := `8:16 rbytes=4096 wbytes=8192 rios=1 wios=2 dbytes=0 dios=0
+253:0 rbytes=1024 wbytes=0 rios=1 wios=0`
+
+	expected := map[string]deviceBlockIO{
+		"8:16":  {readBytes: 4096, writeBytes: 8192, readOps: 1, writeOps: 2},
+		"253:0": {readBytes: 1024, readOps: 1},
+	}
+
+	result := deviceParseIoStat(stat)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}
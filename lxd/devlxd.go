@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -66,24 +67,88 @@ var configKeyGet = devLxdHandler{"/1.0/config/{key}", func(c container, r *http.
 	return okResponse(value, "raw")
 }}
 
-var metadataGet = devLxdHandler{"/1.0/meta-data", func(c container, r *http.Request) *devLxdResponse {
-	value := c.ExpandedConfig()["user.meta-data"]
-	return okResponse(fmt.Sprintf("#cloud-config\ninstance-id: %s\nlocal-hostname: %s\n%s", c.Name(), c.Name(), value), "raw")
-}}
+// cloudInitDefaultNetworkConfig is the network-config of the containers
+// without a user.network-config key, configuring eth0 through DHCP.
+const cloudInitDefaultNetworkConfig = `version: 1
+config:
+  - type: physical
+    name: eth0
+    subnets:
+      - type: dhcp
+        control: auto
+`
+
+// cloudInitData returns the cloud-init provisioning data of a container,
+// as served on /dev/lxd/sock and passed to the image templates, keyed by
+// their name: meta-data, user-data, vendor-data and network-config.
+func cloudInitData(name string, config map[string]string) map[string]string {
+	data := map[string]string{
+		"meta-data":      fmt.Sprintf("#cloud-config\ninstance-id: %s\nlocal-hostname: %s\n%s", name, name, config["user.meta-data"]),
+		"user-data":      "#cloud-config\n",
+		"vendor-data":    "#cloud-config\n",
+		"network-config": cloudInitDefaultNetworkConfig,
+	}
+
+	for _, key := range []string{"user-data", "vendor-data", "network-config"} {
+		if config["user."+key] != "" {
+			data[key] = config["user."+key]
+		}
+	}
+
+	return data
+}
+
+// cloudInitHandler serves one of the kinds of cloud-init provisioning data.
+func cloudInitHandler(key string) devLxdHandler {
+	return devLxdHandler{"/1.0/" + key, func(c container, r *http.Request) *devLxdResponse {
+		return okResponse(cloudInitData(c.Name(), c.ExpandedConfig())[key], "raw")
+	}}
+}
 
 var handlers = []devLxdHandler{
 	{"/", func(c container, r *http.Request) *devLxdResponse {
 		return okResponse([]string{"/1.0"}, "json")
 	}},
 	{"/1.0", func(c container, r *http.Request) *devLxdResponse {
+		if r.Method == "PATCH" {
+			return devLxdStatePatch(c, r)
+		}
+
 		return okResponse(shared.Jmap{"api_version": version.APIVersion}, "json")
 	}},
 	configGet,
 	configKeyGet,
-	metadataGet,
+	cloudInitHandler("meta-data"),
+	cloudInitHandler("user-data"),
+	cloudInitHandler("vendor-data"),
+	cloudInitHandler("network-config"),
 	/* TODO: events */
 }
 
+// devLxdStatePatch lets the container report its state, currently only
+// "Ready" once it's done booting.
+func devLxdStatePatch(c container, r *http.Request) *devLxdResponse {
+	req := struct {
+		State string `json:"state"`
+	}{}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return &devLxdResponse{err.Error(), http.StatusBadRequest, "raw"}
+	}
+
+	if req.State != "Ready" {
+		return &devLxdResponse{fmt.Sprintf("invalid state %q", req.State), http.StatusBadRequest, "raw"}
+	}
+
+	err = containerReadySet(c)
+	if err != nil {
+		return &devLxdResponse{err.Error(), http.StatusInternalServerError, "raw"}
+	}
+
+	return okResponse(shared.Jmap{}, "json")
+}
+
 func hoistReq(f func(container, *http.Request) *devLxdResponse, d *Daemon) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		conn := extractUnderlyingConn(w)
@@ -121,7 +186,7 @@ func hoistReq(f func(container, *http.Request) *devLxdResponse, d *Daemon) func(
 			WriteJSON(w, resp.content)
 		} else {
 			w.Header().Set("Content-Type", "application/octet-stream")
-			fmt.Fprintf(w, resp.content.(string))
+			fmt.Fprint(w, resp.content.(string))
 		}
 	}
 }
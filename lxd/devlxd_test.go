@@ -147,3 +147,26 @@ func TestHttpRequest(t *testing.T) {
 		t.Fatal("resp error not expected: ", string(resp))
 	}
 }
+
+func TestCloudInitData(t *testing.T) {
+	data := cloudInitData("c1", map[string]string{
+		"user.meta-data": "foo: bar\n",
+		"user.user-data": "#!/bin/sh\necho 100%\n",
+	})
+
+	if data["meta-data"] != "#cloud-config\ninstance-id: c1\nlocal-hostname: c1\nfoo: bar\n" {
+		t.Errorf("Unexpected meta-data: %q", data["meta-data"])
+	}
+
+	if data["user-data"] != "#!/bin/sh\necho 100%\n" {
+		t.Errorf("Unexpected user-data: %q", data["user-data"])
+	}
+
+	if data["vendor-data"] != "#cloud-config\n" {
+		t.Errorf("Unexpected vendor-data: %q", data["vendor-data"])
+	}
+
+	if data["network-config"] != cloudInitDefaultNetworkConfig {
+		t.Errorf("Unexpected network-config: %q", data["network-config"])
+	}
+}
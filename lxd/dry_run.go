@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os/exec"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+)
+
+// dryRunRequested returns whether the "dry-run" argument was passed, in which
+// case a destructive request is only checked and what it would do returned.
+func dryRunRequested(r *http.Request) bool {
+	return shared.IsTrue(r.URL.Query().Get("dry-run"))
+}
+
+// dryRunPlan returns an empty plan, so that it marshals to empty lists.
+func dryRunPlan() *api.OperationPlan {
+	return &api.OperationPlan{Actions: []string{}, Warnings: []string{}}
+}
+
+// containerDeletePlan returns what deleting a container or a snapshot would
+// do, failing like the deletion would if it can't be done.
+func containerDeletePlan(d *Daemon, c container) (*api.OperationPlan, error) {
+	plan := dryRunPlan()
+
+	s, err := storagePoolVolumeContainerLoadInit(d, c.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	if c.IsSnapshot() {
+		err = s.ContainerDeletePlan(c, plan)
+		if err != nil {
+			return nil, err
+		}
+
+		plan.Actions = append(plan.Actions, fmt.Sprintf("Remove the database record of snapshot %s", c.Name()))
+		return plan, nil
+	}
+
+	snapshots, err := c.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, snap := range snapshots {
+		snapStorage, err := storagePoolVolumeContainerLoadInit(d, snap.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		err = snapStorage.ContainerDeletePlan(snap, plan)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.ContainerDeletePlan(c, plan)
+	if err != nil {
+		return nil, err
+	}
+
+	backups, err := dbContainerGetBackups(d.db, c.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, backup := range backups {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("Delete backup %s", backup))
+	}
+
+	plan.Actions = append(plan.Actions, fmt.Sprintf("Remove the database record of container %s", c.Name()))
+
+	if c.IsEphemeral() {
+		plan.Warnings = append(plan.Warnings, "The container is ephemeral, it's deleted anyway when stopped")
+	}
+
+	return plan, nil
+}
+
+// storagePoolDeletePlan returns what deleting a storage pool would do, along
+// with removing the given images from it.
+func storagePoolDeletePlan(d *Daemon, poolName string, images []string) (*api.OperationPlan, error) {
+	plan := dryRunPlan()
+
+	for _, fingerprint := range images {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("Delete image %s from storage pool %s", fingerprint, poolName))
+	}
+
+	s, err := storagePoolInit(d, poolName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.StoragePoolDeletePlan(plan)
+	if err != nil {
+		return nil, err
+	}
+
+	plan.Actions = append(plan.Actions, fmt.Sprintf("Remove the database record of storage pool %s", poolName))
+	return plan, nil
+}
+
+// storagePoolVolumeDeletePlan returns what deleting a custom storage volume
+// would do.
+func storagePoolVolumeDeletePlan(d *Daemon, poolName string, volumeName string, volumeType int) (*api.OperationPlan, error) {
+	plan := dryRunPlan()
+
+	s, err := storagePoolVolumeInit(d, poolName, volumeName, volumeType)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.StoragePoolVolumeDeletePlan(plan)
+	if err != nil {
+		return nil, err
+	}
+
+	plan.Actions = append(plan.Actions, fmt.Sprintf("Remove the database record of storage volume %s", volumeName))
+	return plan, nil
+}
+
+// containerRestorePlan returns what restoring a container to one of its
+// snapshots would do.
+func containerRestorePlan(d *Daemon, c container, source container, stateful bool) (*api.OperationPlan, error) {
+	plan := dryRunPlan()
+
+	s, err := storagePoolVolumeContainerLoadInit(d, c.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.ContainerCanRestore(c, source)
+	if err != nil {
+		return nil, err
+	}
+
+	if stateful {
+		if !source.IsStateful() {
+			return nil, fmt.Errorf("The snapshot \"%s\" doesn't have any running state to restore", source.Name())
+		}
+
+		_, err := exec.LookPath("criu")
+		if err != nil {
+			return nil, fmt.Errorf("Failed to restore container state. CRIU isn't installed.")
+		}
+	}
+
+	if c.IsRunning() {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("Stop container %s", c.Name()))
+	}
+
+	err = s.ContainerRestorePlan(c, source, plan)
+	if err != nil {
+		return nil, err
+	}
+
+	plan.Actions = append(plan.Actions, fmt.Sprintf("Replace the configuration of container %s with the one of snapshot %s", c.Name(), source.Name()))
+
+	if stateful {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("Restore the running state of container %s", c.Name()))
+	} else if c.IsRunning() {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("Start container %s again", c.Name()))
+	}
+
+	return plan, nil
+}
+
+// containerMigratePlan returns what migrating a container away would send,
+// without connecting to the target.
+func containerMigratePlan(d *Daemon, c container, stateful bool, containerOnly bool) (*api.OperationPlan, error) {
+	plan := dryRunPlan()
+
+	s, err := storagePoolVolumeContainerLoadInit(d, c.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	live := stateful && c.IsRunning()
+	if live {
+		_, err := exec.LookPath("criu")
+		if err != nil {
+			return nil, fmt.Errorf("Unable to perform container live migration. CRIU isn't installed on the source server.")
+		}
+	}
+
+	if !containerOnly {
+		snapshots, err := c.Snapshots()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, snap := range snapshots {
+			plan.Actions = append(plan.Actions, fmt.Sprintf("Send snapshot %s", snap.Name()))
+		}
+	}
+
+	plan.Actions = append(plan.Actions, fmt.Sprintf("Send container %s using %s", c.Name(), s.MigrationType()))
+
+	if live {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("Checkpoint container %s with CRIU and send its state", c.Name()))
+		plan.Warnings = append(plan.Warnings, "The container is frozen while its state is sent")
+	} else if c.IsRunning() {
+		plan.Warnings = append(plan.Warnings, "The container is running, its filesystem is copied while in use")
+	}
+
+	if s.MigrationType() != MigrationFSType_RSYNC {
+		plan.Warnings = append(plan.Warnings, "The data is sent with rsync instead if the target uses a different storage driver")
+	}
+
+	return plan, nil
+}
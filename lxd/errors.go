@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+)
+
+// wrappedError gives context to an error while keeping it, so that errors
+// carrying more than their message, like commandError and storageError, can
+// still be found once wrapped on their way up.
+type wrappedError struct {
+	msg   string
+	cause error
+}
+
+func (e wrappedError) Error() string {
+	return e.msg
+}
+
+// errorWrapf returns an error with the message formatted as with fmt.Errorf,
+// wrapping err.
+func errorWrapf(err error, format string, args ...interface{}) error {
+	return wrappedError{msg: fmt.Sprintf(format, args...), cause: err}
+}
+
+// errorCauses returns err followed by the errors it wraps, innermost last.
+func errorCauses(err error) []error {
+	causes := []error{}
+	for err != nil {
+		causes = append(causes, err)
+
+		wrapped, ok := err.(wrappedError)
+		if !ok {
+			break
+		}
+
+		err = wrapped.cause
+	}
+
+	return causes
+}
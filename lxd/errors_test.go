@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestErrorCauses(t *testing.T) {
+	cmdErr := &commandError{command: "zfs recv", output: "cannot receive", err: fmt.Errorf("exit status 1")}
+
+	err := errorWrapf(cmdErr, "Error transferring container data: %s", cmdErr)
+	err = errorWrapf(err, "Migration failed: %s", err)
+
+	causes := errorCauses(err)
+	if len(causes) != 3 || causes[2] != cmdErr {
+		t.Fatalf("Unexpected causes: %v", causes)
+	}
+
+	if err.Error() != "Migration failed: Error transferring container data: Failed to run: zfs recv: exit status 1: cannot receive" {
+		t.Errorf("Unexpected message: %s", err)
+	}
+
+	causes = errorCauses(fmt.Errorf("plain"))
+	if len(causes) != 1 {
+		t.Errorf("Unexpected causes of a plain error: %v", causes)
+	}
+}
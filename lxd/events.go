@@ -71,6 +71,8 @@ func eventsSocket(r *http.Request, w http.ResponseWriter) error {
 
 	typeStr := r.FormValue("type")
 	if typeStr == "" {
+		// The other types have to be asked for, so that the existing
+		// clients don't get sent events they don't know about.
 		typeStr = "logging,operation"
 	}
 
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lxc/lxd/shared"
+)
+
+// idmapShiftExcludesParse splits a comma separated list of paths of a root
+// filesystem, dropping the empty ones.
+func idmapShiftExcludesParse(value string) []string {
+	paths := []string{}
+	for _, path := range strings.Split(value, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		path = filepath.Clean("/" + path)
+		if path != "/" && !shared.StringInSlice(path, paths) {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+// idmapShiftExcludes returns the paths of the root filesystem of a container
+// which are left alone when shifting it, the ones of the container along with
+// the ones of its image.
+func idmapShiftExcludes(config map[string]string) []string {
+	return idmapShiftExcludesParse(config["image.idmap.shift_exclude"] + "," + config["security.idmap.shift_exclude"])
+}
+
+// idmapShiftLastExcludes returns the paths the root filesystem of a container
+// was last shifted without, none if that wasn't recorded as it was shifted
+// before they could be set.
+func idmapShiftLastExcludes(config map[string]string) []string {
+	excludes := []string{}
+
+	last := config["volatile.last_state.idmap_shift_exclude"]
+	if last != "" {
+		err := json.Unmarshal([]byte(last), &excludes)
+		if err != nil {
+			return []string{}
+		}
+	}
+
+	return excludes
+}
+
+// idmapShiftExcludesToJSON returns the paths as stored in
+// "volatile.last_state.idmap_shift_exclude".
+func idmapShiftExcludesToJSON(excludes []string) (string, error) {
+	data, err := json.Marshal(excludes)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// idmapShiftSkipper returns the function skipping the given paths of a root
+// filesystem and their content when shifting it, nil if there are none.
+func idmapShiftSkipper(excludes []string) func(dir string, absPath string, fi os.FileInfo) bool {
+	if len(excludes) == 0 {
+		return nil
+	}
+
+	return func(dir string, absPath string, fi os.FileInfo) bool {
+		path := filepath.Clean("/" + strings.TrimPrefix(absPath, dir))
+		for _, exclude := range excludes {
+			if path == exclude || strings.HasPrefix(path, exclude+"/") {
+				return true
+			}
+		}
+
+		return false
+	}
+}
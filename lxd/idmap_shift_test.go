@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIdmapShiftExcludes(t *testing.T) {
+	config := map[string]string{
+		"image.idmap.shift_exclude":    "/srv/data, var/lib/db/",
+		"security.idmap.shift_exclude": "/srv/data,,/, /opt/../etc/shadow",
+	}
+
+	excludes := idmapShiftExcludes(config)
+	expected := []string{"/srv/data", "/var/lib/db", "/etc/shadow"}
+	if !reflect.DeepEqual(excludes, expected) {
+		t.Errorf("Got %v, expected %v", excludes, expected)
+	}
+
+	if len(idmapShiftLastExcludes(config)) != 0 {
+		t.Errorf("Expected no paths to have been left alone when none were recorded")
+	}
+
+	jsonExcludes, err := idmapShiftExcludesToJSON(excludes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config["volatile.last_state.idmap_shift_exclude"] = jsonExcludes
+	if !reflect.DeepEqual(idmapShiftLastExcludes(config), excludes) {
+		t.Errorf("Got %v back from %s", idmapShiftLastExcludes(config), jsonExcludes)
+	}
+}
+
+func TestIdmapShiftSkipper(t *testing.T) {
+	if idmapShiftSkipper([]string{}) != nil {
+		t.Errorf("Expected no skipper without paths")
+	}
+
+	skipper := idmapShiftSkipper([]string{"/srv/data", "/etc/shadow"})
+	tests := map[string]bool{
+		"/rootfs":              false,
+		"/rootfs/srv":          false,
+		"/rootfs/srv/data":     true,
+		"/rootfs/srv/data/a/b": true,
+		"/rootfs/srv/database": false,
+		"/rootfs/etc/shadow":   true,
+		"/rootfs/etc/shadow-":  false,
+		"/rootfs/etc/passwd":   false,
+	}
+
+	for path, skipped := range tests {
+		if skipper("/rootfs", path, nil) != skipped {
+			t.Errorf("%s: expected skipped to be %v", path, skipped)
+		}
+	}
+}
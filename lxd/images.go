@@ -216,6 +216,7 @@ type imageMetadata struct {
 	ExpiryDate   int64                     `yaml:"expiry_date"`
 	Properties   map[string]string         `yaml:"properties"`
 	Templates    map[string]*templateEntry `yaml:"templates"`
+	Security     *imageSecurityPolicy      `yaml:"security"`
 }
 
 /*
@@ -324,6 +325,25 @@ func imgPostContInfo(d *Daemon, r *http.Request, req api.ImagesPost, builddir st
 		return nil, err
 	}
 
+	// Record its security policy, reading it from the metadata it got
+	_, err = imageSecurityPolicyGet(d, info.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	// Have the ZFS stream of the image generated right away, so that it's
+	// available to the hosts downloading the image.
+	if zfsImageStreamsEnabled() && c.Storage() != nil && c.Storage().GetStorageType() == storageTypeZfs {
+		poolName, err := c.StoragePool()
+		if err == nil {
+			err = imageCreateInPool(d, &info, poolName)
+		}
+
+		if err != nil {
+			logger.Warn("Failed to create the published image on the container's storage pool", log.Ctx{"image": info.Fingerprint, "err": err})
+		}
+	}
+
 	return &info, nil
 }
 
@@ -634,6 +654,11 @@ func getImgPostInfo(d *Daemon, r *http.Request, builddir string, post *os.File)
 		return nil, err
 	}
 
+	err = imageSecurityPolicyStore(d, info.Fingerprint, imageMeta.Security)
+	if err != nil {
+		return nil, err
+	}
+
 	return &info, nil
 }
 
@@ -708,7 +733,7 @@ func imagesPost(d *Daemon, r *http.Request) Response {
 		imageUpload = true
 	}
 
-	if !imageUpload && !shared.StringInSlice(req.Source.Type, []string{"container", "snapshot", "image", "url"}) {
+	if !imageUpload && !shared.StringInSlice(req.Source.Type, []string{"container", "snapshot", "image", "url", "build"}) {
 		cleanup(builddir, post)
 		return InternalError(fmt.Errorf("Invalid images JSON"))
 	}
@@ -731,6 +756,9 @@ func imagesPost(d *Daemon, r *http.Request) Response {
 			} else if req.Source.Type == "url" {
 				/* Processing image copy from URL */
 				info, err = imgPostURLInfo(d, req, op)
+			} else if req.Source.Type == "build" {
+				/* Processing image build from a definition */
+				info, err = imgPostBuildInfo(d, req, builddir, op)
 			} else {
 				/* Processing image creation from container */
 				imagePublishLock.Lock()
@@ -997,6 +1025,15 @@ func autoUpdateImage(d *Daemon, op *operation, fingerprint string, id int, info
 		}
 	}
 
+	// Remove the ZFS stream of the image.
+	fname = zfsImageStreamPath(fingerprint)
+	if shared.PathExists(fname) {
+		err = os.Remove(fname)
+		if err != nil {
+			logger.Debugf("Error deleting image file %s: %s", fname, err)
+		}
+	}
+
 	// Remove the database entry for the image.
 	if err = dbImageDelete(d.db, id); err != nil {
 		logger.Debugf("Error deleting image from database %s: %s", fname, err)
@@ -1058,6 +1095,15 @@ func pruneExpiredImages(d *Daemon) {
 			}
 		}
 
+		// Remove the ZFS stream of the image.
+		fname = zfsImageStreamPath(fp)
+		if shared.PathExists(fname) {
+			err = os.Remove(fname)
+			if err != nil {
+				logger.Debugf("Error deleting image file %s: %s", fname, err)
+			}
+		}
+
 		imgID, _, err := dbImageGet(d.db, fp, false, false)
 		if err != nil {
 			logger.Debugf("Error retrieving image info %s: %s", fp, err)
@@ -1134,6 +1180,15 @@ func imageDelete(d *Daemon, r *http.Request) Response {
 			}
 		}
 
+		// Remove the ZFS stream of the image.
+		fname = zfsImageStreamPath(imgInfo.Fingerprint)
+		if shared.PathExists(fname) {
+			err = os.Remove(fname)
+			if err != nil {
+				logger.Debugf("Error deleting image file %s: %s", fname, err)
+			}
+		}
+
 		// Remove the database entry for the image.
 		return dbImageDelete(d.db, imgID)
 	}
@@ -1522,10 +1577,26 @@ func imageExport(d *Daemon, r *http.Request) Response {
 		return SmartError(err)
 	}
 
-	if !imgInfo.Public && public && !imageValidSecret(imgInfo.Fingerprint, secret) {
+	if !imgInfo.Public && public && !signedURLValid(r) && !imageValidSecret(imgInfo.Fingerprint, secret) {
 		return NotFound
 	}
 
+	// The ZFS stream isn't covered by the fingerprint, so it's fetched on
+	// its own rather than as part of the image.
+	if r.FormValue("type") == "zfs" {
+		streamPath := zfsImageStreamPath(imgInfo.Fingerprint)
+		if !shared.PathExists(streamPath) {
+			return NotFound
+		}
+
+		files := make([]fileResponseEntry, 1)
+		files[0].identifier = "zfs"
+		files[0].path = streamPath
+		files[0].filename = imgInfo.Fingerprint + ".zfs-stream"
+
+		return FileResponse(r, files, nil, false)
+	}
+
 	imagePath := shared.VarPath("images", imgInfo.Fingerprint)
 	rootfsPath := imagePath + ".rootfs"
 
@@ -1592,6 +1663,16 @@ func imageSecret(d *Daemon, r *http.Request) Response {
 	return OperationResponse(op)
 }
 
+func imageSignedURL(d *Daemon, r *http.Request) Response {
+	fingerprint := mux.Vars(r)["fingerprint"]
+	_, imgInfo, err := dbImageGet(d.db, fingerprint, false, false)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	return signedURLResponse(r, fmt.Sprintf("/%s/images/%s/export", version.APIVersion, imgInfo.Fingerprint))
+}
+
 func imageRefresh(d *Daemon, r *http.Request) Response {
 	fingerprint := mux.Vars(r)["fingerprint"]
 	imageId, imageInfo, err := dbImageGet(d.db, fingerprint, false, false)
@@ -1615,6 +1696,7 @@ func imageRefresh(d *Daemon, r *http.Request) Response {
 
 var imagesExportCmd = Command{name: "images/{fingerprint}/export", untrustedGet: true, get: imageExport}
 var imagesSecretCmd = Command{name: "images/{fingerprint}/secret", post: imageSecret}
+var imagesSignedURLCmd = Command{name: "images/{fingerprint}/signed-url", post: imageSignedURL}
 var imagesRefreshCmd = Command{name: "images/{fingerprint}/refresh", post: imageRefresh}
 
 var aliasesCmd = Command{name: "images/aliases", post: aliasesPost, get: aliasesGet}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// Builds are heavy on CPU and disk, only run one at a time.
+var imageBuildLock sync.Mutex
+
+// imageBuildHash adds the content of a file to the fingerprint of an image
+// and returns its size.
+func imageBuildHash(hash io.Writer, path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return io.Copy(hash, f)
+}
+
+// imageBuildImport registers the metadata tarball and the rootfs built by
+// distrobuilder as a new split image, like an upload of both would.
+func imageBuildImport(d *Daemon, req api.ImagesPost, metaPath string, rootfsPath string) (*api.Image, error) {
+	info := api.Image{}
+	info.Public = req.Public
+	info.AutoUpdate = req.AutoUpdate
+
+	hash := sha256.New()
+	for _, path := range []string{metaPath, rootfsPath} {
+		size, err := imageBuildHash(hash, path)
+		if err != nil {
+			return nil, err
+		}
+
+		info.Size += size
+	}
+
+	info.Fingerprint = fmt.Sprintf("%x", hash.Sum(nil))
+
+	imageMeta, err := getImageMetadata(metaPath)
+	if err != nil {
+		return nil, err
+	}
+
+	info.Architecture = imageMeta.Architecture
+	info.CreatedAt = time.Unix(imageMeta.CreationDate, 0)
+	info.ExpiresAt = time.Unix(imageMeta.ExpiryDate, 0)
+
+	info.Properties = imageMeta.Properties
+	if info.Properties == nil {
+		info.Properties = map[string]string{}
+	}
+
+	for key, value := range req.Properties {
+		info.Properties[key] = value
+	}
+
+	exists, err := dbImageExists(d.db, info.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		return nil, fmt.Errorf("Image with same fingerprint already exists")
+	}
+
+	err = shared.FileMove(metaPath, shared.VarPath("images", info.Fingerprint))
+	if err != nil {
+		return nil, err
+	}
+
+	err = shared.FileMove(rootfsPath, shared.VarPath("images", info.Fingerprint+".rootfs"))
+	if err != nil {
+		os.Remove(shared.VarPath("images", info.Fingerprint))
+		return nil, err
+	}
+
+	err = dbImageInsert(d.db, info.Fingerprint, info.Filename, info.Size, info.Public, info.AutoUpdate, info.Architecture, info.CreatedAt, info.ExpiresAt, info.Properties)
+	if err != nil {
+		os.Remove(shared.VarPath("images", info.Fingerprint))
+		os.Remove(shared.VarPath("images", info.Fingerprint+".rootfs"))
+		return nil, err
+	}
+
+	err = imageSecurityPolicyStore(d, info.Fingerprint, imageMeta.Security)
+	if err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// imgPostBuildInfo builds a new image from a distrobuilder definition. The
+// rootfs is built in the temporary build directory of the request, which is
+// removed along with everything distrobuilder downloaded once done.
+func imgPostBuildInfo(d *Daemon, req api.ImagesPost, builddir string, op *operation) (*api.Image, error) {
+	if req.Source.Definition == "" {
+		return nil, fmt.Errorf("No build definition provided")
+	}
+
+	_, err := exec.LookPath("distrobuilder")
+	if err != nil {
+		return nil, fmt.Errorf("Building images requires distrobuilder to be installed")
+	}
+
+	definitionPath := filepath.Join(builddir, "definition.yaml")
+	err = ioutil.WriteFile(definitionPath, []byte(req.Source.Definition), 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	targetDir := filepath.Join(builddir, "target")
+	err = os.Mkdir(targetDir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	imageBuildLock.Lock()
+	defer imageBuildLock.Unlock()
+
+	op.UpdateMetadata(map[string]interface{}{"build_status": "building"})
+
+	ctx := log.Ctx{"operation": op.id}
+	logger.Info("Building image", ctx)
+
+	_, err = shared.RunCommand("distrobuilder", "--cache-dir", filepath.Join(builddir, "cache"), "build-lxd", definitionPath, targetDir)
+	if err != nil {
+		ctx["err"] = err
+		logger.Error("Failed to build image", ctx)
+		return nil, err
+	}
+
+	logger.Info("Built image", ctx)
+	op.UpdateMetadata(map[string]interface{}{"build_status": "importing"})
+
+	return imageBuildImport(d, req, filepath.Join(targetDir, "lxd.tar.xz"), filepath.Join(targetDir, "rootfs.squashfs"))
+}
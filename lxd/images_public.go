@@ -0,0 +1,459 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/logger"
+	"github.com/lxc/lxd/shared/simplestreams"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// The public image server is a separate listener, set with
+// "core.https_public_address", which only serves the public images, through
+// the read-only image endpoints of the REST API and as a simplestreams
+// server. It never asks for client certificates, so even trusted clients
+// only get to see the public images there.
+
+// publicCommands are the endpoints of the REST API served by the public
+// image server, only their GET is.
+var publicCommands = []Command{
+	api10Cmd,
+	aliasCmd,
+	imagesCmd,
+	imageCmd,
+	imagesExportCmd,
+}
+
+var imagePublicFingerprintRegexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// imagePublicFiles describes the files of a split image, as listed in the
+// simplestreams manifest.
+type imagePublicFiles struct {
+	MetaSha256   string
+	MetaSize     int64
+	RootfsSha256 string
+	RootfsSize   int64
+	Squashfs     bool
+}
+
+// imagePublicFilesCache holds the hashes of the files of the images, which
+// never change.
+var imagePublicFilesCache = map[string]imagePublicFiles{}
+var imagePublicFilesCacheLock sync.Mutex
+
+func imagePublicFileHash(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", -1, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return "", -1, err
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil)), size, nil
+}
+
+// imagePublicFilesGet returns the files of an image, false if it isn't split
+// in a metadata tarball and a root filesystem, which simplestreams requires.
+func imagePublicFilesGet(fingerprint string) (imagePublicFiles, bool, error) {
+	imagePublicFilesCacheLock.Lock()
+	files, ok := imagePublicFilesCache[fingerprint]
+	imagePublicFilesCacheLock.Unlock()
+	if ok {
+		return files, true, nil
+	}
+
+	metaPath := shared.VarPath("images", fingerprint)
+	rootfsPath := metaPath + ".rootfs"
+	if !shared.PathExists(rootfsPath) {
+		return files, false, nil
+	}
+
+	var err error
+	files.MetaSha256, files.MetaSize, err = imagePublicFileHash(metaPath)
+	if err != nil {
+		return files, false, err
+	}
+
+	files.RootfsSha256, files.RootfsSize, err = imagePublicFileHash(rootfsPath)
+	if err != nil {
+		return files, false, err
+	}
+
+	_, ext, _ := detectCompression(rootfsPath)
+	files.Squashfs = ext == ".squashfs"
+
+	imagePublicFilesCacheLock.Lock()
+	imagePublicFilesCache[fingerprint] = files
+	imagePublicFilesCacheLock.Unlock()
+
+	return files, true, nil
+}
+
+// imagePublicProductName returns the name of the simplestreams product an
+// image is a version of.
+func imagePublicProductName(image api.Image) string {
+	osName := image.Properties["os"]
+	release := image.Properties["release"]
+	if osName == "" || release == "" {
+		// Images without os and release are products of their own.
+		return fmt.Sprintf("lxd:%s:%s:default", image.Fingerprint[0:12], image.Architecture)
+	}
+
+	variant := image.Properties["variant"]
+	if variant == "" {
+		variant = "default"
+	}
+
+	return fmt.Sprintf("%s:%s:%s:%s", strings.ToLower(osName), strings.ToLower(release), image.Architecture, variant)
+}
+
+// imagesPublicProducts returns the simplestreams products of the given split
+// images, whose files are in files.
+func imagesPublicProducts(images []api.Image, files map[string]imagePublicFiles) map[string]simplestreams.SimpleStreamsManifestProduct {
+	products := map[string]simplestreams.SimpleStreamsManifestProduct{}
+	aliases := map[string][]string{}
+
+	for _, image := range images {
+		imageFiles, ok := files[image.Fingerprint]
+		if !ok {
+			continue
+		}
+
+		name := imagePublicProductName(image)
+		product, ok := products[name]
+		if !ok {
+			product = simplestreams.SimpleStreamsManifestProduct{
+				Architecture:    image.Architecture,
+				OperatingSystem: image.Properties["os"],
+				Release:         image.Properties["release"],
+				ReleaseTitle:    image.Properties["release"],
+				Version:         image.Properties["version"],
+				Supported:       true,
+				Versions:        map[string]simplestreams.SimpleStreamsManifestProductVersion{},
+			}
+
+			if product.OperatingSystem == "" {
+				product.OperatingSystem = image.Properties["description"]
+			}
+		}
+
+		for _, alias := range image.Aliases {
+			if !shared.StringInSlice(alias.Name, aliases[name]) {
+				aliases[name] = append(aliases[name], alias.Name)
+			}
+		}
+
+		// Simplestreams clients take the creation date from the start
+		// of the version name.
+		prefix := fmt.Sprintf("images/%s", image.Fingerprint)
+		meta := simplestreams.SimpleStreamsManifestProductVersionItem{
+			Path:       prefix + "/lxd.tar.xz",
+			FileType:   "lxd.tar.xz",
+			HashSha256: imageFiles.MetaSha256,
+			Size:       imageFiles.MetaSize,
+		}
+
+		rootfs := simplestreams.SimpleStreamsManifestProductVersionItem{
+			HashSha256: imageFiles.RootfsSha256,
+			Size:       imageFiles.RootfsSize,
+		}
+
+		if imageFiles.Squashfs {
+			rootfs.Path = prefix + "/rootfs.squashfs"
+			rootfs.FileType = "squashfs"
+			meta.LXDHashSha256SquashFs = image.Fingerprint
+		} else {
+			rootfs.Path = prefix + "/root.tar.xz"
+			rootfs.FileType = "root.tar.xz"
+			meta.LXDHashSha256RootXz = image.Fingerprint
+		}
+
+		version := fmt.Sprintf("%s_%s", image.CreatedAt.UTC().Format("20060102_1504"), image.Fingerprint[0:12])
+		product.Versions[version] = simplestreams.SimpleStreamsManifestProductVersion{
+			Label: image.Properties["label"],
+			Items: map[string]simplestreams.SimpleStreamsManifestProductVersionItem{
+				meta.FileType:   meta,
+				rootfs.FileType: rootfs,
+			},
+		}
+
+		products[name] = product
+	}
+
+	for name, names := range aliases {
+		product := products[name]
+		sort.Strings(names)
+		product.Aliases = strings.Join(names, ",")
+		products[name] = product
+	}
+
+	return products
+}
+
+// imagesPublicManifest returns the simplestreams manifest of the public
+// images, leaving out those which aren't split.
+func imagesPublicManifest(d *Daemon) (*simplestreams.SimpleStreamsManifest, error) {
+	fingerprints, err := dbImagesGet(d.db, true)
+	if err != nil {
+		return nil, err
+	}
+
+	images := []api.Image{}
+	files := map[string]imagePublicFiles{}
+	for _, fingerprint := range fingerprints {
+		_, image, err := dbImageGet(d.db, fingerprint, true, true)
+		if err != nil {
+			continue
+		}
+
+		imageFiles, split, err := imagePublicFilesGet(fingerprint)
+		if err != nil {
+			logger.Warn("Failed to hash the files of the image", log.Ctx{"image": fingerprint, "err": err})
+			continue
+		}
+
+		if !split {
+			continue
+		}
+
+		images = append(images, *image)
+		files[fingerprint] = imageFiles
+	}
+
+	return &simplestreams.SimpleStreamsManifest{
+		Updated:  time.Now().UTC().Format(time.RFC1123Z),
+		DataType: "image-downloads",
+		Format:   "products:1.0",
+		Products: imagesPublicProducts(images, files),
+	}, nil
+}
+
+func imagesPublicRenderJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(data)
+	if err != nil {
+		logger.Errorf("Failed to write the simplestreams data: %s", err)
+	}
+}
+
+func imagesPublicStreamsIndex(d *Daemon, w http.ResponseWriter, r *http.Request) {
+	manifest, err := imagesPublicManifest(d)
+	if err != nil {
+		InternalError(err).Render(w)
+		return
+	}
+
+	products := []string{}
+	for name := range manifest.Products {
+		products = append(products, name)
+	}
+	sort.Strings(products)
+
+	imagesPublicRenderJSON(w, simplestreams.SimpleStreamsIndex{
+		Format:  "index:1.0",
+		Updated: manifest.Updated,
+		Index: map[string]simplestreams.SimpleStreamsIndexStream{
+			"images": {
+				DataType: "image-downloads",
+				Path:     "streams/v1/images.json",
+				Updated:  manifest.Updated,
+				Products: products,
+			},
+		},
+	})
+}
+
+func imagesPublicStreamsImages(d *Daemon, w http.ResponseWriter, r *http.Request) {
+	manifest, err := imagesPublicManifest(d)
+	if err != nil {
+		InternalError(err).Render(w)
+		return
+	}
+
+	imagesPublicRenderJSON(w, manifest)
+}
+
+func imagesPublicStreamsFile(d *Daemon, w http.ResponseWriter, r *http.Request) {
+	fingerprint := mux.Vars(r)["fingerprint"]
+	file := mux.Vars(r)["file"]
+
+	if !imagePublicFingerprintRegexp.MatchString(fingerprint) {
+		NotFound.Render(w)
+		return
+	}
+
+	_, _, err := dbImageGet(d.db, fingerprint, true, true)
+	if err != nil {
+		NotFound.Render(w)
+		return
+	}
+
+	path := shared.VarPath("images", fingerprint)
+	switch file {
+	case "lxd.tar.xz":
+	case "rootfs.squashfs", "root.tar.xz":
+		path += ".rootfs"
+	default:
+		NotFound.Render(w)
+		return
+	}
+
+	if !shared.PathExists(path) {
+		NotFound.Render(w)
+		return
+	}
+
+	files := []fileResponseEntry{{identifier: file, path: path, filename: file}}
+	err = FileResponse(r, files, nil, false).Render(w)
+	if err != nil {
+		logger.Errorf("Failed to send the image file: %s", err)
+	}
+}
+
+// createPublicCmd adds the GET of an endpoint of the REST API to the router
+// of the public image server.
+func (d *Daemon) createPublicCmd(router *mux.Router, c Command) {
+	uri := "/1.0"
+	if c.name != "" {
+		uri = fmt.Sprintf("/1.0/%s", c.name)
+	}
+
+	router.HandleFunc(uri, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != "GET" || c.get == nil {
+			Forbidden.Render(w)
+			return
+		}
+
+		logger.Debug("handling public GET", log.Ctx{"url": r.URL.RequestURI(), "ip": r.RemoteAddr})
+
+		err := c.get(d, r).Render(w)
+		if err != nil {
+			err := InternalError(err).Render(w)
+			if err != nil {
+				logger.Errorf("Failed writing error for error, giving up")
+			}
+		}
+	})
+}
+
+// publicRouter returns the router of the public image server.
+func (d *Daemon) publicRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.StrictSlash(false)
+
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		SyncResponse(true, []string{"/1.0"}).Render(w)
+	})
+
+	for _, c := range publicCommands {
+		d.createPublicCmd(router, c)
+	}
+
+	streams := map[string]func(d *Daemon, w http.ResponseWriter, r *http.Request){
+		"/streams/v1/index.json":       imagesPublicStreamsIndex,
+		"/streams/v1/images.json":      imagesPublicStreamsImages,
+		"/images/{fingerprint}/{file}": imagesPublicStreamsFile,
+	}
+
+	for uri, handler := range streams {
+		handler := handler
+		router.HandleFunc(uri, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "GET" {
+				Forbidden.Render(w)
+				return
+			}
+
+			handler(d, w, r)
+		})
+	}
+
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		NotFound.Render(w)
+	})
+
+	return router
+}
+
+// UpdateHTTPsPublicPort moves the public image server to a new address, an
+// empty one stopping it.
+func (d *Daemon) UpdateHTTPsPublicPort(newAddress string) error {
+	oldAddress := daemonConfig["core.https_public_address"].Get()
+	if oldAddress == newAddress && d.PublicTCPSocket != nil {
+		return nil
+	}
+
+	if d.PublicTCPSocket != nil {
+		d.PublicTCPSocket.Socket.Close()
+		d.PublicTCPSocket = nil
+	}
+
+	if newAddress == "" {
+		return nil
+	}
+
+	_, _, err := net.SplitHostPort(newAddress)
+	if err != nil {
+		ip := net.ParseIP(newAddress)
+		if ip != nil && ip.To4() == nil {
+			newAddress = fmt.Sprintf("[%s]:%s", newAddress, shared.DefaultPort)
+		} else {
+			newAddress = fmt.Sprintf("%s:%s", newAddress, shared.DefaultPort)
+		}
+	}
+
+	// No client certificate is asked for, all clients are untrusted.
+	tlsConfig := d.tlsConfig.Clone()
+	tlsConfig.ClientAuth = tls.NoClientCert
+	tlsConfig.ClientCAs = nil
+
+	tcpl, err := tls.Listen("tcp", newAddress, tlsConfig)
+	if err != nil {
+		return fmt.Errorf("cannot listen on public https socket: %v", err)
+	}
+
+	logger.Info(" - binding public image server TCP socket", log.Ctx{"socket": tcpl.Addr()})
+	router := d.publicRouter()
+	d.tomb.Go(func() error { return http.Serve(tcpl, &lxdHttpServer{router, d}) })
+	d.PublicTCPSocket = &Socket{Socket: tcpl, CloseOnExit: true}
+
+	return nil
+}
+
+func daemonConfigSetPublicAddress(d *Daemon, key string, value string) (string, error) {
+	if value != "" && value == daemonConfig["core.https_address"].Get() {
+		return "", fmt.Errorf("The public image server can't share the address of the REST API")
+	}
+
+	err := d.UpdateHTTPsPublicPort(value)
+	if err != nil {
+		return "", err
+	}
+
+	return value, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/simplestreams"
+)
+
+func TestImagesPublicProducts(t *testing.T) {
+	created := time.Date(2017, 7, 3, 9, 41, 0, 0, time.UTC)
+	fingerprint1 := strings.Repeat("a", 64)
+	fingerprint2 := strings.Repeat("b", 64)
+	fingerprint3 := strings.Repeat("c", 64)
+
+	images := []api.Image{
+		{
+			ImagePut:     api.ImagePut{Properties: map[string]string{"os": "Ubuntu", "release": "xenial"}},
+			Aliases:      []api.ImageAlias{{Name: "xenial"}},
+			Architecture: "x86_64",
+			Fingerprint:  fingerprint1,
+			CreatedAt:    created,
+		},
+		{
+			ImagePut:     api.ImagePut{Properties: map[string]string{"description": "custom"}},
+			Architecture: "x86_64",
+			Fingerprint:  fingerprint2,
+			CreatedAt:    created,
+		},
+		{
+			// Not split, left out.
+			ImagePut:     api.ImagePut{Properties: map[string]string{"os": "Alpine", "release": "3.6"}},
+			Architecture: "x86_64",
+			Fingerprint:  fingerprint3,
+			CreatedAt:    created,
+		},
+	}
+
+	files := map[string]imagePublicFiles{
+		fingerprint1: {MetaSha256: "m1", MetaSize: 10, RootfsSha256: "r1", RootfsSize: 100, Squashfs: true},
+		fingerprint2: {MetaSha256: "m2", MetaSize: 20, RootfsSha256: "r2", RootfsSize: 200},
+	}
+
+	products := imagesPublicProducts(images, files)
+	if len(products) != 2 {
+		t.Fatalf("Expected 2 products, got %d", len(products))
+	}
+
+	product, ok := products["ubuntu:xenial:x86_64:default"]
+	if !ok || product.Aliases != "xenial" {
+		t.Errorf("Unexpected products: %v", products)
+	}
+
+	// What a simplestreams client makes of them.
+	manifest := simplestreams.SimpleStreamsManifest{Products: products}
+	parsed, downloads := manifest.ToLXD()
+	if len(parsed) != 2 {
+		t.Fatalf("Expected the client to find 2 images, got %d", len(parsed))
+	}
+
+	for _, image := range parsed {
+		if image.Fingerprint != fingerprint1 && image.Fingerprint != fingerprint2 {
+			t.Errorf("Unexpected fingerprint: %s", image.Fingerprint)
+		}
+
+		if !image.CreatedAt.Equal(time.Date(2017, 7, 3, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("Unexpected creation date: %s", image.CreatedAt)
+		}
+	}
+
+	if downloads[fingerprint1][1][0] != "images/"+fingerprint1+"/rootfs.squashfs" {
+		t.Errorf("Unexpected root filesystem path: %v", downloads[fingerprint1])
+	}
+
+	if downloads[fingerprint2][1][0] != "images/"+fingerprint2+"/root.tar.xz" {
+		t.Errorf("Unexpected root filesystem path: %v", downloads[fingerprint2])
+	}
+}
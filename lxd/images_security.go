@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// imageSecurityPolicy is the "security" section of the metadata of an image,
+// what the workload it ships needs on top of the default confinement.
+type imageSecurityPolicy struct {
+	// The syscalls the workload requires, only those blocked by default
+	// matter.
+	Syscalls []string `yaml:"syscalls"`
+
+	// AppArmor rules to add to the profile of the containers.
+	Apparmor string `yaml:"apparmor"`
+}
+
+var syscallNameRegexp = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// imageSecuritySyscallsParse splits a comma separated list of syscalls.
+func imageSecuritySyscallsParse(value string) ([]string, error) {
+	syscalls := []string{}
+	for _, syscall := range strings.Split(value, ",") {
+		syscall = strings.TrimSpace(syscall)
+		if syscall == "" {
+			continue
+		}
+
+		if !syscallNameRegexp.MatchString(syscall) {
+			return nil, fmt.Errorf("Invalid syscall name: %s", syscall)
+		}
+
+		syscalls = append(syscalls, syscall)
+	}
+
+	return syscalls, nil
+}
+
+// imageSecurityApparmorParse splits AppArmor rules, one per line, with their
+// whitespace normalized so that they can be compared. Empty lines and
+// comments are left out.
+func imageSecurityApparmorParse(value string) []string {
+	rules := []string{}
+	for _, line := range strings.Split(value, "\n") {
+		rule := strings.Join(strings.Fields(line), " ")
+		if rule == "" || strings.HasPrefix(rule, "#") {
+			continue
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+func daemonConfigValidateImageSyscalls(d *Daemon, key string, value string) error {
+	_, err := imageSecuritySyscallsParse(value)
+	return err
+}
+
+// seccompDefaultRules returns the rules of the default syscall blacklist,
+// indexed by syscall, along with the syscalls in the order they're listed.
+func seccompDefaultRules() (map[string]string, []string) {
+	rules := map[string]string{}
+	syscalls := []string{}
+
+	for _, line := range strings.Split(DEFAULT_SECCOMP_POLICY, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[1] == "#" {
+			continue
+		}
+
+		rules[fields[0]] = line
+		syscalls = append(syscalls, fields[0])
+	}
+
+	return rules, syscalls
+}
+
+// imageSecurityPolicyConfig returns the container configuration keys applying
+// the policy of an image on top of config, the configuration the container
+// gets from its profiles and its own keys, and what of the policy was left
+// out. Syscalls are only unblocked if in allowedSyscalls and AppArmor rules
+// only added if allowApparmor is set and they're in allowedApparmor.
+func imageSecurityPolicyConfig(policy *imageSecurityPolicy, config map[string]string, allowedSyscalls []string, allowApparmor bool, allowedApparmor []string) (map[string]string, []string) {
+	changes := map[string]string{}
+	skipped := []string{}
+
+	rules, defaultSyscalls := seccompDefaultRules()
+
+	unblock := []string{}
+	for _, syscall := range policy.Syscalls {
+		_, blocked := rules[syscall]
+		if !blocked || shared.StringInSlice(syscall, unblock) {
+			continue
+		}
+
+		if !shared.StringInSlice(syscall, allowedSyscalls) {
+			skipped = append(skipped, fmt.Sprintf("syscall %s isn't in images.security.syscalls_allowed", syscall))
+			continue
+		}
+
+		unblock = append(unblock, syscall)
+	}
+
+	if len(unblock) > 0 {
+		custom := false
+		for _, key := range []string{"raw.seccomp", "security.syscalls.whitelist", "security.syscalls.blacklist", "security.syscalls.blacklist_default"} {
+			_, ok := config[key]
+			if ok {
+				custom = true
+				break
+			}
+		}
+
+		if custom {
+			skipped = append(skipped, fmt.Sprintf("syscalls %s, the container sets its own syscall policy", strings.Join(unblock, ", ")))
+		} else {
+			// Replace the default blacklist with the same rules,
+			// minus the syscalls the image requires.
+			blacklist := []string{"reject_force_umount"}
+			for _, syscall := range defaultSyscalls {
+				if !shared.StringInSlice(syscall, unblock) {
+					blacklist = append(blacklist, rules[syscall])
+				}
+			}
+
+			changes["security.syscalls.blacklist_default"] = "false"
+			changes["security.syscalls.blacklist"] = strings.Join(blacklist, "\n")
+		}
+	}
+
+	apparmorRules := imageSecurityApparmorParse(policy.Apparmor)
+	if len(apparmorRules) > 0 && !allowApparmor {
+		skipped = append(skipped, "AppArmor rules, images.security.apparmor isn't set")
+		apparmorRules = nil
+	}
+
+	allowedRules := []string{}
+	for _, rule := range apparmorRules {
+		if !shared.StringInSlice(rule, allowedApparmor) {
+			skipped = append(skipped, fmt.Sprintf("AppArmor rule \"%s\" isn't in images.security.apparmor_allowed", rule))
+			continue
+		}
+
+		allowedRules = append(allowedRules, rule)
+	}
+
+	apparmor := strings.Join(allowedRules, "\n")
+	if apparmor != "" {
+		if config["raw.apparmor"] != "" {
+			changes["raw.apparmor"] = fmt.Sprintf("%s\n%s", strings.TrimRight(config["raw.apparmor"], "\n"), apparmor)
+		} else {
+			changes["raw.apparmor"] = apparmor
+		}
+	}
+
+	return changes, skipped
+}
+
+// imageSecurityPolicyApply adds the security policy shipped in the metadata
+// of an image to the configuration of a container being created from it, as
+// far as "images.security.syscalls_allowed", "images.security.apparmor" and
+// "images.security.apparmor_allowed" allow.
+func imageSecurityPolicyApply(d *Daemon, args *containerArgs, hash string) error {
+	allowedSyscalls, err := imageSecuritySyscallsParse(daemonConfig["images.security.syscalls_allowed"].Get())
+	if err != nil {
+		return err
+	}
+
+	allowApparmor := daemonConfig["images.security.apparmor"].GetBool()
+	allowedApparmor := imageSecurityApparmorParse(daemonConfig["images.security.apparmor_allowed"].Get())
+	if len(allowedSyscalls) == 0 && !allowApparmor {
+		return nil
+	}
+
+	policy, err := imageSecurityPolicyGet(d, hash)
+	if err != nil {
+		return err
+	}
+
+	if policy == nil {
+		return nil
+	}
+
+	// What the container ends up with, its own keys overriding the ones
+	// of its profiles.
+	config := map[string]string{}
+	for _, profile := range args.Profiles {
+		profileConfig, err := dbProfileConfig(d.db, profile)
+		if err != nil {
+			return err
+		}
+
+		for k, v := range profileConfig {
+			config[k] = v
+		}
+	}
+
+	for k, v := range args.Config {
+		config[k] = v
+	}
+
+	changes, skipped := imageSecurityPolicyConfig(policy, config, allowedSyscalls, allowApparmor, allowedApparmor)
+	for _, reason := range skipped {
+		logger.Warn("Left out part of the security policy of the image", log.Ctx{"container": args.Name, "image": hash, "reason": reason})
+	}
+
+	for k, v := range changes {
+		args.Config[k] = v
+	}
+
+	if len(changes) > 0 {
+		logger.Info("Applied the security policy of the image", log.Ctx{"container": args.Name, "image": hash})
+	}
+
+	return nil
+}
+
+// imageSecurityPolicyStore records the security policy of an image as it's
+// imported, so that creating containers from it doesn't need to extract it
+// from its metadata again.
+func imageSecurityPolicyStore(d *Daemon, fingerprint string, policy *imageSecurityPolicy) error {
+	id, _, err := dbImageGet(d.db, fingerprint, false, true)
+	if err != nil {
+		return err
+	}
+
+	return dbImageSecurityPolicySet(d.db, id, policy)
+}
+
+// imageSecurityPolicyGet returns the security policy of an image, nil if it
+// has none. It's read from the metadata of the images imported before it got
+// recorded, and recorded then.
+func imageSecurityPolicyGet(d *Daemon, fingerprint string) (*imageSecurityPolicy, error) {
+	id, _, err := dbImageGet(d.db, fingerprint, false, true)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := dbImageSecurityPolicyGet(d.db, id)
+	if err != NoSuchObjectError {
+		return policy, err
+	}
+
+	metadata, err := getImageMetadata(shared.VarPath("images", fingerprint))
+	if err != nil {
+		return nil, err
+	}
+
+	err = dbImageSecurityPolicySet(d.db, id, metadata.Security)
+	if err != nil {
+		logger.Warn("Failed to record the security policy of the image", log.Ctx{"image": fingerprint, "err": err})
+	}
+
+	return metadata.Security, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestImageSecurityPolicyConfig(t *testing.T) {
+	policy := &imageSecurityPolicy{
+		Syscalls: []string{"open_by_handle_at", "keyctl", "kexec_load"},
+		Apparmor: "mount fstype=nfs,\n",
+	}
+
+	// Nothing allowed.
+	changes, skipped := imageSecurityPolicyConfig(policy, map[string]string{}, nil, false, nil)
+	if len(changes) != 0 {
+		t.Errorf("Expected no changes, got %v", changes)
+	}
+
+	if len(skipped) != 3 {
+		t.Errorf("Expected the two blocked syscalls and the AppArmor rules to be left out, got %v", skipped)
+	}
+
+	// Some syscalls and the AppArmor rules allowed.
+	changes, skipped = imageSecurityPolicyConfig(policy, map[string]string{"raw.apparmor": "ptrace,"}, []string{"open_by_handle_at"}, true, []string{"mount fstype=nfs,"})
+	if len(skipped) != 1 || !strings.Contains(skipped[0], "kexec_load") {
+		t.Errorf("Expected kexec_load to be left out, got %v", skipped)
+	}
+
+	if changes["security.syscalls.blacklist_default"] != "false" {
+		t.Errorf("Expected the default blacklist to be replaced")
+	}
+
+	blacklist := changes["security.syscalls.blacklist"]
+	if strings.Contains(blacklist, "open_by_handle_at") || !strings.Contains(blacklist, "kexec_load errno 38") || !strings.Contains(blacklist, "reject_force_umount") {
+		t.Errorf("Unexpected blacklist: %s", blacklist)
+	}
+
+	if changes["raw.apparmor"] != "ptrace,\nmount fstype=nfs," {
+		t.Errorf("Unexpected raw.apparmor: %q", changes["raw.apparmor"])
+	}
+
+	// The container's own syscall policy wins.
+	changes, skipped = imageSecurityPolicyConfig(policy, map[string]string{"security.syscalls.whitelist": "read"}, []string{"open_by_handle_at", "kexec_load"}, false, nil)
+	_, ok := changes["security.syscalls.blacklist"]
+	if ok || len(skipped) != 2 {
+		t.Errorf("Expected the syscalls to be left out, got %v and %v", changes, skipped)
+	}
+}
+
+func TestImageSecurityPolicyConfigApparmorAllowed(t *testing.T) {
+	policy := &imageSecurityPolicy{
+		Apparmor: "# Needed for NFS\nmount  fstype=nfs,\n\nmount,\n",
+	}
+
+	// Only the allowed rules make it, whatever their spacing.
+	changes, skipped := imageSecurityPolicyConfig(policy, map[string]string{}, nil, true, []string{"mount fstype=nfs,"})
+	if changes["raw.apparmor"] != "mount fstype=nfs," {
+		t.Errorf("Unexpected raw.apparmor: %q", changes["raw.apparmor"])
+	}
+
+	if len(skipped) != 1 || !strings.Contains(skipped[0], "\"mount,\"") {
+		t.Errorf("Expected the unrestricted mount rule to be left out, got %v", skipped)
+	}
+
+	// Nothing allowed, nothing added.
+	changes, skipped = imageSecurityPolicyConfig(policy, map[string]string{}, nil, true, nil)
+	_, ok := changes["raw.apparmor"]
+	if ok || len(skipped) != 2 {
+		t.Errorf("Expected the AppArmor rules to be left out, got %v and %v", changes, skipped)
+	}
+}
+
+func TestImageSecuritySyscallsParse(t *testing.T) {
+	syscalls, err := imageSecuritySyscallsParse(" kexec_load, ,init_module")
+	if err != nil || len(syscalls) != 2 {
+		t.Errorf("Unexpected result: %v, %v", syscalls, err)
+	}
+
+	_, err = imageSecuritySyscallsParse("kexec_load;reboot")
+	if err == nil {
+		t.Errorf("Expected an invalid syscall name to be rejected")
+	}
+}
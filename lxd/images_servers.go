@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// A default image server is considered down after that many consecutive
+// failures and is only tried again as a last resort until it passes a health
+// check or imageServerRetryInterval has elapsed.
+const imageServerMaxFailures = 3
+const imageServerRetryInterval = 5 * time.Minute
+
+var imageServerStats = map[string]*api.ImageServer{}
+var imageServerStatsLock sync.Mutex
+
+// imageServersList returns the configured default image servers, highest
+// priority first.
+func imageServersList() []string {
+	key, ok := daemonConfig["images.default_servers"]
+	if !ok {
+		return []string{}
+	}
+
+	servers := []string{}
+	for _, server := range strings.Split(key.Get(), ",") {
+		server = strings.TrimRight(strings.TrimSpace(server), "/")
+		if server == "" {
+			continue
+		}
+
+		servers = append(servers, server)
+	}
+
+	return servers
+}
+
+func daemonConfigValidateImageServers(d *Daemon, key string, value string) error {
+	for _, server := range strings.Split(value, ",") {
+		server = strings.TrimSpace(server)
+		if server == "" {
+			continue
+		}
+
+		u, err := url.Parse(server)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("Invalid image server URL: %s", server)
+		}
+	}
+
+	return nil
+}
+
+func imageServerHealthy(stats *api.ImageServer) bool {
+	if stats.ConsecutiveFailures < imageServerMaxFailures {
+		return true
+	}
+
+	return time.Since(stats.LastFailure) > imageServerRetryInterval
+}
+
+// imageServerCandidates returns the servers to try for a download from the
+// given server. If it's one of the default image servers, the other default
+// servers are added as mirrors and servers which are down are moved last.
+func imageServerCandidates(server string, protocol string) []string {
+	if protocol != "simplestreams" {
+		return []string{server}
+	}
+
+	return imageServerOrder(server, imageServersList())
+}
+
+// imageServerOrder returns the given server followed by the other servers,
+// those which are down last, if it's one of them.
+func imageServerOrder(server string, servers []string) []string {
+	primary := strings.TrimRight(server, "/")
+	found := false
+	for _, entry := range servers {
+		if entry == primary {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return []string{server}
+	}
+
+	ordered := []string{primary}
+	for _, entry := range servers {
+		if entry != primary {
+			ordered = append(ordered, entry)
+		}
+	}
+
+	imageServerStatsLock.Lock()
+	defer imageServerStatsLock.Unlock()
+
+	healthy := []string{}
+	unhealthy := []string{}
+	for _, entry := range ordered {
+		stats, ok := imageServerStats[entry]
+		if ok && !imageServerHealthy(stats) {
+			unhealthy = append(unhealthy, entry)
+			continue
+		}
+
+		healthy = append(healthy, entry)
+	}
+
+	return append(healthy, unhealthy...)
+}
+
+// imageServerStatusRegexp matches the errors of the requests which got an
+// unexpected HTTP status, as returned by the simplestreams client.
+var imageServerStatusRegexp = regexp.MustCompile(`^Unable to fetch .*: ([0-9]{3})`)
+
+// imageServerFailure returns whether an error means the image server failed,
+// it couldn't be reached or it returned a 5xx HTTP status. Other errors, like
+// a missing alias, would be the same on the other servers.
+func imageServerFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch err.(type) {
+	case *url.Error, net.Error:
+		return true
+	}
+
+	if err == io.ErrUnexpectedEOF {
+		return true
+	}
+
+	match := imageServerStatusRegexp.FindStringSubmatch(err.Error())
+	return match != nil && strings.HasPrefix(match[1], "5")
+}
+
+func imageServerRecord(server string, err error) {
+	server = strings.TrimRight(server, "/")
+
+	imageServerStatsLock.Lock()
+	defer imageServerStatsLock.Unlock()
+
+	stats, ok := imageServerStats[server]
+	if !ok {
+		stats = &api.ImageServer{URL: server}
+		imageServerStats[server] = stats
+	}
+
+	stats.Requests++
+	if err != nil {
+		stats.Failures++
+		stats.ConsecutiveFailures++
+		stats.LastFailure = time.Now()
+		stats.LastError = err.Error()
+		return
+	}
+
+	stats.ConsecutiveFailures = 0
+	stats.LastSuccess = time.Now()
+}
+
+// ImageDownload resolves the image fingerprint and if not in the database,
+// downloads it, failing over to the other default image servers if needed.
+func (d *Daemon) ImageDownload(op *operation, server string, protocol string, certificate string, secret string, alias string, forContainer bool, autoUpdate bool, storagePool string, preferCached bool) (*api.Image, error) {
+	candidates := imageServerCandidates(server, protocol)
+	return imageServerFailover(candidates, func(candidate string) (*api.Image, error) {
+		// The certificate only applies to the requested server.
+		candidateCertificate := certificate
+		if candidate != strings.TrimRight(server, "/") {
+			candidateCertificate = ""
+		}
+
+		return d.imageDownload(op, candidate, protocol, candidateCertificate, secret, alias, forContainer, autoUpdate, storagePool, preferCached)
+	})
+}
+
+// imageServerFailover downloads from the first candidate, moving on to the
+// next one as long as the servers fail.
+func imageServerFailover(candidates []string, download func(candidate string) (*api.Image, error)) (*api.Image, error) {
+	var firstErr error
+
+	for i, candidate := range candidates {
+		info, err := download(candidate)
+		failure := imageServerFailure(err)
+
+		// Errors which aren't the server's fault don't count against it.
+		if len(candidates) > 1 {
+			if failure {
+				imageServerRecord(candidate, err)
+			} else {
+				imageServerRecord(candidate, nil)
+			}
+		}
+
+		if err == nil {
+			return info, nil
+		}
+
+		if !failure {
+			return nil, err
+		}
+
+		if firstErr == nil {
+			firstErr = err
+		}
+
+		if i+1 < len(candidates) {
+			logger.Warn("Image download failed, trying next image server", log.Ctx{"server": candidate, "next": candidates[i+1], "err": err})
+		}
+	}
+
+	return nil, firstErr
+}
+
+// imageServersHealthCheck probes the default image servers which are
+// currently considered down.
+func imageServersHealthCheck(d *Daemon) {
+	for _, server := range imageServersList() {
+		imageServerStatsLock.Lock()
+		stats, ok := imageServerStats[server]
+		down := ok && stats.ConsecutiveFailures >= imageServerMaxFailures
+		imageServerStatsLock.Unlock()
+
+		if !down {
+			continue
+		}
+
+		client, err := d.httpClient("")
+		if err != nil {
+			logger.Warn("Failed to probe image server", log.Ctx{"server": server, "err": err})
+			continue
+		}
+
+		resp, err := client.Get(fmt.Sprintf("%s/streams/v1/index.json", server))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("Unexpected HTTP status: %s", resp.Status)
+			}
+		}
+
+		imageServerRecord(server, err)
+		if err == nil {
+			logger.Info("Image server is back up", log.Ctx{"server": server})
+		}
+	}
+}
+
+// /1.0/image-servers
+// Get the default image servers and their statistics.
+func imageServersGet(d *Daemon, r *http.Request) Response {
+	servers := []api.ImageServer{}
+
+	imageServerStatsLock.Lock()
+	for i, server := range imageServersList() {
+		entry := api.ImageServer{URL: server}
+
+		stats, ok := imageServerStats[server]
+		if ok {
+			entry = *stats
+		}
+
+		entry.Priority = i
+		entry.Healthy = !ok || imageServerHealthy(stats)
+		servers = append(servers, entry)
+	}
+	imageServerStatsLock.Unlock()
+
+	return SyncResponse(true, servers)
+}
+
+var imageServersCmd = Command{name: "image-servers", get: imageServersGet}
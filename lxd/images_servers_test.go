@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/lxc/lxd/shared/api"
+)
+
+func TestImageServerFailure(t *testing.T) {
+	tests := []struct {
+		err     error
+		failure bool
+	}{
+		{nil, false},
+		{&url.Error{Op: "Get", URL: "https://images.example.net", Err: fmt.Errorf("connection refused")}, true},
+		{fmt.Errorf("Unable to fetch https://images.example.net/streams/v1/index.json: 503 Service Unavailable"), true},
+		{fmt.Errorf("Unable to fetch https://images.example.net/streams/v1/index.json: 404 Not Found"), false},
+		{fmt.Errorf("Alias 'ubuntu/missing' doesn't exist"), false},
+		{fmt.Errorf("The requested image couldn't be found."), false},
+	}
+
+	for _, test := range tests {
+		failure := imageServerFailure(test.err)
+		if failure != test.failure {
+			t.Errorf("Error %v: got %v, expected %v", test.err, failure, test.failure)
+		}
+	}
+}
+
+func TestImageServerFailover(t *testing.T) {
+	imageServerStats = map[string]*api.ImageServer{}
+	defer func() { imageServerStats = map[string]*api.ImageServer{} }()
+
+	servers := []string{"https://a.example.net", "https://b.example.net", "https://c.example.net"}
+
+	// The first server is down, the second one has the image.
+	tried := []string{}
+	info, err := imageServerFailover(servers, func(candidate string) (*api.Image, error) {
+		tried = append(tried, candidate)
+		if candidate == servers[0] {
+			return nil, &url.Error{Op: "Get", URL: candidate, Err: fmt.Errorf("connection refused")}
+		}
+
+		return &api.Image{Fingerprint: "abc"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Fingerprint != "abc" || !reflect.DeepEqual(tried, servers[:2]) {
+		t.Errorf("Unexpected download from %v: %v", tried, info)
+	}
+
+	if imageServerStats[servers[0]].ConsecutiveFailures != 1 || imageServerStats[servers[1]].ConsecutiveFailures != 0 {
+		t.Errorf("Unexpected statistics: %v, %v", imageServerStats[servers[0]], imageServerStats[servers[1]])
+	}
+
+	// A missing alias is the same on every server.
+	tried = []string{}
+	_, err = imageServerFailover(servers, func(candidate string) (*api.Image, error) {
+		tried = append(tried, candidate)
+		return nil, fmt.Errorf("Alias 'missing' doesn't exist")
+	})
+	if err == nil || len(tried) != 1 {
+		t.Errorf("Expected no failover on a missing alias, tried %v", tried)
+	}
+
+	if imageServerStats[servers[0]].ConsecutiveFailures != 0 || imageServerStats[servers[0]].Failures != 1 {
+		t.Errorf("A missing alias counted as a server failure: %v", imageServerStats[servers[0]])
+	}
+
+	// Every server fails, the first error is returned.
+	_, err = imageServerFailover(servers, func(candidate string) (*api.Image, error) {
+		return nil, fmt.Errorf("Unable to fetch %s/streams/v1/index.json: 502 Bad Gateway", candidate)
+	})
+	if err == nil || err.Error() != "Unable to fetch https://a.example.net/streams/v1/index.json: 502 Bad Gateway" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestImageServerOrder(t *testing.T) {
+	imageServerStats = map[string]*api.ImageServer{}
+	defer func() { imageServerStats = map[string]*api.ImageServer{} }()
+
+	servers := []string{"https://a.example.net", "https://b.example.net", "https://c.example.net"}
+
+	order := imageServerOrder("https://b.example.net/", servers)
+	expected := []string{"https://b.example.net", "https://a.example.net", "https://c.example.net"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("Got %v, expected %v", order, expected)
+	}
+
+	// Servers which are down are tried last, even when requested.
+	for i := 0; i < imageServerMaxFailures; i++ {
+		imageServerRecord("https://b.example.net", fmt.Errorf("connection refused"))
+	}
+
+	order = imageServerOrder("https://b.example.net", servers)
+	expected = []string{"https://a.example.net", "https://c.example.net", "https://b.example.net"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("Got %v, expected %v", order, expected)
+	}
+
+	// Other servers aren't mirrors of the default ones.
+	order = imageServerOrder("https://other.example.net", servers)
+	if !reflect.DeepEqual(order, []string{"https://other.example.net"}) {
+		t.Errorf("Unexpected order for a server which isn't a default one: %v", order)
+	}
+}
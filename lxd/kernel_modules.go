@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/lxc/lxd/shared"
+)
+
+var kernelModuleNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// kernelModulesParse splits a comma separated list of kernel modules.
+func kernelModulesParse(value string) ([]string, error) {
+	modules := []string{}
+	for _, module := range strings.Split(value, ",") {
+		module = strings.TrimSpace(module)
+		if module == "" {
+			continue
+		}
+
+		if !kernelModuleNameRegexp.MatchString(module) {
+			return nil, fmt.Errorf("Invalid kernel module name: %s", module)
+		}
+
+		modules = append(modules, module)
+	}
+
+	return modules, nil
+}
+
+func daemonConfigValidateKernelModules(d *Daemon, key string, value string) error {
+	_, err := kernelModulesParse(value)
+	return err
+}
+
+// kernelModulesError lists the kernel modules required by a container which
+// couldn't be loaded along with the reason for each of them.
+type kernelModulesError struct {
+	Modules map[string]string
+}
+
+// Names returns the sorted names of the modules which couldn't be loaded.
+func (e kernelModulesError) Names() []string {
+	names := []string{}
+	for module := range e.Modules {
+		names = append(names, module)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func (e kernelModulesError) Error() string {
+	missing := []string{}
+	for _, module := range e.Names() {
+		missing = append(missing, fmt.Sprintf("%s (%s)", module, e.Modules[module]))
+	}
+
+	return fmt.Sprintf("Failed to load the required kernel modules: %s", strings.Join(missing, ", "))
+}
+
+// kernelModulesLoad loads the kernel modules listed in linux.kernel_modules,
+// checking them against "core.kernel_modules_allowed" first. All the modules
+// which couldn't be loaded are reported at once as a kernelModulesError.
+func kernelModulesLoad(value string) error {
+	modules, err := kernelModulesParse(value)
+	if err != nil {
+		return err
+	}
+
+	allowed, err := kernelModulesParse(daemonConfig["core.kernel_modules_allowed"].Get())
+	if err != nil {
+		return err
+	}
+
+	failed := map[string]string{}
+	for _, module := range modules {
+		if len(allowed) > 0 && !shared.StringInSlice(module, allowed) {
+			failed[module] = "not in core.kernel_modules_allowed"
+			continue
+		}
+
+		err := loadModule(module)
+		if err != nil {
+			failed[module] = strings.TrimSpace(err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return kernelModulesError{Modules: failed}
+	}
+
+	return nil
+}
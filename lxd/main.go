@@ -73,12 +73,17 @@ func run() error {
 		fmt.Printf("        Setup storage and networking\n")
 		fmt.Printf("    ready\n")
 		fmt.Printf("        Tells LXD that any setup-mode configuration has been done and that it can start containers.\n")
-		fmt.Printf("    shutdown [--timeout=60]\n")
+		fmt.Printf("    shutdown [--timeout=60] [--force]\n")
 		fmt.Printf("        Perform a clean shutdown of LXD and all running containers\n")
+		fmt.Printf("        once the migrations and copies in flight are done, unless forced\n")
 		fmt.Printf("    waitready [--timeout=15]\n")
 		fmt.Printf("        Wait until LXD is ready to handle requests\n")
 		fmt.Printf("    import <container name> [--force]\n")
 		fmt.Printf("        Import a pre-existing container from storage\n")
+		fmt.Printf("    freeze-storage [--timeout=300]\n")
+		fmt.Printf("        Sync the storage pools and hold off snapshots, copies and deletions for a host backup\n")
+		fmt.Printf("    thaw-storage\n")
+		fmt.Printf("        Let snapshots, copies and deletions proceed after a host backup\n")
 
 		fmt.Printf("\n\nCommon options:\n")
 		fmt.Printf("    --debug\n")
@@ -132,6 +137,10 @@ func run() error {
 		fmt.Printf("    --timeout SECONDS\n")
 		fmt.Printf("        How long to wait before failing\n")
 
+		fmt.Printf("\nFreeze-storage options:\n")
+		fmt.Printf("    --timeout SECONDS\n")
+		fmt.Printf("        How long to wait before thawing the storage by itself\n")
+
 		fmt.Printf("\nWaitready options:\n")
 		fmt.Printf("    --timeout SECONDS\n")
 		fmt.Printf("        How long to wait before failing\n")
@@ -218,6 +227,10 @@ func run() error {
 			return cmdWaitReady()
 		case "import":
 			return cmdImport(os.Args[1:])
+		case "freeze-storage":
+			return cmdFreezeStorage()
+		case "thaw-storage":
+			return cmdThawStorage()
 
 		// Internal commands
 		case "forkgetnet":
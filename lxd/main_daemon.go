@@ -62,6 +62,14 @@ func cmdDaemon() error {
 		return err
 	}
 
+	// Let systemd know that the API is up when running as a notify unit.
+	err = systemdNotify("READY=1\nSTATUS=Serving the REST API")
+	if err != nil {
+		logger.Warnf("Failed to notify systemd: %s", err)
+	}
+
+	go systemdWatchdog(d)
+
 	var ret error
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -72,7 +80,9 @@ func cmdDaemon() error {
 		sig := <-ch
 
 		logger.Infof("Received '%s signal', shutting down containers.", sig)
+		systemdNotify("STOPPING=1")
 
+		transfersWait()
 		containersShutdown(d)
 
 		ret = d.Stop()
@@ -80,10 +90,14 @@ func cmdDaemon() error {
 	}()
 
 	go func() {
-		<-d.shutdownChan
+		force := <-d.shutdownChan
 
 		logger.Infof("Asked to shutdown by API, shutting down containers.")
+		systemdNotify("STOPPING=1")
 
+		if !force {
+			transfersWait()
+		}
 		containersShutdown(d)
 
 		ret = d.Stop()
@@ -98,6 +112,7 @@ func cmdDaemon() error {
 		sig := <-ch
 
 		logger.Infof("Received '%s signal', exiting.", sig)
+		systemdNotify("STOPPING=1")
 		ret = d.Stop()
 		wg.Done()
 	}()
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/lxc/lxd/client"
+)
+
+func cmdFreezeStorage() error {
+	c, err := lxd.ConnectLXDUnix("", nil)
+	if err != nil {
+		return err
+	}
+
+	req := internalStorageFreezePut{}
+	if *argTimeout > 0 {
+		req.Timeout = *argTimeout
+	}
+
+	_, _, err = c.RawQuery("PUT", "/internal/storage/freeze", req, "")
+	return err
+}
+
+func cmdThawStorage() error {
+	c, err := lxd.ConnectLXDUnix("", nil)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = c.RawQuery("DELETE", "/internal/storage/freeze", nil, "")
+	return err
+}
@@ -643,6 +643,12 @@ func (cmd *CmdInit) availableStoragePoolsDrivers() []string {
 			continue
 		}
 
+		// block pools need devices which can't be guessed and
+		// external pools need a driver to be installed.
+		if driver == "block" || driver == "external" {
+			continue
+		}
+
 		// btrfs can work in user namespaces too. (If
 		// source=/some/path/on/btrfs is used.)
 		if cmd.RunningInUserns && driver != "btrfs" {
@@ -670,6 +676,40 @@ func (cmd *CmdInit) profileDeviceAlreadyExists(profile *api.ProfilesPost, device
 	return nil
 }
 
+// Print the storage sources of the given types the given backend can use, if
+// the server is able to list them.
+func (cmd *CmdInit) printStorageSources(client lxd.ContainerServer, backend string, types ...string) {
+	sources, err := client.GetStorageSources()
+	if err != nil {
+		return
+	}
+
+	candidates := []string{}
+	for _, source := range sources {
+		if !shared.StringInSlice(source.Type, types) || !shared.StringInSlice(backend, source.Drivers) {
+			continue
+		}
+
+		name := source.Name
+		if source.Path != "" {
+			name = source.Path
+		}
+
+		description := shared.GetByteSizeString(int64(source.Size), 0)
+		if source.Model != "" {
+			description = fmt.Sprintf("%s, %s", source.Model, description)
+		}
+
+		candidates = append(candidates, fmt.Sprintf("  %s (%s)", name, description))
+	}
+
+	if len(candidates) == 0 {
+		return
+	}
+
+	fmt.Printf("Available candidates:\n%s\n", strings.Join(candidates, "\n"))
+}
+
 // Ask if the user wants to create a new storage pool, and return
 // the relevant parameters if so.
 func (cmd *CmdInit) askStorage(client lxd.ContainerServer, existingPools []string, availableBackends []string) (*cmdInitStorageParams, error) {
@@ -724,6 +764,7 @@ func (cmd *CmdInit) askStorage(client lxd.ContainerServer, existingPools []strin
 					}
 					return nil
 				}
+				cmd.printStorageSources(client, storage.Backend, "disk", "partition")
 				storage.Device = cmd.Context.AskString("Path to the existing block device: ", "", deviceExists)
 			} else {
 				backingFs, err := filesystemDetect(shared.VarPath())
@@ -752,6 +793,7 @@ func (cmd *CmdInit) askStorage(client lxd.ContainerServer, existingPools []strin
 				}
 			}
 		} else {
+			cmd.printStorageSources(client, storage.Backend, "zpool", "vg")
 			question := fmt.Sprintf("Name of the existing %s pool or dataset: ", strings.ToUpper(storage.Backend))
 			storage.Dataset = cmd.Context.AskString(question, "", nil)
 		}
@@ -13,7 +13,12 @@ func cmdShutdown() error {
 		return err
 	}
 
-	_, _, err = c.RawQuery("PUT", "/internal/shutdown", nil, "")
+	url := "/internal/shutdown"
+	if *argForce {
+		url += "?force=1"
+	}
+
+	_, _, err = c.RawQuery("PUT", url, nil, "")
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// metricsNetworkCounters are the traffic counters of a container's NIC, from
+// the point of view of the container.
+type metricsNetworkCounters struct {
+	container string
+	device    string
+
+	rxBytes   int64
+	rxPackets int64
+	rxDropped int64
+	txBytes   int64
+	txPackets int64
+	txDropped int64
+
+	// Whether the drop counters are known, they're only available from the
+	// host side of the NIC.
+	drops bool
+}
+
+// metricsHostCounter reads a statistic of a host interface.
+func metricsHostCounter(iface string, name string) (int64, error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/sys/class/net/%s/statistics/%s", iface, name))
+	if err != nil {
+		return -1, err
+	}
+
+	return strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+}
+
+// metricsHostCounters fills the counters from the host side of a veth pair.
+// What the host side receives was sent by the container and the other way
+// around.
+func metricsHostCounters(iface string, counters *metricsNetworkCounters) error {
+	values := map[string]*int64{
+		"rx_bytes":   &counters.txBytes,
+		"rx_packets": &counters.txPackets,
+		"rx_dropped": &counters.txDropped,
+		"tx_bytes":   &counters.rxBytes,
+		"tx_packets": &counters.rxPackets,
+		"tx_dropped": &counters.rxDropped,
+	}
+
+	for name, value := range values {
+		v, err := metricsHostCounter(iface, name)
+		if err != nil {
+			return err
+		}
+
+		*value = v
+	}
+
+	counters.drops = true
+	return nil
+}
+
+// metricsContainerNetwork returns the counters of the NICs of a running
+// container. The host side of the veth pair is used when there's one, the
+// counters from inside the container's network namespace otherwise.
+func metricsContainerNetwork(c container) ([]metricsNetworkCounters, error) {
+	result := []metricsNetworkCounters{}
+
+	if !c.IsRunning() {
+		return result, nil
+	}
+
+	state, err := c.RenderState()
+	if err != nil {
+		return nil, err
+	}
+
+	config := c.ExpandedConfig()
+	devices := c.ExpandedDevices()
+	for _, k := range devices.DeviceNames() {
+		m := devices[k]
+		if m["type"] != "nic" {
+			continue
+		}
+
+		name := m["name"]
+		if name == "" {
+			name = config[fmt.Sprintf("volatile.%s.name", k)]
+		}
+
+		counters := metricsNetworkCounters{container: c.Name(), device: k}
+
+		network, ok := state.Network[name]
+		hostName := network.HostName
+		if hostName == "" {
+			hostName = m["host_name"]
+		}
+
+		if hostName != "" && shared.PathExists(fmt.Sprintf("/sys/class/net/%s", hostName)) {
+			err := metricsHostCounters(hostName, &counters)
+			if err == nil {
+				result = append(result, counters)
+				continue
+			}
+
+			logger.Debug("Failed to read the host interface counters", log.Ctx{"container": c.Name(), "device": k, "err": err})
+		}
+
+		if !ok {
+			continue
+		}
+
+		counters.rxBytes = network.Counters.BytesReceived
+		counters.rxPackets = network.Counters.PacketsReceived
+		counters.txBytes = network.Counters.BytesSent
+		counters.txPackets = network.Counters.PacketsSent
+		result = append(result, counters)
+	}
+
+	return result, nil
+}
+
+// metricsLabelEscape escapes a label value for the Prometheus text format.
+func metricsLabelEscape(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	return strings.Replace(value, "\n", `\n`, -1)
+}
+
+// metricsNetworkRender renders the NIC counters in the Prometheus text
+// exposition format.
+func metricsNetworkRender(counters []metricsNetworkCounters) []byte {
+	type metric struct {
+		name  string
+		help  string
+		value func(c metricsNetworkCounters) (int64, bool)
+	}
+
+	metrics := []metric{
+		{"lxd_container_network_receive_bytes_total", "Bytes received by the container's NIC.",
+			func(c metricsNetworkCounters) (int64, bool) { return c.rxBytes, true }},
+		{"lxd_container_network_receive_packets_total", "Packets received by the container's NIC.",
+			func(c metricsNetworkCounters) (int64, bool) { return c.rxPackets, true }},
+		{"lxd_container_network_receive_drops_total", "Packets dropped on their way to the container's NIC.",
+			func(c metricsNetworkCounters) (int64, bool) { return c.rxDropped, c.drops }},
+		{"lxd_container_network_transmit_bytes_total", "Bytes sent by the container's NIC.",
+			func(c metricsNetworkCounters) (int64, bool) { return c.txBytes, true }},
+		{"lxd_container_network_transmit_packets_total", "Packets sent by the container's NIC.",
+			func(c metricsNetworkCounters) (int64, bool) { return c.txPackets, true }},
+		{"lxd_container_network_transmit_drops_total", "Packets sent by the container's NIC which were dropped.",
+			func(c metricsNetworkCounters) (int64, bool) { return c.txDropped, c.drops }},
+	}
+
+	buf := bytes.Buffer{}
+	for _, m := range metrics {
+		fmt.Fprintf(&buf, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(&buf, "# TYPE %s counter\n", m.name)
+
+		for _, c := range counters {
+			value, ok := m.value(c)
+			if !ok {
+				continue
+			}
+
+			fmt.Fprintf(&buf, "%s{container=\"%s\",device=\"%s\"} %d\n", m.name, metricsLabelEscape(c.container), metricsLabelEscape(c.device), value)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// metricsResponse writes metrics in the Prometheus text exposition format
+// rather than the usual JSON envelope.
+type metricsResponse struct {
+	content []byte
+}
+
+func (r *metricsResponse) Render(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, err := w.Write(r.content)
+	return err
+}
+
+func (r *metricsResponse) String() string {
+	return "metrics"
+}
+
+// /1.0/metrics
+// Return the metrics of the containers and storage pools in the Prometheus text
+// format.
+func metricsGet(d *Daemon, r *http.Request) Response {
+	names, err := dbContainersList(d.db, cTypeRegular)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	sort.Strings(names)
+
+	counters := []metricsNetworkCounters{}
+	for _, name := range names {
+		c, err := containerLoadByName(d, name)
+		if err != nil {
+			continue
+		}
+
+		network, err := metricsContainerNetwork(c)
+		if err != nil {
+			logger.Debug("Failed to get the network metrics", log.Ctx{"container": name, "err": err})
+			continue
+		}
+
+		counters = append(counters, network...)
+	}
+
+	content := metricsNetworkRender(counters)
+
+	zfs, err := metricsZfs(d)
+	if err != nil {
+		logger.Debug("Failed to get the ZFS metrics", log.Ctx{"err": err})
+	} else {
+		content = append(content, metricsZfsRender(zfs)...)
+	}
+
+	return &metricsResponse{content: content}
+}
+
+var metricsCmd = Command{name: "metrics", get: metricsGet}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func Test_metricsNetworkRender(t *testing.T) {
+	counters := []metricsNetworkCounters{
+		{container: "c1", device: "eth0", rxBytes: 10, txBytes: 20, rxDropped: 1, drops: true},
+		{container: "c2", device: "eth1", rxBytes: 30},
+	}
+
+	content := string(metricsNetworkRender(counters))
+
+	expected := []string{
+		`lxd_container_network_receive_bytes_total{container="c1",device="eth0"} 10`,
+		`lxd_container_network_transmit_bytes_total{container="c1",device="eth0"} 20`,
+		`lxd_container_network_receive_drops_total{container="c1",device="eth0"} 1`,
+		`lxd_container_network_receive_bytes_total{container="c2",device="eth1"} 30`,
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(content, line+"\n") {
+			t.Errorf("Missing '%s' in:\n%s", line, content)
+		}
+	}
+
+	if strings.Contains(content, `drops_total{container="c2"`) {
+		t.Errorf("Unexpected drop counters for c2:\n%s", content)
+	}
+}
+
+func Test_metricsZfsPoolCounters(t *testing.T) {
+	kstat := func(dataset string, commits string) map[string]string {
+		content := fmt.Sprintf(`31 1 0x01 7 2160 5214787214 6837346493
+name                            type data
+dataset_name                    7    %s
+writes                          4    10
+nwritten                        4    4096
+zil_commit_count                4    %s
+zil_itx_metaslab_slog_bytes     4    1024
+`, dataset, commits)
+		return zfsKstatParse(content)
+	}
+
+	kstats := []map[string]string{
+		kstat("tank/lxd/containers/c2", "3"),
+		kstat("tank/lxd/containers/c1", "5"),
+		kstat("tank/lxd/custom/data", "1"),
+		kstat("tank/lxd/images/abcd", "0"),
+		kstat("tank/other", "100"),
+	}
+
+	counters := metricsZfsPoolCounters("default", "tank/lxd", kstats)
+	if len(counters) != 4 {
+		t.Fatalf("Expected 4 sets of statistics, got %d", len(counters))
+	}
+
+	content := string(metricsZfsRender(counters))
+
+	expected := []string{
+		`lxd_zfs_volume_sync_commits_total{pool="default",type="container",volume="c1"} 5`,
+		`lxd_zfs_volume_sync_commits_total{pool="default",type="container",volume="c2"} 3`,
+		`lxd_zfs_volume_sync_commits_total{pool="default",type="custom",volume="data"} 1`,
+		`lxd_zfs_volume_sync_slog_bytes_total{pool="default",type="custom",volume="data"} 1024`,
+		`lxd_zfs_pool_sync_commits_total{pool="default"} 9`,
+		`lxd_zfs_pool_write_bytes_total{pool="default"} 16384`,
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(content, line+"\n") {
+			t.Errorf("Missing '%s' in:\n%s", line, content)
+		}
+	}
+
+	if strings.Contains(content, "sync_writes_total") {
+		t.Errorf("Unexpected statistics missing from the kstats:\n%s", content)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// metricsZfsStats are the statistics of the datasets, from their kstats,
+// exposed as metrics, along with their help.
+var metricsZfsStats = []struct {
+	kstat string
+	name  string
+	help  string
+}{
+	{"zil_commit_count", "sync_commits_total", "ZIL commits, one per fsync() or batch of synchronous writes."},
+	{"zil_itx_count", "sync_writes_total", "Records written to the ZIL."},
+	{"zil_itx_metaslab_slog_bytes", "sync_slog_bytes_total", "Bytes written to the ZIL on the separate log devices."},
+	{"zil_itx_metaslab_normal_bytes", "sync_normal_bytes_total", "Bytes written to the ZIL on the main devices of the pool."},
+	{"writes", "writes_total", "Writes, synchronous or not."},
+	{"nwritten", "write_bytes_total", "Bytes written, synchronously or not."},
+}
+
+// metricsZfsCounters are the statistics of a container or custom volume on a
+// ZFS storage pool, or of the whole pool if volumeType is empty.
+type metricsZfsCounters struct {
+	pool       string
+	volumeType string
+	volume     string
+	values     map[string]int64
+}
+
+// metricsZfsByVolume sorts the statistics by volume type and name.
+type metricsZfsByVolume []metricsZfsCounters
+
+func (a metricsZfsByVolume) Len() int      { return len(a) }
+func (a metricsZfsByVolume) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a metricsZfsByVolume) Less(i, j int) bool {
+	if a[i].volumeType != a[j].volumeType {
+		return a[i].volumeType < a[j].volumeType
+	}
+
+	return a[i].volume < a[j].volume
+}
+
+// zfsKstatParse parses a kstat file, such as
+// /proc/spl/kstat/zfs/<pool>/objset-<id>, into its values by name.
+func zfsKstatParse(content string) map[string]string {
+	values := map[string]string{}
+
+	lines := strings.Split(content, "\n")
+	if len(lines) < 2 {
+		return values
+	}
+
+	// The first line is the header of the kstat and the second one
+	// the header of the columns.
+	for _, line := range lines[2:] {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+
+		values[fields[0]] = strings.Join(fields[2:], " ")
+	}
+
+	return values
+}
+
+// metricsZfsDatasetVolume returns the type and name of the volume a dataset
+// below the dataset of a storage pool belongs to, if it's a container or a
+// custom volume.
+func metricsZfsDatasetVolume(poolDataset string, dataset string) (string, string, bool) {
+	if !strings.HasPrefix(dataset, poolDataset+"/") {
+		return "", "", false
+	}
+
+	fields := strings.SplitN(strings.TrimPrefix(dataset, poolDataset+"/"), "/", 2)
+	if len(fields) != 2 || fields[1] == "" || strings.Contains(fields[1], "/") {
+		return "", "", false
+	}
+
+	switch fields[0] {
+	case "containers":
+		return "container", fields[1], true
+	case "custom":
+		return "custom", fields[1], true
+	}
+
+	return "", "", false
+}
+
+// metricsZfsPoolCounters turns the kstats of the datasets of a zpool into the
+// statistics of the containers and custom volumes of a storage pool using
+// poolDataset, followed by the totals of the storage pool.
+func metricsZfsPoolCounters(poolName string, poolDataset string, kstats []map[string]string) []metricsZfsCounters {
+	result := []metricsZfsCounters{}
+	total := metricsZfsCounters{pool: poolName, values: map[string]int64{}}
+
+	for _, kstat := range kstats {
+		dataset := kstat["dataset_name"]
+		if dataset != poolDataset && !strings.HasPrefix(dataset, poolDataset+"/") {
+			continue
+		}
+
+		values := map[string]int64{}
+		for _, stat := range metricsZfsStats {
+			value, ok := kstat[stat.kstat]
+			if !ok {
+				continue
+			}
+
+			v, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				continue
+			}
+
+			values[stat.kstat] = v
+			total.values[stat.kstat] += v
+		}
+
+		volumeType, volume, ok := metricsZfsDatasetVolume(poolDataset, dataset)
+		if ok && len(values) > 0 {
+			result = append(result, metricsZfsCounters{pool: poolName, volumeType: volumeType, volume: volume, values: values})
+		}
+	}
+
+	sort.Sort(metricsZfsByVolume(result))
+
+	if len(total.values) > 0 {
+		result = append(result, total)
+	}
+
+	return result
+}
+
+// metricsZfs returns the statistics of the ZFS storage pools and of their
+// containers and custom volumes. They come from the per-dataset kstats, which
+// not all ZFS versions provide, and the ZIL ones from even fewer.
+func metricsZfs(d *Daemon) ([]metricsZfsCounters, error) {
+	result := []metricsZfsCounters{}
+
+	pools, err := dbStoragePools(d.db)
+	if err != nil {
+		if err == NoSuchObjectError {
+			return result, nil
+		}
+
+		return nil, err
+	}
+
+	for _, poolName := range pools {
+		_, pool, err := dbStoragePoolGet(d.db, poolName)
+		if err != nil {
+			return nil, err
+		}
+
+		if pool.Driver != "zfs" {
+			continue
+		}
+
+		poolDataset := pool.Config["zfs.pool_name"]
+		if poolDataset == "" {
+			poolDataset = poolName
+		}
+
+		zpool := strings.SplitN(poolDataset, "/", 2)[0]
+		paths, err := filepath.Glob(fmt.Sprintf("/proc/spl/kstat/zfs/%s/objset-*", zpool))
+		if err != nil {
+			return nil, err
+		}
+
+		kstats := []map[string]string{}
+		for _, path := range paths {
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				// The dataset is gone.
+				logger.Debug("Failed to read the statistics of a ZFS dataset", log.Ctx{"path": path, "err": err})
+				continue
+			}
+
+			kstats = append(kstats, zfsKstatParse(string(content)))
+		}
+
+		result = append(result, metricsZfsPoolCounters(poolName, poolDataset, kstats)...)
+	}
+
+	return result, nil
+}
+
+// metricsZfsRender renders the ZFS statistics in the Prometheus text
+// exposition format.
+func metricsZfsRender(counters []metricsZfsCounters) []byte {
+	buf := bytes.Buffer{}
+
+	for _, kind := range []string{"volume", "pool"} {
+		for _, stat := range metricsZfsStats {
+			name := fmt.Sprintf("lxd_zfs_%s_%s", kind, stat.name)
+			header := false
+
+			for _, c := range counters {
+				if (c.volumeType == "") != (kind == "pool") {
+					continue
+				}
+
+				value, ok := c.values[stat.kstat]
+				if !ok {
+					continue
+				}
+
+				if !header {
+					fmt.Fprintf(&buf, "# HELP %s %s\n", name, stat.help)
+					fmt.Fprintf(&buf, "# TYPE %s counter\n", name)
+					header = true
+				}
+
+				if kind == "pool" {
+					fmt.Fprintf(&buf, "%s{pool=\"%s\"} %d\n", name, metricsLabelEscape(c.pool), value)
+				} else {
+					fmt.Fprintf(&buf, "%s{pool=\"%s\",type=\"%s\",volume=\"%s\"} %d\n", name, metricsLabelEscape(c.pool), c.volumeType, metricsLabelEscape(c.volume), value)
+				}
+			}
+		}
+	}
+
+	return buf.Bytes()
+}
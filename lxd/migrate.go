@@ -15,6 +15,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -25,6 +26,8 @@ import (
 	"github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/api"
 	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
 )
 
 type migrationFields struct {
@@ -238,24 +241,48 @@ func (s *migrationSourceWs) Connect(op *operation, r *http.Request, w http.Respo
 }
 
 func (s *migrationSourceWs) ConnectTarget(target api.ContainerPostTarget) error {
+	conns, err := migrationDial(target)
+	if err != nil {
+		return err
+	}
+
+	for name, wsConn := range conns {
+		switch name {
+		case "control":
+			s.controlConn = wsConn
+		case "fs":
+			s.fsConn = wsConn
+		case "criu":
+			s.criuConn = wsConn
+		}
+	}
+
+	s.allConnected <- true
+
+	return nil
+}
+
+// migrationDial connects to the websockets of the migration operation of
+// another host, returning them by name.
+func migrationDial(target api.ContainerPostTarget) (map[string]*websocket.Conn, error) {
 	var err error
 	var cert *x509.Certificate
 
 	if target.Certificate != "" {
 		certBlock, _ := pem.Decode([]byte(target.Certificate))
 		if certBlock == nil {
-			return fmt.Errorf("Invalid certificate")
+			return nil, fmt.Errorf("Invalid certificate")
 		}
 
 		cert, err = x509.ParseCertificate(certBlock.Bytes)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
 	config, err := shared.GetTLSConfig("", "", "", cert)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	dialer := websocket.Dialer{
@@ -263,18 +290,17 @@ func (s *migrationSourceWs) ConnectTarget(target api.ContainerPostTarget) error
 		NetDial:         shared.RFC3493Dialer,
 	}
 
-	for name, secret := range target.Websockets {
-		var conn **websocket.Conn
+	conns := map[string]*websocket.Conn{}
+	closeAll := func() {
+		for _, wsConn := range conns {
+			wsConn.Close()
+		}
+	}
 
-		switch name {
-		case "control":
-			conn = &s.controlConn
-		case "fs":
-			conn = &s.fsConn
-		case "criu":
-			conn = &s.criuConn
-		default:
-			return fmt.Errorf("Unknown secret provided: %s", name)
+	for name, secret := range target.Websockets {
+		if !shared.StringInSlice(name, []string{"control", "fs", "criu"}) {
+			closeAll()
+			return nil, fmt.Errorf("Unknown secret provided: %s", name)
 		}
 
 		query := url.Values{"secret": []string{secret}}
@@ -284,15 +310,14 @@ func (s *migrationSourceWs) ConnectTarget(target api.ContainerPostTarget) error
 
 		wsConn, _, err := dialer.Dial(wsUrl, http.Header{})
 		if err != nil {
-			return err
+			closeAll()
+			return nil, err
 		}
 
-		*conn = wsConn
+		conns[name] = wsConn
 	}
 
-	s.allConnected <- true
-
-	return nil
+	return conns, nil
 }
 
 func writeActionScript(directory string, operation string, secret string) error {
@@ -317,6 +342,216 @@ fi
 	return err
 }
 
+// migrationSendSync tells the other end of conn whether the stream following
+// is the final one of a pre-copy.
+func migrationSendSync(conn *websocket.Conn, final bool) error {
+	data, err := proto.Marshal(&MigrationSync{Final: proto.Bool(final)})
+	if err != nil {
+		return err
+	}
+
+	return conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+// migrationRecvSync returns whether the stream following on conn is the
+// final one of a pre-copy.
+func migrationRecvSync(conn *websocket.Conn) (bool, error) {
+	mt, data, err := conn.ReadMessage()
+	if err != nil {
+		return false, err
+	}
+
+	if mt != websocket.BinaryMessage {
+		return false, fmt.Errorf("Only binary messages allowed")
+	}
+
+	msg := MigrationSync{}
+	err = proto.Unmarshal(data, &msg)
+	if err != nil {
+		return false, err
+	}
+
+	return msg.GetFinal(), nil
+}
+
+// migrationPreDumpSize returns how much memory a pre-dump wrote.
+func migrationPreDumpSize(dir string) (int64, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "pages-*.img"))
+	if err != nil {
+		return -1, err
+	}
+
+	size := int64(0)
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return -1, err
+		}
+
+		size += fi.Size()
+	}
+
+	return size, nil
+}
+
+// migrationPreCopyConverged tells whether enough memory was pre-copied, the
+// last pre-dump having written at most 100 - goal percent of what the first
+// one, which wrote all of it, did.
+func migrationPreCopyConverged(first int64, last int64, goal int64) bool {
+	if first <= 0 {
+		return true
+	}
+
+	return last*100 <= first*(100-goal)
+}
+
+// preCopy pre-dumps the memory of the running container into checkpointDir,
+// sending each pre-dump to the sink along with what changed on the
+// filesystem since the previous one if it's sent with zfs. It stops once
+// enough memory was pre-copied or after the configured number of pre-dumps,
+// returning the name of the last one, empty if none could be done.
+func (s *migrationSourceWs) preCopy(checkpointDir string, driver MigrationStorageSourceDriver, fsType MigrationFSType, bwlimit string) (string, error) {
+	config := s.container.ExpandedConfig()
+
+	iterations := int64(10)
+	if config["migration.incremental.memory.iterations"] != "" {
+		iterations, _ = strconv.ParseInt(config["migration.incremental.memory.iterations"], 10, 64)
+	}
+
+	goal := int64(70)
+	if config["migration.incremental.memory.goal"] != "" {
+		goal, _ = strconv.ParseInt(config["migration.incremental.memory.goal"], 10, 64)
+	}
+
+	incremental, ok := driver.(migrationIncrementalSource)
+	sendFs := ok && fsType == MigrationFSType_ZFS
+
+	ctName, _, _ := containerGetParentAndSnapshotName(s.container.Name())
+	last := ""
+	first := int64(-1)
+	for i := int64(0); i < iterations; i++ {
+		name := fmt.Sprintf("pre-dump-%d", i)
+		dir := filepath.Join(checkpointDir, name)
+		err := os.Mkdir(dir, 0700)
+		if err != nil {
+			return last, err
+		}
+
+		err = s.container.Migrate(lxc.MIGRATE_PRE_DUMP, dir, "migration", false, false, last)
+		if err != nil {
+			// Not all kernels and versions of CRIU can pre-dump,
+			// the final dump then copies what's left.
+			logger.Warnf("Stopping the pre-copy of %s after %d pre-dumps: %s", s.container.Name(), i, err)
+			os.RemoveAll(dir)
+			return last, nil
+		}
+		last = name
+
+		err = migrationSendSync(s.criuConn, false)
+		if err != nil {
+			return last, err
+		}
+
+		err = RsyncSend(ctName, shared.AddSlash(checkpointDir), s.criuConn, nil, bwlimit, nil)
+		if err != nil {
+			return last, err
+		}
+
+		if sendFs {
+			err = migrationSendSync(s.fsConn, false)
+			if err != nil {
+				return last, err
+			}
+
+			err = incremental.SendIncremental(s.fsConn)
+			if err != nil {
+				return last, err
+			}
+		}
+
+		size, err := migrationPreDumpSize(dir)
+		if err != nil {
+			return last, err
+		}
+
+		if first < 0 {
+			first = size
+		}
+
+		if migrationPreCopyConverged(first, size, goal) {
+			break
+		}
+	}
+
+	return last, nil
+}
+
+// migrationFsFallback returns why a sink storing containers as fsType has to
+// receive them with rsync from a source which can send them as offered, empty
+// if it doesn't have to. The block devices of the source and sink use
+// sourceBlockFilesystem and blockFilesystem.
+func migrationFsFallback(fsType MigrationFSType, offered []MigrationFSType, live bool, sourceBlockFilesystem string, blockFilesystem string) string {
+	if fsType == MigrationFSType_RSYNC {
+		return ""
+	}
+
+	found := false
+	for _, t := range offered {
+		if t == fsType {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return fmt.Sprintf("The source can't send %s streams", strings.ToLower(fsType.String()))
+	}
+
+	// Block devices can only be sent as they are if both ends use the
+	// same filesystem and they can't be resynced after a checkpoint.
+	if fsType == MigrationFSType_BLOCK {
+		if live {
+			return "Block devices can't be resynced after a checkpoint"
+		}
+
+		if sourceBlockFilesystem != blockFilesystem {
+			return fmt.Sprintf("The block devices of the source use %s rather than %s", sourceBlockFilesystem, blockFilesystem)
+		}
+	}
+
+	return ""
+}
+
+// migrationStrategy describes how a migration was negotiated to be done, from
+// the header the sink answered with.
+func migrationStrategy(header *MigrationHeader) map[string]interface{} {
+	criu := ""
+	if header.Criu != nil {
+		criu = strings.TrimPrefix(strings.ToLower(header.GetCriu().String()), "criu_")
+	}
+
+	rsyncFeatures := header.GetRsyncFeatures()
+	if rsyncFeatures == nil || header.GetFs() != MigrationFSType_RSYNC {
+		rsyncFeatures = []string{}
+	}
+
+	return map[string]interface{}{
+		"fs":             strings.ToLower(header.GetFs().String()),
+		"fs_fallback":    header.GetFsFallback(),
+		"criu":           criu,
+		"compression":    header.GetCompression(),
+		"checksum":       header.GetChecksum(),
+		"rsync_features": rsyncFeatures,
+		"pre_copy":       header.GetPreCopy(),
+	}
+}
+
+// migrationStrategySet exposes how a migration is done in the "migration"
+// metadata of its operation.
+func migrationStrategySet(op *operation, header *MigrationHeader) {
+	operationMetadataSet(op, "migration", migrationStrategy(header))
+}
+
 func snapshotToProtobuf(c container) *Snapshot {
 	config := []*Config{}
 	for k, v := range c.LocalConfig() {
@@ -356,6 +591,22 @@ func snapshotToProtobuf(c container) *Snapshot {
 func (s *migrationSourceWs) Do(migrateOp *operation) error {
 	<-s.allConnected
 
+	done, err := transferStart(transferMigrationSource, s.container.Name())
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	storageFreezeEnter()
+	defer storageFreezeLeave()
+
+	/* Only queue once the sink is connected, it then already got its turn
+	 * and no migration can wait for its sink while holding the turn of
+	 * another.
+	 */
+	release := migrationsOutgoing.wait(migrateOp, migrationPriority(s.container))
+	defer release()
+
 	criuType := CRIUType_CRIU_RSYNC.Enum()
 	if !s.live {
 		criuType = nil
@@ -418,6 +669,66 @@ func (s *migrationSourceWs) Do(migrateOp *operation) error {
 		Snapshots:     snapshots,
 	}
 
+	// Everything can be sent with rsync.
+	header.FsTypes = []MigrationFSType{myType}
+	if myType != MigrationFSType_RSYNC {
+		header.FsTypes = append(header.FsTypes, MigrationFSType_RSYNC)
+	}
+
+	if myType == MigrationFSType_BLOCK {
+		blockFilesystem := storageBlockFilesystem(s.container.Storage())
+		header.BlockFilesystem = &blockFilesystem
+	}
+
+	// Offer to compress the zfs streams if the pool asks for it.
+	poolConfig := s.container.Storage().GetStoragePoolWritable().Config
+	compression := poolConfig["zfs.migration.compression"]
+	if myType == MigrationFSType_ZFS && compression != "" && compression != "none" && migrationCompressionSupported(compression) {
+		header.Compression = &compression
+	}
+
+	// Offer to verify the zfs streams if the pool asks for it.
+	if myType == MigrationFSType_ZFS && shared.IsTrue(poolConfig["zfs.migration.checksum"]) {
+		header.Checksum = proto.Bool(true)
+	}
+
+	// zfs send leaves the properties of the dataset behind, so send them
+	// along for the sink to set them again.
+	if myType == MigrationFSType_ZFS {
+		dataset, _, ok := zfsContainerDataset(s.container)
+		if ok {
+			properties, err := zfsDatasetLocalProperties(dataset)
+			if err != nil {
+				logger.Warn("Not sending the properties of the container's dataset", log.Ctx{"container": s.container.Name(), "err": err})
+			}
+
+			for _, property := range zfsMigrationProperties {
+				value, ok := properties[property]
+				if !ok {
+					continue
+				}
+
+				header.Properties = append(header.Properties, &Config{Key: proto.String(property), Value: proto.String(value)})
+			}
+		}
+	}
+
+	// Offer the rsync features the pool asks for, in case we end up using
+	// rsync.
+	header.RsyncFeatures = rsyncPoolFeatures(poolConfig)
+
+	// Offer to only send what changed since a snapshot the sink already
+	// has, in case it is refreshing a copy of the container.
+	_, ok := driver.(migrationRefreshSource)
+	if ok && myType == MigrationFSType_ZFS && !s.containerOnly && !s.container.IsSnapshot() {
+		header.Refresh = proto.Bool(true)
+	}
+
+	// Offer to pre-copy the memory if the container asks for it.
+	if s.live && shared.IsTrue(s.container.ExpandedConfig()["migration.incremental.memory"]) {
+		header.PreCopy = proto.Bool(true)
+	}
+
 	err = s.send(&header)
 	if err != nil {
 		s.sendControl(err)
@@ -435,20 +746,74 @@ func (s *migrationSourceWs) Do(migrateOp *operation) error {
 		return err
 	}
 
-	bwlimit := ""
 	if *header.Fs != myType {
+		logger.Info("Falling back to rsync for the migration", log.Ctx{"container": s.container.Name(), "reason": header.GetFsFallback()})
+
 		myType = MigrationFSType_RSYNC
 		header.Fs = &myType
 
 		driver, _ = rsyncMigrationSource(s.container, s.containerOnly)
+	}
+
+	migrationStrategySet(migrateOp, &header)
+
+	// Only send what changed since the snapshot the sink picked if it is
+	// refreshing its copy of the container.
+	if header.GetRefreshBase() != "" {
+		refresher, ok := driver.(migrationRefreshSource)
+		if !ok || myType != MigrationFSType_ZFS {
+			err := fmt.Errorf("The source can't refresh the target's copy of the container")
+			s.sendControl(err)
+			return err
+		}
 
-		// Check if this storage pool has a rate limit set for rsync.
-		poolwritable := s.container.Storage().GetStoragePoolWritable()
-		if poolwritable.Config != nil {
-			bwlimit = poolwritable.Config["rsync.bwlimit"]
+		err := refresher.SetRefreshBase(header.GetRefreshBase(), header.GetRefreshGuid())
+		if err != nil {
+			s.sendControl(err)
+			return err
 		}
 	}
 
+	// Check if this storage pool has a rate limit set for rsync, which
+	// also applies to the zfs streams.
+	bwlimit := ""
+	if poolConfig != nil {
+		bwlimit = poolConfig["rsync.bwlimit"]
+	}
+
+	// Compress the streams if the sink accepted to.
+	compressor, ok := driver.(migrationCompressionSource)
+	if ok && myType == MigrationFSType_ZFS && header.GetCompression() != "" {
+		level, _ := strconv.Atoi(poolConfig["zfs.migration.compression_level"])
+		compressor.SetCompression(header.GetCompression(), level)
+	}
+
+	// Send the checksums of the streams if the sink accepted to verify them.
+	checksummer, ok := driver.(migrationChecksumSource)
+	if ok && myType == MigrationFSType_ZFS && header.GetChecksum() {
+		checksummer.SetChecksum(true)
+	}
+
+	// Use the rsync features the sink accepted, older ones accept none.
+	rsyncer, ok := driver.(migrationRsyncSource)
+	if ok && myType == MigrationFSType_RSYNC {
+		rsyncer.SetRsync(rsyncFeaturesSupported(header.RsyncFeatures), rsyncPoolArgs(poolConfig))
+	}
+
+	// Pre-copy the memory if the sink accepted to.
+	preCopy := s.live && header.GetPreCopy()
+
+	stageNames := []string{"transfer"}
+	if preCopy {
+		stageNames = append(stageNames, "pre-copy")
+	}
+	if s.live {
+		stageNames = append(stageNames, "checkpoint", "checkpoint transfer", "final sync")
+	}
+	stageNames = append(stageNames, "restore")
+	stages := operationStagesSet(migrateOp, stageNames...)
+	defer stages.fail()
+
 	// All failure paths need to do a few things to correctly handle errors before returning.
 	// Unfortunately, handling errors is not well-suited to defer as the code depends on the
 	// status of driver and the error value.  The error value is especially tricky due to the
@@ -462,6 +827,13 @@ func (s *migrationSourceWs) Do(migrateOp *operation) error {
 		return err
 	}
 
+	// The container is expected to take about as much to send as it uses.
+	usage, err := s.container.Storage().ContainerGetUsage(s.container)
+	if err != nil {
+		usage = 0
+	}
+
+	stages.start("transfer", usage)
 	err = driver.SendWhileRunning(s.fsConn, migrateOp, bwlimit, s.containerOnly)
 	if err != nil {
 		return abort(err)
@@ -481,6 +853,29 @@ func (s *migrationSourceWs) Do(migrateOp *operation) error {
 			return abort(err)
 		}
 
+		/* With a pre-copy, the final dump goes next to the pre-dumps
+		 * and only has the memory changed since the last one.
+		 */
+		stateDir := checkpointDir
+		preDumpDir := ""
+		if preCopy {
+			stages.start("pre-copy", 0)
+			preDumpDir, err = s.preCopy(checkpointDir, driver, myType, bwlimit)
+			if err != nil {
+				os.RemoveAll(checkpointDir)
+				return abort(err)
+			}
+
+			stateDir = filepath.Join(checkpointDir, "final")
+			err = os.Mkdir(stateDir, 0700)
+			if err != nil {
+				os.RemoveAll(checkpointDir)
+				return abort(err)
+			}
+		}
+
+		stages.start("checkpoint", 0)
+
 		if lxc.VersionAtLeast(2, 0, 4) {
 			/* What happens below is slightly convoluted. Due to various
 			 * complications with networking, there's no easy way for criu
@@ -543,7 +938,7 @@ func (s *migrationSourceWs) Do(migrateOp *operation) error {
 				return abort(err)
 			}
 
-			err = writeActionScript(checkpointDir, actionScriptOp.url, actionScriptOpSecret)
+			err = writeActionScript(stateDir, actionScriptOp.url, actionScriptOpSecret)
 			if err != nil {
 				os.RemoveAll(checkpointDir)
 				return abort(err)
@@ -556,7 +951,7 @@ func (s *migrationSourceWs) Do(migrateOp *operation) error {
 			}
 
 			go func() {
-				dumpSuccess <- s.container.Migrate(lxc.MIGRATE_DUMP, checkpointDir, "migration", true, true)
+				dumpSuccess <- s.container.Migrate(lxc.MIGRATE_DUMP, stateDir, "migration", true, true, preDumpDir)
 				os.RemoveAll(checkpointDir)
 			}()
 
@@ -570,7 +965,7 @@ func (s *migrationSourceWs) Do(migrateOp *operation) error {
 			}
 		} else {
 			defer os.RemoveAll(checkpointDir)
-			err = s.container.Migrate(lxc.MIGRATE_DUMP, checkpointDir, "migration", true, false)
+			err = s.container.Migrate(lxc.MIGRATE_DUMP, stateDir, "migration", true, false, preDumpDir)
 			if err != nil {
 				return abort(err)
 			}
@@ -583,12 +978,28 @@ func (s *migrationSourceWs) Do(migrateOp *operation) error {
 		 * no reason to do these in parallel. In the future when we're using
 		 * p.haul's protocol, it will make sense to do these in parallel.
 		 */
+		stages.start("checkpoint transfer", 0)
+		if preCopy {
+			err = migrationSendSync(s.criuConn, true)
+			if err != nil {
+				return abort(err)
+			}
+		}
+
 		ctName, _, _ := containerGetParentAndSnapshotName(s.container.Name())
-		err = RsyncSend(ctName, shared.AddSlash(checkpointDir), s.criuConn, nil, bwlimit)
+		err = RsyncSend(ctName, shared.AddSlash(checkpointDir), s.criuConn, nil, bwlimit, nil)
 		if err != nil {
 			return abort(err)
 		}
 
+		stages.start("final sync", 0)
+		if preCopy && myType == MigrationFSType_ZFS {
+			err = migrationSendSync(s.fsConn, true)
+			if err != nil {
+				return abort(err)
+			}
+		}
+
 		err = driver.SendAfterCheckpoint(s.fsConn, bwlimit)
 		if err != nil {
 			return abort(err)
@@ -597,6 +1008,8 @@ func (s *migrationSourceWs) Do(migrateOp *operation) error {
 
 	driver.Cleanup()
 
+	// Wait for the target to be done with the container.
+	stages.start("restore", 0)
 	msg := MigrationControl{}
 	err = s.recv(&msg)
 	if err != nil {
@@ -616,6 +1029,7 @@ func (s *migrationSourceWs) Do(migrateOp *operation) error {
 		return fmt.Errorf(*msg.Message)
 	}
 
+	stages.finish(nil)
 	return nil
 }
 
@@ -631,6 +1045,9 @@ type migrationSink struct {
 	dialer       websocket.Dialer
 	allConnected chan bool
 	push         bool
+
+	// Whether the container is an existing copy being refreshed.
+	refresh bool
 }
 
 type MigrationSinkArgs struct {
@@ -641,15 +1058,26 @@ type MigrationSinkArgs struct {
 	Push          bool
 	Live          bool
 	ContainerOnly bool
+	Refresh       bool
+
+	// Storage specific fields, set by the sink for the storage driver
+	Snapshots     []*Snapshot
+	Idmap         *shared.IdmapSet
+	Compression   string
+	Checksum      bool
+	RsyncFeatures []string
+	PreCopy       bool
+	Properties    map[string]string
 }
 
 func NewMigrationSink(args *MigrationSinkArgs) (*migrationSink, error) {
 	sink := migrationSink{
-		src:    migrationFields{container: args.Container, containerOnly: args.ContainerOnly},
-		dest:   migrationFields{containerOnly: args.ContainerOnly},
-		url:    args.Url,
-		dialer: args.Dialer,
-		push:   args.Push,
+		src:     migrationFields{container: args.Container, containerOnly: args.ContainerOnly},
+		dest:    migrationFields{containerOnly: args.ContainerOnly},
+		url:     args.Url,
+		dialer:  args.Dialer,
+		push:    args.Push,
+		refresh: args.Refresh,
 	}
 
 	if sink.push {
@@ -766,10 +1194,23 @@ func (s *migrationSink) Connect(op *operation, r *http.Request, w http.ResponseW
 func (c *migrationSink) Do(migrateOp *operation) error {
 	var err error
 
+	// Wait for our turn before the source can start sending.
+	release := migrationsIncoming.wait(migrateOp, migrationPriority(c.src.container))
+	defer release()
+
+	storageFreezeEnter()
+	defer storageFreezeLeave()
+
 	if c.push {
 		<-c.allConnected
 	}
 
+	done, err := transferStart(transferMigrationTarget, c.src.container.Name())
+	if err != nil {
+		return err
+	}
+	defer done()
+
 	disconnector := c.src.disconnect
 	if c.push {
 		disconnector = c.dest.disconnect
@@ -837,12 +1278,81 @@ func (c *migrationSink) Do(migrateOp *operation) error {
 		Criu: criuType,
 	}
 
-	// If the storage type the source has doesn't match what we have, then
-	// we have to use rsync.
-	if *header.Fs != *resp.Fs {
+	// Older sources only offer the type of their storage and rsync.
+	fsTypes := header.GetFsTypes()
+	if len(fsTypes) == 0 {
+		fsTypes = []MigrationFSType{header.GetFs(), MigrationFSType_RSYNC}
+	}
+
+	blockFilesystem := ""
+	if myType == MigrationFSType_BLOCK {
+		blockFilesystem = storageBlockFilesystem(c.src.container.Storage())
+	}
+
+	// If the source can't send what we store, then we have to use rsync.
+	fallback := migrationFsFallback(myType, fsTypes, live, header.GetBlockFilesystem(), blockFilesystem)
+	if fallback != "" {
+		logger.Info("Falling back to rsync for the migration", log.Ctx{"container": c.src.container.Name(), "reason": fallback})
+
 		mySink = rsyncMigrationSink
 		myType = MigrationFSType_RSYNC
 		resp.Fs = &myType
+		resp.FsFallback = &fallback
+	}
+
+	// Accept to receive compressed zfs streams if we can decompress them.
+	compression := ""
+	if myType == MigrationFSType_ZFS && header.GetCompression() != "" && migrationCompressionSupported(header.GetCompression()) {
+		compression = header.GetCompression()
+		resp.Compression = &compression
+	}
+
+	// Accept to verify the checksums of the zfs streams.
+	checksum := myType == MigrationFSType_ZFS && header.GetChecksum()
+	if checksum {
+		resp.Checksum = proto.Bool(true)
+	}
+
+	// Accept the rsync features we know of.
+	rsyncFeatures := []string{}
+	if myType == MigrationFSType_RSYNC {
+		rsyncFeatures = rsyncFeaturesSupported(header.RsyncFeatures)
+		resp.RsyncFeatures = rsyncFeatures
+	}
+
+	// Accept to restore from pre-dumps.
+	preCopy := live && header.GetPreCopy()
+	if preCopy {
+		resp.PreCopy = proto.Bool(true)
+	}
+
+	// Refreshing our copy of the container, only receive what changed
+	// since the latest snapshot we share with the source.
+	refreshSnapshots := []*Snapshot{}
+	if c.refresh {
+		if myType != MigrationFSType_ZFS || !header.GetRefresh() {
+			err := fmt.Errorf("Refreshing containers is only supported between ZFS storage pools")
+			controller(err)
+			return err
+		}
+
+		base, guid, snapshots, err := migrationRefreshPrepare(c.src.container, header.Snapshots)
+		if err != nil {
+			controller(err)
+			return err
+		}
+
+		resp.RefreshBase = &base
+		resp.RefreshGuid = &guid
+		refreshSnapshots = snapshots
+	}
+
+	// Set the properties of the source's dataset again on ours.
+	properties := map[string]string{}
+	if myType == MigrationFSType_ZFS {
+		for _, property := range header.GetProperties() {
+			properties[property.GetKey()] = property.GetValue()
+		}
 	}
 
 	err = sender(&resp)
@@ -851,6 +1361,14 @@ func (c *migrationSink) Do(migrateOp *operation) error {
 		return err
 	}
 
+	migrationStrategySet(migrateOp, &resp)
+
+	stageNames := []string{"transfer", "idmap shift"}
+	if live {
+		stageNames = append(stageNames, "restore")
+	}
+	stages := operationStagesSet(migrateOp, stageNames...)
+
 	restore := make(chan error)
 	go func(c *migrationSink) {
 		imagesDir := ""
@@ -886,6 +1404,8 @@ func (c *migrationSink) Do(migrateOp *operation) error {
 					base.Name = &name
 					snapshots = append(snapshots, base)
 				}
+			} else if c.refresh {
+				snapshots = refreshSnapshots
 			} else {
 				snapshots = header.Snapshots
 			}
@@ -897,12 +1417,28 @@ func (c *migrationSink) Do(migrateOp *operation) error {
 				fsConn = c.src.fsConn
 			}
 
-			err = mySink(live, c.src.container, snapshots, fsConn, srcIdmap, migrateOp, c.src.containerOnly)
+			stages.start("transfer", 0)
+			args := MigrationSinkArgs{
+				Container:     c.src.container,
+				ContainerOnly: c.src.containerOnly,
+				Live:          live,
+				Refresh:       c.refresh,
+				Snapshots:     snapshots,
+				Idmap:         srcIdmap,
+				Compression:   compression,
+				Checksum:      checksum,
+				RsyncFeatures: rsyncFeatures,
+				PreCopy:       preCopy,
+				Properties:    properties,
+			}
+
+			err = mySink(fsConn, migrateOp, args)
 			if err != nil {
 				fsTransfer <- err
 				return
 			}
 
+			stages.start("idmap shift", 0)
 			err = ShiftIfNecessary(c.src.container, srcIdmap)
 			if err != nil {
 				fsTransfer <- err
@@ -929,10 +1465,28 @@ func (c *migrationSink) Do(migrateOp *operation) error {
 				criuConn = c.src.criuConn
 			}
 
-			err = RsyncRecv(shared.AddSlash(imagesDir), criuConn, nil)
-			if err != nil {
-				restore <- err
-				return
+			/* With a pre-copy, the pre-dumps come first and the
+			 * final dump ends up next to them.
+			 */
+			for {
+				final := true
+				if preCopy {
+					final, err = migrationRecvSync(criuConn)
+					if err != nil {
+						restore <- err
+						return
+					}
+				}
+
+				err = RsyncRecv(shared.AddSlash(imagesDir), criuConn, nil, nil)
+				if err != nil {
+					restore <- err
+					return
+				}
+
+				if final {
+					break
+				}
 			}
 		}
 
@@ -943,7 +1497,13 @@ func (c *migrationSink) Do(migrateOp *operation) error {
 		}
 
 		if live {
-			err = c.src.container.Migrate(lxc.MIGRATE_RESTORE, imagesDir, "migration", false, false)
+			stages.start("restore", 0)
+			stateDir := imagesDir
+			if preCopy {
+				stateDir = filepath.Join(imagesDir, "final")
+			}
+
+			err = c.src.container.Migrate(lxc.MIGRATE_RESTORE, stateDir, "migration", false, false, "")
 			if err != nil {
 				restore <- err
 				return
@@ -964,16 +1524,21 @@ func (c *migrationSink) Do(migrateOp *operation) error {
 	for {
 		select {
 		case err = <-restore:
+			stages.finish(err)
 			controller(err)
 			return err
 		case msg, ok := <-source:
 			if !ok {
+				err := fmt.Errorf("Got error reading source")
+				stages.finish(err)
 				disconnector()
-				return fmt.Errorf("Got error reading source")
+				return err
 			}
 			if !*msg.Success {
+				err := fmt.Errorf(*msg.Message)
+				stages.finish(err)
 				disconnector()
-				return fmt.Errorf(*msg.Message)
+				return err
 			} else {
 				// The source can only tell us it failed (e.g. if
 				// checkpointing failed). We have to tell the source
@@ -14,6 +14,7 @@ It has these top-level messages:
 	Device
 	Snapshot
 	MigrationHeader
+	MigrationSync
 	MigrationControl
 */
 package main
@@ -31,17 +32,20 @@ const (
 	MigrationFSType_RSYNC MigrationFSType = 0
 	MigrationFSType_BTRFS MigrationFSType = 1
 	MigrationFSType_ZFS   MigrationFSType = 2
+	MigrationFSType_BLOCK MigrationFSType = 3
 )
 
 var MigrationFSType_name = map[int32]string{
 	0: "RSYNC",
 	1: "BTRFS",
 	2: "ZFS",
+	3: "BLOCK",
 }
 var MigrationFSType_value = map[string]int32{
 	"RSYNC": 0,
 	"BTRFS": 1,
 	"ZFS":   2,
+	"BLOCK": 3,
 }
 
 func (x MigrationFSType) Enum() *MigrationFSType {
@@ -255,12 +259,41 @@ func (m *Snapshot) GetStateful() bool {
 }
 
 type MigrationHeader struct {
-	Fs               *MigrationFSType `protobuf:"varint,1,req,name=fs,enum=main.MigrationFSType" json:"fs,omitempty"`
-	Criu             *CRIUType        `protobuf:"varint,2,opt,name=criu,enum=main.CRIUType" json:"criu,omitempty"`
-	Idmap            []*IDMapType     `protobuf:"bytes,3,rep,name=idmap" json:"idmap,omitempty"`
-	SnapshotNames    []string         `protobuf:"bytes,4,rep,name=snapshotNames" json:"snapshotNames,omitempty"`
-	Snapshots        []*Snapshot      `protobuf:"bytes,5,rep,name=snapshots" json:"snapshots,omitempty"`
-	XXX_unrecognized []byte           `json:"-"`
+	Fs            *MigrationFSType `protobuf:"varint,1,req,name=fs,enum=main.MigrationFSType" json:"fs,omitempty"`
+	Criu          *CRIUType        `protobuf:"varint,2,opt,name=criu,enum=main.CRIUType" json:"criu,omitempty"`
+	Idmap         []*IDMapType     `protobuf:"bytes,3,rep,name=idmap" json:"idmap,omitempty"`
+	SnapshotNames []string         `protobuf:"bytes,4,rep,name=snapshotNames" json:"snapshotNames,omitempty"`
+	Snapshots     []*Snapshot      `protobuf:"bytes,5,rep,name=snapshots" json:"snapshots,omitempty"`
+	// filesystem of the block devices sent with BLOCK
+	BlockFilesystem *string `protobuf:"bytes,6,opt,name=blockFilesystem" json:"blockFilesystem,omitempty"`
+	// compression of the filesystem streams, offered by the source and
+	// confirmed by the sink
+	Compression *string `protobuf:"bytes,7,opt,name=compression" json:"compression,omitempty"`
+	// whether the filesystem streams are followed by their checksums,
+	// offered by the source and confirmed by the sink
+	Checksum *bool `protobuf:"varint,8,opt,name=checksum" json:"checksum,omitempty"`
+	// optional rsync features (e.g. "compress"), offered by the source
+	// and confirmed by the sink
+	RsyncFeatures []string `protobuf:"bytes,9,rep,name=rsyncFeatures" json:"rsyncFeatures,omitempty"`
+	// whether the memory of a live migration is pre-copied with CRIU
+	// pre-dumps before the final dump, offered by the source and
+	// confirmed by the sink
+	PreCopy *bool `protobuf:"varint,10,opt,name=preCopy" json:"preCopy,omitempty"`
+	// filesystem types the source can send, in order of preference, the
+	// sink picking one of them in fs
+	FsTypes []MigrationFSType `protobuf:"varint,11,rep,name=fsTypes,enum=main.MigrationFSType" json:"fsTypes,omitempty"`
+	// why the sink didn't pick the first filesystem type offered
+	FsFallback *string `protobuf:"bytes,12,opt,name=fsFallback" json:"fsFallback,omitempty"`
+	// properties set on the zfs dataset of the container, which zfs send
+	// leaves behind and the sink sets again after receiving it
+	Properties []*Config `protobuf:"bytes,13,rep,name=properties" json:"properties,omitempty"`
+	// whether the source can only send what changed since a snapshot the
+	// sink already has, which the sink then sets in refreshBase along with
+	// the guid of its copy of it
+	Refresh          *bool   `protobuf:"varint,14,opt,name=refresh" json:"refresh,omitempty"`
+	RefreshBase      *string `protobuf:"bytes,15,opt,name=refreshBase" json:"refreshBase,omitempty"`
+	RefreshGuid      *string `protobuf:"bytes,16,opt,name=refreshGuid" json:"refreshGuid,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
 }
 
 func (m *MigrationHeader) Reset()         { *m = MigrationHeader{} }
@@ -302,6 +335,101 @@ func (m *MigrationHeader) GetSnapshots() []*Snapshot {
 	return nil
 }
 
+func (m *MigrationHeader) GetBlockFilesystem() string {
+	if m != nil && m.BlockFilesystem != nil {
+		return *m.BlockFilesystem
+	}
+	return ""
+}
+
+func (m *MigrationHeader) GetCompression() string {
+	if m != nil && m.Compression != nil {
+		return *m.Compression
+	}
+	return ""
+}
+
+func (m *MigrationHeader) GetChecksum() bool {
+	if m != nil && m.Checksum != nil {
+		return *m.Checksum
+	}
+	return false
+}
+
+func (m *MigrationHeader) GetRsyncFeatures() []string {
+	if m != nil {
+		return m.RsyncFeatures
+	}
+	return nil
+}
+
+func (m *MigrationHeader) GetPreCopy() bool {
+	if m != nil && m.PreCopy != nil {
+		return *m.PreCopy
+	}
+	return false
+}
+
+func (m *MigrationHeader) GetFsTypes() []MigrationFSType {
+	if m != nil {
+		return m.FsTypes
+	}
+	return nil
+}
+
+func (m *MigrationHeader) GetFsFallback() string {
+	if m != nil && m.FsFallback != nil {
+		return *m.FsFallback
+	}
+	return ""
+}
+
+func (m *MigrationHeader) GetProperties() []*Config {
+	if m != nil {
+		return m.Properties
+	}
+	return nil
+}
+
+func (m *MigrationHeader) GetRefresh() bool {
+	if m != nil && m.Refresh != nil {
+		return *m.Refresh
+	}
+	return false
+}
+
+func (m *MigrationHeader) GetRefreshBase() string {
+	if m != nil && m.RefreshBase != nil {
+		return *m.RefreshBase
+	}
+	return ""
+}
+
+func (m *MigrationHeader) GetRefreshGuid() string {
+	if m != nil && m.RefreshGuid != nil {
+		return *m.RefreshGuid
+	}
+	return ""
+}
+
+// precedes each checkpoint transfer of a pre-copy, and each zfs stream sent
+// after the container one
+type MigrationSync struct {
+	Final            *bool  `protobuf:"varint,1,req,name=final" json:"final,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *MigrationSync) Reset()         { *m = MigrationSync{} }
+func (m *MigrationSync) String() string { return proto.CompactTextString(m) }
+func (*MigrationSync) ProtoMessage()    {}
+
+func (m *MigrationSync) GetFinal() bool {
+	if m != nil && m.Final != nil {
+		return *m.Final
+	}
+	return false
+}
+
 type MigrationControl struct {
 	Success *bool `protobuf:"varint,1,req,name=success" json:"success,omitempty"`
 	// optional failure message if sending a failure
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+)
+
+// The statuses of the checks of a migration.
+const (
+	migrationCheckOk      = "ok"
+	migrationCheckWarning = "warning"
+	migrationCheckError   = "error"
+)
+
+// migrationCheckSource is what the checks of a migration need to know about
+// the container and its server.
+type migrationCheckSource struct {
+	pool            string
+	fsType          MigrationFSType
+	blockFilesystem string
+
+	// The state ("enabled" or "active") of the features of the zpool.
+	zfsFeatures map[string]string
+
+	criu       bool
+	privileged bool
+	idmapSize  int64
+}
+
+// migrationIdmapSize returns how many uids an idmap maps.
+func migrationIdmapSize(set *shared.IdmapSet) int64 {
+	if set == nil {
+		return 0
+	}
+
+	size := int64(0)
+	for _, entry := range set.Idmap {
+		if entry.Isuid {
+			size += entry.Maprange
+		}
+	}
+
+	return size
+}
+
+// zfsPoolFeaturesParse parses the output of "zpool get -H -o property,value
+// all" into the state of the features of the zpool, leaving out the disabled
+// ones.
+func zfsPoolFeaturesParse(output string) map[string]string {
+	features := map[string]string{}
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !strings.HasPrefix(fields[0], "feature@") {
+			continue
+		}
+
+		if fields[1] != "enabled" && fields[1] != "active" {
+			continue
+		}
+
+		features[strings.TrimPrefix(fields[0], "feature@")] = fields[1]
+	}
+
+	return features
+}
+
+// zfsPoolFeatures returns the state of the features of a zpool.
+func zfsPoolFeatures(zpool string) (map[string]string, error) {
+	output, err := shared.RunCommand("zpool", "get", "-H", "-o", "property,value", "all", zpool)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get the features of the ZFS pool \"%s\": %s", zpool, output)
+	}
+
+	return zfsPoolFeaturesParse(output), nil
+}
+
+// migrationStoragePoolCapability returns how a storage pool receives migrated
+// containers.
+func migrationStoragePoolCapability(d *Daemon, poolName string) (*api.MigrationStoragePoolCapability, error) {
+	_, pool, err := dbStoragePoolGet(d.db, poolName)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := storagePoolInit(d, poolName)
+	if err != nil {
+		return nil, err
+	}
+
+	fsType := s.MigrationType()
+	result := api.MigrationStoragePoolCapability{
+		Name:          poolName,
+		Driver:        pool.Driver,
+		MigrationType: strings.ToLower(fsType.String()),
+	}
+
+	switch fsType {
+	case MigrationFSType_BLOCK:
+		result.BlockFilesystem = pool.Config["volume.block.filesystem"]
+		if result.BlockFilesystem == "" {
+			result.BlockFilesystem = "ext4"
+		}
+	case MigrationFSType_ZFS:
+		zpool := pool.Config["zfs.pool_name"]
+		if zpool == "" {
+			zpool = poolName
+		}
+
+		features, err := zfsPoolFeatures(strings.SplitN(zpool, "/", 2)[0])
+		if err != nil {
+			return nil, err
+		}
+
+		result.ZfsFeatures = []string{}
+		for feature := range features {
+			result.ZfsFeatures = append(result.ZfsFeatures, feature)
+		}
+		sort.Strings(result.ZfsFeatures)
+	}
+
+	return &result, nil
+}
+
+// migrationCapabilities returns what this server supports as the target of a
+// migration.
+func migrationCapabilities(d *Daemon) (*api.MigrationCapabilities, error) {
+	_, err := exec.LookPath("criu")
+
+	caps := api.MigrationCapabilities{
+		CRIU:             err == nil,
+		UnprivilegedOnly: daemonConfig["core.unprivileged_only"].GetBool(),
+		IdmapSize:        migrationIdmapSize(d.IdmapSet),
+		StoragePools:     []api.MigrationStoragePoolCapability{},
+	}
+
+	pools, err := dbStoragePools(d.db)
+	if err != nil && err != NoSuchObjectError {
+		return nil, err
+	}
+
+	for _, poolName := range pools {
+		pool, err := migrationStoragePoolCapability(d, poolName)
+		if err != nil {
+			return nil, err
+		}
+
+		caps.StoragePools = append(caps.StoragePools, *pool)
+	}
+
+	return &caps, nil
+}
+
+// migrationCheck checks whether a container can be migrated to a target,
+// into the given storage pool of the target or the one of the same name as
+// the container's if empty.
+func migrationCheck(source migrationCheckSource, target api.MigrationCapabilities, poolName string, live bool) api.ContainerMigrationCheck {
+	result := api.ContainerMigrationCheck{Compatible: true, Checks: []api.ContainerMigrationCheckResult{}}
+
+	add := func(name string, status string, message string) {
+		if status == migrationCheckError {
+			result.Compatible = false
+		}
+
+		result.Checks = append(result.Checks, api.ContainerMigrationCheckResult{Name: name, Status: status, Message: message})
+	}
+
+	if poolName == "" {
+		poolName = source.pool
+	}
+
+	var pool *api.MigrationStoragePoolCapability
+	for i := range target.StoragePools {
+		if target.StoragePools[i].Name == poolName {
+			pool = &target.StoragePools[i]
+			break
+		}
+	}
+
+	// Storage
+	if pool == nil {
+		add("storage", migrationCheckError, fmt.Sprintf("The target has no storage pool %s", poolName))
+	} else {
+		fsType := MigrationFSType(MigrationFSType_value[strings.ToUpper(pool.MigrationType)])
+		offered := []MigrationFSType{source.fsType, MigrationFSType_RSYNC}
+
+		fallback := migrationFsFallback(fsType, offered, live, source.blockFilesystem, pool.BlockFilesystem)
+		if fallback != "" {
+			fsType = MigrationFSType_RSYNC
+		}
+
+		result.Fs = strings.ToLower(fsType.String())
+		if fallback != "" {
+			add("storage", migrationCheckWarning, fmt.Sprintf("Sent with rsync: %s", fallback))
+		} else {
+			add("storage", migrationCheckOk, fmt.Sprintf("Sent with %s", result.Fs))
+		}
+
+		// Streams of datasets using features the target zpool
+		// doesn't support can't be received.
+		if fsType == MigrationFSType_ZFS {
+			missing := []string{}
+			for feature, state := range source.zfsFeatures {
+				if state == "active" && !shared.StringInSlice(feature, pool.ZfsFeatures) {
+					missing = append(missing, feature)
+				}
+			}
+			sort.Strings(missing)
+
+			if len(missing) > 0 {
+				add("zfs_features", migrationCheckError, fmt.Sprintf("The target ZFS pool doesn't support %s", strings.Join(missing, ", ")))
+			} else {
+				add("zfs_features", migrationCheckOk, "The target ZFS pool supports the features in use")
+			}
+		}
+	}
+
+	// CRIU
+	if live {
+		if !source.criu {
+			add("criu", migrationCheckError, "CRIU isn't installed on the source server")
+		} else if !target.CRIU {
+			add("criu", migrationCheckError, "CRIU isn't installed on the target server")
+		} else {
+			add("criu", migrationCheckOk, "CRIU is installed on both servers")
+		}
+	}
+
+	// Idmap
+	if source.privileged {
+		if target.UnprivilegedOnly {
+			add("idmap", migrationCheckError, "The container is privileged and the target only allows unprivileged containers")
+		} else {
+			add("idmap", migrationCheckOk, "The container is privileged")
+		}
+	} else if target.IdmapSize == 0 {
+		add("idmap", migrationCheckError, "The target can't run unprivileged containers")
+	} else if source.idmapSize > target.IdmapSize {
+		add("idmap", migrationCheckError, fmt.Sprintf("The container maps %d ids, the target only has %d", source.idmapSize, target.IdmapSize))
+	} else {
+		add("idmap", migrationCheckOk, fmt.Sprintf("The target has the %d ids the container maps", source.idmapSize))
+	}
+
+	return result
+}
+
+// /1.0/migration
+// Describe what this server supports as the target of a migration.
+func migrationGet(d *Daemon, r *http.Request) Response {
+	caps, err := migrationCapabilities(d)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	return SyncResponse(true, caps)
+}
+
+// /1.0/containers/{name}/migration-check
+// Check whether a container can be migrated to a target described by its
+// /1.0/migration, without starting anything.
+func containerMigrationCheckPost(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+
+	req := api.ContainerMigrationCheckPost{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	c, err := containerLoadByName(d, name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	s, err := storagePoolVolumeContainerLoadInit(d, name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	poolName, err := c.StoragePool()
+	if err != nil {
+		return SmartError(err)
+	}
+
+	_, criuErr := exec.LookPath("criu")
+
+	source := migrationCheckSource{
+		pool:       poolName,
+		fsType:     s.MigrationType(),
+		criu:       criuErr == nil,
+		privileged: c.IsPrivileged(),
+	}
+
+	switch source.fsType {
+	case MigrationFSType_BLOCK:
+		source.blockFilesystem = storageBlockFilesystem(s)
+	case MigrationFSType_ZFS:
+		zpool := s.GetStoragePoolWritable().Config["zfs.pool_name"]
+		if zpool == "" {
+			zpool = poolName
+		}
+
+		source.zfsFeatures, err = zfsPoolFeatures(strings.SplitN(zpool, "/", 2)[0])
+		if err != nil {
+			return SmartError(err)
+		}
+	}
+
+	if !source.privileged {
+		idmapset, err := c.IdmapSet()
+		if err != nil {
+			return SmartError(err)
+		}
+
+		source.idmapSize = migrationIdmapSize(idmapset)
+	}
+
+	return SyncResponse(true, migrationCheck(source, req.Target, req.Pool, req.Live && c.IsRunning()))
+}
+
+var migrationCmd = Command{name: "migration", get: migrationGet}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/lxc/lxd/shared/api"
+)
+
+func TestZfsPoolFeaturesParse(t *testing.T) {
+	output := `size	9.94G
+feature@async_destroy	enabled
+feature@empty_bpobj	active
+feature@large_dnode	disabled
+feature@encryption	enabled
+`
+
+	features := zfsPoolFeaturesParse(output)
+	expected := map[string]string{"async_destroy": "enabled", "empty_bpobj": "active", "encryption": "enabled"}
+	if len(features) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, features)
+	}
+
+	for feature, state := range expected {
+		if features[feature] != state {
+			t.Fatalf("Expected %v, got %v", expected, features)
+		}
+	}
+}
+
+func TestMigrationCheck(t *testing.T) {
+	source := migrationCheckSource{
+		pool:        "default",
+		fsType:      MigrationFSType_ZFS,
+		zfsFeatures: map[string]string{"large_dnode": "active", "encryption": "enabled"},
+		criu:        true,
+		idmapSize:   65536,
+	}
+
+	target := api.MigrationCapabilities{
+		CRIU:      false,
+		IdmapSize: 65536,
+		StoragePools: []api.MigrationStoragePoolCapability{
+			{Name: "default", Driver: "zfs", MigrationType: "zfs", ZfsFeatures: []string{"async_destroy"}},
+			{Name: "fast", Driver: "btrfs", MigrationType: "btrfs"},
+		},
+	}
+
+	statuses := func(result api.ContainerMigrationCheck) map[string]string {
+		m := map[string]string{}
+		for _, check := range result.Checks {
+			m[check.Name] = check.Status
+		}
+
+		return m
+	}
+
+	// The source zpool uses a feature the target lacks.
+	result := migrationCheck(source, target, "", false)
+	s := statuses(result)
+	if result.Compatible || result.Fs != "zfs" || s["storage"] != "ok" || s["zfs_features"] != "error" || s["idmap"] != "ok" {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+
+	_, ok := s["criu"]
+	if ok {
+		t.Fatal("Checked CRIU for a migration which isn't live")
+	}
+
+	// Falling back to rsync doesn't need the zfs features.
+	result = migrationCheck(source, target, "fast", true)
+	s = statuses(result)
+	if result.Compatible || result.Fs != "rsync" || s["storage"] != "warning" || s["criu"] != "error" {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+
+	_, ok = s["zfs_features"]
+	if ok {
+		t.Fatal("Checked the zfs features of a migration using rsync")
+	}
+
+	result = migrationCheck(source, target, "missing", false)
+	if result.Compatible || statuses(result)["storage"] != "error" {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+
+	// The target doesn't have enough ids.
+	target.IdmapSize = 1000
+	result = migrationCheck(source, target, "fast", false)
+	if result.Compatible || statuses(result)["idmap"] != "error" {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+
+	// Privileged containers don't need any.
+	source.privileged = true
+	result = migrationCheck(source, target, "fast", false)
+	if !result.Compatible {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+
+	target.UnprivilegedOnly = true
+	result = migrationCheck(source, target, "fast", false)
+	if result.Compatible || statuses(result)["idmap"] != "error" {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/logger"
+	"github.com/lxc/lxd/shared/version"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// migrationPartialKey marks the containers created to receive a migration
+// until it succeeds, so that those a crash of LXD left half received can be
+// told apart and purged.
+const migrationPartialKey = "volatile.migration.partial"
+
+// migrationPartialList returns the containers left behind by migrations which
+// never completed, leaving out those still being received.
+func migrationPartialList(d *Daemon) ([]container, error) {
+	names, err := dbContainersList(d.db, cTypeRegular)
+	if err != nil {
+		return nil, err
+	}
+
+	partial := []container{}
+	for _, name := range names {
+		if operationsUsing("containers", name) {
+			continue
+		}
+
+		c, err := containerLoadByName(d, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if !shared.IsTrue(c.LocalConfig()[migrationPartialKey]) {
+			continue
+		}
+
+		partial = append(partial, c)
+	}
+
+	return partial, nil
+}
+
+// /1.0/migration/partial
+// List the containers left behind by migrations which never completed.
+func migrationPartialGet(d *Daemon, r *http.Request) Response {
+	partial, err := migrationPartialList(d)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	urls := []string{}
+	for _, c := range partial {
+		urls = append(urls, fmt.Sprintf("/%s/containers/%s", version.APIVersion, c.Name()))
+	}
+
+	return SyncResponse(true, urls)
+}
+
+// Delete the containers left behind by migrations which never completed, with
+// whatever they received, so that the migrations can be retried.
+func migrationPartialDelete(d *Daemon, r *http.Request) Response {
+	partial, err := migrationPartialList(d)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	names := []string{}
+	for _, c := range partial {
+		names = append(names, c.Name())
+	}
+
+	run := func(op *operation) error {
+		for _, c := range partial {
+			logger.Info("Purging a partially received container", log.Ctx{"container": c.Name()})
+
+			err := c.Delete()
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	resources := map[string][]string{}
+	resources["containers"] = names
+
+	op, err := operationCreate(operationClassTask, resources, nil, run, nil, nil)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return OperationResponse(op)
+}
+
+var migrationPartialCmd = Command{name: "migration/partial", get: migrationPartialGet, delete: migrationPartialDelete}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// migrationQueue limits how many migrations run at once in one direction,
+// the others waiting for their turn by priority, then in order of arrival.
+type migrationQueue struct {
+	// Maximum number of migrations running at once, 0 for no limit.
+	limit func() int
+
+	lock    sync.Mutex
+	running int
+	waiting []*migrationQueueEntry
+	seq     int64
+}
+
+type migrationQueueEntry struct {
+	priority int
+	seq      int64
+	ready    chan struct{}
+}
+
+// migrationQueueByPriority sorts the waiting migrations, highest priority
+// first.
+type migrationQueueByPriority []*migrationQueueEntry
+
+func (a migrationQueueByPriority) Len() int      { return len(a) }
+func (a migrationQueueByPriority) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a migrationQueueByPriority) Less(i, j int) bool {
+	if a[i].priority != a[j].priority {
+		return a[i].priority > a[j].priority
+	}
+
+	return a[i].seq < a[j].seq
+}
+
+// The outgoing and incoming migrations, limited by
+// "storage.migrations_outgoing" and "storage.migrations_incoming".
+var migrationsOutgoing = &migrationQueue{limit: func() int {
+	return int(daemonConfig["storage.migrations_outgoing"].GetInt64())
+}}
+
+var migrationsIncoming = &migrationQueue{limit: func() int {
+	return int(daemonConfig["storage.migrations_incoming"].GetInt64())
+}}
+
+// migrationPriority returns the priority of the migration of a container,
+// from its "migration.priority".
+func migrationPriority(c container) int {
+	priority, err := strconv.Atoi(c.ExpandedConfig()["migration.priority"])
+	if err != nil {
+		return 5
+	}
+
+	return priority
+}
+
+// enqueue adds a migration to the queue, returning the channel closed once
+// it may run.
+func (q *migrationQueue) enqueue(priority int) chan struct{} {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.seq++
+	e := &migrationQueueEntry{priority: priority, seq: q.seq, ready: make(chan struct{})}
+	q.waiting = append(q.waiting, e)
+	sort.Sort(migrationQueueByPriority(q.waiting))
+
+	q.dispatch()
+	return e.ready
+}
+
+// release lets the next migration run once one is done.
+func (q *migrationQueue) release() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.running--
+	q.dispatch()
+}
+
+// dispatch starts the waiting migrations there's room for, the lock being
+// held.
+func (q *migrationQueue) dispatch() {
+	limit := q.limit()
+
+	for len(q.waiting) > 0 && (limit <= 0 || q.running < limit) {
+		e := q.waiting[0]
+		q.waiting = q.waiting[1:]
+		q.running++
+		close(e.ready)
+	}
+}
+
+// wait waits for the turn of a migration of the given priority, exposing
+// that it's queued in the "queued" metadata of its operation meanwhile. It
+// returns the function to call once it's done.
+func (q *migrationQueue) wait(op *operation, priority int) func() {
+	ready := q.enqueue(priority)
+
+	select {
+	case <-ready:
+	default:
+		operationMetadataSet(op, "queued", true)
+		<-ready
+		operationMetadataSet(op, "queued", false)
+	}
+
+	once := sync.Once{}
+	return func() {
+		once.Do(q.release)
+	}
+}
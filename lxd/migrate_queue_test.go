@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMigrationQueuePriority(t *testing.T) {
+	q := &migrationQueue{limit: func() int { return 1 }}
+
+	first := q.enqueue(5)
+	low := q.enqueue(1)
+	high := q.enqueue(9)
+	medium := q.enqueue(5)
+
+	isReady := func(ch chan struct{}) bool {
+		select {
+		case <-ch:
+			return true
+		default:
+			return false
+		}
+	}
+
+	if !isReady(first) {
+		t.Fatal("The first migration didn't run right away")
+	}
+
+	if isReady(low) || isReady(high) || isReady(medium) {
+		t.Fatal("A migration ran over the limit")
+	}
+
+	for _, next := range []chan struct{}{high, medium, low} {
+		q.release()
+		if !isReady(next) {
+			t.Fatal("The migrations didn't run by priority")
+		}
+	}
+
+	q.release()
+	if q.running != 0 || len(q.waiting) != 0 {
+		t.Fatalf("Expected an empty queue, got %d running and %d waiting", q.running, len(q.waiting))
+	}
+}
+
+func TestMigrationQueueUnlimited(t *testing.T) {
+	q := &migrationQueue{limit: func() int { return 0 }}
+
+	for i := 0; i < 10; i++ {
+		select {
+		case <-q.enqueue(5):
+		default:
+			t.Fatalf("Migration %d waited with no limit", i)
+		}
+	}
+}
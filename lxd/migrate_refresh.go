@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lxc/lxd/shared"
+)
+
+// migrationRefreshBase returns the latest of the source's snapshots which the
+// target also has, a refresh only sending what changed since.
+func migrationRefreshBase(sourceSnapshots []string, targetSnapshots []string) string {
+	base := ""
+	for _, name := range sourceSnapshots {
+		if shared.StringInSlice(name, targetSnapshots) {
+			base = name
+		}
+	}
+
+	return base
+}
+
+// migrationRefreshPrepare picks the snapshot a refresh of the target's copy of
+// a container starts from. It returns its name, the guid of the target's copy
+// of it and the snapshots the source is left to send. The target's snapshots
+// which the source doesn't have are deleted, as the streams apply on top of
+// the base snapshot.
+func migrationRefreshPrepare(c container, snapshots []*Snapshot) (string, string, []*Snapshot, error) {
+	_, _, ok := zfsContainerDataset(c)
+	if !ok {
+		return "", "", nil, fmt.Errorf("Refreshing containers is only supported on ZFS storage pools")
+	}
+
+	if c.IsRunning() {
+		return "", "", nil, fmt.Errorf("The container \"%s\" must be stopped to be refreshed", c.Name())
+	}
+
+	targetSnapshots, err := c.Snapshots()
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	targetSnapshotNames := []string{}
+	for _, snap := range targetSnapshots {
+		_, snapOnlyName, _ := containerGetParentAndSnapshotName(snap.Name())
+		targetSnapshotNames = append(targetSnapshotNames, snapOnlyName)
+	}
+
+	sourceSnapshotNames := []string{}
+	for _, snap := range snapshots {
+		sourceSnapshotNames = append(sourceSnapshotNames, snap.GetName())
+	}
+
+	base := migrationRefreshBase(sourceSnapshotNames, targetSnapshotNames)
+	if base == "" {
+		return "", "", nil, fmt.Errorf("The container \"%s\" doesn't share any snapshot with the source, it must be copied again", c.Name())
+	}
+
+	baseSnapshot, err := zfsContainerSnapshotFullName(c.Storage(), c.Name(), base)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	guid, err := shared.RunCommand("zfs", "get", "-H", "-p", "-o", "value", "guid", baseSnapshot)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("Failed to get the guid of the snapshot \"%s\": %s", base, guid)
+	}
+
+	for i, snap := range targetSnapshots {
+		if shared.StringInSlice(targetSnapshotNames[i], sourceSnapshotNames) {
+			continue
+		}
+
+		err := snap.Delete()
+		if err != nil {
+			return "", "", nil, err
+		}
+	}
+
+	remaining := []*Snapshot{}
+	for i, name := range sourceSnapshotNames {
+		if name == base {
+			remaining = snapshots[i+1:]
+		}
+	}
+
+	return base, strings.TrimSpace(guid), remaining, nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMigrationRefreshBase(t *testing.T) {
+	tests := []struct {
+		source   []string
+		target   []string
+		expected string
+	}{
+		{[]string{"snap0", "snap1", "snap2"}, []string{"snap0", "snap1"}, "snap1"},
+		{[]string{"snap0", "snap1", "snap2"}, []string{"snap0", "snap2"}, "snap2"},
+		{[]string{"snap0", "snap1"}, []string{"snap0", "snap1", "snap2"}, "snap1"},
+		{[]string{"snap0", "snap1"}, []string{"backup"}, ""},
+		{[]string{}, []string{"snap0"}, ""},
+	}
+
+	for _, test := range tests {
+		base := migrationRefreshBase(test.source, test.target)
+		if base != test.expected {
+			t.Errorf("Expected %q for %v and %v, got %q", test.expected, test.source, test.target, base)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrationPreCopyConverged(t *testing.T) {
+	tests := []struct {
+		first     int64
+		last      int64
+		goal      int64
+		converged bool
+	}{
+		{1000, 1000, 70, false},
+		{1000, 400, 70, false},
+		{1000, 300, 70, true},
+		{1000, 0, 70, true},
+		{1000, 1000, 0, true},
+		{1000, 1, 100, false},
+		{0, 0, 70, true},
+	}
+
+	for _, test := range tests {
+		converged := migrationPreCopyConverged(test.first, test.last, test.goal)
+		if converged != test.converged {
+			t.Errorf("Expected %v for %d bytes out of %d with a goal of %d%%, got %v", test.converged, test.last, test.first, test.goal, converged)
+		}
+	}
+}
+
+func TestMigrationPreDumpSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lxd_pre_dump_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]int{
+		"pages-1.img":   4096,
+		"pages-2.img":   8192,
+		"pagemap-1.img": 100,
+		"stats-dump":    10,
+		"inventory.img": 20,
+	}
+
+	for name, size := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), make([]byte, size), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	size, err := migrationPreDumpSize(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size != 12288 {
+		t.Fatalf("Expected 12288 bytes, got %d", size)
+	}
+}
+
+func TestMigrationFsFallback(t *testing.T) {
+	tests := []struct {
+		fsType      MigrationFSType
+		offered     []MigrationFSType
+		live        bool
+		sourceBlock string
+		block       string
+		fallback    string
+	}{
+		{MigrationFSType_ZFS, []MigrationFSType{MigrationFSType_ZFS, MigrationFSType_RSYNC}, true, "", "", ""},
+		{MigrationFSType_BTRFS, []MigrationFSType{MigrationFSType_ZFS, MigrationFSType_RSYNC}, false, "", "", "The source can't send btrfs streams"},
+		{MigrationFSType_RSYNC, []MigrationFSType{MigrationFSType_ZFS, MigrationFSType_RSYNC}, false, "", "", ""},
+		{MigrationFSType_BLOCK, []MigrationFSType{MigrationFSType_BLOCK, MigrationFSType_RSYNC}, false, "ext4", "ext4", ""},
+		{MigrationFSType_BLOCK, []MigrationFSType{MigrationFSType_BLOCK, MigrationFSType_RSYNC}, true, "ext4", "ext4", "Block devices can't be resynced after a checkpoint"},
+		{MigrationFSType_BLOCK, []MigrationFSType{MigrationFSType_BLOCK, MigrationFSType_RSYNC}, false, "xfs", "ext4", "The block devices of the source use xfs rather than ext4"},
+	}
+
+	for _, test := range tests {
+		fallback := migrationFsFallback(test.fsType, test.offered, test.live, test.sourceBlock, test.block)
+		if fallback != test.fallback {
+			t.Errorf("Expected %q for %s from %v, got %q", test.fallback, test.fsType, test.offered, fallback)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/lxc/lxd/shared/api"
+)
+
+// operationStages tracks the progress of an operation made of several
+// stages, e.g. the transfer of a container followed by its final sync and
+// restore when live migrating it. It's exposed in the "stages" metadata of
+// the operation, along with the name of the current stage in "stage".
+//
+// All methods are no-ops on a nil operationStages, what operationStagesSet
+// returns for operations run outside of an API operation.
+type operationStages struct {
+	op *operation
+
+	lock    sync.Mutex
+	stages  []api.OperationStage
+	current int
+
+	// Bytes moved by the storage streams of the operation when the current
+	// stage started.
+	storageBytes int64
+}
+
+// operationStagesSet sets the stages an operation is expected to go through,
+// more can be started on the way.
+func operationStagesSet(op *operation, names ...string) *operationStages {
+	if op == nil {
+		return nil
+	}
+
+	s := &operationStages{op: op, current: -1}
+	for _, name := range names {
+		s.stages = append(s.stages, operationStageNew(name))
+	}
+
+	op.lock.Lock()
+	op.stages = s
+	op.lock.Unlock()
+
+	s.render()
+	return s
+}
+
+// operationStagesGet returns the stages of an operation, nil if it has none.
+func operationStagesGet(op *operation) *operationStages {
+	if op == nil {
+		return nil
+	}
+
+	op.lock.Lock()
+	defer op.lock.Unlock()
+
+	return op.stages
+}
+
+func operationStageNew(name string) api.OperationStage {
+	return api.OperationStage{Name: name, Status: api.Pending.String(), Percent: -1, ETA: -1}
+}
+
+// start marks the current stage as done and starts the named one, total being
+// the number of bytes it's expected to process, 0 if unknown.
+func (s *operationStages) start(name string, total int64) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	s.stageDone()
+
+	s.current = -1
+	for i := range s.stages {
+		if s.stages[i].Name == name && s.stages[i].Status == api.Pending.String() {
+			s.current = i
+			break
+		}
+	}
+
+	if s.current < 0 {
+		s.stages = append(s.stages, operationStageNew(name))
+		s.current = len(s.stages) - 1
+	}
+
+	stage := &s.stages[s.current]
+	stage.Status = api.Running.String()
+	stage.Total = total
+	if total > 0 {
+		stage.Percent = 0
+	}
+
+	s.storageBytes = atomic.LoadInt64(&s.op.storageBytes)
+	s.lock.Unlock()
+
+	s.render()
+}
+
+// progress updates the progress of the current stage, total being 0 to keep
+// the one it was started with.
+func (s *operationStages) progress(processed int64, total int64, speed int64) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	if s.current < 0 {
+		s.lock.Unlock()
+		return
+	}
+
+	stage := &s.stages[s.current]
+	if total > 0 {
+		stage.Total = total
+	}
+
+	operationStageProgress(stage, processed, speed)
+	s.lock.Unlock()
+
+	s.render()
+}
+
+// storageProgress updates the progress of the current stage from the bytes
+// the storage streams of the operation moved since it started.
+func (s *operationStages) storageProgress(speed int64) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	processed := atomic.LoadInt64(&s.op.storageBytes) - s.storageBytes
+	s.lock.Unlock()
+
+	s.progress(processed, 0, speed)
+}
+
+// finish marks the current stage as done, or as failed if err is set.
+func (s *operationStages) finish(err error) {
+	if s == nil {
+		return
+	}
+
+	if err != nil {
+		s.fail()
+		return
+	}
+
+	s.lock.Lock()
+	s.stageDone()
+	s.lock.Unlock()
+
+	s.render()
+}
+
+// fail marks the current stage as failed, if there's one. Deferred, it covers
+// all the error paths of an operation calling finish once done.
+func (s *operationStages) fail() {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	if s.current < 0 {
+		s.lock.Unlock()
+		return
+	}
+
+	s.stages[s.current].Status = api.Failure.String()
+	s.stages[s.current].ETA = -1
+	s.current = -1
+	s.lock.Unlock()
+
+	s.render()
+}
+
+// stageDone marks the current stage as done, the lock being held.
+func (s *operationStages) stageDone() {
+	if s.current < 0 {
+		return
+	}
+
+	stage := &s.stages[s.current]
+	stage.Status = api.Success.String()
+	stage.Percent = 100
+	stage.ETA = 0
+	s.current = -1
+}
+
+// operationStageProgress computes the progress of a running stage. Totals
+// being estimates, the stage stays below 100% until it's done.
+func operationStageProgress(stage *api.OperationStage, processed int64, speed int64) {
+	stage.Processed = processed
+	stage.Speed = speed
+
+	if stage.Total <= 0 {
+		return
+	}
+
+	stage.Percent = processed * 100 / stage.Total
+	if stage.Percent > 99 {
+		stage.Percent = 99
+	}
+
+	stage.ETA = -1
+	if speed > 0 && stage.Total > processed {
+		stage.ETA = (stage.Total - processed) / speed
+	}
+}
+
+func (s *operationStages) render() {
+	s.lock.Lock()
+	stages := make([]api.OperationStage, len(s.stages))
+	copy(stages, s.stages)
+
+	current := ""
+	if s.current >= 0 {
+		current = s.stages[s.current].Name
+	}
+	s.lock.Unlock()
+
+	s.op.lock.Lock()
+	meta := map[string]interface{}{}
+	for k, v := range s.op.metadata {
+		meta[k] = v
+	}
+	s.op.lock.Unlock()
+
+	meta["stages"] = stages
+	meta["stage"] = current
+	s.op.UpdateMetadata(meta)
+}
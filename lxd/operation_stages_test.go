@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/lxc/lxd/shared/api"
+)
+
+func TestOperationStageProgress(t *testing.T) {
+	stage := operationStageNew("transfer")
+
+	operationStageProgress(&stage, 1024, 512)
+	if stage.Processed != 1024 || stage.Percent != -1 || stage.ETA != -1 {
+		t.Fatalf("Unexpected progress without a total: %+v", stage)
+	}
+
+	stage.Total = 4096
+	operationStageProgress(&stage, 1024, 512)
+	if stage.Percent != 25 {
+		t.Errorf("Expected 25%%, got %d%%", stage.Percent)
+	}
+
+	if stage.ETA != 6 {
+		t.Errorf("Expected 6s left, got %ds", stage.ETA)
+	}
+
+	// Totals are estimates, the stage isn't done until it says so.
+	operationStageProgress(&stage, 8192, 512)
+	if stage.Percent != 99 || stage.ETA != -1 {
+		t.Errorf("Expected 99%% and an unknown ETA past the total, got %d%% and %ds", stage.Percent, stage.ETA)
+	}
+}
+
+func TestOperationStagesNil(t *testing.T) {
+	stages := operationStagesSet(nil, "transfer")
+	if stages != nil {
+		t.Fatal("Expected no stages without an operation")
+	}
+
+	// None of those may panic.
+	stages.start("transfer", 1024)
+	stages.progress(512, 0, 128)
+	stages.storageProgress(128)
+	stages.finish(nil)
+	stages.fail()
+
+	if operationStageNew("transfer").Status != api.Pending.String() {
+		t.Error("Expected new stages to be pending")
+	}
+}
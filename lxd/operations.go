@@ -38,6 +38,9 @@ func (t operationClass) String() string {
 }
 
 type operation struct {
+	// Bytes moved by storage streams, kept first for 64bit atomic alignment
+	storageBytes int64
+
 	id        string
 	class     operationClass
 	createdAt time.Time
@@ -50,6 +53,9 @@ type operation struct {
 	readonly  bool
 	canceler  *cancel.Canceler
 
+	// Progress of the stages of operations made of several
+	stages *operationStages
+
 	// Those functions are called at various points in the operation lifecycle
 	onRun     func(*operation) error
 	onCancel  func(*operation) error
@@ -114,6 +120,8 @@ func (op *operation) Run() (chan error, error) {
 		go func(op *operation, chanRun chan error) {
 			err := op.onRun(op)
 			if err != nil {
+				op.logFailure(err)
+
 				op.lock.Lock()
 				op.status = api.Failure
 				op.err = SmartError(err).String()
@@ -371,6 +379,24 @@ func (op *operation) UpdateMetadata(opMetadata interface{}) error {
 	return nil
 }
 
+// operationMetadataSet sets one of the metadata of an operation, keeping the
+// others.
+func operationMetadataSet(op *operation, key string, value interface{}) {
+	if op == nil {
+		return
+	}
+
+	op.lock.Lock()
+	meta := map[string]interface{}{}
+	for k, v := range op.metadata {
+		meta[k] = v
+	}
+	op.lock.Unlock()
+
+	meta[key] = value
+	op.UpdateMetadata(meta)
+}
+
 func operationCreate(opClass operationClass, opResources map[string][]string, opMetadata interface{},
 	onRun func(*operation) error,
 	onCancel func(*operation) error,
@@ -426,6 +452,25 @@ func operationCreate(opClass operationClass, opResources map[string][]string, op
 	return &op, nil
 }
 
+// operationsUsing returns whether an operation which isn't done yet has the
+// named object among its resources of the given kind (e.g. "containers").
+func operationsUsing(kind string, name string) bool {
+	operationsLock.Lock()
+	defer operationsLock.Unlock()
+
+	for _, op := range operations {
+		op.lock.Lock()
+		done := op.readonly
+		op.lock.Unlock()
+
+		if !done && shared.StringInSlice(name, op.resources[kind]) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func operationGet(id string) (*operation, error) {
 	operationsLock.Lock()
 	op, ok := operations[id]
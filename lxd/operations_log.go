@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// Amount of command output kept for a failed operation. Only the end of the
+// output is kept as that's where the errors usually are.
+const operationLogSize = 64 * 1024
+
+// Failed operations get their command output written to a file in this
+// directory of the LXD log path, where it's kept for operationLogRetention.
+const operationLogDir = "operations.d"
+const operationLogRetention = 7 * 24 * time.Hour
+
+// commandError is returned when an external command such as zfs or rsync
+// fails and keeps the command's output so that it can be attached to the
+// operation.
+type commandError struct {
+	command string
+	output  string
+	err     error
+}
+
+func (e *commandError) Error() string {
+	output := strings.TrimSpace(e.output)
+	if output == "" {
+		return fmt.Sprintf("Failed to run: %s: %s", e.command, e.err)
+	}
+
+	lines := strings.Split(output, "\n")
+	return fmt.Sprintf("Failed to run: %s: %s: %s", e.command, e.err, lines[len(lines)-1])
+}
+
+// commandErrorNew wraps the error of a command together with its output,
+// returning nil if the command succeeded.
+func commandErrorNew(cmd *exec.Cmd, output []byte, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &commandError{command: strings.Join(cmd.Args, " "), output: string(output), err: err}
+}
+
+// operationLogTail returns the end of the output, at most operationLogSize
+// bytes of it, and whether it had to be truncated.
+func operationLogTail(output string) (string, bool) {
+	if len(output) <= operationLogSize {
+		return output, false
+	}
+
+	return output[len(output)-operationLogSize:], true
+}
+
+// logFailure records the output of the command which made the operation
+// fail in its metadata and in a log file which outlives the operation. The
+// command error may have been wrapped with errorWrapf on its way up.
+func (op *operation) logFailure(err error) {
+	var cmdErr *commandError
+	for _, cause := range errorCauses(err) {
+		e, ok := cause.(*commandError)
+		if ok {
+			cmdErr = e
+			break
+		}
+	}
+
+	if cmdErr == nil {
+		return
+	}
+
+	output, truncated := operationLogTail(cmdErr.output)
+	path := shared.LogPath(operationLogDir, fmt.Sprintf("%s.log", op.id))
+
+	op.lock.Lock()
+	if op.metadata == nil {
+		op.metadata = map[string]interface{}{}
+	}
+
+	op.metadata["command_error"] = map[string]interface{}{
+		"command":   cmdErr.command,
+		"output":    output,
+		"truncated": truncated,
+		"log":       path,
+	}
+	op.lock.Unlock()
+
+	content := fmt.Sprintf("operation: %s\ncreated: %s\ncommand: %s\nerror: %s\n\n%s",
+		op.id, op.createdAt.Format(time.RFC3339), cmdErr.command, cmdErr.err, output)
+
+	err = os.MkdirAll(shared.LogPath(operationLogDir), 0700)
+	if err == nil {
+		err = ioutil.WriteFile(path, []byte(content), 0600)
+	}
+
+	if err != nil {
+		logger.Warn("Failed to write operation log", log.Ctx{"operation": op.id, "err": err})
+	}
+}
+
+// operationLogsExpire removes the operation logs older than
+// operationLogRetention.
+func operationLogsExpire() error {
+	logs, err := ioutil.ReadDir(shared.LogPath(operationLogDir))
+	if err != nil {
+		return err
+	}
+
+	for _, logfile := range logs {
+		if time.Since(logfile.ModTime()) < operationLogRetention {
+			continue
+		}
+
+		err := os.Remove(shared.LogPath(operationLogDir, logfile.Name()))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -85,6 +85,9 @@ func patchesApplyAll(d *Daemon) error {
 		}
 	}
 
+	// Patches may have modified ZFS datasets directly.
+	zfsDatasetCacheInvalidate("")
+
 	return nil
 }
 
@@ -63,52 +63,16 @@ func doProfileUpdate(d *Daemon, name string, id int64, profile *api.Profile, req
 	}
 
 	// Update the database
-	tx, err := dbBegin(d.db)
+	err = doProfileUpdateDb(d, id, profile, req)
 	if err != nil {
 		return SmartError(err)
 	}
 
-	if profile.Description != req.Description {
-		err = dbProfileDescriptionUpdate(tx, id, req.Description)
-		if err != nil {
-			tx.Rollback()
-			return SmartError(err)
-		}
-	}
-
 	// Optimize for description-only changes
 	if reflect.DeepEqual(profile.Config, req.Config) && reflect.DeepEqual(profile.Devices, req.Devices) {
-		err = txCommit(tx)
-		if err != nil {
-			return SmartError(err)
-		}
-
 		return EmptySyncResponse
 	}
 
-	err = dbProfileConfigClear(tx, id)
-	if err != nil {
-		tx.Rollback()
-		return SmartError(err)
-	}
-
-	err = dbProfileConfigAdd(tx, id, req.Config)
-	if err != nil {
-		tx.Rollback()
-		return SmartError(err)
-	}
-
-	err = dbDevicesAdd(tx, "profile", id, req.Devices)
-	if err != nil {
-		tx.Rollback()
-		return SmartError(err)
-	}
-
-	err = txCommit(tx)
-	if err != nil {
-		return SmartError(err)
-	}
-
 	// Update all the containers using the profile. Must be done after txCommit due to DB lock.
 	failures := map[string]error{}
 	for _, c := range containers {
@@ -117,7 +81,8 @@ func doProfileUpdate(d *Daemon, name string, id int64, profile *api.Profile, req
 			Ephemeral:    c.IsEphemeral(),
 			Config:       c.LocalConfig(),
 			Devices:      c.LocalDevices(),
-			Profiles:     c.Profiles()}, true)
+			Profiles:     c.Profiles(),
+			UpdateSource: containerUpdateSourceProfile}, true)
 
 		if err != nil {
 			failures[c.Name()] = err
@@ -134,3 +99,45 @@ func doProfileUpdate(d *Daemon, name string, id int64, profile *api.Profile, req
 
 	return EmptySyncResponse
 }
+
+// doProfileUpdateDb writes the new description, config and devices of a
+// profile to the database in a single transaction.
+func doProfileUpdateDb(d *Daemon, id int64, profile *api.Profile, req api.ProfilePut) error {
+	tx, err := dbBegin(d.db)
+	if err != nil {
+		return err
+	}
+
+	if profile.Description != req.Description {
+		err = dbProfileDescriptionUpdate(tx, id, req.Description)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// Optimize for description-only changes
+	if reflect.DeepEqual(profile.Config, req.Config) && reflect.DeepEqual(profile.Devices, req.Devices) {
+		return txCommit(tx)
+	}
+
+	err = dbProfileConfigClear(tx, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = dbProfileConfigAdd(tx, id, req.Config)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = dbDevicesAdd(tx, "profile", id, req.Devices)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return txCommit(tx)
+}
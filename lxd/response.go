@@ -329,6 +329,11 @@ func SmartError(err error) Response {
 	case sqlite3.ErrConstraintUnique:
 		return Conflict
 	default:
+		resp := storageErrorResponse(err)
+		if resp != nil {
+			return resp
+		}
+
 		return InternalError(err)
 	}
 }
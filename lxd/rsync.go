@@ -7,16 +7,135 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"strings"
 
 	"github.com/gorilla/websocket"
 	"github.com/pborman/uuid"
 
 	"github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
 )
 
-// rsyncCopy copies a directory using rsync (with the --devices option).
-func rsyncLocalCopy(source string, dest string, bwlimit string) (string, error) {
+// rsyncFeatures are the optional rsync features of migrations, mapped to the
+// option of the sender and the flag of the receiving server. Both ends must
+// agree on them as they change what's sent.
+var rsyncFeatures = map[string][2]string{
+	"checksum":  {"--checksum", "c"},
+	"compress":  {"--compress", "z"},
+	"hardlinks": {"--hard-links", "H"},
+}
+
+// rsyncPoolFeatures returns the features the migrations of a storage pool
+// offer. Hard links are always preserved, as they are by local copies.
+func rsyncPoolFeatures(config map[string]string) []string {
+	features := []string{"hardlinks"}
+
+	if shared.IsTrue(config["rsync.compression"]) {
+		features = append(features, "compress")
+	}
+
+	if shared.IsTrue(config["rsync.checksum"]) {
+		features = append(features, "checksum")
+	}
+
+	return features
+}
+
+// rsyncFeaturesSupported returns the features known to this LXD.
+func rsyncFeaturesSupported(features []string) []string {
+	supported := []string{}
+	for _, feature := range features {
+		_, ok := rsyncFeatures[feature]
+		if ok && !shared.StringInSlice(feature, supported) {
+			supported = append(supported, feature)
+		}
+	}
+
+	return supported
+}
+
+// rsyncPoolArgs returns the extra options of rsync set in "rsync.args".
+func rsyncPoolArgs(config map[string]string) []string {
+	return strings.Fields(config["rsync.args"])
+}
+
+// rsyncPoolLocalArgs returns the extra options of the local copies of a
+// storage pool, which always compare checksums unless "rsync.checksum" is
+// explicitly false.
+func rsyncPoolLocalArgs(config map[string]string) []string {
+	args := []string{}
+
+	checksum, ok := config["rsync.checksum"]
+	if ok && checksum != "" && !shared.IsTrue(checksum) {
+		args = append(args, "--no-checksum")
+	}
+
+	return append(args, rsyncPoolArgs(config)...)
+}
+
+// rsyncServerFlags returns the flags of the receiving rsync server matching
+// the options of the sender for the given features. The receiving end always
+// writes sparse files as such, the sender always passes --sparse.
+func rsyncServerFlags(features []string) string {
+	flags := "-vlogDtprS"
+	for _, feature := range rsyncFeaturesSupported(features) {
+		flags += rsyncFeatures[feature][1]
+	}
+
+	return flags + "e.iLsfx"
+}
+
+// rsyncVersion returns the version of rsync, 0.0.0 if it can't be found out.
+func rsyncVersion() (int, int, int) {
+	out, err := shared.RunCommand("rsync", "--version")
+	if err != nil {
+		return 0, 0, 0
+	}
+
+	// "rsync  version 3.1.2  protocol version 31", with a "v" before the
+	// version from 3.2.0 on.
+	fields := strings.Fields(strings.Split(out, "\n")[0])
+	if len(fields) < 3 {
+		return 0, 0, 0
+	}
+
+	major := 0
+	minor := 0
+	micro := 0
+	fmt.Sscanf(strings.TrimPrefix(fields[2], "v"), "%d.%d.%d", &major, &minor, &micro)
+
+	return major, minor, micro
+}
+
+// rsyncSparseArgs returns the extra options of a local copy which keep
+// sparse files sparse with the given version of rsync, along with the ones
+// left out. Before rsync 3.1.3, --sparse couldn't be combined with --inplace
+// and --preallocate allocated the holes.
+func rsyncSparseArgs(major int, minor int, micro int, extraArgs []string) ([]string, []string) {
+	if major > 3 || (major == 3 && (minor > 1 || (minor == 1 && micro >= 3))) {
+		return extraArgs, []string{}
+	}
+
+	args := []string{}
+	dropped := []string{}
+	for _, arg := range extraArgs {
+		if arg == "--inplace" || arg == "--preallocate" {
+			dropped = append(dropped, arg)
+			continue
+		}
+
+		args = append(args, arg)
+	}
+
+	return args, dropped
+}
+
+// rsyncCopy copies a directory using rsync (with the --devices option). The
+// extra arguments come last so they can override the defaults, except for
+// those which would make sparse files lose their holes.
+func rsyncLocalCopy(source string, dest string, bwlimit string, extraArgs ...string) (string, error) {
 	err := os.MkdirAll(dest, 0755)
 	if err != nil {
 		return "", err
@@ -31,7 +150,7 @@ func rsyncLocalCopy(source string, dest string, bwlimit string) (string, error)
 		bwlimit = "0"
 	}
 
-	return shared.RunCommand("rsync",
+	args := []string{
 		"-a",
 		"-HAX",
 		"--sparse",
@@ -41,11 +160,25 @@ func rsyncLocalCopy(source string, dest string, bwlimit string) (string, error)
 		"--numeric-ids",
 		"--bwlimit", bwlimit,
 		rsyncVerbosity,
-		shared.AddSlash(source),
-		dest)
+	}
+
+	if len(extraArgs) > 0 {
+		major, minor, micro := rsyncVersion()
+		sparseArgs, dropped := rsyncSparseArgs(major, minor, micro, extraArgs)
+		if len(dropped) > 0 {
+			logger.Warn("Leaving out rsync options which this version of rsync can't combine with --sparse", log.Ctx{"options": strings.Join(dropped, " ")})
+		}
+
+		args = append(args, sparseArgs...)
+	}
+
+	return shared.RunCommand("rsync", append(args, shared.AddSlash(source), dest)...)
 }
 
-func rsyncSendSetup(name string, path string, bwlimit string) (*exec.Cmd, net.Conn, io.ReadCloser, error) {
+// rsyncSendSetup starts the sending rsync. The features must be the ones
+// the receiving end was set up for, see rsyncServerFlags, while the extra
+// arguments may only affect the sender.
+func rsyncSendSetup(name string, path string, bwlimit string, features []string, extraArgs ...string) (*exec.Cmd, net.Conn, io.ReadCloser, error) {
 	/*
 	 * The way rsync works, it invokes a subprocess that does the actual
 	 * talking (given to it by a -E argument). Since there isn't an easy
@@ -87,12 +220,20 @@ func rsyncSendSetup(name string, path string, bwlimit string) (*exec.Cmd, net.Co
 		bwlimit = "0"
 	}
 
-	cmd := exec.Command("rsync",
+	args := []string{
 		"-arvP",
 		"--devices",
 		"--numeric-ids",
 		"--partial",
 		"--sparse",
+	}
+
+	for _, feature := range rsyncFeaturesSupported(features) {
+		args = append(args, rsyncFeatures[feature][0])
+	}
+
+	args = append(args, extraArgs...)
+	args = append(args,
 		path,
 		"localhost:/tmp/foo",
 		"-e",
@@ -100,6 +241,8 @@ func rsyncSendSetup(name string, path string, bwlimit string) (*exec.Cmd, net.Co
 		"--bwlimit",
 		bwlimit)
 
+	cmd := exec.Command("rsync", args...)
+
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
 		return nil, nil, nil, err
@@ -121,9 +264,10 @@ func rsyncSendSetup(name string, path string, bwlimit string) (*exec.Cmd, net.Co
 }
 
 // RsyncSend sets up the sending half of an rsync, to recursively send the
-// directory pointed to by path over the websocket.
-func RsyncSend(name string, path string, conn *websocket.Conn, readWrapper func(io.ReadCloser) io.ReadCloser, bwlimit string) error {
-	cmd, dataSocket, stderr, err := rsyncSendSetup(name, path, bwlimit)
+// directory pointed to by path over the websocket, using the features
+// negotiated with the receiving end.
+func RsyncSend(name string, path string, conn *websocket.Conn, readWrapper func(io.ReadCloser) io.ReadCloser, bwlimit string, features []string, extraArgs ...string) error {
+	cmd, dataSocket, stderr, err := rsyncSendSetup(name, path, bwlimit, features, extraArgs...)
 	if err != nil {
 		return err
 	}
@@ -154,16 +298,16 @@ func RsyncSend(name string, path string, conn *websocket.Conn, readWrapper func(
 	<-readDone
 	<-writeDone
 
-	return err
+	return commandErrorNew(cmd, output, err)
 }
 
 // RsyncRecv sets up the receiving half of the websocket to rsync (the other
-// half set up by RsyncSend), putting the contents in the directory specified
-// by path.
-func RsyncRecv(path string, conn *websocket.Conn, writeWrapper func(io.WriteCloser) io.WriteCloser) error {
+// half set up by RsyncSend with the same features), putting the contents in
+// the directory specified by path.
+func RsyncRecv(path string, conn *websocket.Conn, writeWrapper func(io.WriteCloser) io.WriteCloser, features []string) error {
 	cmd := exec.Command("rsync",
 		"--server",
-		"-vlogDtpre.iLsfx",
+		rsyncServerFlags(features),
 		"--numeric-ids",
 		"--devices",
 		"--partial",
@@ -211,5 +355,5 @@ func RsyncRecv(path string, conn *websocket.Conn, writeWrapper func(io.WriteClos
 	<-readDone
 	<-writeDone
 
-	return err
+	return commandErrorNew(cmd, output, err)
 }
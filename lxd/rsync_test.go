@@ -0,0 +1,153 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestRsyncServerFlags(t *testing.T) {
+	tests := []struct {
+		features []string
+		flags    string
+	}{
+		{nil, "-vlogDtprSe.iLsfx"},
+		{[]string{"hardlinks"}, "-vlogDtprSHe.iLsfx"},
+		{[]string{"hardlinks", "compress", "checksum"}, "-vlogDtprSHzce.iLsfx"},
+		{[]string{"unknown", "compress", "compress"}, "-vlogDtprSze.iLsfx"},
+	}
+
+	for _, test := range tests {
+		flags := rsyncServerFlags(test.features)
+		if flags != test.flags {
+			t.Errorf("Features %v: got %s, expected %s", test.features, flags, test.flags)
+		}
+	}
+}
+
+func TestRsyncPoolFeatures(t *testing.T) {
+	features := rsyncPoolFeatures(map[string]string{})
+	if !reflect.DeepEqual(features, []string{"hardlinks"}) {
+		t.Errorf("Unexpected default features: %v", features)
+	}
+
+	features = rsyncPoolFeatures(map[string]string{"rsync.compression": "true", "rsync.checksum": "true"})
+	if !reflect.DeepEqual(features, []string{"hardlinks", "compress", "checksum"}) {
+		t.Errorf("Unexpected features: %v", features)
+	}
+}
+
+func TestRsyncPoolLocalArgs(t *testing.T) {
+	tests := []struct {
+		config map[string]string
+		args   []string
+	}{
+		{map[string]string{}, []string{}},
+		{map[string]string{"rsync.checksum": "true"}, []string{}},
+		{map[string]string{"rsync.checksum": "false"}, []string{"--no-checksum"}},
+		{map[string]string{"rsync.checksum": "false", "rsync.args": " --exclude=/tmp  -W "}, []string{"--no-checksum", "--exclude=/tmp", "-W"}},
+	}
+
+	for _, test := range tests {
+		args := rsyncPoolLocalArgs(test.config)
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("Config %v: got %v, expected %v", test.config, args, test.args)
+		}
+	}
+}
+
+func TestRsyncSparseArgs(t *testing.T) {
+	versions := []struct {
+		major, minor, micro int
+		combines            bool
+	}{
+		{3, 0, 9, false},
+		{3, 1, 2, false},
+		{3, 1, 3, true},
+		{3, 2, 3, true},
+	}
+
+	extraArgs := [][]string{
+		{},
+		{"--exclude=/tmp"},
+		{"--inplace"},
+		{"--preallocate", "--exclude=/tmp"},
+		{"--inplace", "--preallocate"},
+	}
+
+	for _, version := range versions {
+		for _, extra := range extraArgs {
+			args, dropped := rsyncSparseArgs(version.major, version.minor, version.micro, extra)
+			if len(args)+len(dropped) != len(extra) {
+				t.Errorf("rsync %d.%d.%d with %v: got %v, dropped %v", version.major, version.minor, version.micro, extra, args, dropped)
+				continue
+			}
+
+			for _, arg := range args {
+				if !version.combines && (arg == "--inplace" || arg == "--preallocate") {
+					t.Errorf("rsync %d.%d.%d with %v: %s kept", version.major, version.minor, version.micro, extra, arg)
+				}
+			}
+
+			if version.combines && len(dropped) > 0 {
+				t.Errorf("rsync %d.%d.%d with %v: %v dropped", version.major, version.minor, version.micro, extra, dropped)
+			}
+		}
+	}
+}
+
+func TestRsyncLocalCopySparse(t *testing.T) {
+	_, err := exec.LookPath("rsync")
+	if err != nil {
+		t.Skip("rsync isn't installed")
+	}
+
+	for _, extra := range [][]string{{}, {"--inplace"}, {"--preallocate"}, {"--no-checksum"}} {
+		source, err := ioutil.TempDir("", "lxd_rsync_source_")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(source)
+
+		dest, err := ioutil.TempDir("", "lxd_rsync_dest_")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dest)
+
+		// A 64MiB file with a single byte of data at its end.
+		f, err := os.Create(filepath.Join(source, "sparse"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = f.WriteAt([]byte{1}, 64*1024*1024-1)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		output, err := rsyncLocalCopy(source, dest, "", extra...)
+		if err != nil {
+			t.Fatalf("rsync with %v failed: %s", extra, output)
+		}
+
+		st := syscall.Stat_t{}
+		err = syscall.Stat(filepath.Join(dest, "sparse"), &st)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if st.Size != 64*1024*1024 {
+			t.Errorf("rsync with %v: the copy is %d bytes", extra, st.Size)
+		}
+
+		if st.Blocks*512 > 1024*1024 {
+			t.Errorf("rsync with %v: the copy lost its holes, %d bytes are allocated", extra, st.Blocks*512)
+		}
+	}
+}
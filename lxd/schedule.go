@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// scheduler runs a task on the containers whose schedule, as accepted by
+// shared.ParseSchedule in one of their configuration keys, came due since the
+// last check.
+type scheduler struct {
+	// name is what the task is, for the logs.
+	name string
+
+	// keys hold the schedules. When several come due at once, the task
+	// runs for the last one.
+	keys []string
+
+	// timezone is the key holding the timezone of the schedules, the local
+	// one being used when it's unset.
+	timezone string
+
+	// run runs the task on a container for the key which came due.
+	run func(d *Daemon, c container, key string)
+
+	// last is when the schedules were last checked. Only the times which
+	// passed since then are acted upon, so those missed while LXD was down
+	// are skipped.
+	last time.Time
+
+	// running holds the containers the task is running on, so that a slow
+	// run doesn't get a second one started alongside it.
+	running map[string]bool
+	lock    sync.Mutex
+}
+
+func newScheduler(name string, timezone string, run func(d *Daemon, c container, key string), keys ...string) *scheduler {
+	return &scheduler{
+		name:     name,
+		keys:     keys,
+		timezone: timezone,
+		run:      run,
+		running:  map[string]bool{},
+	}
+}
+
+// start marks the task as running on a container, returning false if it
+// already is.
+func (s *scheduler) start(name string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.running[name] {
+		return false
+	}
+
+	s.running[name] = true
+	return true
+}
+
+// done marks the task as no longer running on a container.
+func (s *scheduler) done(name string) {
+	s.lock.Lock()
+	delete(s.running, name)
+	s.lock.Unlock()
+}
+
+// isRunning tells whether the task is running on a container.
+func (s *scheduler) isRunning(name string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.running[name]
+}
+
+// due returns the last of the keys of config whose schedule came due
+// between last and now, an empty string if none did. The keys holding an
+// invalid schedule are logged and ignored.
+func (s *scheduler) due(containerName string, config map[string]string, last time.Time, now time.Time) (string, error) {
+	loc := time.Local
+	if config[s.timezone] != "" {
+		var err error
+		loc, err = time.LoadLocation(config[s.timezone])
+		if err != nil {
+			return "", err
+		}
+	}
+
+	result := ""
+	for _, key := range s.keys {
+		if config[key] == "" {
+			continue
+		}
+
+		due, err := shared.ScheduleDue(config[key], loc, last, now)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Invalid %s schedule", s.name), log.Ctx{"container": containerName, "key": key, "err": err})
+			continue
+		}
+
+		if due {
+			result = key
+		}
+	}
+
+	return result, nil
+}
+
+// check runs the task on the containers whose schedule came due since the
+// last check, each in its own goroutine so that they don't hold each other
+// up.
+func (s *scheduler) check(d *Daemon) {
+	now := time.Now()
+	last := s.last
+	s.last = now
+
+	if last.IsZero() {
+		return
+	}
+
+	names, err := dbContainersList(d.db, cTypeRegular)
+	if err != nil {
+		logger.Error("Unable to retrieve the list of containers", log.Ctx{"err": err})
+		return
+	}
+
+	for _, name := range names {
+		c, err := containerLoadByName(d, name)
+		if err != nil {
+			logger.Error("Failed to load container", log.Ctx{"container": name, "err": err})
+			continue
+		}
+
+		key, err := s.due(name, c.ExpandedConfig(), last, now)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Invalid %s schedule timezone", s.name), log.Ctx{"container": name, "err": err})
+			continue
+		}
+
+		if key == "" {
+			continue
+		}
+
+		if !s.start(name) {
+			logger.Warn(fmt.Sprintf("Skipping a scheduled %s, the previous one is still running", s.name), log.Ctx{"container": name})
+			continue
+		}
+
+		go func(c container, key string) {
+			defer s.done(c.Name())
+			s.run(d, c, key)
+		}(c, key)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedulerDue(t *testing.T) {
+	s := newScheduler("test", "test.timezone", nil, "test.start", "test.stop")
+
+	last := time.Date(2026, 10, 16, 19, 59, 0, 0, time.UTC)
+	now := last.Add(time.Minute)
+
+	tests := []struct {
+		config map[string]string
+		key    string
+	}{
+		{map[string]string{}, ""},
+		{map[string]string{"test.start": "20:00", "test.timezone": "UTC"}, "test.start"},
+		{map[string]string{"test.start": "21:00", "test.timezone": "UTC"}, ""},
+		{map[string]string{"test.start": "20:00", "test.stop": "20:00", "test.timezone": "UTC"}, "test.stop"},
+		{map[string]string{"test.start": "invalid", "test.stop": "20:00", "test.timezone": "UTC"}, "test.stop"},
+		{map[string]string{"test.start": "22:00", "test.timezone": "Europe/Paris"}, "test.start"},
+	}
+
+	for _, test := range tests {
+		key, err := s.due("c1", test.config, last, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key != test.key {
+			t.Errorf("Expected %q to come due for %v, got %q", test.key, test.config, key)
+		}
+	}
+
+	_, err := s.due("c1", map[string]string{"test.start": "20:00", "test.timezone": "Nowhere/Invalid"}, last, now)
+	if err == nil {
+		t.Error("Expected an invalid timezone to fail")
+	}
+}
+
+func TestSchedulerRunning(t *testing.T) {
+	s := newScheduler("test", "test.timezone", nil, "test.schedule")
+
+	if !s.start("c1") || s.start("c1") || !s.isRunning("c1") {
+		t.Fatal("Expected the task to run once at a time")
+	}
+
+	if !s.start("c2") {
+		t.Error("Expected the task to run on another container meanwhile")
+	}
+
+	s.done("c1")
+	if s.isRunning("c1") || !s.start("c1") {
+		t.Error("Expected the task to run again once done")
+	}
+}
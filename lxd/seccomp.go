@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/osarch"
@@ -120,6 +121,11 @@ func getSeccompProfileContent(c container) (string, error) {
 		policy += DEFAULT_SECCOMP_POLICY
 	}
 
+	blacklist := config["security.syscalls.blacklist"]
+	if blacklist != "" {
+		policy += "[all]\n" + strings.TrimRight(blacklist, "\n") + "\n"
+	}
+
 	compat := config["security.syscalls.blacklist_compat"]
 	if shared.IsTrue(compat) {
 		arch, err := osarch.ArchitectureName(c.Architecture())
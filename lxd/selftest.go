@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/logger"
+)
+
+var selfTestLock sync.Mutex
+var selfTestReport *api.ServerSelfTest
+
+func selfTestFlag(category string, name string, available bool, message string) api.ServerCheck {
+	check := api.ServerCheck{
+		Name:      name,
+		Category:  category,
+		Available: available,
+	}
+
+	if !available {
+		check.Message = message
+	}
+
+	return check
+}
+
+func selfTestTool(name string, args []string, parse func(output string) string) api.ServerCheck {
+	check := api.ServerCheck{
+		Name:     name,
+		Category: "tools",
+	}
+
+	_, err := exec.LookPath(name)
+	if err != nil {
+		check.Message = fmt.Sprintf("The '%s' tool isn't available", name)
+		return check
+	}
+
+	output, err := shared.RunCommand(name, args...)
+	if err != nil {
+		check.Message = fmt.Sprintf("The '%s' tool isn't working properly", name)
+		return check
+	}
+
+	check.Available = true
+	check.Version = parse(output)
+	return check
+}
+
+func selfTestStorage(driver string) api.ServerCheck {
+	check := api.ServerCheck{
+		Name:     driver,
+		Category: "storage",
+	}
+
+	sCore, err := storageCoreInit(driver)
+	if err != nil {
+		check.Message = err.Error()
+		return check
+	}
+
+	check.Available = true
+	check.Version = sCore.GetStorageTypeVersion()
+	return check
+}
+
+func selfTestKernelVersion() (int, int) {
+	content, err := ioutil.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return 0, 0
+	}
+
+	major := 0
+	minor := 0
+	fmt.Sscanf(string(content), "%d.%d", &major, &minor)
+	return major, minor
+}
+
+func selfTestFileContains(path string, needle string) bool {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	return strings.Contains(string(content), needle)
+}
+
+// selfTestRun runs all the environment checks and stores the resulting
+// capability report.
+func selfTestRun() *api.ServerSelfTest {
+	checks := []api.ServerCheck{}
+
+	// Kernel features
+	major, minor := selfTestKernelVersion()
+	checks = append(checks,
+		selfTestFlag("kernel", "user_namespaces", shared.PathExists("/proc/self/ns/user"), "The kernel doesn't support user namespaces"),
+		selfTestFlag("kernel", "seccomp", selfTestFileContains("/proc/self/status", "Seccomp:"), "The kernel doesn't support seccomp"),
+		selfTestFlag("kernel", "apparmor", aaAvailable, "AppArmor support is disabled or unavailable"),
+		selfTestFlag("kernel", "apparmor_stacking", aaStacking, "AppArmor stacking isn't supported"),
+		selfTestFlag("kernel", "shiftfs", selfTestFileContains("/proc/filesystems", "shiftfs"), "The shiftfs filesystem isn't available"),
+		selfTestFlag("kernel", "idmapped_mounts", major > 5 || (major == 5 && minor >= 12), "Idmapped mounts require a 5.12 or newer kernel"))
+
+	// CGroup controllers
+	checks = append(checks,
+		selfTestFlag("cgroup", "blkio", cgBlkioController, "Couldn't find the CGroup blkio controller"),
+		selfTestFlag("cgroup", "cpu", cgCpuController, "Couldn't find the CGroup CPU controller"),
+		selfTestFlag("cgroup", "cpuacct", cgCpuacctController, "Couldn't find the CGroup CPUacct controller"),
+		selfTestFlag("cgroup", "cpuset", cgCpusetController, "Couldn't find the CGroup CPUset controller"),
+		selfTestFlag("cgroup", "devices", cgDevicesController, "Couldn't find the CGroup devices controller"),
+		selfTestFlag("cgroup", "memory", cgMemoryController, "Couldn't find the CGroup memory controller"),
+		selfTestFlag("cgroup", "net_prio", cgNetPrioController, "Couldn't find the CGroup network class controller"),
+		selfTestFlag("cgroup", "pids", cgPidsController, "Couldn't find the CGroup pids controller"),
+		selfTestFlag("cgroup", "memory_swap", cgSwapAccounting, "CGroup memory swap accounting is disabled"))
+
+	// Storage drivers
+	for _, driver := range []string{"btrfs", "lvm", "zfs"} {
+		checks = append(checks, selfTestStorage(driver))
+	}
+
+	// Tools
+	checks = append(checks,
+		selfTestTool("criu", []string{"--version"}, func(output string) string {
+			for _, line := range strings.Split(output, "\n") {
+				if strings.HasPrefix(line, "Version:") {
+					return strings.TrimSpace(strings.TrimPrefix(line, "Version:"))
+				}
+			}
+
+			return ""
+		}),
+		selfTestTool("dnsmasq", []string{"--version"}, func(output string) string {
+			fields := strings.Fields(output)
+			if len(fields) < 3 {
+				return ""
+			}
+
+			return fields[2]
+		}))
+
+	report := &api.ServerSelfTest{
+		CheckedAt: time.Now(),
+		Checks:    checks,
+	}
+
+	for _, check := range checks {
+		if !check.Available {
+			logger.Debugf("Self-test: %s/%s unavailable: %s", check.Category, check.Name, check.Message)
+		}
+	}
+
+	selfTestLock.Lock()
+	selfTestReport = report
+	selfTestLock.Unlock()
+
+	return report
+}
+
+// selfTestLoad returns the last capability report, running the checks if
+// they haven't been run yet.
+func selfTestLoad() *api.ServerSelfTest {
+	selfTestLock.Lock()
+	report := selfTestReport
+	selfTestLock.Unlock()
+
+	if report == nil {
+		report = selfTestRun()
+	}
+
+	return report
+}
+
+// selfTestCapabilities returns the names of the available capabilities,
+// prefixed with their category.
+func selfTestCapabilities() []string {
+	capabilities := []string{}
+	for _, check := range selfTestLoad().Checks {
+		if check.Available {
+			capabilities = append(capabilities, fmt.Sprintf("%s.%s", check.Category, check.Name))
+		}
+	}
+
+	return capabilities
+}
+
+// /1.0/self-test
+// Get the last capability report.
+func selfTestGet(d *Daemon, r *http.Request) Response {
+	return SyncResponse(true, selfTestLoad())
+}
+
+// /1.0/self-test
+// Re-run the environment checks.
+func selfTestPost(d *Daemon, r *http.Request) Response {
+	return SyncResponse(true, selfTestRun())
+}
+
+var selfTestCmd = Command{name: "self-test", get: selfTestGet, post: selfTestPost}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// signedURLDefaultExpiry is how long signed URLs are valid for when no expiry
+// was requested.
+const signedURLDefaultExpiry = time.Hour
+
+var signedURLKeyLock sync.Mutex
+var signedURLKey []byte
+
+// signedURLKeyGet returns the key the URLs are signed with, generating it on
+// first use. Removing it and restarting LXD invalidates all the signed URLs
+// handed out so far.
+func signedURLKeyGet() ([]byte, error) {
+	signedURLKeyLock.Lock()
+	defer signedURLKeyLock.Unlock()
+
+	if signedURLKey != nil {
+		return signedURLKey, nil
+	}
+
+	path := shared.VarPath("signed-urls.key")
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		secret, err := shared.RandomCryptoString()
+		if err != nil {
+			return nil, err
+		}
+
+		err = ioutil.WriteFile(path, []byte(secret), 0600)
+		if err != nil {
+			return nil, err
+		}
+
+		content = []byte(secret)
+	} else if err != nil {
+		return nil, err
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("Invalid signing key in %s", path)
+	}
+
+	signedURLKey = key
+	return signedURLKey, nil
+}
+
+// signedURLSignature returns the signature of a URL path valid until the
+// given unix time.
+func signedURLSignature(key []byte, path string, expires int64) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%d", path, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedURLCheck tells whether the signature of a URL path is valid and not
+// expired yet.
+func signedURLCheck(key []byte, path string, expires string, signature string, now time.Time) bool {
+	expiry, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() > expiry {
+		return false
+	}
+
+	return hmac.Equal([]byte(signedURLSignature(key, path, expiry)), []byte(signature))
+}
+
+// signedURLValid tells whether a request was made through a valid signed URL.
+func signedURLValid(r *http.Request) bool {
+	signature := r.FormValue("signature")
+	if signature == "" {
+		return false
+	}
+
+	key, err := signedURLKeyGet()
+	if err != nil {
+		logger.Error("Failed to load the signing key of the URLs", log.Ctx{"err": err})
+		return false
+	}
+
+	return signedURLCheck(key, r.URL.Path, r.FormValue("expires"), signature, time.Now())
+}
+
+// signedURLResponse returns a URL of the given path which can be fetched
+// without a trusted client certificate until the requested expiry.
+func signedURLResponse(r *http.Request, path string) Response {
+	req := api.SignedURLPost{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && err != io.EOF {
+		return BadRequest(err)
+	}
+
+	if req.ExpiresAt.IsZero() {
+		req.ExpiresAt = time.Now().Add(signedURLDefaultExpiry)
+	}
+
+	if !req.ExpiresAt.After(time.Now()) {
+		return BadRequest(fmt.Errorf("The expiry of the URL must be in the future"))
+	}
+
+	key, err := signedURLKeyGet()
+	if err != nil {
+		return InternalError(err)
+	}
+
+	expires := req.ExpiresAt.Unix()
+	signedURL := api.SignedURL{
+		URL:       fmt.Sprintf("%s?expires=%d&signature=%s", path, expires, signedURLSignature(key, path, expires)),
+		ExpiresAt: time.Unix(expires, 0),
+	}
+
+	return SyncResponse(true, signedURL)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSignedURLCheck(t *testing.T) {
+	key := []byte("secret")
+	path := "/1.0/images/abcd/export"
+	now := time.Unix(1500000000, 0)
+	expires := now.Add(time.Hour).Unix()
+	signature := signedURLSignature(key, path, expires)
+
+	if !signedURLCheck(key, path, fmt.Sprintf("%d", expires), signature, now) {
+		t.Errorf("Expected the signature to be valid")
+	}
+
+	if signedURLCheck(key, path, fmt.Sprintf("%d", expires), signature, now.Add(2*time.Hour)) {
+		t.Errorf("Expected an expired signature to be rejected")
+	}
+
+	if signedURLCheck(key, "/1.0/images/efgh/export", fmt.Sprintf("%d", expires), signature, now) {
+		t.Errorf("Expected the signature of another path to be rejected")
+	}
+
+	if signedURLCheck(key, path, fmt.Sprintf("%d", expires+3600), signature, now) {
+		t.Errorf("Expected an extended expiry to be rejected")
+	}
+
+	if signedURLCheck([]byte("other"), path, fmt.Sprintf("%d", expires), signature, now) {
+		t.Errorf("Expected the signature of another key to be rejected")
+	}
+
+	if signedURLCheck(key, path, "tomorrow", signature, now) {
+		t.Errorf("Expected an invalid expiry to be rejected")
+	}
+}
@@ -19,45 +19,65 @@ import (
 	"github.com/lxc/lxd/shared/version"
 )
 
-// lxdStorageLockMap is a hashmap that allows functions to check whether the
-// operation they are about to perform is already in progress. If it is the
-// channel can be used to wait for the operation to finish. If it is not, the
-// function that wants to perform the operation should store its code in the
-// hashmap.
-// Note that any access to this map must be done while holding a lock.
-var lxdStorageOngoingOperationMap = map[string]chan bool{}
-
-// lxdStorageMapLock is used to access lxdStorageOngoingOperationMap.
-var lxdStorageMapLock sync.Mutex
+// lxdStorageVolumeRefs counts the users of each mounted custom storage volume
+// so that a volume attached to several containers is only unmounted once the
+// last of them is done with it.
+var lxdStorageVolumeRefs = map[storageLockKey]int{}
+
+// lxdStorageVolumeRefsLock is used to access lxdStorageVolumeRefs. It is held
+// while a custom storage volume is being mounted or unmounted so that a mount
+// can't race with the unmount of the last user.
+var lxdStorageVolumeRefsLock sync.Mutex
+
+// storagePoolVolumeMountRef takes a reference on a custom storage volume,
+// mounting it with the given function unless it's already mounted. It returns
+// whether the volume was mounted.
+func storagePoolVolumeMountRef(poolName string, volumeName string, mount func() error) (bool, error) {
+	refID := storageCustomLockKey(poolName, volumeName)
+
+	lxdStorageVolumeRefsLock.Lock()
+	defer lxdStorageVolumeRefsLock.Unlock()
+
+	ourMount := false
+	if !shared.IsMountPoint(getStoragePoolVolumeMountPoint(poolName, volumeName)) {
+		err := mount()
+		if err != nil {
+			return false, err
+		}
+		ourMount = true
+	}
 
-// The following functions are used to construct simple operation codes that are
-// unique.
-func getPoolMountLockID(poolName string) string {
-	return fmt.Sprintf("mount/pool/%s", poolName)
+	lxdStorageVolumeRefs[refID]++
+	return ourMount, nil
 }
 
-func getPoolUmountLockID(poolName string) string {
-	return fmt.Sprintf("umount/pool/%s", poolName)
-}
+// storagePoolVolumeUmountRef drops a reference on a custom storage volume and
+// unmounts it with the given function if it was the last one. It returns
+// whether the volume was unmounted.
+func storagePoolVolumeUmountRef(poolName string, volumeName string, umount func() error) (bool, error) {
+	refID := storageCustomLockKey(poolName, volumeName)
 
-func getImageCreateLockID(poolName string, fingerprint string) string {
-	return fmt.Sprintf("create/image/%s/%s", poolName, fingerprint)
-}
+	lxdStorageVolumeRefsLock.Lock()
+	defer lxdStorageVolumeRefsLock.Unlock()
 
-func getContainerMountLockID(poolName string, containerName string) string {
-	return fmt.Sprintf("mount/container/%s/%s", poolName, containerName)
-}
+	if lxdStorageVolumeRefs[refID] > 1 {
+		lxdStorageVolumeRefs[refID]--
+		logger.Debugf("Storage volume \"%s\" on storage pool \"%s\" is still used %d times, not unmounting.", volumeName, poolName, lxdStorageVolumeRefs[refID])
+		return false, nil
+	}
 
-func getContainerUmountLockID(poolName string, containerName string) string {
-	return fmt.Sprintf("umount/container/%s/%s", poolName, containerName)
-}
+	if !shared.IsMountPoint(getStoragePoolVolumeMountPoint(poolName, volumeName)) {
+		delete(lxdStorageVolumeRefs, refID)
+		return false, nil
+	}
 
-func getCustomMountLockID(poolName string, volumeName string) string {
-	return fmt.Sprintf("mount/custom/%s/%s", poolName, volumeName)
-}
+	err := umount()
+	if err != nil {
+		return false, err
+	}
 
-func getCustomUmountLockID(poolName string, volumeName string) string {
-	return fmt.Sprintf("umount/custom/%s/%s", poolName, volumeName)
+	delete(lxdStorageVolumeRefs, refID)
+	return true, nil
 }
 
 // Simply cache used to storage the activated drivers on this LXD instance. This
@@ -120,10 +140,12 @@ const (
 	storageTypeZfs
 	storageTypeLvm
 	storageTypeDir
+	storageTypeBlock
+	storageTypeExternal
 	storageTypeMock
 )
 
-var supportedStoragePoolDrivers = []string{"btrfs", "dir", "lvm", "zfs"}
+var supportedStoragePoolDrivers = []string{"block", "btrfs", "dir", "external", "lvm", "zfs"}
 
 func storageTypeToString(sType storageType) (string, error) {
 	switch sType {
@@ -137,6 +159,10 @@ func storageTypeToString(sType storageType) (string, error) {
 		return "mock", nil
 	case storageTypeDir:
 		return "dir", nil
+	case storageTypeBlock:
+		return "block", nil
+	case storageTypeExternal:
+		return "external", nil
 	}
 
 	return "", fmt.Errorf("invalid storage type")
@@ -154,6 +180,10 @@ func storageStringToType(sName string) (storageType, error) {
 		return storageTypeMock, nil
 	case "dir":
 		return storageTypeDir, nil
+	case "block":
+		return storageTypeBlock, nil
+	case "external":
+		return storageTypeExternal, nil
 	}
 
 	return -1, fmt.Errorf("invalid storage type name")
@@ -168,6 +198,10 @@ type storage interface {
 	GetStorageTypeName() string
 	GetStorageTypeVersion() string
 
+	// StorageAuditSet sets the audit entry of the destructive operation
+	// the storage is used for, the commands it runs are recorded to it.
+	StorageAuditSet(audit *storageAudit)
+
 	// Functions dealing with storage pools.
 	StoragePoolInit() error
 	StoragePoolCheck() error
@@ -179,15 +213,44 @@ type storage interface {
 	GetStoragePoolWritable() api.StoragePoolPut
 	SetStoragePoolWritable(writable *api.StoragePoolPut)
 
+	// StoragePoolVerify adds the divergences between the database and the
+	// driver specific on-disk state of the pool and of the given
+	// containers to the report.
+	StoragePoolVerify(report *storageVerifyReport, containers []container) error
+
+	// StoragePoolDeletePlan and StoragePoolVolumeDeletePlan add what
+	// StoragePoolDelete and StoragePoolVolumeDelete would do to a dry-run
+	// plan, without doing it.
+	StoragePoolDeletePlan(plan *api.OperationPlan) error
+	StoragePoolVolumeDeletePlan(plan *api.OperationPlan) error
+
 	// Functions dealing with custom storage volumes.
 	StoragePoolVolumeCreate() error
 	StoragePoolVolumeDelete() error
+
+	// StoragePoolVolumeMount returns whether it mounted the volume. Each
+	// successful call takes a reference on the volume, whether it mounted
+	// it or not, which StoragePoolVolumeUmount releases.
 	StoragePoolVolumeMount() (bool, error)
 	StoragePoolVolumeUmount() (bool, error)
-	StoragePoolVolumeUpdate(changedConfig []string) error
+
+	StoragePoolVolumeUpdate(writable *api.StorageVolumePut, changedConfig []string) error
+	StoragePoolVolumeRename(newName string) error
+	StoragePoolVolumeGetState() (*api.StorageVolumeState, error)
+
+	// StoragePoolVolumeCopy creates the volume as a copy of another custom
+	// volume of the pool. Unless flatten is set, the copy may share its
+	// data with the source.
+	StoragePoolVolumeCopy(sourceName string, flatten bool) error
 	GetStoragePoolVolumeWritable() api.StorageVolumePut
 	SetStoragePoolVolumeWritable(writable *api.StorageVolumePut)
 
+	// Functions dealing with custom storage volume snapshots.
+	StoragePoolVolumeSnapshotCreate(snapshotName string) error
+	StoragePoolVolumeSnapshotDelete(snapshotName string) error
+	StoragePoolVolumeSnapshotRename(snapshotName string, newName string) error
+	StoragePoolVolumeSnapshotRestore(snapshotName string) error
+
 	// Functions dealing with container storage volumes.
 	// ContainerCreate creates an empty container (no rootfs/metadata.yaml)
 	ContainerCreate(container container) error
@@ -197,10 +260,31 @@ type storage interface {
 	ContainerCanRestore(container container, sourceContainer container) error
 	ContainerDelete(container container) error
 	ContainerCopy(target container, source container, containerOnly bool) error
+
+	// ContainerRefresh brings a previous copy of the source up to date by
+	// sending the snapshots it's missing and the current state of the
+	// source incrementally from base, the latest snapshot they share.
+	ContainerRefresh(target container, source container, base container, snapshots []container) error
+
+	// ContainerRebuild replaces the rootfs of a container by a fresh one
+	// created from an image, leaving the rest of the container alone.
+	ContainerRebuild(container container, imageFingerprint string) error
+
+	// ScratchVolumeCreate creates the scratch volume of a disk device of a
+	// container being started and returns where it's mounted, while
+	// ScratchVolumeDelete destroys it along with its content.
+	ScratchVolumeCreate(container container, device string, size int64) (string, error)
+	ScratchVolumeDelete(container container, device string) error
 	ContainerMount(c container) (bool, error)
 	ContainerUmount(name string, path string) (bool, error)
 	ContainerRename(container container, newName string) error
 	ContainerRestore(container container, sourceContainer container) error
+
+	// ContainerDeletePlan and ContainerRestorePlan add what
+	// ContainerDelete, or ContainerSnapshotDelete for snapshots, and
+	// ContainerRestore would do to a dry-run plan, without doing it.
+	ContainerDeletePlan(container container, plan *api.OperationPlan) error
+	ContainerRestorePlan(container container, sourceContainer container, plan *api.OperationPlan) error
 	ContainerSetQuota(container container, size int64) error
 	ContainerGetUsage(container container) (int64, error)
 	GetContainerPoolInfo() (int64, string)
@@ -215,6 +299,15 @@ type storage interface {
 	// For use in migrating snapshots.
 	ContainerSnapshotCreateEmpty(snapshotContainer container) error
 
+	// Functions dealing with backups.
+	// ContainerBackupCreate writes the optimized storage format of a
+	// backup of the source to path.
+	ContainerBackupCreate(backup backupArgs, sourceContainer container, path string) error
+
+	// ContainerBackupLoad creates the container and its snapshots from the
+	// unpacked backup at path.
+	ContainerBackupLoad(info backupInfo, path string) error
+
 	// Functions dealing with image storage volumes.
 	ImageCreate(fingerprint string) error
 	ImageDelete(fingerprint string) error
@@ -245,7 +338,7 @@ type storage interface {
 	// already present on the target instance as an exercise for the
 	// enterprising developer.
 	MigrationSource(container container, containerOnly bool) (MigrationStorageSourceDriver, error)
-	MigrationSink(live bool, container container, objects []*Snapshot, conn *websocket.Conn, srcIdmap *shared.IdmapSet, op *operation, containerOnly bool) error
+	MigrationSink(conn *websocket.Conn, op *operation, args MigrationSinkArgs) error
 }
 
 func storageCoreInit(driver string) (storage, error) {
@@ -255,6 +348,13 @@ func storageCoreInit(driver string) (storage, error) {
 	}
 
 	switch sType {
+	case storageTypeBlock:
+		block := storageBlock{}
+		err = block.StorageCoreInit()
+		if err != nil {
+			return nil, err
+		}
+		return &block, nil
 	case storageTypeBtrfs:
 		btrfs := storageBtrfs{}
 		err = btrfs.StorageCoreInit()
@@ -269,6 +369,13 @@ func storageCoreInit(driver string) (storage, error) {
 			return nil, err
 		}
 		return &dir, nil
+	case storageTypeExternal:
+		external := storageExternal{}
+		err = external.StorageCoreInit()
+		if err != nil {
+			return nil, err
+		}
+		return &external, nil
 	case storageTypeLvm:
 		lvm := storageLvm{}
 		err = lvm.StorageCoreInit()
@@ -324,6 +431,17 @@ func storageInit(d *Daemon, poolName string, volumeName string, volumeType int)
 	}
 
 	switch sType {
+	case storageTypeBlock:
+		block := storageBlock{}
+		block.poolID = poolID
+		block.pool = pool
+		block.volume = volume
+		block.d = d
+		err = block.StoragePoolInit()
+		if err != nil {
+			return nil, err
+		}
+		return storageWrap(&block, poolName, volumeName), nil
 	case storageTypeBtrfs:
 		btrfs := storageBtrfs{}
 		btrfs.poolID = poolID
@@ -334,7 +452,7 @@ func storageInit(d *Daemon, poolName string, volumeName string, volumeType int)
 		if err != nil {
 			return nil, err
 		}
-		return &btrfs, nil
+		return storageWrap(&btrfs, poolName, volumeName), nil
 	case storageTypeDir:
 		dir := storageDir{}
 		dir.poolID = poolID
@@ -345,7 +463,18 @@ func storageInit(d *Daemon, poolName string, volumeName string, volumeType int)
 		if err != nil {
 			return nil, err
 		}
-		return &dir, nil
+		return storageWrap(&dir, poolName, volumeName), nil
+	case storageTypeExternal:
+		external := storageExternal{}
+		external.poolID = poolID
+		external.pool = pool
+		external.volume = volume
+		external.d = d
+		err = external.StoragePoolInit()
+		if err != nil {
+			return nil, err
+		}
+		return storageWrap(&external, poolName, volumeName), nil
 	case storageTypeLvm:
 		lvm := storageLvm{}
 		lvm.poolID = poolID
@@ -356,7 +485,7 @@ func storageInit(d *Daemon, poolName string, volumeName string, volumeType int)
 		if err != nil {
 			return nil, err
 		}
-		return &lvm, nil
+		return storageWrap(&lvm, poolName, volumeName), nil
 	case storageTypeMock:
 		mock := storageMock{}
 		mock.poolID = poolID
@@ -367,7 +496,7 @@ func storageInit(d *Daemon, poolName string, volumeName string, volumeType int)
 		if err != nil {
 			return nil, err
 		}
-		return &mock, nil
+		return storageWrap(&mock, poolName, volumeName), nil
 	case storageTypeZfs:
 		zfs := storageZfs{}
 		zfs.poolID = poolID
@@ -378,22 +507,64 @@ func storageInit(d *Daemon, poolName string, volumeName string, volumeType int)
 		if err != nil {
 			return nil, err
 		}
-		return &zfs, nil
+		return storageWrap(&zfs, poolName, volumeName), nil
 	}
 
 	return nil, fmt.Errorf("invalid storage type")
 }
 
+// storageWrap wraps a storage driver in the layers its operations go
+// through, from the outside in: the history recorder, the locks and the
+// storage hooks.
+func storageWrap(driver storage, poolName string, volumeName string) storage {
+	hooks := &storageHookRunner{storage: driver, poolName: poolName}
+	locker := &storageLocker{storage: hooks, poolName: poolName, volumeName: volumeName}
+	return &storageHistoryRecorder{storage: locker, poolName: poolName, volumeName: volumeName}
+}
+
+// storageUnwrap returns the storage driver below the layers added by
+// storageWrap, for the code which needs the driver's own type.
+func storageUnwrap(s storage) storage {
+	for {
+		switch wrapper := s.(type) {
+		case *storageHistoryRecorder:
+			s = wrapper.storage
+		case *storageLocker:
+			s = wrapper.storage
+		case *storageHookRunner:
+			s = wrapper.storage
+		default:
+			return s
+		}
+	}
+}
+
 func storagePoolInit(d *Daemon, poolName string) (storage, error) {
 	return storageInit(d, poolName, "", -1)
 }
 
+// storagePoolVolumeAttachInit initializes a custom storage volume which is
+// being attached to a container, shifting it to the container's idmap if
+// needed. The volume is returned mounted, the reference taken on it being
+// released when the volume is detached.
 func storagePoolVolumeAttachInit(d *Daemon, poolName string, volumeName string, volumeType int, c container) (storage, error) {
 	st, err := storageInit(d, poolName, volumeName, volumeType)
 	if err != nil {
 		return nil, err
 	}
 
+	_, err = st.StoragePoolVolumeMount()
+	if err != nil {
+		return nil, err
+	}
+
+	tryUndo := true
+	defer func() {
+		if tryUndo {
+			st.StoragePoolVolumeUmount()
+		}
+	}()
+
 	poolVolumePut := st.GetStoragePoolVolumeWritable()
 
 	// get last idmapset
@@ -448,23 +619,14 @@ func storagePoolVolumeAttachInit(d *Daemon, poolName string, volumeName string,
 			}
 		}
 
-		// mount storage volume
-		ourMount, err := st.StoragePoolVolumeMount()
+		err = storageShiftCheck(st)
 		if err != nil {
 			return nil, err
 		}
-		if ourMount {
-			defer func() {
-				_, err := st.StoragePoolVolumeUmount()
-				if err != nil {
-					logger.Warnf("failed to unmount storage volume")
-				}
-			}()
-		}
 
 		// unshift rootfs
 		if lastIdmap != nil {
-			err := lastIdmap.UnshiftRootfs(remapPath)
+			err := lastIdmap.UnshiftRootfs(remapPath, nil)
 			if err != nil {
 				logger.Errorf("Failed to unshift \"%s\"", remapPath)
 				return nil, err
@@ -474,7 +636,7 @@ func storagePoolVolumeAttachInit(d *Daemon, poolName string, volumeName string,
 
 		// shift rootfs
 		if nextIdmap != nil {
-			err := nextIdmap.ShiftRootfs(remapPath)
+			err := nextIdmap.ShiftRootfs(remapPath, nil)
 			if err != nil {
 				logger.Errorf("Failed to shift \"%s\"", remapPath)
 				return nil, err
@@ -508,6 +670,7 @@ func storagePoolVolumeAttachInit(d *Daemon, poolName string, volumeName string,
 		return nil, err
 	}
 
+	tryUndo = false
 	return st, nil
 }
 
@@ -554,6 +717,11 @@ func getImageMountPoint(poolName string, fingerprint string) string {
 	return shared.VarPath("storage-pools", poolName, "images", fingerprint)
 }
 
+// ${LXD_DIR}/storage-pools/<pool>/scratch/<container_name>/<device>
+func getScratchVolumeMountPoint(poolName string, containerName string, device string) string {
+	return shared.VarPath("storage-pools", poolName, "scratch", containerName, device)
+}
+
 // ${LXD_DIR}/storage-pools/<pool>/custom/<storage_volume>
 func getStoragePoolVolumeMountPoint(poolName string, volumeName string) string {
 	return shared.VarPath("storage-pools", poolName, "custom", volumeName)
@@ -726,6 +894,74 @@ func ShiftIfNecessary(container container, srcIdmap *shared.IdmapSet) error {
 	return nil
 }
 
+// storageContainerCopyAcrossPools copies a container, along with its
+// snapshots unless containerOnly, from another storage pool with rsync. The
+// storage of the target is created through s, the driver of its pool, while
+// the database records of the target and of its snapshots must exist already.
+func storageContainerCopyAcrossPools(s storage, target container, source container, containerOnly bool) error {
+	logger.Debugf("Copying container storage %s -> %s across storage pools.", source.Name(), target.Name())
+
+	poolConfig := s.GetStoragePoolWritable().Config
+	bwlimit := poolConfig["rsync.bwlimit"]
+	rsyncArgs := rsyncPoolLocalArgs(poolConfig)
+
+	err := s.ContainerCreate(target)
+	if err != nil {
+		return err
+	}
+
+	ourStart, err := target.StorageStart()
+	if err != nil {
+		return err
+	}
+	if ourStart {
+		defer target.StorageStop()
+	}
+
+	// Each snapshot is copied into the target which is then snapshotted,
+	// so that the drivers keeping snapshots as deltas still do.
+	if !containerOnly {
+		snapshots, err := source.Snapshots()
+		if err != nil {
+			return err
+		}
+
+		for _, snap := range snapshots {
+			_, snapOnlyName, _ := containerGetParentAndSnapshotName(snap.Name())
+			targetSnapshot, err := containerLoadByName(target.Daemon(), fmt.Sprintf("%s/%s", target.Name(), snapOnlyName))
+			if err != nil {
+				return err
+			}
+
+			ourSnapStart, err := snap.StorageStart()
+			if err != nil {
+				return err
+			}
+
+			output, err := rsyncLocalCopy(snap.Path(), shared.AddSlash(target.Path()), bwlimit, rsyncArgs...)
+			if ourSnapStart {
+				snap.StorageStop()
+			}
+			if err != nil {
+				return fmt.Errorf("Failed to rsync snapshot %s: %s: %s", snap.Name(), output, err)
+			}
+
+			err = s.ContainerSnapshotCreate(targetSnapshot, target)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	output, err := rsyncLocalCopy(source.Path(), shared.AddSlash(target.Path()), bwlimit, rsyncArgs...)
+	if err != nil {
+		return fmt.Errorf("Failed to rsync container %s: %s: %s", source.Name(), output, err)
+	}
+
+	logger.Debugf("Copied container storage %s -> %s across storage pools.", source.Name(), target.Name())
+	return nil
+}
+
 func progressWrapperRender(op *operation, key string, description string, progressInt int64, speedInt int64) {
 	meta := op.metadata
 	if meta == nil {
@@ -741,6 +977,8 @@ func progressWrapperRender(op *operation, key string, description string, progre
 		meta[key] = progress
 		op.UpdateMetadata(meta)
 	}
+
+	operationStagesGet(op).storageProgress(speedInt)
 }
 
 // StorageProgressReader reports the read progress.
@@ -755,7 +993,7 @@ func StorageProgressReader(op *operation, key string, description string) func(i
 		}
 
 		readPipe := &ioprogress.ProgressReader{
-			ReadCloser: reader,
+			ReadCloser: &storageCountingReader{ReadCloser: reader, op: op},
 			Tracker: &ioprogress.ProgressTracker{
 				Handler: progress,
 			},
@@ -777,7 +1015,7 @@ func StorageProgressWriter(op *operation, key string, description string) func(i
 		}
 
 		writePipe := &ioprogress.ProgressWriter{
-			WriteCloser: writer,
+			WriteCloser: &storageCountingWriter{WriteCloser: writer, op: op},
 			Tracker: &ioprogress.ProgressTracker{
 				Handler: progress,
 			},
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// storageAuditRequestorLXD is the requestor of the destructive storage
+// operations LXD runs on its own, e.g. the expiry of snapshots.
+const storageAuditRequestorLXD = "lxd"
+
+// storageAudit collects the audit entry of a destructive storage operation
+// while it runs, along with the commands it runs on the way.
+type storageAudit struct {
+	lock  sync.Mutex
+	entry api.StorageAuditEntry
+}
+
+// storageAuditRequestor describes who sent a request: the local unix socket
+// or the fingerprint of the client certificate and the remote address.
+func storageAuditRequestor(r *http.Request) string {
+	if r == nil {
+		return storageAuditRequestorLXD
+	}
+
+	if r.RemoteAddr == "@" {
+		return "unix socket"
+	}
+
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		fingerprint := shared.CertFingerprint(r.TLS.PeerCertificates[0])
+		return fmt.Sprintf("%s (%s)", fingerprint[:12], r.RemoteAddr)
+	}
+
+	return r.RemoteAddr
+}
+
+// storageAuditStart starts the audit entry of a destructive storage operation
+// sent by the given request, nil for LXD itself.
+func storageAuditStart(r *http.Request, poolName string, action string, target string) *storageAudit {
+	return &storageAudit{entry: api.StorageAuditEntry{
+		Date:      time.Now().UTC(),
+		Pool:      poolName,
+		Action:    action,
+		Target:    target,
+		Requestor: storageAuditRequestor(r),
+		Commands:  []string{},
+	}}
+}
+
+// command records a command run by the operation.
+func (a *storageAudit) command(name string, args ...string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.entry.Commands = append(a.entry.Commands, strings.Join(append([]string{name}, args...), " "))
+}
+
+// finish stores the audit entry along with the outcome of the operation,
+// pruning the ones older than "storage.audit_retention" days.
+func (a *storageAudit) finish(d *Daemon, err error) {
+	a.lock.Lock()
+	entry := a.entry
+	a.lock.Unlock()
+
+	entry.Result = "success"
+	if err != nil {
+		entry.Result = "failure"
+		entry.Error = err.Error()
+	}
+
+	dbErr := dbStorageAuditAdd(d.db, entry)
+	if dbErr != nil {
+		logger.Error("Failed to store storage audit entry", log.Ctx{"pool": entry.Pool, "action": entry.Action, "target": entry.Target, "err": dbErr})
+	}
+
+	retention := daemonConfig["storage.audit_retention"].GetInt64()
+	if retention > 0 {
+		dbErr = dbStorageAuditPrune(d.db, time.Now().Add(-time.Duration(retention)*24*time.Hour))
+		if dbErr != nil {
+			logger.Error("Failed to prune the storage audit entries", log.Ctx{"err": dbErr})
+		}
+	}
+}
+
+// storageAuditCommand records a destructive command about to be run by a
+// storage driver to the audit entry of the operation it's part of. Commands
+// run outside of one, e.g. the deletion of the snapshots of a container being
+// deleted, get an audit entry of their own, requested by LXD. The returned
+// function must be called with the outcome of the command.
+func storageAuditCommand(d *Daemon, audit *storageAudit, poolName string, action string, target string, name string, args ...string) func(err error) {
+	if audit != nil {
+		audit.command(name, args...)
+		return func(err error) {}
+	}
+
+	own := storageAuditStart(nil, poolName, action, target)
+	own.command(name, args...)
+	return func(err error) {
+		if d != nil {
+			own.finish(d, err)
+		}
+	}
+}
+
+// /1.0/storage-audit
+// List the destructive storage operations, most recent first.
+func storageAuditGet(d *Daemon, r *http.Request) Response {
+	entries, err := dbStorageAuditGet(d.db, r.FormValue("pool"))
+	if err != nil {
+		return SmartError(err)
+	}
+
+	return SyncResponse(true, entries)
+}
+
+var storageAuditCmd = Command{name: "storage-audit", get: storageAuditGet}
@@ -0,0 +1,821 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/logger"
+)
+
+// The block driver gives every container and custom volume a whole block
+// device of its own, either one of "block.devices" or one of the LUNs of the
+// "block.iscsi.target". Everything else, snapshots and images included, is
+// kept in the directory of the pool like with the dir driver.
+type storageBlock struct {
+	storageDir
+}
+
+// storageBlockClaimLock serializes the choice of the device of new volumes,
+// so that two of them never end up on the same one.
+var storageBlockClaimLock sync.Mutex
+
+// blockISCSIPath returns the path udev gives to the LUNs of an iSCSI target,
+// without the "-lun-<n>" suffix.
+func blockISCSIPath(portal string, target string) string {
+	if !strings.Contains(portal, ":") {
+		portal = fmt.Sprintf("%s:3260", portal)
+	}
+
+	return fmt.Sprintf("/dev/disk/by-path/ip-%s-iscsi-%s", portal, target)
+}
+
+// blockPoolDevices returns the block devices of a storage pool with the given
+// config, in the order volumes get them.
+func blockPoolDevices(config map[string]string) ([]string, error) {
+	devices := []string{}
+	for _, device := range strings.Split(config["block.devices"], ",") {
+		device = strings.TrimSpace(device)
+		if device == "" {
+			continue
+		}
+
+		devices = append(devices, device)
+	}
+
+	if config["block.iscsi.target"] != "" {
+		luns, err := filepath.Glob(blockISCSIPath(config["block.iscsi.portal"], config["block.iscsi.target"]) + "-lun-*")
+		if err != nil {
+			return nil, err
+		}
+
+		devices = append(devices, luns...)
+	}
+
+	return devices, nil
+}
+
+// blockDeviceHasData tells whether a block device holds a filesystem or any
+// other signature blkid knows of.
+func blockDeviceHasData(device string) bool {
+	fsType, err := shared.BlockFsDetect(device)
+	return err == nil && fsType != ""
+}
+
+// blockDeviceFree returns the first of the devices which is neither used by a
+// volume nor holds any data.
+func blockDeviceFree(devices []string, used []string, hasData func(device string) bool) (string, error) {
+	for _, device := range devices {
+		if shared.StringInSlice(device, used) || hasData(device) {
+			continue
+		}
+
+		return device, nil
+	}
+
+	return "", fmt.Errorf("No free block device left in the storage pool")
+}
+
+// Only initialize the minimal information we need about a given storage type.
+func (s *storageBlock) StorageCoreInit() error {
+	s.sType = storageTypeBlock
+	typeName, err := storageTypeToString(s.sType)
+	if err != nil {
+		return err
+	}
+	s.sTypeName = typeName
+	s.sTypeVersion = "1"
+
+	logger.Debugf("Initializing a block driver.")
+	return nil
+}
+
+// Initialize a full storage interface.
+func (s *storageBlock) StoragePoolInit() error {
+	err := s.StorageCoreInit()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// The iSCSI target is logged into when LXD starts, so that the devices of the
+// volumes are there.
+func (s *storageBlock) StoragePoolCheck() error {
+	logger.Debugf("Checking block storage pool \"%s\".", s.pool.Name)
+	return s.blockISCSILogin()
+}
+
+// blockISCSILogin logs into the iSCSI target of the pool, unless its LUNs
+// are already there.
+func (s *storageBlock) blockISCSILogin() error {
+	portal := s.pool.Config["block.iscsi.portal"]
+	target := s.pool.Config["block.iscsi.target"]
+	if target == "" {
+		return nil
+	}
+
+	luns, _ := filepath.Glob(blockISCSIPath(portal, target) + "-lun-*")
+	if len(luns) > 0 {
+		return nil
+	}
+
+	output, err := shared.RunCommand("iscsiadm", "-m", "discovery", "-t", "sendtargets", "-p", portal)
+	if err != nil {
+		return fmt.Errorf("Failed to discover the iSCSI targets of \"%s\": %s", portal, output)
+	}
+
+	output, err = shared.RunCommand("iscsiadm", "-m", "node", "-T", target, "-p", portal, "--login")
+	if err != nil {
+		return fmt.Errorf("Failed to log into iSCSI target \"%s\": %s", target, output)
+	}
+
+	return nil
+}
+
+func (s *storageBlock) StoragePoolCreate() error {
+	logger.Infof("Creating block storage pool \"%s\".", s.pool.Name)
+
+	if s.pool.Config["block.devices"] == "" && s.pool.Config["block.iscsi.target"] == "" {
+		return fmt.Errorf("Block storage pools need \"block.devices\" or \"block.iscsi.target\" to be set")
+	}
+
+	if (s.pool.Config["block.iscsi.portal"] == "") != (s.pool.Config["block.iscsi.target"] == "") {
+		return fmt.Errorf("\"block.iscsi.portal\" and \"block.iscsi.target\" must be set together")
+	}
+
+	// The directory of the pool holds the snapshots and the mountpoints of
+	// the volumes.
+	err := s.storageDir.StoragePoolCreate()
+	if err != nil {
+		return err
+	}
+
+	revert := true
+	defer func() {
+		if !revert {
+			return
+		}
+		s.storageDir.StoragePoolDelete()
+	}()
+
+	err = s.blockISCSILogin()
+	if err != nil {
+		return err
+	}
+
+	devices, err := blockPoolDevices(s.pool.Config)
+	if err != nil {
+		return err
+	}
+
+	if len(devices) == 0 {
+		return fmt.Errorf("No block device found for the storage pool")
+	}
+
+	for _, device := range devices {
+		if !shared.IsBlockdevPath(device) {
+			return fmt.Errorf("\"%s\" is not a block device", device)
+		}
+	}
+
+	revert = false
+
+	logger.Infof("Created block storage pool \"%s\".", s.pool.Name)
+	return nil
+}
+
+func (s *storageBlock) StoragePoolDelete() error {
+	logger.Infof("Deleting block storage pool \"%s\".", s.pool.Name)
+
+	err := s.storageDir.StoragePoolDelete()
+	if err != nil {
+		return err
+	}
+
+	target := s.pool.Config["block.iscsi.target"]
+	if target != "" {
+		output, err := shared.RunCommand("iscsiadm", "-m", "node", "-T", target, "-p", s.pool.Config["block.iscsi.portal"], "--logout")
+		if err != nil {
+			return fmt.Errorf("Failed to log out of iSCSI target \"%s\": %s", target, output)
+		}
+	}
+
+	logger.Infof("Deleted block storage pool \"%s\".", s.pool.Name)
+	return nil
+}
+
+func (s *storageBlock) StoragePoolDeletePlan(plan *api.OperationPlan) error {
+	err := s.storageDir.StoragePoolDeletePlan(plan)
+	if err != nil {
+		return err
+	}
+
+	target := s.pool.Config["block.iscsi.target"]
+	if target != "" {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("Log out of iSCSI target \"%s\"", target))
+	}
+
+	return nil
+}
+
+func (s *storageBlock) StoragePoolMount() (bool, error) {
+	err := s.blockISCSILogin()
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *storageBlock) StoragePoolUpdate(writable *api.StoragePoolPut, changedConfig []string) error {
+	for _, key := range changedConfig {
+		if !shared.StringInSlice(key, storagePoolRsyncKeys) && !shared.StringInSlice(key, []string{"block.devices", "volume.block.filesystem", "volume.block.mount_options"}) {
+			return fmt.Errorf("The \"%s\" property of block storage pools can't be changed", key)
+		}
+	}
+
+	if !shared.StringInSlice("block.devices", changedConfig) {
+		return nil
+	}
+
+	devices, err := blockPoolDevices(writable.Config)
+	if err != nil {
+		return err
+	}
+
+	used, err := s.blockDevicesUsed()
+	if err != nil {
+		return err
+	}
+
+	for _, device := range used {
+		if !shared.StringInSlice(device, devices) {
+			return fmt.Errorf("Block device \"%s\" is used by a volume and can't be removed from the storage pool", device)
+		}
+	}
+
+	for _, device := range devices {
+		if !shared.IsBlockdevPath(device) {
+			return fmt.Errorf("\"%s\" is not a block device", device)
+		}
+	}
+
+	return nil
+}
+
+func (s *storageBlock) getBlockFilesystem() string {
+	if s.volume.Config["block.filesystem"] != "" {
+		return s.volume.Config["block.filesystem"]
+	}
+
+	if s.pool.Config["volume.block.filesystem"] != "" {
+		return s.pool.Config["volume.block.filesystem"]
+	}
+
+	return "ext4"
+}
+
+func (s *storageBlock) getBlockMountOptions() string {
+	if s.volume.Config["block.mount_options"] != "" {
+		return s.volume.Config["block.mount_options"]
+	}
+
+	if s.pool.Config["volume.block.mount_options"] != "" {
+		return s.pool.Config["volume.block.mount_options"]
+	}
+
+	return "discard"
+}
+
+// blockDevicesUsed returns the devices used by the volumes of the pool, but
+// the one the driver was initialized for.
+func (s *storageBlock) blockDevicesUsed() ([]string, error) {
+	volumes, err := dbStoragePoolVolumesGet(s.d.db, s.poolID, []int{storagePoolVolumeTypeContainer, storagePoolVolumeTypeCustom})
+	if err != nil && err != NoSuchObjectError {
+		return nil, err
+	}
+
+	used := []string{}
+	for _, volume := range volumes {
+		if volume.Name == s.volume.Name && volume.Type == s.volume.Type {
+			continue
+		}
+
+		if volume.Config["block.device"] != "" {
+			used = append(used, volume.Config["block.device"])
+		}
+	}
+
+	return used, nil
+}
+
+// blockVolumeClaim gives a device to the volume the driver was initialized
+// for, the one of its "block.device" if set or else the first free one, and
+// creates its filesystem.
+func (s *storageBlock) blockVolumeClaim(volumeType int) (string, error) {
+	storageBlockClaimLock.Lock()
+	defer storageBlockClaimLock.Unlock()
+
+	devices, err := blockPoolDevices(s.pool.Config)
+	if err != nil {
+		return "", err
+	}
+
+	used, err := s.blockDevicesUsed()
+	if err != nil {
+		return "", err
+	}
+
+	device := s.volume.Config["block.device"]
+	if device != "" {
+		if !shared.StringInSlice(device, devices) {
+			return "", fmt.Errorf("Block device \"%s\" isn't part of storage pool \"%s\"", device, s.pool.Name)
+		}
+
+		if shared.StringInSlice(device, used) {
+			return "", fmt.Errorf("Block device \"%s\" is already used by another volume", device)
+		}
+
+		if blockDeviceHasData(device) {
+			return "", fmt.Errorf("Block device \"%s\" already holds data", device)
+		}
+	} else {
+		device, err = blockDeviceFree(devices, used, blockDeviceHasData)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	output, err := makeFSType(device, s.getBlockFilesystem())
+	if err != nil {
+		return "", fmt.Errorf("Failed to create the filesystem on block device \"%s\": %s", device, output)
+	}
+
+	s.volume.Config["block.device"] = device
+	err = dbStoragePoolVolumeUpdate(s.d.db, s.volume.Name, volumeType, s.poolID, s.volume.Description, s.volume.Config)
+	if err != nil {
+		blockDeviceWipe(device)
+		return "", err
+	}
+
+	return device, nil
+}
+
+// blockDeviceWipe removes the filesystem of a device given back to the pool,
+// so that it's picked again for new volumes.
+func blockDeviceWipe(device string) error {
+	output, err := shared.RunCommand("wipefs", "-a", device)
+	if err != nil {
+		return fmt.Errorf("Failed to wipe block device \"%s\": %s", device, output)
+	}
+
+	return nil
+}
+
+// Functions dealing with storage volumes.
+func (s *storageBlock) StoragePoolVolumeCreate() error {
+	logger.Infof("Creating block storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+
+	device, err := s.blockVolumeClaim(storagePoolVolumeTypeCustom)
+	if err != nil {
+		return err
+	}
+
+	customPoolVolumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
+	err = os.MkdirAll(customPoolVolumeMntPoint, 0711)
+	if err != nil {
+		blockDeviceWipe(device)
+		return err
+	}
+
+	logger.Infof("Created block storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	return nil
+}
+
+func (s *storageBlock) StoragePoolVolumeDelete() error {
+	logger.Infof("Deleting block storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+
+	customPoolVolumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
+	if shared.IsMountPoint(customPoolVolumeMntPoint) {
+		err := tryUnmount(customPoolVolumeMntPoint, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	device := s.volume.Config["block.device"]
+	if device != "" {
+		err := blockDeviceWipe(device)
+		if err != nil {
+			return err
+		}
+	}
+
+	if shared.PathExists(customPoolVolumeMntPoint) {
+		err := os.Remove(customPoolVolumeMntPoint)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Infof("Deleted block storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	return nil
+}
+
+func (s *storageBlock) StoragePoolVolumeMount() (bool, error) {
+	logger.Debugf("Mounting block storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+
+	device := s.volume.Config["block.device"]
+	if device == "" {
+		return false, fmt.Errorf("Storage volume \"%s\" has no block device", s.volume.Name)
+	}
+
+	customPoolVolumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
+	ourMount, err := storagePoolVolumeMountRef(s.pool.Name, s.volume.Name, func() error {
+		mountFlags, mountOptions := lxdResolveMountoptions(s.getBlockMountOptions())
+		return tryMount(device, customPoolVolumeMntPoint, s.getBlockFilesystem(), mountFlags, mountOptions)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	logger.Debugf("Mounted block storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	return ourMount, nil
+}
+
+func (s *storageBlock) StoragePoolVolumeUmount() (bool, error) {
+	logger.Debugf("Unmounting block storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+
+	customPoolVolumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
+	ourUmount, err := storagePoolVolumeUmountRef(s.pool.Name, s.volume.Name, func() error {
+		return tryUnmount(customPoolVolumeMntPoint, 0)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	logger.Debugf("Unmounted block storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	return ourUmount, nil
+}
+
+// The device of the volume is kept in its config, only its mountpoint is
+// named after it.
+func (s *storageBlock) StoragePoolVolumeRename(newName string) error {
+	logger.Infof("Renaming block storage volume \"%s\" on storage pool \"%s\" to \"%s\".", s.volume.Name, s.pool.Name, newName)
+
+	oldPoolVolumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
+	newPoolVolumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, newName)
+	if shared.IsMountPoint(oldPoolVolumeMntPoint) {
+		err := tryUnmount(oldPoolVolumeMntPoint, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := os.Rename(oldPoolVolumeMntPoint, newPoolVolumeMntPoint)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Renamed block storage volume \"%s\" on storage pool \"%s\" to \"%s\".", s.volume.Name, s.pool.Name, newName)
+	return nil
+}
+
+func (s *storageBlock) StoragePoolVolumeGetState() (*api.StorageVolumeState, error) {
+	return nil, fmt.Errorf("the block storage driver doesn't report the usage of storage volumes")
+}
+
+func (s *storageBlock) StoragePoolVolumeCopy(sourceName string, flatten bool) error {
+	return storagePoolVolumeCopyRsync(s.d, s, s.pool.Name, sourceName, s.volume.Name)
+}
+
+func (s *storageBlock) StoragePoolVolumeUpdate(writable *api.StorageVolumePut, changedConfig []string) error {
+	for _, key := range changedConfig {
+		if key != "block.mount_options" {
+			return fmt.Errorf("The \"%s\" property of block storage volumes can't be changed", key)
+		}
+	}
+
+	return nil
+}
+
+func (s *storageBlock) StoragePoolVolumeSnapshotCreate(snapshotName string) error {
+	return fmt.Errorf("block storage volumes don't support snapshots")
+}
+
+func (s *storageBlock) StoragePoolVolumeSnapshotDelete(snapshotName string) error {
+	return fmt.Errorf("block storage volumes don't support snapshots")
+}
+
+func (s *storageBlock) StoragePoolVolumeSnapshotRename(snapshotName string, newName string) error {
+	return fmt.Errorf("block storage volumes don't support snapshots")
+}
+
+func (s *storageBlock) StoragePoolVolumeSnapshotRestore(snapshotName string) error {
+	return fmt.Errorf("block storage volumes don't support snapshots")
+}
+
+func (s *storageBlock) ContainerStorageReady(name string) bool {
+	return s.volume.Config["block.device"] != ""
+}
+
+// blockContainerPrepare gives a device to a new container and mounts it on
+// the mountpoint of the container.
+func (s *storageBlock) blockContainerPrepare(c container) (bool, error) {
+	_, err := s.blockVolumeClaim(storagePoolVolumeTypeContainer)
+	if err != nil {
+		return false, err
+	}
+
+	containerMntPoint := getContainerMountPoint(s.pool.Name, c.Name())
+	err = createContainerMountpoint(containerMntPoint, c.Path(), c.IsPrivileged())
+	if err != nil {
+		return false, err
+	}
+
+	return s.ContainerMount(c)
+}
+
+func (s *storageBlock) ContainerCreate(container container) error {
+	logger.Debugf("Creating empty block storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+
+	revert := true
+	defer func() {
+		if !revert {
+			return
+		}
+		s.ContainerDelete(container)
+	}()
+
+	ourMount, err := s.blockContainerPrepare(container)
+	if err != nil {
+		return err
+	}
+	if ourMount {
+		defer s.ContainerUmount(container.Name(), container.Path())
+	}
+
+	err = container.TemplateApply("create")
+	if err != nil {
+		return err
+	}
+
+	revert = false
+
+	logger.Debugf("Created empty block storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	return nil
+}
+
+func (s *storageBlock) ContainerCreateFromImage(container container, imageFingerprint string) error {
+	logger.Debugf("Creating block storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+
+	revert := true
+	defer func() {
+		if !revert {
+			return
+		}
+		s.ContainerDelete(container)
+	}()
+
+	ourMount, err := s.blockContainerPrepare(container)
+	if err != nil {
+		return err
+	}
+	if ourMount {
+		defer s.ContainerUmount(container.Name(), container.Path())
+	}
+
+	containerMntPoint := getContainerMountPoint(s.pool.Name, container.Name())
+	imagePath := shared.VarPath("images", imageFingerprint)
+	err = unpackImage(s.d, imagePath, containerMntPoint, storageTypeBlock)
+	if err != nil {
+		return err
+	}
+
+	if !container.IsPrivileged() {
+		err := s.shiftRootfs(container)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = container.TemplateApply("create")
+	if err != nil {
+		return err
+	}
+
+	revert = false
+
+	logger.Debugf("Created block storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	return nil
+}
+
+func (s *storageBlock) ContainerDelete(container container) error {
+	logger.Debugf("Deleting block storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+
+	_, err := s.ContainerUmount(container.Name(), container.Path())
+	if err != nil {
+		return err
+	}
+
+	// The device is given back to the pool along with the database entry
+	// of the volume.
+	device := s.volume.Config["block.device"]
+	if device != "" {
+		err := blockDeviceWipe(device)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Only the mountpoint and the snapshots are left.
+	err = s.storageDir.ContainerDelete(container)
+	if err != nil {
+		return err
+	}
+
+	logger.Debugf("Deleted block storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	return nil
+}
+
+func (s *storageBlock) ContainerCopy(target container, source container, containerOnly bool) error {
+	logger.Debugf("Copying block container storage %s -> %s.", source.Name(), target.Name())
+
+	_, sourcePool := source.Storage().GetContainerPoolInfo()
+	if sourcePool != s.pool.Name {
+		return fmt.Errorf("Copying containers between different storage pools isn't supported by the block storage driver")
+	}
+
+	revert := true
+	defer func() {
+		if !revert {
+			return
+		}
+		s.ContainerDelete(target)
+	}()
+
+	ourMount, err := s.blockContainerPrepare(target)
+	if err != nil {
+		return err
+	}
+	if ourMount {
+		defer s.ContainerUmount(target.Name(), target.Path())
+	}
+
+	err = s.storageDir.ContainerCopy(target, source, containerOnly)
+	if err != nil {
+		return err
+	}
+
+	revert = false
+
+	logger.Debugf("Copied block container storage %s -> %s.", source.Name(), target.Name())
+	return nil
+}
+
+func (s *storageBlock) ContainerRefresh(target container, source container, base container, snapshots []container) error {
+	return fmt.Errorf("Refreshing containers isn't supported by the block storage driver")
+}
+
+func (s *storageBlock) ContainerRebuild(container container, imageFingerprint string) error {
+	return fmt.Errorf("Rebuilding containers isn't supported by the block storage driver")
+}
+
+func (s *storageBlock) ScratchVolumeCreate(container container, device string, size int64) (string, error) {
+	return "", fmt.Errorf("Scratch volumes aren't supported by the block storage driver")
+}
+
+func (s *storageBlock) ScratchVolumeDelete(container container, device string) error {
+	return fmt.Errorf("Scratch volumes aren't supported by the block storage driver")
+}
+
+func (s *storageBlock) ContainerMount(c container) (bool, error) {
+	name := c.Name()
+	if shared.IsSnapshot(name) {
+		return true, nil
+	}
+
+	logger.Debugf("Mounting block storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+
+	device := s.volume.Config["block.device"]
+	if device == "" {
+		return false, fmt.Errorf("Container \"%s\" has no block device", name)
+	}
+
+	containerMntPoint := getContainerMountPoint(s.pool.Name, name)
+	unlock, joined := storageLocks.join(storageContainerLockKey(s.pool.Name, name), storageOpMount)
+	if joined {
+		// Give the benefit of the doubt and assume that the other
+		// thread actually succeeded in mounting the storage volume.
+		return false, nil
+	}
+
+	var mounterr error
+	ourMount := false
+	if !shared.IsMountPoint(containerMntPoint) {
+		mountFlags, mountOptions := lxdResolveMountoptions(s.getBlockMountOptions())
+		mounterr = tryMount(device, containerMntPoint, s.getBlockFilesystem(), mountFlags, mountOptions)
+		ourMount = true
+	}
+
+	unlock()
+
+	if mounterr != nil {
+		return false, mounterr
+	}
+
+	logger.Debugf("Mounted block storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	return ourMount, nil
+}
+
+func (s *storageBlock) ContainerUmount(name string, path string) (bool, error) {
+	if shared.IsSnapshot(name) {
+		return true, nil
+	}
+
+	logger.Debugf("Unmounting block storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+
+	containerMntPoint := getContainerMountPoint(s.pool.Name, name)
+	unlock, joined := storageLocks.join(storageContainerLockKey(s.pool.Name, name), storageOpUmount)
+	if joined {
+		// Give the benefit of the doubt and assume that the other
+		// thread actually succeeded in unmounting the storage volume.
+		return false, nil
+	}
+
+	var imgerr error
+	ourUmount := false
+	if shared.IsMountPoint(containerMntPoint) {
+		imgerr = tryUnmount(containerMntPoint, 0)
+		ourUmount = true
+	}
+
+	unlock()
+
+	if imgerr != nil {
+		return false, imgerr
+	}
+
+	logger.Debugf("Unmounted block storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	return ourUmount, nil
+}
+
+func (s *storageBlock) ContainerRename(container container, newName string) error {
+	// The device follows the database entry of the volume, only the
+	// mountpoint needs to move.
+	_, err := s.ContainerUmount(container.Name(), container.Path())
+	if err != nil {
+		return err
+	}
+
+	return s.storageDir.ContainerRename(container, newName)
+}
+
+func (s *storageBlock) ContainerRestore(container container, sourceContainer container) error {
+	ourMount, err := s.ContainerMount(container)
+	if err != nil {
+		return err
+	}
+	if ourMount {
+		defer s.ContainerUmount(container.Name(), container.Path())
+	}
+
+	return s.storageDir.ContainerRestore(container, sourceContainer)
+}
+
+func (s *storageBlock) ContainerSetQuota(container container, size int64) error {
+	return fmt.Errorf("the block container backend doesn't support quotas, the size of a container is the one of its device")
+}
+
+func (s *storageBlock) ContainerGetUsage(container container) (int64, error) {
+	ourMount, err := s.ContainerMount(container)
+	if err != nil {
+		return -1, err
+	}
+	if ourMount {
+		defer s.ContainerUmount(container.Name(), container.Path())
+	}
+
+	fs := syscall.Statfs_t{}
+	err = syscall.Statfs(getContainerMountPoint(s.pool.Name, container.Name()), &fs)
+	if err != nil {
+		return -1, err
+	}
+
+	return int64((fs.Blocks - fs.Bfree) * uint64(fs.Bsize)), nil
+}
+
+func (s *storageBlock) ContainerBackupCreate(backup backupArgs, sourceContainer container, path string) error {
+	return fmt.Errorf("Optimized backups aren't supported by the block storage driver")
+}
+
+func (s *storageBlock) ContainerBackupLoad(info backupInfo, path string) error {
+	return s.containerBackupLoad(info, path)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBlockISCSIPath(t *testing.T) {
+	path := blockISCSIPath("192.0.2.10", "iqn.2017-10.org.example:lxd")
+	if path != "/dev/disk/by-path/ip-192.0.2.10:3260-iscsi-iqn.2017-10.org.example:lxd" {
+		t.Errorf("Unexpected path for the default port: %s", path)
+	}
+
+	path = blockISCSIPath("192.0.2.10:3261", "iqn.2017-10.org.example:lxd")
+	if path != "/dev/disk/by-path/ip-192.0.2.10:3261-iscsi-iqn.2017-10.org.example:lxd" {
+		t.Errorf("Unexpected path for a given port: %s", path)
+	}
+}
+
+func TestBlockPoolDevices(t *testing.T) {
+	devices, err := blockPoolDevices(map[string]string{"block.devices": "/dev/sdb, /dev/sdc,"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(devices, []string{"/dev/sdb", "/dev/sdc"}) {
+		t.Errorf("Unexpected devices: %v", devices)
+	}
+}
+
+func TestBlockDeviceFree(t *testing.T) {
+	devices := []string{"/dev/sdb", "/dev/sdc", "/dev/sdd", "/dev/sde"}
+	hasData := func(device string) bool {
+		return device == "/dev/sdc"
+	}
+
+	device, err := blockDeviceFree(devices, []string{"/dev/sdb"}, hasData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if device != "/dev/sdd" {
+		t.Errorf("Expected /dev/sdd, got %s", device)
+	}
+
+	_, err = blockDeviceFree(devices, []string{"/dev/sdb", "/dev/sdd", "/dev/sde"}, hasData)
+	if err == nil {
+		t.Errorf("Expected an error when no device is free")
+	}
+}
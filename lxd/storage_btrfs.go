@@ -331,30 +331,13 @@ func (s *storageBtrfs) StoragePoolMount() (bool, error) {
 
 	poolMntPoint := getStoragePoolMountPoint(s.pool.Name)
 
-	poolMountLockID := getPoolMountLockID(s.pool.Name)
-	lxdStorageMapLock.Lock()
-	if waitChannel, ok := lxdStorageOngoingOperationMap[poolMountLockID]; ok {
-		lxdStorageMapLock.Unlock()
-		if _, ok := <-waitChannel; ok {
-			logger.Warnf("Received value over semaphore. This should not have happened.")
-		}
+	unlock, joined := storageLocks.join(storagePoolLockKey(s.pool.Name), storageOpMount)
+	if joined {
 		// Give the benefit of the doubt and assume that the other
 		// thread actually succeeded in mounting the storage pool.
 		return false, nil
 	}
-
-	lxdStorageOngoingOperationMap[poolMountLockID] = make(chan bool)
-	lxdStorageMapLock.Unlock()
-
-	removeLockFromMap := func() {
-		lxdStorageMapLock.Lock()
-		if waitChannel, ok := lxdStorageOngoingOperationMap[poolMountLockID]; ok {
-			close(waitChannel)
-			delete(lxdStorageOngoingOperationMap, poolMountLockID)
-		}
-		lxdStorageMapLock.Unlock()
-	}
-	defer removeLockFromMap()
+	defer unlock()
 
 	// Check whether the mount poolMntPoint exits.
 	if !shared.PathExists(poolMntPoint) {
@@ -429,31 +412,13 @@ func (s *storageBtrfs) StoragePoolUmount() (bool, error) {
 
 	poolMntPoint := getStoragePoolMountPoint(s.pool.Name)
 
-	poolUmountLockID := getPoolUmountLockID(s.pool.Name)
-	lxdStorageMapLock.Lock()
-	if waitChannel, ok := lxdStorageOngoingOperationMap[poolUmountLockID]; ok {
-		lxdStorageMapLock.Unlock()
-		if _, ok := <-waitChannel; ok {
-			logger.Warnf("Received value over semaphore. This should not have happened.")
-		}
+	unlock, joined := storageLocks.join(storagePoolLockKey(s.pool.Name), storageOpUmount)
+	if joined {
 		// Give the benefit of the doubt and assume that the other
 		// thread actually succeeded in unmounting the storage pool.
 		return false, nil
 	}
-
-	lxdStorageOngoingOperationMap[poolUmountLockID] = make(chan bool)
-	lxdStorageMapLock.Unlock()
-
-	removeLockFromMap := func() {
-		lxdStorageMapLock.Lock()
-		if waitChannel, ok := lxdStorageOngoingOperationMap[poolUmountLockID]; ok {
-			close(waitChannel)
-			delete(lxdStorageOngoingOperationMap, poolUmountLockID)
-		}
-		lxdStorageMapLock.Unlock()
-	}
-
-	defer removeLockFromMap()
+	defer unlock()
 
 	if shared.IsMountPoint(poolMntPoint) {
 		err := syscall.Unmount(poolMntPoint, 0)
@@ -469,7 +434,7 @@ func (s *storageBtrfs) StoragePoolUmount() (bool, error) {
 func (s *storageBtrfs) StoragePoolUpdate(writable *api.StoragePoolPut, changedConfig []string) error {
 	logger.Infof("Updating BTRFS storage pool \"%s\".", s.pool.Name)
 
-	// rsync.bwlimit does not require any on-disk changes
+	// The rsync.* keys do not require any on-disk changes
 
 	if shared.StringInSlice("btrfs.mount_options", changedConfig) {
 		s.setBtrfsMountOptions(writable.Config["btrfs.mount_options"])
@@ -492,6 +457,10 @@ func (s *storageBtrfs) SetStoragePoolWritable(writable *api.StoragePoolPut) {
 	s.pool.StoragePoolPut = *writable
 }
 
+func (s *storageBtrfs) StoragePoolVerify(report *storageVerifyReport, containers []container) error {
+	return nil
+}
+
 func (s *storageBtrfs) GetContainerPoolInfo() (int64, string) {
 	return s.poolID, s.pool.Name
 }
@@ -569,10 +538,80 @@ func (s *storageBtrfs) StoragePoolVolumeUmount() (bool, error) {
 	return true, nil
 }
 
-func (s *storageBtrfs) StoragePoolVolumeUpdate(changedConfig []string) error {
+func (s *storageBtrfs) StoragePoolVolumeRename(newName string) error {
+	logger.Infof("Renaming BTRFS storage volume \"%s\" on storage pool \"%s\" to \"%s\".", s.volume.Name, s.pool.Name, newName)
+
+	// The storage pool must be mounted.
+	_, err := s.StoragePoolMount()
+	if err != nil {
+		return err
+	}
+
+	// Subvolumes are renamed like directories.
+	oldSubvolumeName := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
+	newSubvolumeName := getStoragePoolVolumeMountPoint(s.pool.Name, newName)
+	err = os.Rename(oldSubvolumeName, newSubvolumeName)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Renamed BTRFS storage volume \"%s\" on storage pool \"%s\" to \"%s\".", s.volume.Name, s.pool.Name, newName)
+	return nil
+}
+
+func (s *storageBtrfs) StoragePoolVolumeGetState() (*api.StorageVolumeState, error) {
+	return nil, fmt.Errorf("the BTRFS storage driver doesn't report the usage of storage volumes")
+}
+
+func (s *storageBtrfs) StoragePoolVolumeCopy(sourceName string, flatten bool) error {
+	logger.Infof("Copying BTRFS storage volume \"%s\" to \"%s\" on storage pool \"%s\".", sourceName, s.volume.Name, s.pool.Name)
+
+	_, err := s.StoragePoolMount()
+	if err != nil {
+		return err
+	}
+
+	customSubvolumePath := s.getCustomSubvolumePath(s.pool.Name)
+	if !shared.PathExists(customSubvolumePath) {
+		err := os.MkdirAll(customSubvolumePath, 0700)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Snapshots are independent subvolumes sharing their extents with the
+	// source, there's nothing to flatten.
+	source := getStoragePoolVolumeMountPoint(s.pool.Name, sourceName)
+	target := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
+	err = s.btrfsPoolVolumesSnapshot(source, target, false)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Copied BTRFS storage volume \"%s\" to \"%s\" on storage pool \"%s\".", sourceName, s.volume.Name, s.pool.Name)
+	return nil
+}
+
+func (s *storageBtrfs) StoragePoolVolumeUpdate(writable *api.StorageVolumePut, changedConfig []string) error {
 	return fmt.Errorf("BTRFS storage properties cannot be changed")
 }
 
+func (s *storageBtrfs) StoragePoolVolumeSnapshotCreate(snapshotName string) error {
+	return fmt.Errorf("BTRFS storage volumes don't support snapshots")
+}
+
+func (s *storageBtrfs) StoragePoolVolumeSnapshotDelete(snapshotName string) error {
+	return fmt.Errorf("BTRFS storage volumes don't support snapshots")
+}
+
+func (s *storageBtrfs) StoragePoolVolumeSnapshotRename(snapshotName string, newName string) error {
+	return fmt.Errorf("BTRFS storage volumes don't support snapshots")
+}
+
+func (s *storageBtrfs) StoragePoolVolumeSnapshotRestore(snapshotName string) error {
+	return fmt.Errorf("BTRFS storage volumes don't support snapshots")
+}
+
 func (s *storageBtrfs) GetStoragePoolVolumeWritable() api.StorageVolumePut {
 	return s.volume.Writable()
 }
@@ -658,28 +697,14 @@ func (s *storageBtrfs) ContainerCreateFromImage(container container, fingerprint
 	// Mountpoint of the image:
 	// ${LXD_DIR}/images/<fingerprint>
 	imageMntPoint := getImageMountPoint(s.pool.Name, fingerprint)
-	imageStoragePoolLockID := getImageCreateLockID(s.pool.Name, fingerprint)
-	lxdStorageMapLock.Lock()
-	if waitChannel, ok := lxdStorageOngoingOperationMap[imageStoragePoolLockID]; ok {
-		lxdStorageMapLock.Unlock()
-		if _, ok := <-waitChannel; ok {
-			logger.Warnf("Received value over semaphore. This should not have happened.")
-		}
-	} else {
-		lxdStorageOngoingOperationMap[imageStoragePoolLockID] = make(chan bool)
-		lxdStorageMapLock.Unlock()
-
+	unlock, joined := storageLocks.join(storageImageLockKey(s.pool.Name, fingerprint), storageOpCreate)
+	if !joined {
 		var imgerr error
 		if !shared.PathExists(imageMntPoint) || !isBtrfsSubVolume(imageMntPoint) {
 			imgerr = s.ImageCreate(fingerprint)
 		}
 
-		lxdStorageMapLock.Lock()
-		if waitChannel, ok := lxdStorageOngoingOperationMap[imageStoragePoolLockID]; ok {
-			close(waitChannel)
-			delete(lxdStorageOngoingOperationMap, imageStoragePoolLockID)
-		}
-		lxdStorageMapLock.Unlock()
+		unlock()
 
 		if imgerr != nil {
 			return imgerr
@@ -854,7 +879,7 @@ func (s *storageBtrfs) ContainerCopy(target container, source container, contain
 	_, sourcePool := source.Storage().GetContainerPoolInfo()
 	_, targetPool := target.Storage().GetContainerPoolInfo()
 	if sourcePool != targetPool {
-		return fmt.Errorf("copying containers between different storage pools is not implemented")
+		return storageContainerCopyAcrossPools(s, target, source, containerOnly)
 	}
 
 	err = s.copyContainer(target, source)
@@ -900,6 +925,22 @@ func (s *storageBtrfs) ContainerCopy(target container, source container, contain
 	return nil
 }
 
+func (s *storageBtrfs) ContainerRefresh(target container, source container, base container, snapshots []container) error {
+	return fmt.Errorf("Refreshing containers isn't supported by the btrfs storage driver")
+}
+
+func (s *storageBtrfs) ContainerRebuild(container container, imageFingerprint string) error {
+	return fmt.Errorf("Rebuilding containers isn't supported by the btrfs storage driver")
+}
+
+func (s *storageBtrfs) ScratchVolumeCreate(container container, device string, size int64) (string, error) {
+	return "", fmt.Errorf("Scratch volumes aren't supported by the btrfs storage driver")
+}
+
+func (s *storageBtrfs) ScratchVolumeDelete(container container, device string) error {
+	return fmt.Errorf("Scratch volumes aren't supported by the btrfs storage driver")
+}
+
 func (s *storageBtrfs) ContainerMount(c container) (bool, error) {
 	logger.Debugf("Mounting BTRFS storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
 
@@ -1021,7 +1062,7 @@ func (s *storageBtrfs) ContainerRestore(container container, sourceContainer con
 			// Use rsync to fill the empty volume.  Sync by using
 			// the subvolume name.
 			bwlimit := s.pool.Config["rsync.bwlimit"]
-			output, err := rsyncLocalCopy(sourceContainerSubvolumeName, targetContainerSubvolumeName, bwlimit)
+			output, err := rsyncLocalCopy(sourceContainerSubvolumeName, targetContainerSubvolumeName, bwlimit, rsyncPoolLocalArgs(s.pool.Config)...)
 			if err != nil {
 				s.ContainerDelete(container)
 				logger.Errorf("ContainerRestore: rsync failed: %s.", string(output))
@@ -1285,6 +1326,14 @@ func (s *storageBtrfs) ContainerSnapshotCreateEmpty(snapshotContainer container)
 	return nil
 }
 
+func (s *storageBtrfs) ContainerBackupCreate(backup backupArgs, sourceContainer container, path string) error {
+	return fmt.Errorf("Optimized backups aren't supported by the btrfs storage driver")
+}
+
+func (s *storageBtrfs) ContainerBackupLoad(info backupInfo, path string) error {
+	return s.containerBackupLoad(info, path)
+}
+
 func (s *storageBtrfs) ImageCreate(fingerprint string) error {
 	logger.Debugf("Creating BTRFS storage volume for image \"%s\" on storage pool \"%s\".", fingerprint, s.pool.Name)
 
@@ -1923,14 +1972,14 @@ func (s *storageBtrfs) MigrationSource(c container, containerOnly bool) (Migrati
 	return driver, nil
 }
 
-func (s *storageBtrfs) MigrationSink(live bool, container container, snapshots []*Snapshot, conn *websocket.Conn, srcIdmap *shared.IdmapSet, op *operation, containerOnly bool) error {
+func (s *storageBtrfs) MigrationSink(conn *websocket.Conn, op *operation, args MigrationSinkArgs) error {
 	if runningInUserns {
-		return rsyncMigrationSink(live, container, snapshots, conn, srcIdmap, op, containerOnly)
+		return rsyncMigrationSink(conn, op, args)
 	}
 
 	btrfsRecv := func(snapName string, btrfsPath string, targetPath string, isSnapshot bool, writeWrapper func(io.WriteCloser) io.WriteCloser) error {
-		args := []string{"receive", "-e", btrfsPath}
-		cmd := exec.Command("btrfs", args...)
+		recvArgs := []string{"receive", "-e", btrfsPath}
+		cmd := exec.Command("btrfs", recvArgs...)
 
 		// Remove the existing pre-created subvolume
 		err := btrfsSubVolumesDelete(targetPath)
@@ -1999,10 +2048,24 @@ func (s *storageBtrfs) MigrationSink(live bool, container container, snapshots [
 		return nil
 	}
 
-	containerName := container.Name()
-	_, containerPool := container.Storage().GetContainerPoolInfo()
+	// Undo whatever got received if the migration fails halfway, newest
+	// first, so that it can be retried.
+	revert := true
+	undo := []func(){}
+	defer func() {
+		if !revert {
+			return
+		}
+
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}()
+
+	containerName := args.Container.Name()
+	_, containerPool := args.Container.Storage().GetContainerPoolInfo()
 	containersPath := getSnapshotMountPoint(containerPool, containerName)
-	if !containerOnly && len(snapshots) > 0 {
+	if !args.ContainerOnly && len(args.Snapshots) > 0 {
 		err := os.MkdirAll(containersPath, 0700)
 		if err != nil {
 			return err
@@ -2015,6 +2078,7 @@ func (s *storageBtrfs) MigrationSink(live bool, container container, snapshots [
 			if err != nil {
 				return err
 			}
+			undo = append(undo, func() { os.Remove(snapshotMntPointSymlink) })
 		}
 	}
 
@@ -2022,7 +2086,7 @@ func (s *storageBtrfs) MigrationSink(live bool, container container, snapshots [
 	// container's root disk device so we can simply
 	// retrieve it from the expanded devices.
 	parentStoragePool := ""
-	parentExpandedDevices := container.ExpandedDevices()
+	parentExpandedDevices := args.Container.ExpandedDevices()
 	parentLocalRootDiskDeviceKey, parentLocalRootDiskDevice, _ := containerGetRootDiskDevice(parentExpandedDevices)
 	if parentLocalRootDiskDeviceKey != "" {
 		parentStoragePool = parentLocalRootDiskDevice["pool"]
@@ -2033,27 +2097,28 @@ func (s *storageBtrfs) MigrationSink(live bool, container container, snapshots [
 		return fmt.Errorf("detected that the container's root device is missing the pool property during BTRFS migration")
 	}
 
-	if !containerOnly {
-		for _, snap := range snapshots {
-			args := snapshotProtobufToContainerArgs(containerName, snap)
+	if !args.ContainerOnly {
+		for _, snap := range args.Snapshots {
+			snapArgs := snapshotProtobufToContainerArgs(containerName, snap)
 
 			// Ensure that snapshot and parent container have the
 			// same storage pool in their local root disk device.
 			// If the root disk device for the snapshot comes from a
 			// profile on the new instance as well we don't need to
 			// do anything.
-			if args.Devices != nil {
-				snapLocalRootDiskDeviceKey, _, _ := containerGetRootDiskDevice(args.Devices)
+			if snapArgs.Devices != nil {
+				snapLocalRootDiskDeviceKey, _, _ := containerGetRootDiskDevice(snapArgs.Devices)
 				if snapLocalRootDiskDeviceKey != "" {
-					args.Devices[snapLocalRootDiskDeviceKey]["pool"] = parentStoragePool
+					snapArgs.Devices[snapLocalRootDiskDeviceKey]["pool"] = parentStoragePool
 				}
 			}
 
-			snapshotMntPoint := getSnapshotMountPoint(containerPool, args.Name)
-			_, err := containerCreateEmptySnapshot(container.Daemon(), args)
+			snapshotMntPoint := getSnapshotMountPoint(containerPool, snapArgs.Name)
+			cs, err := containerCreateEmptySnapshot(args.Container.Daemon(), snapArgs)
 			if err != nil {
 				return err
 			}
+			undo = append(undo, migrationSinkSnapshotDelete(cs))
 
 			snapshotMntPointSymlinkTarget := shared.VarPath("storage-pools", s.pool.Name, "snapshots", containerName)
 			snapshotMntPointSymlink := shared.VarPath("snapshots", containerName)
@@ -2082,7 +2147,7 @@ func (s *storageBtrfs) MigrationSink(live bool, container container, snapshots [
 	}
 
 	containersMntPoint := getContainerMountPoint(s.pool.Name, "")
-	err := createContainerMountpoint(containersMntPoint, container.Path(), container.IsPrivileged())
+	err := createContainerMountpoint(containersMntPoint, args.Container.Path(), args.Container.IsPrivileged())
 	if err != nil {
 		return err
 	}
@@ -2106,6 +2171,8 @@ func (s *storageBtrfs) MigrationSink(live bool, container container, snapshots [
 		return err
 	}
 
+	revert = false
+
 	return nil
 }
 
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// storageBwlimitParse converts a bandwidth limit in the format of rsync's
+// --bwlimit, which is in KiB/s unless a unit is given (e.g. "512", "1.5M" or
+// "10MB"), to bytes per second. Zero means no limit.
+func storageBwlimitParse(bwlimit string) (int64, error) {
+	value := strings.TrimSpace(bwlimit)
+	if value == "" {
+		return 0, nil
+	}
+
+	unit := strings.TrimLeft(value, "0123456789.")
+	number := value[:len(value)-len(unit)]
+
+	var multiplier float64
+	switch strings.ToLower(unit) {
+	case "b":
+		multiplier = 1
+	case "", "k", "kib":
+		multiplier = 1024
+	case "kb":
+		multiplier = 1000
+	case "m", "mib":
+		multiplier = 1024 * 1024
+	case "mb":
+		multiplier = 1000 * 1000
+	case "g", "gib":
+		multiplier = 1024 * 1024 * 1024
+	case "gb":
+		multiplier = 1000 * 1000 * 1000
+	default:
+		return -1, fmt.Errorf("Invalid bandwidth limit: %s", bwlimit)
+	}
+
+	rate, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return -1, fmt.Errorf("Invalid bandwidth limit: %s", bwlimit)
+	}
+
+	return int64(rate * multiplier), nil
+}
+
+// storageRateLimitedReader throttles reads to rate bytes per second on
+// average since the first read.
+type storageRateLimitedReader struct {
+	io.ReadCloser
+	rate int64
+
+	start time.Time
+	read  int64
+}
+
+func (r *storageRateLimitedReader) Read(p []byte) (int, error) {
+	if r.start.IsZero() {
+		r.start = time.Now()
+	}
+
+	// Don't read more than a tenth of a second worth of data at once so
+	// that the stream doesn't come in bursts.
+	max := r.rate / 10
+	if max < 1 {
+		max = 1
+	}
+
+	if int64(len(p)) > max {
+		p = p[:max]
+	}
+
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+
+	expected := time.Duration(float64(r.read) / float64(r.rate) * float64(time.Second))
+	elapsed := time.Since(r.start)
+	if expected > elapsed {
+		time.Sleep(expected - elapsed)
+	}
+
+	return n, err
+}
+
+// storageRateLimitReader throttles the reader to rate bytes per second, if
+// the rate is set.
+func storageRateLimitReader(reader io.ReadCloser, rate int64) io.ReadCloser {
+	if rate <= 0 {
+		return reader
+	}
+
+	return &storageRateLimitedReader{ReadCloser: reader, rate: rate}
+}
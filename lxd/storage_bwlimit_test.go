@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestStorageBwlimitParse(t *testing.T) {
+	tests := map[string]int64{
+		"":     0,
+		"0":    0,
+		"100":  100 * 1024,
+		"1.5M": 1536 * 1024,
+		"10MB": 10 * 1000 * 1000,
+		"512b": 512,
+	}
+
+	for bwlimit, expected := range tests {
+		rate, err := storageBwlimitParse(bwlimit)
+		if err != nil {
+			t.Errorf("Failed to parse '%s': %v", bwlimit, err)
+			continue
+		}
+
+		if rate != expected {
+			t.Errorf("Expected '%s' to be %d bytes/s, got %d", bwlimit, expected, rate)
+		}
+	}
+
+	_, err := storageBwlimitParse("fast")
+	if err == nil {
+		t.Error("Expected an invalid limit to fail")
+	}
+}
@@ -44,10 +44,20 @@ func (s *storageDir) StoragePoolInit() error {
 // Initialize a full storage interface.
 func (s *storageDir) StoragePoolCheck() error {
 	logger.Debugf("Checking DIR storage pool \"%s\".", s.pool.Name)
+
+	if s.isNFS() {
+		_, err := s.nfsMount()
+		return err
+	}
+
 	return nil
 }
 
 func (s *storageDir) StoragePoolCreate() error {
+	if s.isNFS() {
+		return s.nfsPoolCreate()
+	}
+
 	logger.Infof("Creating DIR storage pool \"%s\".", s.pool.Name)
 
 	source := s.pool.Config["source"]
@@ -99,6 +109,10 @@ func (s *storageDir) StoragePoolCreate() error {
 }
 
 func (s *storageDir) StoragePoolDelete() error {
+	if s.isNFS() {
+		return s.nfsPoolDelete()
+	}
+
 	logger.Infof("Deleting DIR storage pool \"%s\".", s.pool.Name)
 
 	source := s.pool.Config["source"]
@@ -130,11 +144,38 @@ func (s *storageDir) StoragePoolDelete() error {
 	return nil
 }
 
+func (s *storageDir) StoragePoolDeletePlan(plan *api.OperationPlan) error {
+	source := s.pool.Config["source"]
+	if source == "" {
+		return fmt.Errorf("no \"source\" property found for the storage pool")
+	}
+
+	if shared.PathExists(source) {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("Remove the directory \"%s\" and all its content", source))
+	}
+
+	prefix := shared.VarPath("storage-pools")
+	storagePoolSymlink := getStoragePoolMountPoint(s.pool.Name)
+	if !strings.HasPrefix(source, prefix) && shared.PathExists(storagePoolSymlink) {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("Remove the symlink \"%s\"", storagePoolSymlink))
+	}
+
+	return nil
+}
+
 func (s *storageDir) StoragePoolMount() (bool, error) {
+	if s.isNFS() {
+		return s.nfsMount()
+	}
+
 	return true, nil
 }
 
 func (s *storageDir) StoragePoolUmount() (bool, error) {
+	if s.isNFS() {
+		return s.nfsUmount()
+	}
+
 	return true, nil
 }
 
@@ -150,6 +191,10 @@ func (s *storageDir) SetStoragePoolWritable(writable *api.StoragePoolPut) {
 	s.pool.StoragePoolPut = *writable
 }
 
+func (s *storageDir) StoragePoolVerify(report *storageVerifyReport, containers []container) error {
+	return nil
+}
+
 func (s *storageDir) SetStoragePoolVolumeWritable(writable *api.StorageVolumePut) {
 	s.volume.StorageVolumePut = *writable
 }
@@ -159,8 +204,10 @@ func (s *storageDir) GetContainerPoolInfo() (int64, string) {
 }
 
 func (s *storageDir) StoragePoolUpdate(writable *api.StoragePoolPut, changedConfig []string) error {
-	if shared.StringInSlice("rsync.bwlimit", changedConfig) {
-		return nil
+	for _, key := range storagePoolRsyncKeys {
+		if shared.StringInSlice(key, changedConfig) {
+			return nil
+		}
 	}
 
 	return fmt.Errorf("storage property cannot be changed")
@@ -215,10 +262,48 @@ func (s *storageDir) StoragePoolVolumeUmount() (bool, error) {
 	return true, nil
 }
 
-func (s *storageDir) StoragePoolVolumeUpdate(changedConfig []string) error {
+func (s *storageDir) StoragePoolVolumeRename(newName string) error {
+	logger.Infof("Renaming DIR storage volume \"%s\" on storage pool \"%s\" to \"%s\".", s.volume.Name, s.pool.Name, newName)
+
+	oldPath := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
+	newPath := getStoragePoolVolumeMountPoint(s.pool.Name, newName)
+	err := os.Rename(oldPath, newPath)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Renamed DIR storage volume \"%s\" on storage pool \"%s\" to \"%s\".", s.volume.Name, s.pool.Name, newName)
+	return nil
+}
+
+func (s *storageDir) StoragePoolVolumeGetState() (*api.StorageVolumeState, error) {
+	return nil, fmt.Errorf("the directory storage driver doesn't report the usage of storage volumes")
+}
+
+func (s *storageDir) StoragePoolVolumeCopy(sourceName string, flatten bool) error {
+	return storagePoolVolumeCopyRsync(s.d, s, s.pool.Name, sourceName, s.volume.Name)
+}
+
+func (s *storageDir) StoragePoolVolumeUpdate(writable *api.StorageVolumePut, changedConfig []string) error {
 	return fmt.Errorf("dir storage properties cannot be changed")
 }
 
+func (s *storageDir) StoragePoolVolumeSnapshotCreate(snapshotName string) error {
+	return fmt.Errorf("dir storage volumes don't support snapshots")
+}
+
+func (s *storageDir) StoragePoolVolumeSnapshotDelete(snapshotName string) error {
+	return fmt.Errorf("dir storage volumes don't support snapshots")
+}
+
+func (s *storageDir) StoragePoolVolumeSnapshotRename(snapshotName string, newName string) error {
+	return fmt.Errorf("dir storage volumes don't support snapshots")
+}
+
+func (s *storageDir) StoragePoolVolumeSnapshotRestore(snapshotName string) error {
+	return fmt.Errorf("dir storage volumes don't support snapshots")
+}
+
 func (s *storageDir) ContainerStorageReady(name string) bool {
 	containerMntPoint := getContainerMountPoint(s.pool.Name, name)
 	ok, _ := shared.PathIsEmpty(containerMntPoint)
@@ -266,6 +351,13 @@ func (s *storageDir) ContainerCreateFromImage(container container, imageFingerpr
 	}
 
 	privileged := container.IsPrivileged()
+	if !privileged {
+		err := storageShiftCheck(s)
+		if err != nil {
+			return err
+		}
+	}
+
 	containerName := container.Name()
 	containerMntPoint := getContainerMountPoint(s.pool.Name, containerName)
 	err := createContainerMountpoint(containerMntPoint, container.Path(), privileged)
@@ -372,7 +464,7 @@ func (s *storageDir) copyContainer(target container, source container) error {
 	}
 
 	bwlimit := s.pool.Config["rsync.bwlimit"]
-	output, err := rsyncLocalCopy(sourceContainerMntPoint, targetContainerMntPoint, bwlimit)
+	output, err := rsyncLocalCopy(sourceContainerMntPoint, targetContainerMntPoint, bwlimit, rsyncPoolLocalArgs(s.pool.Config)...)
 	if err != nil {
 		return fmt.Errorf("failed to rsync container: %s: %s", string(output), err)
 	}
@@ -406,7 +498,7 @@ func (s *storageDir) copySnapshot(target container, source container) error {
 	}
 
 	bwlimit := s.pool.Config["rsync.bwlimit"]
-	output, err := rsyncLocalCopy(sourceContainerMntPoint, targetContainerMntPoint, bwlimit)
+	output, err := rsyncLocalCopy(sourceContainerMntPoint, targetContainerMntPoint, bwlimit, rsyncPoolLocalArgs(s.pool.Config)...)
 	if err != nil {
 		return fmt.Errorf("failed to rsync container: %s: %s", string(output), err)
 	}
@@ -428,7 +520,7 @@ func (s *storageDir) ContainerCopy(target container, source container, container
 	_, sourcePool := source.Storage().GetContainerPoolInfo()
 	_, targetPool := target.Storage().GetContainerPoolInfo()
 	if sourcePool != targetPool {
-		return fmt.Errorf("copying containers between different storage pools is not implemented")
+		return storageContainerCopyAcrossPools(s, target, source, containerOnly)
 	}
 
 	err = s.copyContainer(target, source)
@@ -474,7 +566,31 @@ func (s *storageDir) ContainerCopy(target container, source container, container
 	return nil
 }
 
+func (s *storageDir) ContainerRefresh(target container, source container, base container, snapshots []container) error {
+	return fmt.Errorf("Refreshing containers isn't supported by the dir storage driver")
+}
+
+func (s *storageDir) ContainerRebuild(container container, imageFingerprint string) error {
+	return fmt.Errorf("Rebuilding containers isn't supported by the dir storage driver")
+}
+
+func (s *storageDir) ScratchVolumeCreate(container container, device string, size int64) (string, error) {
+	return "", fmt.Errorf("Scratch volumes aren't supported by the dir storage driver")
+}
+
+func (s *storageDir) ScratchVolumeDelete(container container, device string) error {
+	return fmt.Errorf("Scratch volumes aren't supported by the dir storage driver")
+}
+
 func (s *storageDir) ContainerMount(c container) (bool, error) {
+	// Catch stale NFS handles before the container gets to use them.
+	if s.isNFS() {
+		_, err := s.nfsMount()
+		if err != nil {
+			return false, err
+		}
+	}
+
 	return true, nil
 }
 
@@ -540,7 +656,7 @@ func (s *storageDir) ContainerRestore(container container, sourceContainer conta
 
 	// Restore using rsync
 	bwlimit := s.pool.Config["rsync.bwlimit"]
-	output, err := rsyncLocalCopy(sourcePath, targetPath, bwlimit)
+	output, err := rsyncLocalCopy(sourcePath, targetPath, bwlimit, rsyncPoolLocalArgs(s.pool.Config)...)
 	if err != nil {
 		return fmt.Errorf("failed to rsync container: %s: %s", string(output), err)
 	}
@@ -574,7 +690,7 @@ func (s *storageDir) ContainerSnapshotCreate(snapshotContainer container, source
 	}
 
 	rsync := func(snapshotContainer container, oldPath string, newPath string, bwlimit string) error {
-		output, err := rsyncLocalCopy(oldPath, newPath, bwlimit)
+		output, err := rsyncLocalCopy(oldPath, newPath, bwlimit, rsyncPoolLocalArgs(s.pool.Config)...)
 		if err != nil {
 			s.ContainerDelete(snapshotContainer)
 			return fmt.Errorf("failed to rsync: %s: %s", string(output), err)
@@ -741,6 +857,14 @@ func (s *storageDir) ContainerSnapshotStop(container container) (bool, error) {
 	return true, nil
 }
 
+func (s *storageDir) ContainerBackupCreate(backup backupArgs, sourceContainer container, path string) error {
+	return fmt.Errorf("Optimized backups aren't supported by the dir storage driver")
+}
+
+func (s *storageDir) ContainerBackupLoad(info backupInfo, path string) error {
+	return s.containerBackupLoad(info, path)
+}
+
 func (s *storageDir) ImageCreate(fingerprint string) error {
 	return nil
 }
@@ -774,6 +898,6 @@ func (s *storageDir) MigrationSource(container container, containerOnly bool) (M
 	return rsyncMigrationSource(container, containerOnly)
 }
 
-func (s *storageDir) MigrationSink(live bool, container container, snapshots []*Snapshot, conn *websocket.Conn, srcIdmap *shared.IdmapSet, op *operation, containerOnly bool) error {
-	return rsyncMigrationSink(live, container, snapshots, conn, srcIdmap, op, containerOnly)
+func (s *storageDir) MigrationSink(conn *websocket.Conn, op *operation, args MigrationSinkArgs) error {
+	return rsyncMigrationSink(conn, op, args)
 }
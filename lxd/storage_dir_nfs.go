@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/logger"
+)
+
+// NFS backed DIR storage pools have an NFS export as their "source" (e.g.
+// "nfs-server:/srv/lxd"), which LXD mounts on the storage pool's mount point
+// itself instead of symlinking a local directory there.
+
+// storageDirNFSMountLock serializes the mounts and unmounts of the NFS
+// exports, so that two operations don't mount the same one on top of each
+// other.
+var storageDirNFSMountLock sync.Mutex
+
+// storageDirIsNFSSource returns whether the source of a DIR storage pool is
+// an NFS export rather than a local path.
+func storageDirIsNFSSource(source string) bool {
+	return !filepath.IsAbs(source) && strings.Contains(source, ":/")
+}
+
+func (s *storageDir) isNFS() bool {
+	return storageDirIsNFSSource(s.pool.Config["source"])
+}
+
+// storagePoolIsNFS returns whether a storage pool is an NFS backed DIR one.
+func storagePoolIsNFS(s storage) bool {
+	return s.GetStorageType() == storageTypeDir && storageDirIsNFSSource(s.GetStoragePoolWritable().Config["source"])
+}
+
+// storageShiftCheck refuses shifting the ownership of the files of a
+// container or storage volume on an NFS backed storage pool. Rewriting the
+// owner of every file over the network takes ages and fails outright on
+// exports squashing root.
+func storageShiftCheck(s storage) error {
+	if !storagePoolIsNFS(s) {
+		return nil
+	}
+
+	_, poolName := s.GetContainerPoolInfo()
+	return fmt.Errorf("Shifting the ownership of files isn't supported on the NFS backed storage pool \"%s\", only privileged containers can use it", poolName)
+}
+
+// storageDirNFSIsStale returns whether the NFS mount at path lost its handle
+// on the server, e.g. as the export was recreated.
+func storageDirNFSIsStale(path string) bool {
+	st := syscall.Stat_t{}
+	return syscall.Stat(path, &st) == syscall.ESTALE
+}
+
+func (s *storageDir) nfsPoolCreate() error {
+	logger.Infof("Creating NFS backed DIR storage pool \"%s\".", s.pool.Name)
+
+	_, err := s.nfsMount()
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Created NFS backed DIR storage pool \"%s\".", s.pool.Name)
+	return nil
+}
+
+// nfsPoolDelete unmounts the NFS export, leaving its content alone as it may
+// well be shared with other hosts.
+func (s *storageDir) nfsPoolDelete() error {
+	logger.Infof("Deleting NFS backed DIR storage pool \"%s\".", s.pool.Name)
+
+	poolMntPoint := getStoragePoolMountPoint(s.pool.Name)
+
+	// Only the directories LXD created, if empty, go.
+	for _, dir := range []string{"containers", "snapshots", "images", "custom"} {
+		os.Remove(filepath.Join(poolMntPoint, dir))
+	}
+
+	_, err := s.nfsUmount()
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(poolMntPoint)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	logger.Infof("Deleted NFS backed DIR storage pool \"%s\".", s.pool.Name)
+	return nil
+}
+
+// nfsMount mounts the NFS export of the storage pool unless it already is,
+// mounting it again if its handle went stale.
+func (s *storageDir) nfsMount() (bool, error) {
+	source := s.pool.Config["source"]
+	poolMntPoint := getStoragePoolMountPoint(s.pool.Name)
+
+	storageDirNFSMountLock.Lock()
+	defer storageDirNFSMountLock.Unlock()
+
+	if storageDirNFSIsStale(poolMntPoint) {
+		logger.Warnf("Stale NFS handle on storage pool \"%s\", mounting \"%s\" again.", s.pool.Name, source)
+		err := tryUnmount(poolMntPoint, syscall.MNT_DETACH)
+		if err != nil {
+			return false, err
+		}
+	} else if shared.IsMountPoint(poolMntPoint) {
+		return false, nil
+	}
+
+	if !shared.PathExists(poolMntPoint) {
+		err := os.MkdirAll(poolMntPoint, 0711)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	args := []string{"-t", "nfs"}
+	if s.pool.Config["dir.mount_options"] != "" {
+		args = append(args, "-o", s.pool.Config["dir.mount_options"])
+	}
+	args = append(args, source, poolMntPoint)
+
+	output, err := shared.RunCommand("mount", args...)
+	if err != nil {
+		return false, fmt.Errorf("Failed to mount the NFS export \"%s\": %s", source, strings.TrimSpace(output))
+	}
+
+	return true, nil
+}
+
+func (s *storageDir) nfsUmount() (bool, error) {
+	poolMntPoint := getStoragePoolMountPoint(s.pool.Name)
+
+	storageDirNFSMountLock.Lock()
+	defer storageDirNFSMountLock.Unlock()
+
+	// A stale mount can't be unmounted cleanly anymore.
+	flags := 0
+	if storageDirNFSIsStale(poolMntPoint) {
+		flags = syscall.MNT_DETACH
+	} else if !shared.IsMountPoint(poolMntPoint) {
+		return false, nil
+	}
+
+	err := tryUnmount(poolMntPoint, flags)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
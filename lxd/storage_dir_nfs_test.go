@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestStorageDirIsNFSSource(t *testing.T) {
+	nfs := []string{"nfs-server:/srv/lxd", "10.0.0.2:/export", "[fd00::2]:/export"}
+	for _, source := range nfs {
+		if !storageDirIsNFSSource(source) {
+			t.Errorf("Expected \"%s\" to be an NFS export", source)
+		}
+	}
+
+	local := []string{"", "/data/lxd", "/var/lib/lxd/storage-pools/default", "/srv/a:/b", "relative/path"}
+	for _, source := range local {
+		if storageDirIsNFSSource(source) {
+			t.Errorf("Expected \"%s\" not to be an NFS export", source)
+		}
+	}
+}
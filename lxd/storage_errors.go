@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// StorageErrBusy happens when a storage resource is in use, for example
+	// a dataset which can't be destroyed right after being unmounted.
+	StorageErrBusy = fmt.Errorf("The storage resource is busy")
+
+	// StorageErrNotFound happens when a storage resource doesn't exist.
+	StorageErrNotFound = fmt.Errorf("The storage resource doesn't exist")
+
+	// StorageErrQuotaExceeded happens when a storage volume is over its
+	// quota.
+	StorageErrQuotaExceeded = fmt.Errorf("The storage volume quota is exceeded")
+
+	// StorageErrPoolFull happens when a storage pool is out of space.
+	StorageErrPoolFull = fmt.Errorf("The storage pool is full")
+)
+
+// storageError is a failure of a storage driver of a known kind, one of the
+// StorageErr errors, along with the message describing it.
+type storageError struct {
+	kind error
+	msg  string
+}
+
+func (e storageError) Error() string {
+	return e.msg
+}
+
+// storageErrorKind returns which of the StorageErr errors err, or any error
+// it wraps, is, nil if it's none of them.
+func storageErrorKind(err error) error {
+	for _, cause := range errorCauses(err) {
+		e, ok := cause.(storageError)
+		if ok {
+			return e.kind
+		}
+
+		switch cause {
+		case StorageErrBusy, StorageErrNotFound, StorageErrQuotaExceeded, StorageErrPoolFull:
+			return cause
+		}
+	}
+
+	return nil
+}
+
+// storageErrorResponse maps the kind of a storage error to an HTTP status
+// code, returning nil for other errors.
+func storageErrorResponse(err error) Response {
+	switch storageErrorKind(err) {
+	case StorageErrBusy:
+		return &errorResponse{http.StatusConflict, err.Error()}
+	case StorageErrNotFound:
+		return &errorResponse{http.StatusNotFound, err.Error()}
+	case StorageErrQuotaExceeded, StorageErrPoolFull:
+		return &errorResponse{http.StatusRequestEntityTooLarge, err.Error()}
+	}
+
+	return nil
+}
+
+// zfsErrorKind tells which of the StorageErr errors the output of a failed
+// zfs or zpool command on dataset is about, nil if none. Being busy or
+// missing only counts for the dataset itself, not for another one like a
+// device of a new pool.
+func zfsErrorKind(dataset string, output string) error {
+	output = strings.ToLower(output)
+
+	// The lines of the output naming the dataset, zfs quoting it.
+	target := ""
+	for _, line := range strings.Split(output, "\n") {
+		if strings.Contains(line, strings.ToLower(fmt.Sprintf("'%s'", dataset))) {
+			target += line + "\n"
+		}
+	}
+
+	switch {
+	case strings.Contains(target, "busy"):
+		return StorageErrBusy
+	case strings.Contains(target, "does not exist"), strings.Contains(target, "no such pool"):
+		return StorageErrNotFound
+	case strings.Contains(output, "quota exceeded"):
+		return StorageErrQuotaExceeded
+	case strings.Contains(output, "out of space"), strings.Contains(output, "no space left on device"):
+		return StorageErrPoolFull
+	}
+
+	return nil
+}
+
+// zfsError returns the error of a failed zfs or zpool command on dataset, of
+// the kind of StorageErr error its output tells.
+func zfsError(message string, dataset string, output string) error {
+	msg := fmt.Sprintf("%s: %s", message, output)
+
+	kind := zfsErrorKind(dataset, output)
+	if kind == nil {
+		return fmt.Errorf("%s", msg)
+	}
+
+	return storageError{kind: kind, msg: msg}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestZfsErrorKind(t *testing.T) {
+	outputs := map[string]error{
+		"cannot destroy 'tank/containers/c1': dataset is busy":                      StorageErrBusy,
+		"cannot open 'tank/containers/c1': dataset does not exist":                  StorageErrNotFound,
+		"cannot open 'tank/containers/c1@snap0': dataset does not exist":            nil,
+		"cannot receive new filesystem stream: out of space":                        StorageErrPoolFull,
+		"cannot create 'tank/containers/c1': disk quota exceeded":                   StorageErrQuotaExceeded,
+		"cannot create 'tank/containers/c1': invalid character '!' in name":         nil,
+		"umount: /var/lib/lxd/storage-pools/default/containers/c1: target is busy.": nil,
+	}
+
+	for output, kind := range outputs {
+		if zfsErrorKind("tank/containers/c1", output) != kind {
+			t.Errorf("Expected %v for %q, got %v", kind, output, zfsErrorKind("tank/containers/c1", output))
+		}
+	}
+
+	// Only the pool being created may be missing, not its devices.
+	if zfsErrorKind("tank", "cannot open 'tank': no such pool") != StorageErrNotFound {
+		t.Error("Expected a missing pool to be reported")
+	}
+
+	if zfsErrorKind("tank", "cannot open '/dev/sdz': does not exist") != nil {
+		t.Error("Expected a missing device not to be reported as a missing pool")
+	}
+}
+
+func TestZfsError(t *testing.T) {
+	err := zfsError("Failed to destroy ZFS filesystem", "tank/containers/c1", "cannot destroy 'tank/containers/c1': dataset is busy")
+	if err.Error() != "Failed to destroy ZFS filesystem: cannot destroy 'tank/containers/c1': dataset is busy" {
+		t.Errorf("Unexpected message: %s", err)
+	}
+
+	if storageErrorKind(err) != StorageErrBusy {
+		t.Errorf("Expected a busy error, got %v", storageErrorKind(err))
+	}
+
+	wrapped := errorWrapf(err, "Failed to delete the container: %s", err)
+	if storageErrorKind(wrapped) != StorageErrBusy {
+		t.Errorf("Expected a wrapped busy error, got %v", storageErrorKind(wrapped))
+	}
+
+	err = zfsError("Failed to set ZFS config", "tank/containers/c1", "bad property value")
+	if storageErrorKind(err) != nil {
+		t.Errorf("Expected an error of no kind, got %v", storageErrorKind(err))
+	}
+
+	if storageErrorKind(fmt.Errorf("dataset is busy")) != nil {
+		t.Error("Expected plain errors to be of no kind")
+	}
+}
+
+func TestStorageErrorResponse(t *testing.T) {
+	codes := map[error]int{
+		StorageErrBusy:          http.StatusConflict,
+		StorageErrNotFound:      http.StatusNotFound,
+		StorageErrQuotaExceeded: http.StatusRequestEntityTooLarge,
+		StorageErrPoolFull:      http.StatusRequestEntityTooLarge,
+	}
+
+	for kind, code := range codes {
+		err := errorWrapf(storageError{kind: kind, msg: "failed"}, "Failed to create the volume: failed")
+		resp, ok := SmartError(err).(*errorResponse)
+		if !ok {
+			t.Fatalf("Expected an error response for %v", kind)
+		}
+
+		if resp.code != code || resp.msg != "Failed to create the volume: failed" {
+			t.Errorf("Expected %d for %v, got %d (%s)", code, kind, resp.code, resp.msg)
+		}
+	}
+}
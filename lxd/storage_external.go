@@ -0,0 +1,889 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/logger"
+)
+
+// The external driver hands the storage volumes of a pool over to an
+// executable out of the LXD tree, named by "external.driver" and installed
+// in ${LXD_DIR}/storage-drivers. LXD calls it with the action to carry out as
+// its only argument and a storageExternalRequest on stdin, and builds
+// containers, snapshots and copies on top of those actions. Containers are
+// filled, copied and migrated with rsync. See doc/storage-drivers.md.
+type storageExternal struct {
+	storageShared
+
+	driverPath string
+}
+
+// storageExternalVersion is the version of the protocol between LXD and the
+// external drivers.
+const storageExternalVersion = "1"
+
+// storageExternalUnsupported is the exit status of the external drivers for
+// actions they don't implement.
+const storageExternalUnsupported = 3
+
+type storageExternalPool struct {
+	Name   string            `json:"name"`
+	Config map[string]string `json:"config"`
+}
+
+type storageExternalVolume struct {
+	Name   string            `json:"name"`
+	Type   string            `json:"type"`
+	Config map[string]string `json:"config"`
+}
+
+// storageExternalRequest is what the external drivers get on stdin.
+type storageExternalRequest struct {
+	Version string                 `json:"version"`
+	Pool    storageExternalPool    `json:"pool"`
+	Volume  *storageExternalVolume `json:"volume,omitempty"`
+
+	// The new name of the volume for "volume_rename", the name of the
+	// snapshot for "volume_snapshot" and "volume_restore".
+	Target string `json:"target,omitempty"`
+
+	// Where to mount or unmount the volume for "volume_mount" and
+	// "volume_umount".
+	Path string `json:"path,omitempty"`
+
+	// The size in bytes for "volume_set_quota".
+	Size int64 `json:"size,omitempty"`
+
+	// The changed config keys for "pool_update" and "volume_update".
+	Changed []string `json:"changed,omitempty"`
+}
+
+// storageExternalResponse is what the external drivers may print on stdout.
+type storageExternalResponse struct {
+	Usage int64 `json:"usage"`
+}
+
+// storageExternalDriverPath returns the path of an external driver.
+func storageExternalDriverPath(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("No external storage driver set in \"external.driver\"")
+	}
+
+	if strings.Contains(name, "/") || name == "." || name == ".." {
+		return "", fmt.Errorf("Invalid external storage driver name: %s", name)
+	}
+
+	path := shared.VarPath("storage-drivers", name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("External storage driver \"%s\" isn't installed in %s", name, shared.VarPath("storage-drivers"))
+	}
+
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return "", fmt.Errorf("External storage driver \"%s\" isn't executable", name)
+	}
+
+	return path, nil
+}
+
+// storageExternalRun runs an action of an external driver and decodes what
+// it printed, if anything, into resp.
+func storageExternalRun(driverPath string, action string, req storageExternalRequest, resp interface{}) error {
+	req.Version = storageExternalVersion
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(driverPath, action)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if ok {
+			status, ok := exitErr.Sys().(syscall.WaitStatus)
+			if ok && status.ExitStatus() == storageExternalUnsupported {
+				return fmt.Errorf("The \"%s\" storage driver doesn't support \"%s\"", filepath.Base(driverPath), action)
+			}
+		}
+
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+
+		return fmt.Errorf("The \"%s\" storage driver failed to run \"%s\": %s", filepath.Base(driverPath), action, msg)
+	}
+
+	if resp == nil || len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return nil
+	}
+
+	err = json.Unmarshal(stdout.Bytes(), resp)
+	if err != nil {
+		return fmt.Errorf("The \"%s\" storage driver printed an invalid response to \"%s\": %s", filepath.Base(driverPath), action, err)
+	}
+
+	return nil
+}
+
+// Only initialize the minimal information we need about a given storage type.
+func (s *storageExternal) StorageCoreInit() error {
+	s.sType = storageTypeExternal
+	typeName, err := storageTypeToString(s.sType)
+	if err != nil {
+		return err
+	}
+	s.sTypeName = typeName
+	s.sTypeVersion = storageExternalVersion
+
+	logger.Debugf("Initializing an external driver.")
+	return nil
+}
+
+// Initialize a full storage interface.
+func (s *storageExternal) StoragePoolInit() error {
+	err := s.StorageCoreInit()
+	if err != nil {
+		return err
+	}
+
+	s.driverPath, err = storageExternalDriverPath(s.pool.Config["external.driver"])
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// run runs an action of the driver on the pool.
+func (s *storageExternal) run(action string, req storageExternalRequest, resp interface{}) error {
+	req.Pool = storageExternalPool{Name: s.pool.Name, Config: s.pool.Config}
+	return storageExternalRun(s.driverPath, action, req, resp)
+}
+
+// runVolume runs an action of the driver on a volume, the one the driver was
+// initialized for under the given name.
+func (s *storageExternal) runVolume(action string, volumeType string, volumeName string, req storageExternalRequest, resp interface{}) error {
+	req.Volume = &storageExternalVolume{Name: volumeName, Type: volumeType, Config: s.volume.Config}
+	return s.run(action, req, resp)
+}
+
+// The driver is asked to bring the pool up when LXD starts.
+func (s *storageExternal) StoragePoolCheck() error {
+	logger.Debugf("Checking external storage pool \"%s\".", s.pool.Name)
+	return s.run("pool_mount", storageExternalRequest{}, nil)
+}
+
+func (s *storageExternal) StoragePoolCreate() error {
+	logger.Infof("Creating external storage pool \"%s\".", s.pool.Name)
+
+	poolMntPoint := getStoragePoolMountPoint(s.pool.Name)
+	err := os.MkdirAll(poolMntPoint, 0711)
+	if err != nil {
+		return err
+	}
+
+	err = s.run("pool_create", storageExternalRequest{}, nil)
+	if err != nil {
+		os.Remove(poolMntPoint)
+		return err
+	}
+
+	logger.Infof("Created external storage pool \"%s\".", s.pool.Name)
+	return nil
+}
+
+func (s *storageExternal) StoragePoolDelete() error {
+	logger.Infof("Deleting external storage pool \"%s\".", s.pool.Name)
+
+	err := s.run("pool_delete", storageExternalRequest{}, nil)
+	if err != nil {
+		return err
+	}
+
+	err = os.RemoveAll(getStoragePoolMountPoint(s.pool.Name))
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Deleted external storage pool \"%s\".", s.pool.Name)
+	return nil
+}
+
+// The driver can't tell whether the pool was mounted already, so it's never
+// reported as mounted by the caller, which would unmount it when done.
+func (s *storageExternal) StoragePoolMount() (bool, error) {
+	err := s.run("pool_mount", storageExternalRequest{}, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return false, nil
+}
+
+func (s *storageExternal) StoragePoolUmount() (bool, error) {
+	err := s.run("pool_umount", storageExternalRequest{}, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *storageExternal) StoragePoolUpdate(writable *api.StoragePoolPut, changedConfig []string) error {
+	if shared.StringInSlice("external.driver", changedConfig) {
+		return fmt.Errorf("The driver of external storage pools can't be changed")
+	}
+
+	req := storageExternalRequest{Changed: changedConfig}
+	req.Pool = storageExternalPool{Name: s.pool.Name, Config: writable.Config}
+	return storageExternalRun(s.driverPath, "pool_update", req, nil)
+}
+
+func (s *storageExternal) GetStoragePoolWritable() api.StoragePoolPut {
+	return s.pool.Writable()
+}
+
+func (s *storageExternal) SetStoragePoolWritable(writable *api.StoragePoolPut) {
+	s.pool.StoragePoolPut = *writable
+}
+
+func (s *storageExternal) StoragePoolVerify(report *storageVerifyReport, containers []container) error {
+	return nil
+}
+
+func (s *storageExternal) GetContainerPoolInfo() (int64, string) {
+	return s.poolID, s.pool.Name
+}
+
+// Functions dealing with storage volumes.
+func (s *storageExternal) StoragePoolVolumeCreate() error {
+	logger.Infof("Creating external storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+
+	err := s.runVolume("volume_create", storagePoolVolumeTypeNameCustom, s.volume.Name, storageExternalRequest{}, nil)
+	if err != nil {
+		return err
+	}
+
+	customPoolVolumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
+	err = os.MkdirAll(customPoolVolumeMntPoint, 0711)
+	if err != nil {
+		s.runVolume("volume_delete", storagePoolVolumeTypeNameCustom, s.volume.Name, storageExternalRequest{}, nil)
+		return err
+	}
+
+	logger.Infof("Created external storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	return nil
+}
+
+func (s *storageExternal) StoragePoolVolumeDelete() error {
+	logger.Infof("Deleting external storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+
+	customPoolVolumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
+	if shared.IsMountPoint(customPoolVolumeMntPoint) {
+		err := s.runVolume("volume_umount", storagePoolVolumeTypeNameCustom, s.volume.Name, storageExternalRequest{Path: customPoolVolumeMntPoint}, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := s.runVolume("volume_delete", storagePoolVolumeTypeNameCustom, s.volume.Name, storageExternalRequest{}, nil)
+	if err != nil {
+		return err
+	}
+
+	if shared.PathExists(customPoolVolumeMntPoint) {
+		err := os.Remove(customPoolVolumeMntPoint)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Infof("Deleted external storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	return nil
+}
+
+func (s *storageExternal) StoragePoolVolumeMount() (bool, error) {
+	customPoolVolumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
+	return storagePoolVolumeMountRef(s.pool.Name, s.volume.Name, func() error {
+		return s.runVolume("volume_mount", storagePoolVolumeTypeNameCustom, s.volume.Name, storageExternalRequest{Path: customPoolVolumeMntPoint}, nil)
+	})
+}
+
+func (s *storageExternal) StoragePoolVolumeUmount() (bool, error) {
+	customPoolVolumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
+	return storagePoolVolumeUmountRef(s.pool.Name, s.volume.Name, func() error {
+		return s.runVolume("volume_umount", storagePoolVolumeTypeNameCustom, s.volume.Name, storageExternalRequest{Path: customPoolVolumeMntPoint}, nil)
+	})
+}
+
+func (s *storageExternal) StoragePoolVolumeRename(newName string) error {
+	logger.Infof("Renaming external storage volume \"%s\" on storage pool \"%s\" to \"%s\".", s.volume.Name, s.pool.Name, newName)
+
+	oldPoolVolumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
+	newPoolVolumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, newName)
+	if shared.IsMountPoint(oldPoolVolumeMntPoint) {
+		err := s.runVolume("volume_umount", storagePoolVolumeTypeNameCustom, s.volume.Name, storageExternalRequest{Path: oldPoolVolumeMntPoint}, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The driver renames the snapshots of the volume along.
+	err := s.runVolume("volume_rename", storagePoolVolumeTypeNameCustom, s.volume.Name, storageExternalRequest{Target: newName}, nil)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(oldPoolVolumeMntPoint, newPoolVolumeMntPoint)
+	if err != nil {
+		s.runVolume("volume_rename", storagePoolVolumeTypeNameCustom, newName, storageExternalRequest{Target: s.volume.Name}, nil)
+		return err
+	}
+
+	logger.Infof("Renamed external storage volume \"%s\" on storage pool \"%s\" to \"%s\".", s.volume.Name, s.pool.Name, newName)
+	return nil
+}
+
+func (s *storageExternal) StoragePoolVolumeGetState() (*api.StorageVolumeState, error) {
+	return nil, fmt.Errorf("the external storage driver doesn't report the usage of storage volumes")
+}
+
+func (s *storageExternal) StoragePoolVolumeCopy(sourceName string, flatten bool) error {
+	return storagePoolVolumeCopyRsync(s.d, s, s.pool.Name, sourceName, s.volume.Name)
+}
+
+func (s *storageExternal) StoragePoolVolumeUpdate(writable *api.StorageVolumePut, changedConfig []string) error {
+	req := storageExternalRequest{Changed: changedConfig}
+	req.Volume = &storageExternalVolume{Name: s.volume.Name, Type: s.volume.Type, Config: writable.Config}
+	return s.run("volume_update", req, nil)
+}
+
+func (s *storageExternal) GetStoragePoolVolumeWritable() api.StorageVolumePut {
+	return s.volume.Writable()
+}
+
+func (s *storageExternal) SetStoragePoolVolumeWritable(writable *api.StorageVolumePut) {
+	s.volume.StorageVolumePut = *writable
+}
+
+func (s *storageExternal) StoragePoolVolumeSnapshotCreate(snapshotName string) error {
+	target := fmt.Sprintf("%s%s%s", s.volume.Name, shared.SnapshotDelimiter, snapshotName)
+	return s.runVolume("volume_snapshot", storagePoolVolumeTypeNameCustom, s.volume.Name, storageExternalRequest{Target: target}, nil)
+}
+
+func (s *storageExternal) StoragePoolVolumeSnapshotDelete(snapshotName string) error {
+	name := fmt.Sprintf("%s%s%s", s.volume.Name, shared.SnapshotDelimiter, snapshotName)
+	return s.runVolume("volume_delete", storagePoolVolumeTypeNameCustom, name, storageExternalRequest{}, nil)
+}
+
+func (s *storageExternal) StoragePoolVolumeSnapshotRename(snapshotName string, newName string) error {
+	name := fmt.Sprintf("%s%s%s", s.volume.Name, shared.SnapshotDelimiter, snapshotName)
+	target := fmt.Sprintf("%s%s%s", s.volume.Name, shared.SnapshotDelimiter, newName)
+	return s.runVolume("volume_rename", storagePoolVolumeTypeNameCustom, name, storageExternalRequest{Target: target}, nil)
+}
+
+func (s *storageExternal) StoragePoolVolumeSnapshotRestore(snapshotName string) error {
+	target := fmt.Sprintf("%s%s%s", s.volume.Name, shared.SnapshotDelimiter, snapshotName)
+	return s.runVolume("volume_restore", storagePoolVolumeTypeNameCustom, s.volume.Name, storageExternalRequest{Target: target}, nil)
+}
+
+func (s *storageExternal) ContainerStorageReady(name string) bool {
+	return true
+}
+
+// containerPrepare creates the volume of a new container and mounts it on the
+// mountpoint of the container.
+func (s *storageExternal) containerPrepare(c container) (bool, error) {
+	err := s.runVolume("volume_create", storagePoolVolumeTypeNameContainer, c.Name(), storageExternalRequest{}, nil)
+	if err != nil {
+		return false, err
+	}
+
+	containerMntPoint := getContainerMountPoint(s.pool.Name, c.Name())
+	err = createContainerMountpoint(containerMntPoint, c.Path(), c.IsPrivileged())
+	if err != nil {
+		return false, err
+	}
+
+	return s.ContainerMount(c)
+}
+
+func (s *storageExternal) ContainerCreate(container container) error {
+	logger.Debugf("Creating empty external storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+
+	revert := true
+	defer func() {
+		if !revert {
+			return
+		}
+		s.ContainerDelete(container)
+	}()
+
+	ourMount, err := s.containerPrepare(container)
+	if err != nil {
+		return err
+	}
+	if ourMount {
+		defer s.ContainerUmount(container.Name(), container.Path())
+	}
+
+	err = container.TemplateApply("create")
+	if err != nil {
+		return err
+	}
+
+	revert = false
+
+	logger.Debugf("Created empty external storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	return nil
+}
+
+func (s *storageExternal) ContainerCreateFromImage(container container, imageFingerprint string) error {
+	logger.Debugf("Creating external storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+
+	revert := true
+	defer func() {
+		if !revert {
+			return
+		}
+		s.ContainerDelete(container)
+	}()
+
+	ourMount, err := s.containerPrepare(container)
+	if err != nil {
+		return err
+	}
+	if ourMount {
+		defer s.ContainerUmount(container.Name(), container.Path())
+	}
+
+	containerMntPoint := getContainerMountPoint(s.pool.Name, container.Name())
+	imagePath := shared.VarPath("images", imageFingerprint)
+	err = unpackImage(s.d, imagePath, containerMntPoint, storageTypeExternal)
+	if err != nil {
+		return err
+	}
+
+	if !container.IsPrivileged() {
+		err := s.shiftRootfs(container)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = container.TemplateApply("create")
+	if err != nil {
+		return err
+	}
+
+	revert = false
+
+	logger.Debugf("Created external storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	return nil
+}
+
+func (s *storageExternal) ContainerCanRestore(container container, sourceContainer container) error {
+	return nil
+}
+
+func (s *storageExternal) ContainerDelete(container container) error {
+	logger.Debugf("Deleting external storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+
+	_, err := s.ContainerUmount(container.Name(), container.Path())
+	if err != nil {
+		return err
+	}
+
+	err = s.runVolume("volume_delete", storagePoolVolumeTypeNameContainer, container.Name(), storageExternalRequest{}, nil)
+	if err != nil {
+		return err
+	}
+
+	containerMntPoint := getContainerMountPoint(s.pool.Name, container.Name())
+	err = deleteContainerMountpoint(containerMntPoint, container.Path(), s.GetStorageTypeName())
+	if err != nil {
+		return err
+	}
+
+	// Delete potential leftover snapshot mountpoints.
+	snapshotMntPoint := getSnapshotMountPoint(s.pool.Name, container.Name())
+	if shared.PathExists(snapshotMntPoint) {
+		err := os.RemoveAll(snapshotMntPoint)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Delete potential leftover snapshot symlinks:
+	// ${LXD_DIR}/snapshots/<container_name> -> ${POOL}/snapshots/<container_name>
+	snapshotSymlink := shared.VarPath("snapshots", container.Name())
+	if shared.PathExists(snapshotSymlink) {
+		err := os.Remove(snapshotSymlink)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Debugf("Deleted external storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	return nil
+}
+
+// containerSync copies the content of a container or snapshot into the
+// mounted target container.
+func (s *storageExternal) containerSync(target container, source container) error {
+	ourStart, err := source.StorageStart()
+	if err != nil {
+		return err
+	}
+	if ourStart {
+		defer source.StorageStop()
+	}
+
+	bwlimit := s.pool.Config["rsync.bwlimit"]
+	output, err := rsyncLocalCopy(source.Path(), getContainerMountPoint(s.pool.Name, target.Name()), bwlimit, rsyncPoolLocalArgs(s.pool.Config)...)
+	if err != nil {
+		return fmt.Errorf("failed to rsync container: %s: %s", string(output), err)
+	}
+
+	return s.setUnprivUserACL(source, getContainerMountPoint(s.pool.Name, target.Name()))
+}
+
+func (s *storageExternal) ContainerCopy(target container, source container, containerOnly bool) error {
+	logger.Debugf("Copying external container storage %s -> %s.", source.Name(), target.Name())
+
+	_, sourcePool := source.Storage().GetContainerPoolInfo()
+	if sourcePool != s.pool.Name {
+		return fmt.Errorf("copying containers between different storage pools is not implemented")
+	}
+
+	revert := true
+	defer func() {
+		if !revert {
+			return
+		}
+		s.ContainerDelete(target)
+	}()
+
+	ourMount, err := s.containerPrepare(target)
+	if err != nil {
+		return err
+	}
+	if ourMount {
+		defer s.ContainerUmount(target.Name(), target.Path())
+	}
+
+	// The snapshots are synced into the new container and snapshotted in
+	// turn, oldest first, before the container itself.
+	if !containerOnly {
+		snapshots, err := source.Snapshots()
+		if err != nil {
+			return err
+		}
+
+		for _, snap := range snapshots {
+			_, snapOnlyName, _ := containerGetParentAndSnapshotName(snap.Name())
+			newSnapName := fmt.Sprintf("%s%s%s", target.Name(), shared.SnapshotDelimiter, snapOnlyName)
+			targetSnapshot, err := containerLoadByName(s.d, newSnapName)
+			if err != nil {
+				return err
+			}
+
+			err = s.containerSync(target, snap)
+			if err != nil {
+				return err
+			}
+
+			err = s.ContainerSnapshotCreate(targetSnapshot, target)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = s.containerSync(target, source)
+	if err != nil {
+		return err
+	}
+
+	err = target.TemplateApply("copy")
+	if err != nil {
+		return err
+	}
+
+	revert = false
+
+	logger.Debugf("Copied external container storage %s -> %s.", source.Name(), target.Name())
+	return nil
+}
+
+func (s *storageExternal) ContainerRefresh(target container, source container, base container, snapshots []container) error {
+	return fmt.Errorf("Refreshing containers isn't supported by the external storage driver")
+}
+
+func (s *storageExternal) ContainerRebuild(container container, imageFingerprint string) error {
+	return fmt.Errorf("Rebuilding containers isn't supported by the external storage driver")
+}
+
+func (s *storageExternal) ScratchVolumeCreate(container container, device string, size int64) (string, error) {
+	return "", fmt.Errorf("Scratch volumes aren't supported by the external storage driver")
+}
+
+func (s *storageExternal) ScratchVolumeDelete(container container, device string) error {
+	return fmt.Errorf("Scratch volumes aren't supported by the external storage driver")
+}
+
+func (s *storageExternal) ContainerMount(c container) (bool, error) {
+	name := c.Name()
+	containerMntPoint := getContainerMountPoint(s.pool.Name, name)
+	if shared.IsSnapshot(name) {
+		containerMntPoint = getSnapshotMountPoint(s.pool.Name, name)
+	}
+
+	if shared.IsMountPoint(containerMntPoint) {
+		return false, nil
+	}
+
+	err := s.runVolume("volume_mount", storagePoolVolumeTypeNameContainer, name, storageExternalRequest{Path: containerMntPoint}, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *storageExternal) ContainerUmount(name string, path string) (bool, error) {
+	containerMntPoint := getContainerMountPoint(s.pool.Name, name)
+	if shared.IsSnapshot(name) {
+		containerMntPoint = getSnapshotMountPoint(s.pool.Name, name)
+	}
+
+	if !shared.IsMountPoint(containerMntPoint) {
+		return false, nil
+	}
+
+	err := s.runVolume("volume_umount", storagePoolVolumeTypeNameContainer, name, storageExternalRequest{Path: containerMntPoint}, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *storageExternal) ContainerRename(container container, newName string) error {
+	logger.Debugf("Renaming external storage volume for container \"%s\" from %s -> %s.", s.volume.Name, s.volume.Name, newName)
+
+	_, err := s.ContainerUmount(container.Name(), container.Path())
+	if err != nil {
+		return err
+	}
+
+	// The driver renames the snapshots of the volume along with it.
+	err = s.runVolume("volume_rename", storagePoolVolumeTypeNameContainer, container.Name(), storageExternalRequest{Target: newName}, nil)
+	if err != nil {
+		return err
+	}
+
+	oldContainerMntPoint := getContainerMountPoint(s.pool.Name, container.Name())
+	oldContainerSymlink := shared.VarPath("containers", container.Name())
+	newContainerMntPoint := getContainerMountPoint(s.pool.Name, newName)
+	newContainerSymlink := shared.VarPath("containers", newName)
+	err = renameContainerMountpoint(oldContainerMntPoint, oldContainerSymlink, newContainerMntPoint, newContainerSymlink)
+	if err != nil {
+		return err
+	}
+
+	oldSnapshotsMntPoint := getSnapshotMountPoint(s.pool.Name, container.Name())
+	newSnapshotsMntPoint := getSnapshotMountPoint(s.pool.Name, newName)
+	if shared.PathExists(oldSnapshotsMntPoint) {
+		err = os.Rename(oldSnapshotsMntPoint, newSnapshotsMntPoint)
+		if err != nil {
+			return err
+		}
+	}
+
+	oldSnapshotSymlink := shared.VarPath("snapshots", container.Name())
+	newSnapshotSymlink := shared.VarPath("snapshots", newName)
+	if shared.PathExists(oldSnapshotSymlink) {
+		err := os.Remove(oldSnapshotSymlink)
+		if err != nil {
+			return err
+		}
+
+		err = os.Symlink(newSnapshotsMntPoint, newSnapshotSymlink)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Debugf("Renamed external storage volume for container \"%s\" from %s -> %s.", s.volume.Name, s.volume.Name, newName)
+	return nil
+}
+
+func (s *storageExternal) ContainerRestore(container container, sourceContainer container) error {
+	_, err := s.ContainerUmount(container.Name(), container.Path())
+	if err != nil {
+		return err
+	}
+
+	return s.runVolume("volume_restore", storagePoolVolumeTypeNameContainer, container.Name(), storageExternalRequest{Target: sourceContainer.Name()}, nil)
+}
+
+func (s *storageExternal) ContainerSetQuota(container container, size int64) error {
+	return s.runVolume("volume_set_quota", storagePoolVolumeTypeNameContainer, container.Name(), storageExternalRequest{Size: size}, nil)
+}
+
+func (s *storageExternal) ContainerGetUsage(container container) (int64, error) {
+	resp := storageExternalResponse{}
+	err := s.runVolume("volume_usage", storagePoolVolumeTypeNameContainer, container.Name(), storageExternalRequest{}, &resp)
+	if err != nil {
+		return -1, err
+	}
+
+	return resp.Usage, nil
+}
+
+// snapshotMountpointCreate creates the mountpoint of a snapshot and the
+// symlink to the snapshots of its container.
+func (s *storageExternal) snapshotMountpointCreate(snapshotName string) error {
+	sourceName, _, _ := containerGetParentAndSnapshotName(snapshotName)
+	snapshotMntPoint := getSnapshotMountPoint(s.pool.Name, snapshotName)
+	snapshotMntPointSymlinkTarget := shared.VarPath("storage-pools", s.pool.Name, "snapshots", sourceName)
+	snapshotMntPointSymlink := shared.VarPath("snapshots", sourceName)
+	return createSnapshotMountpoint(snapshotMntPoint, snapshotMntPointSymlinkTarget, snapshotMntPointSymlink)
+}
+
+func (s *storageExternal) ContainerSnapshotCreate(snapshotContainer container, sourceContainer container) error {
+	logger.Debugf("Creating external storage volume for snapshot \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+
+	err := s.runVolume("volume_snapshot", storagePoolVolumeTypeNameContainer, sourceContainer.Name(), storageExternalRequest{Target: snapshotContainer.Name()}, nil)
+	if err != nil {
+		return err
+	}
+
+	err = s.snapshotMountpointCreate(snapshotContainer.Name())
+	if err != nil {
+		return err
+	}
+
+	logger.Debugf("Created external storage volume for snapshot \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	return nil
+}
+
+func (s *storageExternal) ContainerSnapshotCreateEmpty(snapshotContainer container) error {
+	err := s.runVolume("volume_create", storagePoolVolumeTypeNameContainer, snapshotContainer.Name(), storageExternalRequest{}, nil)
+	if err != nil {
+		return err
+	}
+
+	return s.snapshotMountpointCreate(snapshotContainer.Name())
+}
+
+func (s *storageExternal) ContainerSnapshotDelete(snapshotContainer container) error {
+	logger.Debugf("Deleting external storage volume for snapshot \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+
+	_, err := s.ContainerUmount(snapshotContainer.Name(), snapshotContainer.Path())
+	if err != nil {
+		return err
+	}
+
+	err = s.runVolume("volume_delete", storagePoolVolumeTypeNameContainer, snapshotContainer.Name(), storageExternalRequest{}, nil)
+	if err != nil {
+		return err
+	}
+
+	sourceName, _, _ := containerGetParentAndSnapshotName(snapshotContainer.Name())
+	snapshotMntPoint := getSnapshotMountPoint(s.pool.Name, snapshotContainer.Name())
+	snapshotMntPointSymlinkTarget := shared.VarPath("storage-pools", s.pool.Name, "snapshots", sourceName)
+	snapshotMntPointSymlink := shared.VarPath("snapshots", sourceName)
+	err = deleteSnapshotMountpoint(snapshotMntPoint, snapshotMntPointSymlinkTarget, snapshotMntPointSymlink)
+	if err != nil {
+		return err
+	}
+
+	logger.Debugf("Deleted external storage volume for snapshot \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	return nil
+}
+
+func (s *storageExternal) ContainerSnapshotRename(snapshotContainer container, newName string) error {
+	_, err := s.ContainerUmount(snapshotContainer.Name(), snapshotContainer.Path())
+	if err != nil {
+		return err
+	}
+
+	err = s.runVolume("volume_rename", storagePoolVolumeTypeNameContainer, snapshotContainer.Name(), storageExternalRequest{Target: newName}, nil)
+	if err != nil {
+		return err
+	}
+
+	oldSnapshotMntPoint := getSnapshotMountPoint(s.pool.Name, snapshotContainer.Name())
+	newSnapshotMntPoint := getSnapshotMountPoint(s.pool.Name, newName)
+	return os.Rename(oldSnapshotMntPoint, newSnapshotMntPoint)
+}
+
+func (s *storageExternal) ContainerSnapshotStart(container container) (bool, error) {
+	return s.ContainerMount(container)
+}
+
+func (s *storageExternal) ContainerSnapshotStop(container container) (bool, error) {
+	return s.ContainerUmount(container.Name(), container.Path())
+}
+
+func (s *storageExternal) ContainerBackupCreate(backup backupArgs, sourceContainer container, path string) error {
+	return fmt.Errorf("Optimized backups aren't supported by the external storage driver")
+}
+
+func (s *storageExternal) ContainerBackupLoad(info backupInfo, path string) error {
+	return s.containerBackupLoad(info, path)
+}
+
+// Images are unpacked into every new container rather than kept on the pool.
+func (s *storageExternal) ImageCreate(fingerprint string) error {
+	return nil
+}
+
+func (s *storageExternal) ImageDelete(fingerprint string) error {
+	return s.deleteImageDbPoolVolume(fingerprint)
+}
+
+func (s *storageExternal) ImageMount(fingerprint string) (bool, error) {
+	return true, nil
+}
+
+func (s *storageExternal) ImageUmount(fingerprint string) (bool, error) {
+	return true, nil
+}
+
+func (s *storageExternal) MigrationType() MigrationFSType {
+	return MigrationFSType_RSYNC
+}
+
+func (s *storageExternal) PreservesInodes() bool {
+	return false
+}
+
+func (s *storageExternal) MigrationSource(container container, containerOnly bool) (MigrationStorageSourceDriver, error) {
+	return rsyncMigrationSource(container, containerOnly)
+}
+
+func (s *storageExternal) MigrationSink(conn *websocket.Conn, op *operation, args MigrationSinkArgs) error {
+	return rsyncMigrationSink(conn, op, args)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// storageExternalTestDriver writes a driver running the given shell script.
+func storageExternalTestDriver(t *testing.T, script string) (string, func()) {
+	dir, err := ioutil.TempDir("", "lxd-storage-external-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "test")
+	err = ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestStorageExternalRun(t *testing.T) {
+	driver, cleanup := storageExternalTestDriver(t, `
+[ "$1" = "volume_usage" ] || exit 1
+grep -q '"name":"c1"' || exit 1
+echo '{"usage": 1024}'
+`)
+	defer cleanup()
+
+	req := storageExternalRequest{Volume: &storageExternalVolume{Name: "c1", Type: "container"}}
+	resp := storageExternalResponse{}
+	err := storageExternalRun(driver, "volume_usage", req, &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Usage != 1024 {
+		t.Errorf("Unexpected usage: %d", resp.Usage)
+	}
+}
+
+func TestStorageExternalRun_Unsupported(t *testing.T) {
+	driver, cleanup := storageExternalTestDriver(t, "exit 3\n")
+	defer cleanup()
+
+	err := storageExternalRun(driver, "volume_snapshot", storageExternalRequest{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "doesn't support \"volume_snapshot\"") {
+		t.Errorf("Unexpected error for an unsupported action: %v", err)
+	}
+}
+
+func TestStorageExternalRun_Failure(t *testing.T) {
+	driver, cleanup := storageExternalTestDriver(t, "echo 'Export is gone' >&2\nexit 1\n")
+	defer cleanup()
+
+	err := storageExternalRun(driver, "pool_mount", storageExternalRequest{}, nil)
+	if err == nil || !strings.HasSuffix(err.Error(), ": Export is gone") {
+		t.Errorf("Unexpected error for a failed action: %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+/* The storage can be frozen while an external tool backs up the whole host.
+ * Snapshots, copies, restores, backups, migrations, moves between pools and
+ * deletions then wait for it to be thawed. Freezing waits for those already
+ * running to complete and syncs the storage pools so that the backup sees
+ * them in a consistent state. In case the backup tool goes away, the storage
+ * thaws by itself once the freeze times out.
+ */
+
+// storageFreezeDefaultTimeout is how long the storage stays frozen when no
+// timeout is given.
+const storageFreezeDefaultTimeout = 5 * time.Minute
+
+var storageFreezeLock sync.Mutex
+var storageFreezeCond = sync.NewCond(&storageFreezeLock)
+var storageFreezeUntil time.Time
+var storageFreezeTimer *time.Timer
+var storageFreezeRunning int
+
+// storageFreezeEnter waits for the storage to be thawed and registers a
+// running storage operation. It must be followed by storageFreezeLeave.
+func storageFreezeEnter() {
+	storageFreezeLock.Lock()
+	defer storageFreezeLock.Unlock()
+
+	for !storageFreezeUntil.IsZero() {
+		storageFreezeCond.Wait()
+	}
+
+	storageFreezeRunning++
+}
+
+// storageFreezeLeave unregisters a storage operation.
+func storageFreezeLeave() {
+	storageFreezeLock.Lock()
+	defer storageFreezeLock.Unlock()
+
+	storageFreezeRunning--
+	storageFreezeCond.Broadcast()
+}
+
+// storageFrozenUntil returns when the storage thaws, or the zero time if it
+// isn't frozen.
+func storageFrozenUntil() time.Time {
+	storageFreezeLock.Lock()
+	defer storageFreezeLock.Unlock()
+
+	return storageFreezeUntil
+}
+
+// storageFreeze freezes the storage for timeout, or extends the current
+// freeze, and syncs the storage pools.
+func storageFreeze(d *Daemon, timeout time.Duration) (time.Time, error) {
+	storageFreezeLock.Lock()
+
+	until := time.Now().Add(timeout)
+	storageFreezeUntil = until
+
+	if storageFreezeTimer != nil {
+		storageFreezeTimer.Stop()
+	}
+	storageFreezeTimer = time.AfterFunc(timeout, storageFreezeExpire)
+
+	// The timeout also applies to waiting for the running operations.
+	for storageFreezeRunning > 0 && !storageFreezeUntil.IsZero() {
+		storageFreezeCond.Wait()
+	}
+
+	if storageFreezeUntil.IsZero() {
+		storageFreezeLock.Unlock()
+		return time.Time{}, fmt.Errorf("The storage was thawed before the running storage operations completed")
+	}
+
+	storageFreezeLock.Unlock()
+
+	storageFreezeSync(d)
+
+	logger.Info("Froze the storage", log.Ctx{"until": until})
+	return until, nil
+}
+
+// storageThaw thaws the storage, letting the waiting operations proceed.
+func storageThaw() {
+	storageFreezeLock.Lock()
+	defer storageFreezeLock.Unlock()
+
+	storageThawLocked()
+}
+
+func storageThawLocked() {
+	if storageFreezeUntil.IsZero() {
+		return
+	}
+
+	storageFreezeUntil = time.Time{}
+
+	if storageFreezeTimer != nil {
+		storageFreezeTimer.Stop()
+		storageFreezeTimer = nil
+	}
+
+	storageFreezeCond.Broadcast()
+	logger.Info("Thawed the storage")
+}
+
+func storageFreezeExpire() {
+	storageFreezeLock.Lock()
+	defer storageFreezeLock.Unlock()
+
+	// The freeze may have been extended in the meantime.
+	if storageFreezeUntil.IsZero() || time.Now().Before(storageFreezeUntil) {
+		return
+	}
+
+	logger.Warn("The storage freeze timed out")
+	storageThawLocked()
+}
+
+// storageFreezeSync flushes the storage pools to disk.
+func storageFreezeSync(d *Daemon) {
+	syscall.Sync()
+
+	// Have ZFS commit its pending transaction groups too.
+	for poolName, zpool := range zfsStoragePoolZpools(d) {
+		output, err := shared.RunCommand("zpool", "sync", zpool)
+		if err != nil {
+			// "zpool sync" is only available as of ZFS 0.7.
+			logger.Warn("Failed to sync the zpool", log.Ctx{"pool": poolName, "zpool": zpool, "output": output})
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/suite"
+)
+
+type storageFreezeTestSuite struct {
+	lxdTestSuite
+}
+
+func (suite *storageFreezeTestSuite) TestStorageFreeze() {
+	until, err := storageFreeze(suite.d, time.Minute)
+	suite.Req.Nil(err)
+	suite.Req.Equal(until, storageFrozenUntil())
+
+	entered := make(chan bool)
+	go func() {
+		storageFreezeEnter()
+		storageFreezeLeave()
+		close(entered)
+	}()
+
+	select {
+	case <-entered:
+		suite.T().Fatal("A storage operation ran while the storage was frozen")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	storageThaw()
+
+	select {
+	case <-entered:
+	case <-time.After(time.Second):
+		suite.T().Fatal("The storage operation didn't run once the storage was thawed")
+	}
+
+	suite.Req.True(storageFrozenUntil().IsZero())
+}
+
+func (suite *storageFreezeTestSuite) TestStorageFreezeTimeout() {
+	_, err := storageFreeze(suite.d, 100*time.Millisecond)
+	suite.Req.Nil(err)
+
+	time.Sleep(300 * time.Millisecond)
+	suite.Req.True(storageFrozenUntil().IsZero())
+}
+
+func (suite *storageFreezeTestSuite) TestStorageFreezeWaitsForOperations() {
+	storageFreezeEnter()
+
+	_, err := storageFreeze(suite.d, 100*time.Millisecond)
+	suite.Req.NotNil(err)
+	suite.Req.True(storageFrozenUntil().IsZero())
+
+	storageFreezeLeave()
+}
+
+// The storage changes made through the API wait for the storage to be thawed.
+func (suite *storageFreezeTestSuite) TestStorageFreezeBlocksVolumeDelete() {
+	err := storagePoolVolumeDBCreate(suite.d, lxdTestSuiteDefaultStoragePool, "frozen", "", storagePoolVolumeTypeNameCustom, nil)
+	suite.Req.Nil(err)
+
+	_, err = storageFreeze(suite.d, time.Minute)
+	suite.Req.Nil(err)
+	defer storageThaw()
+
+	req, err := http.NewRequest("DELETE", "/1.0/storage-pools/"+lxdTestSuiteDefaultStoragePool+"/volumes/custom/frozen", nil)
+	suite.Req.Nil(err)
+	req = mux.SetURLVars(req, map[string]string{"pool": lxdTestSuiteDefaultStoragePool, "type": "custom", "name": "frozen"})
+
+	deleted := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		storagePoolVolumeTypeDelete(suite.d, req).Render(rec)
+		deleted <- rec.Code
+	}()
+
+	select {
+	case <-deleted:
+		suite.T().Fatal("A storage volume was deleted while the storage was frozen")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	storageThaw()
+
+	select {
+	case code := <-deleted:
+		suite.Req.Equal(http.StatusOK, code)
+	case <-time.After(time.Second):
+		suite.T().Fatal("The storage volume wasn't deleted once the storage was thawed")
+	}
+}
+
+func (suite *storageFreezeTestSuite) TestStorageFreezeBlocksPoolDelete() {
+	mockStorage, _ := storageTypeToString(storageTypeMock)
+	_, err := dbStoragePoolCreate(suite.d.db, "frozen", "", mockStorage, map[string]string{})
+	suite.Req.Nil(err)
+
+	_, err = storageFreeze(suite.d, time.Minute)
+	suite.Req.Nil(err)
+	defer storageThaw()
+
+	req, err := http.NewRequest("DELETE", "/1.0/storage-pools/frozen", nil)
+	suite.Req.Nil(err)
+	req = mux.SetURLVars(req, map[string]string{"name": "frozen"})
+
+	deleted := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		storagePoolDelete(suite.d, req).Render(rec)
+		deleted <- rec.Code
+	}()
+
+	select {
+	case <-deleted:
+		suite.T().Fatal("A storage pool was deleted while the storage was frozen")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	storageThaw()
+
+	select {
+	case code := <-deleted:
+		suite.Req.Equal(http.StatusOK, code)
+	case <-time.After(time.Second):
+		suite.T().Fatal("The storage pool wasn't deleted once the storage was thawed")
+	}
+}
+
+func TestStorageFreezeTestSuite(t *testing.T) {
+	suite.Run(t, new(storageFreezeTestSuite))
+}
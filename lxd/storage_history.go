@@ -0,0 +1,301 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+)
+
+// storageHistory retains the most recent completed storage operations, both
+// across all storage pools and for each storage pool individually. It's only
+// kept in memory, so it starts over when LXD restarts.
+type storageHistory struct {
+	lock   sync.Mutex
+	global []api.StorageOperationRecord
+	pools  map[string][]api.StorageOperationRecord
+}
+
+var storageOperationHistory = storageHistory{pools: map[string][]api.StorageOperationRecord{}}
+
+func storageHistorySize() int {
+	key, ok := daemonConfig["storage.history_size"]
+	if !ok {
+		return 0
+	}
+
+	return int(key.GetInt64())
+}
+
+func storageHistoryTrim(records []api.StorageOperationRecord, size int) []api.StorageOperationRecord {
+	if len(records) <= size {
+		return records
+	}
+
+	return records[len(records)-size:]
+}
+
+func (h *storageHistory) add(record api.StorageOperationRecord, size int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if size <= 0 {
+		h.global = nil
+		h.pools = map[string][]api.StorageOperationRecord{}
+		return
+	}
+
+	h.global = storageHistoryTrim(append(h.global, record), size)
+	h.pools[record.Pool] = storageHistoryTrim(append(h.pools[record.Pool], record), size)
+}
+
+func (h *storageHistory) get(poolName string) []api.StorageOperationRecord {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	records := h.global
+	if poolName != "" {
+		records = h.pools[poolName]
+	}
+
+	// Return the most recent operation first.
+	result := make([]api.StorageOperationRecord, 0, len(records))
+	for i := len(records) - 1; i >= 0; i-- {
+		result = append(result, records[i])
+	}
+
+	return result
+}
+
+func storageHistoryRecord(poolName string, opType string, target string, start time.Time, bytes int64, err error) {
+	record := api.StorageOperationRecord{
+		Pool:      poolName,
+		Type:      opType,
+		Target:    target,
+		StartedAt: start,
+		Duration:  int64(time.Since(start) / time.Millisecond),
+		Bytes:     bytes,
+		Result:    "success",
+	}
+
+	if err != nil {
+		record.Result = "failure"
+		record.Error = err.Error()
+	}
+
+	storageOperationHistory.add(record, storageHistorySize())
+}
+
+// storageCountingReader and storageCountingWriter account the bytes moved
+// by an operation so that they can be reported in the storage history.
+type storageCountingReader struct {
+	io.ReadCloser
+	op *operation
+}
+
+func (r *storageCountingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	atomic.AddInt64(&r.op.storageBytes, int64(n))
+	return n, err
+}
+
+type storageCountingWriter struct {
+	io.WriteCloser
+	op *operation
+}
+
+func (w *storageCountingWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	atomic.AddInt64(&w.op.storageBytes, int64(n))
+	return n, err
+}
+
+// storageHistoryRecorder wraps a storage driver and records the outcome of
+// the operations which create, copy or remove data on the storage pool. The
+// bytes moved are only known for the incoming migrations, whose streams are
+// counted by the operation they run in.
+type storageHistoryRecorder struct {
+	storage
+
+	poolName   string
+	volumeName string
+}
+
+func (s *storageHistoryRecorder) StoragePoolCreate() error {
+	start := time.Now()
+	err := s.storage.StoragePoolCreate()
+	storageHistoryRecord(s.poolName, "pool_create", s.poolName, start, 0, err)
+	return err
+}
+
+func (s *storageHistoryRecorder) StoragePoolDelete() error {
+	start := time.Now()
+	err := s.storage.StoragePoolDelete()
+	storageHistoryRecord(s.poolName, "pool_delete", s.poolName, start, 0, err)
+	return err
+}
+
+func (s *storageHistoryRecorder) StoragePoolVolumeCreate() error {
+	start := time.Now()
+	err := s.storage.StoragePoolVolumeCreate()
+	storageHistoryRecord(s.poolName, "volume_create", s.volumeName, start, 0, err)
+	return err
+}
+
+func (s *storageHistoryRecorder) StoragePoolVolumeDelete() error {
+	start := time.Now()
+	err := s.storage.StoragePoolVolumeDelete()
+	storageHistoryRecord(s.poolName, "volume_delete", s.volumeName, start, 0, err)
+	return err
+}
+
+func (s *storageHistoryRecorder) StoragePoolVolumeSnapshotCreate(snapshotName string) error {
+	start := time.Now()
+	err := s.storage.StoragePoolVolumeSnapshotCreate(snapshotName)
+	storageHistoryRecord(s.poolName, "volume_snapshot_create", s.volumeName+shared.SnapshotDelimiter+snapshotName, start, 0, err)
+	return err
+}
+
+func (s *storageHistoryRecorder) StoragePoolVolumeSnapshotDelete(snapshotName string) error {
+	start := time.Now()
+	err := s.storage.StoragePoolVolumeSnapshotDelete(snapshotName)
+	storageHistoryRecord(s.poolName, "volume_snapshot_delete", s.volumeName+shared.SnapshotDelimiter+snapshotName, start, 0, err)
+	return err
+}
+
+func (s *storageHistoryRecorder) ContainerCreate(container container) error {
+	start := time.Now()
+	err := s.storage.ContainerCreate(container)
+	storageHistoryRecord(s.poolName, "container_create", container.Name(), start, 0, err)
+	return err
+}
+
+func (s *storageHistoryRecorder) ContainerCreateFromImage(container container, imageFingerprint string) error {
+	start := time.Now()
+	err := s.storage.ContainerCreateFromImage(container, imageFingerprint)
+	storageHistoryRecord(s.poolName, "container_create", container.Name(), start, 0, err)
+	return err
+}
+
+func (s *storageHistoryRecorder) ContainerDelete(container container) error {
+	start := time.Now()
+	err := s.storage.ContainerDelete(container)
+	storageHistoryRecord(s.poolName, "container_delete", container.Name(), start, 0, err)
+	return err
+}
+
+func (s *storageHistoryRecorder) ContainerCopy(target container, source container, containerOnly bool) error {
+	start := time.Now()
+	err := s.storage.ContainerCopy(target, source, containerOnly)
+	storageHistoryRecord(s.poolName, "container_copy", target.Name(), start, 0, err)
+	return err
+}
+
+func (s *storageHistoryRecorder) ContainerRefresh(target container, source container, base container, snapshots []container) error {
+	start := time.Now()
+	err := s.storage.ContainerRefresh(target, source, base, snapshots)
+	storageHistoryRecord(s.poolName, "container_refresh", target.Name(), start, 0, err)
+	return err
+}
+
+func (s *storageHistoryRecorder) ContainerRebuild(container container, imageFingerprint string) error {
+	start := time.Now()
+	err := s.storage.ContainerRebuild(container, imageFingerprint)
+	storageHistoryRecord(s.poolName, "container_rebuild", container.Name(), start, 0, err)
+	return err
+}
+
+func (s *storageHistoryRecorder) ContainerRestore(container container, sourceContainer container) error {
+	start := time.Now()
+	err := s.storage.ContainerRestore(container, sourceContainer)
+	storageHistoryRecord(s.poolName, "container_restore", container.Name(), start, 0, err)
+	return err
+}
+
+func (s *storageHistoryRecorder) ContainerSnapshotCreate(snapshotContainer container, sourceContainer container) error {
+	start := time.Now()
+	err := s.storage.ContainerSnapshotCreate(snapshotContainer, sourceContainer)
+	storageHistoryRecord(s.poolName, "snapshot_create", snapshotContainer.Name(), start, 0, err)
+	return err
+}
+
+func (s *storageHistoryRecorder) ContainerSnapshotDelete(snapshotContainer container) error {
+	start := time.Now()
+	err := s.storage.ContainerSnapshotDelete(snapshotContainer)
+	storageHistoryRecord(s.poolName, "snapshot_delete", snapshotContainer.Name(), start, 0, err)
+	return err
+}
+
+func (s *storageHistoryRecorder) ContainerBackupCreate(backup backupArgs, sourceContainer container, path string) error {
+	start := time.Now()
+	err := s.storage.ContainerBackupCreate(backup, sourceContainer, path)
+	storageHistoryRecord(s.poolName, "backup_create", sourceContainer.Name(), start, 0, err)
+	return err
+}
+
+func (s *storageHistoryRecorder) ContainerBackupLoad(info backupInfo, path string) error {
+	start := time.Now()
+	err := s.storage.ContainerBackupLoad(info, path)
+	storageHistoryRecord(s.poolName, "backup_load", info.Name, start, 0, err)
+	return err
+}
+
+func (s *storageHistoryRecorder) ImageCreate(fingerprint string) error {
+	start := time.Now()
+	err := s.storage.ImageCreate(fingerprint)
+	storageHistoryRecord(s.poolName, "image_create", fingerprint, start, 0, err)
+	return err
+}
+
+func (s *storageHistoryRecorder) ImageDelete(fingerprint string) error {
+	start := time.Now()
+	err := s.storage.ImageDelete(fingerprint)
+	storageHistoryRecord(s.poolName, "image_delete", fingerprint, start, 0, err)
+	return err
+}
+
+func (s *storageHistoryRecorder) MigrationSink(conn *websocket.Conn, op *operation, args MigrationSinkArgs) error {
+	start := time.Now()
+	bytes := int64(0)
+	if op != nil {
+		bytes = atomic.LoadInt64(&op.storageBytes)
+	}
+
+	err := s.storage.MigrationSink(conn, op, args)
+	if op != nil {
+		bytes = atomic.LoadInt64(&op.storageBytes) - bytes
+	}
+
+	storageHistoryRecord(s.poolName, "migration_sink", args.Container.Name(), start, bytes, err)
+	return err
+}
+
+// /1.0/storage-history
+// Get the most recent storage operations across all pools.
+func storageHistoryGet(d *Daemon, r *http.Request) Response {
+	return SyncResponse(true, storageOperationHistory.get(""))
+}
+
+var storageHistoryCmd = Command{name: "storage-history", get: storageHistoryGet}
+
+// /1.0/storage-pools/{name}/history
+// Get the most recent storage operations of a single storage pool.
+func storagePoolHistoryGet(d *Daemon, r *http.Request) Response {
+	poolName := mux.Vars(r)["name"]
+
+	_, err := dbStoragePoolGetID(d.db, poolName)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	return SyncResponse(true, storageOperationHistory.get(poolName))
+}
+
+var storagePoolHistoryCmd = Command{name: "storage-pools/{name}/history", get: storagePoolHistoryGet}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/lxc/lxd/shared/logger"
+)
+
+// The storage hooks are executables run by the daemon around the storage
+// operations on containers, so that external backup or replication systems
+// can act on the data at the right moment. The pre hooks run before the
+// operation and abort it when they fail, the post hooks run once it
+// succeeded and only have their failures logged.
+const (
+	storageHookPostSnapshot = "post_snapshot"
+	storageHookPostCopy     = "post_copy"
+	storageHookPreDelete    = "pre_delete"
+)
+
+func daemonConfigValidateStorageHook(d *Daemon, key string, value string) error {
+	if value == "" {
+		return nil
+	}
+
+	if !filepath.IsAbs(value) {
+		return fmt.Errorf("The storage hook must be an absolute path")
+	}
+
+	return nil
+}
+
+func storageHookPath(hook string) string {
+	key, ok := daemonConfig[fmt.Sprintf("storage.hooks.%s", hook)]
+	if !ok {
+		return ""
+	}
+
+	return key.Get()
+}
+
+// storageHookArgs describes the storage volume a hook is run for.
+type storageHookArgs struct {
+	pool      string
+	driver    string
+	container string
+	dataset   string
+
+	// Set for the hooks about a copy.
+	sourcePool      string
+	sourceContainer string
+}
+
+// storageHookEnv returns the environment variables passed to a hook, on top
+// of the daemon's own.
+func storageHookEnv(hook string, args storageHookArgs) []string {
+	env := []string{
+		fmt.Sprintf("LXD_HOOK=%s", hook),
+		fmt.Sprintf("LXD_POOL=%s", args.pool),
+		fmt.Sprintf("LXD_POOL_DRIVER=%s", args.driver),
+		fmt.Sprintf("LXD_DATASET=%s", args.dataset),
+	}
+
+	parent, snapshot, isSnapshot := containerGetParentAndSnapshotName(args.container)
+	env = append(env, fmt.Sprintf("LXD_CONTAINER=%s", parent))
+	if isSnapshot {
+		env = append(env, fmt.Sprintf("LXD_SNAPSHOT=%s", snapshot))
+	}
+
+	if args.sourceContainer != "" {
+		env = append(env, fmt.Sprintf("LXD_SOURCE_POOL=%s", args.sourcePool))
+		env = append(env, fmt.Sprintf("LXD_SOURCE_CONTAINER=%s", args.sourceContainer))
+	}
+
+	return env
+}
+
+// storageHookDataset returns the ZFS dataset or snapshot backing a container
+// or, for the other drivers, where it's mounted on the host.
+func storageHookDataset(s storage, c container) string {
+	_, poolName := s.GetContainerPoolInfo()
+
+	if s.GetStorageType() != storageTypeZfs {
+		if c.IsSnapshot() {
+			return getSnapshotMountPoint(poolName, c.Name())
+		}
+
+		return getContainerMountPoint(poolName, c.Name())
+	}
+
+	dataset := s.GetStoragePoolWritable().Config["zfs.pool_name"]
+	if dataset == "" {
+		dataset = poolName
+	}
+
+	parent, snapshot, isSnapshot := containerGetParentAndSnapshotName(c.Name())
+	if isSnapshot {
+		fullName, err := zfsContainerSnapshotFullName(s, parent, snapshot)
+		if err == nil {
+			return fullName
+		}
+
+		return fmt.Sprintf("%s/containers/%s@snapshot-%s", dataset, parent, snapshot)
+	}
+
+	return fmt.Sprintf("%s/containers/%s", dataset, c.Name())
+}
+
+// storageHookRun runs the hook configured for the given event, if any.
+func storageHookRun(hook string, args storageHookArgs) error {
+	path := storageHookPath(hook)
+	if path == "" {
+		return nil
+	}
+
+	logger.Debug("Running storage hook", log.Ctx{"hook": hook, "path": path, "pool": args.pool, "container": args.container})
+
+	cmd := exec.Command(path)
+	cmd.Env = append(os.Environ(), storageHookEnv(hook, args)...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Storage hook \"%s\" failed: %s: %s", hook, err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// storageHookRunner wraps a storage driver and runs the storage hooks around
+// its container operations.
+type storageHookRunner struct {
+	storage
+
+	poolName string
+}
+
+func (s *storageHookRunner) hookArgs(c container) storageHookArgs {
+	return storageHookArgs{
+		pool:      s.poolName,
+		driver:    s.GetStorageTypeName(),
+		container: c.Name(),
+		dataset:   storageHookDataset(s.storage, c),
+	}
+}
+
+func (s *storageHookRunner) postHook(hook string, args storageHookArgs) {
+	err := storageHookRun(hook, args)
+	if err != nil {
+		logger.Warn("Storage hook failed", log.Ctx{"hook": hook, "pool": args.pool, "container": args.container, "err": err})
+	}
+}
+
+func (s *storageHookRunner) postCopyHook(target container, source container) {
+	args := s.hookArgs(target)
+	args.sourceContainer = source.Name()
+	args.sourcePool = s.poolName
+	if source.Storage() != nil {
+		_, args.sourcePool = source.Storage().GetContainerPoolInfo()
+	}
+
+	s.postHook(storageHookPostCopy, args)
+}
+
+func (s *storageHookRunner) ContainerDelete(container container) error {
+	err := storageHookRun(storageHookPreDelete, s.hookArgs(container))
+	if err != nil {
+		return err
+	}
+
+	return s.storage.ContainerDelete(container)
+}
+
+func (s *storageHookRunner) ContainerCopy(target container, source container, containerOnly bool) error {
+	err := s.storage.ContainerCopy(target, source, containerOnly)
+	if err != nil {
+		return err
+	}
+
+	s.postCopyHook(target, source)
+	return nil
+}
+
+func (s *storageHookRunner) ContainerRefresh(target container, source container, base container, snapshots []container) error {
+	err := s.storage.ContainerRefresh(target, source, base, snapshots)
+	if err != nil {
+		return err
+	}
+
+	s.postCopyHook(target, source)
+	return nil
+}
+
+func (s *storageHookRunner) ContainerSnapshotCreate(snapshotContainer container, sourceContainer container) error {
+	err := s.storage.ContainerSnapshotCreate(snapshotContainer, sourceContainer)
+	if err != nil {
+		return err
+	}
+
+	s.postHook(storageHookPostSnapshot, s.hookArgs(snapshotContainer))
+	return nil
+}
+
+func (s *storageHookRunner) ContainerSnapshotDelete(snapshotContainer container) error {
+	err := storageHookRun(storageHookPreDelete, s.hookArgs(snapshotContainer))
+	if err != nil {
+		return err
+	}
+
+	return s.storage.ContainerSnapshotDelete(snapshotContainer)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStorageHookEnv(t *testing.T) {
+	env := storageHookEnv(storageHookPostSnapshot, storageHookArgs{
+		pool:      "default",
+		driver:    "zfs",
+		container: "c1/snap0",
+		dataset:   "tank/containers/c1@snapshot-snap0",
+	})
+
+	expected := []string{
+		"LXD_HOOK=post_snapshot",
+		"LXD_POOL=default",
+		"LXD_POOL_DRIVER=zfs",
+		"LXD_DATASET=tank/containers/c1@snapshot-snap0",
+		"LXD_CONTAINER=c1",
+		"LXD_SNAPSHOT=snap0",
+	}
+
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("Expected %v, got %v", expected, env)
+	}
+
+	env = storageHookEnv(storageHookPostCopy, storageHookArgs{
+		pool:            "default",
+		driver:          "dir",
+		container:       "c2",
+		dataset:         "/var/lib/lxd/storage-pools/default/containers/c2",
+		sourcePool:      "other",
+		sourceContainer: "c1",
+	})
+
+	expected = []string{
+		"LXD_HOOK=post_copy",
+		"LXD_POOL=default",
+		"LXD_POOL_DRIVER=dir",
+		"LXD_DATASET=/var/lib/lxd/storage-pools/default/containers/c2",
+		"LXD_CONTAINER=c2",
+		"LXD_SOURCE_POOL=other",
+		"LXD_SOURCE_CONTAINER=c1",
+	}
+
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("Expected %v, got %v", expected, env)
+	}
+}
+
+func TestStorageHookValidate(t *testing.T) {
+	for _, value := range []string{"", "/usr/local/bin/lxd-backup"} {
+		err := daemonConfigValidateStorageHook(nil, "storage.hooks.post_snapshot", value)
+		if err != nil {
+			t.Errorf("Expected \"%s\" to be valid, got: %s", value, err)
+		}
+	}
+
+	err := daemonConfigValidateStorageHook(nil, "storage.hooks.post_snapshot", "lxd-backup")
+	if err == nil {
+		t.Error("Expected a relative path to be refused")
+	}
+}
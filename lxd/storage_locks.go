@@ -0,0 +1,466 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// storageLockTimeout is how long an operation on a storage resource waits for
+// the one already running on it before giving up.
+const storageLockTimeout = 30 * time.Minute
+
+// storageLockKind is the kind of storage resource a lock is about.
+type storageLockKind string
+
+const (
+	storageLockPool      storageLockKind = "storage pool"
+	storageLockImage     storageLockKind = "image"
+	storageLockContainer storageLockKind = "container"
+	storageLockCustom    storageLockKind = "storage volume"
+)
+
+// storageLockKey identifies a storage resource, snapshots being containers of
+// their own.
+type storageLockKey struct {
+	kind storageLockKind
+	pool string
+	name string
+}
+
+func (k storageLockKey) String() string {
+	if k.kind == storageLockPool {
+		return fmt.Sprintf("%s %s", k.kind, k.pool)
+	}
+
+	return fmt.Sprintf("%s %s on storage pool %s", k.kind, k.name, k.pool)
+}
+
+func storagePoolLockKey(poolName string) storageLockKey {
+	return storageLockKey{kind: storageLockPool, pool: poolName}
+}
+
+func storageImageLockKey(poolName string, fingerprint string) storageLockKey {
+	return storageLockKey{kind: storageLockImage, pool: poolName, name: fingerprint}
+}
+
+func storageContainerLockKey(poolName string, containerName string) storageLockKey {
+	return storageLockKey{kind: storageLockContainer, pool: poolName, name: containerName}
+}
+
+func storageCustomLockKey(poolName string, volumeName string) storageLockKey {
+	return storageLockKey{kind: storageLockCustom, pool: poolName, name: volumeName}
+}
+
+// storageLockOp is an operation run on a storage resource.
+type storageLockOp string
+
+const (
+	storageOpMount   storageLockOp = "mount"
+	storageOpUmount  storageLockOp = "unmount"
+	storageOpCreate  storageLockOp = "creation"
+	storageOpDelete  storageLockOp = "deletion"
+	storageOpCopy    storageLockOp = "copy"
+	storageOpRestore storageLockOp = "restore"
+	storageOpMigrate storageLockOp = "migration"
+	storageOpRebuild storageLockOp = "rebuild"
+)
+
+// storageLockOngoing identifies an operation in progress on a resource.
+type storageLockOngoing struct {
+	key storageLockKey
+	op  storageLockOp
+}
+
+// storageLockHolder is the operation, or the operations when shared, holding
+// the lock of a resource.
+type storageLockHolder struct {
+	op       storageLockOp
+	shared   bool
+	users    int
+	released chan struct{}
+}
+
+// storageLockManager serializes the operations on the storage resources.
+//
+// Idempotent operations, such as mounts, are joined: the callers running one
+// while it's already in progress wait for it to be done rather than running
+// it again. The operations changing a resource take its lock, either alone or
+// shared with other operations only reading it, such as the copies of a
+// container or the creation of containers from an image, so that an image
+// can't be deleted while containers are being created from it. Once an
+// operation waits for a lock alone, the new shared ones queue up behind it so
+// that it doesn't wait forever.
+type storageLockManager struct {
+	lock    sync.Mutex
+	ongoing map[storageLockOngoing]chan struct{}
+	held    map[storageLockKey]*storageLockHolder
+	waiting map[storageLockKey]*storageLockWaiters
+}
+
+// storageLockWaiters counts the operations waiting to hold the lock of a
+// resource alone. Its channel is closed when one of them stops waiting.
+type storageLockWaiters struct {
+	op      storageLockOp
+	count   int
+	changed chan struct{}
+}
+
+var storageLocks = storageLockManager{
+	ongoing: map[storageLockOngoing]chan struct{}{},
+	held:    map[storageLockKey]*storageLockHolder{},
+	waiting: map[storageLockKey]*storageLockWaiters{},
+}
+
+// join starts the given operation on a resource, unless it's already in
+// progress in which case it waits for it to be done and returns true. The
+// returned function must be called once the operation is done, if started.
+func (m *storageLockManager) join(key storageLockKey, op storageLockOp) (func(), bool) {
+	ongoing := storageLockOngoing{key: key, op: op}
+
+	m.lock.Lock()
+	done, ok := m.ongoing[ongoing]
+	if ok {
+		m.lock.Unlock()
+		<-done
+		return func() {}, true
+	}
+
+	done = make(chan struct{})
+	m.ongoing[ongoing] = done
+	m.lock.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.lock.Lock()
+			delete(m.ongoing, ongoing)
+			close(done)
+			m.lock.Unlock()
+		})
+	}, false
+}
+
+// acquire takes the lock of a resource for the given operation, waiting for
+// up to timeout for the operations holding it. Shared locks are held along
+// with the other shared ones, unless an exclusive one is waiting in which
+// case they wait for it to be done. The returned function releases the lock.
+func (m *storageLockManager) acquire(key storageLockKey, op storageLockOp, shared bool, timeout time.Duration) (func(), error) {
+	deadline := time.Now().Add(timeout)
+	queued := false
+
+	for {
+		m.lock.Lock()
+		holder, ok := m.held[key]
+		waiters := m.waiting[key]
+
+		var wake chan struct{}
+		var heldOp storageLockOp
+		if shared && waiters != nil && (!ok || holder.shared) {
+			// Let the exclusive operation waiting go first.
+			wake = waiters.changed
+			heldOp = waiters.op
+		} else if !ok || (shared && holder.shared) {
+			if !ok {
+				holder = &storageLockHolder{op: op, shared: shared, released: make(chan struct{})}
+				m.held[key] = holder
+			}
+
+			holder.users++
+			if queued {
+				m.dequeue(key)
+			}
+			m.lock.Unlock()
+			return m.releaser(key, holder), nil
+		} else {
+			if !shared && !queued {
+				m.enqueue(key, op)
+				queued = true
+			}
+
+			wake = holder.released
+			heldOp = holder.op
+		}
+		m.lock.Unlock()
+
+		wait := deadline.Sub(time.Now())
+		timer := time.NewTimer(wait)
+		select {
+		case <-wake:
+			timer.Stop()
+			if wait > 0 {
+				continue
+			}
+		case <-timer.C:
+		}
+
+		if queued {
+			m.lock.Lock()
+			m.dequeue(key)
+			m.lock.Unlock()
+		}
+
+		return nil, fmt.Errorf("Timed out waiting for the %s of %s to finish", heldOp, key)
+	}
+}
+
+// enqueue records an exclusive operation waiting for the lock of a resource.
+// It must be called with the manager locked.
+func (m *storageLockManager) enqueue(key storageLockKey, op storageLockOp) {
+	waiters, ok := m.waiting[key]
+	if !ok {
+		waiters = &storageLockWaiters{op: op, changed: make(chan struct{})}
+		m.waiting[key] = waiters
+	}
+
+	waiters.count++
+}
+
+// dequeue records an exclusive operation no longer waiting for the lock of a
+// resource, waking up the shared ones queued behind it. It must be called
+// with the manager locked.
+func (m *storageLockManager) dequeue(key storageLockKey) {
+	waiters := m.waiting[key]
+	waiters.count--
+	close(waiters.changed)
+
+	if waiters.count == 0 {
+		delete(m.waiting, key)
+	} else {
+		waiters.changed = make(chan struct{})
+	}
+}
+
+func (m *storageLockManager) releaser(key storageLockKey, holder *storageLockHolder) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.lock.Lock()
+			defer m.lock.Unlock()
+
+			holder.users--
+			if holder.users > 0 {
+				return
+			}
+
+			if m.held[key] == holder {
+				delete(m.held, key)
+			}
+			close(holder.released)
+		})
+	}
+}
+
+// storageLockAll takes the locks of several resources in turn, releasing
+// those already taken if one can't be.
+func storageLockAll(locks ...func() (func(), error)) (func(), error) {
+	releases := []func(){}
+	release := func() {
+		for i := len(releases) - 1; i >= 0; i-- {
+			releases[i]()
+		}
+	}
+
+	for _, lock := range locks {
+		unlock, err := lock()
+		if err != nil {
+			release()
+			return nil, err
+		}
+
+		releases = append(releases, unlock)
+	}
+
+	return release, nil
+}
+
+// storageLocker wraps a storage driver and takes the locks of the resources
+// the operations creating, copying, migrating or deleting data work on.
+type storageLocker struct {
+	storage
+
+	poolName   string
+	volumeName string
+}
+
+func (s *storageLocker) lock(key storageLockKey, op storageLockOp, shared bool) func() (func(), error) {
+	return func() (func(), error) {
+		return storageLocks.acquire(key, op, shared, storageLockTimeout)
+	}
+}
+
+func (s *storageLocker) StoragePoolDelete() error {
+	unlock, err := storageLockAll(s.lock(storagePoolLockKey(s.poolName), storageOpDelete, false))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.storage.StoragePoolDelete()
+}
+
+func (s *storageLocker) StoragePoolVolumeCreate() error {
+	unlock, err := storageLockAll(s.lock(storageCustomLockKey(s.poolName, s.volumeName), storageOpCreate, false))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.storage.StoragePoolVolumeCreate()
+}
+
+func (s *storageLocker) StoragePoolVolumeDelete() error {
+	unlock, err := storageLockAll(s.lock(storageCustomLockKey(s.poolName, s.volumeName), storageOpDelete, false))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.storage.StoragePoolVolumeDelete()
+}
+
+func (s *storageLocker) ContainerCreate(container container) error {
+	unlock, err := storageLockAll(s.lock(storageContainerLockKey(s.poolName, container.Name()), storageOpCreate, false))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.storage.ContainerCreate(container)
+}
+
+func (s *storageLocker) ContainerCreateFromImage(container container, imageFingerprint string) error {
+	unlock, err := storageLockAll(
+		s.lock(storageImageLockKey(s.poolName, imageFingerprint), storageOpCreate, true),
+		s.lock(storageContainerLockKey(s.poolName, container.Name()), storageOpCreate, false))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.storage.ContainerCreateFromImage(container, imageFingerprint)
+}
+
+func (s *storageLocker) ContainerDelete(container container) error {
+	unlock, err := storageLockAll(s.lock(storageContainerLockKey(s.poolName, container.Name()), storageOpDelete, false))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.storage.ContainerDelete(container)
+}
+
+func (s *storageLocker) ContainerCopy(target container, source container, containerOnly bool) error {
+	unlock, err := storageLockAll(
+		s.lock(storageContainerLockKey(s.poolName, source.Name()), storageOpCopy, true),
+		s.lock(storageContainerLockKey(s.poolName, target.Name()), storageOpCopy, false))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.storage.ContainerCopy(target, source, containerOnly)
+}
+
+func (s *storageLocker) ContainerRefresh(target container, source container, base container, snapshots []container) error {
+	unlock, err := storageLockAll(
+		s.lock(storageContainerLockKey(s.poolName, source.Name()), storageOpCopy, true),
+		s.lock(storageContainerLockKey(s.poolName, target.Name()), storageOpCopy, false))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.storage.ContainerRefresh(target, source, base, snapshots)
+}
+
+func (s *storageLocker) ContainerRebuild(container container, imageFingerprint string) error {
+	unlock, err := storageLockAll(
+		s.lock(storageImageLockKey(s.poolName, imageFingerprint), storageOpCreate, true),
+		s.lock(storageContainerLockKey(s.poolName, container.Name()), storageOpRebuild, false))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.storage.ContainerRebuild(container, imageFingerprint)
+}
+
+func (s *storageLocker) ContainerRestore(container container, sourceContainer container) error {
+	unlock, err := storageLockAll(
+		s.lock(storageContainerLockKey(s.poolName, sourceContainer.Name()), storageOpRestore, true),
+		s.lock(storageContainerLockKey(s.poolName, container.Name()), storageOpRestore, false))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.storage.ContainerRestore(container, sourceContainer)
+}
+
+func (s *storageLocker) ContainerSnapshotCreate(snapshotContainer container, sourceContainer container) error {
+	unlock, err := storageLockAll(
+		s.lock(storageContainerLockKey(s.poolName, sourceContainer.Name()), storageOpCreate, true),
+		s.lock(storageContainerLockKey(s.poolName, snapshotContainer.Name()), storageOpCreate, false))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.storage.ContainerSnapshotCreate(snapshotContainer, sourceContainer)
+}
+
+func (s *storageLocker) ContainerSnapshotDelete(snapshotContainer container) error {
+	unlock, err := storageLockAll(s.lock(storageContainerLockKey(s.poolName, snapshotContainer.Name()), storageOpDelete, false))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.storage.ContainerSnapshotDelete(snapshotContainer)
+}
+
+func (s *storageLocker) ContainerBackupLoad(info backupInfo, path string) error {
+	unlock, err := storageLockAll(s.lock(storageContainerLockKey(s.poolName, info.Name), storageOpCreate, false))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.storage.ContainerBackupLoad(info, path)
+}
+
+func (s *storageLocker) ImageCreate(fingerprint string) error {
+	unlock, err := storageLockAll(s.lock(storageImageLockKey(s.poolName, fingerprint), storageOpCreate, false))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.storage.ImageCreate(fingerprint)
+}
+
+func (s *storageLocker) ImageDelete(fingerprint string) error {
+	unlock, err := storageLockAll(s.lock(storageImageLockKey(s.poolName, fingerprint), storageOpDelete, false))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.storage.ImageDelete(fingerprint)
+}
+
+// MigrationSink shares the lock of the container with the creation of its
+// snapshots, which the sinks run as they receive them.
+func (s *storageLocker) MigrationSink(conn *websocket.Conn, op *operation, args MigrationSinkArgs) error {
+	unlock, err := storageLockAll(s.lock(storageContainerLockKey(s.poolName, args.Container.Name()), storageOpMigrate, true))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.storage.MigrationSink(conn, op, args)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func newStorageLockManager() *storageLockManager {
+	return &storageLockManager{
+		ongoing: map[storageLockOngoing]chan struct{}{},
+		held:    map[storageLockKey]*storageLockHolder{},
+		waiting: map[storageLockKey]*storageLockWaiters{},
+	}
+}
+
+func TestStorageLocksJoin(t *testing.T) {
+	m := newStorageLockManager()
+	key := storageContainerLockKey("default", "c1")
+
+	done, joined := m.join(key, storageOpMount)
+	if joined {
+		t.Fatal("Joined an operation which wasn't running")
+	}
+
+	// Other operations on the same resource aren't joined.
+	umountDone, joined := m.join(key, storageOpUmount)
+	if joined {
+		t.Fatal("Joined the mount of the container when unmounting it")
+	}
+	umountDone()
+
+	result := make(chan bool)
+	go func() {
+		_, joined := m.join(key, storageOpMount)
+		result <- joined
+	}()
+
+	select {
+	case <-result:
+		t.Fatal("Joined the mount before it was done")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	done()
+	if !<-result {
+		t.Fatal("Didn't join the running mount")
+	}
+
+	// Calling it twice is harmless.
+	done()
+
+	done, joined = m.join(key, storageOpMount)
+	if joined {
+		t.Fatal("Joined a mount which was done")
+	}
+	done()
+}
+
+func TestStorageLocksAcquireShared(t *testing.T) {
+	m := newStorageLockManager()
+	key := storageImageLockKey("default", "abcd")
+
+	first, err := m.acquire(key, storageOpCreate, true, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := m.acquire(key, storageOpCreate, true, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.acquire(key, storageOpDelete, false, 50*time.Millisecond)
+	if err == nil {
+		t.Fatal("Deleted the image while containers were being created from it")
+	}
+
+	first()
+	_, err = m.acquire(key, storageOpDelete, false, 50*time.Millisecond)
+	if err == nil {
+		t.Fatal("Deleted the image while a container was being created from it")
+	}
+
+	second()
+	unlock, err := m.acquire(key, storageOpDelete, false, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+
+	if len(m.held) != 0 {
+		t.Fatalf("Expected no locks to be held, got %d", len(m.held))
+	}
+}
+
+func TestStorageLocksAcquireExclusive(t *testing.T) {
+	m := newStorageLockManager()
+	key := storageContainerLockKey("default", "c1")
+
+	unlock, err := m.acquire(key, storageOpCopy, false, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.acquire(key, storageOpCopy, true, 50*time.Millisecond)
+	if err == nil {
+		t.Fatal("Shared the lock of a container being copied to")
+	}
+
+	expected := "Timed out waiting for the copy of container c1 on storage pool default to finish"
+	if err.Error() != expected {
+		t.Fatalf("Expected %q, got %q", expected, err.Error())
+	}
+
+	result := make(chan error)
+	go func() {
+		unlock, err := m.acquire(key, storageOpDelete, false, time.Second)
+		if err == nil {
+			unlock()
+		}
+		result <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	unlock()
+
+	err = <-result
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageLocksAcquireWaiting(t *testing.T) {
+	m := newStorageLockManager()
+	key := storageContainerLockKey("default", "c1")
+
+	unlock, err := m.acquire(key, storageOpCopy, true, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := make(chan error)
+	go func() {
+		unlock, err := m.acquire(key, storageOpDelete, false, time.Second)
+		if err == nil {
+			time.Sleep(50 * time.Millisecond)
+			unlock()
+		}
+		result <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	_, err = m.acquire(key, storageOpCopy, true, 50*time.Millisecond)
+	if err == nil {
+		t.Fatal("Shared the lock of a container waiting to be deleted")
+	}
+
+	expected := "Timed out waiting for the deletion of container c1 on storage pool default to finish"
+	if err.Error() != expected {
+		t.Fatalf("Expected %q, got %q", expected, err.Error())
+	}
+
+	shared := make(chan error)
+	go func() {
+		unlock, err := m.acquire(key, storageOpCopy, true, time.Second)
+		if err == nil {
+			unlock()
+		}
+		shared <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	unlock()
+
+	err = <-result
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = <-shared
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.held) != 0 || len(m.waiting) != 0 {
+		t.Fatal("Locks left behind")
+	}
+}
+
+func TestStorageLockAll(t *testing.T) {
+	m := newStorageLockManager()
+	source := storageContainerLockKey("default", "c1")
+	target := storageContainerLockKey("default", "c2")
+
+	unlock, err := m.acquire(target, storageOpDelete, false, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = storageLockAll(
+		func() (func(), error) { return m.acquire(source, storageOpCopy, true, time.Second) },
+		func() (func(), error) { return m.acquire(target, storageOpCopy, false, 50*time.Millisecond) })
+	if err == nil {
+		t.Fatal("Copied to a container being deleted")
+	}
+
+	_, ok := m.held[source]
+	if ok {
+		t.Fatal("The lock of the source wasn't released")
+	}
+
+	unlock()
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/gorilla/websocket"
+	"github.com/pborman/uuid"
 
 	"github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/api"
@@ -403,31 +405,13 @@ func (s *storageLvm) StoragePoolMount() (bool, error) {
 		return true, nil
 	}
 
-	poolMountLockID := getPoolMountLockID(s.pool.Name)
-	lxdStorageMapLock.Lock()
-	if waitChannel, ok := lxdStorageOngoingOperationMap[poolMountLockID]; ok {
-		lxdStorageMapLock.Unlock()
-		if _, ok := <-waitChannel; ok {
-			logger.Warnf("Received value over semaphore. This should not have happened.")
-		}
+	unlock, joined := storageLocks.join(storagePoolLockKey(s.pool.Name), storageOpMount)
+	if joined {
 		// Give the benefit of the doubt and assume that the other
 		// thread actually succeeded in mounting the storage pool.
 		return false, nil
 	}
-
-	lxdStorageOngoingOperationMap[poolMountLockID] = make(chan bool)
-	lxdStorageMapLock.Unlock()
-
-	removeLockFromMap := func() {
-		lxdStorageMapLock.Lock()
-		if waitChannel, ok := lxdStorageOngoingOperationMap[poolMountLockID]; ok {
-			close(waitChannel)
-			delete(lxdStorageOngoingOperationMap, poolMountLockID)
-		}
-		lxdStorageMapLock.Unlock()
-	}
-
-	defer removeLockFromMap()
+	defer unlock()
 
 	if filepath.IsAbs(source) && !shared.IsBlockdevPath(source) {
 		// Try to prepare new loop device.
@@ -484,17 +468,14 @@ func (s *storageLvm) StoragePoolVolumeCreate() error {
 		}
 	}()
 
+	// The volume is only mounted once used, mounting it here would take a
+	// reference nothing releases.
 	customPoolVolumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
 	err = os.MkdirAll(customPoolVolumeMntPoint, 0711)
 	if err != nil {
 		return err
 	}
 
-	_, err = s.StoragePoolVolumeMount()
-	if err != nil {
-		return err
-	}
-
 	tryUndo = false
 
 	logger.Infof("Created LVM storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
@@ -544,38 +525,12 @@ func (s *storageLvm) StoragePoolVolumeMount() (bool, error) {
 	}
 	lvmVolumePath := getLvmDevPath(poolName, volumeType, s.volume.Name)
 
-	customMountLockID := getCustomMountLockID(s.pool.Name, s.volume.Name)
-	lxdStorageMapLock.Lock()
-	if waitChannel, ok := lxdStorageOngoingOperationMap[customMountLockID]; ok {
-		lxdStorageMapLock.Unlock()
-		if _, ok := <-waitChannel; ok {
-			logger.Warnf("Received value over semaphore. This should not have happened.")
-		}
-		// Give the benefit of the doubt and assume that the other
-		// thread actually succeeded in mounting the storage volume.
-		return false, nil
-	}
-
-	lxdStorageOngoingOperationMap[customMountLockID] = make(chan bool)
-	lxdStorageMapLock.Unlock()
-
-	var customerr error
-	ourMount := false
-	if !shared.IsMountPoint(customPoolVolumeMntPoint) {
+	ourMount, err := storagePoolVolumeMountRef(s.pool.Name, s.volume.Name, func() error {
 		mountFlags, mountOptions := lxdResolveMountoptions(s.getLvmMountOptions())
-		customerr = tryMount(lvmVolumePath, customPoolVolumeMntPoint, lvFsType, mountFlags, mountOptions)
-		ourMount = true
-	}
-
-	lxdStorageMapLock.Lock()
-	if waitChannel, ok := lxdStorageOngoingOperationMap[customMountLockID]; ok {
-		close(waitChannel)
-		delete(lxdStorageOngoingOperationMap, customMountLockID)
-	}
-	lxdStorageMapLock.Unlock()
-
-	if customerr != nil {
-		return false, customerr
+		return tryMount(lvmVolumePath, customPoolVolumeMntPoint, lvFsType, mountFlags, mountOptions)
+	})
+	if err != nil {
+		return false, err
 	}
 
 	logger.Debugf("Mounted LVM storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
@@ -587,41 +542,50 @@ func (s *storageLvm) StoragePoolVolumeUmount() (bool, error) {
 
 	customPoolVolumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
 
-	customUmountLockID := getCustomUmountLockID(s.pool.Name, s.volume.Name)
-	lxdStorageMapLock.Lock()
-	if waitChannel, ok := lxdStorageOngoingOperationMap[customUmountLockID]; ok {
-		lxdStorageMapLock.Unlock()
-		if _, ok := <-waitChannel; ok {
-			logger.Warnf("Received value over semaphore. This should not have happened.")
-		}
-		// Give the benefit of the doubt and assume that the other
-		// thread actually succeeded in unmounting the storage volume.
-		return false, nil
+	ourUmount, err := storagePoolVolumeUmountRef(s.pool.Name, s.volume.Name, func() error {
+		return tryUnmount(customPoolVolumeMntPoint, 0)
+	})
+	if err != nil {
+		return false, err
 	}
 
-	lxdStorageOngoingOperationMap[customUmountLockID] = make(chan bool)
-	lxdStorageMapLock.Unlock()
+	logger.Debugf("Unmounted LVM storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	return ourUmount, nil
+}
 
-	var customerr error
-	ourUmount := false
-	if shared.IsMountPoint(customPoolVolumeMntPoint) {
-		customerr = tryUnmount(customPoolVolumeMntPoint, 0)
-		ourUmount = true
+func (s *storageLvm) StoragePoolVolumeRename(newName string) error {
+	logger.Infof("Renaming LVM storage volume \"%s\" on storage pool \"%s\" to \"%s\".", s.volume.Name, s.pool.Name, newName)
+
+	oldPoolVolumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
+	newPoolVolumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, newName)
+	if shared.IsMountPoint(oldPoolVolumeMntPoint) {
+		err := tryUnmount(oldPoolVolumeMntPoint, 0)
+		if err != nil {
+			return err
+		}
 	}
 
-	lxdStorageMapLock.Lock()
-	if waitChannel, ok := lxdStorageOngoingOperationMap[customUmountLockID]; ok {
-		close(waitChannel)
-		delete(lxdStorageOngoingOperationMap, customUmountLockID)
+	err := s.renameLVByPath(s.volume.Name, newName, storagePoolVolumeAPIEndpointCustom)
+	if err != nil {
+		return fmt.Errorf("Failed to rename a custom LV, oldName='%s', newName='%s', err='%s'", s.volume.Name, newName, err)
 	}
-	lxdStorageMapLock.Unlock()
 
-	if customerr != nil {
-		return false, customerr
+	err = os.Rename(oldPoolVolumeMntPoint, newPoolVolumeMntPoint)
+	if err != nil {
+		s.renameLVByPath(newName, s.volume.Name, storagePoolVolumeAPIEndpointCustom)
+		return err
 	}
 
-	logger.Debugf("Unmounted LVM storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
-	return ourUmount, nil
+	logger.Infof("Renamed LVM storage volume \"%s\" on storage pool \"%s\" to \"%s\".", s.volume.Name, s.pool.Name, newName)
+	return nil
+}
+
+func (s *storageLvm) StoragePoolVolumeGetState() (*api.StorageVolumeState, error) {
+	return nil, fmt.Errorf("the LVM storage driver doesn't report the usage of storage volumes")
+}
+
+func (s *storageLvm) StoragePoolVolumeCopy(sourceName string, flatten bool) error {
+	return storagePoolVolumeCopyRsync(s.d, s, s.pool.Name, sourceName, s.volume.Name)
 }
 
 func (s *storageLvm) GetStoragePoolWritable() api.StoragePoolPut {
@@ -636,6 +600,10 @@ func (s *storageLvm) SetStoragePoolWritable(writable *api.StoragePoolPut) {
 	s.pool.StoragePoolPut = *writable
 }
 
+func (s *storageLvm) StoragePoolVerify(report *storageVerifyReport, containers []container) error {
+	return nil
+}
+
 func (s *storageLvm) SetStoragePoolVolumeWritable(writable *api.StorageVolumePut) {
 	s.volume.StorageVolumePut = *writable
 }
@@ -670,7 +638,7 @@ func (s *storageLvm) StoragePoolUpdate(writable *api.StoragePoolPut, changedConf
 	// "volume.block.mount_options" requires no on-disk modifications.
 	// "volume.block.filesystem" requires no on-disk modifications.
 	// "volume.size" requires no on-disk modifications.
-	// "rsync.bwlimit" requires no on-disk modifications.
+	// The "rsync.*" keys require no on-disk modifications.
 
 	// Given a set of changeable pool properties the change should be
 	// "transactional": either the whole update succeeds or none. So try to
@@ -758,7 +726,7 @@ func (s *storageLvm) StoragePoolUpdate(writable *api.StoragePoolPut, changedConf
 	return nil
 }
 
-func (s *storageLvm) StoragePoolVolumeUpdate(changedConfig []string) error {
+func (s *storageLvm) StoragePoolVolumeUpdate(writable *api.StorageVolumePut, changedConfig []string) error {
 	logger.Infof("Updating LVM storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
 
 	if shared.StringInSlice("block.mount_options", changedConfig) && len(changedConfig) == 1 {
@@ -771,6 +739,22 @@ func (s *storageLvm) StoragePoolVolumeUpdate(changedConfig []string) error {
 	return nil
 }
 
+func (s *storageLvm) StoragePoolVolumeSnapshotCreate(snapshotName string) error {
+	return fmt.Errorf("LVM storage volumes don't support snapshots")
+}
+
+func (s *storageLvm) StoragePoolVolumeSnapshotDelete(snapshotName string) error {
+	return fmt.Errorf("LVM storage volumes don't support snapshots")
+}
+
+func (s *storageLvm) StoragePoolVolumeSnapshotRename(snapshotName string, newName string) error {
+	return fmt.Errorf("LVM storage volumes don't support snapshots")
+}
+
+func (s *storageLvm) StoragePoolVolumeSnapshotRestore(snapshotName string) error {
+	return fmt.Errorf("LVM storage volumes don't support snapshots")
+}
+
 func (s *storageLvm) ContainerStorageReady(name string) bool {
 	containerLvmName := containerNameToLVName(name)
 	poolName := s.getOnDiskPoolName()
@@ -988,7 +972,7 @@ func (s *storageLvm) ContainerCopy(target container, source container, container
 	_, sourcePool := source.Storage().GetContainerPoolInfo()
 	_, targetPool := target.Storage().GetContainerPoolInfo()
 	if sourcePool != targetPool {
-		return fmt.Errorf("copying containers between different storage pools is not implemented")
+		return storageContainerCopyAcrossPools(s, target, source, containerOnly)
 	}
 
 	err = s.copyContainer(target, source)
@@ -1039,6 +1023,22 @@ func (s *storageLvm) ContainerCopy(target container, source container, container
 	return nil
 }
 
+func (s *storageLvm) ContainerRefresh(target container, source container, base container, snapshots []container) error {
+	return fmt.Errorf("Refreshing containers isn't supported by the lvm storage driver")
+}
+
+func (s *storageLvm) ContainerRebuild(container container, imageFingerprint string) error {
+	return fmt.Errorf("Rebuilding containers isn't supported by the lvm storage driver")
+}
+
+func (s *storageLvm) ScratchVolumeCreate(container container, device string, size int64) (string, error) {
+	return "", fmt.Errorf("Scratch volumes aren't supported by the lvm storage driver")
+}
+
+func (s *storageLvm) ScratchVolumeDelete(container container, device string) error {
+	return fmt.Errorf("Scratch volumes aren't supported by the lvm storage driver")
+}
+
 func (s *storageLvm) ContainerMount(c container) (bool, error) {
 	name := c.Name()
 	logger.Debugf("Mounting LVM storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
@@ -1052,21 +1052,13 @@ func (s *storageLvm) ContainerMount(c container) (bool, error) {
 		containerMntPoint = getSnapshotMountPoint(s.pool.Name, name)
 	}
 
-	containerMountLockID := getContainerMountLockID(s.pool.Name, name)
-	lxdStorageMapLock.Lock()
-	if waitChannel, ok := lxdStorageOngoingOperationMap[containerMountLockID]; ok {
-		lxdStorageMapLock.Unlock()
-		if _, ok := <-waitChannel; ok {
-			logger.Warnf("Received value over semaphore. This should not have happened.")
-		}
+	unlock, joined := storageLocks.join(storageContainerLockKey(s.pool.Name, name), storageOpMount)
+	if joined {
 		// Give the benefit of the doubt and assume that the other
 		// thread actually succeeded in mounting the storage volume.
 		return false, nil
 	}
 
-	lxdStorageOngoingOperationMap[containerMountLockID] = make(chan bool)
-	lxdStorageMapLock.Unlock()
-
 	var mounterr error
 	ourMount := false
 	if !shared.IsMountPoint(containerMntPoint) {
@@ -1075,12 +1067,7 @@ func (s *storageLvm) ContainerMount(c container) (bool, error) {
 		ourMount = true
 	}
 
-	lxdStorageMapLock.Lock()
-	if waitChannel, ok := lxdStorageOngoingOperationMap[containerMountLockID]; ok {
-		close(waitChannel)
-		delete(lxdStorageOngoingOperationMap, containerMountLockID)
-	}
-	lxdStorageMapLock.Unlock()
+	unlock()
 
 	if mounterr != nil {
 		return false, mounterr
@@ -1098,21 +1085,13 @@ func (s *storageLvm) ContainerUmount(name string, path string) (bool, error) {
 		containerMntPoint = getSnapshotMountPoint(s.pool.Name, name)
 	}
 
-	containerUmountLockID := getContainerUmountLockID(s.pool.Name, name)
-	lxdStorageMapLock.Lock()
-	if waitChannel, ok := lxdStorageOngoingOperationMap[containerUmountLockID]; ok {
-		lxdStorageMapLock.Unlock()
-		if _, ok := <-waitChannel; ok {
-			logger.Warnf("Received value over semaphore. This should not have happened.")
-		}
+	unlock, joined := storageLocks.join(storageContainerLockKey(s.pool.Name, name), storageOpUmount)
+	if joined {
 		// Give the benefit of the doubt and assume that the other
 		// thread actually succeeded in unmounting the storage volume.
 		return false, nil
 	}
 
-	lxdStorageOngoingOperationMap[containerUmountLockID] = make(chan bool)
-	lxdStorageMapLock.Unlock()
-
 	var imgerr error
 	ourUmount := false
 	if shared.IsMountPoint(containerMntPoint) {
@@ -1120,12 +1099,7 @@ func (s *storageLvm) ContainerUmount(name string, path string) (bool, error) {
 		ourUmount = true
 	}
 
-	lxdStorageMapLock.Lock()
-	if waitChannel, ok := lxdStorageOngoingOperationMap[containerUmountLockID]; ok {
-		close(waitChannel)
-		delete(lxdStorageOngoingOperationMap, containerUmountLockID)
-	}
-	lxdStorageMapLock.Unlock()
+	unlock()
 
 	if imgerr != nil {
 		return false, imgerr
@@ -1277,7 +1251,7 @@ func (s *storageLvm) ContainerRestore(target container, source container) error
 		defer target.Unfreeze()
 
 		bwlimit := s.pool.Config["rsync.bwlimit"]
-		output, err := rsyncLocalCopy(sourceContainerMntPoint, targetContainerMntPoint, bwlimit)
+		output, err := rsyncLocalCopy(sourceContainerMntPoint, targetContainerMntPoint, bwlimit, rsyncPoolLocalArgs(s.pool.Config)...)
 		if err != nil {
 			return fmt.Errorf("failed to rsync container: %s: %s", string(output), err)
 		}
@@ -1484,6 +1458,14 @@ func (s *storageLvm) ContainerSnapshotCreateEmpty(snapshotContainer container) e
 	return nil
 }
 
+func (s *storageLvm) ContainerBackupCreate(backup backupArgs, sourceContainer container, path string) error {
+	return fmt.Errorf("Optimized backups aren't supported by the lvm storage driver")
+}
+
+func (s *storageLvm) ContainerBackupLoad(info backupInfo, path string) error {
+	return s.containerBackupLoad(info, path)
+}
+
 func (s *storageLvm) ImageCreate(fingerprint string) error {
 	logger.Debugf("Creating LVM storage volume for image \"%s\" on storage pool \"%s\".", fingerprint, s.pool.Name)
 
@@ -1638,18 +1620,200 @@ func (s *storageLvm) ImageUmount(fingerprint string) (bool, error) {
 	return true, nil
 }
 
+// MigrationType only sends the logical volumes themselves on a thin pool. On
+// a normal one, the snapshots received are snapshots of the container's LV,
+// which can't be replaced by the next volume received without losing them.
 func (s *storageLvm) MigrationType() MigrationFSType {
-	return MigrationFSType_RSYNC
+	if !s.useThinpool {
+		return MigrationFSType_RSYNC
+	}
+
+	return MigrationFSType_BLOCK
 }
 
 func (s *storageLvm) PreservesInodes() bool {
 	return false
 }
 
+type lvmMigrationSourceDriver struct {
+	container container
+	snapshots []container
+	lvm       *storageLvm
+}
+
+func (s *lvmMigrationSourceDriver) Snapshots() []container {
+	return s.snapshots
+}
+
+func (s *lvmMigrationSourceDriver) send(conn *websocket.Conn, lvName string, readWrapper func(io.ReadCloser) io.ReadCloser) error {
+	lvPath := getLvmDevPath(s.lvm.getOnDiskPoolName(), storagePoolVolumeAPIEndpointContainers, lvName)
+	return blockSend(lvPath, conn, readWrapper)
+}
+
+func (s *lvmMigrationSourceDriver) SendWhileRunning(conn *websocket.Conn, op *operation, bwlimit string, containerOnly bool) error {
+	if s.container.IsSnapshot() {
+		wrapper := StorageProgressReader(op, "fs_progress", s.container.Name())
+		return s.send(conn, containerNameToLVName(s.container.Name()), wrapper)
+	}
+
+	if !containerOnly {
+		for _, snap := range s.snapshots {
+			wrapper := StorageProgressReader(op, "fs_progress", snap.Name())
+			err := s.send(conn, containerNameToLVName(snap.Name()), wrapper)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Send a temporary snapshot of the container so that the filesystem
+	// doesn't change during the transfer.
+	poolName := s.lvm.getOnDiskPoolName()
+	containerLvmName := containerNameToLVName(s.container.Name())
+	tmpLvmName := containerNameToLVName(fmt.Sprintf("%s%smigration-%s", s.container.Name(), shared.SnapshotDelimiter, uuid.NewRandom().String()))
+
+	_, err := s.lvm.createSnapshotLV(poolName, containerLvmName, storagePoolVolumeAPIEndpointContainers, tmpLvmName, storagePoolVolumeAPIEndpointContainers, true, s.lvm.useThinpool)
+	if err != nil {
+		return err
+	}
+	defer s.lvm.removeLV(poolName, storagePoolVolumeAPIEndpointContainers, tmpLvmName)
+
+	wrapper := StorageProgressReader(op, "fs_progress", s.container.Name())
+	return s.send(conn, tmpLvmName, wrapper)
+}
+
+func (s *lvmMigrationSourceDriver) SendAfterCheckpoint(conn *websocket.Conn, bwlimit string) error {
+	// Never negotiated for live migrations.
+	return fmt.Errorf("Block transfers don't support live migration")
+}
+
+func (s *lvmMigrationSourceDriver) Cleanup() {
+}
+
 func (s *storageLvm) MigrationSource(container container, containerOnly bool) (MigrationStorageSourceDriver, error) {
-	return rsyncMigrationSource(container, containerOnly)
+	if s.MigrationType() != MigrationFSType_BLOCK {
+		return rsyncMigrationSource(container, containerOnly)
+	}
+
+	driver := lvmMigrationSourceDriver{
+		container: container,
+		lvm:       s,
+	}
+
+	if containerOnly || container.IsSnapshot() {
+		return &driver, nil
+	}
+
+	snapshots, err := container.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+	driver.snapshots = snapshots
+
+	return &driver, nil
 }
 
-func (s *storageLvm) MigrationSink(live bool, container container, snapshots []*Snapshot, conn *websocket.Conn, srcIdmap *shared.IdmapSet, op *operation, containerOnly bool) error {
-	return rsyncMigrationSink(live, container, snapshots, conn, srcIdmap, op, containerOnly)
+func (s *storageLvm) MigrationSink(conn *websocket.Conn, op *operation, args MigrationSinkArgs) error {
+	if s.MigrationType() != MigrationFSType_BLOCK {
+		return rsyncMigrationSink(conn, op, args)
+	}
+
+	poolName := s.getOnDiskPoolName()
+	containerLvmName := containerNameToLVName(args.Container.Name())
+
+	// Every received volume replaces the container's LV with a new one of
+	// the size of the source. The snapshots of a thin LV don't depend on
+	// it, so those already received survive its removal.
+	blockRecvLv := func(name string) error {
+		_, err := s.ContainerUmount(args.Container.Name(), args.Container.Path())
+		if err != nil {
+			return err
+		}
+
+		prepare := func(size int64) (string, error) {
+			err := s.removeLV(poolName, storagePoolVolumeAPIEndpointContainers, containerLvmName)
+			if err != nil {
+				return "", err
+			}
+
+			err = lvmCreateRawLv(poolName, s.getLvmThinpoolName(), containerLvmName, fmt.Sprintf("%d", size), storagePoolVolumeAPIEndpointContainers, s.useThinpool)
+			if err != nil {
+				return "", err
+			}
+
+			return getLvmDevPath(poolName, storagePoolVolumeAPIEndpointContainers, containerLvmName), nil
+		}
+
+		wrapper := StorageProgressWriter(op, "fs_progress", name)
+		return blockRecv(conn, wrapper, prepare, !s.useThinpool)
+	}
+
+	// At this point we have already figured out the parent
+	// container's root disk device so we can simply
+	// retrieve it from the expanded devices.
+	parentStoragePool := ""
+	parentExpandedDevices := args.Container.ExpandedDevices()
+	parentLocalRootDiskDeviceKey, parentLocalRootDiskDevice, _ := containerGetRootDiskDevice(parentExpandedDevices)
+	if parentLocalRootDiskDeviceKey != "" {
+		parentStoragePool = parentLocalRootDiskDevice["pool"]
+	}
+
+	// A little neuroticism.
+	if parentStoragePool == "" {
+		return fmt.Errorf("detected that the container's root device is missing the pool property during LVM migration")
+	}
+
+	// Undo whatever got received if the migration fails halfway, newest
+	// first, so that it can be retried.
+	revert := true
+	undo := []func(){}
+	defer func() {
+		if !revert {
+			return
+		}
+
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}()
+
+	if !args.ContainerOnly {
+		for _, snap := range args.Snapshots {
+			snapArgs := snapshotProtobufToContainerArgs(args.Container.Name(), snap)
+
+			// Ensure that snapshot and parent container have the
+			// same storage pool in their local root disk device.
+			if snapArgs.Devices != nil {
+				snapLocalRootDiskDeviceKey, _, _ := containerGetRootDiskDevice(snapArgs.Devices)
+				if snapLocalRootDiskDeviceKey != "" {
+					snapArgs.Devices[snapLocalRootDiskDeviceKey]["pool"] = parentStoragePool
+				}
+			}
+
+			err := blockRecvLv(snap.GetName())
+			if err != nil {
+				return err
+			}
+
+			err = ShiftIfNecessary(args.Container, args.Idmap)
+			if err != nil {
+				return err
+			}
+
+			cs, err := containerCreateAsSnapshot(args.Container.Daemon(), snapArgs, args.Container)
+			if err != nil {
+				return err
+			}
+			undo = append(undo, migrationSinkSnapshotDelete(cs))
+		}
+	}
+
+	err := blockRecvLv(args.Container.Name())
+	if err != nil {
+		return err
+	}
+
+	revert = false
+
+	return nil
 }
@@ -363,7 +363,7 @@ func (s *storageLvm) copyContainerLv(target container, source container, readonl
 	}
 
 	bwlimit := s.pool.Config["rsync.bwlimit"]
-	output, err := rsyncLocalCopy(sourceContainerMntPoint, targetContainerMntPoint, bwlimit)
+	output, err := rsyncLocalCopy(sourceContainerMntPoint, targetContainerMntPoint, bwlimit, rsyncPoolLocalArgs(s.pool.Config)...)
 	if err != nil {
 		return fmt.Errorf("failed to rsync container: %s: %s", string(output), err)
 	}
@@ -445,29 +445,15 @@ func (s *storageLvm) containerCreateFromImageThinLv(c container, fp string) erro
 	// Check if the image already exists.
 	imageLvmDevPath := getLvmDevPath(poolName, storagePoolVolumeAPIEndpointImages, fp)
 
-	imageStoragePoolLockID := getImageCreateLockID(poolName, fp)
-	lxdStorageMapLock.Lock()
-	if waitChannel, ok := lxdStorageOngoingOperationMap[imageStoragePoolLockID]; ok {
-		lxdStorageMapLock.Unlock()
-		if _, ok := <-waitChannel; ok {
-			logger.Warnf("Received value over semaphore. This should not have happened.")
-		}
-	} else {
-		lxdStorageOngoingOperationMap[imageStoragePoolLockID] = make(chan bool)
-		lxdStorageMapLock.Unlock()
-
+	unlock, joined := storageLocks.join(storageImageLockKey(poolName, fp), storageOpCreate)
+	if !joined {
 		var imgerr error
 		ok, _ := storageLVExists(imageLvmDevPath)
 		if !ok {
 			imgerr = s.ImageCreate(fp)
 		}
 
-		lxdStorageMapLock.Lock()
-		if waitChannel, ok := lxdStorageOngoingOperationMap[imageStoragePoolLockID]; ok {
-			close(waitChannel)
-			delete(lxdStorageOngoingOperationMap, imageStoragePoolLockID)
-		}
-		lxdStorageMapLock.Unlock()
+		unlock()
 
 		if imgerr != nil {
 			return imgerr
@@ -739,7 +725,8 @@ func getPrefixedLvName(volumeType string, lvmVolume string) string {
 	return fmt.Sprintf("%s_%s", volumeType, lvmVolume)
 }
 
-func lvmCreateLv(vgName string, thinPoolName string, lvName string, lvFsType string, lvSize string, volumeType string, makeThinLv bool) error {
+// lvmCreateRawLv creates a LV without a filesystem.
+func lvmCreateRawLv(vgName string, thinPoolName string, lvName string, lvSize string, volumeType string, makeThinLv bool) error {
 	var output string
 	var err error
 
@@ -756,19 +743,20 @@ func lvmCreateLv(vgName string, thinPoolName string, lvName string, lvFsType str
 		return fmt.Errorf("Could not create thin LV named %s", lvmPoolVolumeName)
 	}
 
-	fsPath := getLvmDevPath(vgName, volumeType, lvName)
+	return nil
+}
 
-	switch lvFsType {
-	case "xfs":
-		output, err = shared.TryRunCommand("mkfs.xfs", fsPath)
-	default:
-		// default = ext4
-		output, err = shared.TryRunCommand(
-			"mkfs.ext4",
-			"-E", "nodiscard,lazy_itable_init=0,lazy_journal_init=0",
-			fsPath)
+func lvmCreateLv(vgName string, thinPoolName string, lvName string, lvFsType string, lvSize string, volumeType string, makeThinLv bool) error {
+	var output string
+
+	err := lvmCreateRawLv(vgName, thinPoolName, lvName, lvSize, volumeType, makeThinLv)
+	if err != nil {
+		return err
 	}
 
+	fsPath := getLvmDevPath(vgName, volumeType, lvName)
+
+	output, err = makeFSType(fsPath, lvFsType)
 	if err != nil {
 		logger.Errorf("Filesystem creation failed: %s.", output)
 		return fmt.Errorf("Error making filesystem on image LV: %v", err)
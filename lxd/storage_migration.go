@@ -7,6 +7,7 @@ import (
 
 	"github.com/lxc/lxd/lxd/types"
 	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/logger"
 )
 
 // MigrationStorageSourceDriver defines the functions needed to implement a
@@ -33,16 +34,62 @@ type MigrationStorageSourceDriver interface {
 	Cleanup()
 }
 
+// migrationCompressionSource is implemented by the migration source drivers
+// which can compress their streams.
+type migrationCompressionSource interface {
+	/* compress the streams with the algorithm negotiated with the sink,
+	 * at the given level (0 for the default of the algorithm).
+	 */
+	SetCompression(algorithm string, level int)
+}
+
+// migrationChecksumSource is implemented by the migration source drivers
+// which can send the checksums of their streams.
+type migrationChecksumSource interface {
+	/* follow each stream with its checksums and send it again if the
+	 * sink asks for it.
+	 */
+	SetChecksum(checksum bool)
+}
+
+// migrationRsyncSource is implemented by the migration source drivers which
+// send with rsync.
+type migrationRsyncSource interface {
+	/* send with the rsync features negotiated with the sink and the
+	 * extra arguments of the pool.
+	 */
+	SetRsync(features []string, args []string)
+}
+
+// migrationIncrementalSource is implemented by the migration source drivers
+// which can send what changed since their last send while the container is
+// still running, between the pre-dumps of a pre-copy.
+type migrationIncrementalSource interface {
+	SendIncremental(conn *websocket.Conn) error
+}
+
+// migrationRefreshSource is implemented by the migration source drivers which
+// can only send what changed since a snapshot the sink already has, when
+// refreshing an existing copy of the container.
+type migrationRefreshSource interface {
+	SetRefreshBase(base string, guid string) error
+}
+
 type rsyncStorageSourceDriver struct {
 	container container
 	snapshots []container
+
+	// The rsync features negotiated with the sink and the extra
+	// arguments of the pool.
+	features []string
+	args     []string
 }
 
-func (s rsyncStorageSourceDriver) Snapshots() []container {
+func (s *rsyncStorageSourceDriver) Snapshots() []container {
 	return s.snapshots
 }
 
-func (s rsyncStorageSourceDriver) SendWhileRunning(conn *websocket.Conn, op *operation, bwlimit string, containerOnly bool) error {
+func (s *rsyncStorageSourceDriver) SendWhileRunning(conn *websocket.Conn, op *operation, bwlimit string, containerOnly bool) error {
 	ctName, _, _ := containerGetParentAndSnapshotName(s.container.Name())
 
 	if !containerOnly {
@@ -57,7 +104,7 @@ func (s rsyncStorageSourceDriver) SendWhileRunning(conn *websocket.Conn, op *ope
 
 			path := send.Path()
 			wrapper := StorageProgressReader(op, "fs_progress", send.Name())
-			err = RsyncSend(ctName, shared.AddSlash(path), conn, wrapper, bwlimit)
+			err = RsyncSend(ctName, shared.AddSlash(path), conn, wrapper, bwlimit, s.features, s.args...)
 			if err != nil {
 				return err
 			}
@@ -65,19 +112,26 @@ func (s rsyncStorageSourceDriver) SendWhileRunning(conn *websocket.Conn, op *ope
 	}
 
 	wrapper := StorageProgressReader(op, "fs_progress", s.container.Name())
-	return RsyncSend(ctName, shared.AddSlash(s.container.Path()), conn, wrapper, bwlimit)
+	return RsyncSend(ctName, shared.AddSlash(s.container.Path()), conn, wrapper, bwlimit, s.features, s.args...)
 }
 
-func (s rsyncStorageSourceDriver) SendAfterCheckpoint(conn *websocket.Conn, bwlimit string) error {
+func (s *rsyncStorageSourceDriver) SendAfterCheckpoint(conn *websocket.Conn, bwlimit string) error {
 	ctName, _, _ := containerGetParentAndSnapshotName(s.container.Name())
 	// resync anything that changed between our first send and the checkpoint
-	return RsyncSend(ctName, shared.AddSlash(s.container.Path()), conn, nil, bwlimit)
+	return RsyncSend(ctName, shared.AddSlash(s.container.Path()), conn, nil, bwlimit, s.features, s.args...)
 }
 
-func (s rsyncStorageSourceDriver) Cleanup() {
+func (s *rsyncStorageSourceDriver) Cleanup() {
 	// noop
 }
 
+// SetRsync sends with the features negotiated with the sink and the extra
+// arguments of the pool.
+func (s *rsyncStorageSourceDriver) SetRsync(features []string, args []string) {
+	s.features = features
+	s.args = args
+}
+
 func rsyncMigrationSource(c container, containerOnly bool) (MigrationStorageSourceDriver, error) {
 	var err error
 	var snapshots = []container{}
@@ -88,7 +142,7 @@ func rsyncMigrationSource(c container, containerOnly bool) (MigrationStorageSour
 		}
 	}
 
-	return rsyncStorageSourceDriver{c, snapshots}, nil
+	return &rsyncStorageSourceDriver{container: c, snapshots: snapshots}, nil
 }
 
 func snapshotProtobufToContainerArgs(containerName string, snap *Snapshot) containerArgs {
@@ -121,19 +175,30 @@ func snapshotProtobufToContainerArgs(containerName string, snap *Snapshot) conta
 	}
 }
 
-func rsyncMigrationSink(live bool, container container, snapshots []*Snapshot, conn *websocket.Conn, srcIdmap *shared.IdmapSet, op *operation, containerOnly bool) error {
-	ourStart, err := container.StorageStart()
+// migrationSinkSnapshotDelete returns the function deleting a snapshot created
+// by a migration sink, to undo it if the migration fails.
+func migrationSinkSnapshotDelete(snap container) func() {
+	return func() {
+		err := snap.Delete()
+		if err != nil {
+			logger.Errorf("Failed to delete the snapshot \"%s\" of a failed migration: %s.", snap.Name(), err)
+		}
+	}
+}
+
+func rsyncMigrationSink(conn *websocket.Conn, op *operation, args MigrationSinkArgs) error {
+	ourStart, err := args.Container.StorageStart()
 	if err != nil {
 		return err
 	}
 	if ourStart {
-		defer container.StorageStop()
+		defer args.Container.StorageStop()
 	}
 
 	// At this point we have already figured out the parent container's root
 	// disk device so we can simply retrieve it from the expanded devices.
 	parentStoragePool := ""
-	parentExpandedDevices := container.ExpandedDevices()
+	parentExpandedDevices := args.Container.ExpandedDevices()
 	parentLocalRootDiskDeviceKey, parentLocalRootDiskDevice, _ := containerGetRootDiskDevice(parentExpandedDevices)
 	if parentLocalRootDiskDeviceKey != "" {
 		parentStoragePool = parentLocalRootDiskDevice["pool"]
@@ -144,101 +209,119 @@ func rsyncMigrationSink(live bool, container container, snapshots []*Snapshot, c
 		return fmt.Errorf("the container's root device is missing the pool property")
 	}
 
-	isDirBackend := container.Storage().GetStorageType() == storageTypeDir
+	// Delete the snapshots created so far if the migration fails halfway,
+	// newest first, so that it can be retried.
+	revert := true
+	undo := []func(){}
+	defer func() {
+		if !revert {
+			return
+		}
+
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}()
+
+	isDirBackend := args.Container.Storage().GetStorageType() == storageTypeDir
 	if isDirBackend {
-		if !containerOnly {
-			for _, snap := range snapshots {
-				args := snapshotProtobufToContainerArgs(container.Name(), snap)
+		if !args.ContainerOnly {
+			for _, snap := range args.Snapshots {
+				snapArgs := snapshotProtobufToContainerArgs(args.Container.Name(), snap)
 
 				// Ensure that snapshot and parent container have the
 				// same storage pool in their local root disk device.
 				// If the root disk device for the snapshot comes from a
 				// profile on the new instance as well we don't need to
 				// do anything.
-				if args.Devices != nil {
-					snapLocalRootDiskDeviceKey, _, _ := containerGetRootDiskDevice(args.Devices)
+				if snapArgs.Devices != nil {
+					snapLocalRootDiskDeviceKey, _, _ := containerGetRootDiskDevice(snapArgs.Devices)
 					if snapLocalRootDiskDeviceKey != "" {
-						args.Devices[snapLocalRootDiskDeviceKey]["pool"] = parentStoragePool
+						snapArgs.Devices[snapLocalRootDiskDeviceKey]["pool"] = parentStoragePool
 					}
 				}
 
-				s, err := containerCreateEmptySnapshot(container.Daemon(), args)
+				s, err := containerCreateEmptySnapshot(args.Container.Daemon(), snapArgs)
 				if err != nil {
 					return err
 				}
+				undo = append(undo, migrationSinkSnapshotDelete(s))
 
 				wrapper := StorageProgressWriter(op, "fs_progress", s.Name())
-				if err := RsyncRecv(shared.AddSlash(s.Path()), conn, wrapper); err != nil {
+				if err := RsyncRecv(shared.AddSlash(s.Path()), conn, wrapper, args.RsyncFeatures); err != nil {
 					return err
 				}
 
-				err = ShiftIfNecessary(container, srcIdmap)
+				err = ShiftIfNecessary(args.Container, args.Idmap)
 				if err != nil {
 					return err
 				}
 			}
 		}
 
-		wrapper := StorageProgressWriter(op, "fs_progress", container.Name())
-		err = RsyncRecv(shared.AddSlash(container.Path()), conn, wrapper)
+		wrapper := StorageProgressWriter(op, "fs_progress", args.Container.Name())
+		err = RsyncRecv(shared.AddSlash(args.Container.Path()), conn, wrapper, args.RsyncFeatures)
 		if err != nil {
 			return err
 		}
 	} else {
-		if !containerOnly {
-			for _, snap := range snapshots {
-				args := snapshotProtobufToContainerArgs(container.Name(), snap)
+		if !args.ContainerOnly {
+			for _, snap := range args.Snapshots {
+				snapArgs := snapshotProtobufToContainerArgs(args.Container.Name(), snap)
 
 				// Ensure that snapshot and parent container have the
 				// same storage pool in their local root disk device.
 				// If the root disk device for the snapshot comes from a
 				// profile on the new instance as well we don't need to
 				// do anything.
-				if args.Devices != nil {
-					snapLocalRootDiskDeviceKey, _, _ := containerGetRootDiskDevice(args.Devices)
+				if snapArgs.Devices != nil {
+					snapLocalRootDiskDeviceKey, _, _ := containerGetRootDiskDevice(snapArgs.Devices)
 					if snapLocalRootDiskDeviceKey != "" {
-						args.Devices[snapLocalRootDiskDeviceKey]["pool"] = parentStoragePool
+						snapArgs.Devices[snapLocalRootDiskDeviceKey]["pool"] = parentStoragePool
 					}
 				}
 
 				wrapper := StorageProgressWriter(op, "fs_progress", snap.GetName())
-				err := RsyncRecv(shared.AddSlash(container.Path()), conn, wrapper)
+				err := RsyncRecv(shared.AddSlash(args.Container.Path()), conn, wrapper, args.RsyncFeatures)
 				if err != nil {
 					return err
 				}
 
-				err = ShiftIfNecessary(container, srcIdmap)
+				err = ShiftIfNecessary(args.Container, args.Idmap)
 				if err != nil {
 					return err
 				}
 
-				_, err = containerCreateAsSnapshot(container.Daemon(), args, container)
+				s, err := containerCreateAsSnapshot(args.Container.Daemon(), snapArgs, args.Container)
 				if err != nil {
 					return err
 				}
+				undo = append(undo, migrationSinkSnapshotDelete(s))
 			}
 		}
 
-		wrapper := StorageProgressWriter(op, "fs_progress", container.Name())
-		err = RsyncRecv(shared.AddSlash(container.Path()), conn, wrapper)
+		wrapper := StorageProgressWriter(op, "fs_progress", args.Container.Name())
+		err = RsyncRecv(shared.AddSlash(args.Container.Path()), conn, wrapper, args.RsyncFeatures)
 		if err != nil {
 			return err
 		}
 	}
 
-	if live {
+	if args.Live {
 		/* now receive the final sync */
-		wrapper := StorageProgressWriter(op, "fs_progress", container.Name())
-		err := RsyncRecv(shared.AddSlash(container.Path()), conn, wrapper)
+		wrapper := StorageProgressWriter(op, "fs_progress", args.Container.Name())
+		err := RsyncRecv(shared.AddSlash(args.Container.Path()), conn, wrapper, args.RsyncFeatures)
 		if err != nil {
 			return err
 		}
 	}
 
-	err = ShiftIfNecessary(container, srcIdmap)
+	err = ShiftIfNecessary(args.Container, args.Idmap)
 	if err != nil {
 		return err
 	}
 
+	revert = false
+
 	return nil
 }
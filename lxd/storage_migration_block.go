@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/lxc/lxd/shared"
+)
+
+/* Block devices are migrated as a stream of extents which leaves out the
+ * blocks only containing zeros, so that unallocated space is neither sent
+ * over the network nor allocated on a thinly provisioned target.
+ *
+ * The stream starts with blockStreamMagic and the size of the device. Each
+ * extent is its offset and length followed by its data and an empty extent
+ * at the end of the device terminates the stream. All the numbers are big
+ * endian uint64.
+ */
+const blockStreamMagic = "LXDBLOCK"
+
+// Blocks of blockStreamChunkSize are checked for zeros and sent in extents
+// of up to blockStreamExtentSize.
+const blockStreamChunkSize = 64 * 1024
+const blockStreamExtentSize = 4 * 1024 * 1024
+
+var blockStreamZeros = make([]byte, blockStreamChunkSize)
+
+// blockStreamEncode writes the block stream of the size bytes read from r.
+func blockStreamEncode(w io.Writer, r io.Reader, size int64) error {
+	_, err := io.WriteString(w, blockStreamMagic)
+	if err != nil {
+		return err
+	}
+
+	err = binary.Write(w, binary.BigEndian, uint64(size))
+	if err != nil {
+		return err
+	}
+
+	extent := make([]byte, 0, blockStreamExtentSize)
+	extentOffset := int64(0)
+
+	flush := func() error {
+		if len(extent) == 0 {
+			return nil
+		}
+
+		err := binary.Write(w, binary.BigEndian, [2]uint64{uint64(extentOffset), uint64(len(extent))})
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(extent)
+		extent = extent[:0]
+		return err
+	}
+
+	chunk := make([]byte, blockStreamChunkSize)
+	offset := int64(0)
+	for offset < size {
+		if size-offset < int64(len(chunk)) {
+			chunk = chunk[:size-offset]
+		}
+
+		_, err := io.ReadFull(r, chunk)
+		if err != nil {
+			return err
+		}
+
+		if bytes.Equal(chunk, blockStreamZeros[:len(chunk)]) {
+			err = flush()
+			if err != nil {
+				return err
+			}
+		} else {
+			if len(extent) == 0 {
+				extentOffset = offset
+			}
+
+			extent = append(extent, chunk...)
+			if len(extent) >= blockStreamExtentSize {
+				err = flush()
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		offset += int64(len(chunk))
+	}
+
+	err = flush()
+	if err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.BigEndian, [2]uint64{uint64(size), 0})
+}
+
+// blockStreamZero writes zeros between the two offsets.
+func blockStreamZero(w io.WriterAt, from int64, to int64) error {
+	for from < to {
+		length := to - from
+		if length > blockStreamChunkSize {
+			length = blockStreamChunkSize
+		}
+
+		_, err := w.WriteAt(blockStreamZeros[:length], from)
+		if err != nil {
+			return err
+		}
+
+		from += length
+	}
+
+	return nil
+}
+
+// blockStreamDecode writes the extents of a block stream to the target
+// returned by open, which gets the size of the source device. The holes are
+// only filled with zeros if zeroHoles is set, that is if the target doesn't
+// read back zeros where nothing was written.
+func blockStreamDecode(r io.Reader, open func(size int64) (io.WriterAt, error), zeroHoles bool) error {
+	magic := make([]byte, len(blockStreamMagic))
+	_, err := io.ReadFull(r, magic)
+	if err != nil {
+		return err
+	}
+
+	if string(magic) != blockStreamMagic {
+		return fmt.Errorf("Invalid block stream")
+	}
+
+	var size uint64
+	err = binary.Read(r, binary.BigEndian, &size)
+	if err != nil {
+		return err
+	}
+
+	w, err := open(int64(size))
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, blockStreamExtentSize)
+	next := uint64(0)
+	for {
+		var extent [2]uint64
+		err := binary.Read(r, binary.BigEndian, &extent)
+		if err != nil {
+			return err
+		}
+
+		offset, length := extent[0], extent[1]
+		if offset < next || length > blockStreamExtentSize || offset+length > size {
+			return fmt.Errorf("Invalid block stream extent at offset %d", offset)
+		}
+
+		if zeroHoles {
+			err = blockStreamZero(w, int64(next), int64(offset))
+			if err != nil {
+				return err
+			}
+		}
+
+		if length == 0 {
+			if offset != size {
+				return fmt.Errorf("Invalid block stream extent at offset %d", offset)
+			}
+
+			return nil
+		}
+
+		_, err = io.ReadFull(r, data[:length])
+		if err != nil {
+			return err
+		}
+
+		_, err = w.WriteAt(data[:length], int64(offset))
+		if err != nil {
+			return err
+		}
+
+		next = offset + length
+	}
+}
+
+// blockSend sends the block device at path over the websocket.
+func blockSend(path string, conn *websocket.Conn, readWrapper func(io.ReadCloser) io.ReadCloser) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	size, err := f.Seek(0, os.SEEK_END)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Seek(0, os.SEEK_SET)
+	if err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	encodeErr := make(chan error, 1)
+	go func() {
+		err := blockStreamEncode(writer, f, size)
+		writer.CloseWithError(err)
+		encodeErr <- err
+	}()
+
+	readPipe := io.ReadCloser(reader)
+	if readWrapper != nil {
+		readPipe = readWrapper(reader)
+	}
+
+	<-shared.WebsocketSendStream(conn, readPipe, 4*1024*1024)
+
+	// Unblock the encoder if the websocket went away.
+	reader.Close()
+
+	return <-encodeErr
+}
+
+// blockRecv receives a block device from the websocket. The prepare function
+// gets the size of the source device and returns the path of the block
+// device to write to.
+func blockRecv(conn *websocket.Conn, writeWrapper func(io.WriteCloser) io.WriteCloser, prepare func(size int64) (string, error), zeroHoles bool) error {
+	var target *os.File
+	open := func(size int64) (io.WriterAt, error) {
+		path, err := prepare(size)
+		if err != nil {
+			return nil, err
+		}
+
+		target, err = os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		return target, nil
+	}
+
+	reader, writer := io.Pipe()
+	decodeErr := make(chan error, 1)
+	go func() {
+		err := blockStreamDecode(reader, open, zeroHoles)
+		reader.CloseWithError(err)
+		decodeErr <- err
+	}()
+
+	writePipe := io.WriteCloser(writer)
+	if writeWrapper != nil {
+		writePipe = writeWrapper(writer)
+	}
+
+	<-shared.WebsocketRecvStream(writePipe, conn)
+
+	// Let the decoder know if the stream ended early.
+	writer.Close()
+
+	err := <-decodeErr
+	if target != nil {
+		syncErr := target.Sync()
+		target.Close()
+
+		if err == nil {
+			err = syncErr
+		}
+	}
+
+	return err
+}
+
+// storageBlockFilesystem returns the filesystem of a block based storage
+// volume.
+func storageBlockFilesystem(st storage) string {
+	volume := st.GetStoragePoolVolumeWritable()
+	if volume.Config["block.filesystem"] != "" {
+		return volume.Config["block.filesystem"]
+	}
+
+	pool := st.GetStoragePoolWritable()
+	if pool.Config["volume.block.filesystem"] != "" {
+		return pool.Config["volume.block.filesystem"]
+	}
+
+	return "ext4"
+}
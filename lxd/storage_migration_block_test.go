@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+type blockStreamBuffer []byte
+
+func (b blockStreamBuffer) WriteAt(p []byte, off int64) (int, error) {
+	return copy(b[off:], p), nil
+}
+
+func TestBlockStream(t *testing.T) {
+	// Data at the start, in the middle and in an unaligned tail.
+	source := make([]byte, 10*blockStreamChunkSize+100)
+	copy(source, []byte("header"))
+	copy(source[5*blockStreamChunkSize+10:], []byte("middle"))
+	copy(source[len(source)-4:], []byte("tail"))
+
+	stream := bytes.Buffer{}
+	err := blockStreamEncode(&stream, bytes.NewReader(source), int64(len(source)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stream.Len() > 4*blockStreamChunkSize {
+		t.Errorf("Expected the zero blocks to be skipped, got a %d bytes stream", stream.Len())
+	}
+
+	for _, zeroHoles := range []bool{false, true} {
+		target := blockStreamBuffer(bytes.Repeat([]byte{0xff}, len(source)))
+		if !zeroHoles {
+			target = make(blockStreamBuffer, len(source))
+		}
+
+		size := int64(0)
+		open := func(s int64) (io.WriterAt, error) {
+			size = s
+			return target, nil
+		}
+
+		err = blockStreamDecode(bytes.NewReader(stream.Bytes()), open, zeroHoles)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if size != int64(len(source)) {
+			t.Errorf("Expected a size of %d, got %d", len(source), size)
+		}
+
+		if !bytes.Equal(target, source) {
+			t.Errorf("The decoded data doesn't match the source (zeroHoles=%v)", zeroHoles)
+		}
+	}
+}
+
+func TestBlockStreamTruncated(t *testing.T) {
+	source := bytes.Repeat([]byte{0x01}, 2*blockStreamChunkSize)
+
+	stream := bytes.Buffer{}
+	err := blockStreamEncode(&stream, bytes.NewReader(source), int64(len(source)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	open := func(size int64) (io.WriterAt, error) {
+		return make(blockStreamBuffer, size), nil
+	}
+
+	err = blockStreamDecode(bytes.NewReader(stream.Bytes()[:stream.Len()-16]), open, false)
+	if err == nil {
+		t.Error("Expected a truncated stream to fail")
+	}
+}
+
+func TestLvmMigrationType(t *testing.T) {
+	s := &storageLvm{useThinpool: true}
+	if s.MigrationType() != MigrationFSType_BLOCK {
+		t.Errorf("Expected thin pools to send block devices, got %v", s.MigrationType())
+	}
+
+	// The snapshots of a normal LV would go with its removal by the sink.
+	s.useThinpool = false
+	if s.MigrationType() != MigrationFSType_RSYNC {
+		t.Errorf("Expected normal pools to use rsync, got %v", s.MigrationType())
+	}
+}
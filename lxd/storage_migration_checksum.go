@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/lxc/lxd/shared/logger"
+)
+
+/* When negotiated, the end of each filesystem stream is followed by the
+ * sha256 sums of its chunks, sent by the source as a text message on the
+ * filesystem connection. The sink compares them with what it received and
+ * answers with one of the migrationChecksum* replies. On a mismatch, the
+ * source sends the same stream again.
+ */
+const migrationChecksumChunkSize = 4 * 1024 * 1024
+
+// Number of times a stream is sent before the sink gives up on it.
+const migrationChecksumAttempts = 3
+
+const (
+	migrationChecksumOk    = "ok"
+	migrationChecksumRetry = "retry"
+	migrationChecksumAbort = "abort"
+)
+
+type migrationChecksums struct {
+	ChunkSize int64    `json:"chunk_size"`
+	Checksums []string `json:"checksums"`
+}
+
+// migrationChecksummer computes the checksums of the chunks of a stream.
+type migrationChecksummer struct {
+	hash   hash.Hash
+	filled int64
+	sums   []string
+}
+
+func newMigrationChecksummer() *migrationChecksummer {
+	return &migrationChecksummer{hash: sha256.New(), sums: []string{}}
+}
+
+func (c *migrationChecksummer) Write(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) > 0 {
+		size := migrationChecksumChunkSize - c.filled
+		if int64(len(p)) < size {
+			size = int64(len(p))
+		}
+
+		c.hash.Write(p[:size])
+		c.filled += size
+		p = p[size:]
+
+		if c.filled == migrationChecksumChunkSize {
+			c.sums = append(c.sums, hex.EncodeToString(c.hash.Sum(nil)))
+			c.hash.Reset()
+			c.filled = 0
+		}
+	}
+
+	return n, nil
+}
+
+// Checksums returns the checksums of the stream so far, including its last
+// partial chunk.
+func (c *migrationChecksummer) Checksums() migrationChecksums {
+	sums := append([]string{}, c.sums...)
+	if c.filled > 0 {
+		sums = append(sums, hex.EncodeToString(c.hash.Sum(nil)))
+	}
+
+	return migrationChecksums{ChunkSize: migrationChecksumChunkSize, Checksums: sums}
+}
+
+type migrationChecksumReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// migrationChecksumReader feeds what's read from r to the checksummer.
+func migrationChecksumReader(r io.ReadCloser, c *migrationChecksummer) io.ReadCloser {
+	return &migrationChecksumReadCloser{Reader: io.TeeReader(r, c), Closer: r}
+}
+
+type migrationChecksumWriteCloser struct {
+	io.Writer
+	io.Closer
+}
+
+// migrationChecksumWriter feeds what's written to w to the checksummer.
+func migrationChecksumWriter(w io.WriteCloser, c *migrationChecksummer) io.WriteCloser {
+	return &migrationChecksumWriteCloser{Writer: io.MultiWriter(w, c), Closer: w}
+}
+
+// migrationChecksumCompare returns an error describing the first difference
+// between the checksums the source sent and those of what was received.
+func migrationChecksumCompare(expected migrationChecksums, actual migrationChecksums) error {
+	if expected.ChunkSize != actual.ChunkSize {
+		return fmt.Errorf("Unexpected checksum chunk size: %d", expected.ChunkSize)
+	}
+
+	for i, sum := range expected.Checksums {
+		if i >= len(actual.Checksums) {
+			return fmt.Errorf("Stream truncated at chunk %d of %d", i, len(expected.Checksums))
+		}
+
+		if sum != actual.Checksums[i] {
+			return fmt.Errorf("Checksum mismatch at chunk %d (offset %d)", i, int64(i)*expected.ChunkSize)
+		}
+	}
+
+	if len(actual.Checksums) > len(expected.Checksums) {
+		return fmt.Errorf("Received %d chunks instead of %d", len(actual.Checksums), len(expected.Checksums))
+	}
+
+	return nil
+}
+
+func migrationChecksumReadText(conn *websocket.Conn) ([]byte, error) {
+	mt, r, err := conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+
+	if mt != websocket.TextMessage {
+		return nil, fmt.Errorf("Expected a text message")
+	}
+
+	return ioutil.ReadAll(r)
+}
+
+// migrationChecksumSend sends the checksums of a stream which was just sent
+// and returns whether the sink asked for it again.
+func migrationChecksumSend(conn *websocket.Conn, checksums migrationChecksums) (bool, error) {
+	data, err := json.Marshal(checksums)
+	if err != nil {
+		return false, err
+	}
+
+	err = conn.WriteMessage(websocket.TextMessage, data)
+	if err != nil {
+		return false, err
+	}
+
+	reply, err := migrationChecksumReadText(conn)
+	if err != nil {
+		return false, err
+	}
+
+	switch string(reply) {
+	case migrationChecksumOk:
+		return false, nil
+	case migrationChecksumRetry:
+		return true, nil
+	case migrationChecksumAbort:
+		return false, fmt.Errorf("The target failed to receive an intact stream after %d attempts", migrationChecksumAttempts)
+	}
+
+	return false, fmt.Errorf("Unexpected checksum reply: %s", string(reply))
+}
+
+// migrationChecksumVerify checks the checksums of a stream which was just
+// received against those the source sends and answers it, asking for the
+// stream again unless it was the last attempt. It returns whether the stream
+// is intact.
+func migrationChecksumVerify(conn *websocket.Conn, actual migrationChecksums, attempt int) (bool, error) {
+	data, err := migrationChecksumReadText(conn)
+	if err != nil {
+		return false, err
+	}
+
+	expected := migrationChecksums{}
+	err = json.Unmarshal(data, &expected)
+	if err != nil {
+		return false, err
+	}
+
+	reply := migrationChecksumOk
+	mismatch := migrationChecksumCompare(expected, actual)
+	if mismatch != nil {
+		logger.Warnf("Received a corrupted migration stream (attempt %d of %d): %v.", attempt, migrationChecksumAttempts, mismatch)
+
+		reply = migrationChecksumRetry
+		if attempt >= migrationChecksumAttempts {
+			reply = migrationChecksumAbort
+		}
+	}
+
+	err = conn.WriteMessage(websocket.TextMessage, []byte(reply))
+	if err != nil {
+		return false, err
+	}
+
+	return mismatch == nil, nil
+}
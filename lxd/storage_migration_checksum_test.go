@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_migrationChecksummer(t *testing.T) {
+	data := bytes.Repeat([]byte("lxd"), migrationChecksumChunkSize)
+
+	// Feed the data in uneven writes straddling the chunks.
+	c := newMigrationChecksummer()
+	for i := 0; i < len(data); i += 1000003 {
+		end := i + 1000003
+		if end > len(data) {
+			end = len(data)
+		}
+
+		c.Write(data[i:end])
+	}
+
+	whole := newMigrationChecksummer()
+	whole.Write(data)
+
+	sums := c.Checksums()
+	if len(sums.Checksums) != 3 {
+		t.Fatalf("Expected 3 chunks, got %d", len(sums.Checksums))
+	}
+
+	err := migrationChecksumCompare(whole.Checksums(), sums)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data[migrationChecksumChunkSize+1] = 'x'
+	corrupted := newMigrationChecksummer()
+	corrupted.Write(data)
+
+	err = migrationChecksumCompare(sums, corrupted.Checksums())
+	if err == nil {
+		t.Fatal("Expected the corrupted chunk to be detected")
+	}
+
+	truncated := newMigrationChecksummer()
+	truncated.Write(data[:migrationChecksumChunkSize])
+
+	err = migrationChecksumCompare(sums, truncated.Checksums())
+	if err == nil {
+		t.Fatal("Expected the truncated stream to be detected")
+	}
+}
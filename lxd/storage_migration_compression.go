@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// migrationCompressionSupported returns whether the filesystem streams of a
+// migration can be compressed or decompressed with the algorithm. gzip is
+// built in while zstd requires the "zstd" tool.
+func migrationCompressionSupported(algorithm string) bool {
+	switch algorithm {
+	case "gzip":
+		return true
+	case "zstd":
+		_, err := exec.LookPath("zstd")
+		return err == nil
+	}
+
+	return false
+}
+
+// migrationCompressor is the compressed stream of a reader. Closing it
+// stops the compression and returns its error.
+type migrationCompressor struct {
+	*io.PipeReader
+	done chan error
+}
+
+func (c *migrationCompressor) Close() error {
+	c.PipeReader.Close()
+	return <-c.done
+}
+
+// migrationCompressReader compresses what's read from r with the algorithm
+// at the level, zero meaning the default level of the algorithm.
+func migrationCompressReader(r io.ReadCloser, algorithm string, level int) (io.ReadCloser, error) {
+	reader, writer := io.Pipe()
+	c := &migrationCompressor{PipeReader: reader, done: make(chan error, 1)}
+
+	switch algorithm {
+	case "gzip":
+		if level == 0 {
+			level = gzip.DefaultCompression
+		} else if level > gzip.BestCompression {
+			level = gzip.BestCompression
+		}
+
+		gz, err := gzip.NewWriterLevel(writer, level)
+		if err != nil {
+			return nil, err
+		}
+
+		go func() {
+			_, err := io.Copy(gz, r)
+			if err == nil {
+				err = gz.Close()
+			}
+
+			// Unblock the writer of r if the stream was interrupted.
+			r.Close()
+			writer.CloseWithError(err)
+			c.done <- err
+		}()
+	case "zstd":
+		args := []string{"-c", "-q"}
+		if level > 0 {
+			args = append(args, fmt.Sprintf("-%d", level))
+		}
+
+		output := bytes.Buffer{}
+		cmd := exec.Command("zstd", args...)
+		cmd.Stdin = r
+		cmd.Stdout = writer
+		cmd.Stderr = &output
+
+		err := cmd.Start()
+		if err != nil {
+			return nil, err
+		}
+
+		go func() {
+			err := cmd.Wait()
+			err = commandErrorNew(cmd, output.Bytes(), err)
+			r.Close()
+			writer.CloseWithError(err)
+			c.done <- err
+		}()
+	default:
+		return nil, fmt.Errorf("Unsupported compression: %s", algorithm)
+	}
+
+	return c, nil
+}
+
+// migrationDecompressor decompresses what's written to it. Closing it
+// waits for the end of the decompression and returns its error.
+type migrationDecompressor struct {
+	*io.PipeWriter
+	done chan error
+}
+
+func (d *migrationDecompressor) Close() error {
+	d.PipeWriter.Close()
+	return <-d.done
+}
+
+// migrationDecompressWriter writes the decompressed content of a stream
+// compressed with the algorithm to w.
+func migrationDecompressWriter(w io.Writer, algorithm string) (io.WriteCloser, error) {
+	reader, writer := io.Pipe()
+	d := &migrationDecompressor{PipeWriter: writer, done: make(chan error, 1)}
+
+	switch algorithm {
+	case "gzip":
+		go func() {
+			gz, err := gzip.NewReader(reader)
+			if err == nil {
+				_, err = io.Copy(w, gz)
+			}
+
+			// Fail the writes if the decompression stopped early.
+			reader.CloseWithError(err)
+			d.done <- err
+		}()
+	case "zstd":
+		output := bytes.Buffer{}
+		cmd := exec.Command("zstd", "-d", "-c", "-q")
+		cmd.Stdin = reader
+		cmd.Stdout = w
+		cmd.Stderr = &output
+
+		err := cmd.Start()
+		if err != nil {
+			return nil, err
+		}
+
+		go func() {
+			err := cmd.Wait()
+			err = commandErrorNew(cmd, output.Bytes(), err)
+			reader.CloseWithError(err)
+			d.done <- err
+		}()
+	default:
+		return nil, fmt.Errorf("Unsupported compression: %s", algorithm)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestMigrationCompression(t *testing.T) {
+	source := bytes.Repeat([]byte("some rootfs content\n"), 10000)
+
+	compressed, err := migrationCompressReader(ioutil.NopCloser(bytes.NewReader(source)), "gzip", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := ioutil.ReadAll(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = compressed.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stream) >= len(source) {
+		t.Errorf("Expected the stream to be compressed, got %d bytes out of %d", len(stream), len(source))
+	}
+
+	target := bytes.Buffer{}
+	decompressor, err := migrationDecompressWriter(&target, "gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = decompressor.Write(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = decompressor.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(target.Bytes(), source) {
+		t.Error("The decompressed stream doesn't match the source")
+	}
+}
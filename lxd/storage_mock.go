@@ -5,7 +5,6 @@ import (
 
 	"github.com/gorilla/websocket"
 
-	"github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/api"
 	"github.com/lxc/lxd/shared/logger"
 )
@@ -72,6 +71,10 @@ func (s *storageMock) SetStoragePoolWritable(writable *api.StoragePoolPut) {
 	s.pool.StoragePoolPut = *writable
 }
 
+func (s *storageMock) StoragePoolVerify(report *storageVerifyReport, containers []container) error {
+	return nil
+}
+
 func (s *storageMock) SetStoragePoolVolumeWritable(writable *api.StorageVolumePut) {
 	s.volume.StorageVolumePut = *writable
 }
@@ -96,7 +99,35 @@ func (s *storageMock) StoragePoolVolumeUmount() (bool, error) {
 	return true, nil
 }
 
-func (s *storageMock) StoragePoolVolumeUpdate(changedConfig []string) error {
+func (s *storageMock) StoragePoolVolumeUpdate(writable *api.StorageVolumePut, changedConfig []string) error {
+	return nil
+}
+
+func (s *storageMock) StoragePoolVolumeRename(newName string) error {
+	return nil
+}
+
+func (s *storageMock) StoragePoolVolumeGetState() (*api.StorageVolumeState, error) {
+	return &api.StorageVolumeState{}, nil
+}
+
+func (s *storageMock) StoragePoolVolumeCopy(sourceName string, flatten bool) error {
+	return nil
+}
+
+func (s *storageMock) StoragePoolVolumeSnapshotCreate(snapshotName string) error {
+	return nil
+}
+
+func (s *storageMock) StoragePoolVolumeSnapshotDelete(snapshotName string) error {
+	return nil
+}
+
+func (s *storageMock) StoragePoolVolumeSnapshotRename(snapshotName string, newName string) error {
+	return nil
+}
+
+func (s *storageMock) StoragePoolVolumeSnapshotRestore(snapshotName string) error {
 	return nil
 }
 
@@ -130,6 +161,22 @@ func (s *storageMock) ContainerCopy(target container, source container, containe
 	return nil
 }
 
+func (s *storageMock) ContainerRefresh(target container, source container, base container, snapshots []container) error {
+	return nil
+}
+
+func (s *storageMock) ContainerRebuild(container container, imageFingerprint string) error {
+	return nil
+}
+
+func (s *storageMock) ScratchVolumeCreate(container container, device string, size int64) (string, error) {
+	return "", nil
+}
+
+func (s *storageMock) ScratchVolumeDelete(container container, device string) error {
+	return nil
+}
+
 func (s *storageMock) ContainerMount(c container) (bool, error) {
 	return true, nil
 }
@@ -188,6 +235,14 @@ func (s *storageMock) ContainerSnapshotCreateEmpty(snapshotContainer container)
 	return nil
 }
 
+func (s *storageMock) ContainerBackupCreate(backup backupArgs, sourceContainer container, path string) error {
+	return nil
+}
+
+func (s *storageMock) ContainerBackupLoad(info backupInfo, path string) error {
+	return nil
+}
+
 func (s *storageMock) ImageCreate(fingerprint string) error {
 	return nil
 }
@@ -215,6 +270,6 @@ func (s *storageMock) PreservesInodes() bool {
 func (s *storageMock) MigrationSource(container container, containerOnly bool) (MigrationStorageSourceDriver, error) {
 	return nil, fmt.Errorf("not implemented")
 }
-func (s *storageMock) MigrationSink(live bool, container container, snapshots []*Snapshot, conn *websocket.Conn, srcIdmap *shared.IdmapSet, op *operation, containerOnly bool) error {
+func (s *storageMock) MigrationSink(conn *websocket.Conn, op *operation, args MigrationSinkArgs) error {
 	return nil
 }
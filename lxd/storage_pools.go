@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/api"
 	"github.com/lxc/lxd/shared/version"
 )
@@ -21,6 +22,11 @@ func storagePoolsGet(d *Daemon, r *http.Request) Response {
 		recursion = 0
 	}
 
+	selector, err := shared.ParseLabelSelector(r.FormValue("labels"))
+	if err != nil {
+		return BadRequest(err)
+	}
+
 	pools, err := dbStoragePools(d.db)
 	if err != nil && err != NoSuchObjectError {
 		return SmartError(err)
@@ -29,13 +35,18 @@ func storagePoolsGet(d *Daemon, r *http.Request) Response {
 	resultString := []string{}
 	resultMap := []api.StoragePool{}
 	for _, pool := range pools {
+		plID, pl, err := dbStoragePoolGet(d.db, pool)
+		if err != nil {
+			continue
+		}
+
+		if !selector.Matches(pl.Labels) {
+			continue
+		}
+
 		if recursion == 0 {
 			resultString = append(resultString, fmt.Sprintf("/%s/storage-pools/%s", version.APIVersion, pool))
 		} else {
-			plID, pl, err := dbStoragePoolGet(d.db, pool)
-			if err != nil {
-				continue
-			}
 
 			// Get all users of the storage pool.
 			poolUsedBy, err := storagePoolUsedByGet(d.db, plID, pool)
@@ -43,6 +54,7 @@ func storagePoolsGet(d *Daemon, r *http.Request) Response {
 				return SmartError(err)
 			}
 			pl.UsedBy = poolUsedBy
+			pl.Status = storagePoolStatus(pl)
 
 			resultMap = append(resultMap, *pl)
 		}
@@ -75,11 +87,21 @@ func storagePoolsPost(d *Daemon, r *http.Request) Response {
 		return BadRequest(fmt.Errorf("No driver provided"))
 	}
 
+	err = shared.ValidLabels(req.Labels)
+	if err != nil {
+		return BadRequest(err)
+	}
+
 	err = storagePoolCreateInternal(d, req.Name, req.Description, req.Driver, req.Config)
 	if err != nil {
 		return InternalError(err)
 	}
 
+	err = storagePoolLabelsUpdate(d, req.Name, req.Labels)
+	if err != nil {
+		return InternalError(err)
+	}
+
 	return SyncResponseLocation(true, nil, fmt.Sprintf("/%s/storage-pools/%s", version.APIVersion, req.Name))
 }
 
@@ -102,6 +124,7 @@ func storagePoolGet(d *Daemon, r *http.Request) Response {
 		return SmartError(err)
 	}
 	pool.UsedBy = poolUsedBy
+	pool.Status = storagePoolStatus(pool)
 
 	etag := []interface{}{pool.Name, pool.Driver, pool.Config}
 
@@ -138,11 +161,21 @@ func storagePoolPut(d *Daemon, r *http.Request) Response {
 		return BadRequest(err)
 	}
 
+	err = shared.ValidLabels(req.Labels)
+	if err != nil {
+		return BadRequest(err)
+	}
+
 	err = storagePoolUpdate(d, poolName, req.Description, req.Config)
 	if err != nil {
 		return InternalError(err)
 	}
 
+	err = storagePoolLabelsUpdate(d, poolName, req.Labels)
+	if err != nil {
+		return InternalError(err)
+	}
+
 	return EmptySyncResponse
 }
 
@@ -182,17 +215,37 @@ func storagePoolPatch(d *Daemon, r *http.Request) Response {
 		}
 	}
 
+	// Labels stacking
+	if req.Labels != nil {
+		for k, v := range dbInfo.Labels {
+			_, ok := req.Labels[k]
+			if !ok {
+				req.Labels[k] = v
+			}
+		}
+	}
+
 	// Validate the configuration
 	err = storagePoolValidateConfig(poolName, dbInfo.Driver, req.Config)
 	if err != nil {
 		return BadRequest(err)
 	}
 
+	err = shared.ValidLabels(req.Labels)
+	if err != nil {
+		return BadRequest(err)
+	}
+
 	err = storagePoolUpdate(d, poolName, req.Description, req.Config)
 	if err != nil {
 		return InternalError(fmt.Errorf("failed to update the storage pool configuration"))
 	}
 
+	err = storagePoolLabelsUpdate(d, poolName, req.Labels)
+	if err != nil {
+		return InternalError(err)
+	}
+
 	return EmptySyncResponse
 }
 
@@ -206,20 +259,61 @@ func storagePoolDelete(d *Daemon, r *http.Request) Response {
 		return NotFound
 	}
 
-	// Check if the storage pool has any volumes associated with it, if so
-	// error out.
-	volumeCount, err := dbStoragePoolVolumesGetNames(d.db, poolID)
-	if volumeCount > 0 {
-		return BadRequest(fmt.Errorf("storage pool \"%s\" has volumes attached to it", poolName))
+	// Containers, custom volumes and profiles using the storage pool
+	// always prevent its deletion, the images cached on it only unless
+	// forced, in which case they're removed first so that no dataset is
+	// left behind.
+	force := shared.IsTrue(r.FormValue("force"))
+
+	usedBy, err := storagePoolUsedByGet(d.db, poolID, poolName)
+	if err != nil {
+		return SmartError(err)
 	}
 
-	// Check if the storage pool is still referenced in any profiles.
-	profiles, err := profilesUsingPoolGetNames(d.db, poolName)
+	customVolumes, err := dbStoragePoolVolumesGetType(d.db, storagePoolVolumeTypeCustom, poolID)
 	if err != nil {
 		return SmartError(err)
 	}
-	if len(profiles) > 0 {
-		return BadRequest(fmt.Errorf("Storage pool \"%s\" has profiles using it:\n%s", poolName, strings.Join(profiles, "\n")))
+
+	for _, volume := range customVolumes {
+		usedBy = append(usedBy, fmt.Sprintf("/%s/storage-pools/%s/volumes/custom/%s", version.APIVersion, poolName, volume))
+	}
+
+	blockers := []string{}
+	for _, url := range usedBy {
+		if force && strings.HasPrefix(url, fmt.Sprintf("/%s/images/", version.APIVersion)) {
+			continue
+		}
+
+		blockers = append(blockers, url)
+	}
+
+	if len(blockers) > 0 {
+		return BadRequest(storageDeleteBlocked(poolName, "", blockers, !force))
+	}
+
+	images, err := dbStoragePoolVolumesGetType(d.db, storagePoolVolumeTypeImage, poolID)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	if dryRunRequested(r) {
+		plan, err := storagePoolDeletePlan(d, poolName, images)
+		if err != nil {
+			return SmartError(err)
+		}
+
+		return SyncResponse(true, plan)
+	}
+
+	storageFreezeEnter()
+	defer storageFreezeLeave()
+
+	for _, fingerprint := range images {
+		err = doDeleteImageFromPool(d, fingerprint, poolName)
+		if err != nil {
+			return SmartError(errorWrapf(err, "Failed to remove image \"%s\" from the storage pool: %s", fingerprint, err))
+		}
 	}
 
 	s, err := storagePoolInit(d, poolName)
@@ -227,9 +321,12 @@ func storagePoolDelete(d *Daemon, r *http.Request) Response {
 		return InternalError(err)
 	}
 
+	audit := storageAuditStart(r, poolName, "pool_delete", poolName)
+	s.StorageAuditSet(audit)
 	err = s.StoragePoolDelete()
+	audit.finish(d, err)
 	if err != nil {
-		return InternalError(err)
+		return SmartError(err)
 	}
 
 	err = dbStoragePoolDelete(d.db, poolName)
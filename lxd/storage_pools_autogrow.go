@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// storagePoolAutoGrowInterval is how often the free space of the storage
+// pools with "size.auto_grow" set is checked.
+const storagePoolAutoGrowInterval = 5 * time.Minute
+
+// The defaults of "size.auto_grow.increment" and "size.auto_grow.threshold".
+const storagePoolAutoGrowIncrement = "5GB"
+const storagePoolAutoGrowThreshold = 10
+
+// storagePoolLoopFile returns the loop file LXD creates for a storage pool
+// when no source is given.
+func storagePoolLoopFile(poolName string) string {
+	return shared.VarPath("disks", fmt.Sprintf("%s.img", poolName))
+}
+
+// storagePoolAutoGrowSize returns the size the loop file of a storage pool
+// should be grown to, 0 if it's not to be grown. The pool is grown by
+// increment bytes once less than threshold percent of it is free, up to max
+// bytes unless max is 0.
+func storagePoolAutoGrowSize(used uint64, total uint64, size int64, increment int64, max int64, threshold int) int64 {
+	if total == 0 || increment <= 0 {
+		return 0
+	}
+
+	free := uint64(0)
+	if total > used {
+		free = total - used
+	}
+
+	if free*100 >= total*uint64(threshold) {
+		return 0
+	}
+
+	newSize := size + increment
+	if max > 0 && newSize > max {
+		newSize = max
+	}
+
+	if newSize <= size {
+		return 0
+	}
+
+	return newSize
+}
+
+// storagePoolAutoGrow grows the loop file of a storage pool if it's running
+// out of space, and the pool along with it.
+func storagePoolAutoGrow(d *Daemon, pool *api.StoragePool) error {
+	source := pool.Config["source"]
+	if source != storagePoolLoopFile(pool.Name) {
+		return nil
+	}
+
+	increment, err := shared.ParseByteSizeString(pool.Config["size.auto_grow.increment"])
+	if err != nil {
+		return err
+	}
+
+	if increment == 0 {
+		increment, _ = shared.ParseByteSizeString(storagePoolAutoGrowIncrement)
+	}
+
+	max, err := shared.ParseByteSizeString(pool.Config["size.auto_grow.max"])
+	if err != nil {
+		return err
+	}
+
+	threshold := storagePoolAutoGrowThreshold
+	if pool.Config["size.auto_grow.threshold"] != "" {
+		threshold, err = strconv.Atoi(pool.Config["size.auto_grow.threshold"])
+		if err != nil {
+			return err
+		}
+	}
+
+	used, total, err := storagePoolUsageGet(d, pool.Name)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	size := storagePoolAutoGrowSize(used, total, info.Size(), increment, max, threshold)
+	if size == 0 {
+		return nil
+	}
+
+	// The loop file is sparse, make sure the host can hold what's added.
+	fs := syscall.Statfs_t{}
+	err = syscall.Statfs(filepath.Dir(source), &fs)
+	if err != nil {
+		return err
+	}
+
+	if fs.Bavail*uint64(fs.Bsize) < uint64(size-info.Size()) {
+		return fmt.Errorf("Not enough space left on the host to grow the loop file to %s", shared.GetByteSizeString(size, 2))
+	}
+
+	err = os.Truncate(source, size)
+	if err != nil {
+		return err
+	}
+
+	switch pool.Driver {
+	case "zfs":
+		zpool := pool.Config["zfs.pool_name"]
+		if zpool == "" {
+			zpool = pool.Name
+		}
+
+		output, err := shared.RunCommand("zpool", "online", "-e", strings.SplitN(zpool, "/", 2)[0], source)
+		if err != nil {
+			return fmt.Errorf("Failed to expand the ZFS pool: %s", output)
+		}
+	case "btrfs":
+		output, err := shared.RunCommand("losetup", "-j", source)
+		if err != nil {
+			return fmt.Errorf("Failed to find the loop device of %s: %s", source, output)
+		}
+
+		loopDev := strings.SplitN(strings.TrimSpace(output), ":", 2)[0]
+		if loopDev == "" {
+			return fmt.Errorf("No loop device is attached to %s", source)
+		}
+
+		output, err = shared.RunCommand("losetup", "-c", loopDev)
+		if err != nil {
+			return fmt.Errorf("Failed to refresh the size of %s: %s", loopDev, output)
+		}
+
+		output, err = shared.RunCommand("btrfs", "filesystem", "resize", "max", getStoragePoolMountPoint(pool.Name))
+		if err != nil {
+			return fmt.Errorf("Failed to resize the btrfs filesystem: %s", output)
+		}
+	default:
+		return fmt.Errorf("\"%s\" storage pools can't be grown", pool.Driver)
+	}
+
+	previousSize := pool.Config["size"]
+	pool.Config["size"] = fmt.Sprintf("%dB", size)
+	err = dbStoragePoolUpdate(d.db, pool.Name, pool.Description, pool.Config)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Grew storage pool", log.Ctx{"pool": pool.Name, "size": pool.Config["size"]})
+	eventSend("storage", shared.Jmap{
+		"action":        "pool-grown",
+		"pool":          pool.Name,
+		"size":          pool.Config["size"],
+		"previous_size": previousSize,
+	})
+
+	return nil
+}
+
+// storagePoolsAutoGrowCheck grows the loop-backed storage pools with
+// "size.auto_grow" set which are running out of space.
+func storagePoolsAutoGrowCheck(d *Daemon) {
+	pools, err := dbStoragePools(d.db)
+	if err != nil {
+		if err != NoSuchObjectError {
+			logger.Error("Unable to retrieve the list of storage pools", log.Ctx{"err": err})
+		}
+		return
+	}
+
+	for _, poolName := range pools {
+		_, pool, err := dbStoragePoolGet(d.db, poolName)
+		if err != nil || !shared.IsTrue(pool.Config["size.auto_grow"]) {
+			continue
+		}
+
+		err = storagePoolAutoGrow(d, pool)
+		if err != nil {
+			logger.Error("Failed to grow storage pool", log.Ctx{"pool": poolName, "err": err})
+		}
+	}
+}
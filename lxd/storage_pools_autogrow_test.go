@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestStoragePoolAutoGrowSize(t *testing.T) {
+	gb := int64(1024 * 1024 * 1024)
+
+	tests := []struct {
+		used      uint64
+		total     uint64
+		size      int64
+		max       int64
+		threshold int
+		expected  int64
+	}{
+		// Enough free space left.
+		{used: 5, total: 10, size: 10 * gb, threshold: 10, expected: 0},
+		// Grown by the increment.
+		{used: 95, total: 100, size: 10 * gb, threshold: 10, expected: 15 * gb},
+		// Grown up to the maximum.
+		{used: 95, total: 100, size: 10 * gb, max: 12 * gb, threshold: 10, expected: 12 * gb},
+		// Already at the maximum.
+		{used: 95, total: 100, size: 12 * gb, max: 12 * gb, threshold: 10, expected: 0},
+		// Full.
+		{used: 100, total: 100, size: 10 * gb, threshold: 10, expected: 15 * gb},
+		// Nothing known about the pool.
+		{used: 0, total: 0, size: 10 * gb, threshold: 10, expected: 0},
+	}
+
+	for i, test := range tests {
+		size := storagePoolAutoGrowSize(test.used, test.total, test.size, 5*gb, test.max, test.threshold)
+		if size != test.expected {
+			t.Errorf("Test %d: expected %d, got %d", i, test.expected, size)
+		}
+	}
+}
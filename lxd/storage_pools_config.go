@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -10,6 +11,31 @@ import (
 )
 
 var storagePoolConfigKeys = map[string]func(value string) error{
+	// valid drivers: block
+	"block.devices": func(value string) error {
+		for _, device := range strings.Split(value, ",") {
+			device = strings.TrimSpace(device)
+			if device != "" && !filepath.IsAbs(device) {
+				return fmt.Errorf("Block devices must be given as absolute paths: %s", device)
+			}
+		}
+
+		return nil
+	},
+	"block.iscsi.portal": shared.IsAny,
+	"block.iscsi.target": shared.IsAny,
+
+	// valid drivers: external
+	"external.driver": func(value string) error {
+		if value == "" || strings.Contains(value, "/") || value == "." || value == ".." {
+			return fmt.Errorf("Invalid external storage driver name: %s", value)
+		}
+
+		return nil
+	},
+	// Any other "external.*" key is handed to the external driver as is,
+	// see storagePoolConfigValidator.
+
 	// valid drivers: btrfs
 	// (Note, that we can't be smart in detecting mount options since a lot
 	// of filesystems come with their own additional ones (e.g.
@@ -17,6 +43,9 @@ var storagePoolConfigKeys = map[string]func(value string) error{
 	// shared.IsAny() must do.)
 	"btrfs.mount_options": shared.IsAny,
 
+	// valid drivers: dir
+	"dir.mount_options": shared.IsAny,
+
 	// valid drivers: lvm
 	"lvm.thinpool_name": shared.IsAny,
 	"lvm.use_thinpool":  shared.IsBool,
@@ -32,15 +61,62 @@ var storagePoolConfigKeys = map[string]func(value string) error{
 		return err
 	},
 
-	// valid drivers: btrfs, dir, lvm, zfs
+	// valid drivers: btrfs, zfs (loop-backed)
+	"size.auto_grow": shared.IsBool,
+	"size.auto_grow.increment": func(value string) error {
+		if value == "" {
+			return nil
+		}
+
+		_, err := shared.ParseByteSizeString(value)
+		return err
+	},
+	"size.auto_grow.max": func(value string) error {
+		if value == "" {
+			return nil
+		}
+
+		_, err := shared.ParseByteSizeString(value)
+		return err
+	},
+	"size.auto_grow.threshold": func(value string) error {
+		if value == "" {
+			return nil
+		}
+
+		threshold, err := strconv.Atoi(value)
+		if err != nil || threshold < 1 || threshold > 99 {
+			return fmt.Errorf("Invalid percentage, must be between 1 and 99: %s", value)
+		}
+
+		return nil
+	},
+
+	// valid drivers: all ("hard" mode: zfs)
+	"quota.mode": func(value string) error {
+		return shared.IsOneOf(value, []string{"soft", "hard"})
+	},
+	"quota.size": func(value string) error {
+		if value == "" {
+			return nil
+		}
+
+		_, err := shared.ParseByteSizeString(value)
+		return err
+	},
+
+	// valid drivers: block, btrfs, dir, lvm, zfs
 	"source": shared.IsAny,
 
-	// valid drivers: lvm
+	// valid drivers: block, lvm
 	"volume.block.filesystem": func(value string) error {
 		return shared.IsOneOf(value, []string{"ext4", "xfs"})
 	},
 	"volume.block.mount_options": shared.IsAny,
 
+	// valid drivers: all
+	"volume.shared": shared.IsBool,
+
 	// valid drivers: lvm
 	"volume.size": func(value string) error {
 		if value == "" {
@@ -52,13 +128,97 @@ var storagePoolConfigKeys = map[string]func(value string) error{
 	},
 
 	// valid drivers: zfs
-	"volume.zfs.remove_snapshots": shared.IsBool,
-	"volume.zfs.use_refquota":     shared.IsBool,
+	// Any "zfs.*" storage volume key can be given a default for the new
+	// volumes of the pool as "volume.zfs.*", see storagePoolConfigValidator.
 
 	// valid drivers: zfs
-	"zfs.clone_copy": shared.IsBool,
-	"zfs.pool_name":  shared.IsAny,
-	"rsync.bwlimit":  shared.IsAny,
+	"zfs.clone_copy":    shared.IsBool,
+	"zfs.dataset_cache": shared.IsBool,
+	"zfs.pool_guid":     shared.IsAny,
+	"zfs.pool_name":     shared.IsAny,
+	"zfs.copy.parallelism": func(value string) error {
+		if value == "" {
+			return nil
+		}
+
+		parallelism, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+
+		if parallelism < 1 {
+			return fmt.Errorf("Invalid value for an integer greater than 0: %s", value)
+		}
+
+		return nil
+	},
+	"zfs.images.quota": func(value string) error {
+		if value == "" {
+			return nil
+		}
+
+		_, err := shared.ParseByteSizeString(value)
+		return err
+	},
+	"zfs.migration.checksum": shared.IsBool,
+	"zfs.migration.compression": func(value string) error {
+		return shared.IsOneOf(value, []string{"none", "gzip", "zstd"})
+	},
+	"zfs.migration.compression_level": func(value string) error {
+		if value == "" {
+			return nil
+		}
+
+		level, err := strconv.Atoi(value)
+		if err != nil || level < 1 || level > 19 {
+			return fmt.Errorf("Invalid compression level, must be between 1 and 19: %s", value)
+		}
+
+		return nil
+	},
+	"rsync.bwlimit":     shared.IsAny,
+	"rsync.compression": shared.IsBool,
+	"rsync.checksum":    shared.IsBool,
+	"rsync.args": func(value string) error {
+		for _, arg := range strings.Fields(value) {
+			if !strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("Invalid rsync argument \"%s\", only options are allowed", arg)
+			}
+
+			name := strings.SplitN(arg, "=", 2)[0]
+			if shared.StringInSlice(name, []string{"-e", "--rsh", "--server", "--sender", "--daemon"}) {
+				return fmt.Errorf("The rsync option \"%s\" can't be set", name)
+			}
+		}
+
+		return nil
+	},
+}
+
+// storagePoolRsyncKeys are the storage pool keys used by rsync, which never
+// require on-disk changes.
+var storagePoolRsyncKeys = []string{"rsync.bwlimit", "rsync.compression", "rsync.checksum", "rsync.args"}
+
+// storagePoolConfigValidator returns the validator of a storage pool config
+// key. The "volume.zfs.*" defaults are validated like the storage volume keys
+// they stand for, while the "external.*" keys are left to the external
+// driver.
+func storagePoolConfigValidator(key string) (func(value string) error, bool) {
+	validator, ok := storagePoolConfigKeys[key]
+	if ok {
+		return validator, true
+	}
+
+	if strings.HasPrefix(key, "volume.zfs.") {
+		validator, ok = storageVolumeConfigKeys[strings.TrimPrefix(key, "volume.")]
+		return validator, ok
+	}
+
+	if strings.HasPrefix(key, "external.") {
+		return shared.IsAny, true
+	}
+
+	return nil, false
 }
 
 func storagePoolValidateConfig(name string, driver string, config map[string]string) error {
@@ -76,6 +236,14 @@ func storagePoolValidateConfig(name string, driver string, config map[string]str
 		}
 	}
 
+	if driver != "zfs" && config["quota.mode"] == "hard" {
+		return fmt.Errorf("the \"hard\" quota mode can only be used with ZFS storage pools")
+	}
+
+	if driver == "external" && config["external.driver"] == "" {
+		return fmt.Errorf("the key external.driver is required for EXTERNAL storage pools")
+	}
+
 	v, ok := config["rsync.bwlimit"]
 	if ok && v != "" {
 		_, err := shared.ParseByteSizeString(v)
@@ -93,14 +261,46 @@ func storagePoolValidateConfig(name string, driver string, config map[string]str
 		}
 
 		prfx := strings.HasPrefix
-		if driver == "dir" {
+		if driver == "dir" || driver == "block" {
 			if key == "size" {
 				return fmt.Errorf("the key %s cannot be used with %s storage pools", key, strings.ToUpper(driver))
 			}
 		}
 
+		if driver != "dir" && prfx(key, "dir.") {
+			return fmt.Errorf("the key %s cannot be used with %s storage pools", key, strings.ToUpper(driver))
+		}
+
+		if prfx(key, "size.auto_grow") {
+			if driver != "btrfs" && driver != "zfs" {
+				return fmt.Errorf("the key %s cannot be used with %s storage pools", key, strings.ToUpper(driver))
+			}
+
+			if config["source"] != "" && config["source"] != storagePoolLoopFile(name) {
+				return fmt.Errorf("the key %s can only be used with loop-backed storage pools", key)
+			}
+		}
+
 		if driver != "lvm" {
-			if prfx(key, "lvm.") || prfx(key, "volume.block.") || key == "volume.size" {
+			if prfx(key, "lvm.") || key == "volume.size" {
+				return fmt.Errorf("the key %s cannot be used with %s storage pools", key, strings.ToUpper(driver))
+			}
+		}
+
+		if driver != "lvm" && driver != "block" {
+			if prfx(key, "volume.block.") {
+				return fmt.Errorf("the key %s cannot be used with %s storage pools", key, strings.ToUpper(driver))
+			}
+		}
+
+		if driver != "block" {
+			if prfx(key, "block.") {
+				return fmt.Errorf("the key %s cannot be used with %s storage pools", key, strings.ToUpper(driver))
+			}
+		}
+
+		if driver != "external" {
+			if prfx(key, "external.") {
 				return fmt.Errorf("the key %s cannot be used with %s storage pools", key, strings.ToUpper(driver))
 			}
 		}
@@ -112,7 +312,7 @@ func storagePoolValidateConfig(name string, driver string, config map[string]str
 		}
 
 		// Validate storage pool config keys.
-		validator, ok := storagePoolConfigKeys[key]
+		validator, ok := storagePoolConfigValidator(key)
 		if !ok {
 			return fmt.Errorf("Invalid storage pool configuration key: %s", key)
 		}
@@ -127,7 +327,8 @@ func storagePoolValidateConfig(name string, driver string, config map[string]str
 }
 
 func storagePoolFillDefault(name string, driver string, config map[string]string) error {
-	if driver != "dir" {
+	// External drivers decide on their own how large the pool is.
+	if driver != "dir" && driver != "block" && driver != "external" {
 		if config["size"] == "" {
 			st := syscall.Statfs_t{}
 			err := syscall.Statfs(shared.VarPath(), &st)
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestStoragePoolConfigValidator(t *testing.T) {
+	valid := map[string]string{
+		"block.devices":           "/dev/sdb,/dev/sdc",
+		"quota.mode":              "hard",
+		"quota.size":              "100GB",
+		"rsync.args":              "--exclude=/tmp -W",
+		"rsync.compression":       "true",
+		"volume.zfs.sync":         "always",
+		"volume.zfs.reservation":  "1GB",
+		"volume.zfs.use_refquota": "true",
+		"zfs.clone_copy":          "false",
+		"zfs.images.quota":        "10GB",
+	}
+
+	for key, value := range valid {
+		validator, ok := storagePoolConfigValidator(key)
+		if !ok {
+			t.Errorf("Expected %s to be a valid key", key)
+			continue
+		}
+
+		err := validator(value)
+		if err != nil {
+			t.Errorf("Expected %s=%s to be valid: %v", key, value, err)
+		}
+	}
+
+	validator, ok := storagePoolConfigValidator("volume.zfs.sync")
+	if !ok || validator("sometimes") == nil {
+		t.Errorf("Expected volume.zfs.sync=sometimes to be rejected")
+	}
+
+	validator, ok = storagePoolConfigValidator("rsync.args")
+	if !ok || validator("--rsh=ssh") == nil || validator("/etc") == nil {
+		t.Errorf("Expected rsync.args to reject remote shells and paths")
+	}
+
+	validator, ok = storagePoolConfigValidator("zfs.images.quota")
+	if !ok || validator("lots") == nil {
+		t.Errorf("Expected zfs.images.quota=lots to be rejected")
+	}
+
+	validator, ok = storagePoolConfigValidator("block.devices")
+	if !ok || validator("/dev/sdb,sdc") == nil {
+		t.Errorf("Expected block.devices=/dev/sdb,sdc to be rejected")
+	}
+
+	for _, key := range []string{"volume.zfs.unknown", "volume.shared.zfs", "zfs.sync"} {
+		_, ok := storagePoolConfigValidator(key)
+		if ok {
+			t.Errorf("Expected %s to be an invalid key", key)
+		}
+	}
+}
+
+func TestStoragePoolValidateConfigQuota(t *testing.T) {
+	err := storagePoolValidateConfig("pool1", "zfs", map[string]string{"quota.size": "100GB", "quota.mode": "hard"})
+	if err != nil {
+		t.Errorf("Expected the hard quota mode to be valid on ZFS: %v", err)
+	}
+
+	err = storagePoolValidateConfig("pool1", "dir", map[string]string{"quota.size": "100GB", "quota.mode": "hard"})
+	if err == nil {
+		t.Error("Expected the hard quota mode to be rejected on DIR")
+	}
+}
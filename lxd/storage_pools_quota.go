@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+)
+
+/* The "quota.size" of a storage pool caps the sum of the quotas of its
+ * containers and custom volumes: the "size" of the root disk device of the
+ * containers and the "size" of the custom volumes. The creations which would
+ * take the pool over it are rejected. In "hard" mode, the volumes also count
+ * what they actually use when it's more than their quota, as reported by the
+ * ZFS driver, so that volumes without a quota count as well.
+ */
+
+// storagePoolQuotaVolumeSize returns the quota of a container or custom
+// volume, 0 if it has none.
+func storagePoolQuotaVolumeSize(d *Daemon, volume *api.StorageVolume) (int64, error) {
+	size := volume.Config["size"]
+
+	if volume.Type == storagePoolVolumeTypeNameContainer {
+		c, err := containerLoadByName(d, volume.Name)
+		if err != nil {
+			return 0, err
+		}
+
+		_, rootDiskDevice, err := containerGetRootDiskDevice(c.ExpandedDevices())
+		if err == nil && rootDiskDevice["size"] != "" {
+			size = rootDiskDevice["size"]
+		}
+	}
+
+	if size == "" {
+		return 0, nil
+	}
+
+	return shared.ParseByteSizeString(size)
+}
+
+// storagePoolQuotaUsage returns the space used by the containers and custom
+// volumes of a ZFS storage pool, keyed by "<volume type>/<name>".
+func storagePoolQuotaUsage(d *Daemon, poolName string) (map[string]int64, error) {
+	st, err := storagePoolInit(d, poolName)
+	if err != nil {
+		return nil, err
+	}
+
+	s, ok := storageUnwrap(st).(*storageZfs)
+	if !ok {
+		return nil, fmt.Errorf("The storage pool \"%s\" doesn't report the space used by its volumes", poolName)
+	}
+
+	usage := map[string]int64{}
+	for volumeType, path := range map[string]string{storagePoolVolumeTypeNameContainer: "containers", storagePoolVolumeTypeNameCustom: "custom"} {
+		if !s.zfsFilesystemEntityExists(path, true) {
+			continue
+		}
+
+		datasets, err := s.zfsPoolVolumeGetAll(path, "used")
+		if err != nil {
+			return nil, err
+		}
+
+		for name, properties := range datasets {
+			if strings.Contains(name, "@") || filepath.Dir(name) != path {
+				continue
+			}
+
+			used, err := strconv.ParseInt(properties["used"], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+
+			usage[volumeType+"/"+name[len(path)+1:]] = used
+		}
+	}
+
+	return usage, nil
+}
+
+// storagePoolQuotaCheck fails with a StorageErrQuotaExceeded error if a new
+// volume with a quota of size would take a storage pool over its
+// "quota.size".
+func storagePoolQuotaCheck(d *Daemon, poolName string, size int64) error {
+	poolID, pool, err := dbStoragePoolGet(d.db, poolName)
+	if err != nil {
+		return err
+	}
+
+	if pool.Config["quota.size"] == "" {
+		return nil
+	}
+
+	limit, err := shared.ParseByteSizeString(pool.Config["quota.size"])
+	if err != nil {
+		return err
+	}
+
+	volumes, err := dbStoragePoolVolumesGet(d.db, poolID, []int{storagePoolVolumeTypeContainer, storagePoolVolumeTypeCustom})
+	if err != nil && err != NoSuchObjectError {
+		return err
+	}
+
+	usage := map[string]int64{}
+	if pool.Config["quota.mode"] == "hard" {
+		usage, err = storagePoolQuotaUsage(d, poolName)
+		if err != nil {
+			return err
+		}
+	}
+
+	total := size
+	for _, volume := range volumes {
+		// The snapshots of the containers share their quota.
+		if shared.IsSnapshot(volume.Name) {
+			continue
+		}
+
+		volumeSize, err := storagePoolQuotaVolumeSize(d, volume)
+		if err != nil {
+			return err
+		}
+
+		used := usage[volume.Type+"/"+volume.Name]
+		if used > volumeSize {
+			volumeSize = used
+		}
+
+		total += volumeSize
+	}
+
+	if total > limit {
+		return storageError{
+			kind: StorageErrQuotaExceeded,
+			msg:  fmt.Sprintf("The volumes of the storage pool \"%s\" would take %s, over its quota of %s", poolName, shared.GetByteSizeString(total, 2), pool.Config["quota.size"]),
+		}
+	}
+
+	return nil
+}
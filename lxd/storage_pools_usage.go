@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// storagePoolUsageInterval is how often the space used on the storage pools
+// is sampled.
+const storagePoolUsageInterval = time.Hour
+
+// storagePoolUsageSamples is how many samples are kept for each storage
+// pool, a week worth of them.
+const storagePoolUsageSamples = 168
+
+type storagePoolUsageSample struct {
+	time  time.Time
+	used  uint64
+	total uint64
+}
+
+var storagePoolUsageLock sync.Mutex
+var storagePoolUsageHistory = map[string][]storagePoolUsageSample{}
+
+// storagePoolUsageWarned records the storage pools a forecast warning was
+// sent for, so that it's only sent again once the forecast recovered.
+var storagePoolUsageWarned = map[string]bool{}
+
+// storagePoolUsageParse parses the two sizes, in bytes, printed by "zfs get"
+// and "vgs" on separate lines or separated by sep.
+func storagePoolUsageParse(output string, sep string) (uint64, uint64, error) {
+	fields := strings.FieldsFunc(output, func(r rune) bool {
+		return r == '\n' || strings.ContainsRune(sep, r)
+	})
+
+	values := []uint64{}
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Unexpected size: %s", field)
+		}
+
+		values = append(values, value)
+	}
+
+	if len(values) != 2 {
+		return 0, 0, fmt.Errorf("Unexpected output: %s", output)
+	}
+
+	return values[0], values[1], nil
+}
+
+// storagePoolUsageGet returns the space used on a storage pool and its total
+// size, in bytes.
+func storagePoolUsageGet(d *Daemon, poolName string) (uint64, uint64, error) {
+	_, pool, err := dbStoragePoolGet(d.db, poolName)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	switch pool.Driver {
+	case "zfs":
+		dataset := pool.Config["zfs.pool_name"]
+		if dataset == "" {
+			dataset = poolName
+		}
+
+		output, err := shared.RunCommand("zfs", "get", "-H", "-p", "-o", "value", "used,available", dataset)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Failed to get the usage of the ZFS dataset: %s", output)
+		}
+
+		used, available, err := storagePoolUsageParse(output, "")
+		if err != nil {
+			return 0, 0, err
+		}
+
+		return used, used + available, nil
+	case "lvm":
+		vgName := pool.Config["lvm.vg_name"]
+		if vgName == "" {
+			vgName = poolName
+		}
+
+		output, err := shared.RunCommand("vgs", "--noheadings", "--units", "b", "--nosuffix", "--separator", ":", "-o", "vg_size,vg_free", vgName)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Failed to get the usage of the volume group: %s", output)
+		}
+
+		size, free, err := storagePoolUsageParse(output, ":")
+		if err != nil {
+			return 0, 0, err
+		}
+
+		return size - free, size, nil
+	case "btrfs", "dir":
+		s, err := storagePoolInit(d, poolName)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		_, err = s.StoragePoolMount()
+		if err != nil {
+			return 0, 0, err
+		}
+
+		fs := syscall.Statfs_t{}
+		err = syscall.Statfs(getStoragePoolMountPoint(poolName), &fs)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		total := fs.Blocks * uint64(fs.Bsize)
+		return total - fs.Bfree*uint64(fs.Bsize), total, nil
+	}
+
+	return 0, 0, fmt.Errorf("The usage of \"%s\" storage pools can't be sampled", pool.Driver)
+}
+
+// storagePoolForecast estimates how fast a storage pool grows through a
+// linear regression of its usage samples, and how many days are left until
+// it's full at that pace. It returns nil until there are enough samples.
+func storagePoolForecast(samples []storagePoolUsageSample) *api.StoragePoolResourcesForecast {
+	if len(samples) < 2 {
+		return nil
+	}
+
+	first := samples[0].time
+	last := samples[len(samples)-1]
+
+	n := float64(len(samples))
+	sumX, sumY, sumXY, sumXX := 0.0, 0.0, 0.0, 0.0
+	for _, sample := range samples {
+		x := sample.time.Sub(first).Hours() / 24
+		y := float64(sample.used)
+
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return nil
+	}
+
+	rate := (n*sumXY - sumX*sumY) / denominator
+
+	forecast := api.StoragePoolResourcesForecast{
+		Samples:       len(samples),
+		Since:         first,
+		GrowthRate:    int64(rate),
+		DaysUntilFull: -1,
+	}
+
+	if rate > 0 {
+		free := 0.0
+		if last.total > last.used {
+			free = float64(last.total - last.used)
+		}
+
+		forecast.DaysUntilFull = free / rate
+	}
+
+	return &forecast
+}
+
+// storagePoolForecastGet returns the forecast of a storage pool from the
+// samples taken so far.
+func storagePoolForecastGet(poolName string) *api.StoragePoolResourcesForecast {
+	storagePoolUsageLock.Lock()
+	defer storagePoolUsageLock.Unlock()
+
+	return storagePoolForecast(storagePoolUsageHistory[poolName])
+}
+
+// storagePoolForecastHorizon returns within how many days a storage pool
+// must be forecast to be full for a warning to be sent, 0 meaning never.
+func storagePoolForecastHorizon() float64 {
+	key, ok := daemonConfig["storage.forecast_horizon"]
+	if !ok {
+		return 0
+	}
+
+	return float64(key.GetInt64())
+}
+
+// storagePoolsUsageSample samples the space used on all the storage pools
+// and sends a "storage" event whenever a pool is forecast to be full within
+// the storage.forecast_horizon.
+func storagePoolsUsageSample(d *Daemon) {
+	pools, err := dbStoragePools(d.db)
+	if err != nil {
+		if err != NoSuchObjectError {
+			logger.Error("Unable to retrieve the list of storage pools", log.Ctx{"err": err})
+		}
+		return
+	}
+
+	storagePoolUsageLock.Lock()
+	defer storagePoolUsageLock.Unlock()
+
+	// Forget about storage pools which are gone.
+	for poolName := range storagePoolUsageHistory {
+		if !shared.StringInSlice(poolName, pools) {
+			delete(storagePoolUsageHistory, poolName)
+			delete(storagePoolUsageWarned, poolName)
+		}
+	}
+
+	horizon := storagePoolForecastHorizon()
+	for _, poolName := range pools {
+		used, total, err := storagePoolUsageGet(d, poolName)
+		if err != nil {
+			logger.Debugf("Failed to sample the usage of storage pool \"%s\": %s.", poolName, err)
+			continue
+		}
+
+		samples := append(storagePoolUsageHistory[poolName], storagePoolUsageSample{time: time.Now(), used: used, total: total})
+		if len(samples) > storagePoolUsageSamples {
+			samples = samples[len(samples)-storagePoolUsageSamples:]
+		}
+		storagePoolUsageHistory[poolName] = samples
+
+		forecast := storagePoolForecast(samples)
+		full := forecast != nil && forecast.DaysUntilFull >= 0 && forecast.DaysUntilFull <= horizon
+		if horizon <= 0 || full == storagePoolUsageWarned[poolName] {
+			continue
+		}
+
+		storagePoolUsageWarned[poolName] = full
+		if !full {
+			continue
+		}
+
+		logger.Warn("Storage pool is forecast to be full soon", log.Ctx{"pool": poolName, "days": forecast.DaysUntilFull})
+		eventSend("storage", shared.Jmap{
+			"action":          "pool-forecast",
+			"pool":            poolName,
+			"used":            used,
+			"total":           total,
+			"growth_rate":     forecast.GrowthRate,
+			"days_until_full": forecast.DaysUntilFull,
+		})
+	}
+}
+
+// /1.0/storage-pools/{name}/resources
+// Get the space used on a storage pool and the forecast of its growth.
+func storagePoolResourcesGet(d *Daemon, r *http.Request) Response {
+	poolName := mux.Vars(r)["name"]
+
+	_, err := dbStoragePoolGetID(d.db, poolName)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	used, total, err := storagePoolUsageGet(d, poolName)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	resources := api.StoragePoolResources{
+		Space: api.StoragePoolResourcesSpace{
+			Used:  used,
+			Total: total,
+		},
+		Forecast: storagePoolForecastGet(poolName),
+	}
+
+	return SyncResponse(true, resources)
+}
+
+var storagePoolResourcesCmd = Command{name: "storage-pools/{name}/resources", get: storagePoolResourcesGet}
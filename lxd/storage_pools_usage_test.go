@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStoragePoolUsageParse(t *testing.T) {
+	used, available, err := storagePoolUsageParse("1024\n4096\n", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if used != 1024 || available != 4096 {
+		t.Errorf("Unexpected sizes: %d %d", used, available)
+	}
+
+	size, free, err := storagePoolUsageParse("  21470642176:1024\n", ":")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size != 21470642176 || free != 1024 {
+		t.Errorf("Unexpected sizes: %d %d", size, free)
+	}
+
+	_, _, err = storagePoolUsageParse("1024\n", "")
+	if err == nil {
+		t.Errorf("Expected an error for a missing size")
+	}
+}
+
+func TestStoragePoolForecast(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	day := 24 * time.Hour
+
+	if storagePoolForecast([]storagePoolUsageSample{{time: start, used: 100, total: 1000}}) != nil {
+		t.Errorf("Expected no forecast from a single sample")
+	}
+
+	// Growing by 100 bytes a day with 600 bytes left.
+	samples := []storagePoolUsageSample{
+		{time: start, used: 200, total: 1000},
+		{time: start.Add(day), used: 300, total: 1000},
+		{time: start.Add(2 * day), used: 400, total: 1000},
+	}
+
+	forecast := storagePoolForecast(samples)
+	if forecast == nil {
+		t.Fatal("Expected a forecast")
+	}
+
+	if forecast.Samples != 3 || !forecast.Since.Equal(start) || forecast.GrowthRate != 100 || forecast.DaysUntilFull != 6 {
+		t.Errorf("Unexpected forecast: %+v", forecast)
+	}
+
+	// Shrinking pools are never full.
+	samples[2].used = 100
+	forecast = storagePoolForecast(samples)
+	if forecast == nil || forecast.GrowthRate >= 0 || forecast.DaysUntilFull != -1 {
+		t.Errorf("Unexpected forecast: %+v", forecast)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
 	"github.com/lxc/lxd/shared/version"
 )
 
@@ -127,6 +128,46 @@ func storagePoolUsedByGet(db *sql.DB, poolID int64, poolName string) ([]string,
 	return poolUsedBy, err
 }
 
+// storagePoolStatus returns the health of the storage pool, as reported by
+// the storage backend. It's empty if the driver doesn't report it.
+func storagePoolStatus(pool *api.StoragePool) string {
+	if pool.Driver == "zfs" {
+		return zfsPoolHealthGet(pool.Name)
+	}
+
+	return ""
+}
+
+// storageDeleteBlocked sends a "storage" event listing what prevents the
+// deletion of a storage pool, or of one of its volumes, and returns the
+// matching error. The deletion of a pool only blocked by cached images can be
+// forced.
+func storageDeleteBlocked(poolName string, volumeName string, usedBy []string, forceable bool) error {
+	eventSend("storage", shared.Jmap{
+		"action":  "delete-blocked",
+		"pool":    poolName,
+		"volume":  volumeName,
+		"used_by": usedBy,
+	})
+
+	if volumeName != "" {
+		return fmt.Errorf("The storage volume \"%s\" is still in use by:\n%s", volumeName, strings.Join(usedBy, "\n"))
+	}
+
+	imagesOnly := forceable
+	for _, url := range usedBy {
+		if !strings.HasPrefix(url, fmt.Sprintf("/%s/images/", version.APIVersion)) {
+			imagesOnly = false
+		}
+	}
+
+	if imagesOnly {
+		return fmt.Errorf("The storage pool \"%s\" still holds images, use force to remove them along with it:\n%s", poolName, strings.Join(usedBy, "\n"))
+	}
+
+	return fmt.Errorf("The storage pool \"%s\" is still in use by:\n%s", poolName, strings.Join(usedBy, "\n"))
+}
+
 func profilesUsingPoolGetNames(db *sql.DB, poolName string) ([]string, error) {
 	usedBy := []string{}
 
@@ -248,3 +289,61 @@ func storagePoolCreateInternal(d *Daemon, poolName, poolDescription string, driv
 
 	return nil
 }
+
+// storagePoolLabelsUpdate replaces the labels of a storage pool, leaving them
+// alone if none were passed.
+func storagePoolLabelsUpdate(d *Daemon, poolName string, labels map[string]string) error {
+	if labels == nil {
+		return nil
+	}
+
+	poolID, err := dbStoragePoolGetID(d.db, poolName)
+	if err != nil {
+		return err
+	}
+
+	return dbStoragePoolLabelsUpdate(d.db, poolID, labels)
+}
+
+// storagePoolSelect picks the storage pool matching the label selector which
+// holds the fewest containers.
+func storagePoolSelect(d *Daemon, selector string) (string, error) {
+	s, err := shared.ParseLabelSelector(selector)
+	if err != nil {
+		return "", err
+	}
+
+	pools, err := dbStoragePools(d.db)
+	if err != nil && err != NoSuchObjectError {
+		return "", err
+	}
+
+	result := ""
+	resultCount := -1
+	for _, pool := range pools {
+		poolID, dbInfo, err := dbStoragePoolGet(d.db, pool)
+		if err != nil {
+			return "", err
+		}
+
+		if !s.Matches(dbInfo.Labels) {
+			continue
+		}
+
+		containers, err := dbStoragePoolVolumesGetType(d.db, storagePoolVolumeTypeContainer, poolID)
+		if err != nil {
+			return "", err
+		}
+
+		if resultCount == -1 || len(containers) < resultCount {
+			result = pool
+			resultCount = len(containers)
+		}
+	}
+
+	if result == "" {
+		return "", fmt.Errorf("No storage pool matches the selector \"%s\"", selector)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// storageRetryInitialDelay is how long to wait before the first retry, the
+// following ones waiting twice as long as the previous one.
+const storageRetryInitialDelay = 100 * time.Millisecond
+
+// storageRetryDelays returns how long to wait before each retry, doubling
+// from initial until reaching max.
+func storageRetryDelays(retries int, initial time.Duration, max time.Duration) []time.Duration {
+	delays := []time.Duration{}
+
+	delay := initial
+	for i := 0; i < retries; i++ {
+		if delay > max {
+			delay = max
+		}
+
+		delays = append(delays, delay)
+		delay *= 2
+	}
+
+	return delays
+}
+
+// storageRetryAny considers all errors transient.
+func storageRetryAny(err error) bool {
+	return true
+}
+
+// storageRetryBusy considers the errors about a busy mount, dataset or
+// device transient, e.g. a dataset which can't be destroyed right after
+// being unmounted as the kernel still holds references to it.
+func storageRetryBusy(err error) bool {
+	if err == syscall.EBUSY || storageErrorKind(err) == StorageErrBusy {
+		return true
+	}
+
+	return strings.Contains(strings.ToLower(err.Error()), "busy")
+}
+
+// storageRetry runs fn until it succeeds or fails with an error transient
+// doesn't consider transient, retrying up to "storage.busy_retries" times
+// with an exponential backoff bounded by "storage.busy_retry_max_delay"
+// seconds. It returns the last error.
+func storageRetry(transient func(err error) bool, fn func() error) error {
+	retries := int(daemonConfig["storage.busy_retries"].GetInt64())
+	maxDelay := time.Duration(daemonConfig["storage.busy_retry_max_delay"].GetInt64()) * time.Second
+
+	err := fn()
+	for i, delay := range storageRetryDelays(retries, storageRetryInitialDelay, maxDelay) {
+		if err == nil || !transient(err) {
+			return err
+		}
+
+		logger.Debug("Retrying storage operation", log.Ctx{"attempt": i + 1, "delay": delay, "err": err})
+		time.Sleep(delay)
+		err = fn()
+	}
+
+	return err
+}
+
+// storageRunCommandRetry runs a command, retrying it while it fails because
+// of something being busy.
+func storageRunCommandRetry(name string, arg ...string) (string, error) {
+	var output string
+
+	err := storageRetry(storageRetryBusy, func() error {
+		var err error
+		output, err = shared.RunCommand(name, arg...)
+		return err
+	})
+
+	return output, err
+}
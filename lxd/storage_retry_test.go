@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStorageRetryDelays(t *testing.T) {
+	delays := storageRetryDelays(6, 100*time.Millisecond, time.Second)
+
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+
+	if len(delays) != len(expected) {
+		t.Fatalf("Expected %d delays, got %d", len(expected), len(delays))
+	}
+
+	for i := range expected {
+		if delays[i] != expected[i] {
+			t.Errorf("Expected %s before retry %d, got %s", expected[i], i+1, delays[i])
+		}
+	}
+
+	if len(storageRetryDelays(0, time.Second, time.Second)) != 0 {
+		t.Error("Expected no retries")
+	}
+}
+
+func TestStorageRetryBusy(t *testing.T) {
+	busy := []error{
+		syscall.EBUSY,
+		fmt.Errorf("Failed to run: zfs destroy -r tank/containers/c1: cannot destroy 'tank/containers/c1': dataset is busy"),
+		fmt.Errorf("umount: /var/lib/lxd/storage-pools/default: target is busy."),
+	}
+
+	for _, err := range busy {
+		if !storageRetryBusy(err) {
+			t.Errorf("Expected %q to be retried", err)
+		}
+	}
+
+	if storageRetryBusy(fmt.Errorf("cannot open 'tank/containers/c1': dataset does not exist")) {
+		t.Error("Expected a missing dataset not to be retried")
+	}
+}
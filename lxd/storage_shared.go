@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"path/filepath"
 
 	"github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/api"
@@ -19,6 +20,8 @@ type storageShared struct {
 	pool   *api.StoragePool
 
 	volume *api.StorageVolume
+
+	audit *storageAudit
 }
 
 func (s *storageShared) GetStorageType() storageType {
@@ -33,6 +36,21 @@ func (s *storageShared) GetStorageTypeVersion() string {
 	return s.sTypeVersion
 }
 
+func (s *storageShared) StorageAuditSet(audit *storageAudit) {
+	s.audit = audit
+}
+
+// auditCommand records a destructive command about to be run on the storage
+// pool, see storageAuditCommand.
+func (s *storageShared) auditCommand(action string, target string, name string, args ...string) func(err error) {
+	poolName := ""
+	if s.pool != nil {
+		poolName = s.pool.Name
+	}
+
+	return storageAuditCommand(s.d, s.audit, poolName, action, target, name, args...)
+}
+
 func (s *storageShared) shiftRootfs(c container) error {
 	dpath := c.Path()
 	rpath := c.RootfsPath()
@@ -48,12 +66,23 @@ func (s *storageShared) shiftRootfs(c container) error {
 		return fmt.Errorf("IdmapSet of container '%s' is nil", c.Name())
 	}
 
-	err = idmapset.ShiftRootfs(rpath)
+	excludes := idmapShiftExcludes(c.ExpandedConfig())
+	err = idmapset.ShiftRootfs(rpath, idmapShiftSkipper(excludes))
 	if err != nil {
 		logger.Debugf("Shift of rootfs %s failed: %s", rpath, err)
 		return err
 	}
 
+	jsonExcludes, err := idmapShiftExcludesToJSON(excludes)
+	if err != nil {
+		return err
+	}
+
+	err = c.ConfigKeySet("volatile.last_state.idmap_shift_exclude", jsonExcludes)
+	if err != nil {
+		return err
+	}
+
 	/* Set an acl so the container root can descend the container dir */
 	// TODO: i changed this so it calls s.setUnprivUserAcl, which does
 	// the acl change only if the container is not privileged, think thats right.
@@ -124,3 +153,160 @@ func (s *storageShared) deleteImageDbPoolVolume(fingerprint string) error {
 
 	return nil
 }
+
+// ContainerDeletePlan adds the deletion of the storage volume of a container
+// or snapshot to a dry-run plan. Drivers for which it involves more override
+// it.
+func (s *storageShared) ContainerDeletePlan(container container, plan *api.OperationPlan) error {
+	plan.Actions = append(plan.Actions, fmt.Sprintf("Delete the storage volume of %s on storage pool %s", container.Name(), s.pool.Name))
+	return nil
+}
+
+// StoragePoolDeletePlan adds the deletion of a storage pool and of its
+// mountpoint to a dry-run plan. Drivers for which it involves more override
+// it.
+func (s *storageShared) StoragePoolDeletePlan(plan *api.OperationPlan) error {
+	plan.Actions = append(plan.Actions, fmt.Sprintf("Delete the %s storage pool %s", s.sTypeName, s.pool.Name))
+
+	poolMntPoint := getStoragePoolMountPoint(s.pool.Name)
+	if shared.PathExists(poolMntPoint) {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("Remove the mountpoint \"%s\"", poolMntPoint))
+	}
+
+	return nil
+}
+
+// StoragePoolVolumeDeletePlan adds the deletion of a custom storage volume
+// and of its mountpoint to a dry-run plan. Drivers for which it involves more
+// override it.
+func (s *storageShared) StoragePoolVolumeDeletePlan(plan *api.OperationPlan) error {
+	plan.Actions = append(plan.Actions, fmt.Sprintf("Delete the storage volume %s on storage pool %s", s.volume.Name, s.pool.Name))
+
+	volumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
+	if shared.PathExists(volumeMntPoint) {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("Remove the mountpoint \"%s\"", volumeMntPoint))
+	}
+
+	return nil
+}
+
+// ContainerRestorePlan adds the restore of the storage volume of a container
+// to a dry-run plan.
+func (s *storageShared) ContainerRestorePlan(container container, sourceContainer container, plan *api.OperationPlan) error {
+	plan.Actions = append(plan.Actions, fmt.Sprintf("Replace the storage volume of %s with a copy of %s", container.Name(), sourceContainer.Name()))
+	return nil
+}
+
+// containerBackupLoad creates the container and the snapshots of an
+// unoptimized backup on the storage pool along with their database records,
+// copying their files the way a migration over rsync does. It's used by the
+// drivers which have no native way of loading a backup.
+func (s *storageShared) containerBackupLoad(info backupInfo, path string) error {
+	if info.Optimized {
+		return fmt.Errorf("Optimized backups of %s storage can't be loaded into a %s storage pool", info.Backend, s.sTypeName)
+	}
+
+	logger.Debugf("Loading %s storage volume for backup of container \"%s\" on storage pool \"%s\".", s.sTypeName, info.Name, s.pool.Name)
+
+	backup, err := slurpBackupFile(filepath.Join(path, "container", "backup.yaml"))
+	if err != nil {
+		return err
+	}
+
+	if backup.Container == nil {
+		return fmt.Errorf("The backup doesn't describe the container")
+	}
+
+	args, err := backupContainerArgs(s.d, backup.Container, s.pool.Name)
+	if err != nil {
+		return err
+	}
+
+	c, err := containerCreateAsEmpty(s.d, args)
+	if err != nil {
+		return err
+	}
+
+	revert := true
+	defer func() {
+		if !revert {
+			return
+		}
+
+		c.Delete()
+	}()
+
+	ourStart, err := c.StorageStart()
+	if err != nil {
+		return err
+	}
+	if ourStart {
+		defer c.StorageStop()
+	}
+
+	isDirBackend := c.Storage().GetStorageType() == storageTypeDir
+	for _, snapName := range info.Snapshots {
+		var snap *api.ContainerSnapshot
+		for _, entry := range backup.Snapshots {
+			_, entryName, _ := containerGetParentAndSnapshotName(entry.Name)
+			if entryName == snapName {
+				snap = entry
+				break
+			}
+		}
+
+		if snap == nil {
+			return fmt.Errorf("The backup doesn't describe the snapshot \"%s\"", snapName)
+		}
+
+		args, err := backupSnapshotArgs(s.d, info.Name, snap, s.pool.Name)
+		if err != nil {
+			return err
+		}
+
+		snapPath := filepath.Join(path, "snapshots", snapName)
+		if isDirBackend {
+			sc, err := containerCreateEmptySnapshot(s.d, args)
+			if err != nil {
+				return err
+			}
+
+			output, err := rsyncLocalCopy(snapPath, sc.Path(), "")
+			if err != nil {
+				return fmt.Errorf("Failed to restore snapshot \"%s\": %s", snapName, output)
+			}
+
+			continue
+		}
+
+		// Other drivers snapshot the container once it holds the
+		// snapshot's files. Its state, if any, is part of those.
+		output, err := rsyncLocalCopy(snapPath, c.Path(), "")
+		if err != nil {
+			return fmt.Errorf("Failed to restore snapshot \"%s\": %s", snapName, output)
+		}
+
+		args.Stateful = false
+		_, err = containerCreateAsSnapshot(s.d, args, c)
+		if err != nil {
+			return err
+		}
+	}
+
+	output, err := rsyncLocalCopy(filepath.Join(path, "container"), c.Path(), "")
+	if err != nil {
+		return fmt.Errorf("Failed to restore the container: %s", output)
+	}
+
+	// The copied backup.yaml describes the container where it was backed
+	// up from.
+	err = writeBackupFile(c)
+	if err != nil {
+		return err
+	}
+
+	revert = false
+
+	logger.Debugf("Loaded %s storage volume for backup of container \"%s\" on storage pool \"%s\".", s.sTypeName, info.Name, s.pool.Name)
+	return nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+)
+
+// storageSourcesIgnoredDevices are the prefixes of the block devices which
+// can't back a storage pool, being virtual, read-only media or the volumes of
+// other storage.
+var storageSourcesIgnoredDevices = []string{"loop", "ram", "zram", "dm-", "md", "zd", "nbd", "sr", "fd", "rbd"}
+
+type storageSourcesByTypeAndName []api.StorageSource
+
+func (a storageSourcesByTypeAndName) Len() int {
+	return len(a)
+}
+
+func (a storageSourcesByTypeAndName) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+
+func (a storageSourcesByTypeAndName) Less(i, j int) bool {
+	if a[i].Type != a[j].Type {
+		return a[i].Type < a[j].Type
+	}
+
+	return a[i].Name < a[j].Name
+}
+
+// storageSourcesReadUint reads an integer from a sysfs file, returning 0 if it
+// can't.
+func storageSourcesReadUint(path string) uint64 {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+
+	value, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return value
+}
+
+// storageSourcesHasHolders returns whether another block device, like a
+// device-mapper or RAID one, is built on top of the given one.
+func storageSourcesHasHolders(devPath string) bool {
+	holders, err := ioutil.ReadDir(filepath.Join(devPath, "holders"))
+	return err == nil && len(holders) > 0
+}
+
+// storageSourcesBlockDevices lists the disks without partitions and the
+// partitions found in the sysfs block class at sysPath which are free, as
+// told by the used function.
+func storageSourcesBlockDevices(sysPath string, used func(path string) bool) ([]api.StorageSource, error) {
+	entries, err := ioutil.ReadDir(sysPath)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := []api.StorageSource{}
+	for _, entry := range entries {
+		name := entry.Name()
+		devPath := filepath.Join(sysPath, name)
+
+		ignored := false
+		for _, prefix := range storageSourcesIgnoredDevices {
+			if strings.HasPrefix(name, prefix) {
+				ignored = true
+				break
+			}
+		}
+
+		// Partitions are handled along with their disk.
+		if ignored || shared.PathExists(filepath.Join(devPath, "partition")) {
+			continue
+		}
+
+		if storageSourcesReadUint(filepath.Join(devPath, "ro")) == 1 || storageSourcesHasHolders(devPath) {
+			continue
+		}
+
+		model, _ := ioutil.ReadFile(filepath.Join(devPath, "device", "model"))
+
+		partitions := []string{}
+		children, err := ioutil.ReadDir(devPath)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, child := range children {
+			if strings.HasPrefix(child.Name(), name) && shared.PathExists(filepath.Join(devPath, child.Name(), "partition")) {
+				partitions = append(partitions, child.Name())
+			}
+		}
+
+		candidates := partitions
+		sourceType := "partition"
+		if len(partitions) == 0 {
+			candidates = []string{name}
+			sourceType = "disk"
+		}
+
+		for _, candidate := range candidates {
+			candidatePath := devPath
+			if candidate != name {
+				candidatePath = filepath.Join(devPath, candidate)
+			}
+
+			if storageSourcesHasHolders(candidatePath) {
+				continue
+			}
+
+			// Sizes are always in 512 bytes sectors in sysfs.
+			size := storageSourcesReadUint(filepath.Join(candidatePath, "size")) * 512
+			if size == 0 {
+				continue
+			}
+
+			// Some drivers use "!" in place of "/" in the names.
+			path := filepath.Join("/dev", strings.Replace(candidate, "!", "/", -1))
+			if used(path) {
+				continue
+			}
+
+			sources = append(sources, api.StorageSource{
+				Type:    sourceType,
+				Name:    candidate,
+				Path:    path,
+				Size:    size,
+				Model:   strings.TrimSpace(string(model)),
+				Drivers: []string{"btrfs", "lvm", "zfs"},
+			})
+		}
+	}
+
+	return sources, nil
+}
+
+// storageSourcesParseList parses the name and size pairs printed by "zpool
+// list" and "vgs", separated by sep.
+func storageSourcesParseList(output string, sep string) (map[string]uint64, error) {
+	result := map[string]uint64{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Split(line, sep)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Unexpected line: %s", line)
+		}
+
+		size, err := strconv.ParseUint(strings.TrimSpace(fields[1]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Unexpected size: %s", line)
+		}
+
+		result[strings.TrimSpace(fields[0])] = size
+	}
+
+	return result, nil
+}
+
+// storageSourcesDeviceUsed returns whether a block device is mounted, used as
+// swap or holds any data blkid knows about, like a filesystem, a zpool or a
+// physical volume.
+func storageSourcesDeviceUsed(mounts []string) func(path string) bool {
+	return func(path string) bool {
+		if shared.StringInSlice(path, mounts) {
+			return true
+		}
+
+		fs, _ := shared.BlockFsDetect(path)
+		return fs != ""
+	}
+}
+
+// storageSourcesMounts lists the devices which are mounted or used as swap.
+func storageSourcesMounts() []string {
+	mounts := []string{}
+	for _, file := range []string{"/proc/self/mounts", "/proc/swaps"} {
+		f, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) > 0 && strings.HasPrefix(fields[0], "/dev/") {
+				mounts = append(mounts, fields[0])
+			}
+		}
+
+		f.Close()
+	}
+
+	return mounts
+}
+
+// storageSources lists what new storage pools could be created on: the free
+// block devices as well as the zpools and LVM volume groups which no storage
+// pool uses yet.
+func storageSources(d *Daemon) ([]api.StorageSource, error) {
+	sources, err := storageSourcesBlockDevices("/sys/class/block", storageSourcesDeviceUsed(storageSourcesMounts()))
+	if err != nil {
+		return nil, err
+	}
+
+	usedZpools := []string{}
+	for _, zpool := range zfsStoragePoolZpools(d) {
+		// Datasets of a zpool only count against the zpool itself.
+		usedZpools = append(usedZpools, strings.Split(zpool, "/")[0])
+	}
+
+	usedVgs := []string{}
+	pools, err := dbStoragePools(d.db)
+	if err != nil && err != NoSuchObjectError {
+		return nil, err
+	}
+
+	for _, poolName := range pools {
+		_, pool, err := dbStoragePoolGet(d.db, poolName)
+		if err != nil {
+			return nil, err
+		}
+
+		if pool.Driver != "lvm" {
+			continue
+		}
+
+		vgName := pool.Config["lvm.vg_name"]
+		if vgName == "" {
+			vgName = poolName
+		}
+		usedVgs = append(usedVgs, vgName)
+	}
+
+	// Either tool may be missing, in which case there's nothing to adopt.
+	output, err := shared.RunCommand("zpool", "list", "-H", "-p", "-o", "name,size")
+	if err == nil {
+		zpools, err := storageSourcesParseList(output, "\t")
+		if err != nil {
+			return nil, err
+		}
+
+		for name, size := range zpools {
+			if shared.StringInSlice(name, usedZpools) {
+				continue
+			}
+
+			sources = append(sources, api.StorageSource{Type: "zpool", Name: name, Size: size, Drivers: []string{"zfs"}})
+		}
+	}
+
+	output, err = shared.RunCommand("vgs", "--noheadings", "--units", "b", "--nosuffix", "--separator", ":", "-o", "vg_name,vg_size")
+	if err == nil {
+		vgs, err := storageSourcesParseList(output, ":")
+		if err != nil {
+			return nil, err
+		}
+
+		for name, size := range vgs {
+			if shared.StringInSlice(name, usedVgs) {
+				continue
+			}
+
+			sources = append(sources, api.StorageSource{Type: "vg", Name: name, Size: size, Drivers: []string{"lvm"}})
+		}
+	}
+
+	sort.Sort(storageSourcesByTypeAndName(sources))
+
+	return sources, nil
+}
+
+// /1.0/storage-sources
+// List the block devices, zpools and volume groups new storage pools can use.
+func storageSourcesGet(d *Daemon, r *http.Request) Response {
+	sources, err := storageSources(d)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return SyncResponse(true, sources)
+}
+
+var storageSourcesCmd = Command{name: "storage-sources", get: storageSourcesGet}
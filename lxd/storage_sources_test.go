@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStorageSourcesBlockDevices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lxd_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"sda/size":           "2048",
+		"sda/device/model":   "Disk A  \n",
+		"sda/sda1/partition": "1",
+		"sda/sda1/size":      "1024",
+		"sda/sda2/partition": "2",
+		"sda/sda2/size":      "1024",
+		"sda1/partition":     "1",
+		"sda1/size":          "1024",
+		"sdb/size":           "4096",
+		"sdc/size":           "4096",
+		"sdc/holders/dm-0":   "",
+		"sr0/size":           "4096",
+		"loop0/size":         "4096",
+		"vda/size":           "4096",
+		"vda/ro":             "1",
+		"cciss!c0d0/size":    "8",
+	}
+
+	for path, content := range files {
+		path = filepath.Join(dir, path)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	used := func(path string) bool {
+		return path == "/dev/sda2"
+	}
+
+	sources, err := storageSourcesBlockDevices(dir, used)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := map[string]string{}
+	for _, source := range sources {
+		found[source.Path] = source.Type
+
+		if source.Path == "/dev/sda1" && (source.Size != 1024*512 || source.Model != "Disk A") {
+			t.Errorf("Unexpected partition: %+v", source)
+		}
+	}
+
+	expected := map[string]string{
+		"/dev/sda1":       "partition",
+		"/dev/sdb":        "disk",
+		"/dev/cciss/c0d0": "disk",
+	}
+
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("Expected %v, got %v", expected, found)
+	}
+}
+
+func TestStorageSourcesParseList(t *testing.T) {
+	zpools, err := storageSourcesParseList("default\t10737418240\ntank\t1024\n", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(zpools, map[string]uint64{"default": 10737418240, "tank": 1024}) {
+		t.Errorf("Unexpected zpools: %v", zpools)
+	}
+
+	vgs, err := storageSourcesParseList("  lxd:21470642176\n  ubuntu-vg:1024\n", ":")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(vgs, map[string]uint64{"lxd": 21470642176, "ubuntu-vg": 1024}) {
+		t.Errorf("Unexpected volume groups: %v", vgs)
+	}
+
+	_, err = storageSourcesParseList("lxd:big\n", ":")
+	if err == nil {
+		t.Errorf("Expected an error for an invalid size")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/lxc/lxd/lxd/types"
+)
+
+type storageTestSuite struct {
+	lxdTestSuite
+}
+
+func (suite *storageTestSuite) TestStorageUnwrap() {
+	st, err := storagePoolInit(suite.d, lxdTestSuiteDefaultStoragePool)
+	suite.Req.Nil(err)
+
+	// The driver is only reachable through the wrappers.
+	_, ok := st.(*storageMock)
+	suite.Req.False(ok)
+
+	mock, ok := storageUnwrap(st).(*storageMock)
+	suite.Req.True(ok)
+	suite.Req.Equal(lxdTestSuiteDefaultStoragePool, mock.pool.Name)
+
+	// Unwrapping a bare driver is a no-op.
+	suite.Req.Equal(storage(mock), storageUnwrap(mock))
+}
+
+func (suite *storageTestSuite) TestStoragePoolQuota() {
+	err := dbStoragePoolUpdate(suite.d.db, lxdTestSuiteDefaultStoragePool, "", map[string]string{"quota.size": "15GB"})
+	suite.Req.Nil(err)
+
+	err = storagePoolVolumeDBCreate(suite.d, lxdTestSuiteDefaultStoragePool, "v1", "", storagePoolVolumeTypeNameCustom, map[string]string{"size": "10GB"})
+	suite.Req.Nil(err)
+
+	// Volumes without a quota don't count in soft mode.
+	err = storagePoolVolumeDBCreate(suite.d, lxdTestSuiteDefaultStoragePool, "v2", "", storagePoolVolumeTypeNameCustom, nil)
+	suite.Req.Nil(err)
+
+	err = storagePoolVolumeDBCreate(suite.d, lxdTestSuiteDefaultStoragePool, "v3", "", storagePoolVolumeTypeNameCustom, map[string]string{"size": "10GB"})
+	suite.Req.NotNil(err)
+	suite.Req.Equal(StorageErrQuotaExceeded, storageErrorKind(err))
+
+	// The quota of a container is the size of its root disk.
+	args := containerArgs{
+		Ctype: cTypeRegular,
+		Name:  "testFoo",
+		Devices: types.Devices{
+			"root": types.Device{"type": "disk", "path": "/", "pool": lxdTestSuiteDefaultStoragePool, "size": "10GB"},
+		},
+	}
+
+	_, err = containerCreateInternal(suite.d, args)
+	suite.Req.NotNil(err)
+	suite.Req.Equal(StorageErrQuotaExceeded, storageErrorKind(err))
+
+	_, err = containerLoadByName(suite.d, "testFoo")
+	suite.Req.NotNil(err)
+
+	args.Devices["root"]["size"] = "5GB"
+	c, err := containerCreateInternal(suite.d, args)
+	suite.Req.Nil(err)
+	defer c.Delete()
+}
+
+func (suite *storageTestSuite) TestStoragePoolVolumeSnapshotRestore() {
+	poolID, err := dbStoragePoolGetID(suite.d.db, lxdTestSuiteDefaultStoragePool)
+	suite.Req.Nil(err)
+
+	for _, name := range []string{"v1", "v1/snap0", "v1/snap1"} {
+		_, err = dbStoragePoolVolumeCreate(suite.d.db, name, "", storagePoolVolumeTypeCustom, poolID, map[string]string{})
+		suite.Req.Nil(err)
+	}
+
+	// Drivers which keep the newer snapshots keep them in the database.
+	err = storagePoolVolumeSnapshotRestore(suite.d, lxdTestSuiteDefaultStoragePool, "v1", "snap0")
+	suite.Req.Nil(err)
+
+	snapshots, err := dbStoragePoolVolumeSnapshotsGet(suite.d.db, "v1", poolID)
+	suite.Req.Nil(err)
+	suite.Req.Equal([]string{"v1/snap0", "v1/snap1"}, snapshots)
+
+	err = storagePoolVolumeSnapshotRestore(suite.d, lxdTestSuiteDefaultStoragePool, "v1", "missing")
+	suite.Req.NotNil(err)
+}
+
+func TestStorageTestSuite(t *testing.T) {
+	suite.Run(t, new(storageTestSuite))
+}
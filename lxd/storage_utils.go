@@ -5,7 +5,6 @@ import (
 	"os"
 	"strings"
 	"syscall"
-	"time"
 
 	"github.com/lxc/lxd/shared"
 )
@@ -73,35 +72,16 @@ func lxdResolveMountoptions(options string) (uintptr, string) {
 
 // Useful functions for unreliable backends
 func tryMount(src string, dst string, fs string, flags uintptr, options string) error {
-	var err error
-
-	for i := 0; i < 20; i++ {
-		err = syscall.Mount(src, dst, fs, flags, options)
-		if err == nil {
-			break
-		}
-
-		time.Sleep(500 * time.Millisecond)
-	}
-
-	if err != nil {
-		return err
-	}
-
-	return nil
+	// Devices may take a moment to show up, so retry on all errors.
+	return storageRetry(storageRetryAny, func() error {
+		return syscall.Mount(src, dst, fs, flags, options)
+	})
 }
 
 func tryUnmount(path string, flags int) error {
-	var err error
-
-	for i := 0; i < 20; i++ {
-		err = syscall.Unmount(path, flags)
-		if err == nil {
-			break
-		}
-
-		time.Sleep(500 * time.Millisecond)
-	}
+	err := storageRetry(storageRetryAny, func() error {
+		return syscall.Unmount(path, flags)
+	})
 
 	if err != nil && err == syscall.EBUSY {
 		return err
@@ -179,3 +159,17 @@ func lxdUsesPool(db *sql.DB, onDiskPoolName string, driver string, onDiskPropert
 
 	return false, "", nil
 }
+
+// makeFSType creates a filesystem of the given type, ext4 by default, on a
+// block device.
+func makeFSType(path string, fsType string) (string, error) {
+	switch fsType {
+	case "xfs":
+		return shared.TryRunCommand("mkfs.xfs", path)
+	default:
+		return shared.TryRunCommand(
+			"mkfs.ext4",
+			"-E", "nodiscard,lazy_itable_init=0,lazy_journal_init=0",
+			path)
+	}
+}
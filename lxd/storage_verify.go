@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+)
+
+// storageVerifyReport collects the divergences found while verifying a
+// storage pool, fixing them as they're found when asked to.
+type storageVerifyReport struct {
+	fix         bool
+	divergences []api.StoragePoolDivergence
+}
+
+// add records a divergence. fixFunc fixes it and is nil for the divergences
+// which aren't safe to fix automatically.
+func (r *storageVerifyReport) add(div api.StoragePoolDivergence, fixFunc func() error) {
+	div.Fixable = fixFunc != nil
+
+	if r.fix && fixFunc != nil {
+		err := fixFunc()
+		if err != nil {
+			div.Error = err.Error()
+		} else {
+			div.Fixed = true
+		}
+	}
+
+	r.divergences = append(r.divergences, div)
+}
+
+// failures returns how many of the divergences failed to be fixed.
+func (r *storageVerifyReport) failures() int {
+	failures := 0
+	for _, div := range r.divergences {
+		if div.Error != "" {
+			failures++
+		}
+	}
+
+	return failures
+}
+
+// storageVerifyListDiff returns the entries of expected which are missing from
+// found and the entries of found which aren't expected, both sorted.
+func storageVerifyListDiff(expected []string, found []string) ([]string, []string) {
+	missing := []string{}
+	for _, entry := range expected {
+		if !shared.StringInSlice(entry, found) {
+			missing = append(missing, entry)
+		}
+	}
+
+	unknown := []string{}
+	for _, entry := range found {
+		if !shared.StringInSlice(entry, expected) {
+			unknown = append(unknown, entry)
+		}
+	}
+
+	sort.Strings(missing)
+	sort.Strings(unknown)
+
+	return missing, unknown
+}
+
+// storageVerifyMountpoint checks that the mountpoint of an entity exists.
+// Recreating it is only safe when it's merely where the entity gets mounted
+// rather than where its data is stored.
+func storageVerifyMountpoint(r *storageVerifyReport, entity string, name string, path string, mode os.FileMode, mountOnly bool) {
+	if shared.PathExists(path) {
+		return
+	}
+
+	var fixFunc func() error
+	if mountOnly {
+		fixFunc = func() error {
+			err := os.MkdirAll(path, mode)
+			if err != nil {
+				return err
+			}
+
+			return os.Chmod(path, mode)
+		}
+	}
+
+	div := api.StoragePoolDivergence{
+		Type:     "missing_mountpoint",
+		Entity:   entity,
+		Name:     name,
+		Expected: path,
+	}
+
+	r.add(div, fixFunc)
+}
+
+// storageVerifySymlink checks that path is a symlink to target.
+func storageVerifySymlink(r *storageVerifyReport, entity string, name string, path string, target string) {
+	found, err := os.Readlink(path)
+	if err == nil && found == target {
+		return
+	}
+
+	div := api.StoragePoolDivergence{
+		Type:     "wrong_symlink",
+		Entity:   entity,
+		Name:     name,
+		Expected: fmt.Sprintf("%s -> %s", path, target),
+	}
+
+	if os.IsNotExist(err) {
+		div.Type = "missing_symlink"
+		r.add(div, func() error {
+			return os.Symlink(target, path)
+		})
+		return
+	}
+
+	if err != nil {
+		// Something other than a symlink is in the way, leave it be.
+		div.Found = fmt.Sprintf("%s isn't a symlink", path)
+		r.add(div, nil)
+		return
+	}
+
+	div.Found = fmt.Sprintf("%s -> %s", path, found)
+	r.add(div, func() error {
+		err := os.Remove(path)
+		if err != nil {
+			return err
+		}
+
+		return os.Symlink(target, path)
+	})
+}
+
+// storageVerifyContainer checks the mountpoints and symlinks of a container
+// and of its snapshots.
+func storageVerifyContainer(r *storageVerifyReport, poolName string, c container, mountOnly bool) error {
+	name := c.Name()
+
+	mode := os.FileMode(0755)
+	if c.IsPrivileged() {
+		mode = 0700
+	}
+
+	mountPoint := getContainerMountPoint(poolName, name)
+	storageVerifyMountpoint(r, "container", name, mountPoint, mode, mountOnly)
+	storageVerifySymlink(r, "container", name, shared.VarPath("containers", name), mountPoint)
+
+	snapshots, err := c.Snapshots()
+	if err != nil {
+		return err
+	}
+
+	if len(snapshots) == 0 {
+		return nil
+	}
+
+	snapshotsPath := shared.VarPath("storage-pools", poolName, "snapshots", name)
+	storageVerifySymlink(r, "container", name, shared.VarPath("snapshots", name), snapshotsPath)
+
+	snapNames := []string{}
+	for _, snap := range snapshots {
+		_, snapOnlyName, _ := containerGetParentAndSnapshotName(snap.Name())
+		snapNames = append(snapNames, snapOnlyName)
+
+		storageVerifyMountpoint(r, "snapshot", snap.Name(), getSnapshotMountPoint(poolName, snap.Name()), 0711, mountOnly)
+	}
+
+	// Look for snapshots left on disk without a database record.
+	found := []string{}
+	entries, err := ioutil.ReadDir(snapshotsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, entry := range entries {
+		found = append(found, entry.Name())
+	}
+
+	_, unknown := storageVerifyListDiff(snapNames, found)
+	for _, snapOnlyName := range unknown {
+		div := api.StoragePoolDivergence{
+			Type:   "unknown_snapshot",
+			Entity: "snapshot",
+			Name:   name + shared.SnapshotDelimiter + snapOnlyName,
+			Found:  filepath.Join(snapshotsPath, snapOnlyName),
+		}
+
+		r.add(div, nil)
+	}
+
+	return nil
+}
+
+// storagePoolVerify cross-checks the database records of a storage pool with
+// its on-disk state, fixing the divergences which are safe to fix if asked to.
+func storagePoolVerify(d *Daemon, poolName string, fix bool) (*storageVerifyReport, error) {
+	poolID, pool, err := dbStoragePoolGet(d.db, poolName)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := storagePoolInit(d, poolName)
+	if err != nil {
+		return nil, err
+	}
+
+	ourMount, err := s.StoragePoolMount()
+	if err != nil {
+		return nil, err
+	}
+	if ourMount {
+		defer s.StoragePoolUmount()
+	}
+
+	// With ZFS and LVM, the mountpoints are only where the volumes get
+	// mounted. Elsewhere they hold the data itself.
+	mountOnly := pool.Driver == "zfs" || pool.Driver == "lvm"
+
+	report := &storageVerifyReport{fix: fix}
+
+	names, err := dbStoragePoolVolumesGetType(d.db, storagePoolVolumeTypeContainer, poolID)
+	if err != nil {
+		return nil, err
+	}
+
+	containers := []container{}
+	for _, name := range names {
+		if shared.IsSnapshot(name) {
+			continue
+		}
+
+		c, err := containerLoadByName(d, name)
+		if err != nil {
+			return nil, err
+		}
+
+		err = storageVerifyContainer(report, poolName, c, mountOnly)
+		if err != nil {
+			return nil, err
+		}
+
+		containers = append(containers, c)
+	}
+
+	volumes, err := dbStoragePoolVolumesGetType(d.db, storagePoolVolumeTypeCustom, poolID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range volumes {
+		if shared.IsSnapshot(name) {
+			continue
+		}
+
+		storageVerifyMountpoint(report, "custom", name, getStoragePoolVolumeMountPoint(poolName, name), 0711, mountOnly)
+	}
+
+	err = s.StoragePoolVerify(report, containers)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// /1.0/storage-pools/{name}/verify
+// Check the on-disk state of a storage pool against the database.
+func storagePoolVerifyPost(d *Daemon, r *http.Request) Response {
+	poolName := mux.Vars(r)["name"]
+
+	_, err := dbStoragePoolGetID(d.db, poolName)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	req := api.StoragePoolVerifyPost{}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	run := func(op *operation) error {
+		report, err := storagePoolVerify(d, poolName, req.Fix)
+		if err != nil {
+			return err
+		}
+
+		op.UpdateMetadata(map[string]interface{}{"divergences": report.divergences})
+
+		failures := report.failures()
+		if failures > 0 {
+			return fmt.Errorf("Failed to fix %d out of %d divergences", failures, len(report.divergences))
+		}
+
+		return nil
+	}
+
+	resources := map[string][]string{}
+	resources["storage-pools"] = []string{poolName}
+
+	op, err := operationCreate(operationClassTask, resources, nil, run, nil, nil)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return OperationResponse(op)
+}
+
+var storagePoolVerifyCmd = Command{name: "storage-pools/{name}/verify", post: storagePoolVerifyPost}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/lxc/lxd/shared/api"
+)
+
+func TestStorageVerifyListDiff(t *testing.T) {
+	missing, unknown := storageVerifyListDiff([]string{"snap2", "snap0", "snap1"}, []string{"snap1", "snap3", "snap0"})
+
+	if !reflect.DeepEqual(missing, []string{"snap2"}) {
+		t.Errorf("Unexpected missing entries: %v", missing)
+	}
+
+	if !reflect.DeepEqual(unknown, []string{"snap3"}) {
+		t.Errorf("Unexpected unknown entries: %v", unknown)
+	}
+}
+
+func TestStorageVerifyReport(t *testing.T) {
+	fixes := 0
+	fixFunc := func() error {
+		fixes++
+		return nil
+	}
+
+	failFunc := func() error {
+		return fmt.Errorf("failed")
+	}
+
+	report := &storageVerifyReport{}
+	report.add(api.StoragePoolDivergence{Name: "c1"}, fixFunc)
+	report.add(api.StoragePoolDivergence{Name: "c2"}, nil)
+
+	if fixes != 0 || !report.divergences[0].Fixable || report.divergences[0].Fixed || report.divergences[1].Fixable {
+		t.Errorf("Unexpected report without fixing: %+v", report.divergences)
+	}
+
+	report = &storageVerifyReport{fix: true}
+	report.add(api.StoragePoolDivergence{Name: "c1"}, fixFunc)
+	report.add(api.StoragePoolDivergence{Name: "c2"}, failFunc)
+	report.add(api.StoragePoolDivergence{Name: "c3"}, nil)
+
+	if fixes != 1 || !report.divergences[0].Fixed || report.divergences[1].Fixed || report.divergences[1].Error != "failed" || report.divergences[2].Fixed {
+		t.Errorf("Unexpected report with fixing: %+v", report.divergences)
+	}
+
+	if report.failures() != 1 {
+		t.Errorf("Expected 1 failure, got %d", report.failures())
+	}
+}
+
+func TestStorageVerifySymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lxd_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "target")
+	missing := filepath.Join(dir, "missing")
+	wrong := filepath.Join(dir, "wrong")
+	notLink := filepath.Join(dir, "dir")
+
+	err = os.Symlink(filepath.Join(dir, "other"), wrong)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Mkdir(notLink, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := &storageVerifyReport{fix: true}
+	storageVerifySymlink(report, "container", "c1", missing, target)
+	storageVerifySymlink(report, "container", "c1", wrong, target)
+	storageVerifySymlink(report, "container", "c1", notLink, target)
+
+	if len(report.divergences) != 3 {
+		t.Fatalf("Expected 3 divergences, got %+v", report.divergences)
+	}
+
+	if report.divergences[0].Type != "missing_symlink" || !report.divergences[0].Fixed {
+		t.Errorf("Unexpected divergence: %+v", report.divergences[0])
+	}
+
+	if report.divergences[1].Type != "wrong_symlink" || !report.divergences[1].Fixed {
+		t.Errorf("Unexpected divergence: %+v", report.divergences[1])
+	}
+
+	if report.divergences[2].Type != "wrong_symlink" || report.divergences[2].Fixable {
+		t.Errorf("Unexpected divergence: %+v", report.divergences[2])
+	}
+
+	for _, path := range []string{missing, wrong} {
+		found, err := os.Readlink(path)
+		if err != nil || found != target {
+			t.Errorf("Expected %s to point to %s, got %s (%v)", path, target, found, err)
+		}
+	}
+
+	report = &storageVerifyReport{}
+	storageVerifySymlink(report, "container", "c1", missing, target)
+	if len(report.divergences) != 0 {
+		t.Errorf("Unexpected divergences after fixing: %+v", report.divergences)
+	}
+}
@@ -23,6 +23,11 @@ func storagePoolVolumesGet(d *Daemon, r *http.Request) Response {
 		recursion = 0
 	}
 
+	selector, err := shared.ParseLabelSelector(r.FormValue("labels"))
+	if err != nil {
+		return BadRequest(err)
+	}
+
 	// Retrieve ID of the storage pool (and check if the storage pool
 	// exists).
 	poolID, err := dbStoragePoolGetID(d.db, poolName)
@@ -38,7 +43,17 @@ func storagePoolVolumesGet(d *Daemon, r *http.Request) Response {
 	}
 
 	resultString := []string{}
+	resultMap := []*api.StorageVolume{}
 	for _, volume := range volumes {
+		// Snapshots are listed through their volume.
+		if shared.IsSnapshot(volume.Name) {
+			continue
+		}
+
+		if !selector.Matches(volume.Labels) {
+			continue
+		}
+
 		apiEndpoint, err := storagePoolVolumeTypeNameToAPIEndpoint(volume.Type)
 		if err != nil {
 			return InternalError(err)
@@ -52,6 +67,8 @@ func storagePoolVolumesGet(d *Daemon, r *http.Request) Response {
 				return InternalError(err)
 			}
 			volume.UsedBy = volumeUsedBy
+
+			resultMap = append(resultMap, volume)
 		}
 	}
 
@@ -59,7 +76,7 @@ func storagePoolVolumesGet(d *Daemon, r *http.Request) Response {
 		return SyncResponse(true, resultString)
 	}
 
-	return SyncResponse(true, volumes)
+	return SyncResponse(true, resultMap)
 }
 
 var storagePoolVolumesCmd = Command{name: "storage-pools/{name}/volumes", get: storagePoolVolumesGet}
@@ -77,6 +94,11 @@ func storagePoolVolumesTypeGet(d *Daemon, r *http.Request) Response {
 		recursion = 0
 	}
 
+	selector, err := shared.ParseLabelSelector(r.FormValue("labels"))
+	if err != nil {
+		return BadRequest(err)
+	}
+
 	// Get the name of the volume type.
 	volumeTypeName := mux.Vars(r)["type"]
 
@@ -107,6 +129,20 @@ func storagePoolVolumesTypeGet(d *Daemon, r *http.Request) Response {
 	resultString := []string{}
 	resultMap := []*api.StorageVolume{}
 	for _, volume := range volumes {
+		// Snapshots are listed through their volume.
+		if shared.IsSnapshot(volume) {
+			continue
+		}
+
+		_, vol, err := dbStoragePoolVolumeGetType(d.db, volume, volumeType, poolID)
+		if err != nil {
+			continue
+		}
+
+		if !selector.Matches(vol.Labels) {
+			continue
+		}
+
 		if recursion == 0 {
 			apiEndpoint, err := storagePoolVolumeTypeToAPIEndpoint(volumeType)
 			if err != nil {
@@ -114,11 +150,6 @@ func storagePoolVolumesTypeGet(d *Daemon, r *http.Request) Response {
 			}
 			resultString = append(resultString, fmt.Sprintf("/%s/storage-pools/%s/volumes/%s/%s", version.APIVersion, poolName, apiEndpoint, volume))
 		} else {
-			_, vol, err := dbStoragePoolVolumeGetType(d.db, volume, volumeType, poolID)
-			if err != nil {
-				continue
-			}
-
 			volumeUsedBy, err := storagePoolVolumeUsedByGet(d, vol.Name, vol.Type)
 			if err != nil {
 				return SmartError(err)
@@ -152,6 +183,10 @@ func storagePoolVolumesTypePost(d *Daemon, r *http.Request) Response {
 		return BadRequest(fmt.Errorf("No name provided"))
 	}
 
+	if shared.IsSnapshot(req.Name) {
+		return BadRequest(fmt.Errorf("Storage volume names may not contain slashes"))
+	}
+
 	// Check that the user gave use a storage volume type for the storage
 	// volume we are about to create.
 	if req.Type == "" {
@@ -162,7 +197,30 @@ func storagePoolVolumesTypePost(d *Daemon, r *http.Request) Response {
 	// volume is supposed to be created.
 	poolName := mux.Vars(r)["name"]
 
-	err = storagePoolVolumeCreateInternal(d, poolName, req.Name, req.Description, req.Type, req.Config)
+	err = shared.ValidLabels(req.Labels)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	if req.Source.Name != "" {
+		if req.Type != storagePoolVolumeTypeNameCustom {
+			return BadRequest(fmt.Errorf("only custom storage volumes can be copied"))
+		}
+
+		err = storagePoolVolumeCopyInternal(d, poolName, req.Name, req.Description, req.Config, req.Source)
+	} else {
+		err = storagePoolVolumeCreateInternal(d, poolName, req.Name, req.Description, req.Type, req.Config)
+	}
+	if err != nil {
+		return SmartError(err)
+	}
+
+	volumeType, err := storagePoolVolumeTypeNameToType(req.Type)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	err = storagePoolVolumeLabelsUpdate(d, poolName, req.Name, volumeType, req.Labels)
 	if err != nil {
 		return InternalError(err)
 	}
@@ -224,6 +282,106 @@ func storagePoolVolumeTypeGet(d *Daemon, r *http.Request) Response {
 	return SyncResponseETag(true, volume, etag)
 }
 
+// /1.0/storage-pools/{pool}/volumes/{type}/{name}
+// Rename a custom storage volume and the disks of the containers and profiles
+// using it.
+func storagePoolVolumeTypePost(d *Daemon, r *http.Request) Response {
+	volumeName := mux.Vars(r)["name"]
+	poolName := mux.Vars(r)["pool"]
+	volumeTypeName := mux.Vars(r)["type"]
+
+	req := api.StorageVolumePost{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	if req.Name == "" {
+		return BadRequest(fmt.Errorf("No name provided"))
+	}
+
+	if shared.IsSnapshot(req.Name) {
+		return BadRequest(fmt.Errorf("Storage volume names may not contain slashes"))
+	}
+
+	volumeType, err := storagePoolVolumeTypeNameToType(volumeTypeName)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	if volumeType != storagePoolVolumeTypeCustom {
+		return BadRequest(fmt.Errorf("storage volumes of type \"%s\" cannot be renamed with the storage api", volumeTypeName))
+	}
+
+	poolID, err := dbStoragePoolGetID(d.db, poolName)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	_, _, err = dbStoragePoolVolumeGetType(d.db, req.Name, volumeType, poolID)
+	if err == nil {
+		return Conflict
+	}
+
+	// The volume can't be moved under the feet of running containers.
+	cts, err := dbContainersList(d.db, cTypeRegular)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	for _, ct := range cts {
+		c, err := containerLoadByName(d, ct)
+		if err != nil {
+			return SmartError(err)
+		}
+
+		if !c.IsRunning() {
+			continue
+		}
+
+		for _, device := range c.ExpandedDevices() {
+			if storagePoolVolumeDeviceUses(device, poolName, volumeName) {
+				return BadRequest(fmt.Errorf("Storage volume \"%s\" is in use by the running container \"%s\"", volumeName, ct))
+			}
+		}
+	}
+
+	s, err := storagePoolVolumeInit(d, poolName, volumeName, volumeType)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	snapshots, err := dbStoragePoolVolumeSnapshotsGet(d.db, volumeName, poolID)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	err = s.StoragePoolVolumeRename(req.Name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	err = dbStoragePoolVolumeRename(d.db, volumeName, req.Name, volumeType, poolID)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	for _, snapshot := range snapshots {
+		_, snapshotName, _ := containerGetParentAndSnapshotName(snapshot)
+		err = dbStoragePoolVolumeRename(d.db, snapshot, req.Name+shared.SnapshotDelimiter+snapshotName, volumeType, poolID)
+		if err != nil {
+			return SmartError(err)
+		}
+	}
+
+	err = storagePoolVolumeUsersRename(d, poolName, volumeName, req.Name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	return SyncResponseLocation(true, nil, fmt.Sprintf("/%s/storage-pools/%s/volumes/%s/%s", version.APIVersion, poolName, storagePoolVolumeTypeNameCustom, req.Name))
+}
+
 // /1.0/storage-pools/{pool}/volumes/{type}/{name}
 func storagePoolVolumeTypePut(d *Daemon, r *http.Request) Response {
 	// Get the name of the storage volume.
@@ -270,17 +428,41 @@ func storagePoolVolumeTypePut(d *Daemon, r *http.Request) Response {
 		return BadRequest(err)
 	}
 
+	// Restore the volume from one of its snapshots
+	if req.Restore != "" {
+		if volumeType != storagePoolVolumeTypeCustom {
+			return BadRequest(fmt.Errorf("only custom storage volumes support snapshots"))
+		}
+
+		err = storagePoolVolumeSnapshotRestore(d, poolName, volumeName, req.Restore)
+		if err != nil {
+			return SmartError(err)
+		}
+
+		return EmptySyncResponse
+	}
+
 	// Validate the configuration
 	err = storageVolumeValidateConfig(volumeName, req.Config, pool)
 	if err != nil {
 		return BadRequest(err)
 	}
 
+	err = shared.ValidLabels(req.Labels)
+	if err != nil {
+		return BadRequest(err)
+	}
+
 	err = storagePoolVolumeUpdate(d, poolName, volumeName, volumeType, req.Description, req.Config)
 	if err != nil {
 		return SmartError(err)
 	}
 
+	err = storagePoolVolumeLabelsUpdate(d, poolName, volumeName, volumeType, req.Labels)
+	if err != nil {
+		return SmartError(err)
+	}
+
 	return EmptySyncResponse
 }
 
@@ -343,17 +525,36 @@ func storagePoolVolumeTypePatch(d *Daemon, r *http.Request) Response {
 		}
 	}
 
+	if req.Labels != nil {
+		for k, v := range volume.Labels {
+			_, ok := req.Labels[k]
+			if !ok {
+				req.Labels[k] = v
+			}
+		}
+	}
+
 	// Validate the configuration
 	err = storageVolumeValidateConfig(volumeName, req.Config, pool)
 	if err != nil {
 		return BadRequest(err)
 	}
 
+	err = shared.ValidLabels(req.Labels)
+	if err != nil {
+		return BadRequest(err)
+	}
+
 	err = storagePoolVolumeUpdate(d, poolName, volumeName, volumeType, req.Description, req.Config)
 	if err != nil {
 		return SmartError(err)
 	}
 
+	err = storagePoolVolumeLabelsUpdate(d, poolName, volumeName, volumeType, req.Labels)
+	if err != nil {
+		return SmartError(err)
+	}
+
 	return EmptySyncResponse
 }
 
@@ -389,15 +590,30 @@ func storagePoolVolumeTypeDelete(d *Daemon, r *http.Request) Response {
 	}
 
 	if len(volumeUsedBy) > 0 {
-		return BadRequest(fmt.Errorf("the storage volume is still in use by containers or profiles"))
+		return BadRequest(storageDeleteBlocked(poolName, volumeName, volumeUsedBy, false))
+	}
+
+	if dryRunRequested(r) {
+		plan, err := storagePoolVolumeDeletePlan(d, poolName, volumeName, volumeType)
+		if err != nil {
+			return SmartError(err)
+		}
+
+		return SyncResponse(true, plan)
 	}
 
+	storageFreezeEnter()
+	defer storageFreezeLeave()
+
 	s, err := storagePoolVolumeInit(d, poolName, volumeName, volumeType)
 	if err != nil {
 		return NotFound
 	}
 
+	audit := storageAuditStart(r, poolName, "volume_delete", volumeName)
+	s.StorageAuditSet(audit)
 	err = s.StoragePoolVolumeDelete()
+	audit.finish(d, err)
 	if err != nil {
 		return SmartError(err)
 	}
@@ -407,6 +623,12 @@ func storagePoolVolumeTypeDelete(d *Daemon, r *http.Request) Response {
 		return SmartError(err)
 	}
 
+	// The snapshots were removed along with the volume.
+	err = storagePoolVolumeSnapshotsDeleteAll(d, volumeName, poolID)
+	if err != nil {
+		return SmartError(err)
+	}
+
 	err = dbStoragePoolVolumeDelete(d.db, volumeName, volumeType, poolID)
 	if err != nil {
 		return SmartError(err)
@@ -415,4 +637,4 @@ func storagePoolVolumeTypeDelete(d *Daemon, r *http.Request) Response {
 	return EmptySyncResponse
 }
 
-var storagePoolVolumeTypeCmd = Command{name: "storage-pools/{pool}/volumes/{type}/{name:.*}", get: storagePoolVolumeTypeGet, put: storagePoolVolumeTypePut, patch: storagePoolVolumeTypePatch, delete: storagePoolVolumeTypeDelete}
+var storagePoolVolumeTypeCmd = Command{name: "storage-pools/{pool}/volumes/{type}/{name:.*}", get: storagePoolVolumeTypeGet, post: storagePoolVolumeTypePost, put: storagePoolVolumeTypePut, patch: storagePoolVolumeTypePatch, delete: storagePoolVolumeTypeDelete}
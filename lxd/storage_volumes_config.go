@@ -9,10 +9,12 @@ import (
 )
 
 var storageVolumeConfigKeys = map[string]func(value string) error{
+	"block.device":        shared.IsAny,
 	"block.mount_options": shared.IsAny,
 	"block.filesystem": func(value string) error {
 		return shared.IsOneOf(value, []string{"ext4", "xfs"})
 	},
+	"shared": shared.IsBool,
 	"size": func(value string) error {
 		if value == "" {
 			return nil
@@ -22,9 +24,30 @@ var storageVolumeConfigKeys = map[string]func(value string) error{
 		return err
 	},
 	"zfs.use_refquota":     shared.IsBool,
+	"zfs.use_reserve":      shared.IsBool,
 	"zfs.remove_snapshots": shared.IsBool,
-	"volatile.idmap.last":  shared.IsAny,
-	"volatile.idmap.next":  shared.IsAny,
+	"zfs.reservation": func(value string) error {
+		if value == "" {
+			return nil
+		}
+
+		_, err := shared.ParseByteSizeString(value)
+		return err
+	},
+	"zfs.sync": func(value string) error {
+		return shared.IsOneOf(value, []string{"standard", "always", "disabled"})
+	},
+	"zfs.logbias": func(value string) error {
+		return shared.IsOneOf(value, []string{"latency", "throughput"})
+	},
+	"zfs.primarycache": func(value string) error {
+		return shared.IsOneOf(value, []string{"all", "none", "metadata"})
+	},
+	"zfs.secondarycache": func(value string) error {
+		return shared.IsOneOf(value, []string{"all", "none", "metadata"})
+	},
+	"volatile.idmap.last": shared.IsAny,
+	"volatile.idmap.next": shared.IsAny,
 }
 
 func storageVolumeValidateConfig(name string, config map[string]string, parentPool *api.StoragePool) error {
@@ -34,6 +57,15 @@ func storageVolumeValidateConfig(name string, config map[string]string, parentPo
 			continue
 		}
 
+		// The "external.*" keys are handed to the external driver as is.
+		if strings.HasPrefix(key, "external.") {
+			if parentPool.Driver != "external" {
+				return fmt.Errorf("the key %s can only be used with external storage volumes", key)
+			}
+
+			continue
+		}
+
 		// Validate storage volume config keys.
 		validator, ok := storageVolumeConfigKeys[key]
 		if !ok {
@@ -53,6 +85,30 @@ func storageVolumeValidateConfig(name string, config map[string]string, parentPo
 			if config["zfs.remove_snapshots"] != "" {
 				return fmt.Errorf("the key volume.zfs.remove_snapshots cannot be used with non zfs storage volumes")
 			}
+
+			if config["zfs.use_reserve"] != "" {
+				return fmt.Errorf("the key zfs.use_reserve cannot be used with non zfs storage volumes")
+			}
+
+			if config["zfs.reservation"] != "" {
+				return fmt.Errorf("the key zfs.reservation cannot be used with non zfs storage volumes")
+			}
+
+			if config["zfs.sync"] != "" {
+				return fmt.Errorf("the key zfs.sync cannot be used with non zfs storage volumes")
+			}
+
+			if config["zfs.logbias"] != "" {
+				return fmt.Errorf("the key zfs.logbias cannot be used with non zfs storage volumes")
+			}
+
+			if config["zfs.primarycache"] != "" {
+				return fmt.Errorf("the key zfs.primarycache cannot be used with non zfs storage volumes")
+			}
+
+			if config["zfs.secondarycache"] != "" {
+				return fmt.Errorf("the key zfs.secondarycache cannot be used with non zfs storage volumes")
+			}
 		}
 
 		if parentPool.Driver == "dir" {
@@ -68,6 +124,14 @@ func storageVolumeValidateConfig(name string, config map[string]string, parentPo
 				return fmt.Errorf("the key size cannot be used with dir storage volumes")
 			}
 		}
+
+		if parentPool.Driver == "block" {
+			if config["size"] != "" {
+				return fmt.Errorf("the key size cannot be used with block storage volumes")
+			}
+		} else if config["block.device"] != "" {
+			return fmt.Errorf("the key block.device can only be used with block storage volumes")
+		}
 	}
 
 	return nil
@@ -76,6 +140,14 @@ func storageVolumeValidateConfig(name string, config map[string]string, parentPo
 func storageVolumeFillDefault(name string, config map[string]string, parentPool *api.StoragePool) error {
 	if parentPool.Driver == "dir" {
 		config["size"] = ""
+	} else if parentPool.Driver == "block" {
+		if config["block.filesystem"] == "" {
+			config["block.filesystem"] = parentPool.Config["volume.block.filesystem"]
+		}
+		if config["block.filesystem"] == "" {
+			// Unchangeable volume property: Set unconditionally.
+			config["block.filesystem"] = "ext4"
+		}
 	} else if parentPool.Driver == "lvm" {
 		if config["block.filesystem"] == "" {
 			config["block.filesystem"] = parentPool.Config["volume.block.filesystem"]
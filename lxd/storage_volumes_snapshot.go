@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/version"
+)
+
+// storagePoolVolumeSnapshotLoad checks that the custom storage volume and
+// optionally its snapshot exist and returns the ID of the storage pool.
+func storagePoolVolumeSnapshotLoad(d *Daemon, r *http.Request, snapshotName string) (int64, Response) {
+	poolName := mux.Vars(r)["pool"]
+	volumeName := mux.Vars(r)["name"]
+
+	if mux.Vars(r)["type"] != storagePoolVolumeTypeNameCustom {
+		return -1, BadRequest(fmt.Errorf("only custom storage volumes support snapshots"))
+	}
+
+	poolID, err := dbStoragePoolGetID(d.db, poolName)
+	if err != nil {
+		return -1, SmartError(err)
+	}
+
+	_, _, err = dbStoragePoolVolumeGetType(d.db, volumeName, storagePoolVolumeTypeCustom, poolID)
+	if err != nil {
+		return -1, SmartError(err)
+	}
+
+	if snapshotName != "" {
+		_, _, err = dbStoragePoolVolumeGetType(d.db, volumeName+shared.SnapshotDelimiter+snapshotName, storagePoolVolumeTypeCustom, poolID)
+		if err != nil {
+			return -1, SmartError(err)
+		}
+	}
+
+	return poolID, nil
+}
+
+// /1.0/storage-pools/{pool}/volumes/{type}/{name}/snapshots
+// List the snapshots of a custom storage volume.
+func storagePoolVolumeSnapshotsGet(d *Daemon, r *http.Request) Response {
+	poolName := mux.Vars(r)["pool"]
+	volumeName := mux.Vars(r)["name"]
+
+	recursionStr := r.FormValue("recursion")
+	recursion, err := strconv.Atoi(recursionStr)
+	if err != nil {
+		recursion = 0
+	}
+
+	poolID, resp := storagePoolVolumeSnapshotLoad(d, r, "")
+	if resp != nil {
+		return resp
+	}
+
+	snapshots, err := dbStoragePoolVolumeSnapshotsGet(d.db, volumeName, poolID)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	resultString := []string{}
+	resultMap := []*api.StorageVolumeSnapshot{}
+	for _, snapshot := range snapshots {
+		_, snapshotName, _ := containerGetParentAndSnapshotName(snapshot)
+		if recursion == 0 {
+			resultString = append(resultString, fmt.Sprintf("/%s/storage-pools/%s/volumes/%s/%s/snapshots/%s", version.APIVersion, poolName, storagePoolVolumeTypeNameCustom, volumeName, snapshotName))
+		} else {
+			_, volume, err := dbStoragePoolVolumeGetType(d.db, snapshot, storagePoolVolumeTypeCustom, poolID)
+			if err != nil {
+				continue
+			}
+
+			resultMap = append(resultMap, &api.StorageVolumeSnapshot{Name: snapshotName, Config: volume.Config})
+		}
+	}
+
+	if recursion == 0 {
+		return SyncResponse(true, resultString)
+	}
+
+	return SyncResponse(true, resultMap)
+}
+
+// /1.0/storage-pools/{pool}/volumes/{type}/{name}/snapshots
+// Create a snapshot of a custom storage volume.
+func storagePoolVolumeSnapshotsPost(d *Daemon, r *http.Request) Response {
+	poolName := mux.Vars(r)["pool"]
+	volumeName := mux.Vars(r)["name"]
+
+	req := api.StorageVolumeSnapshotsPost{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	if req.Name == "" {
+		return BadRequest(fmt.Errorf("No name provided"))
+	}
+
+	if strings.Contains(req.Name, shared.SnapshotDelimiter) {
+		return BadRequest(fmt.Errorf("Invalid snapshot name '%s'", req.Name))
+	}
+
+	poolID, resp := storagePoolVolumeSnapshotLoad(d, r, "")
+	if resp != nil {
+		return resp
+	}
+
+	fullName := volumeName + shared.SnapshotDelimiter + req.Name
+	_, _, err = dbStoragePoolVolumeGetType(d.db, fullName, storagePoolVolumeTypeCustom, poolID)
+	if err == nil {
+		return Conflict
+	}
+
+	s, err := storagePoolVolumeInit(d, poolName, volumeName, storagePoolVolumeTypeCustom)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	storageFreezeEnter()
+	defer storageFreezeLeave()
+
+	err = s.StoragePoolVolumeSnapshotCreate(req.Name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	// The snapshot keeps the config the volume had when it was taken.
+	volume := s.GetStoragePoolVolumeWritable()
+	_, err = dbStoragePoolVolumeCreate(d.db, fullName, "", storagePoolVolumeTypeCustom, poolID, volume.Config)
+	if err != nil {
+		s.StoragePoolVolumeSnapshotDelete(req.Name)
+		return SmartError(err)
+	}
+
+	return SyncResponseLocation(true, nil, fmt.Sprintf("/%s/storage-pools/%s/volumes/%s/%s/snapshots/%s", version.APIVersion, poolName, storagePoolVolumeTypeNameCustom, volumeName, req.Name))
+}
+
+// /1.0/storage-pools/{pool}/volumes/{type}/{name}/snapshots/{snapshotName}
+// Get a snapshot of a custom storage volume.
+func storagePoolVolumeSnapshotGet(d *Daemon, r *http.Request) Response {
+	volumeName := mux.Vars(r)["name"]
+	snapshotName := mux.Vars(r)["snapshotName"]
+
+	poolID, resp := storagePoolVolumeSnapshotLoad(d, r, snapshotName)
+	if resp != nil {
+		return resp
+	}
+
+	_, volume, err := dbStoragePoolVolumeGetType(d.db, volumeName+shared.SnapshotDelimiter+snapshotName, storagePoolVolumeTypeCustom, poolID)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	return SyncResponse(true, &api.StorageVolumeSnapshot{Name: snapshotName, Config: volume.Config})
+}
+
+// /1.0/storage-pools/{pool}/volumes/{type}/{name}/snapshots/{snapshotName}
+// Rename a snapshot of a custom storage volume.
+func storagePoolVolumeSnapshotPost(d *Daemon, r *http.Request) Response {
+	poolName := mux.Vars(r)["pool"]
+	volumeName := mux.Vars(r)["name"]
+	snapshotName := mux.Vars(r)["snapshotName"]
+
+	req := api.StorageVolumeSnapshotPost{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	if req.Name == "" {
+		return BadRequest(fmt.Errorf("No name provided"))
+	}
+
+	if strings.Contains(req.Name, shared.SnapshotDelimiter) {
+		return BadRequest(fmt.Errorf("Invalid snapshot name '%s'", req.Name))
+	}
+
+	poolID, resp := storagePoolVolumeSnapshotLoad(d, r, snapshotName)
+	if resp != nil {
+		return resp
+	}
+
+	newFullName := volumeName + shared.SnapshotDelimiter + req.Name
+	_, _, err = dbStoragePoolVolumeGetType(d.db, newFullName, storagePoolVolumeTypeCustom, poolID)
+	if err == nil {
+		return Conflict
+	}
+
+	s, err := storagePoolVolumeInit(d, poolName, volumeName, storagePoolVolumeTypeCustom)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	err = s.StoragePoolVolumeSnapshotRename(snapshotName, req.Name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	err = dbStoragePoolVolumeRename(d.db, volumeName+shared.SnapshotDelimiter+snapshotName, newFullName, storagePoolVolumeTypeCustom, poolID)
+	if err != nil {
+		s.StoragePoolVolumeSnapshotRename(req.Name, snapshotName)
+		return SmartError(err)
+	}
+
+	return SyncResponseLocation(true, nil, fmt.Sprintf("/%s/storage-pools/%s/volumes/%s/%s/snapshots/%s", version.APIVersion, poolName, storagePoolVolumeTypeNameCustom, volumeName, req.Name))
+}
+
+// /1.0/storage-pools/{pool}/volumes/{type}/{name}/snapshots/{snapshotName}
+// Delete a snapshot of a custom storage volume.
+func storagePoolVolumeSnapshotDelete(d *Daemon, r *http.Request) Response {
+	poolName := mux.Vars(r)["pool"]
+	volumeName := mux.Vars(r)["name"]
+	snapshotName := mux.Vars(r)["snapshotName"]
+
+	poolID, resp := storagePoolVolumeSnapshotLoad(d, r, snapshotName)
+	if resp != nil {
+		return resp
+	}
+
+	s, err := storagePoolVolumeInit(d, poolName, volumeName, storagePoolVolumeTypeCustom)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	storageFreezeEnter()
+	defer storageFreezeLeave()
+
+	err = s.StoragePoolVolumeSnapshotDelete(snapshotName)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	err = dbStoragePoolVolumeDelete(d.db, volumeName+shared.SnapshotDelimiter+snapshotName, storagePoolVolumeTypeCustom, poolID)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	return EmptySyncResponse
+}
+
+// storageVolumeSnapshotRestorePruner is implemented by the drivers which
+// remove the snapshots newer than the one a custom volume is restored from.
+type storageVolumeSnapshotRestorePruner interface {
+	storagePoolVolumeSnapshotRestoreRemovesNewer() bool
+}
+
+// storagePoolVolumeSnapshotRestore restores a custom storage volume from one
+// of its snapshots.
+func storagePoolVolumeSnapshotRestore(d *Daemon, poolName string, volumeName string, snapshotName string) error {
+	poolID, err := dbStoragePoolGetID(d.db, poolName)
+	if err != nil {
+		return err
+	}
+
+	fullName := volumeName + shared.SnapshotDelimiter + snapshotName
+	_, _, err = dbStoragePoolVolumeGetType(d.db, fullName, storagePoolVolumeTypeCustom, poolID)
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := dbStoragePoolVolumeSnapshotsGet(d.db, volumeName, poolID)
+	if err != nil {
+		return err
+	}
+
+	newer := []string{}
+	for i, snapshot := range snapshots {
+		if snapshot == fullName {
+			newer = snapshots[i+1:]
+			break
+		}
+	}
+
+	s, err := storagePoolVolumeInit(d, poolName, volumeName, storagePoolVolumeTypeCustom)
+	if err != nil {
+		return err
+	}
+
+	err = s.StoragePoolVolumeSnapshotRestore(snapshotName)
+	if err != nil {
+		return err
+	}
+
+	// The newer snapshots removed by the driver go from the database too.
+	pruner, ok := storageUnwrap(s).(storageVolumeSnapshotRestorePruner)
+	if !ok || !pruner.storagePoolVolumeSnapshotRestoreRemovesNewer() {
+		return nil
+	}
+
+	for _, snapshot := range newer {
+		err := dbStoragePoolVolumeDelete(d.db, snapshot, storagePoolVolumeTypeCustom, poolID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// storagePoolVolumeSnapshotsDeleteAll removes the database entries of all the
+// snapshots of a custom storage volume.
+func storagePoolVolumeSnapshotsDeleteAll(d *Daemon, volumeName string, poolID int64) error {
+	snapshots, err := dbStoragePoolVolumeSnapshotsGet(d.db, volumeName, poolID)
+	if err != nil {
+		return err
+	}
+
+	for _, snapshot := range snapshots {
+		err := dbStoragePoolVolumeDelete(d.db, snapshot, storagePoolVolumeTypeCustom, poolID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var storagePoolVolumeSnapshotsCmd = Command{name: "storage-pools/{pool}/volumes/{type}/{name}/snapshots", get: storagePoolVolumeSnapshotsGet, post: storagePoolVolumeSnapshotsPost}
+
+var storagePoolVolumeSnapshotCmd = Command{name: "storage-pools/{pool}/volumes/{type}/{name}/snapshots/{snapshotName}", get: storagePoolVolumeSnapshotGet, post: storagePoolVolumeSnapshotPost, delete: storagePoolVolumeSnapshotDelete}
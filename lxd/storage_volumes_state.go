@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// /1.0/storage-pools/{pool}/volumes/{type}/{name}/state
+// Get the space used by a custom storage volume.
+func storagePoolVolumeStateGet(d *Daemon, r *http.Request) Response {
+	poolName := mux.Vars(r)["pool"]
+	volumeName := mux.Vars(r)["name"]
+
+	if mux.Vars(r)["type"] != storagePoolVolumeTypeNameCustom {
+		return BadRequest(fmt.Errorf("only custom storage volumes report their state"))
+	}
+
+	poolID, err := dbStoragePoolGetID(d.db, poolName)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	_, _, err = dbStoragePoolVolumeGetType(d.db, volumeName, storagePoolVolumeTypeCustom, poolID)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	s, err := storagePoolVolumeInit(d, poolName, volumeName, storagePoolVolumeTypeCustom)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	state, err := s.StoragePoolVolumeGetState()
+	if err != nil {
+		return SmartError(err)
+	}
+
+	return SyncResponse(true, state)
+}
+
+var storagePoolVolumeStateCmd = Command{name: "storage-pools/{pool}/volumes/{type}/{name}/state", get: storagePoolVolumeStateGet}
@@ -6,8 +6,13 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/lxc/lxd/lxd/types"
 	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/logger"
 	"github.com/lxc/lxd/shared/version"
+
+	log "gopkg.in/inconshreveable/log15.v2"
 )
 
 const (
@@ -116,12 +121,13 @@ func storagePoolVolumeUpdate(d *Daemon, poolName string, volumeName string, volu
 		}
 	}()
 
-	// Diff the configurations
+	// Diff the configurations. The "shared" property is only used by LXD
+	// itself and doesn't need to be passed to the storage driver.
 	changedConfig := []string{}
 	userOnly := true
 	for key := range oldConfig {
 		if oldConfig[key] != newConfig[key] {
-			if !strings.HasPrefix(key, "user.") {
+			if !strings.HasPrefix(key, "user.") && key != "shared" {
 				userOnly = false
 			}
 
@@ -133,7 +139,7 @@ func storagePoolVolumeUpdate(d *Daemon, poolName string, volumeName string, volu
 
 	for key := range newConfig {
 		if oldConfig[key] != newConfig[key] {
-			if !strings.HasPrefix(key, "user.") {
+			if !strings.HasPrefix(key, "user.") && key != "shared" {
 				userOnly = false
 			}
 
@@ -145,17 +151,16 @@ func storagePoolVolumeUpdate(d *Daemon, poolName string, volumeName string, volu
 
 	// Apply config changes if there are any
 	if len(changedConfig) != 0 {
+		newWritable.Config = newConfig
 
 		// Update the storage pool
 		if !userOnly {
-			err = s.StoragePoolVolumeUpdate(changedConfig)
+			err = s.StoragePoolVolumeUpdate(&newWritable, changedConfig)
 			if err != nil {
 				return err
 			}
 		}
 
-		newWritable.Config = newConfig
-
 		// Apply the new configuration
 		s.SetStoragePoolVolumeWritable(&newWritable)
 	}
@@ -278,6 +283,105 @@ func profilesUsingPoolVolumeGetNames(db *sql.DB, volumeName string, volumeType s
 	return usedBy, nil
 }
 
+// storagePoolVolumeDeviceUses tells whether a device is a disk backed by the
+// given custom storage volume.
+func storagePoolVolumeDeviceUses(device map[string]string, poolName string, volumeName string) bool {
+	if device["type"] != "disk" || device["pool"] != poolName {
+		return false
+	}
+
+	// Make sure that we don't compare against stuff like "custom////bla"
+	// but only against "custom/bla".
+	cleanSource := filepath.Clean(device["source"])
+	return cleanSource == volumeName || cleanSource == fmt.Sprintf("%s/%s", storagePoolVolumeTypeNameCustom, volumeName)
+}
+
+// storagePoolVolumeDevicesRename returns a copy of a list of devices where the
+// disks backed by a custom storage volume use its new name, and whether there
+// were any.
+func storagePoolVolumeDevicesRename(devices map[string]map[string]string, poolName string, oldName string, newName string) (map[string]map[string]string, bool) {
+	renamed := false
+	result := map[string]map[string]string{}
+	for name, device := range devices {
+		newDevice := map[string]string{}
+		for k, v := range device {
+			newDevice[k] = v
+		}
+
+		if storagePoolVolumeDeviceUses(device, poolName, oldName) {
+			newDevice["source"] = newName
+			renamed = true
+		}
+
+		result[name] = newDevice
+	}
+
+	return result, renamed
+}
+
+// storagePoolVolumeUsersRename points the disks of the containers and profiles
+// backed by a custom storage volume to its new name.
+func storagePoolVolumeUsersRename(d *Daemon, poolName string, oldName string, newName string) error {
+	cts, err := dbContainersList(d.db, cTypeRegular)
+	if err != nil {
+		return err
+	}
+
+	for _, ct := range cts {
+		c, err := containerLoadByName(d, ct)
+		if err != nil {
+			return err
+		}
+
+		devices, renamed := storagePoolVolumeDevicesRename(c.LocalDevices(), poolName, oldName, newName)
+		if !renamed {
+			continue
+		}
+
+		args := containerArgs{
+			Architecture: c.Architecture(),
+			Description:  c.Description(),
+			Labels:       c.Labels(),
+			Config:       c.LocalConfig(),
+			Devices:      types.Devices(devices),
+			Ephemeral:    c.IsEphemeral(),
+			Profiles:     c.Profiles(),
+			UpdateSource: containerUpdateSourceStorage,
+		}
+
+		err = c.Update(args, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	profiles, err := dbProfiles(d.db)
+	if err != nil {
+		return err
+	}
+
+	for _, pName := range profiles {
+		id, profile, err := dbProfileGet(d.db, pName)
+		if err != nil {
+			return err
+		}
+
+		devices, renamed := storagePoolVolumeDevicesRename(profile.Devices, poolName, oldName, newName)
+		if !renamed {
+			continue
+		}
+
+		req := profile.Writable()
+		req.Devices = devices
+		err = doProfileUpdateDb(d, id, profile, req)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func storagePoolVolumeDBCreate(d *Daemon, poolName string, volumeName, volumeDescription string, volumeTypeName string, volumeConfig map[string]string) error {
 	// Check that the name of the new storage volume is valid. (For example.
 	// zfs pools cannot contain "/" in their names.)
@@ -328,6 +432,19 @@ func storagePoolVolumeDBCreate(d *Daemon, poolName string, volumeName, volumeDes
 		return err
 	}
 
+	size := int64(0)
+	if volumeConfig["size"] != "" {
+		size, err = shared.ParseByteSizeString(volumeConfig["size"])
+		if err != nil {
+			return err
+		}
+	}
+
+	err = storagePoolQuotaCheck(d, poolName, size)
+	if err != nil {
+		return err
+	}
+
 	// Create the database entry for the storage volume.
 	_, err = dbStoragePoolVolumeCreate(d.db, volumeName, volumeDescription, volumeType, poolID, volumeConfig)
 	if err != nil {
@@ -365,3 +482,242 @@ func storagePoolVolumeCreateInternal(d *Daemon, poolName string, volumeName, vol
 
 	return nil
 }
+
+// storagePoolVolumeCopyInternal creates a custom storage volume as a copy of
+// another one of the same pool, with the configuration of the source
+// overridden by volumeConfig.
+func storagePoolVolumeCopyInternal(d *Daemon, poolName string, volumeName string, volumeDescription string, volumeConfig map[string]string, source api.StorageVolumeSource) error {
+	poolID, err := dbStoragePoolGetID(d.db, poolName)
+	if err != nil {
+		return err
+	}
+
+	_, sourceVolume, err := dbStoragePoolVolumeGetType(d.db, source.Name, storagePoolVolumeTypeCustom, poolID)
+	if err != nil {
+		return err
+	}
+
+	config := map[string]string{}
+	for k, v := range sourceVolume.Config {
+		config[k] = v
+	}
+
+	for k, v := range volumeConfig {
+		config[k] = v
+	}
+
+	err = storagePoolVolumeDBCreate(d, poolName, volumeName, volumeDescription, storagePoolVolumeTypeNameCustom, config)
+	if err != nil {
+		return err
+	}
+
+	s, err := storagePoolVolumeInit(d, poolName, volumeName, storagePoolVolumeTypeCustom)
+	if err != nil {
+		dbStoragePoolVolumeDelete(d.db, volumeName, storagePoolVolumeTypeCustom, poolID)
+		return err
+	}
+
+	storageFreezeEnter()
+	defer storageFreezeLeave()
+
+	err = s.StoragePoolVolumeCopy(source.Name, source.Flatten)
+	if err != nil {
+		dbStoragePoolVolumeDelete(d.db, volumeName, storagePoolVolumeTypeCustom, poolID)
+		return err
+	}
+
+	return nil
+}
+
+// storagePoolVolumeCopyRsync creates a custom storage volume and copies
+// another one of the same pool into it with rsync, for the drivers which
+// can't copy volumes natively.
+func storagePoolVolumeCopyRsync(d *Daemon, s storage, poolName string, sourceName string, volumeName string) error {
+	source, err := storagePoolVolumeInit(d, poolName, sourceName, storagePoolVolumeTypeCustom)
+	if err != nil {
+		return err
+	}
+
+	err = s.StoragePoolVolumeCreate()
+	if err != nil {
+		return err
+	}
+
+	revert := true
+	defer func() {
+		if !revert {
+			return
+		}
+
+		s.StoragePoolVolumeDelete()
+	}()
+
+	// Every mount takes a reference, released whether or not it mounted
+	// the volume.
+	_, err = source.StoragePoolVolumeMount()
+	if err != nil {
+		return err
+	}
+	defer source.StoragePoolVolumeUmount()
+
+	_, err = s.StoragePoolVolumeMount()
+	if err != nil {
+		return err
+	}
+	defer s.StoragePoolVolumeUmount()
+
+	poolConfig := s.GetStoragePoolWritable().Config
+	output, err := rsyncLocalCopy(getStoragePoolVolumeMountPoint(poolName, sourceName), getStoragePoolVolumeMountPoint(poolName, volumeName), poolConfig["rsync.bwlimit"], rsyncPoolLocalArgs(poolConfig)...)
+	if err != nil {
+		return fmt.Errorf("Failed to copy the storage volume: %s", output)
+	}
+
+	revert = false
+	return nil
+}
+
+// storagePoolVolumeDeviceName returns the name of the custom storage volume
+// used by a disk device, if any.
+func storagePoolVolumeDeviceName(m types.Device) (string, bool) {
+	if m["type"] != "disk" || m["pool"] == "" || shared.IsTrue(m["scratch"]) {
+		return "", false
+	}
+
+	source := filepath.Clean(m["source"])
+	fields := strings.SplitN(source, "/", 2)
+	if len(fields) == 2 {
+		if fields[0] != storagePoolVolumeTypeNameCustom {
+			return "", false
+		}
+
+		return fields[1], true
+	}
+
+	return source, true
+}
+
+// storagePoolVolumeAttachCheck makes sure that the custom storage volumes used
+// by the disk devices of a container are only attached read-write to several
+// containers if their "shared" property allows it.
+func storagePoolVolumeAttachCheck(d *Daemon, name string, devices types.Devices) error {
+	var others []container
+
+	for _, m := range devices {
+		volumeName, ok := storagePoolVolumeDeviceName(m)
+		if !ok {
+			continue
+		}
+
+		poolID, pool, err := dbStoragePoolGet(d.db, m["pool"])
+		if err != nil {
+			return err
+		}
+
+		_, volume, err := dbStoragePoolVolumeGetType(d.db, volumeName, storagePoolVolumeTypeCustom, poolID)
+		if err != nil {
+			// Missing volumes are reported when the device is set up.
+			continue
+		}
+
+		volumeShared := volume.Config["shared"]
+		if volumeShared == "" {
+			volumeShared = pool.Config["volume.shared"]
+		}
+
+		if shared.IsTrue(volumeShared) {
+			continue
+		}
+
+		if others == nil {
+			cts, err := dbContainersList(d.db, cTypeRegular)
+			if err != nil {
+				return err
+			}
+
+			others = []container{}
+			for _, ct := range cts {
+				if ct == name {
+					continue
+				}
+
+				c, err := containerLoadByName(d, ct)
+				if err != nil {
+					continue
+				}
+
+				others = append(others, c)
+			}
+		}
+
+		readonly := shared.IsTrue(m["readonly"])
+		for _, c := range others {
+			for _, other := range c.ExpandedDevices() {
+				otherName, ok := storagePoolVolumeDeviceName(other)
+				if !ok || other["pool"] != m["pool"] || otherName != volumeName {
+					continue
+				}
+
+				if readonly && shared.IsTrue(other["readonly"]) {
+					continue
+				}
+
+				return fmt.Errorf("Storage volume \"%s\" on storage pool \"%s\" is already attached to container \"%s\", set \"shared\" on the volume to attach it read-write to several containers", volumeName, m["pool"], c.Name())
+			}
+		}
+	}
+
+	return nil
+}
+
+// storagePoolVolumeRefsInit takes a reference on the custom storage volumes
+// attached to the containers which kept running while LXD was down.
+func storagePoolVolumeRefsInit(d *Daemon) {
+	cts, err := dbContainersList(d.db, cTypeRegular)
+	if err != nil {
+		return
+	}
+
+	for _, ct := range cts {
+		c, err := containerLoadByName(d, ct)
+		if err != nil || !c.IsRunning() {
+			continue
+		}
+
+		for _, m := range c.ExpandedDevices() {
+			volumeName, ok := storagePoolVolumeDeviceName(m)
+			if !ok {
+				continue
+			}
+
+			s, err := storagePoolVolumeInit(d, m["pool"], volumeName, storagePoolVolumeTypeCustom)
+			if err != nil {
+				continue
+			}
+
+			_, err = s.StoragePoolVolumeMount()
+			if err != nil {
+				logger.Warn("Failed to mount storage volume", log.Ctx{"container": ct, "pool": m["pool"], "volume": volumeName, "err": err})
+			}
+		}
+	}
+}
+
+// storagePoolVolumeLabelsUpdate replaces the labels of a storage volume,
+// leaving them alone if none were passed.
+func storagePoolVolumeLabelsUpdate(d *Daemon, poolName string, volumeName string, volumeType int, labels map[string]string) error {
+	if labels == nil {
+		return nil
+	}
+
+	poolID, err := dbStoragePoolGetID(d.db, poolName)
+	if err != nil {
+		return err
+	}
+
+	volumeID, err := dbStoragePoolVolumeGetTypeID(d.db, volumeName, volumeType, poolID)
+	if err != nil {
+		return err
+	}
+
+	return dbStorageVolumeLabelsUpdate(d.db, volumeID, labels)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStoragePoolVolumeDevicesRename(t *testing.T) {
+	devices := map[string]map[string]string{
+		"data":  {"type": "disk", "pool": "default", "source": "vol1", "path": "/data"},
+		"typed": {"type": "disk", "pool": "default", "source": "custom//vol1", "path": "/typed"},
+		"other": {"type": "disk", "pool": "other", "source": "vol1", "path": "/other"},
+		"eth0":  {"type": "nic", "nictype": "bridged", "parent": "lxdbr0"},
+	}
+
+	renamed, ok := storagePoolVolumeDevicesRename(devices, "default", "vol1", "vol2")
+	if !ok {
+		t.Fatal("No device was renamed")
+	}
+
+	expected := map[string]map[string]string{
+		"data":  {"type": "disk", "pool": "default", "source": "vol2", "path": "/data"},
+		"typed": {"type": "disk", "pool": "default", "source": "vol2", "path": "/typed"},
+		"other": {"type": "disk", "pool": "other", "source": "vol1", "path": "/other"},
+		"eth0":  {"type": "nic", "nictype": "bridged", "parent": "lxdbr0"},
+	}
+
+	if !reflect.DeepEqual(renamed, expected) {
+		t.Errorf("Unexpected devices: %v", renamed)
+	}
+
+	if devices["data"]["source"] != "vol1" {
+		t.Errorf("The original devices were modified")
+	}
+
+	_, ok = storagePoolVolumeDevicesRename(devices, "default", "vol3", "vol4")
+	if ok {
+		t.Errorf("Devices were renamed for an unused volume")
+	}
+}
@@ -20,7 +20,6 @@ import (
 	"github.com/pborman/uuid"
 )
 
-var zfsUseRefquota = "false"
 var zfsRemoveSnapshots = "false"
 
 type storageZfs struct {
@@ -102,25 +101,42 @@ func (s *storageZfs) StoragePoolCheck() error {
 	}
 
 	poolName := s.getOnDiskPoolName()
+	// The LXD datasets may live below the root dataset of the zpool (e.g.
+	// after a rename), so always import by zpool name.
+	zpoolName := strings.Split(poolName, "/")[0]
+	guid := s.pool.Config["zfs.pool_guid"]
+
+	if zfsFilesystemEntityExists(poolName) {
+		return s.zfsPoolGUIDCheck(zpoolName)
+	}
+
+	if !filepath.IsAbs(source) && guid == "" {
+		return nil
+	}
+	logger.Debugf("ZFS storage pool \"%s\" does not exist. Trying to import it.", poolName)
+
+	// Importing by GUID allows us to find the zpool even if it has been
+	// renamed on the host or another zpool of the same name exists. The
+	// zpool is imported under the name LXD expects.
+	args := []string{"import"}
 	if filepath.IsAbs(source) {
-		if zfsFilesystemEntityExists(poolName) {
-			return nil
-		}
-		logger.Debugf("ZFS storage pool \"%s\" does not exist. Trying to import it.", poolName)
+		args = append(args, "-d", shared.VarPath("disks"))
+	}
 
-		disksPath := shared.VarPath("disks")
-		output, err := shared.RunCommand(
-			"zpool",
-			"import",
-			"-d", disksPath, poolName)
-		if err != nil {
-			return fmt.Errorf("ZFS storage pool \"%s\" could not be imported: %s", poolName, output)
-		}
+	if guid != "" {
+		args = append(args, guid, zpoolName)
+	} else {
+		args = append(args, zpoolName)
+	}
 
-		logger.Debugf("ZFS storage pool \"%s\" successfully imported.", poolName)
+	output, err := shared.RunCommand("zpool", args...)
+	if err != nil {
+		return fmt.Errorf("ZFS storage pool \"%s\" could not be imported: %s", poolName, output)
 	}
 
-	return nil
+	logger.Debugf("ZFS storage pool \"%s\" successfully imported.", poolName)
+
+	return s.zfsPoolGUIDCheck(zpoolName)
 }
 
 func (s *storageZfs) StoragePoolCreate() error {
@@ -175,6 +191,44 @@ func (s *storageZfs) StoragePoolDelete() error {
 	return nil
 }
 
+func (s *storageZfs) StoragePoolDeletePlan(plan *api.OperationPlan) error {
+	poolName := s.getOnDiskPoolName()
+	vdev := s.pool.Config["source"]
+
+	origins, err := s.zfsListOrigins()
+	if err != nil {
+		return err
+	}
+
+	if filepath.IsAbs(vdev) || !strings.Contains(poolName, "/") {
+		// The whole zpool goes, clones included.
+		zpool := strings.Split(poolName, "/")[0]
+		plan.Actions = append(plan.Actions, fmt.Sprintf("Destroy the zpool \"%s\"", zpool))
+		for _, dataset := range zfsDestroyedDatasets(origins, zpool) {
+			plan.Actions = append(plan.Actions, fmt.Sprintf("Destroy the ZFS dataset \"%s\"", dataset))
+		}
+
+		if filepath.IsAbs(vdev) && !shared.IsBlockdevPath(vdev) {
+			plan.Actions = append(plan.Actions, fmt.Sprintf("Remove the loop file \"%s\"", vdev))
+		}
+	} else {
+		for _, dataset := range zfsDestroyedDatasets(origins, poolName) {
+			plan.Actions = append(plan.Actions, fmt.Sprintf("Destroy the ZFS dataset \"%s\"", dataset))
+		}
+
+		for _, clone := range zfsDependentClones(origins, poolName) {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("The ZFS dataset \"%s\" is a clone of \"%s\", it keeps the storage pool from being deleted", clone, origins[clone]))
+		}
+	}
+
+	poolMntPoint := getStoragePoolMountPoint(s.pool.Name)
+	if shared.PathExists(poolMntPoint) {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("Remove the mountpoint \"%s\"", poolMntPoint))
+	}
+
+	return nil
+}
+
 func (s *storageZfs) StoragePoolMount() (bool, error) {
 	return true, nil
 }
@@ -209,6 +263,11 @@ func (s *storageZfs) StoragePoolVolumeCreate() error {
 		return err
 	}
 
+	err = s.zfsPoolVolumeDefaultsApply(fs, s.volume.Config)
+	if err != nil {
+		return err
+	}
+
 	if !shared.IsMountPoint(customPoolVolumeMntPoint) {
 		s.zfsPoolVolumeMount(fs)
 	}
@@ -225,11 +284,51 @@ func (s *storageZfs) StoragePoolVolumeDelete() error {
 	fs := fmt.Sprintf("custom/%s", s.volume.Name)
 	customPoolVolumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
 
-	err := s.zfsPoolVolumeDestroy(fs)
+	props, err := s.zfsPoolVolumeGetAll(fs, "origin", "clones")
+	if err != nil {
+		return err
+	}
+
+	// The copies of the volume are clones of its snapshots, one of them
+	// takes those over so the volume can go.
+	for name, values := range props {
+		if !strings.HasPrefix(name, fmt.Sprintf("%s@", fs)) || values["clones"] == "-" || values["clones"] == "" {
+			continue
+		}
+
+		removable, err := s.zfsPoolVolumePromoteClones(fs)
+		if err != nil {
+			return err
+		}
+
+		if !removable {
+			return fmt.Errorf("the storage volume \"%s\" has copies which depend on it", s.volume.Name)
+		}
+
+		origin, err := s.zfsFilesystemEntityPropertyGet(fs, "origin", true)
+		if err != nil {
+			return err
+		}
+
+		props[fs]["origin"] = origin
+		break
+	}
+
+	err = s.zfsPoolVolumeDestroy(fs)
 	if err != nil {
 		return err
 	}
 
+	// Drop the snapshot the volume was copied from along with its last
+	// copy.
+	origin := strings.TrimPrefix(props[fs]["origin"], fmt.Sprintf("%s/", s.getOnDiskPoolName()))
+	if origin != "-" && origin != "" {
+		err := s.zfsPoolVolumeCleanup(origin)
+		if err != nil {
+			return err
+		}
+	}
+
 	if shared.PathExists(customPoolVolumeMntPoint) {
 		err := os.RemoveAll(customPoolVolumeMntPoint)
 		if err != nil {
@@ -241,43 +340,59 @@ func (s *storageZfs) StoragePoolVolumeDelete() error {
 	return nil
 }
 
-func (s *storageZfs) StoragePoolVolumeMount() (bool, error) {
-	logger.Debugf("Mounting ZFS storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
-
+func (s *storageZfs) StoragePoolVolumeDeletePlan(plan *api.OperationPlan) error {
 	fs := fmt.Sprintf("custom/%s", s.volume.Name)
-	customPoolVolumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
+	dataset := fmt.Sprintf("%s/%s", s.getOnDiskPoolName(), fs)
+
+	origins, err := s.zfsListOrigins()
+	if err != nil {
+		return err
+	}
 
-	customMountLockID := getCustomMountLockID(s.pool.Name, s.volume.Name)
-	lxdStorageMapLock.Lock()
-	if waitChannel, ok := lxdStorageOngoingOperationMap[customMountLockID]; ok {
-		lxdStorageMapLock.Unlock()
-		if _, ok := <-waitChannel; ok {
-			logger.Warnf("Received value over semaphore. This should not have happened.")
+	// The copies of the volume are clones of its snapshots, one of them
+	// takes those over so the volume can go, unless they're snapshots of
+	// its sub-volumes.
+	clones := zfsDependentClones(origins, dataset)
+	if len(clones) > 0 {
+		for _, clone := range clones {
+			if !strings.HasPrefix(origins[clone], dataset+"@") {
+				return fmt.Errorf("the storage volume \"%s\" has copies which depend on it", s.volume.Name)
+			}
 		}
-		// Give the benefit of the doubt and assume that the other
-		// thread actually succeeded in mounting the storage volume.
-		return false, nil
+
+		plan.Actions = append(plan.Actions, fmt.Sprintf("Promote a clone of the ZFS dataset \"%s\" to take over its snapshots", dataset))
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("The ZFS dataset \"%s\" has clones: %s", dataset, strings.Join(clones, ",")))
 	}
 
-	lxdStorageOngoingOperationMap[customMountLockID] = make(chan bool)
-	lxdStorageMapLock.Unlock()
+	for _, name := range zfsDestroyedDatasets(origins, dataset) {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("Destroy the ZFS dataset \"%s\"", name))
+	}
 
-	var customerr error
-	ourMount := false
-	if !shared.IsMountPoint(customPoolVolumeMntPoint) {
-		customerr = s.zfsPoolVolumeMount(fs)
-		ourMount = true
+	origin := origins[dataset]
+	if strings.HasPrefix(origin, fmt.Sprintf("%s/deleted/", s.getOnDiskPoolName())) {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("Destroy the ZFS snapshot \"%s\" it was copied from, if it has no other clones", origin))
 	}
 
-	lxdStorageMapLock.Lock()
-	if waitChannel, ok := lxdStorageOngoingOperationMap[customMountLockID]; ok {
-		close(waitChannel)
-		delete(lxdStorageOngoingOperationMap, customMountLockID)
+	volumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
+	if shared.PathExists(volumeMntPoint) {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("Remove the mountpoint \"%s\"", volumeMntPoint))
 	}
-	lxdStorageMapLock.Unlock()
 
-	if customerr != nil {
-		return false, customerr
+	return nil
+}
+
+func (s *storageZfs) StoragePoolVolumeMount() (bool, error) {
+	logger.Debugf("Mounting ZFS storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+
+	fs := fmt.Sprintf("custom/%s", s.volume.Name)
+
+	// The volume may be attached to several containers at once, every
+	// successful mount takes a reference which the caller has to release.
+	ourMount, err := storagePoolVolumeMountRef(s.pool.Name, s.volume.Name, func() error {
+		return s.zfsPoolVolumeMount(fs)
+	})
+	if err != nil {
+		return false, err
 	}
 
 	logger.Debugf("Mounted ZFS storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
@@ -290,41 +405,144 @@ func (s *storageZfs) StoragePoolVolumeUmount() (bool, error) {
 	fs := fmt.Sprintf("custom/%s", s.volume.Name)
 	customPoolVolumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
 
-	customUmountLockID := getCustomUmountLockID(s.pool.Name, s.volume.Name)
-	lxdStorageMapLock.Lock()
-	if waitChannel, ok := lxdStorageOngoingOperationMap[customUmountLockID]; ok {
-		lxdStorageMapLock.Unlock()
-		if _, ok := <-waitChannel; ok {
-			logger.Warnf("Received value over semaphore. This should not have happened.")
+	ourUmount, err := storagePoolVolumeUmountRef(s.pool.Name, s.volume.Name, func() error {
+		return s.zfsPoolVolumeUmount(fs, customPoolVolumeMntPoint)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	logger.Debugf("Unmounted ZFS storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	return ourUmount, nil
+}
+
+func (s *storageZfs) StoragePoolVolumeRename(newName string) error {
+	logger.Infof("Renaming ZFS storage volume \"%s\" on storage pool \"%s\" to \"%s\".", s.volume.Name, s.pool.Name, newName)
+
+	oldFs := fmt.Sprintf("custom/%s", s.volume.Name)
+	newFs := fmt.Sprintf("custom/%s", newName)
+	oldPoolVolumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
+	newPoolVolumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, newName)
+
+	// The snapshots of the volume are renamed along with the dataset.
+	err := s.zfsPoolVolumeRename(oldFs, newFs)
+	if err != nil {
+		return err
+	}
+
+	err = s.zfsPoolVolumeSet(newFs, "mountpoint", newPoolVolumeMntPoint)
+	if err != nil {
+		s.zfsPoolVolumeRename(newFs, oldFs)
+		return err
+	}
+
+	if shared.PathExists(oldPoolVolumeMntPoint) {
+		err := os.Remove(oldPoolVolumeMntPoint)
+		if err != nil {
+			return err
 		}
-		// Give the benefit of the doubt and assume that the other
-		// thread actually succeeded in unmounting the storage volume.
-		return false, nil
 	}
 
-	lxdStorageOngoingOperationMap[customUmountLockID] = make(chan bool)
-	lxdStorageMapLock.Unlock()
+	logger.Infof("Renamed ZFS storage volume \"%s\" on storage pool \"%s\" to \"%s\".", s.volume.Name, s.pool.Name, newName)
+	return nil
+}
+
+func (s *storageZfs) StoragePoolVolumeGetState() (*api.StorageVolumeState, error) {
+	fs := fmt.Sprintf("custom/%s", s.volume.Name)
 
-	var customerr error
-	ourUmount := false
-	if shared.IsMountPoint(customPoolVolumeMntPoint) {
-		customerr = s.zfsPoolVolumeUmount(fs, customPoolVolumeMntPoint)
-		ourUmount = true
+	values := map[string]int64{}
+	for _, property := range []string{"used", "usedbysnapshots", "quota", "refquota"} {
+		value, err := s.zfsFilesystemEntityPropertyGet(fs, property, true)
+		if err != nil {
+			return nil, err
+		}
+
+		values[property], err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Report whichever quota is set, unset ones being 0.
+	quota := values["quota"]
+	if values["refquota"] > 0 && (quota == 0 || values["refquota"] < quota) {
+		quota = values["refquota"]
 	}
 
-	lxdStorageMapLock.Lock()
-	if waitChannel, ok := lxdStorageOngoingOperationMap[customUmountLockID]; ok {
-		close(waitChannel)
-		delete(lxdStorageOngoingOperationMap, customUmountLockID)
+	return &api.StorageVolumeState{
+		Usage:          values["used"],
+		SnapshotsUsage: values["usedbysnapshots"],
+		Quota:          quota,
+	}, nil
+}
+
+func (s *storageZfs) StoragePoolVolumeCopy(sourceName string, flatten bool) error {
+	logger.Infof("Copying ZFS storage volume \"%s\" to \"%s\" on storage pool \"%s\".", sourceName, s.volume.Name, s.pool.Name)
+	defer s.zfsDatasetCacheInvalidate()
+
+	sourceFs := fmt.Sprintf("custom/%s", sourceName)
+	fs := fmt.Sprintf("custom/%s", s.volume.Name)
+	poolName := s.getOnDiskPoolName()
+	customPoolVolumeMntPoint := getStoragePoolVolumeMountPoint(s.pool.Name, s.volume.Name)
+
+	snapName := fmt.Sprintf("copy-%s", uuid.NewRandom().String())
+	err := s.zfsPoolVolumeSnapshotCreate(sourceFs, snapName)
+	if err != nil {
+		return err
 	}
-	lxdStorageMapLock.Unlock()
 
-	if customerr != nil {
-		return false, customerr
+	if flatten {
+		// The snapshot is only needed for the duration of the copy.
+		defer s.zfsPoolVolumeSnapshotDestroy(sourceFs, snapName)
+
+		err = zfsSendReceive([]string{fmt.Sprintf("%s/%s@%s", poolName, sourceFs, snapName)}, []string{"-u", fmt.Sprintf("%s/%s", poolName, fs)})
+		if err == nil {
+			err = s.zfsPoolVolumeSnapshotDestroy(fs, snapName)
+		}
+		if err == nil {
+			err = s.zfsPoolVolumeSet(fs, "canmount", "noauto")
+		}
+		if err == nil {
+			err = s.zfsPoolVolumeSet(fs, "mountpoint", customPoolVolumeMntPoint)
+		}
+	} else {
+		// The clone keeps the snapshot until it's deleted, see
+		// zfsPoolVolumeCleanup.
+		err = s.zfsPoolVolumeClone(sourceFs, snapName, fs, customPoolVolumeMntPoint)
+		if err != nil {
+			s.zfsPoolVolumeSnapshotDestroy(sourceFs, snapName)
+			return err
+		}
 	}
 
-	logger.Debugf("Unmounted ZFS storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
-	return ourUmount, nil
+	revert := true
+	defer func() {
+		if !revert {
+			return
+		}
+
+		s.StoragePoolVolumeDelete()
+	}()
+
+	if err != nil {
+		return err
+	}
+
+	// Neither clones nor received datasets keep the local properties of
+	// the source.
+	err = s.zfsPoolVolumeDefaultsApply(fs, s.volume.Config)
+	if err != nil {
+		return err
+	}
+
+	if !shared.IsMountPoint(customPoolVolumeMntPoint) {
+		s.zfsPoolVolumeMount(fs)
+	}
+
+	revert = false
+
+	logger.Infof("Copied ZFS storage volume \"%s\" to \"%s\" on storage pool \"%s\".", sourceName, s.volume.Name, s.pool.Name)
+	return nil
 }
 
 func (s *storageZfs) GetStoragePoolWritable() api.StoragePoolPut {
@@ -382,117 +600,480 @@ func (s *storageZfs) StoragePoolUpdate(writable *api.StoragePoolPut, changedConf
 		return fmt.Errorf("the \"zfs.pool_name\" property cannot be changed")
 	}
 
-	// "rsync.bwlimit" requires no on-disk modifications.
-
-	logger.Infof("Updated ZFS storage pool \"%s\".", s.pool.Name)
-	return nil
-}
-
-func (s *storageZfs) StoragePoolVolumeUpdate(changedConfig []string) error {
-	logger.Infof("Updating ZFS storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	if shared.StringInSlice("zfs.pool_guid", changedConfig) {
+		return fmt.Errorf("the \"zfs.pool_guid\" property cannot be changed")
+	}
 
-	if shared.StringInSlice("block.mount_options", changedConfig) {
-		return fmt.Errorf("the \"block.mount_options\" property cannot be changed")
+	// Move the quotas of the containers which follow the pool default.
+	if shared.StringInSlice("volume.zfs.use_refquota", changedConfig) {
+		err := s.zfsPoolQuotaConvert(shared.IsTrue(writable.Config["volume.zfs.use_refquota"]))
+		if err != nil {
+			return err
+		}
 	}
 
-	if shared.StringInSlice("block.filesystem", changedConfig) {
-		return fmt.Errorf("the \"block.filesystem\" property cannot be changed")
+	if shared.StringInSlice("zfs.images.quota", changedConfig) {
+		err := s.zfsImagesQuotaApply(writable.Config["zfs.images.quota"])
+		if err != nil {
+			return err
+		}
 	}
 
-	if shared.StringInSlice("size", changedConfig) {
-		return fmt.Errorf("the \"size\" property cannot be changed")
+	// The "rsync.*" keys require no on-disk modifications.
+
+	// "zfs.dataset_cache" requires no on-disk modifications but drops any
+	// cached dataset listing.
+	if shared.StringInSlice("zfs.dataset_cache", changedConfig) {
+		s.zfsDatasetCacheInvalidate()
 	}
 
-	logger.Infof("Updated ZFS storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	logger.Infof("Updated ZFS storage pool \"%s\".", s.pool.Name)
 	return nil
 }
 
-// Things we don't need to care about
-func (s *storageZfs) ContainerMount(c container) (bool, error) {
-	name := c.Name()
-	logger.Debugf("Mounting ZFS storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
-
-	fs := fmt.Sprintf("containers/%s", name)
-	containerPoolVolumeMntPoint := getContainerMountPoint(s.pool.Name, name)
+// StoragePoolVerify checks that the datasets of the containers, of their
+// snapshots, of the custom volumes and of the images exist, and that the
+// mountpoints and quotas of the containers match their configuration.
+func (s *storageZfs) StoragePoolVerify(report *storageVerifyReport, containers []container) error {
+	poolName := s.getOnDiskPoolName()
 
-	containerMountLockID := getContainerMountLockID(s.pool.Name, name)
-	lxdStorageMapLock.Lock()
-	if waitChannel, ok := lxdStorageOngoingOperationMap[containerMountLockID]; ok {
-		lxdStorageMapLock.Unlock()
-		if _, ok := <-waitChannel; ok {
-			logger.Warnf("Received value over semaphore. This should not have happened.")
-		}
-		// Give the benefit of the doubt and assume that the other
-		// thread actually succeeded in mounting the storage volume.
-		return false, nil
+	datasets, err := s.zfsPoolVolumeGetAll("containers", "mountpoint", "quota", "refquota")
+	if err != nil {
+		return err
 	}
 
-	lxdStorageOngoingOperationMap[containerMountLockID] = make(chan bool)
-	lxdStorageMapLock.Unlock()
+	known := []string{}
+	for _, c := range containers {
+		fs := fmt.Sprintf("containers/%s", c.Name())
+		known = append(known, fs)
 
-	removeLockFromMap := func() {
-		lxdStorageMapLock.Lock()
-		if waitChannel, ok := lxdStorageOngoingOperationMap[containerMountLockID]; ok {
-			close(waitChannel)
-			delete(lxdStorageOngoingOperationMap, containerMountLockID)
-		}
-		lxdStorageMapLock.Unlock()
-	}
+		props, ok := datasets[fs]
+		if !ok {
+			div := api.StoragePoolDivergence{
+				Type:     "missing_dataset",
+				Entity:   "container",
+				Name:     c.Name(),
+				Expected: fmt.Sprintf("%s/%s", poolName, fs),
+			}
 
-	defer removeLockFromMap()
+			report.add(div, nil)
+			continue
+		}
 
-	// Since we're using mount() directly zfs will not automatically create
-	// the mountpoint for us. So let's check and do it if needed.
-	if !shared.PathExists(containerPoolVolumeMntPoint) {
-		err := createContainerMountpoint(containerPoolVolumeMntPoint, c.Path(), c.IsPrivileged())
+		err := s.zfsContainerVerify(report, c, props, datasets)
 		if err != nil {
-			return false, err
+			return err
 		}
 	}
 
-	ourMount := false
-	if !shared.IsMountPoint(containerPoolVolumeMntPoint) {
-		source := fmt.Sprintf("%s/%s", s.getOnDiskPoolName(), fs)
-		zfsMountOptions := fmt.Sprintf("rw,zfsutil,mntpoint=%s", containerPoolVolumeMntPoint)
-		mounterr := tryMount(source, containerPoolVolumeMntPoint, "zfs", 0, zfsMountOptions)
-		if mounterr != nil {
-			if mounterr != syscall.EBUSY {
-				logger.Errorf("Failed to mount ZFS dataset \"%s\" onto \"%s\".", source, containerPoolVolumeMntPoint)
-				return false, mounterr
+	// Look for container datasets without a database record.
+	found := []string{}
+	for name := range datasets {
+		if strings.HasPrefix(name, "containers/") && !strings.ContainsAny(strings.TrimPrefix(name, "containers/"), "/@") {
+			// The datasets left by restores belong to their container.
+			owner := strings.SplitN(name, ".restored-", 2)[0]
+			if owner != name && shared.StringInSlice(owner, known) {
+				continue
 			}
-			// EBUSY error in zfs are related to a bug we're
-			// tracking. So ignore them for now, report back that
-			// the mount isn't ours and proceed.
-			logger.Warnf("ZFS returned EBUSY while \"%s\" is actually not a mountpoint.", containerPoolVolumeMntPoint)
-			return false, mounterr
+
+			found = append(found, name)
 		}
-		ourMount = true
 	}
 
-	logger.Debugf("Mounted ZFS storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
-	return ourMount, nil
-}
+	_, unknown := storageVerifyListDiff(known, found)
+	for _, fs := range unknown {
+		div := api.StoragePoolDivergence{
+			Type:   "unknown_dataset",
+			Entity: "container",
+			Name:   strings.TrimPrefix(fs, "containers/"),
+			Found:  fmt.Sprintf("%s/%s", poolName, fs),
+		}
 
-func (s *storageZfs) ContainerUmount(name string, path string) (bool, error) {
-	logger.Debugf("Unmounting ZFS storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+		report.add(div, nil)
+	}
 
-	fs := fmt.Sprintf("containers/%s", name)
-	containerPoolVolumeMntPoint := getContainerMountPoint(s.pool.Name, name)
+	volumes, err := dbStoragePoolVolumesGetType(s.d.db, storagePoolVolumeTypeCustom, s.poolID)
+	if err != nil {
+		return err
+	}
 
-	containerUmountLockID := getContainerUmountLockID(s.pool.Name, name)
-	lxdStorageMapLock.Lock()
-	if waitChannel, ok := lxdStorageOngoingOperationMap[containerUmountLockID]; ok {
-		lxdStorageMapLock.Unlock()
-		if _, ok := <-waitChannel; ok {
-			logger.Warnf("Received value over semaphore. This should not have happened.")
+	for _, name := range volumes {
+		if shared.IsSnapshot(name) {
+			continue
 		}
-		// Give the benefit of the doubt and assume that the other
-		// thread actually succeeded in unmounting the storage volume.
-		return false, nil
+
+		s.zfsDatasetVerify(report, "custom", name, fmt.Sprintf("custom/%s", name))
 	}
 
-	lxdStorageOngoingOperationMap[containerUmountLockID] = make(chan bool)
-	lxdStorageMapLock.Unlock()
+	images, err := dbStoragePoolVolumesGetType(s.d.db, storagePoolVolumeTypeImage, s.poolID)
+	if err != nil {
+		return err
+	}
+
+	for _, fingerprint := range images {
+		s.zfsDatasetVerify(report, "image", fingerprint, fmt.Sprintf("images/%s", fingerprint))
+	}
+
+	return nil
+}
+
+func (s *storageZfs) zfsDatasetVerify(report *storageVerifyReport, entity string, name string, fs string) {
+	if s.zfsFilesystemEntityExists(fs, true) {
+		return
+	}
+
+	div := api.StoragePoolDivergence{
+		Type:     "missing_dataset",
+		Entity:   entity,
+		Name:     name,
+		Expected: fmt.Sprintf("%s/%s", s.getOnDiskPoolName(), fs),
+	}
+
+	report.add(div, nil)
+}
+
+// zfsContainerVerify checks the mountpoint, the quota and the snapshots of the
+// dataset of a container given the properties of the "containers" datasets.
+func (s *storageZfs) zfsContainerVerify(report *storageVerifyReport, c container, props map[string]string, datasets map[string]map[string]string) error {
+	name := c.Name()
+	fs := fmt.Sprintf("containers/%s", name)
+
+	mountPoint := getContainerMountPoint(s.pool.Name, name)
+	if props["mountpoint"] != mountPoint {
+		// Changing the mountpoint remounts the dataset, which fails
+		// while the container is using it.
+		var fixFunc func() error
+		if !c.IsRunning() {
+			fixFunc = func() error {
+				return s.zfsPoolVolumeSet(fs, "mountpoint", mountPoint)
+			}
+		}
+
+		div := api.StoragePoolDivergence{
+			Type:     "wrong_mountpoint",
+			Entity:   "container",
+			Name:     name,
+			Expected: mountPoint,
+			Found:    props["mountpoint"],
+		}
+
+		report.add(div, fixFunc)
+	}
+
+	_, rootDiskDevice, err := containerGetRootDiskDevice(c.ExpandedDevices())
+	if err == nil {
+		size := int64(0)
+		if rootDiskDevice["size"] != "" {
+			size, err = shared.ParseByteSizeString(rootDiskDevice["size"])
+			if err != nil {
+				return err
+			}
+		}
+
+		st, err := storageInit(s.d, s.pool.Name, name, storagePoolVolumeTypeContainer)
+		if err != nil {
+			return err
+		}
+
+		property := "quota"
+		otherProperty := "refquota"
+		if s.zfsUseRefquota(st.GetStoragePoolVolumeWritable().Config) {
+			property, otherProperty = otherProperty, property
+		}
+
+		// Unset quotas are reported as 0.
+		if props[property] != fmt.Sprintf("%d", size) || props[otherProperty] != "0" {
+			div := api.StoragePoolDivergence{
+				Type:     "wrong_quota",
+				Entity:   "container",
+				Name:     name,
+				Expected: fmt.Sprintf("%s=%d %s=0", property, size, otherProperty),
+				Found:    fmt.Sprintf("%s=%s %s=%s", property, props[property], otherProperty, props[otherProperty]),
+			}
+
+			report.add(div, func() error {
+				return st.ContainerSetQuota(c, size)
+			})
+		}
+	}
+
+	snapshots, err := c.Snapshots()
+	if err != nil {
+		return err
+	}
+
+	expected := []string{}
+	for _, snap := range snapshots {
+		_, snapOnlyName, _ := containerGetParentAndSnapshotName(snap.Name())
+		expected = append(expected, fmt.Sprintf("snapshot-%s", snapOnlyName))
+	}
+
+	found := []string{}
+	for dataset := range datasets {
+		if !strings.Contains(dataset, "@snapshot-") {
+			continue
+		}
+
+		// Snapshots may be held by the datasets left by restores.
+		fields := strings.SplitN(dataset, "@", 2)
+		restored := strings.TrimPrefix(fields[0], zfsRestoredDatasetPrefix(name))
+		if fields[0] == fs || restored != fields[0] && !strings.Contains(restored, "/") {
+			found = append(found, fields[1])
+		}
+	}
+
+	missing, unknown := storageVerifyListDiff(expected, found)
+	for _, snapshot := range missing {
+		div := api.StoragePoolDivergence{
+			Type:     "missing_dataset",
+			Entity:   "snapshot",
+			Name:     name + shared.SnapshotDelimiter + strings.TrimPrefix(snapshot, "snapshot-"),
+			Expected: fmt.Sprintf("%s/%s@%s", s.getOnDiskPoolName(), fs, snapshot),
+		}
+
+		report.add(div, nil)
+	}
+
+	for _, snapshot := range unknown {
+		div := api.StoragePoolDivergence{
+			Type:   "unknown_dataset",
+			Entity: "snapshot",
+			Name:   name + shared.SnapshotDelimiter + strings.TrimPrefix(snapshot, "snapshot-"),
+			Found:  fmt.Sprintf("%s/%s@%s", s.getOnDiskPoolName(), fs, snapshot),
+		}
+
+		report.add(div, nil)
+	}
+
+	return nil
+}
+
+func (s *storageZfs) StoragePoolVolumeUpdate(writable *api.StorageVolumePut, changedConfig []string) error {
+	logger.Infof("Updating ZFS storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+
+	if shared.StringInSlice("block.mount_options", changedConfig) {
+		return fmt.Errorf("the \"block.mount_options\" property cannot be changed")
+	}
+
+	if shared.StringInSlice("block.filesystem", changedConfig) {
+		return fmt.Errorf("the \"block.filesystem\" property cannot be changed")
+	}
+
+	if shared.StringInSlice("size", changedConfig) {
+		return fmt.Errorf("the \"size\" property cannot be changed")
+	}
+
+	if shared.StringInSlice("zfs.use_refquota", changedConfig) && s.volume.Type == storagePoolVolumeTypeNameContainer {
+		fs := fmt.Sprintf("containers/%s", s.volume.Name)
+		err := s.zfsPoolVolumeQuotaConvert(fs, s.zfsUseRefquota(writable.Config))
+		if err != nil {
+			return err
+		}
+	}
+
+	reservationChanged := shared.StringInSlice("zfs.use_reserve", changedConfig) || shared.StringInSlice("zfs.reservation", changedConfig)
+	if reservationChanged && s.volume.Type == storagePoolVolumeTypeNameContainer {
+		fs := fmt.Sprintf("containers/%s", s.volume.Name)
+
+		// Reserve the current quota of the container.
+		property := "quota"
+		if s.zfsUseRefquota(writable.Config) {
+			property = "refquota"
+		}
+
+		value, err := s.zfsFilesystemEntityPropertyGet(fs, property, true)
+		if err != nil {
+			return err
+		}
+
+		size, _ := strconv.ParseInt(value, 10, 64)
+		err = s.zfsPoolVolumeReservationApply(fs, writable.Config, size)
+		if err != nil {
+			return err
+		}
+	}
+
+	tuningChanged := false
+	for _, property := range zfsPoolVolumeTuningKeys {
+		if shared.StringInSlice(fmt.Sprintf("zfs.%s", property), changedConfig) {
+			tuningChanged = true
+			break
+		}
+	}
+
+	if tuningChanged {
+		var fs string
+		switch s.volume.Type {
+		case storagePoolVolumeTypeNameContainer:
+			fs = fmt.Sprintf("containers/%s", s.volume.Name)
+		case storagePoolVolumeTypeNameImage:
+			fs = fmt.Sprintf("images/%s", s.volume.Name)
+		case storagePoolVolumeTypeNameCustom:
+			fs = fmt.Sprintf("custom/%s", s.volume.Name)
+		default:
+			return fmt.Errorf("ZFS properties can't be set on storage volumes of type \"%s\"", s.volume.Type)
+		}
+
+		err := s.zfsPoolVolumeTuningApply(fs, writable.Config, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Infof("Updated ZFS storage volume \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	return nil
+}
+
+func (s *storageZfs) StoragePoolVolumeSnapshotCreate(snapshotName string) error {
+	logger.Infof("Creating ZFS storage volume snapshot \"%s/%s\" on storage pool \"%s\".", s.volume.Name, snapshotName, s.pool.Name)
+
+	fs := fmt.Sprintf("custom/%s", s.volume.Name)
+	err := s.zfsPoolVolumeSnapshotCreate(fs, fmt.Sprintf("snapshot-%s", snapshotName))
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Created ZFS storage volume snapshot \"%s/%s\" on storage pool \"%s\".", s.volume.Name, snapshotName, s.pool.Name)
+	return nil
+}
+
+func (s *storageZfs) StoragePoolVolumeSnapshotDelete(snapshotName string) error {
+	logger.Infof("Deleting ZFS storage volume snapshot \"%s/%s\" on storage pool \"%s\".", s.volume.Name, snapshotName, s.pool.Name)
+
+	fs := fmt.Sprintf("custom/%s", s.volume.Name)
+	snapName := fmt.Sprintf("snapshot-%s", snapshotName)
+	if s.zfsFilesystemEntityExists(fmt.Sprintf("%s@%s", fs, snapName), true) {
+		err := s.zfsPoolVolumeSnapshotDestroy(fs, snapName)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Infof("Deleted ZFS storage volume snapshot \"%s/%s\" on storage pool \"%s\".", s.volume.Name, snapshotName, s.pool.Name)
+	return nil
+}
+
+func (s *storageZfs) StoragePoolVolumeSnapshotRename(snapshotName string, newName string) error {
+	logger.Infof("Renaming ZFS storage volume snapshot \"%s/%s\" to \"%s\" on storage pool \"%s\".", s.volume.Name, snapshotName, newName, s.pool.Name)
+
+	fs := fmt.Sprintf("custom/%s", s.volume.Name)
+	err := s.zfsPoolVolumeSnapshotRename(fs, fmt.Sprintf("snapshot-%s", snapshotName), fmt.Sprintf("snapshot-%s", newName))
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Renamed ZFS storage volume snapshot \"%s/%s\" to \"%s\" on storage pool \"%s\".", s.volume.Name, snapshotName, newName, s.pool.Name)
+	return nil
+}
+
+// storagePoolVolumeSnapshotRestoreRemovesNewer tells whether restoring the
+// volume from a snapshot removes the newer ones.
+func (s *storageZfs) storagePoolVolumeSnapshotRestoreRemovesNewer() bool {
+	return shared.IsTrue(s.zfsVolumeConfigGet(s.volume.Config, "remove_snapshots"))
+}
+
+func (s *storageZfs) StoragePoolVolumeSnapshotRestore(snapshotName string) error {
+	logger.Infof("Restoring ZFS storage volume \"%s\" from snapshot \"%s\" on storage pool \"%s\".", s.volume.Name, snapshotName, s.pool.Name)
+
+	snapshots, err := dbStoragePoolVolumeSnapshotsGet(s.d.db, s.volume.Name, s.poolID)
+	if err != nil {
+		return err
+	}
+
+	fullName := fmt.Sprintf("%s%s%s", s.volume.Name, shared.SnapshotDelimiter, snapshotName)
+	newer := []string{}
+	for i, snapshot := range snapshots {
+		if snapshot == fullName {
+			newer = snapshots[i+1:]
+			break
+		}
+	}
+
+	// ZFS can only roll back to the most recent snapshot.
+	if len(newer) > 0 {
+		if !s.storagePoolVolumeSnapshotRestoreRemovesNewer() {
+			return fmt.Errorf("ZFS can only restore from the latest snapshot. Delete newer snapshots or set \"zfs.remove_snapshots\" on the storage volume")
+		}
+
+		for i := len(newer) - 1; i >= 0; i-- {
+			_, newerName, _ := containerGetParentAndSnapshotName(newer[i])
+			err := s.StoragePoolVolumeSnapshotDelete(newerName)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	fs := fmt.Sprintf("custom/%s", s.volume.Name)
+	err = s.zfsPoolVolumeSnapshotRestore(fs, fmt.Sprintf("snapshot-%s", snapshotName))
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Restored ZFS storage volume \"%s\" from snapshot \"%s\" on storage pool \"%s\".", s.volume.Name, snapshotName, s.pool.Name)
+	return nil
+}
+
+// Things we don't need to care about
+func (s *storageZfs) ContainerMount(c container) (bool, error) {
+	name := c.Name()
+	logger.Debugf("Mounting ZFS storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+
+	fs := fmt.Sprintf("containers/%s", name)
+	containerPoolVolumeMntPoint := getContainerMountPoint(s.pool.Name, name)
+
+	unlock, joined := storageLocks.join(storageContainerLockKey(s.pool.Name, name), storageOpMount)
+	if joined {
+		// Give the benefit of the doubt and assume that the other
+		// thread actually succeeded in mounting the storage volume.
+		return false, nil
+	}
+	defer unlock()
+
+	// Since we're using mount() directly zfs will not automatically create
+	// the mountpoint for us. So let's check and do it if needed.
+	if !shared.PathExists(containerPoolVolumeMntPoint) {
+		err := createContainerMountpoint(containerPoolVolumeMntPoint, c.Path(), c.IsPrivileged())
+		if err != nil {
+			return false, err
+		}
+	}
+
+	ourMount := false
+	if !shared.IsMountPoint(containerPoolVolumeMntPoint) {
+		source := fmt.Sprintf("%s/%s", s.getOnDiskPoolName(), fs)
+		zfsMountOptions := fmt.Sprintf("rw,zfsutil,mntpoint=%s", containerPoolVolumeMntPoint)
+		mounterr := tryMount(source, containerPoolVolumeMntPoint, "zfs", 0, zfsMountOptions)
+		if mounterr != nil {
+			if mounterr != syscall.EBUSY {
+				logger.Errorf("Failed to mount ZFS dataset \"%s\" onto \"%s\".", source, containerPoolVolumeMntPoint)
+				return false, mounterr
+			}
+			// EBUSY error in zfs are related to a bug we're
+			// tracking. So ignore them for now, report back that
+			// the mount isn't ours and proceed.
+			logger.Warnf("ZFS returned EBUSY while \"%s\" is actually not a mountpoint.", containerPoolVolumeMntPoint)
+			return false, mounterr
+		}
+		ourMount = true
+	}
+
+	logger.Debugf("Mounted ZFS storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	return ourMount, nil
+}
+
+func (s *storageZfs) ContainerUmount(name string, path string) (bool, error) {
+	logger.Debugf("Unmounting ZFS storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+
+	fs := fmt.Sprintf("containers/%s", name)
+	containerPoolVolumeMntPoint := getContainerMountPoint(s.pool.Name, name)
+
+	unlock, joined := storageLocks.join(storageContainerLockKey(s.pool.Name, name), storageOpUmount)
+	if joined {
+		// Give the benefit of the doubt and assume that the other
+		// thread actually succeeded in unmounting the storage volume.
+		return false, nil
+	}
 
 	var imgerr error
 	ourUmount := false
@@ -501,12 +1082,7 @@ func (s *storageZfs) ContainerUmount(name string, path string) (bool, error) {
 		ourUmount = true
 	}
 
-	lxdStorageMapLock.Lock()
-	if waitChannel, ok := lxdStorageOngoingOperationMap[containerUmountLockID]; ok {
-		close(waitChannel)
-		delete(lxdStorageOngoingOperationMap, containerUmountLockID)
-	}
-	lxdStorageMapLock.Unlock()
+	unlock()
 
 	if imgerr != nil {
 		return false, imgerr
@@ -554,6 +1130,11 @@ func (s *storageZfs) ContainerCreate(container container) error {
 		return err
 	}
 
+	err = s.zfsPoolVolumeDefaultsApply(fs, s.volume.Config)
+	if err != nil {
+		return err
+	}
+
 	ourMount, err := s.ContainerMount(container)
 	if err != nil {
 		return err
@@ -588,28 +1169,14 @@ func (s *storageZfs) ContainerCreateFromImage(container container, fingerprint s
 
 	fsImage := fmt.Sprintf("images/%s", fingerprint)
 
-	imageStoragePoolLockID := getImageCreateLockID(s.pool.Name, fingerprint)
-	lxdStorageMapLock.Lock()
-	if waitChannel, ok := lxdStorageOngoingOperationMap[imageStoragePoolLockID]; ok {
-		lxdStorageMapLock.Unlock()
-		if _, ok := <-waitChannel; ok {
-			logger.Warnf("Received value over semaphore. This should not have happened.")
-		}
-	} else {
-		lxdStorageOngoingOperationMap[imageStoragePoolLockID] = make(chan bool)
-		lxdStorageMapLock.Unlock()
-
+	unlock, joined := storageLocks.join(storageImageLockKey(s.pool.Name, fingerprint), storageOpCreate)
+	if !joined {
 		var imgerr error
 		if !s.zfsFilesystemEntityExists(fsImage, true) {
 			imgerr = s.ImageCreate(fingerprint)
 		}
 
-		lxdStorageMapLock.Lock()
-		if waitChannel, ok := lxdStorageOngoingOperationMap[imageStoragePoolLockID]; ok {
-			close(waitChannel)
-			delete(lxdStorageOngoingOperationMap, imageStoragePoolLockID)
-		}
-		lxdStorageMapLock.Unlock()
+		unlock()
 
 		if imgerr != nil {
 			return imgerr
@@ -629,6 +1196,11 @@ func (s *storageZfs) ContainerCreateFromImage(container container, fingerprint s
 		s.ContainerDelete(container)
 	}()
 
+	err = s.zfsPoolVolumeDefaultsApply(fs, s.volume.Config)
+	if err != nil {
+		return err
+	}
+
 	ourMount, err := s.ContainerMount(container)
 	if err != nil {
 		return err
@@ -661,29 +1233,176 @@ func (s *storageZfs) ContainerCreateFromImage(container container, fingerprint s
 	return nil
 }
 
-func (s *storageZfs) ContainerCanRestore(container container, sourceContainer container) error {
-	snaps, err := container.Snapshots()
+func (s *storageZfs) ContainerRebuild(container container, fingerprint string) error {
+	defer s.zfsDatasetCacheInvalidate()
+
+	logger.Debugf("Rebuilding ZFS storage volume for container \"%s\" on storage pool \"%s\" from image %s.", s.volume.Name, s.pool.Name, fingerprint)
+
+	containerPath := container.Path()
+	containerName := container.Name()
+	fs := fmt.Sprintf("containers/%s", containerName)
+	containerPoolVolumeMntPoint := getContainerMountPoint(s.pool.Name, containerName)
+	poolName := s.getOnDiskPoolName()
+
+	fsImage := fmt.Sprintf("images/%s", fingerprint)
+
+	unlock, joined := storageLocks.join(storageImageLockKey(s.pool.Name, fingerprint), storageOpCreate)
+	if !joined {
+		var imgerr error
+		if !s.zfsFilesystemEntityExists(fsImage, true) {
+			imgerr = s.ImageCreate(fingerprint)
+		}
+
+		unlock()
+
+		if imgerr != nil {
+			return imgerr
+		}
+	}
+
+	// Keep the properties set on the container's dataset, like its
+	// quota, for the new one.
+	properties, err := zfsDatasetLocalProperties(fmt.Sprintf("%s/%s", poolName, fs))
+	if err != nil {
+		return err
+	}
+
+	_, err = s.ContainerUmount(containerName, containerPath)
+	if err != nil {
+		return err
+	}
+
+	// Move the current dataset out of the way until the new one is in
+	// place. Dots aren't allowed in container names so this can't clash
+	// with another container.
+	oldFs := fmt.Sprintf("%s.rebuild-%s", fs, uuid.NewRandom().String()[0:8])
+	err = s.zfsPoolVolumeSet(fs, "mountpoint", "none")
+	if err != nil {
+		return err
+	}
+
+	err = s.zfsPoolVolumeRename(fs, oldFs)
 	if err != nil {
+		s.zfsPoolVolumeSet(fs, "mountpoint", containerPoolVolumeMntPoint)
 		return err
 	}
 
-	if snaps[len(snaps)-1].Name() != sourceContainer.Name() {
-		if s.pool.Config["volume.zfs.remove_snapshots"] != "" {
-			zfsRemoveSnapshots = s.pool.Config["volume.zfs.remove_snapshots"]
+	revert := true
+	defer func() {
+		if !revert {
+			return
 		}
-		if s.volume.Config["zfs.remove_snapshots"] != "" {
-			zfsRemoveSnapshots = s.volume.Config["zfs.remove_snapshots"]
+
+		s.ContainerUmount(containerName, containerPath)
+		if s.zfsFilesystemEntityExists(fs, true) {
+			s.zfsPoolVolumeDestroy(fs)
 		}
-		if !shared.IsTrue(zfsRemoveSnapshots) {
-			return fmt.Errorf("ZFS can only restore from the latest snapshot. Delete newer snapshots or copy the snapshot into a new container instead")
+
+		s.zfsPoolVolumeRename(oldFs, fs)
+		s.zfsPoolVolumeSet(fs, "mountpoint", containerPoolVolumeMntPoint)
+	}()
+
+	err = s.zfsPoolVolumeClone(fsImage, "readonly", fs, containerPoolVolumeMntPoint)
+	if err != nil {
+		return err
+	}
+
+	err = s.zfsPoolVolumeDefaultsApply(fs, s.volume.Config)
+	if err != nil {
+		return err
+	}
+
+	s.zfsPoolVolumePropertiesApply(fs, properties)
+
+	ourMount, err := s.ContainerMount(container)
+	if err != nil {
+		return err
+	}
+	if ourMount {
+		defer s.ContainerUmount(containerName, containerPath)
+	}
+
+	privileged := container.IsPrivileged()
+	err = createContainerMountpoint(containerPoolVolumeMntPoint, containerPath, privileged)
+	if err != nil {
+		return err
+	}
+
+	if !privileged {
+		err = s.shiftRootfs(container)
+		if err != nil {
+			return err
 		}
+	}
 
+	err = container.TemplateApply("create")
+	if err != nil {
+		return err
+	}
+
+	revert = false
+
+	// The old dataset is kept around as long as copies of the container
+	// were cloned from it.
+	props, err := s.zfsPoolVolumeGetAll(oldFs, "origin", "clones")
+	if err != nil {
+		logger.Warnf("Failed to get the properties of \"%s\": %s.", oldFs, err)
 		return nil
 	}
 
+	removable := true
+	for name, values := range props {
+		if !strings.HasPrefix(name, fmt.Sprintf("%s@", oldFs)) {
+			continue
+		}
+
+		clones := values["clones"]
+		if clones != "-" && clones != "" {
+			removable = false
+			break
+		}
+	}
+
+	if removable {
+		origin := strings.TrimPrefix(props[oldFs]["origin"], fmt.Sprintf("%s/", poolName))
+		err = s.zfsPoolVolumeDestroy(oldFs)
+		if err == nil {
+			err = s.zfsPoolVolumeCleanup(origin)
+		}
+	} else {
+		err = s.zfsPoolVolumeRename(oldFs, fmt.Sprintf("deleted/containers/%s", uuid.NewRandom().String()))
+	}
+	if err != nil {
+		logger.Warnf("Failed to remove the previous dataset \"%s\" of container \"%s\": %s.", oldFs, containerName, err)
+	}
+
+	// What's left from restores of the container's former snapshots.
+	err = s.zfsContainerRestoredCleanup(containerName, true)
+	if err != nil {
+		logger.Warnf("Failed to clean up the restored datasets of container \"%s\": %s.", containerName, err)
+	}
+
+	logger.Debugf("Rebuilt ZFS storage volume for container \"%s\" on storage pool \"%s\" from image %s.", s.volume.Name, s.pool.Name, fingerprint)
+	return nil
+}
+
+func (s *storageZfs) ContainerCanRestore(container container, sourceContainer container) error {
+	// Snapshots older than the latest are restored by swapping in a clone
+	// of them, unless the newer snapshots are to be removed.
 	return nil
 }
 
+// zfsRemoveNewerSnapshots returns whether restoring a container to an older
+// snapshot deletes the newer ones rather than keeping them.
+func (s *storageZfs) zfsRemoveNewerSnapshots() bool {
+	removeSnapshots := s.zfsVolumeConfigGet(s.volume.Config, "remove_snapshots")
+	if removeSnapshots == "" {
+		removeSnapshots = zfsRemoveSnapshots
+	}
+
+	return shared.IsTrue(removeSnapshots)
+}
+
 func (s *storageZfs) ContainerDelete(container container) error {
 	logger.Debugf("Deleting ZFS storage volume for container \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
 
@@ -691,32 +1410,58 @@ func (s *storageZfs) ContainerDelete(container container) error {
 	fs := fmt.Sprintf("containers/%s", containerName)
 	containerPoolVolumeMntPoint := getContainerMountPoint(s.pool.Name, containerName)
 
+	// The datasets left by restores are clones of the container's.
+	err := s.zfsContainerRestoredCleanup(containerName, true)
+	if err != nil {
+		return err
+	}
+
 	if s.zfsFilesystemEntityExists(fs, true) {
-		removable := true
-		snaps, err := s.zfsPoolListSnapshots(fs)
+		// Fetch the origin of the container and the clones of all of
+		// its snapshots at once instead of forking zfs per snapshot.
+		props, err := s.zfsPoolVolumeGetAll(fs, "origin", "clones")
 		if err != nil {
 			return err
 		}
 
-		for _, snap := range snaps {
-			var err error
-			removable, err = s.zfsPoolVolumeSnapshotRemovable(fs, snap)
-			if err != nil {
-				return err
+		removable := true
+		for name, values := range props {
+			if !strings.HasPrefix(name, fmt.Sprintf("%s@", fs)) {
+				continue
 			}
 
-			if !removable {
+			clones := values["clones"]
+			if clones != "-" && clones != "" {
+				removable = false
 				break
 			}
 		}
 
-		if removable {
-			origin, err := s.zfsFilesystemEntityPropertyGet(fs, "origin", true)
+		if !removable {
+			// Rather than keeping the container's dataset around
+			// until all of its copies are gone, have one of them
+			// take over the snapshots they were cloned from.
+			removable, err = s.zfsPoolVolumePromoteClones(fs)
 			if err != nil {
-				return err
+				logger.Warnf("Failed to promote the clones of \"%s\", keeping it until they're deleted: %s.", fs, err)
+				removable = false
+			}
+
+			// The container's dataset is now a clone of the
+			// promoted one.
+			if removable {
+				origin, err := s.zfsFilesystemEntityPropertyGet(fs, "origin", true)
+				if err != nil {
+					return err
+				}
+
+				props[fs]["origin"] = origin
 			}
+		}
+
+		if removable {
 			poolName := s.getOnDiskPoolName()
-			origin = strings.TrimPrefix(origin, fmt.Sprintf("%s/", poolName))
+			origin := strings.TrimPrefix(props[fs]["origin"], fmt.Sprintf("%s/", poolName))
 
 			err = s.zfsPoolVolumeDestroy(fs)
 			if err != nil {
@@ -740,7 +1485,7 @@ func (s *storageZfs) ContainerDelete(container container) error {
 		}
 	}
 
-	err := deleteContainerMountpoint(containerPoolVolumeMntPoint, container.Path(), s.GetStorageTypeName())
+	err = deleteContainerMountpoint(containerPoolVolumeMntPoint, container.Path(), s.GetStorageTypeName())
 	if err != nil {
 		return err
 	}
@@ -806,8 +1551,13 @@ func (s *storageZfs) copyWithoutSnapshotsSparse(target container, source contain
 			}()
 		}
 	} else {
-		if s.zfsFilesystemEntityExists(fmt.Sprintf("containers/%s@snapshot-%s", sourceName, sourceZfsDatasetSnapshot), true) {
-			sourceZfsDataset = fmt.Sprintf("containers/%s", sourceName)
+		snapFs, err := s.zfsContainerSnapshotDataset(sourceName, sourceZfsDatasetSnapshot)
+		if err != nil {
+			return err
+		}
+
+		if s.zfsFilesystemEntityExists(fmt.Sprintf("%s@snapshot-%s", snapFs, sourceZfsDatasetSnapshot), true) {
+			sourceZfsDataset = snapFs
 			sourceZfsDatasetSnapshot = fmt.Sprintf("snapshot-%s", sourceZfsDatasetSnapshot)
 		}
 	}
@@ -855,7 +1605,7 @@ func (s *storageZfs) copyWithoutSnapshotsSparse(target container, source contain
 		}()
 
 		bwlimit := s.pool.Config["rsync.bwlimit"]
-		output, err := rsyncLocalCopy(sourceContainerPath, targetContainerPath, bwlimit)
+		output, err := rsyncLocalCopy(sourceContainerPath, targetContainerPath, bwlimit, rsyncPoolLocalArgs(s.pool.Config)...)
 		if err != nil {
 			return fmt.Errorf("rsync failed: %s", string(output))
 		}
@@ -872,6 +1622,8 @@ func (s *storageZfs) copyWithoutSnapshotsSparse(target container, source contain
 }
 
 func (s *storageZfs) copyWithoutSnapshotFull(target container, source container) error {
+	defer s.zfsDatasetCacheInvalidate()
+
 	logger.Debugf("Creating full ZFS copy \"%s\" -> \"%s\".", source.Name(), target.Name())
 
 	sourceIsSnapshot := source.IsSnapshot()
@@ -908,118 +1660,268 @@ func (s *storageZfs) copyWithoutSnapshotFull(target container, source container)
 		}()
 	}
 
-	zfsSendCmd := exec.Command("zfs", "send", sourceDataset)
+	err := zfsSendReceive([]string{sourceDataset}, []string{targetDataset})
+	if err != nil {
+		return err
+	}
 
-	zfsRecvCmd := exec.Command("zfs", "receive", targetDataset)
+	msg, err := shared.RunCommand("zfs", "rollback", "-r", "-R", targetSnapshotDataset)
+	if err != nil {
+		logger.Errorf("Failed to rollback ZFS dataset: %s.", msg)
+		return err
+	}
 
-	zfsRecvCmd.Stdin, _ = zfsSendCmd.StdoutPipe()
-	zfsRecvCmd.Stdout = os.Stdout
-	zfsRecvCmd.Stderr = os.Stderr
+	targetContainerMountPoint := getContainerMountPoint(s.pool.Name, targetName)
+	targetfs := fmt.Sprintf("containers/%s", targetName)
 
-	err := zfsRecvCmd.Start()
+	err = s.zfsPoolVolumeSet(targetfs, "canmount", "noauto")
 	if err != nil {
 		return err
 	}
 
-	err = zfsSendCmd.Run()
+	err = s.zfsPoolVolumeSet(targetfs, "mountpoint", targetContainerMountPoint)
 	if err != nil {
 		return err
 	}
 
-	err = zfsRecvCmd.Wait()
+	err = s.zfsPoolVolumeSnapshotDestroy(targetfs, snapshotSuffix)
 	if err != nil {
 		return err
 	}
 
-	msg, err := shared.RunCommand("zfs", "rollback", "-r", "-R", targetSnapshotDataset)
+	ourMount, err := s.ContainerMount(target)
 	if err != nil {
-		logger.Errorf("Failed to rollback ZFS dataset: %s.", msg)
 		return err
 	}
+	if ourMount {
+		defer s.ContainerUmount(targetName, targetContainerMountPoint)
+	}
+
+	err = createContainerMountpoint(targetContainerMountPoint, target.Path(), target.IsPrivileged())
+	if err != nil {
+		return err
+	}
+
+	logger.Debugf("Created full ZFS copy \"%s\" -> \"%s\".", source.Name(), target.Name())
+	return nil
+}
+
+// copyWithSnapshots prepares the target snapshot and returns the transfer
+// which copies the source snapshot to it.
+func (s *storageZfs) copyWithSnapshots(target container, source container, parentSnapshot string) (zfsTransfer, error) {
+	sourceName := source.Name()
+	targetParentName, targetSnapOnlyName, _ := containerGetParentAndSnapshotName(target.Name())
+	containersPath := getSnapshotMountPoint(s.pool.Name, targetParentName)
+	snapshotMntPointSymlinkTarget := shared.VarPath("storage-pools", s.pool.Name, "snapshots", targetParentName)
+	snapshotMntPointSymlink := shared.VarPath("snapshots", targetParentName)
+	err := createSnapshotMountpoint(containersPath, snapshotMntPointSymlinkTarget, snapshotMntPointSymlink)
+	if err != nil {
+		return zfsTransfer{}, err
+	}
+
+	poolName := s.getOnDiskPoolName()
+	sourceParentName, sourceSnapOnlyName, _ := containerGetParentAndSnapshotName(sourceName)
+	currentSnapshotDataset := fmt.Sprintf("%s/containers/%s@snapshot-%s", poolName, sourceParentName, sourceSnapOnlyName)
+	args := []string{currentSnapshotDataset}
+	if parentSnapshot != "" {
+		parentName, parentSnaponlyName, _ := containerGetParentAndSnapshotName(parentSnapshot)
+		parentSnapshotDataset := fmt.Sprintf("%s/containers/%s@snapshot-%s", poolName, parentName, parentSnaponlyName)
+		args = append(args, "-i", parentSnapshotDataset)
+	}
+
+	targetSnapshotDataset := fmt.Sprintf("%s/containers/%s@snapshot-%s", poolName, targetParentName, targetSnapOnlyName)
+	return zfsTransfer{send: args, receive: []string{"-F", targetSnapshotDataset}}, nil
+}
+
+// zfsCopyParallelism returns how many snapshot sends may run at the same
+// time when copying a container.
+func (s *storageZfs) zfsCopyParallelism() int {
+	parallelism, err := strconv.Atoi(s.pool.Config["zfs.copy.parallelism"])
+	if err != nil || parallelism < 1 {
+		return 1
+	}
+
+	return parallelism
+}
+
+// copySnapshotsIncremental sends the snapshots of the source, each one
+// incrementally from the previous one starting with base (a snapshot name or
+// "" for a full send), to the matching snapshots of the target and finally
+// the current state of the source.
+func (s *storageZfs) copySnapshotsIncremental(target container, source container, base string, snapshots []container) error {
+	transfers := []zfsTransfer{}
+	prev := base
+	for _, snap := range snapshots {
+		sourceSnapshot, err := containerLoadByName(s.d, snap.Name())
+		if err != nil {
+			return err
+		}
+
+		_, snapOnlyName, _ := containerGetParentAndSnapshotName(snap.Name())
+		newSnapName := fmt.Sprintf("%s/%s", target.Name(), snapOnlyName)
+		targetSnapshot, err := containerLoadByName(s.d, newSnapName)
+		if err != nil {
+			return err
+		}
+
+		transfer, err := s.copyWithSnapshots(targetSnapshot, sourceSnapshot, prev)
+		if err != nil {
+			return err
+		}
+
+		transfers = append(transfers, transfer)
+		prev = snap.Name()
+	}
+
+	// send actual container
+	tmpSnapshotName := fmt.Sprintf("copy-send-%s", uuid.NewRandom().String())
+	err := s.zfsPoolVolumeSnapshotCreate(fmt.Sprintf("containers/%s", source.Name()), tmpSnapshotName)
+	if err != nil {
+		return err
+	}
+
+	poolName := s.getOnDiskPoolName()
+	currentSnapshotDataset := fmt.Sprintf("%s/containers/%s@%s", poolName, source.Name(), tmpSnapshotName)
+	args := []string{currentSnapshotDataset}
+	if prev != "" {
+		_, prevSnapOnlyName, _ := containerGetParentAndSnapshotName(prev)
+		parentSnapshotDataset := fmt.Sprintf("%s/containers/%s@snapshot-%s", poolName, source.Name(), prevSnapOnlyName)
+		args = append(args, "-i", parentSnapshotDataset)
+	}
+
+	// The snapshots and the container are sent together so that the
+	// sends can run ahead of the receives with zfs.copy.parallelism.
+	targetSnapshotDataset := fmt.Sprintf("%s/containers/%s@%s", poolName, target.Name(), tmpSnapshotName)
+	transfers = append(transfers, zfsTransfer{send: args, receive: []string{"-F", targetSnapshotDataset}})
+	err = zfsSendReceiveMany(transfers, s.zfsCopyParallelism())
+	if err != nil {
+		s.zfsPoolVolumeSnapshotDestroy(fmt.Sprintf("containers/%s", source.Name()), tmpSnapshotName)
+		return err
+	}
+
+	s.zfsPoolVolumeSnapshotDestroy(fmt.Sprintf("containers/%s", source.Name()), tmpSnapshotName)
+	s.zfsPoolVolumeSnapshotDestroy(fmt.Sprintf("containers/%s", target.Name()), tmpSnapshotName)
+
+	return nil
+}
+
+// copyAcrossPools copies a container from another ZFS storage pool by
+// sending its snapshots, each one incrementally from the previous one, and
+// its current state from the source zpool to the target one.
+func (s *storageZfs) copyAcrossPools(target container, source container, containerOnly bool) error {
+	sourceDataset, _, _ := zfsContainerDataset(source)
+	poolName := s.getOnDiskPoolName()
+	targetName := target.Name()
+	targetDataset := fmt.Sprintf("%s/containers/%s", poolName, targetName)
+
+	logger.Debugf("Copying ZFS container storage %s -> %s across storage pools.", sourceDataset, targetDataset)
+
+	snapshots := []container{}
+	if !containerOnly {
+		var err error
+		snapshots, err = source.Snapshots()
+		if err != nil {
+			return err
+		}
+	}
+
+	transfers := []zfsTransfer{}
+	prev := ""
+	if len(snapshots) > 0 {
+		// Snapshots held by the datasets left by restores can't be
+		// sent along with the container.
+		output, err := shared.RunCommand("zfs", "list", "-t", "snapshot", "-H", "-o", "name", "-d", "1", sourceDataset)
+		if err != nil {
+			return fmt.Errorf("Failed to list ZFS snapshots: %s", output)
+		}
+
+		existing := strings.Split(output, "\n")
+		for _, snap := range snapshots {
+			_, snapOnlyName, _ := containerGetParentAndSnapshotName(snap.Name())
+			if !shared.StringInSlice(fmt.Sprintf("%s@snapshot-%s", sourceDataset, snapOnlyName), existing) {
+				return fmt.Errorf("Some snapshots of \"%s\" are newer than the one it was restored to, it can only be transferred without its snapshots", source.Name())
+			}
+		}
 
-	targetContainerMountPoint := getContainerMountPoint(s.pool.Name, targetName)
-	targetfs := fmt.Sprintf("containers/%s", targetName)
+		snapshotMntPoint := getSnapshotMountPoint(s.pool.Name, targetName)
+		snapshotMntPointSymlinkTarget := shared.VarPath("storage-pools", s.pool.Name, "snapshots", targetName)
+		snapshotMntPointSymlink := shared.VarPath("snapshots", targetName)
+		err = createSnapshotMountpoint(snapshotMntPoint, snapshotMntPointSymlinkTarget, snapshotMntPointSymlink)
+		if err != nil {
+			return err
+		}
 
-	err = s.zfsPoolVolumeSet(targetfs, "canmount", "noauto")
-	if err != nil {
-		return err
+		for _, snap := range snapshots {
+			_, snapOnlyName, _ := containerGetParentAndSnapshotName(snap.Name())
+			args := []string{fmt.Sprintf("%s@snapshot-%s", sourceDataset, snapOnlyName)}
+			if prev != "" {
+				args = append(args, "-i", fmt.Sprintf("%s@snapshot-%s", sourceDataset, prev))
+			}
+
+			transfers = append(transfers, zfsTransfer{send: args, receive: []string{"-F", fmt.Sprintf("%s@snapshot-%s", targetDataset, snapOnlyName)}})
+			prev = snapOnlyName
+		}
 	}
 
-	err = s.zfsPoolVolumeSet(targetfs, "mountpoint", targetContainerMountPoint)
+	// send actual container
+	tmpSnapshotName := fmt.Sprintf("copy-send-%s", uuid.NewRandom().String())
+	output, err := shared.RunCommand("zfs", "snapshot", fmt.Sprintf("%s@%s", sourceDataset, tmpSnapshotName))
 	if err != nil {
-		return err
+		return fmt.Errorf("Failed to create ZFS snapshot: %s", output)
 	}
+	defer func() {
+		output, err := shared.RunCommand("zfs", "destroy", fmt.Sprintf("%s@%s", sourceDataset, tmpSnapshotName))
+		if err != nil {
+			logger.Warnf("Failed to delete temporary ZFS snapshot \"%s@%s\": %s. Manual cleanup needed.", sourceDataset, tmpSnapshotName, output)
+		}
+		zfsDatasetCacheInvalidate(sourceDataset)
+	}()
 
-	err = s.zfsPoolVolumeSnapshotDestroy(targetfs, snapshotSuffix)
-	if err != nil {
-		return err
+	args := []string{fmt.Sprintf("%s@%s", sourceDataset, tmpSnapshotName)}
+	if prev != "" {
+		args = append(args, "-i", fmt.Sprintf("%s@snapshot-%s", sourceDataset, prev))
 	}
 
-	ourMount, err := s.ContainerMount(target)
+	transfers = append(transfers, zfsTransfer{send: args, receive: []string{"-F", fmt.Sprintf("%s@%s", targetDataset, tmpSnapshotName)}})
+	err = zfsSendReceiveMany(transfers, s.zfsCopyParallelism())
 	if err != nil {
 		return err
 	}
-	if ourMount {
-		defer s.ContainerUmount(targetName, targetContainerMountPoint)
-	}
 
-	err = createContainerMountpoint(targetContainerMountPoint, target.Path(), target.IsPrivileged())
+	fs := fmt.Sprintf("containers/%s", targetName)
+	err = s.zfsPoolVolumeSnapshotDestroy(fs, tmpSnapshotName)
 	if err != nil {
 		return err
 	}
 
-	logger.Debugf("Created full ZFS copy \"%s\" -> \"%s\".", source.Name(), target.Name())
-	return nil
-}
-
-func (s *storageZfs) copyWithSnapshots(target container, source container, parentSnapshot string) error {
-	sourceName := source.Name()
-	targetParentName, targetSnapOnlyName, _ := containerGetParentAndSnapshotName(target.Name())
-	containersPath := getSnapshotMountPoint(s.pool.Name, targetParentName)
-	snapshotMntPointSymlinkTarget := shared.VarPath("storage-pools", s.pool.Name, "snapshots", targetParentName)
-	snapshotMntPointSymlink := shared.VarPath("snapshots", targetParentName)
-	err := createSnapshotMountpoint(containersPath, snapshotMntPointSymlinkTarget, snapshotMntPointSymlink)
+	targetContainerMountPoint := getContainerMountPoint(s.pool.Name, targetName)
+	err = s.zfsPoolVolumeSet(fs, "canmount", "noauto")
 	if err != nil {
 		return err
 	}
 
-	poolName := s.getOnDiskPoolName()
-	sourceParentName, sourceSnapOnlyName, _ := containerGetParentAndSnapshotName(sourceName)
-	currentSnapshotDataset := fmt.Sprintf("%s/containers/%s@snapshot-%s", poolName, sourceParentName, sourceSnapOnlyName)
-	args := []string{"send", currentSnapshotDataset}
-	if parentSnapshot != "" {
-		parentName, parentSnaponlyName, _ := containerGetParentAndSnapshotName(parentSnapshot)
-		parentSnapshotDataset := fmt.Sprintf("%s/containers/%s@snapshot-%s", poolName, parentName, parentSnaponlyName)
-		args = append(args, "-i", parentSnapshotDataset)
-	}
-
-	zfsSendCmd := exec.Command("zfs", args...)
-	targetSnapshotDataset := fmt.Sprintf("%s/containers/%s@snapshot-%s", poolName, targetParentName, targetSnapOnlyName)
-	zfsRecvCmd := exec.Command("zfs", "receive", "-F", targetSnapshotDataset)
-
-	zfsRecvCmd.Stdin, _ = zfsSendCmd.StdoutPipe()
-	zfsRecvCmd.Stdout = os.Stdout
-	zfsRecvCmd.Stderr = os.Stderr
-
-	err = zfsRecvCmd.Start()
+	err = s.zfsPoolVolumeSet(fs, "mountpoint", targetContainerMountPoint)
 	if err != nil {
 		return err
 	}
 
-	err = zfsSendCmd.Run()
+	err = createContainerMountpoint(targetContainerMountPoint, target.Path(), target.IsPrivileged())
 	if err != nil {
 		return err
 	}
 
-	err = zfsRecvCmd.Wait()
+	err = s.zfsPoolVolumeDefaultsApply(fs, s.volume.Config)
 	if err != nil {
 		return err
 	}
 
+	logger.Debugf("Copied ZFS container storage %s -> %s across storage pools.", sourceDataset, targetDataset)
 	return nil
 }
 
 func (s *storageZfs) ContainerCopy(target container, source container, containerOnly bool) error {
+	defer s.zfsDatasetCacheInvalidate()
+
 	logger.Debugf("Copying ZFS container storage %s -> %s.", source.Name(), target.Name())
 
 	ourStart, err := source.StorageStart()
@@ -1033,7 +1935,11 @@ func (s *storageZfs) ContainerCopy(target container, source container, container
 	_, sourcePool := source.Storage().GetContainerPoolInfo()
 	_, targetPool := target.Storage().GetContainerPoolInfo()
 	if sourcePool != targetPool {
-		return fmt.Errorf("copying containers between different storage pools is not implemented")
+		if source.Storage().GetStorageType() != storageTypeZfs {
+			return storageContainerCopyAcrossPools(s, target, source, containerOnly)
+		}
+
+		return s.copyAcrossPools(target, source, containerOnly)
 	}
 
 	snapshots, err := source.Snapshots()
@@ -1048,6 +1954,11 @@ func (s *storageZfs) ContainerCopy(target container, source container, container
 			err = s.copyWithoutSnapshotsSparse(target, source)
 		}
 	} else {
+		err = s.zfsContainerSnapshotsLinear(source.Name())
+		if err != nil {
+			return err
+		}
+
 		targetContainerName := target.Name()
 		targetContainerPath := target.Path()
 		targetContainerMountPoint := getContainerMountPoint(s.pool.Name, targetContainerName)
@@ -1056,88 +1967,94 @@ func (s *storageZfs) ContainerCopy(target container, source container, container
 			return err
 		}
 
-		prev := ""
-		prevSnapOnlyName := ""
-		for i, snap := range snapshots {
-			if i > 0 {
-				prev = snapshots[i-1].Name()
-			}
-
-			sourceSnapshot, err := containerLoadByName(s.d, snap.Name())
-			if err != nil {
-				return err
-			}
-
-			_, snapOnlyName, _ := containerGetParentAndSnapshotName(snap.Name())
-			prevSnapOnlyName = snapOnlyName
-			newSnapName := fmt.Sprintf("%s/%s", target.Name(), snapOnlyName)
-			targetSnapshot, err := containerLoadByName(s.d, newSnapName)
-			if err != nil {
-				return err
-			}
-
-			err = s.copyWithSnapshots(targetSnapshot, sourceSnapshot, prev)
-			if err != nil {
-				return err
-			}
-		}
-
-		// send actual container
-		tmpSnapshotName := fmt.Sprintf("copy-send-%s", uuid.NewRandom().String())
-		err = s.zfsPoolVolumeSnapshotCreate(fmt.Sprintf("containers/%s", source.Name()), tmpSnapshotName)
+		err = s.copySnapshotsIncremental(target, source, "", snapshots)
 		if err != nil {
 			return err
 		}
 
-		poolName := s.getOnDiskPoolName()
-		currentSnapshotDataset := fmt.Sprintf("%s/containers/%s@%s", poolName, source.Name(), tmpSnapshotName)
-		args := []string{"send", currentSnapshotDataset}
-		if prevSnapOnlyName != "" {
-			parentSnapshotDataset := fmt.Sprintf("%s/containers/%s@snapshot-%s", poolName, source.Name(), prevSnapOnlyName)
-			args = append(args, "-i", parentSnapshotDataset)
-		}
-
-		zfsSendCmd := exec.Command("zfs", args...)
-		targetSnapshotDataset := fmt.Sprintf("%s/containers/%s@%s", poolName, target.Name(), tmpSnapshotName)
-		zfsRecvCmd := exec.Command("zfs", "receive", "-F", targetSnapshotDataset)
-
-		zfsRecvCmd.Stdin, _ = zfsSendCmd.StdoutPipe()
-		zfsRecvCmd.Stdout = os.Stdout
-		zfsRecvCmd.Stderr = os.Stderr
+		fs := fmt.Sprintf("containers/%s", target.Name())
 
-		err = zfsRecvCmd.Start()
+		err = s.zfsPoolVolumeSet(fs, "canmount", "noauto")
 		if err != nil {
 			return err
 		}
 
-		err = zfsSendCmd.Run()
+		err = s.zfsPoolVolumeSet(fs, "mountpoint", targetContainerMountPoint)
 		if err != nil {
 			return err
 		}
 
-		err = zfsRecvCmd.Wait()
-		if err != nil {
-			return err
-		}
+	}
 
-		s.zfsPoolVolumeSnapshotDestroy(fmt.Sprintf("containers/%s", source.Name()), tmpSnapshotName)
-		s.zfsPoolVolumeSnapshotDestroy(fmt.Sprintf("containers/%s", target.Name()), tmpSnapshotName)
+	if err != nil {
+		return err
+	}
 
-		fs := fmt.Sprintf("containers/%s", target.Name())
+	err = s.zfsPoolVolumeDefaultsApply(fmt.Sprintf("containers/%s", target.Name()), s.volume.Config)
+	if err != nil {
+		return err
+	}
 
-		err = s.zfsPoolVolumeSet(fs, "canmount", "noauto")
-		if err != nil {
-			return err
-		}
+	logger.Debugf("Copied ZFS container storage %s -> %s.", source.Name(), target.Name())
+	return nil
+}
 
-		err = s.zfsPoolVolumeSet(fs, "mountpoint", targetContainerMountPoint)
+func (s *storageZfs) ContainerRefresh(target container, source container, base container, snapshots []container) error {
+	defer s.zfsDatasetCacheInvalidate()
+
+	logger.Debugf("Refreshing ZFS container storage %s -> %s.", source.Name(), target.Name())
+
+	_, sourcePool := source.Storage().GetContainerPoolInfo()
+	_, targetPool := target.Storage().GetContainerPoolInfo()
+	if sourcePool != targetPool {
+		return fmt.Errorf("refreshing containers between different storage pools is not implemented")
+	}
+
+	for _, c := range []container{source, target} {
+		err := s.zfsContainerSnapshotsLinear(c.Name())
 		if err != nil {
 			return err
 		}
+	}
+
+	// The incremental streams only apply if the target's copy of the base
+	// snapshot is the very same as the source's.
+	baseParentName, baseSnapOnlyName, _ := containerGetParentAndSnapshotName(base.Name())
+	sourceGUID, err := s.zfsFilesystemEntityPropertyGet(fmt.Sprintf("containers/%s@snapshot-%s", baseParentName, baseSnapOnlyName), "guid", true)
+	if err != nil {
+		return err
+	}
+
+	targetGUID, err := s.zfsFilesystemEntityPropertyGet(fmt.Sprintf("containers/%s@snapshot-%s", target.Name(), baseSnapOnlyName), "guid", true)
+	if err != nil {
+		return err
+	}
 
+	if sourceGUID != targetGUID {
+		return fmt.Errorf("The snapshot \"%s\" of \"%s\" isn't a copy of the source's, the container must be copied again", baseSnapOnlyName, target.Name())
 	}
 
-	logger.Debugf("Copied ZFS container storage %s -> %s.", source.Name(), target.Name())
+	ourStart, err := source.StorageStart()
+	if err != nil {
+		return err
+	}
+	if ourStart {
+		defer source.StorageStop()
+	}
+
+	// The target is rolled back to the base snapshot by the receive.
+	targetMountPoint := getContainerMountPoint(s.pool.Name, target.Name())
+	_, err = s.ContainerUmount(target.Name(), targetMountPoint)
+	if err != nil {
+		return err
+	}
+
+	err = s.copySnapshotsIncremental(target, source, base.Name(), snapshots)
+	if err != nil {
+		return err
+	}
+
+	logger.Debugf("Refreshed ZFS container storage %s -> %s.", source.Name(), target.Name())
 	return nil
 }
 
@@ -1167,6 +2084,20 @@ func (s *storageZfs) ContainerRename(container container, newName string) error
 		s.ContainerRename(container, oldName)
 	}()
 
+	// Rename the datasets left by restores along.
+	restored, err := s.zfsContainerRestoredDatasets(oldName)
+	if err != nil {
+		return err
+	}
+
+	for _, dataset := range restored {
+		suffix := strings.TrimPrefix(dataset, zfsRestoredDatasetPrefix(oldName))
+		err = s.zfsPoolVolumeRename(dataset, zfsRestoredDatasetPrefix(newName)+suffix)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Set the new mountpoint for the dataset.
 	newContainerMntPoint := getContainerMountPoint(s.pool.Name, newName)
 	err = s.zfsPoolVolumeSet(newZfsDataset, "mountpoint", newContainerMntPoint)
@@ -1235,42 +2166,224 @@ func (s *storageZfs) ContainerRestore(target container, source container) error
 		defer source.StorageStop()
 	}
 
-	// Start storage for target container
-	ourTargetStart, err := target.StorageStart()
+	// Start storage for target container
+	ourTargetStart, err := target.StorageStart()
+	if err != nil {
+		return err
+	}
+	if ourTargetStart {
+		defer target.StorageStop()
+	}
+
+	// Remove any needed snapshot
+	if s.zfsRemoveNewerSnapshots() {
+		snaps, err := target.Snapshots()
+		if err != nil {
+			return err
+		}
+
+		for i := len(snaps) - 1; i != 0; i-- {
+			if snaps[i].Name() == source.Name() {
+				break
+			}
+
+			err := snaps[i].Delete()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Restore the snapshot
+	cName, snapOnlyName, _ := containerGetParentAndSnapshotName(source.Name())
+	snapName := fmt.Sprintf("snapshot-%s", snapOnlyName)
+	fs := fmt.Sprintf("containers/%s", cName)
+
+	// The file monitor snapshots are newer and would block the rollback.
+	err = zfsFileMonitorReset(cName, fmt.Sprintf("%s/%s", s.getOnDiskPoolName(), fs))
+	if err != nil {
+		return err
+	}
+
+	snapFs, err := s.zfsContainerSnapshotDataset(cName, snapOnlyName)
+	if err != nil {
+		return err
+	}
+
+	zfsSnapshots, err := s.zfsPoolListSnapshots(fs)
+	if err != nil {
+		return err
+	}
+
+	// Rolling back is only possible to the latest snapshot.
+	if snapFs == fs && len(zfsSnapshots) > 0 && zfsSnapshots[len(zfsSnapshots)-1] == snapName {
+		err = s.zfsPoolVolumeSnapshotRestore(fs, snapName)
+		if err != nil {
+			return err
+		}
+	} else {
+		err = s.zfsContainerRestoreSwap(target, snapFs, snapName)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Debugf("Restored ZFS storage volume for container \"%s\" from %s -> %s.", s.volume.Name, source.Name(), target.Name())
+	return nil
+}
+
+func (s *storageZfs) ContainerRestorePlan(target container, source container, plan *api.OperationPlan) error {
+	cName, snapOnlyName, _ := containerGetParentAndSnapshotName(source.Name())
+	snapName := fmt.Sprintf("snapshot-%s", snapOnlyName)
+	fs := fmt.Sprintf("containers/%s", cName)
+
+	if s.zfsRemoveNewerSnapshots() {
+		snaps, err := target.Snapshots()
+		if err != nil {
+			return err
+		}
+
+		for i := len(snaps) - 1; i != 0; i-- {
+			if snaps[i].Name() == source.Name() {
+				break
+			}
+
+			plan.Actions = append(plan.Actions, fmt.Sprintf("Delete snapshot %s, newer than %s", snaps[i].Name(), source.Name()))
+		}
+
+		plan.Actions = append(plan.Actions, fmt.Sprintf("Roll the ZFS dataset \"%s\" back to \"%s\"", fs, snapName))
+		return nil
+	}
+
+	snapFs, err := s.zfsContainerSnapshotDataset(cName, snapOnlyName)
+	if err != nil {
+		return err
+	}
+
+	zfsSnapshots, err := s.zfsPoolListSnapshots(fs)
+	if err != nil {
+		return err
+	}
+
+	if snapFs == fs && len(zfsSnapshots) > 0 && zfsSnapshots[len(zfsSnapshots)-1] == snapName {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("Roll the ZFS dataset \"%s\" back to \"%s\"", fs, snapName))
+		return nil
+	}
+
+	subvols, err := s.zfsPoolListSubvolumes(fmt.Sprintf("%s/%s", s.getOnDiskPoolName(), fs))
+	if err != nil {
+		return err
+	}
+
+	if len(subvols) > 0 {
+		return fmt.Errorf("Containers with ZFS sub-volumes can only be restored to their latest snapshot. Set \"zfs.remove_snapshots\" to delete the newer snapshots")
+	}
+
+	plan.Actions = append(plan.Actions, fmt.Sprintf("Replace the ZFS dataset \"%s\" with a clone of \"%s@%s\"", fs, snapFs, snapName))
+	plan.Warnings = append(plan.Warnings, fmt.Sprintf("The previous ZFS dataset is kept as \"%s<uuid>\" while it holds the newer snapshots", zfsRestoredDatasetPrefix(cName)))
+	return nil
+}
+
+// zfsContainerRestoreSwap restores a container to a snapshot which isn't the
+// latest one without deleting the newer snapshots. A clone of the snapshot
+// takes the place of the container's dataset and is promoted so that it holds
+// the snapshots it descends from. The previous dataset is kept as
+// "containers/<name>.restored-<uuid>" for as long as it holds the newer
+// snapshots.
+func (s *storageZfs) zfsContainerRestoreSwap(target container, snapFs string, snapName string) error {
+	cName := target.Name()
+	fs := fmt.Sprintf("containers/%s", cName)
+	poolName := s.getOnDiskPoolName()
+
+	subvols, err := s.zfsPoolListSubvolumes(fmt.Sprintf("%s/%s", poolName, fs))
+	if err != nil {
+		return err
+	}
+
+	if len(subvols) > 0 {
+		return fmt.Errorf("Containers with ZFS sub-volumes can only be restored to their latest snapshot. Set \"zfs.remove_snapshots\" to delete the newer snapshots")
+	}
+
+	// The properties set on the dataset, like its quota, go with it.
+	props, err := s.zfsPoolVolumeLocalProperties(fs, append([]string{"quota", "refquota"}, zfsPoolVolumeTuningKeys...)...)
+	if err != nil {
+		return err
+	}
+
+	cloneFs := fmt.Sprintf("%s%s", zfsRestoredDatasetPrefix(cName), uuid.NewRandom().String())
+	err = s.zfsPoolVolumeClone(snapFs, snapName, cloneFs, "none")
+	if err != nil {
+		return err
+	}
+
+	ourUmount, err := s.ContainerUmount(cName, "")
+	if err != nil {
+		s.zfsPoolVolumeDestroy(cloneFs)
+		return err
+	}
+
+	oldFs := fmt.Sprintf("%s%s", zfsRestoredDatasetPrefix(cName), uuid.NewRandom().String())
+	err = s.zfsPoolVolumeRename(fs, oldFs)
+	if err != nil {
+		s.zfsPoolVolumeDestroy(cloneFs)
+		return err
+	}
+
+	err = s.zfsPoolVolumeRename(cloneFs, fs)
 	if err != nil {
+		s.zfsPoolVolumeRename(oldFs, fs)
+		s.zfsPoolVolumeDestroy(cloneFs)
 		return err
 	}
-	if ourTargetStart {
-		defer target.StorageStop()
+
+	err = s.zfsPoolVolumeSet(oldFs, "mountpoint", "none")
+	if err != nil {
+		return err
 	}
 
-	// Remove any needed snapshot
-	snaps, err := target.Snapshots()
+	err = s.zfsPoolVolumeSet(fs, "mountpoint", getContainerMountPoint(s.pool.Name, cName))
 	if err != nil {
 		return err
 	}
 
-	for i := len(snaps) - 1; i != 0; i-- {
-		if snaps[i].Name() == source.Name() {
+	for key, value := range props {
+		err = s.zfsPoolVolumeSet(fs, key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Take over the snapshots the restored one descends from, which may be
+	// spread over the datasets left by previous restores.
+	prefix := fmt.Sprintf("%s/%s", poolName, zfsRestoredDatasetPrefix(cName))
+	for {
+		origin, err := s.zfsFilesystemEntityPropertyGet(fs, "origin", true)
+		if err != nil {
+			return err
+		}
+
+		if !strings.HasPrefix(origin, prefix) {
 			break
 		}
 
-		err := snaps[i].Delete()
+		err = s.zfsPoolVolumePromote(fs)
 		if err != nil {
 			return err
 		}
 	}
 
-	// Restore the snapshot
-	cName, snapOnlyName, _ := containerGetParentAndSnapshotName(source.Name())
-	snapName := fmt.Sprintf("snapshot-%s", snapOnlyName)
-
-	err = s.zfsPoolVolumeSnapshotRestore(fmt.Sprintf("containers/%s", cName), snapName)
+	err = s.zfsContainerRestoredCleanup(cName, false)
 	if err != nil {
 		return err
 	}
 
-	logger.Debugf("Restored ZFS storage volume for container \"%s\" from %s -> %s.", s.volume.Name, source.Name(), target.Name())
+	if ourUmount {
+		_, err = s.ContainerMount(target)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -1282,16 +2395,9 @@ func (s *storageZfs) ContainerSetQuota(container container, size int64) error {
 	fs := fmt.Sprintf("containers/%s", container.Name())
 
 	property := "quota"
-
-	if s.pool.Config["volume.zfs.use_refquota"] != "" {
-		zfsUseRefquota = s.pool.Config["volume.zfs.use_refquota"]
-	}
-	if s.volume.Config["zfs.use_refquota"] != "" {
-		zfsUseRefquota = s.volume.Config["zfs.use_refquota"]
-	}
-
-	if shared.IsTrue(zfsUseRefquota) {
-		property = "refquota"
+	otherProperty := "refquota"
+	if s.zfsUseRefquota(s.volume.Config) {
+		property, otherProperty = otherProperty, property
 	}
 
 	if size > 0 {
@@ -1304,6 +2410,21 @@ func (s *storageZfs) ContainerSetQuota(container container, size int64) error {
 		return err
 	}
 
+	// Don't leave a quota behind from before "zfs.use_refquota" changed.
+	err = s.zfsPoolVolumeSet(fs, otherProperty, "none")
+	if err != nil {
+		return err
+	}
+
+	// A reservation set outside of LXD is left alone unless one is
+	// configured, its removal being handled by StoragePoolVolumeUpdate.
+	if s.zfsPoolVolumeReservationConfigured(s.volume.Config) {
+		err = s.zfsPoolVolumeReservationApply(fs, s.volume.Config, size)
+		if err != nil {
+			return err
+		}
+	}
+
 	logger.Debugf("Set ZFS quota for container \"%s\".", container.Name())
 	return nil
 }
@@ -1314,15 +2435,7 @@ func (s *storageZfs) ContainerGetUsage(container container) (int64, error) {
 	fs := fmt.Sprintf("containers/%s", container.Name())
 
 	property := "used"
-
-	if s.pool.Config["volume.zfs.use_refquota"] != "" {
-		zfsUseRefquota = s.pool.Config["volume.zfs.use_refquota"]
-	}
-	if s.volume.Config["zfs.use_refquota"] != "" {
-		zfsUseRefquota = s.volume.Config["zfs.use_refquota"]
-	}
-
-	if shared.IsTrue(zfsUseRefquota) {
+	if s.zfsUseRefquota(s.volume.Config) {
 		property = "usedbydataset"
 	}
 
@@ -1384,27 +2497,119 @@ func (s *storageZfs) ContainerSnapshotCreate(snapshotContainer container, source
 	return nil
 }
 
+func (s *storageZfs) ContainerDeletePlan(container container, plan *api.OperationPlan) error {
+	if container.IsSnapshot() {
+		cName, snapOnlyName, _ := containerGetParentAndSnapshotName(container.Name())
+		snapName := fmt.Sprintf("snapshot-%s", snapOnlyName)
+
+		snapFs, err := s.zfsContainerSnapshotDataset(cName, snapOnlyName)
+		if err != nil {
+			return err
+		}
+
+		snapDataset := fmt.Sprintf("%s@%s", snapFs, snapName)
+		props, err := s.zfsPoolVolumeGetAll(snapDataset, "clones", "userrefs")
+		if err != nil || props[snapDataset] == nil {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("The ZFS snapshot \"%s\" doesn't exist", snapDataset))
+			return nil
+		}
+
+		userrefs := props[snapDataset]["userrefs"]
+		if userrefs != "" && userrefs != "0" {
+			return fmt.Errorf("The ZFS snapshot \"%s\" is held (%s holds), release it first", snapDataset, userrefs)
+		}
+
+		clones := props[snapDataset]["clones"]
+		if clones == "-" || clones == "" {
+			plan.Actions = append(plan.Actions, fmt.Sprintf("Destroy the ZFS snapshot \"%s\"", snapDataset))
+		} else {
+			plan.Actions = append(plan.Actions, fmt.Sprintf("Rename the ZFS snapshot \"%s\" away, it's kept until its clones are deleted", snapDataset))
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("The ZFS snapshot \"%s\" has clones: %s", snapDataset, clones))
+		}
+
+		return nil
+	}
+
+	fs := fmt.Sprintf("containers/%s", container.Name())
+	restored, err := s.zfsContainerRestoredDatasets(container.Name())
+	if err != nil {
+		return err
+	}
+
+	for _, dataset := range restored {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("Destroy the ZFS dataset \"%s\" left by a restore", dataset))
+	}
+
+	if !s.zfsFilesystemEntityExists(fs, true) {
+		return nil
+	}
+
+	// The snapshots are gone by then, the ones with clones having been
+	// renamed away.
+	props, err := s.zfsPoolVolumeGetAll(fs, "clones")
+	if err != nil {
+		return err
+	}
+
+	clones := []string{}
+	for name, values := range props {
+		if strings.HasPrefix(name, fmt.Sprintf("%s@", fs)) && values["clones"] != "-" && values["clones"] != "" {
+			clones = append(clones, values["clones"])
+		}
+	}
+
+	if len(clones) > 0 {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("Promote a clone of the ZFS dataset \"%s\" to take over its snapshots", fs))
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("The ZFS dataset \"%s\" is kept under \"deleted/\" if its clones can't be promoted: %s", fs, strings.Join(clones, ",")))
+	}
+
+	plan.Actions = append(plan.Actions, fmt.Sprintf("Destroy the ZFS dataset \"%s\"", fs))
+	return nil
+}
+
 func (s *storageZfs) ContainerSnapshotDelete(snapshotContainer container) error {
 	logger.Debugf("Deleting ZFS storage volume for snapshot \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
 
 	sourceContainerName, sourceContainerSnapOnlyName, _ := containerGetParentAndSnapshotName(snapshotContainer.Name())
 	snapName := fmt.Sprintf("snapshot-%s", sourceContainerSnapOnlyName)
 
-	if s.zfsFilesystemEntityExists(fmt.Sprintf("containers/%s@%s", sourceContainerName, snapName), true) {
-		removable, err := s.zfsPoolVolumeSnapshotRemovable(fmt.Sprintf("containers/%s", sourceContainerName), snapName)
-		if removable {
-			err = s.zfsPoolVolumeSnapshotDestroy(fmt.Sprintf("containers/%s", sourceContainerName), snapName)
+	snapFs, err := s.zfsContainerSnapshotDataset(sourceContainerName, sourceContainerSnapOnlyName)
+	if err != nil {
+		return err
+	}
+
+	snapDataset := fmt.Sprintf("%s@%s", snapFs, snapName)
+	props, err := s.zfsPoolVolumeGetAll(snapDataset, "clones", "userrefs")
+	if err == nil && props[snapDataset] != nil {
+		// A held snapshot can't be destroyed, and renaming it away
+		// would leave a dataset behind which LXD never cleans up.
+		userrefs := props[snapDataset]["userrefs"]
+		if userrefs != "" && userrefs != "0" {
+			return fmt.Errorf("The ZFS snapshot \"%s\" is held (%s holds), release it first", snapDataset, userrefs)
+		}
+
+		clones := props[snapDataset]["clones"]
+		if clones == "-" || clones == "" {
+			err = s.zfsPoolVolumeSnapshotDestroy(snapFs, snapName)
 			if err != nil {
 				return err
 			}
 		} else {
-			err = s.zfsPoolVolumeSnapshotRename(fmt.Sprintf("containers/%s", sourceContainerName), snapName, fmt.Sprintf("copy-%s", uuid.NewRandom().String()))
+			err = s.zfsPoolVolumeSnapshotRename(snapFs, snapName, fmt.Sprintf("copy-%s", uuid.NewRandom().String()))
 			if err != nil {
 				return err
 			}
 		}
 	}
 
+	// The dataset left by a restore goes with its last snapshot.
+	if snapFs != fmt.Sprintf("containers/%s", sourceContainerName) {
+		err = s.zfsContainerRestoredCleanup(sourceContainerName, false)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Delete the snapshot on its storage pool:
 	// ${POOL}/snapshots/<snapshot_name>
 	snapshotContainerName := snapshotContainer.Name()
@@ -1472,7 +2677,12 @@ func (s *storageZfs) ContainerSnapshotRename(snapshotContainer container, newNam
 	newZfsDatasetName := fmt.Sprintf("snapshot-%s", newSnapOnlyName)
 
 	if oldZfsDatasetName != newZfsDatasetName {
-		err := s.zfsPoolVolumeSnapshotRename(fmt.Sprintf("containers/%s", oldcName), oldZfsDatasetName, newZfsDatasetName)
+		snapFs, err := s.zfsContainerSnapshotDataset(oldcName, oldSnapOnlyName)
+		if err != nil {
+			return err
+		}
+
+		err = s.zfsPoolVolumeSnapshotRename(snapFs, oldZfsDatasetName, newZfsDatasetName)
 		if err != nil {
 			return err
 		}
@@ -1491,79 +2701,277 @@ func (s *storageZfs) ContainerSnapshotRename(snapshotContainer container, newNam
 		if err != nil {
 			return err
 		}
-	}
+	}
+
+	oldSnapshotMntPoint := getSnapshotMountPoint(s.pool.Name, oldName)
+	if shared.PathExists(oldSnapshotMntPoint) {
+		err := os.Remove(oldSnapshotMntPoint)
+		if err != nil {
+			return err
+		}
+	}
+
+	newSnapshotMntPoint := getSnapshotMountPoint(s.pool.Name, newName)
+	if !shared.PathExists(newSnapshotMntPoint) {
+		err := os.MkdirAll(newSnapshotMntPoint, 0700)
+		if err != nil {
+			return err
+		}
+	}
+
+	snapshotMntPointSymlinkTarget := shared.VarPath("storage-pools", s.pool.Name, "snapshots", oldcName)
+	snapshotMntPointSymlink := shared.VarPath("snapshots", oldcName)
+	if !shared.PathExists(snapshotMntPointSymlink) {
+		err := os.Symlink(snapshotMntPointSymlinkTarget, snapshotMntPointSymlink)
+		if err != nil {
+			return err
+		}
+	}
+
+	revert = false
+
+	logger.Debugf("Renamed ZFS storage volume for snapshot \"%s\" from %s -> %s.", s.volume.Name, s.volume.Name, newName)
+	return nil
+}
+
+func (s *storageZfs) ContainerSnapshotStart(container container) (bool, error) {
+	logger.Debugf("Initializing ZFS storage volume for snapshot \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+
+	cName, sName, _ := containerGetParentAndSnapshotName(container.Name())
+	sourceFs, err := s.zfsContainerSnapshotDataset(cName, sName)
+	if err != nil {
+		return false, err
+	}
+
+	sourceSnap := fmt.Sprintf("snapshot-%s", sName)
+	destFs := fmt.Sprintf("snapshots/%s/%s", cName, sName)
+
+	snapshotMntPoint := getSnapshotMountPoint(s.pool.Name, container.Name())
+	err = s.zfsPoolVolumeClone(sourceFs, sourceSnap, destFs, snapshotMntPoint)
+	if err != nil {
+		return false, err
+	}
+
+	err = s.zfsPoolVolumeMount(destFs)
+	if err != nil {
+		return false, err
+	}
+
+	logger.Debugf("Initialized ZFS storage volume for snapshot \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	return true, nil
+}
+
+func (s *storageZfs) ContainerSnapshotStop(container container) (bool, error) {
+	logger.Debugf("Stopping ZFS storage volume for snapshot \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+
+	cName, sName, _ := containerGetParentAndSnapshotName(container.Name())
+	destFs := fmt.Sprintf("snapshots/%s/%s", cName, sName)
+
+	err := s.zfsPoolVolumeDestroy(destFs)
+	if err != nil {
+		return false, err
+	}
+
+	logger.Debugf("Stopped ZFS storage volume for snapshot \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+	return true, nil
+}
+
+func (s *storageZfs) ContainerSnapshotCreateEmpty(snapshotContainer container) error {
+	/* don't touch the fs yet, as migration will do that for us */
+	return nil
+}
+
+func (s *storageZfs) ContainerBackupCreate(backup backupArgs, source container, path string) error {
+	logger.Debugf("Creating optimized ZFS backup \"%s\" of container \"%s\" on storage pool \"%s\".", backup.Name, source.Name(), s.pool.Name)
+
+	poolName := s.getOnDiskPoolName()
+	fs := fmt.Sprintf("containers/%s", source.Name())
+
+	// Each snapshot is sent incrementally from the previous one.
+	prev := ""
+	if !backup.ContainerOnly {
+		err := s.zfsContainerSnapshotsLinear(source.Name())
+		if err != nil {
+			return err
+		}
+
+		snapshots, err := source.Snapshots()
+		if err != nil {
+			return err
+		}
+
+		err = os.MkdirAll(filepath.Join(path, "snapshots"), 0711)
+		if err != nil {
+			return err
+		}
+
+		for _, snap := range snapshots {
+			_, snapOnlyName, _ := containerGetParentAndSnapshotName(snap.Name())
+			dataset := fmt.Sprintf("%s/%s@snapshot-%s", poolName, fs, snapOnlyName)
+			args := []string{dataset}
+			if prev != "" {
+				args = append(args, "-i", prev)
+			}
+
+			err := zfsSendFile(args, filepath.Join(path, "snapshots", fmt.Sprintf("%s.bin", snapOnlyName)))
+			if err != nil {
+				return err
+			}
+
+			prev = dataset
+		}
+	}
+
+	// The current state is sent from a temporary snapshot.
+	tmpSnapshotName := fmt.Sprintf("backup-%s", uuid.NewRandom().String())
+	err := s.zfsPoolVolumeSnapshotCreate(fs, tmpSnapshotName)
+	if err != nil {
+		return err
+	}
+	defer s.zfsPoolVolumeSnapshotDestroy(fs, tmpSnapshotName)
+
+	args := []string{fmt.Sprintf("%s/%s@%s", poolName, fs, tmpSnapshotName)}
+	if prev != "" {
+		args = append(args, "-i", prev)
+	}
+
+	err = zfsSendFile(args, filepath.Join(path, "container.bin"))
+	if err != nil {
+		return err
+	}
+
+	logger.Debugf("Created optimized ZFS backup \"%s\" of container \"%s\" on storage pool \"%s\".", backup.Name, source.Name(), s.pool.Name)
+	return nil
+}
+
+// ContainerBackupLoad receives the streams of an optimized backup or copies
+// the snapshots of a tarball one after the other to a new dataset, taking a
+// ZFS snapshot after each of them.
+func (s *storageZfs) ContainerBackupLoad(info backupInfo, path string) error {
+	logger.Debugf("Loading ZFS storage volume for backup of container \"%s\" on storage pool \"%s\".", info.Name, s.pool.Name)
+
+	defer s.zfsDatasetCacheInvalidate()
+
+	if info.Optimized && info.Backend != "zfs" {
+		return fmt.Errorf("Optimized backups of %s storage can't be loaded into a ZFS storage pool", info.Backend)
+	}
+
+	poolName := s.getOnDiskPoolName()
+	fs := fmt.Sprintf("containers/%s", info.Name)
+	if s.zfsFilesystemEntityExists(fs, true) {
+		return fmt.Errorf("The ZFS dataset of container \"%s\" already exists", info.Name)
+	}
+
+	containerMntPoint := getContainerMountPoint(s.pool.Name, info.Name)
+	containerMntPointSymlink := shared.VarPath("containers", info.Name)
+	err := createContainerMountpoint(containerMntPoint, containerMntPointSymlink, info.Privileged)
+	if err != nil {
+		return err
+	}
+
+	snapshotMntPointSymlinkTarget := shared.VarPath("storage-pools", s.pool.Name, "snapshots", info.Name)
+	snapshotMntPointSymlink := shared.VarPath("snapshots", info.Name)
+
+	revert := true
+	defer func() {
+		if !revert {
+			return
+		}
+
+		if s.zfsFilesystemEntityExists(fs, true) {
+			s.zfsPoolVolumeDestroy(fs)
+		}
+
+		for _, snapName := range info.Snapshots {
+			snapshotMntPoint := getSnapshotMountPoint(s.pool.Name, fmt.Sprintf("%s/%s", info.Name, snapName))
+			deleteSnapshotMountpoint(snapshotMntPoint, snapshotMntPointSymlinkTarget, snapshotMntPointSymlink)
+		}
+
+		deleteContainerMountpoint(containerMntPoint, containerMntPointSymlink, s.GetStorageTypeName())
+	}()
+
+	for _, snapName := range info.Snapshots {
+		snapshotMntPoint := getSnapshotMountPoint(s.pool.Name, fmt.Sprintf("%s/%s", info.Name, snapName))
+		err := createSnapshotMountpoint(snapshotMntPoint, snapshotMntPointSymlinkTarget, snapshotMntPointSymlink)
+		if err != nil {
+			return err
+		}
+	}
+
+	if info.Optimized {
+		for _, snapName := range info.Snapshots {
+			dataset := fmt.Sprintf("%s/%s@snapshot-%s", poolName, fs, snapName)
+			err := zfsReceiveFile([]string{"-F", "-u", dataset}, filepath.Join(path, "snapshots", fmt.Sprintf("%s.bin", snapName)))
+			if err != nil {
+				return err
+			}
+		}
+
+		tmpSnapshotName := "backup-load"
+		dataset := fmt.Sprintf("%s/%s@%s", poolName, fs, tmpSnapshotName)
+		err := zfsReceiveFile([]string{"-F", "-u", dataset}, filepath.Join(path, "container.bin"))
+		if err != nil {
+			return err
+		}
+
+		err = s.zfsPoolVolumeSnapshotDestroy(fs, tmpSnapshotName)
+		if err != nil {
+			return err
+		}
 
-	oldSnapshotMntPoint := getSnapshotMountPoint(s.pool.Name, oldName)
-	if shared.PathExists(oldSnapshotMntPoint) {
-		err := os.Remove(oldSnapshotMntPoint)
+		err = s.zfsPoolVolumeSet(fs, "canmount", "noauto")
 		if err != nil {
 			return err
 		}
-	}
 
-	newSnapshotMntPoint := getSnapshotMountPoint(s.pool.Name, newName)
-	if !shared.PathExists(newSnapshotMntPoint) {
-		err := os.MkdirAll(newSnapshotMntPoint, 0700)
+		err = s.zfsPoolVolumeSet(fs, "mountpoint", containerMntPoint)
 		if err != nil {
 			return err
 		}
-	}
 
-	snapshotMntPointSymlinkTarget := shared.VarPath("storage-pools", s.pool.Name, "snapshots", oldcName)
-	snapshotMntPointSymlink := shared.VarPath("snapshots", oldcName)
-	if !shared.PathExists(snapshotMntPointSymlink) {
-		err := os.Symlink(snapshotMntPointSymlinkTarget, snapshotMntPointSymlink)
+		err = s.zfsPoolVolumeMount(fs)
 		if err != nil {
 			return err
 		}
-	}
-
-	revert = false
-
-	logger.Debugf("Renamed ZFS storage volume for snapshot \"%s\" from %s -> %s.", s.volume.Name, s.volume.Name, newName)
-	return nil
-}
-
-func (s *storageZfs) ContainerSnapshotStart(container container) (bool, error) {
-	logger.Debugf("Initializing ZFS storage volume for snapshot \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
-
-	cName, sName, _ := containerGetParentAndSnapshotName(container.Name())
-	sourceFs := fmt.Sprintf("containers/%s", cName)
-	sourceSnap := fmt.Sprintf("snapshot-%s", sName)
-	destFs := fmt.Sprintf("snapshots/%s/%s", cName, sName)
-
-	snapshotMntPoint := getSnapshotMountPoint(s.pool.Name, container.Name())
-	err := s.zfsPoolVolumeClone(sourceFs, sourceSnap, destFs, snapshotMntPoint)
-	if err != nil {
-		return false, err
-	}
+	} else {
+		dataset := fmt.Sprintf("%s/%s", poolName, fs)
+		msg, err := zfsPoolVolumeCreate(dataset, "mountpoint=none", "canmount=noauto")
+		if err != nil {
+			logger.Errorf("failed to create ZFS storage volume for container \"%s\" on storage pool \"%s\": %s", info.Name, s.pool.Name, msg)
+			return err
+		}
 
-	err = s.zfsPoolVolumeMount(destFs)
-	if err != nil {
-		return false, err
-	}
+		err = s.zfsPoolVolumeSet(fs, "mountpoint", containerMntPoint)
+		if err != nil {
+			return err
+		}
 
-	logger.Debugf("Initialized ZFS storage volume for snapshot \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
-	return true, nil
-}
+		err = s.zfsPoolVolumeMount(fs)
+		if err != nil {
+			return err
+		}
 
-func (s *storageZfs) ContainerSnapshotStop(container container) (bool, error) {
-	logger.Debugf("Stopping ZFS storage volume for snapshot \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
+		for _, snapName := range info.Snapshots {
+			output, err := rsyncLocalCopy(filepath.Join(path, "snapshots", snapName), containerMntPoint, "")
+			if err != nil {
+				return fmt.Errorf("Failed to restore snapshot \"%s\": %s", snapName, output)
+			}
 
-	cName, sName, _ := containerGetParentAndSnapshotName(container.Name())
-	destFs := fmt.Sprintf("snapshots/%s/%s", cName, sName)
+			err = s.zfsPoolVolumeSnapshotCreate(fs, fmt.Sprintf("snapshot-%s", snapName))
+			if err != nil {
+				return err
+			}
+		}
 
-	err := s.zfsPoolVolumeDestroy(destFs)
-	if err != nil {
-		return false, err
+		output, err := rsyncLocalCopy(filepath.Join(path, "container"), containerMntPoint, "")
+		if err != nil {
+			return fmt.Errorf("Failed to restore the container: %s", output)
+		}
 	}
 
-	logger.Debugf("Stopped ZFS storage volume for snapshot \"%s\" on storage pool \"%s\".", s.volume.Name, s.pool.Name)
-	return true, nil
-}
+	revert = false
 
-func (s *storageZfs) ContainerSnapshotCreateEmpty(snapshotContainer container) error {
-	/* don't touch the fs yet, as migration will do that for us */
+	logger.Debugf("Loaded ZFS storage volume for backup of container \"%s\" on storage pool \"%s\".", info.Name, s.pool.Name)
 	return nil
 }
 
@@ -1619,6 +3027,43 @@ func (s *storageZfs) ImageCreate(fingerprint string) error {
 		return nil
 	}
 
+	// Receive the stream which came with the image rather than unpacking
+	// it, falling back to unpacking it on failure.
+	if shared.PathExists(zfsImageStreamPath(fingerprint)) {
+		err := s.zfsImageCreateFromStream(fingerprint)
+		if err == nil {
+			revert = false
+			subrevert = false
+
+			logger.Debugf("Created ZFS storage volume for image \"%s\" on storage pool \"%s\" from its stream.", fingerprint, s.pool.Name)
+			return nil
+		}
+
+		logger.Warnf("Failed to receive the stream of image \"%s\", unpacking it instead: %s.", fingerprint, err)
+		if s.zfsFilesystemEntityExists(fs, true) {
+			s.zfsPoolVolumeDestroy(fs)
+		}
+	}
+
+	// Copy the image from the shared images pool rather than unpacking it
+	// again, falling back to unpacking it on failure.
+	imagesPool := zfsImagesPoolGet()
+	if imagesPool != "" && imagesPool != s.pool.Name {
+		err := s.zfsImageCreateFromImagesPool(imagesPool, fingerprint)
+		if err == nil {
+			revert = false
+			subrevert = false
+
+			logger.Debugf("Created ZFS storage volume for image \"%s\" on storage pool \"%s\" from shared images pool \"%s\".", fingerprint, s.pool.Name, imagesPool)
+			return nil
+		}
+
+		logger.Warnf("Failed to copy image \"%s\" from shared images pool \"%s\", unpacking it instead: %s.", fingerprint, imagesPool, err)
+		if s.zfsFilesystemEntityExists(fs, true) {
+			s.zfsPoolVolumeDestroy(fs)
+		}
+	}
+
 	if !shared.PathExists(imageMntPoint) {
 		err := os.MkdirAll(imageMntPoint, 0700)
 		if err != nil {
@@ -1675,6 +3120,12 @@ func (s *storageZfs) ImageCreate(fingerprint string) error {
 		return err
 	}
 
+	// Apply the pool's default tuning to the image.
+	err = s.zfsPoolVolumeTuningApply(fs, map[string]string{}, false)
+	if err != nil {
+		return err
+	}
+
 	// Mark the new storage volume for the image as readonly.
 	err = s.zfsPoolVolumeSet(fs, "readonly", "on")
 	if err != nil {
@@ -1701,6 +3152,14 @@ func (s *storageZfs) ImageCreate(fingerprint string) error {
 
 	revert = false
 
+	// The stream is only an optimization, the image can do without it.
+	if zfsImageStreamsEnabled() && !shared.PathExists(zfsImageStreamPath(fingerprint)) {
+		err := s.zfsImageStreamCreate(fingerprint)
+		if err != nil {
+			logger.Warnf("Failed to generate the ZFS stream of image \"%s\": %s.", fingerprint, err)
+		}
+	}
+
 	logger.Debugf("Created ZFS storage volume for image \"%s\" on storage pool \"%s\".", fingerprint, s.pool.Name)
 	return nil
 }
@@ -1773,6 +3232,24 @@ type zfsMigrationSourceDriver struct {
 	zfs              *storageZfs
 	runningSnapName  string
 	stoppedSnapName  string
+
+	// Dataset the streams are sent from, relative to the pool. A snapshot
+	// may live in a dataset left behind by a restore.
+	dataset string
+
+	// Bandwidth limit of the sends in bytes per second.
+	bwlimit int64
+
+	// Compression of the sends, if negotiated with the sink.
+	compression      string
+	compressionLevel int
+
+	// Whether the sink verifies the checksums of the streams.
+	checksum bool
+
+	// Snapshot the sink already has when refreshing a copy of the
+	// container, the streams starting from it.
+	base string
 }
 
 func (s *zfsMigrationSourceDriver) Snapshots() []container {
@@ -1780,11 +3257,36 @@ func (s *zfsMigrationSourceDriver) Snapshots() []container {
 }
 
 func (s *zfsMigrationSourceDriver) send(conn *websocket.Conn, zfsName string, zfsParent string, readWrapper func(io.ReadCloser) io.ReadCloser) error {
-	sourceParentName, _, _ := containerGetParentAndSnapshotName(s.container.Name())
+	if !s.checksum {
+		return s.sendStream(conn, zfsName, zfsParent, readWrapper, nil)
+	}
+
+	// Send the stream again for as long as the sink received it corrupted.
+	for {
+		checksummer := newMigrationChecksummer()
+		err := s.sendStream(conn, zfsName, zfsParent, readWrapper, checksummer)
+		if err != nil {
+			return err
+		}
+
+		retry, err := migrationChecksumSend(conn, checksummer.Checksums())
+		if err != nil {
+			return err
+		}
+
+		if !retry {
+			return nil
+		}
+
+		logger.Warnf("The stream of %s was corrupted in transit, sending it again.", zfsName)
+	}
+}
+
+func (s *zfsMigrationSourceDriver) sendStream(conn *websocket.Conn, zfsName string, zfsParent string, readWrapper func(io.ReadCloser) io.ReadCloser, checksummer *migrationChecksummer) error {
 	poolName := s.zfs.getOnDiskPoolName()
-	args := []string{"send", fmt.Sprintf("%s/containers/%s@%s", poolName, sourceParentName, zfsName)}
+	args := []string{"send", fmt.Sprintf("%s/%s@%s", poolName, s.dataset, zfsName)}
 	if zfsParent != "" {
-		args = append(args, "-i", fmt.Sprintf("%s/containers/%s@%s", poolName, s.container.Name(), zfsParent))
+		args = append(args, "-i", fmt.Sprintf("%s/%s@%s", poolName, s.dataset, zfsParent))
 	}
 
 	cmd := exec.Command("zfs", args...)
@@ -1794,11 +3296,6 @@ func (s *zfsMigrationSourceDriver) send(conn *websocket.Conn, zfsName string, zf
 		return err
 	}
 
-	readPipe := io.ReadCloser(stdout)
-	if readWrapper != nil {
-		readPipe = readWrapper(stdout)
-	}
-
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
 		return err
@@ -1808,8 +3305,36 @@ func (s *zfsMigrationSourceDriver) send(conn *websocket.Conn, zfsName string, zf
 		return err
 	}
 
+	// The stream is compressed before being throttled so that the limit
+	// applies to what's actually sent.
+	stream := io.ReadCloser(stdout)
+	if s.compression != "" {
+		stream, err = migrationCompressReader(stdout, s.compression, s.compressionLevel)
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return err
+		}
+	}
+
+	// The checksums are computed on what's actually sent.
+	readPipe := stream
+	if checksummer != nil {
+		readPipe = migrationChecksumReader(readPipe, checksummer)
+	}
+
+	readPipe = storageRateLimitReader(readPipe, s.bwlimit)
+	if readWrapper != nil {
+		readPipe = readWrapper(readPipe)
+	}
+
 	<-shared.WebsocketSendStream(conn, readPipe, 4*1024*1024)
 
+	var compressErr error
+	if s.compression != "" {
+		compressErr = stream.Close()
+	}
+
 	output, err := ioutil.ReadAll(stderr)
 	if err != nil {
 		logger.Errorf("Problem reading zfs send stderr: %s.", err)
@@ -1818,12 +3343,31 @@ func (s *zfsMigrationSourceDriver) send(conn *websocket.Conn, zfsName string, zf
 	err = cmd.Wait()
 	if err != nil {
 		logger.Errorf("Problem with zfs send: %s.", string(output))
+		return commandErrorNew(cmd, output, err)
 	}
 
-	return err
+	return compressErr
+}
+
+// SetCompression compresses the streams with the algorithm negotiated with
+// the sink.
+func (s *zfsMigrationSourceDriver) SetCompression(algorithm string, level int) {
+	s.compression = algorithm
+	s.compressionLevel = level
+}
+
+// SetChecksum sends the checksums of the streams for the sink to verify.
+func (s *zfsMigrationSourceDriver) SetChecksum(checksum bool) {
+	s.checksum = checksum
 }
 
 func (s *zfsMigrationSourceDriver) SendWhileRunning(conn *websocket.Conn, op *operation, bwlimit string, containerOnly bool) error {
+	var err error
+	s.bwlimit, err = storageBwlimitParse(bwlimit)
+	if err != nil {
+		return err
+	}
+
 	if s.container.IsSnapshot() {
 		_, snapOnlyName, _ := containerGetParentAndSnapshotName(s.container.Name())
 		snapshotName := fmt.Sprintf("snapshot-%s", snapOnlyName)
@@ -1831,10 +3375,10 @@ func (s *zfsMigrationSourceDriver) SendWhileRunning(conn *websocket.Conn, op *op
 		return s.send(conn, snapshotName, "", wrapper)
 	}
 
-	lastSnap := ""
+	lastSnap := s.base
 	if !containerOnly {
 		for i, snap := range s.zfsSnapshotNames {
-			prev := ""
+			prev := s.base
 			if i > 0 {
 				prev = s.zfsSnapshotNames[i-1]
 			}
@@ -1849,7 +3393,7 @@ func (s *zfsMigrationSourceDriver) SendWhileRunning(conn *websocket.Conn, op *op
 	}
 
 	s.runningSnapName = fmt.Sprintf("migration-send-%s", uuid.NewRandom().String())
-	if err := s.zfs.zfsPoolVolumeSnapshotCreate(fmt.Sprintf("containers/%s", s.container.Name()), s.runningSnapName); err != nil {
+	if err := s.zfs.zfsPoolVolumeSnapshotCreate(s.dataset, s.runningSnapName); err != nil {
 		return err
 	}
 
@@ -1861,9 +3405,61 @@ func (s *zfsMigrationSourceDriver) SendWhileRunning(conn *websocket.Conn, op *op
 	return nil
 }
 
+// SendIncremental sends what changed since the last send, replacing the
+// snapshot the final one is sent from.
+func (s *zfsMigrationSourceDriver) SendIncremental(conn *websocket.Conn) error {
+	snapName := fmt.Sprintf("migration-send-%s", uuid.NewRandom().String())
+	if err := s.zfs.zfsPoolVolumeSnapshotCreate(s.dataset, snapName); err != nil {
+		return err
+	}
+
+	if err := s.send(conn, snapName, s.runningSnapName, nil); err != nil {
+		s.zfs.zfsPoolVolumeSnapshotDestroy(s.dataset, snapName)
+		return err
+	}
+
+	s.zfs.zfsPoolVolumeSnapshotDestroy(s.dataset, s.runningSnapName)
+	s.runningSnapName = snapName
+
+	return nil
+}
+
+// SetRefreshBase only sends the snapshots made after base and what changed
+// since, the sink's copy of base having to be the very same as ours.
+func (s *zfsMigrationSourceDriver) SetRefreshBase(base string, guid string) error {
+	zfsBase := fmt.Sprintf("snapshot-%s", base)
+	for i, snap := range s.zfsSnapshotNames {
+		if snap != zfsBase {
+			continue
+		}
+
+		ourGUID, err := s.zfs.zfsFilesystemEntityPropertyGet(fmt.Sprintf("containers/%s@%s", s.container.Name(), snap), "guid", true)
+		if err != nil {
+			return err
+		}
+
+		if ourGUID != guid {
+			return fmt.Errorf("The target's snapshot \"%s\" isn't a copy of the source's, the container must be copied again", base)
+		}
+
+		s.base = snap
+		s.snapshots = s.snapshots[i+1:]
+		s.zfsSnapshotNames = s.zfsSnapshotNames[i+1:]
+		return nil
+	}
+
+	return fmt.Errorf("The source doesn't have the snapshot \"%s\" anymore", base)
+}
+
 func (s *zfsMigrationSourceDriver) SendAfterCheckpoint(conn *websocket.Conn, bwlimit string) error {
+	var err error
+	s.bwlimit, err = storageBwlimitParse(bwlimit)
+	if err != nil {
+		return err
+	}
+
 	s.stoppedSnapName = fmt.Sprintf("migration-send-%s", uuid.NewRandom().String())
-	if err := s.zfs.zfsPoolVolumeSnapshotCreate(fmt.Sprintf("containers/%s", s.container.Name()), s.stoppedSnapName); err != nil {
+	if err := s.zfs.zfsPoolVolumeSnapshotCreate(s.dataset, s.stoppedSnapName); err != nil {
 		return err
 	}
 
@@ -1876,11 +3472,11 @@ func (s *zfsMigrationSourceDriver) SendAfterCheckpoint(conn *websocket.Conn, bwl
 
 func (s *zfsMigrationSourceDriver) Cleanup() {
 	if s.stoppedSnapName != "" {
-		s.zfs.zfsPoolVolumeSnapshotDestroy(fmt.Sprintf("containers/%s", s.container.Name()), s.stoppedSnapName)
+		s.zfs.zfsPoolVolumeSnapshotDestroy(s.dataset, s.stoppedSnapName)
 	}
 
 	if s.runningSnapName != "" {
-		s.zfs.zfsPoolVolumeSnapshotDestroy(fmt.Sprintf("containers/%s", s.container.Name()), s.runningSnapName)
+		s.zfs.zfsPoolVolumeSnapshotDestroy(s.dataset, s.runningSnapName)
 	}
 }
 
@@ -1897,7 +3493,13 @@ func (s *storageZfs) MigrationSource(ct container, containerOnly bool) (Migratio
 	* to send anything else, because that's all the user asked for.
 	 */
 	if ct.IsSnapshot() {
-		return &zfsMigrationSourceDriver{container: ct, zfs: s}, nil
+		parentName, snapOnlyName, _ := containerGetParentAndSnapshotName(ct.Name())
+		dataset, err := s.zfsContainerSnapshotDataset(parentName, snapOnlyName)
+		if err != nil {
+			return nil, err
+		}
+
+		return &zfsMigrationSourceDriver{container: ct, zfs: s, dataset: dataset}, nil
 	}
 
 	driver := zfsMigrationSourceDriver{
@@ -1905,17 +3507,25 @@ func (s *storageZfs) MigrationSource(ct container, containerOnly bool) (Migratio
 		snapshots:        []container{},
 		zfsSnapshotNames: []string{},
 		zfs:              s,
+		dataset:          fmt.Sprintf("containers/%s", ct.Name()),
 	}
 
 	if containerOnly {
 		return &driver, nil
 	}
 
+	// The snapshots are sent incrementally, which only works if all of
+	// them are in the container's own dataset.
+	err := s.zfsContainerSnapshotsLinear(ct.Name())
+	if err != nil {
+		return nil, err
+	}
+
 	/* List all the snapshots in order of reverse creation. The idea here
 	* is that we send the oldest to newest snapshot, hopefully saving on
 	* xfer costs. Then, after all that, we send the container itself.
 	 */
-	snapshots, err := s.zfsPoolListSnapshots(fmt.Sprintf("containers/%s", ct.Name()))
+	snapshots, err := s.zfsPoolListSnapshots(driver.dataset)
 	if err != nil {
 		return nil, err
 	}
@@ -1943,12 +3553,16 @@ func (s *storageZfs) MigrationSource(ct container, containerOnly bool) (Migratio
 	return &driver, nil
 }
 
-func (s *storageZfs) MigrationSink(live bool, container container, snapshots []*Snapshot, conn *websocket.Conn, srcIdmap *shared.IdmapSet, op *operation, containerOnly bool) error {
+func (s *storageZfs) MigrationSink(conn *websocket.Conn, op *operation, args MigrationSinkArgs) error {
 	poolName := s.getOnDiskPoolName()
-	zfsRecv := func(zfsName string, writeWrapper func(io.WriteCloser) io.WriteCloser) error {
+
+	// zfsReceive runs "zfs receive" with the stream written by feed.
+	zfsReceive := func(zfsName string, feed func(stream io.WriteCloser) error) error {
 		zfsFsName := fmt.Sprintf("%s/%s", poolName, zfsName)
-		args := []string{"receive", "-F", "-u", zfsFsName}
-		cmd := exec.Command("zfs", args...)
+		defer zfsDatasetCacheInvalidate(zfsFsName)
+
+		recvArgs := []string{"receive", "-F", "-u", zfsFsName}
+		cmd := exec.Command("zfs", recvArgs...)
 
 		stdin, err := cmd.StdinPipe()
 		if err != nil {
@@ -1964,12 +3578,26 @@ func (s *storageZfs) MigrationSink(live bool, container container, snapshots []*
 			return err
 		}
 
-		writePipe := io.WriteCloser(stdin)
-		if writeWrapper != nil {
-			writePipe = writeWrapper(stdin)
+		stream := io.WriteCloser(stdin)
+		if args.Compression != "" {
+			stream, err = migrationDecompressWriter(stdin, args.Compression)
+			if err != nil {
+				stdin.Close()
+				cmd.Wait()
+				return err
+			}
+		}
+
+		feedErr := feed(stream)
+
+		var decompressErr error
+		if args.Compression != "" {
+			decompressErr = stream.Close()
 		}
 
-		<-shared.WebsocketRecvStream(writePipe, conn)
+		// Let zfs know that nothing else is coming in case the
+		// stream stopped early.
+		stdin.Close()
 
 		output, err := ioutil.ReadAll(stderr)
 		if err != nil {
@@ -1979,8 +3607,78 @@ func (s *storageZfs) MigrationSink(live bool, container container, snapshots []*
 		err = cmd.Wait()
 		if err != nil {
 			logger.Errorf("problem with zfs recv: %s.", string(output))
+			return commandErrorNew(cmd, output, err)
+		}
+
+		if feedErr != nil {
+			return feedErr
+		}
+
+		return decompressErr
+	}
+
+	zfsRecv := func(zfsName string, writeWrapper func(io.WriteCloser) io.WriteCloser) error {
+		if !args.Checksum {
+			return zfsReceive(zfsName, func(stream io.WriteCloser) error {
+				writePipe := stream
+				if writeWrapper != nil {
+					writePipe = writeWrapper(stream)
+				}
+
+				<-shared.WebsocketRecvStream(writePipe, conn)
+				return nil
+			})
+		}
+
+		/* The stream is kept on disk until its checksums are verified
+		 * so that nothing gets received from a corrupted stream.
+		 */
+		recvVerified := func(attempt int) (bool, error) {
+			f, err := ioutil.TempFile(shared.VarPath("storage-pools", s.pool.Name), "migration_")
+			if err != nil {
+				return false, err
+			}
+			defer os.Remove(f.Name())
+			defer f.Close()
+
+			checksummer := newMigrationChecksummer()
+			writePipe := migrationChecksumWriter(f, checksummer)
+			if writeWrapper != nil {
+				writePipe = writeWrapper(writePipe)
+			}
+
+			<-shared.WebsocketRecvStream(writePipe, conn)
+
+			intact, err := migrationChecksumVerify(conn, checksummer.Checksums(), attempt)
+			if err != nil || !intact {
+				return false, err
+			}
+
+			_, err = f.Seek(0, 0)
+			if err != nil {
+				return false, err
+			}
+
+			return true, zfsReceive(zfsName, func(stream io.WriteCloser) error {
+				_, err := io.Copy(stream, f)
+				return err
+			})
+		}
+
+		for attempt := 1; ; attempt++ {
+			intact, err := recvVerified(attempt)
+			if err != nil {
+				return err
+			}
+
+			if intact {
+				return nil
+			}
+
+			if attempt >= migrationChecksumAttempts {
+				return fmt.Errorf("Failed to receive an intact stream for %s after %d attempts", zfsName, attempt)
+			}
 		}
-		return err
 	}
 
 	/* In some versions of zfs we can write `zfs recv -F` to mounted
@@ -1989,8 +3687,8 @@ func (s *storageZfs) MigrationSink(live bool, container container, snapshots []*
 	 * of a snapshot also needs tha actual fs that it has snapshotted
 	 * unmounted, so we do this before receiving anything.
 	 */
-	zfsName := fmt.Sprintf("containers/%s", container.Name())
-	containerMntPoint := getContainerMountPoint(s.pool.Name, container.Name())
+	zfsName := fmt.Sprintf("containers/%s", args.Container.Name())
+	containerMntPoint := getContainerMountPoint(s.pool.Name, args.Container.Name())
 	if shared.IsMountPoint(containerMntPoint) {
 		err := s.zfsPoolVolumeUmount(zfsName, containerMntPoint)
 		if err != nil {
@@ -1998,14 +3696,29 @@ func (s *storageZfs) MigrationSink(live bool, container container, snapshots []*
 		}
 	}
 
-	if len(snapshots) > 0 {
+	// Undo whatever got received if the migration fails halfway, newest
+	// first, so that it can be retried.
+	revert := true
+	undo := []func(){}
+	defer func() {
+		if !revert {
+			return
+		}
+
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}()
+
+	if len(args.Snapshots) > 0 {
 		snapshotMntPointSymlinkTarget := shared.VarPath("storage-pools", s.pool.Name, "snapshots", s.volume.Name)
-		snapshotMntPointSymlink := shared.VarPath("snapshots", container.Name())
+		snapshotMntPointSymlink := shared.VarPath("snapshots", args.Container.Name())
 		if !shared.PathExists(snapshotMntPointSymlink) {
 			err := os.Symlink(snapshotMntPointSymlinkTarget, snapshotMntPointSymlink)
 			if err != nil {
 				return err
 			}
+			undo = append(undo, func() { os.Remove(snapshotMntPointSymlink) })
 		}
 	}
 
@@ -2013,7 +3726,7 @@ func (s *storageZfs) MigrationSink(live bool, container container, snapshots []*
 	// container's root disk device so we can simply
 	// retrieve it from the expanded devices.
 	parentStoragePool := ""
-	parentExpandedDevices := container.ExpandedDevices()
+	parentExpandedDevices := args.Container.ExpandedDevices()
 	parentLocalRootDiskDeviceKey, parentLocalRootDiskDevice, _ := containerGetRootDiskDevice(parentExpandedDevices)
 	if parentLocalRootDiskDeviceKey != "" {
 		parentStoragePool = parentLocalRootDiskDevice["pool"]
@@ -2024,32 +3737,33 @@ func (s *storageZfs) MigrationSink(live bool, container container, snapshots []*
 		return fmt.Errorf("detected that the container's root device is missing the pool property during BTRFS migration")
 	}
 
-	for _, snap := range snapshots {
-		args := snapshotProtobufToContainerArgs(container.Name(), snap)
+	for _, snap := range args.Snapshots {
+		snapArgs := snapshotProtobufToContainerArgs(args.Container.Name(), snap)
 
 		// Ensure that snapshot and parent container have the
 		// same storage pool in their local root disk device.
 		// If the root disk device for the snapshot comes from a
 		// profile on the new instance as well we don't need to
 		// do anything.
-		if args.Devices != nil {
-			snapLocalRootDiskDeviceKey, _, _ := containerGetRootDiskDevice(args.Devices)
+		if snapArgs.Devices != nil {
+			snapLocalRootDiskDeviceKey, _, _ := containerGetRootDiskDevice(snapArgs.Devices)
 			if snapLocalRootDiskDeviceKey != "" {
-				args.Devices[snapLocalRootDiskDeviceKey]["pool"] = parentStoragePool
+				snapArgs.Devices[snapLocalRootDiskDeviceKey]["pool"] = parentStoragePool
 			}
 		}
-		_, err := containerCreateEmptySnapshot(container.Daemon(), args)
+		cs, err := containerCreateEmptySnapshot(args.Container.Daemon(), snapArgs)
 		if err != nil {
 			return err
 		}
+		undo = append(undo, migrationSinkSnapshotDelete(cs))
 
 		wrapper := StorageProgressWriter(op, "fs_progress", snap.GetName())
-		name := fmt.Sprintf("containers/%s@snapshot-%s", container.Name(), snap.GetName())
+		name := fmt.Sprintf("containers/%s@snapshot-%s", args.Container.Name(), snap.GetName())
 		if err := zfsRecv(name, wrapper); err != nil {
 			return err
 		}
 
-		snapshotMntPoint := getSnapshotMountPoint(poolName, fmt.Sprintf("%s/%s", container.Name(), *snap.Name))
+		snapshotMntPoint := getSnapshotMountPoint(poolName, fmt.Sprintf("%s/%s", args.Container.Name(), *snap.Name))
 		if !shared.PathExists(snapshotMntPoint) {
 			err := os.MkdirAll(snapshotMntPoint, 0700)
 			if err != nil {
@@ -2060,33 +3774,64 @@ func (s *storageZfs) MigrationSink(live bool, container container, snapshots []*
 
 	defer func() {
 		/* clean up our migration-send snapshots that we got from recv. */
-		zfsSnapshots, err := s.zfsPoolListSnapshots(fmt.Sprintf("containers/%s", container.Name()))
+		zfsSnapshots, err := s.zfsPoolListSnapshots(fmt.Sprintf("containers/%s", args.Container.Name()))
+		if err != nil {
+			logger.Errorf("failed listing snapshots post migration: %s.", err)
+			return
+		}
+
+		// Keep the snapshots of the container, those received as well as
+		// those a refreshed copy already had, and wipe any other.
+		keep := []string{}
+		lxdSnapshots, err := args.Container.Snapshots()
 		if err != nil {
 			logger.Errorf("failed listing snapshots post migration: %s.", err)
 			return
 		}
 
+		for _, snap := range lxdSnapshots {
+			_, snapOnlyName, _ := containerGetParentAndSnapshotName(snap.Name())
+			keep = append(keep, fmt.Sprintf("snapshot-%s", snapOnlyName))
+		}
+
 		for _, snap := range zfsSnapshots {
-			// If we received a bunch of snapshots, remove the migration-send-* ones, if not, wipe any snapshot we got
-			if snapshots != nil && len(snapshots) > 0 && !strings.HasPrefix(snap, "migration-send") {
+			if shared.StringInSlice(snap, keep) {
 				continue
 			}
 
-			s.zfsPoolVolumeSnapshotDestroy(fmt.Sprintf("containers/%s", container.Name()), snap)
+			s.zfsPoolVolumeSnapshotDestroy(fmt.Sprintf("containers/%s", args.Container.Name()), snap)
 		}
 	}()
 
 	/* finally, do the real container */
-	wrapper := StorageProgressWriter(op, "fs_progress", container.Name())
+	wrapper := StorageProgressWriter(op, "fs_progress", args.Container.Name())
 	if err := zfsRecv(zfsName, wrapper); err != nil {
 		return err
 	}
 
-	if live {
-		/* and again for the post-running snapshot if this was a live migration */
-		wrapper := StorageProgressWriter(op, "fs_progress", container.Name())
-		if err := zfsRecv(zfsName, wrapper); err != nil {
-			return err
+	if args.Live {
+		/* and again for the post-running snapshot if this was a live
+		 * migration, after the ones sent between the pre-dumps of a
+		 * pre-copy.
+		 */
+		for {
+			final := true
+			if args.PreCopy {
+				var err error
+				final, err = migrationRecvSync(conn)
+				if err != nil {
+					return err
+				}
+			}
+
+			wrapper := StorageProgressWriter(op, "fs_progress", args.Container.Name())
+			if err := zfsRecv(zfsName, wrapper); err != nil {
+				return err
+			}
+
+			if final {
+				break
+			}
 		}
 	}
 
@@ -2096,5 +3841,17 @@ func (s *storageZfs) MigrationSink(live bool, container container, snapshots []*
 	 * failure.
 	 */
 	s.zfsPoolVolumeMount(zfsName)
+
+	// zfs send doesn't carry properties, so apply the local defaults and
+	// then the properties the source had set on the dataset.
+	err := s.zfsPoolVolumeDefaultsApply(zfsName, s.volume.Config)
+	if err != nil {
+		return err
+	}
+
+	s.zfsPoolVolumePropertiesApply(zfsName, args.Properties)
+
+	revert = false
+
 	return nil
 }
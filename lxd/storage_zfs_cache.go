@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/logger"
+)
+
+// zfsDatasetCacheTTL is how long a dataset listing is trusted. Changes made
+// by LXD itself invalidate the cache right away, the TTL only bounds how long
+// changes made behind LXD's back can go unnoticed.
+const zfsDatasetCacheTTL = 5 * time.Second
+
+type zfsDatasetCacheEntry struct {
+	loadedAt time.Time
+
+	// All filesystems, volumes and snapshots below the root.
+	datasets map[string]bool
+
+	// Snapshot names of each dataset, oldest first.
+	snapshots map[string][]string
+}
+
+var zfsDatasetCacheLock sync.Mutex
+var zfsDatasetCache = map[string]*zfsDatasetCacheEntry{}
+
+func zfsDatasetCacheParse(output string) *zfsDatasetCacheEntry {
+	entry := &zfsDatasetCacheEntry{
+		loadedAt:  time.Now(),
+		datasets:  map[string]bool{},
+		snapshots: map[string][]string{},
+	}
+
+	for _, name := range strings.Split(output, "\n") {
+		if name == "" {
+			continue
+		}
+
+		entry.datasets[name] = true
+
+		fields := strings.SplitN(name, "@", 2)
+		if len(fields) == 2 {
+			entry.snapshots[fields[0]] = append(entry.snapshots[fields[0]], fields[1])
+		}
+	}
+
+	return entry
+}
+
+// zfsDatasetCacheGet returns the cached listing of the given root dataset,
+// refreshing it if it's missing or expired.
+func zfsDatasetCacheGet(root string) (*zfsDatasetCacheEntry, error) {
+	zfsDatasetCacheLock.Lock()
+	defer zfsDatasetCacheLock.Unlock()
+
+	entry, ok := zfsDatasetCache[root]
+	if ok && time.Since(entry.loadedAt) < zfsDatasetCacheTTL {
+		return entry, nil
+	}
+
+	output, err := shared.RunCommand(
+		"zfs",
+		"list",
+		"-t", "filesystem,volume,snapshot",
+		"-o", "name",
+		"-H",
+		"-s", "creation",
+		"-r", root)
+	if err != nil {
+		delete(zfsDatasetCache, root)
+		logger.Debugf("zfs list failed: %s.", output)
+		return nil, fmt.Errorf("Failed to list ZFS datasets: %s", output)
+	}
+
+	entry = zfsDatasetCacheParse(output)
+	zfsDatasetCache[root] = entry
+
+	return entry, nil
+}
+
+// zfsDatasetCacheInvalidate drops the cached listings which contain the given
+// dataset or snapshot. An empty dataset drops all cached listings.
+func zfsDatasetCacheInvalidate(dataset string) {
+	dataset = strings.SplitN(dataset, "@", 2)[0]
+
+	zfsDatasetCacheLock.Lock()
+	defer zfsDatasetCacheLock.Unlock()
+
+	for root := range zfsDatasetCache {
+		if dataset == "" || root == dataset || strings.HasPrefix(dataset, root+"/") || strings.HasPrefix(root, dataset+"/") {
+			delete(zfsDatasetCache, root)
+		}
+	}
+}
+
+func (s *storageZfs) zfsDatasetCacheEnabled() bool {
+	value := s.pool.Config["zfs.dataset_cache"]
+	return value == "" || shared.IsTrue(value)
+}
+
+// zfsDatasetCacheLookup returns the cached listing of the pool if caching is
+// enabled and the dataset is below the pool's root dataset.
+func (s *storageZfs) zfsDatasetCacheLookup(dataset string) *zfsDatasetCacheEntry {
+	if !s.zfsDatasetCacheEnabled() {
+		return nil
+	}
+
+	root := s.getOnDiskPoolName()
+	if dataset != root && !strings.HasPrefix(dataset, root+"/") && !strings.HasPrefix(dataset, root+"@") {
+		return nil
+	}
+
+	entry, err := zfsDatasetCacheGet(root)
+	if err != nil {
+		return nil
+	}
+
+	return entry
+}
+
+func (s *storageZfs) zfsDatasetCacheInvalidate() {
+	zfsDatasetCacheInvalidate(s.getOnDiskPoolName())
+}
+
+// zfsDatasetCacheDescendants returns the dataset and its cached descendants,
+// excluding snapshots. It returns false if no cached listing is available.
+func (s *storageZfs) zfsDatasetCacheDescendants(dataset string) ([]string, bool) {
+	entry := s.zfsDatasetCacheLookup(dataset)
+	if entry == nil || !entry.datasets[dataset] {
+		return nil, false
+	}
+
+	datasets := []string{}
+	for name := range entry.datasets {
+		if strings.Contains(name, "@") {
+			continue
+		}
+
+		if name == dataset || strings.HasPrefix(name, dataset+"/") {
+			datasets = append(datasets, name)
+		}
+	}
+
+	return datasets, true
+}
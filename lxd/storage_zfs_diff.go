@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// The file monitor wakes up every zfsFileMonitorTick and checks the
+// containers which are due according to "security.file_monitor.interval".
+const zfsFileMonitorTick = 10 * time.Second
+const zfsFileMonitorDefaultInterval = 300 * time.Second
+
+// Maximum number of changes sent in a single event.
+const zfsFileMonitorMaxChanges = 1000
+
+// Prefix of the rolling snapshots taken by the file monitor.
+const zfsFileMonitorSnapshotPrefix = "file-monitor-"
+
+type zfsFileMonitorState struct {
+	snapshot string
+	lastRun  time.Time
+}
+
+var zfsFileMonitorLock sync.Mutex
+var zfsFileMonitorStates = map[string]*zfsFileMonitorState{}
+
+var zfsDiffChanges = map[string]string{
+	"-": "removed",
+	"+": "created",
+	"M": "modified",
+	"R": "renamed",
+}
+
+var zfsDiffFileTypes = map[string]string{
+	"F": "file",
+	"/": "directory",
+	"@": "symlink",
+	"B": "block",
+	"C": "char",
+	"P": "fifo",
+	"=": "socket",
+	">": "door",
+}
+
+// zfsParseDiff parses the output of "zfs diff -H -F", making the paths
+// relative to the given mount point.
+func zfsParseDiff(output string, mountpoint string) []api.ContainerFileChange {
+	changes := []api.ContainerFileChange{}
+
+	relative := func(path string) string {
+		return strings.TrimPrefix(strings.TrimPrefix(path, mountpoint), "/")
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 3 {
+			continue
+		}
+
+		change, ok := zfsDiffChanges[fields[0]]
+		if !ok {
+			continue
+		}
+
+		fileType, ok := zfsDiffFileTypes[fields[1]]
+		if !ok {
+			fileType = fields[1]
+		}
+
+		entry := api.ContainerFileChange{
+			Change:   change,
+			FileType: fileType,
+			Path:     relative(fields[2]),
+		}
+
+		if change == "renamed" && len(fields) > 3 {
+			entry.NewPath = relative(fields[3])
+		}
+
+		changes = append(changes, entry)
+	}
+
+	return changes
+}
+
+func zfsFileMonitorInterval(c container) time.Duration {
+	value := c.ExpandedConfig()["security.file_monitor.interval"]
+	if value == "" {
+		return zfsFileMonitorDefaultInterval
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return zfsFileMonitorDefaultInterval
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// zfsContainerDataset returns the ZFS dataset and mount point of the
+// container if it's on a ZFS storage pool.
+func zfsContainerDataset(c container) (string, string, bool) {
+	st := c.Storage()
+	if st == nil || st.GetStorageType() != storageTypeZfs {
+		return "", "", false
+	}
+
+	_, poolName := st.GetContainerPoolInfo()
+	dataset := st.GetStoragePoolWritable().Config["zfs.pool_name"]
+	if dataset == "" {
+		dataset = poolName
+	}
+
+	return fmt.Sprintf("%s/containers/%s", dataset, c.Name()), getContainerMountPoint(poolName, c.Name()), true
+}
+
+// zfsContainerSnapshotFullName returns the full name of the ZFS snapshot
+// backing a snapshot of a container on a ZFS storage pool, which is in a
+// dataset left behind by a restore if the container was restored to an older
+// snapshot since.
+func zfsContainerSnapshotFullName(st storage, cName string, snapOnlyName string) (string, error) {
+	s, ok := storageUnwrap(st).(*storageZfs)
+	if !ok {
+		return "", fmt.Errorf("The container \"%s\" isn't on a ZFS storage pool", cName)
+	}
+
+	fs, err := s.zfsContainerSnapshotDataset(cName, snapOnlyName)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/%s@snapshot-%s", s.getOnDiskPoolName(), fs, snapOnlyName), nil
+}
+
+func zfsFileMonitorSnapshots(dataset string) ([]string, error) {
+	output, err := shared.RunCommand("zfs", "list", "-t", "snapshot", "-H", "-o", "name", "-d", "1", dataset)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list ZFS snapshots: %s", output)
+	}
+
+	snapshots := []string{}
+	for _, name := range strings.Split(output, "\n") {
+		fields := strings.SplitN(name, "@", 2)
+		if len(fields) == 2 && strings.HasPrefix(fields[1], zfsFileMonitorSnapshotPrefix) {
+			snapshots = append(snapshots, name)
+		}
+	}
+
+	return snapshots, nil
+}
+
+// zfsFileMonitorReset removes the file monitor snapshots of a container
+// dataset, which would otherwise prevent rolling it back.
+func zfsFileMonitorReset(containerName string, dataset string) error {
+	defer zfsDatasetCacheInvalidate(dataset)
+
+	zfsFileMonitorLock.Lock()
+	delete(zfsFileMonitorStates, containerName)
+	zfsFileMonitorLock.Unlock()
+
+	snapshots, err := zfsFileMonitorSnapshots(dataset)
+	if err != nil {
+		return err
+	}
+
+	for _, snapshot := range snapshots {
+		output, err := shared.RunCommand("zfs", "destroy", snapshot)
+		if err != nil {
+			return fmt.Errorf("Failed to destroy ZFS snapshot: %s", output)
+		}
+	}
+
+	return nil
+}
+
+// zfsFileMonitorRun takes a new rolling snapshot of the container and sends
+// the changes since the previous one as a "file-change" event.
+func zfsFileMonitorRun(c container, dataset string, mountpoint string) error {
+	defer zfsDatasetCacheInvalidate(dataset)
+
+	zfsFileMonitorLock.Lock()
+	state, ok := zfsFileMonitorStates[c.Name()]
+	zfsFileMonitorLock.Unlock()
+
+	// Start from a clean slate, dropping snapshots left behind by a
+	// previous LXD run or by a renamed container.
+	if !ok {
+		err := zfsFileMonitorReset(c.Name(), dataset)
+		if err != nil {
+			return err
+		}
+
+		state = &zfsFileMonitorState{}
+	}
+
+	snapshot := fmt.Sprintf("%s@%s%d", dataset, zfsFileMonitorSnapshotPrefix, time.Now().UnixNano())
+	output, err := shared.RunCommand("zfs", "snapshot", snapshot)
+	if err != nil {
+		return fmt.Errorf("Failed to create ZFS snapshot: %s", output)
+	}
+
+	previous := state.snapshot
+	state.snapshot = snapshot
+	state.lastRun = time.Now()
+
+	zfsFileMonitorLock.Lock()
+	zfsFileMonitorStates[c.Name()] = state
+	zfsFileMonitorLock.Unlock()
+
+	if previous == "" {
+		return nil
+	}
+
+	defer shared.RunCommand("zfs", "destroy", previous)
+
+	output, err = shared.RunCommand("zfs", "diff", "-H", "-F", previous, snapshot)
+	if err != nil {
+		return fmt.Errorf("Failed to compute ZFS diff: %s", output)
+	}
+
+	changes := zfsParseDiff(output, mountpoint)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	truncated := false
+	if len(changes) > zfsFileMonitorMaxChanges {
+		changes = changes[:zfsFileMonitorMaxChanges]
+		truncated = true
+	}
+
+	eventSend("file-change", shared.Jmap{
+		"container": c.Name(),
+		"changes":   changes,
+		"truncated": truncated,
+	})
+
+	return nil
+}
+
+// zfsFileMonitorCheck runs the file monitor for all the running containers
+// which have "security.file_monitor" enabled and are due.
+func zfsFileMonitorCheck(d *Daemon) {
+	names, err := dbContainersList(d.db, cTypeRegular)
+	if err != nil {
+		return
+	}
+
+	for _, name := range names {
+		c, err := containerLoadByName(d, name)
+		if err != nil {
+			continue
+		}
+
+		if !shared.IsTrue(c.ExpandedConfig()["security.file_monitor"]) || !c.IsRunning() {
+			continue
+		}
+
+		zfsFileMonitorLock.Lock()
+		state, ok := zfsFileMonitorStates[name]
+		due := !ok || time.Since(state.lastRun) >= zfsFileMonitorInterval(c)
+		zfsFileMonitorLock.Unlock()
+
+		if !due {
+			continue
+		}
+
+		dataset, mountpoint, ok := zfsContainerDataset(c)
+		if !ok {
+			continue
+		}
+
+		err = zfsFileMonitorRun(c, dataset, mountpoint)
+		if err != nil {
+			logger.Warn("Failed to check container for file changes", log.Ctx{"container": name, "err": err})
+		}
+	}
+
+	// Forget about containers which are gone.
+	zfsFileMonitorLock.Lock()
+	for name := range zfsFileMonitorStates {
+		if !shared.StringInSlice(name, names) {
+			delete(zfsFileMonitorStates, name)
+		}
+	}
+	zfsFileMonitorLock.Unlock()
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// zfsPoolHealthInterval is how often the health of the ZFS pools used by LXD
+// is checked.
+const zfsPoolHealthInterval = time.Minute
+
+var zfsPoolHealthLock sync.Mutex
+var zfsPoolHealth = map[string]string{}
+
+// zfsParsePoolStatus parses the output of "zpool status -x" into the state
+// of each unhealthy zpool.
+func zfsParsePoolStatus(output string) map[string]string {
+	states := map[string]string{}
+
+	zpool := ""
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+
+		if strings.HasPrefix(line, "pool:") {
+			zpool = strings.TrimSpace(strings.TrimPrefix(line, "pool:"))
+			continue
+		}
+
+		if zpool != "" && strings.HasPrefix(line, "state:") {
+			states[zpool] = strings.TrimSpace(strings.TrimPrefix(line, "state:"))
+			zpool = ""
+		}
+	}
+
+	return states
+}
+
+// zfsParseZpoolState returns the state of a zpool from the output of
+// "zpool status -x <zpool>", which only details unhealthy zpools.
+func zfsParseZpoolState(zpool string, output string) string {
+	state, ok := zfsParsePoolStatus(output)[zpool]
+	if ok {
+		return state
+	}
+
+	if strings.Contains(output, fmt.Sprintf("pool '%s' is healthy", zpool)) {
+		return "ONLINE"
+	}
+
+	return "UNAVAIL"
+}
+
+// zfsZpoolState returns the state of a zpool, UNAVAIL if it isn't imported
+// or doesn't exist.
+func zfsZpoolState(zpool string) string {
+	output, err := shared.RunCommand("zpool", "status", "-x", zpool)
+	if err != nil {
+		logger.Debugf("zpool status of \"%s\" failed: %s.", zpool, strings.TrimSpace(output))
+		return "UNAVAIL"
+	}
+
+	return zfsParseZpoolState(zpool, output)
+}
+
+// zfsPoolHealthGet returns the last known state of the zpool backing the
+// given storage pool or an empty string if it hasn't been checked yet.
+func zfsPoolHealthGet(poolName string) string {
+	zfsPoolHealthLock.Lock()
+	defer zfsPoolHealthLock.Unlock()
+
+	return zfsPoolHealth[poolName]
+}
+
+// zfsStoragePoolZpools returns the zpools backing the ZFS storage pools,
+// keyed by storage pool name.
+func zfsStoragePoolZpools(d *Daemon) map[string]string {
+	zpools := map[string]string{}
+
+	pools, err := dbStoragePools(d.db)
+	if err != nil {
+		return zpools
+	}
+
+	for _, poolName := range pools {
+		_, pool, err := dbStoragePoolGet(d.db, poolName)
+		if err != nil || pool.Driver != "zfs" {
+			continue
+		}
+
+		zpool := pool.Config["zfs.pool_name"]
+		if zpool == "" {
+			zpool = poolName
+		}
+
+		zpools[poolName] = strings.SplitN(zpool, "/", 2)[0]
+	}
+
+	return zpools
+}
+
+// zfsPoolHealthCheck checks the health of the zpools backing the ZFS storage
+// pools and sends a "storage" event whenever a pool's state changes.
+func zfsPoolHealthCheck(d *Daemon) {
+	zpools := zfsStoragePoolZpools(d)
+	if len(zpools) == 0 {
+		return
+	}
+
+	// Several storage pools can share a zpool.
+	states := map[string]string{}
+	for _, zpool := range zpools {
+		_, ok := states[zpool]
+		if !ok {
+			states[zpool] = zfsZpoolState(zpool)
+		}
+	}
+
+	zfsPoolHealthLock.Lock()
+	defer zfsPoolHealthLock.Unlock()
+
+	for poolName, zpool := range zpools {
+		state := states[zpool]
+
+		previous := zfsPoolHealth[poolName]
+		zfsPoolHealth[poolName] = state
+		if state == previous {
+			continue
+		}
+
+		ctx := log.Ctx{"pool": poolName, "zpool": zpool, "state": state}
+		if state != "ONLINE" {
+			logger.Warn("ZFS storage pool is unhealthy", ctx)
+		} else if previous != "" {
+			logger.Info("ZFS storage pool is healthy again", ctx)
+		}
+
+		// Don't report healthy pools on the first check.
+		if previous == "" && state == "ONLINE" {
+			continue
+		}
+
+		eventSend("storage", shared.Jmap{
+			"action":         "pool-health",
+			"pool":           poolName,
+			"zpool":          zpool,
+			"state":          state,
+			"previous_state": previous,
+		})
+	}
+
+	for poolName := range zfsPoolHealth {
+		_, ok := zpools[poolName]
+		if !ok {
+			delete(zfsPoolHealth, poolName)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+func daemonConfigValidateZfsImagesPool(d *Daemon, key string, value string) error {
+	if value == "" {
+		return nil
+	}
+
+	_, pool, err := dbStoragePoolGet(d.db, value)
+	if err != nil {
+		return fmt.Errorf("Invalid storage pool \"%s\": %s", value, err)
+	}
+
+	if pool.Driver != "zfs" {
+		return fmt.Errorf("The shared images pool must be a ZFS storage pool")
+	}
+
+	return nil
+}
+
+// zfsImagesPoolGet returns the name of the shared images pool, if any.
+func zfsImagesPoolGet() string {
+	key, ok := daemonConfig["storage.zfs_images_pool"]
+	if !ok {
+		return ""
+	}
+
+	return key.Get()
+}
+
+// zfsImageCreateFromImagesPool copies an image from the shared images pool
+// using "zfs send | zfs receive" instead of unpacking it again. The image is
+// first created in the shared images pool if it doesn't hold it yet.
+func (s *storageZfs) zfsImageCreateFromImagesPool(imagesPoolName string, fingerprint string) error {
+	defer s.zfsDatasetCacheInvalidate()
+
+	imagesPoolID, imagesPool, err := dbStoragePoolGet(s.d.db, imagesPoolName)
+	if err != nil {
+		return err
+	}
+
+	if imagesPool.Driver != "zfs" {
+		return fmt.Errorf("The shared images pool \"%s\" isn't a ZFS storage pool", imagesPoolName)
+	}
+
+	poolIDs, err := dbImageGetPools(s.d.db, fingerprint)
+	if err != nil {
+		return err
+	}
+
+	if !shared.Int64InSlice(imagesPoolID, poolIDs) {
+		err := imageCreateInPool(s.d, &api.Image{Fingerprint: fingerprint}, imagesPoolName)
+		if err != nil {
+			return err
+		}
+	}
+
+	imagesDataset := imagesPool.Config["zfs.pool_name"]
+	if imagesDataset == "" {
+		imagesDataset = imagesPoolName
+	}
+
+	fs := fmt.Sprintf("images/%s", fingerprint)
+	sourceDataset := fmt.Sprintf("%s/%s@readonly", imagesDataset, fs)
+	targetDataset := fmt.Sprintf("%s/%s", s.getOnDiskPoolName(), fs)
+
+	logger.Debugf("Copying ZFS image \"%s\" from shared images pool \"%s\" to storage pool \"%s\".", fingerprint, imagesPoolName, s.pool.Name)
+
+	err = zfsSendReceive([]string{sourceDataset}, []string{"-u", targetDataset})
+	if err != nil {
+		return err
+	}
+
+	// Properties aren't part of the stream.
+	err = s.zfsPoolVolumeSet(fs, "mountpoint", "none")
+	if err != nil {
+		return err
+	}
+
+	err = s.zfsPoolVolumeTuningApply(fs, map[string]string{}, false)
+	if err != nil {
+		return err
+	}
+
+	return s.zfsPoolVolumeSet(fs, "readonly", "on")
+}
+
+// zfsImagesQuotaApply caps the space the images of the storage pool can use
+// with a quota on its images/ dataset, or removes it if value is empty.
+func (s *storageZfs) zfsImagesQuotaApply(value string) error {
+	quota := "none"
+	if value != "" {
+		size, err := shared.ParseByteSizeString(value)
+		if err != nil {
+			return err
+		}
+
+		if size > 0 {
+			quota = fmt.Sprintf("%d", size)
+		}
+	}
+
+	return s.zfsPoolVolumeSet("images", "quota", quota)
+}
+
+// zfsImageStreamPath returns the path of the "zfs send" stream of an image,
+// stored alongside its tarballs. Older versions of LXD used "<fingerprint>.zfs"
+// as the mountpoint of the image datasets, hence the different suffix.
+func zfsImageStreamPath(fingerprint string) string {
+	return shared.VarPath("images", fingerprint) + ".zfs-stream"
+}
+
+// zfsImageStreamsEnabled returns whether streams should be generated for the
+// images unpacked on ZFS storage pools.
+func zfsImageStreamsEnabled() bool {
+	key, ok := daemonConfig["storage.zfs_image_streams"]
+	if !ok {
+		return false
+	}
+
+	return key.GetBool()
+}
+
+// zfsImageStreamCreate writes the stream of the image's "readonly" snapshot
+// so that other ZFS storage pools, local or on the hosts downloading the
+// image, can receive it instead of unpacking the tarballs.
+func (s *storageZfs) zfsImageStreamCreate(fingerprint string) error {
+	path := zfsImageStreamPath(fingerprint)
+	tmpPath := path + ".tmp"
+
+	snapshot := fmt.Sprintf("%s/images/%s@readonly", s.getOnDiskPoolName(), fingerprint)
+	err := zfsSendFile([]string{snapshot}, tmpPath)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// zfsImageCreateFromStream receives an image from the stream which came with
+// it instead of unpacking its tarballs.
+func (s *storageZfs) zfsImageCreateFromStream(fingerprint string) error {
+	defer s.zfsDatasetCacheInvalidate()
+
+	fs := fmt.Sprintf("images/%s", fingerprint)
+	targetDataset := fmt.Sprintf("%s/%s", s.getOnDiskPoolName(), fs)
+
+	logger.Debugf("Receiving ZFS image \"%s\" on storage pool \"%s\" from its stream.", fingerprint, s.pool.Name)
+
+	err := zfsReceiveFile([]string{"-u", targetDataset}, zfsImageStreamPath(fingerprint))
+	if err != nil {
+		return err
+	}
+
+	// Containers are cloned from the snapshot the stream was made of.
+	if !s.zfsFilesystemEntityExists(fmt.Sprintf("%s@readonly", fs), true) {
+		return fmt.Errorf("The stream of image \"%s\" doesn't contain its \"readonly\" snapshot", fingerprint)
+	}
+
+	// Properties aren't part of the stream.
+	err = s.zfsPoolVolumeSet(fs, "mountpoint", "none")
+	if err != nil {
+		return err
+	}
+
+	err = s.zfsPoolVolumeTuningApply(fs, map[string]string{}, false)
+	if err != nil {
+		return err
+	}
+
+	return s.zfsPoolVolumeSet(fs, "readonly", "on")
+}
+
+// zfsImagesGCMinAge is how old image datasets must be for the garbage
+// collection to consider them, so that it doesn't race with images being
+// created.
+const zfsImagesGCMinAge = time.Hour
+
+// zfsImagesGCInterval returns how often the image datasets are garbage
+// collected, 0 meaning never.
+func zfsImagesGCInterval() time.Duration {
+	key, ok := daemonConfig["storage.zfs_images_gc_interval"]
+	if !ok {
+		return 0
+	}
+
+	return time.Duration(key.GetInt64()) * time.Hour
+}
+
+// zfsParseDatasetCreation parses the output of "zfs list -H -p -o
+// name,creation" into the creation time of the datasets, relative to
+// poolName.
+func zfsParseDatasetCreation(output string, poolName string) (map[string]time.Time, error) {
+	datasets := map[string]time.Time{}
+	for _, line := range strings.Split(output, "\n") {
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Unexpected line: %s", line)
+		}
+
+		creation, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Unexpected creation time: %s", line)
+		}
+
+		name := strings.TrimPrefix(fields[0], fmt.Sprintf("%s/", poolName))
+		datasets[name] = time.Unix(creation, 0)
+	}
+
+	return datasets, nil
+}
+
+// zfsImagesGCCandidates returns the image datasets, both in images/ and in
+// deleted/images/, which belong to none of the images the storage pool holds
+// according to the database and are older than zfsImagesGCMinAge.
+func zfsImagesGCCandidates(datasets map[string]time.Time, referenced []string, now time.Time) []string {
+	candidates := []string{}
+	for name, creation := range datasets {
+		fingerprint := ""
+		if strings.HasPrefix(name, "images/") {
+			fingerprint = strings.TrimPrefix(name, "images/")
+		} else if strings.HasPrefix(name, "deleted/images/") {
+			fingerprint = strings.TrimPrefix(name, "deleted/images/")
+		}
+
+		if fingerprint == "" || strings.Contains(fingerprint, "/") {
+			continue
+		}
+
+		if shared.StringInSlice(fingerprint, referenced) || now.Sub(creation) < zfsImagesGCMinAge {
+			continue
+		}
+
+		candidates = append(candidates, name)
+	}
+
+	return candidates
+}
+
+// zfsImagesGC destroys the image datasets of the storage pool which no
+// image uses anymore: those left behind by images whose deletion failed,
+// like expired cached images, and those in deleted/images/ whose clones are
+// all gone. Unreferenced image datasets which still have clones are moved
+// to deleted/images/ for the next run to destroy them.
+func (s *storageZfs) zfsImagesGC() error {
+	referenced, err := dbStoragePoolVolumesGetType(s.d.db, storagePoolVolumeTypeImage, s.poolID)
+	if err != nil {
+		return err
+	}
+
+	// Deep enough to reach deleted/images/<fingerprint>.
+	poolName := s.getOnDiskPoolName()
+	output, err := shared.RunCommand("zfs", "list", "-H", "-p", "-t", "filesystem", "-o", "name,creation", "-r", "-d", "3", poolName)
+	if err != nil {
+		return fmt.Errorf("Failed to list ZFS image datasets: %s", output)
+	}
+
+	datasets, err := zfsParseDatasetCreation(output, poolName)
+	if err != nil {
+		return err
+	}
+
+	defer s.zfsDatasetCacheInvalidate()
+
+	for _, fs := range zfsImagesGCCandidates(datasets, referenced, time.Now()) {
+		removable, err := s.zfsPoolVolumeSnapshotRemovable(fs, "readonly")
+		if err != nil {
+			// Datasets from an interrupted image creation may lack it.
+			removable, err = s.zfsPoolVolumeSnapshotRemovable(fs, "")
+			if err != nil {
+				return err
+			}
+		}
+
+		ctx := log.Ctx{"pool": s.pool.Name, "dataset": fs}
+		if removable {
+			err := s.zfsPoolVolumeDestroy(fs)
+			if err != nil {
+				return err
+			}
+
+			logger.Info("Destroyed unused image dataset", ctx)
+			continue
+		}
+
+		if strings.HasPrefix(fs, "deleted/") {
+			continue
+		}
+
+		err = s.zfsPoolVolumeSet(fs, "mountpoint", "none")
+		if err != nil {
+			return err
+		}
+
+		err = s.zfsPoolVolumeRename(fs, fmt.Sprintf("deleted/%s", fs))
+		if err != nil {
+			return err
+		}
+
+		logger.Info("Moved unused image dataset with clones to deleted/", ctx)
+	}
+
+	return nil
+}
+
+// zfsImagesGCLast is when the image datasets were last garbage collected.
+var zfsImagesGCLast time.Time
+
+// zfsImagesGCCheck garbage collects the image datasets of all the ZFS
+// storage pools once storage.zfs_images_gc_interval has passed since the
+// last run.
+func zfsImagesGCCheck(d *Daemon) {
+	interval := zfsImagesGCInterval()
+	if interval <= 0 || time.Since(zfsImagesGCLast) < interval {
+		return
+	}
+	zfsImagesGCLast = time.Now()
+
+	for poolName := range zfsStoragePoolZpools(d) {
+		st, err := storagePoolInit(d, poolName)
+		if err != nil {
+			logger.Error("Failed to initialize storage pool", log.Ctx{"pool": poolName, "err": err})
+			continue
+		}
+
+		s, ok := storageUnwrap(st).(*storageZfs)
+		if !ok {
+			continue
+		}
+
+		// The deletions mustn't run behind a backup's back.
+		storageFreezeEnter()
+		err = s.zfsImagesGC()
+		storageFreezeLeave()
+		if err != nil {
+			logger.Error("Failed to garbage collect the image datasets", log.Ctx{"pool": poolName, "err": err})
+		}
+	}
+}
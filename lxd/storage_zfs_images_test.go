@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestZfsParseDatasetCreation(t *testing.T) {
+	output := "tank/lxd\t1500000000\ntank/lxd/images/abc\t1500000100\n"
+
+	datasets, err := zfsParseDatasetCreation(output, "tank/lxd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]time.Time{
+		"tank/lxd":   time.Unix(1500000000, 0),
+		"images/abc": time.Unix(1500000100, 0),
+	}
+
+	if !reflect.DeepEqual(datasets, expected) {
+		t.Errorf("Expected %v, got %v", expected, datasets)
+	}
+
+	_, err = zfsParseDatasetCreation("tank/lxd/images/abc\tyesterday\n", "tank/lxd")
+	if err == nil {
+		t.Errorf("Expected an error for an invalid creation time")
+	}
+}
+
+func TestZfsImagesGCCandidates(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	old := now.Add(-2 * time.Hour)
+
+	datasets := map[string]time.Time{
+		"images":                 old,
+		"images/used":            old,
+		"images/unused":          old,
+		"images/new":             now.Add(-time.Minute),
+		"deleted/images":         old,
+		"deleted/images/unused2": old,
+		"deleted/images/used":    old,
+		"containers/c1":          old,
+		"custom/images":          old,
+	}
+
+	candidates := zfsImagesGCCandidates(datasets, []string{"used"}, now)
+	sort.Strings(candidates)
+
+	expected := []string{"deleted/images/unused2", "images/unused"}
+	if !reflect.DeepEqual(candidates, expected) {
+		t.Errorf("Expected %v, got %v", expected, candidates)
+	}
+}
@@ -0,0 +1,244 @@
+// +build linux
+// +build cgo
+// +build libzfs
+
+package main
+
+/*
+#cgo LDFLAGS: -lzfs -lzfs_core -lnvpair
+#include <stdlib.h>
+#include <libzfs.h>
+#include <libzfs_core.h>
+#include <libnvpair.h>
+
+static libzfs_handle_t *lxd_zfs_handle = NULL;
+
+static int lxd_zfs_init(void)
+{
+	if (lxd_zfs_handle)
+		return 0;
+
+	if (libzfs_core_init() != 0)
+		return -1;
+
+	lxd_zfs_handle = libzfs_init();
+	if (!lxd_zfs_handle) {
+		libzfs_core_fini();
+		return -1;
+	}
+
+	return 0;
+}
+
+static int lxd_zfs_prop_get(const char *dataset, const char *property, char *buf, size_t len)
+{
+	zfs_handle_t *zhp;
+	zfs_prop_t prop;
+	int ret;
+
+	// User properties aren't handled here.
+	prop = zfs_name_to_prop(property);
+	if (prop == ZPROP_INVAL)
+		return -1;
+
+	zhp = zfs_open(lxd_zfs_handle, dataset, ZFS_TYPE_DATASET);
+	if (!zhp)
+		return -1;
+
+	ret = zfs_prop_get(zhp, prop, buf, len, NULL, NULL, 0, B_TRUE);
+	zfs_close(zhp);
+
+	return ret;
+}
+
+static int lxd_zfs_prop_set(const char *dataset, const char *property, const char *value)
+{
+	zfs_handle_t *zhp;
+	int ret;
+
+	zhp = zfs_open(lxd_zfs_handle, dataset, ZFS_TYPE_DATASET);
+	if (!zhp)
+		return -1;
+
+	ret = zfs_prop_set(zhp, property, value);
+	zfs_close(zhp);
+
+	return ret;
+}
+
+static int lxd_zfs_snapshots(char **names, int count, int destroy)
+{
+	nvlist_t *snaps;
+	nvlist_t *errlist = NULL;
+	int i, ret;
+
+	snaps = fnvlist_alloc();
+	for (i = 0; i < count; i++)
+		fnvlist_add_boolean(snaps, names[i]);
+
+	if (destroy)
+		ret = lzc_destroy_snaps(snaps, B_FALSE, &errlist);
+	else
+		ret = lzc_snapshot(snaps, NULL, &errlist);
+
+	fnvlist_free(snaps);
+	if (errlist)
+		nvlist_free(errlist);
+
+	return ret;
+}
+
+static int lxd_zfs_clone(const char *origin, const char *target, const char *mountpoint)
+{
+	nvlist_t *props;
+	int ret;
+
+	props = fnvlist_alloc();
+	fnvlist_add_string(props, "mountpoint", mountpoint);
+	fnvlist_add_uint64(props, "canmount", ZFS_CANMOUNT_NOAUTO);
+
+	ret = lzc_clone(target, origin, props);
+	fnvlist_free(props);
+
+	return ret;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// libzfs isn't thread safe.
+var zfsNativeLock sync.Mutex
+var zfsNativeReady = false
+
+func zfsNativeInit() bool {
+	if zfsNativeReady {
+		return true
+	}
+
+	zfsNativeReady = C.lxd_zfs_init() == 0
+	return zfsNativeReady
+}
+
+// zfsNativeAvailable tells whether the ZFS operations can go through libzfs.
+func zfsNativeAvailable() bool {
+	zfsNativeLock.Lock()
+	defer zfsNativeLock.Unlock()
+
+	return zfsNativeInit()
+}
+
+func zfsNativePropertyGet(dataset string, key string) (string, error) {
+	zfsNativeLock.Lock()
+	defer zfsNativeLock.Unlock()
+
+	if !zfsNativeInit() {
+		return "", errZfsNativeUnavailable
+	}
+
+	cDataset := C.CString(dataset)
+	defer C.free(unsafe.Pointer(cDataset))
+	cKey := C.CString(key)
+	defer C.free(unsafe.Pointer(cKey))
+
+	buf := make([]byte, 4096)
+	ret := C.lxd_zfs_prop_get(cDataset, cKey, (*C.char)(unsafe.Pointer(&buf[0])), C.size_t(len(buf)))
+	if ret != 0 {
+		return "", fmt.Errorf("Failed to get property \"%s\" of \"%s\"", key, dataset)
+	}
+
+	return C.GoString((*C.char)(unsafe.Pointer(&buf[0]))), nil
+}
+
+func zfsNativePropertySet(dataset string, key string, value string) error {
+	zfsNativeLock.Lock()
+	defer zfsNativeLock.Unlock()
+
+	if !zfsNativeInit() {
+		return errZfsNativeUnavailable
+	}
+
+	cDataset := C.CString(dataset)
+	defer C.free(unsafe.Pointer(cDataset))
+	cKey := C.CString(key)
+	defer C.free(unsafe.Pointer(cKey))
+	cValue := C.CString(value)
+	defer C.free(unsafe.Pointer(cValue))
+
+	ret := C.lxd_zfs_prop_set(cDataset, cKey, cValue)
+	if ret != 0 {
+		return fmt.Errorf("Failed to set property \"%s\" of \"%s\"", key, dataset)
+	}
+
+	return nil
+}
+
+func zfsNativeSnapshots(snapshots []string, destroy bool) error {
+	zfsNativeLock.Lock()
+	defer zfsNativeLock.Unlock()
+
+	if !zfsNativeInit() {
+		return errZfsNativeUnavailable
+	}
+
+	if len(snapshots) == 0 {
+		return nil
+	}
+
+	cNames := make([]*C.char, len(snapshots))
+	for i, snapshot := range snapshots {
+		cNames[i] = C.CString(snapshot)
+		defer C.free(unsafe.Pointer(cNames[i]))
+	}
+
+	cDestroy := C.int(0)
+	if destroy {
+		cDestroy = 1
+	}
+
+	ret := C.lxd_zfs_snapshots(&cNames[0], C.int(len(cNames)), cDestroy)
+	if ret != 0 {
+		return fmt.Errorf("libzfs_core snapshot operation failed with error %d", int(ret))
+	}
+
+	return nil
+}
+
+// zfsNativeSnapshotCreate atomically creates the given snapshots.
+func zfsNativeSnapshotCreate(snapshots []string) error {
+	return zfsNativeSnapshots(snapshots, false)
+}
+
+// zfsNativeSnapshotDestroy destroys the given snapshots.
+func zfsNativeSnapshotDestroy(snapshots []string) error {
+	return zfsNativeSnapshots(snapshots, true)
+}
+
+// zfsNativeClone clones origin into target with canmount=noauto. Unlike
+// "zfs clone -p" the parent of target must exist.
+func zfsNativeClone(origin string, target string, mountpoint string) error {
+	zfsNativeLock.Lock()
+	defer zfsNativeLock.Unlock()
+
+	if !zfsNativeInit() {
+		return errZfsNativeUnavailable
+	}
+
+	cOrigin := C.CString(origin)
+	defer C.free(unsafe.Pointer(cOrigin))
+	cTarget := C.CString(target)
+	defer C.free(unsafe.Pointer(cTarget))
+	cMountpoint := C.CString(mountpoint)
+	defer C.free(unsafe.Pointer(cMountpoint))
+
+	ret := C.lxd_zfs_clone(cOrigin, cTarget, cMountpoint)
+	if ret != 0 {
+		return fmt.Errorf("libzfs_core clone failed with error %d", int(ret))
+	}
+
+	return nil
+}
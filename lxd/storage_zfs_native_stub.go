@@ -0,0 +1,29 @@
+// +build !linux !cgo !libzfs
+
+package main
+
+// Without the libzfs build tag, all ZFS operations go through the zfs tool.
+
+func zfsNativeAvailable() bool {
+	return false
+}
+
+func zfsNativePropertyGet(dataset string, key string) (string, error) {
+	return "", errZfsNativeUnavailable
+}
+
+func zfsNativePropertySet(dataset string, key string, value string) error {
+	return errZfsNativeUnavailable
+}
+
+func zfsNativeSnapshotCreate(snapshots []string) error {
+	return errZfsNativeUnavailable
+}
+
+func zfsNativeSnapshotDestroy(snapshots []string) error {
+	return errZfsNativeUnavailable
+}
+
+func zfsNativeClone(origin string, target string, mountpoint string) error {
+	return errZfsNativeUnavailable
+}
@@ -0,0 +1,177 @@
+// +build linux
+// +build cgo
+// +build libzfs
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/lxc/lxd/shared"
+)
+
+// zfsNativeTestPool creates a zpool backed by a sparse file, skipping the
+// test when that can't be done.
+func zfsNativeTestPool(t *testing.T) (string, func()) {
+	if os.Geteuid() != 0 {
+		t.Skip("Creating a zpool requires root")
+	}
+
+	_, err := exec.LookPath("zpool")
+	if err != nil {
+		t.Skip("The zpool tool isn't available")
+	}
+
+	if !zfsNativeAvailable() {
+		t.Skip("libzfs couldn't be initialized")
+	}
+
+	dir, err := ioutil.TempDir("", "lxd_zfs_native_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vdev := filepath.Join(dir, "vdev")
+	f, err := os.Create(vdev)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	err = f.Truncate(128 * 1024 * 1024)
+	f.Close()
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	pool := fmt.Sprintf("lxdtest%d", os.Getpid())
+	output, err := shared.RunCommand("zpool", "create", "-m", "none", pool, vdev)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Skipf("Failed to create a zpool: %s", output)
+	}
+
+	return pool, func() {
+		shared.RunCommand("zpool", "destroy", "-f", pool)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestZfsNativeSnapshots(t *testing.T) {
+	pool, cleanup := zfsNativeTestPool(t)
+	defer cleanup()
+
+	for _, dataset := range []string{"c1", "c1/nested"} {
+		output, err := shared.RunCommand("zfs", "create", fmt.Sprintf("%s/%s", pool, dataset))
+		if err != nil {
+			t.Fatalf("Failed to create %s: %s", dataset, output)
+		}
+	}
+
+	snapshots := []string{fmt.Sprintf("%s/c1@snap0", pool), fmt.Sprintf("%s/c1/nested@snap0", pool)}
+	err := zfsNativeSnapshotCreate(snapshots)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listed, err := zfsPoolListSnapshotsNamed(fmt.Sprintf("%s/c1", pool), "snap0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(listed) != 2 {
+		t.Fatalf("Expected 2 snapshots, got %v", listed)
+	}
+
+	err = zfsNativeSnapshotDestroy(snapshots)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listed, err = zfsPoolListSnapshotsNamed(fmt.Sprintf("%s/c1", pool), "snap0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(listed) != 0 {
+		t.Fatalf("Expected the snapshots to be destroyed, got %v", listed)
+	}
+
+	// Snapshotting a missing dataset fails instead of doing nothing.
+	err = zfsNativeSnapshotCreate([]string{fmt.Sprintf("%s/missing@snap0", pool)})
+	if err == nil {
+		t.Fatal("Expected the snapshot of a missing dataset to fail")
+	}
+}
+
+func TestZfsNativeProperties(t *testing.T) {
+	pool, cleanup := zfsNativeTestPool(t)
+	defer cleanup()
+
+	dataset := fmt.Sprintf("%s/c1", pool)
+	output, err := shared.RunCommand("zfs", "create", dataset)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %s", dataset, output)
+	}
+
+	err = zfsNativePropertySet(dataset, "compression", "lz4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := zfsNativePropertyGet(dataset, "compression")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value != "lz4" {
+		t.Errorf("Expected compression to be lz4, got %q", value)
+	}
+
+	_, err = zfsNativePropertyGet(fmt.Sprintf("%s/missing", pool), "compression")
+	if err == nil {
+		t.Error("Expected getting a property of a missing dataset to fail")
+	}
+}
+
+func TestZfsNativeClone(t *testing.T) {
+	pool, cleanup := zfsNativeTestPool(t)
+	defer cleanup()
+
+	origin := fmt.Sprintf("%s/c1", pool)
+	output, err := shared.RunCommand("zfs", "create", origin)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %s", origin, output)
+	}
+
+	err = zfsNativeSnapshotCreate([]string{origin + "@snap0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = zfsNativeClone(origin+"@snap0", fmt.Sprintf("%s/c2", pool), "none")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := zfsNativePropertyGet(fmt.Sprintf("%s/c2", pool), "origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value != origin+"@snap0" {
+		t.Errorf("Expected the clone's origin to be %s@snap0, got %q", origin, value)
+	}
+
+	// Unlike "zfs clone -p", the parent of the target must exist.
+	err = zfsNativeClone(origin+"@snap0", fmt.Sprintf("%s/missing/c3", pool), "none")
+	if err == nil {
+		t.Error("Expected cloning under a missing parent to fail")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/lxc/lxd/shared/logger"
+)
+
+// Scratch volumes are datasets under "scratch/<container>" rather than under
+// the container's own, so that they're left out of its snapshots, copies and
+// backups. Their content isn't worth syncing to disk as they're destroyed
+// when the container stops.
+
+func (s *storageZfs) ScratchVolumeCreate(container container, device string, size int64) (string, error) {
+	fs := fmt.Sprintf("scratch/%s/%s", container.Name(), device)
+	mountpoint := getScratchVolumeMountPoint(s.pool.Name, container.Name(), device)
+
+	logger.Debugf("Creating ZFS scratch volume \"%s\" on storage pool \"%s\".", fs, s.pool.Name)
+
+	// Scratch volumes always start empty, even when a crash left the
+	// previous one behind.
+	if s.zfsFilesystemEntityExists(fs, true) {
+		err := s.zfsPoolVolumeDestroy(fs)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	properties := []string{"mountpoint=none", "canmount=noauto", "sync=disabled", "com.sun:auto-snapshot=false"}
+	if size > 0 {
+		properties = append(properties, fmt.Sprintf("quota=%d", size))
+	}
+
+	output, err := zfsPoolVolumeCreate(fmt.Sprintf("%s/%s", s.getOnDiskPoolName(), fs), properties...)
+	if err != nil {
+		return "", zfsError("Failed to create ZFS scratch volume", fmt.Sprintf("%s/%s", s.getOnDiskPoolName(), fs), output)
+	}
+
+	revert := true
+	defer func() {
+		if !revert {
+			return
+		}
+
+		s.ScratchVolumeDelete(container, device)
+	}()
+
+	err = os.MkdirAll(mountpoint, 0711)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.zfsPoolVolumeSet(fs, "mountpoint", mountpoint)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.zfsPoolVolumeMount(fs)
+	if err != nil {
+		return "", err
+	}
+
+	revert = false
+
+	logger.Debugf("Created ZFS scratch volume \"%s\" on storage pool \"%s\".", fs, s.pool.Name)
+	return mountpoint, nil
+}
+
+func (s *storageZfs) ScratchVolumeDelete(container container, device string) error {
+	parent := fmt.Sprintf("scratch/%s", container.Name())
+	fs := fmt.Sprintf("%s/%s", parent, device)
+	mountpoint := getScratchVolumeMountPoint(s.pool.Name, container.Name(), device)
+
+	logger.Debugf("Deleting ZFS scratch volume \"%s\" on storage pool \"%s\".", fs, s.pool.Name)
+
+	if s.zfsFilesystemEntityExists(fs, true) {
+		err := s.zfsPoolVolumeDestroy(fs)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := os.RemoveAll(mountpoint)
+	if err != nil {
+		return err
+	}
+
+	// The dataset holding the scratch volumes of the container goes with
+	// the last of them.
+	subvols, err := s.zfsPoolListSubvolumes(fmt.Sprintf("%s/%s", s.getOnDiskPoolName(), parent))
+	if err == nil && len(subvols) == 0 {
+		err = s.zfsPoolVolumeDestroy(parent)
+		if err != nil {
+			logger.Warnf("Failed to delete ZFS dataset \"%s\": %s.", parent, err)
+		}
+
+		os.Remove(getScratchVolumeMountPoint(s.pool.Name, container.Name(), ""))
+	}
+
+	logger.Debugf("Deleted ZFS scratch volume \"%s\" on storage pool \"%s\".", fs, s.pool.Name)
+	return nil
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -11,10 +15,18 @@ import (
 
 	"github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/logger"
+
+	"github.com/pborman/uuid"
 )
 
+// errZfsNativeUnavailable is returned by the zfsNative functions when LXD
+// wasn't built with libzfs support or libzfs couldn't be initialized.
+var errZfsNativeUnavailable = fmt.Errorf("Native ZFS support isn't available")
+
 // zfsPoolVolumeCreate creates a ZFS dataset with a set of given properties.
 func zfsPoolVolumeCreate(dataset string, properties ...string) (string, error) {
+	defer zfsDatasetCacheInvalidate(dataset)
+
 	cmd := []string{"zfs", "create"}
 
 	for _, prop := range properties {
@@ -49,6 +61,8 @@ func (s *storageZfs) zfsPoolCheck(pool string) error {
 }
 
 func (s *storageZfs) zfsPoolCreate() error {
+	defer s.zfsDatasetCacheInvalidate()
+
 	zpoolName := s.getOnDiskPoolName()
 	vdev := s.pool.Config["source"]
 	if vdev == "" {
@@ -84,8 +98,14 @@ func (s *storageZfs) zfsPoolCreate() error {
 			"create", zpoolName, vdev,
 			"-f", "-m", "none", "-O", "compression=on")
 		if err != nil {
-			return fmt.Errorf("Failed to create the ZFS pool: %s", output)
+			return zfsError("Failed to create the ZFS pool", zpoolName, output)
+		}
+
+		guid, err := zfsPoolGUIDGet(zpoolName)
+		if err != nil {
+			return err
 		}
+		s.pool.Config["zfs.pool_guid"] = guid
 	} else {
 		// Unset size property since it doesn't make sense.
 		s.pool.Config["size"] = ""
@@ -112,8 +132,14 @@ func (s *storageZfs) zfsPoolCreate() error {
 				"create", zpoolName, vdev,
 				"-f", "-m", "none", "-O", "compression=on")
 			if err != nil {
-				return fmt.Errorf("Failed to create the ZFS pool: %s", output)
+				return zfsError("Failed to create the ZFS pool", zpoolName, output)
 			}
+
+			guid, err := zfsPoolGUIDGet(zpoolName)
+			if err != nil {
+				return err
+			}
+			s.pool.Config["zfs.pool_guid"] = guid
 		} else {
 			if s.pool.Config["zfs.pool_name"] != "" {
 				return fmt.Errorf("invalid combination of \"source\" and \"zfs.pool_name\" property")
@@ -133,7 +159,7 @@ func (s *storageZfs) zfsPoolCreate() error {
 						vdev)
 					if err != nil {
 						logger.Errorf("zfs create failed: %s.", output)
-						return fmt.Errorf("Failed to create ZFS filesystem: %s", output)
+						return zfsError("Failed to create ZFS filesystem", vdev, output)
 					}
 				} else {
 					msg, err := zfsPoolVolumeSet(vdev, "mountpoint", "none")
@@ -194,6 +220,13 @@ func (s *storageZfs) zfsPoolCreate() error {
 		return err
 	}
 
+	if s.pool.Config["zfs.images.quota"] != "" {
+		err = s.zfsImagesQuotaApply(s.pool.Config["zfs.images.quota"])
+		if err != nil {
+			return err
+		}
+	}
+
 	fixperms = shared.VarPath("storage-pools", s.pool.Name, "images")
 	err = os.MkdirAll(fixperms, imagesDirMode)
 	if err != nil && !os.IsNotExist(err) {
@@ -248,38 +281,92 @@ func (s *storageZfs) zfsPoolCreate() error {
 	return nil
 }
 
-func (s *storageZfs) zfsPoolVolumeClone(source string, name string, dest string, mountpoint string) error {
-	poolName := s.getOnDiskPoolName()
-	output, err := shared.RunCommand(
-		"zfs",
-		"clone",
-		"-p",
-		"-o", fmt.Sprintf("mountpoint=%s", mountpoint),
-		"-o", "canmount=noauto",
-		fmt.Sprintf("%s/%s@%s", poolName, source, name),
-		fmt.Sprintf("%s/%s", poolName, dest))
+// zfsPoolGUIDGet returns the GUID of the given zpool.
+func zfsPoolGUIDGet(zpool string) (string, error) {
+	output, err := shared.RunCommand("zpool", "get", "-H", "-o", "value", "guid", zpool)
 	if err != nil {
-		logger.Errorf("zfs clone failed: %s.", output)
-		return fmt.Errorf("Failed to clone the filesystem: %s", output)
+		return "", fmt.Errorf("Failed to retrieve the GUID of the ZFS pool \"%s\": %s", zpool, output)
 	}
 
-	subvols, err := s.zfsPoolListSubvolumes(fmt.Sprintf("%s/%s", poolName, source))
+	return strings.TrimSpace(output), nil
+}
+
+// zfsPoolGUIDCheck makes sure that the zpool imported under the given name is
+// the one the storage pool was created on. Storage pools created before LXD
+// started recording GUIDs get the GUID of the current zpool recorded.
+func (s *storageZfs) zfsPoolGUIDCheck(zpool string) error {
+	expected := s.pool.Config["zfs.pool_guid"]
+
+	guid, err := zfsPoolGUIDGet(zpool)
 	if err != nil {
+		if expected == "" {
+			logger.Warnf("Failed to record the GUID of ZFS storage pool \"%s\": %s", s.pool.Name, err)
+			return nil
+		}
+
 		return err
 	}
 
-	for _, sub := range subvols {
-		snaps, err := s.zfsPoolListSnapshots(sub)
+	if expected == "" {
+		s.pool.Config["zfs.pool_guid"] = guid
+		err := dbStoragePoolUpdate(s.d.db, s.pool.Name, s.pool.Description, s.pool.Config)
 		if err != nil {
-			return err
+			logger.Warnf("Failed to record the GUID of ZFS storage pool \"%s\": %s", s.pool.Name, err)
+		}
+
+		return nil
+	}
+
+	if guid != expected {
+		return fmt.Errorf("the ZFS pool \"%s\" has GUID %s but storage pool \"%s\" expects GUID %s", zpool, guid, s.pool.Name, expected)
+	}
+
+	return nil
+}
+
+func (s *storageZfs) zfsPoolVolumeClone(source string, name string, dest string, mountpoint string) error {
+	defer s.zfsDatasetCacheInvalidate()
+
+	poolName := s.getOnDiskPoolName()
+	if !s.zfsPoolVolumeCloneNative(fmt.Sprintf("%s/%s@%s", poolName, source, name), fmt.Sprintf("%s/%s", poolName, dest), mountpoint) {
+		output, err := shared.RunCommand(
+			"zfs",
+			"clone",
+			"-p",
+			"-o", fmt.Sprintf("mountpoint=%s", mountpoint),
+			"-o", "canmount=noauto",
+			fmt.Sprintf("%s/%s@%s", poolName, source, name),
+			fmt.Sprintf("%s/%s", poolName, dest))
+		if err != nil {
+			logger.Errorf("zfs clone failed: %s.", output)
+			return zfsError("Failed to clone the filesystem", fmt.Sprintf("%s/%s@%s", poolName, source, name), output)
 		}
+	}
+
+	subvols, err := s.zfsPoolListSubvolumes(fmt.Sprintf("%s/%s", poolName, source))
+	if err != nil {
+		return err
+	}
 
-		if !shared.StringInSlice(name, snaps) {
+	if len(subvols) == 0 {
+		return nil
+	}
+
+	datasets, err := s.zfsPoolVolumeGetAll(source, "type")
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range subvols {
+		if datasets[fmt.Sprintf("%s@%s", sub, name)] == nil {
 			continue
 		}
 
 		destSubvol := dest + strings.TrimPrefix(sub, source)
 		snapshotMntPoint := getSnapshotMountPoint(s.pool.Name, destSubvol)
+		if s.zfsPoolVolumeCloneNative(fmt.Sprintf("%s/%s@%s", poolName, sub, name), fmt.Sprintf("%s/%s", poolName, destSubvol), snapshotMntPoint) {
+			continue
+		}
 
 		output, err := shared.RunCommand(
 			"zfs",
@@ -291,30 +378,52 @@ func (s *storageZfs) zfsPoolVolumeClone(source string, name string, dest string,
 			fmt.Sprintf("%s/%s", poolName, destSubvol))
 		if err != nil {
 			logger.Errorf("zfs clone failed: %s.", output)
-			return fmt.Errorf("Failed to clone the sub-volume: %s", output)
+			return zfsError("Failed to clone the sub-volume", fmt.Sprintf("%s/%s@%s", poolName, sub, name), output)
 		}
 	}
 
 	return nil
 }
 
+// zfsPoolVolumeCloneNative attempts to clone through libzfs_core. As this
+// doesn't create missing parents, it's only tried when the parent of the
+// target is known to exist.
+func (s *storageZfs) zfsPoolVolumeCloneNative(origin string, target string, mountpoint string) bool {
+	parent := filepath.Dir(target)
+	entry := s.zfsDatasetCacheLookup(parent)
+	if entry == nil || !entry.datasets[parent] {
+		return false
+	}
+
+	return zfsNativeClone(origin, target, mountpoint) == nil
+}
+
 func (s *storageZfs) zfsFilesystemEntityDelete() error {
-	var output string
-	var err error
+	defer s.zfsDatasetCacheInvalidate()
+
+	var args []string
 	poolName := s.getOnDiskPoolName()
-	if strings.Contains(poolName, "/") {
+	vdev := s.pool.Config["source"]
+	if filepath.IsAbs(vdev) {
+		// Loop file backed pools always use a dedicated zpool, even
+		// if the LXD datasets live below its root dataset.
+		args = []string{"zpool", "destroy", "-f", strings.Split(poolName, "/")[0]}
+	} else if strings.Contains(poolName, "/") {
 		// Command to destroy a zfs dataset.
-		output, err = shared.RunCommand("zfs", "destroy", "-r", poolName)
+		args = []string{"zfs", "destroy", "-r", poolName}
 	} else {
 		// Command to destroy a zfs pool.
-		output, err = shared.RunCommand("zpool", "destroy", "-f", poolName)
+		args = []string{"zpool", "destroy", "-f", poolName}
 	}
+
+	audited := s.auditCommand("pool_destroy", poolName, args[0], args[1:]...)
+	output, err := shared.RunCommand(args[0], args[1:]...)
+	audited(err)
 	if err != nil {
-		return fmt.Errorf("Failed to delete the ZFS pool: %s", output)
+		return zfsError("Failed to delete the ZFS pool", args[len(args)-1], output)
 	}
 
 	// Cleanup storage
-	vdev := s.pool.Config["source"]
 	if filepath.IsAbs(vdev) && !shared.IsBlockdevPath(vdev) {
 		os.RemoveAll(vdev)
 	}
@@ -323,6 +432,8 @@ func (s *storageZfs) zfsFilesystemEntityDelete() error {
 }
 
 func (s *storageZfs) zfsPoolVolumeDestroy(path string) error {
+	defer s.zfsDatasetCacheInvalidate()
+
 	mountpoint, err := s.zfsFilesystemEntityPropertyGet(path, "mountpoint", true)
 	if err != nil {
 		return err
@@ -337,16 +448,20 @@ func (s *storageZfs) zfsPoolVolumeDestroy(path string) error {
 	}
 
 	poolName := s.getOnDiskPoolName()
-	// Due to open fds or kernel refs, this may fail for a bit, give it 10s
-	output, err := shared.TryRunCommand(
+	dataset := fmt.Sprintf("%s/%s", poolName, path)
+	audited := s.auditCommand("dataset_destroy", dataset, "zfs", "destroy", "-r", dataset)
+
+	// Due to open fds or kernel refs, this may fail for a bit.
+	output, err := storageRunCommandRetry(
 		"zfs",
 		"destroy",
 		"-r",
-		fmt.Sprintf("%s/%s", poolName, path))
+		dataset)
+	audited(err)
 
 	if err != nil {
 		logger.Errorf("zfs destroy failed: %s.", output)
-		return fmt.Errorf("Failed to destroy ZFS filesystem: %s", output)
+		return zfsError("Failed to destroy ZFS filesystem", dataset, output)
 	}
 
 	return nil
@@ -407,26 +522,39 @@ func (s *storageZfs) zfsPoolVolumeCleanup(path string) error {
 
 			return nil
 		}
-	} else if strings.HasPrefix(path, "containers") && strings.Contains(path, "@copy-") {
+	} else if (strings.HasPrefix(path, "containers") || strings.HasPrefix(path, "custom")) && strings.Contains(path, "@copy-") {
 		// Just remove the copy- snapshot for copies of active containers
-		err := s.zfsPoolVolumeDestroy(path)
+		// and custom volumes, unless other copies still use it.
+		removable, err := s.zfsPoolVolumeSnapshotRemovable(path, "")
 		if err != nil {
 			return err
 		}
+
+		if removable {
+			err := s.zfsPoolVolumeDestroy(path)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
 func (s *storageZfs) zfsFilesystemEntityExists(path string, prefixPathWithPool bool) bool {
-	output, _ := s.zfsFilesystemEntityPropertyGet(path, "name", prefixPathWithPool)
-
 	// If prefixPathWithPool is false we assume that the path passed in
 	// already is a valid zfs entity we want to check for.
 	fsToCheck := path
 	if prefixPathWithPool {
 		fsToCheck = fmt.Sprintf("%s/%s", s.getOnDiskPoolName(), path)
 	}
+
+	entry := s.zfsDatasetCacheLookup(fsToCheck)
+	if entry != nil {
+		return entry.datasets[fsToCheck]
+	}
+
+	output, _ := s.zfsFilesystemEntityPropertyGet(path, "name", prefixPathWithPool)
 	if output == fsToCheck {
 		return true
 	}
@@ -442,6 +570,11 @@ func (s *storageZfs) zfsFilesystemEntityPropertyGet(path string, key string, pre
 		fsToCheck = fmt.Sprintf("%s/%s", s.getOnDiskPoolName(), path)
 	}
 
+	value, err := zfsNativePropertyGet(fsToCheck, key)
+	if err == nil {
+		return value, nil
+	}
+
 	output, err := shared.RunCommand(
 		"zfs",
 		"get",
@@ -451,17 +584,152 @@ func (s *storageZfs) zfsFilesystemEntityPropertyGet(path string, key string, pre
 		key,
 		fsToCheck)
 	if err != nil {
-		return "", fmt.Errorf("Failed to get ZFS config: %s", output)
+		return "", zfsError("Failed to get ZFS config", fsToCheck, output)
 	}
 
 	return strings.TrimRight(output, "\n"), nil
 }
 
+// zfsPoolVolumeGetAll retrieves the given properties of a dataset and of all
+// of its descendants, including snapshots, with a single "zfs get" call. The
+// returned map is keyed by dataset name relative to the pool.
+func (s *storageZfs) zfsPoolVolumeGetAll(path string, keys ...string) (map[string]map[string]string, error) {
+	poolName := s.getOnDiskPoolName()
+	output, err := shared.RunCommand(
+		"zfs",
+		"get",
+		"-r",
+		"-H",
+		"-p",
+		"-o", "name,property,value",
+		strings.Join(keys, ","),
+		fmt.Sprintf("%s/%s", poolName, path))
+	if err != nil {
+		logger.Errorf("zfs get failed: %s.", output)
+		return nil, zfsError("Failed to get ZFS config", fmt.Sprintf("%s/%s", poolName, path), output)
+	}
+
+	return zfsParsePropertyList(output, poolName)
+}
+
+// zfsListOrigins returns the origin of every dataset and snapshot of the
+// zpool the storage pool lives on, "-" for those which aren't clones. Clones
+// may live anywhere in the zpool, not only below the LXD datasets.
+func (s *storageZfs) zfsListOrigins() (map[string]string, error) {
+	zpool := strings.Split(s.getOnDiskPoolName(), "/")[0]
+	output, err := shared.RunCommand(
+		"zfs",
+		"list",
+		"-H",
+		"-r",
+		"-t", "all",
+		"-o", "name,origin",
+		zpool)
+	if err != nil {
+		return nil, zfsError("Failed to list ZFS datasets", zpool, output)
+	}
+
+	return zfsParseOrigins(output)
+}
+
+// zfsParseOrigins parses the tab separated name and origin pairs printed by
+// "zfs list -H -o name,origin".
+func zfsParseOrigins(output string) (map[string]string, error) {
+	origins := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		if line == "" {
+			continue
+		}
+
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Unexpected ZFS list line: %s", line)
+		}
+
+		origins[fields[0]] = fields[1]
+	}
+
+	return origins, nil
+}
+
+// zfsDatasetBelow returns whether a dataset or snapshot is root, one of its
+// snapshots or lives below it.
+func zfsDatasetBelow(name string, root string) bool {
+	return name == root || strings.HasPrefix(name, root+"/") || strings.HasPrefix(name, root+"@")
+}
+
+// zfsDestroyedDatasets returns the datasets and snapshots "zfs destroy -r"
+// of root would destroy, sorted.
+func zfsDestroyedDatasets(origins map[string]string, root string) []string {
+	datasets := []string{}
+	for name := range origins {
+		if zfsDatasetBelow(name, root) {
+			datasets = append(datasets, name)
+		}
+	}
+
+	sort.Strings(datasets)
+	return datasets
+}
+
+// zfsDependentClones returns the clones living outside of root whose origin
+// is one of the snapshots "zfs destroy -r" of root would destroy, which keep
+// it from being destroyed, sorted.
+func zfsDependentClones(origins map[string]string, root string) []string {
+	clones := []string{}
+	for name, origin := range origins {
+		if origin == "-" || origin == "" || zfsDatasetBelow(name, root) {
+			continue
+		}
+
+		if zfsDatasetBelow(origin, root) {
+			clones = append(clones, name)
+		}
+	}
+
+	sort.Strings(clones)
+	return clones
+}
+
+// zfsParsePropertyList parses the tab separated name, property and value
+// triplets printed by "zfs get -H -o name,property,value".
+func zfsParsePropertyList(output string, poolName string) (map[string]map[string]string, error) {
+	result := map[string]map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		if line == "" {
+			continue
+		}
+
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("Unexpected ZFS property line: %s", line)
+		}
+
+		name := strings.TrimPrefix(fields[0], fmt.Sprintf("%s/", poolName))
+		if result[name] == nil {
+			result[name] = map[string]string{}
+		}
+		result[name][fields[1]] = fields[2]
+	}
+
+	return result, nil
+}
+
 func (s *storageZfs) zfsPoolVolumeRename(source string, dest string) error {
+	defer s.zfsDatasetCacheInvalidate()
+
 	var err error
 	var output string
 
 	poolName := s.getOnDiskPoolName()
+
+	// Datasets are renamed to "deleted/" when they can't be destroyed yet,
+	// which is as good as destroying them.
+	audited := func(err error) {}
+	if strings.HasPrefix(dest, "deleted/") {
+		audited = s.auditCommand("dataset_rename", fmt.Sprintf("%s/%s", poolName, source), "zfs", "rename", "-p", fmt.Sprintf("%s/%s", poolName, source), fmt.Sprintf("%s/%s", poolName, dest))
+	}
+
 	for i := 0; i < 20; i++ {
 		output, err = shared.RunCommand(
 			"zfs",
@@ -472,11 +740,13 @@ func (s *storageZfs) zfsPoolVolumeRename(source string, dest string) error {
 
 		// Success
 		if err == nil {
+			audited(nil)
 			return nil
 		}
 
 		// zfs rename can fail because of descendants, yet still manage the rename
 		if !s.zfsFilesystemEntityExists(source, true) && s.zfsFilesystemEntityExists(dest, true) {
+			audited(nil)
 			return nil
 		}
 
@@ -485,127 +755,361 @@ func (s *storageZfs) zfsPoolVolumeRename(source string, dest string) error {
 
 	// Timeout
 	logger.Errorf("zfs rename failed: %s.", output)
-	return fmt.Errorf("Failed to rename ZFS filesystem: %s", output)
+	err = zfsError("Failed to rename ZFS filesystem", fmt.Sprintf("%s/%s", poolName, source), output)
+	audited(err)
+	return err
 }
 
-func (s *storageZfs) zfsPoolVolumeSet(path string, key string, value string) error {
+func (s *storageZfs) zfsPoolVolumePromote(path string) error {
+	defer s.zfsDatasetCacheInvalidate()
+
 	poolName := s.getOnDiskPoolName()
 	output, err := shared.RunCommand(
 		"zfs",
-		"set",
-		fmt.Sprintf("%s=%s", key, value),
+		"promote",
 		fmt.Sprintf("%s/%s", poolName, path))
 	if err != nil {
-		logger.Errorf("zfs set failed: %s.", output)
-		return fmt.Errorf("Failed to set ZFS config: %s", output)
+		logger.Errorf("zfs promote failed: %s.", output)
+		return zfsError("Failed to promote ZFS filesystem", fmt.Sprintf("%s/%s", poolName, path), output)
 	}
 
 	return nil
 }
 
-func (s *storageZfs) zfsPoolVolumeSnapshotCreate(path string, name string) error {
+// zfsPoolVolumeLocalProperties returns those of keys which are set on the
+// dataset itself rather than inherited or left to their defaults.
+func (s *storageZfs) zfsPoolVolumeLocalProperties(path string, keys ...string) (map[string]string, error) {
 	poolName := s.getOnDiskPoolName()
 	output, err := shared.RunCommand(
 		"zfs",
-		"snapshot",
-		"-r",
-		fmt.Sprintf("%s/%s@%s", poolName, path, name))
+		"get",
+		"-H",
+		"-p",
+		"-s", "local",
+		"-o", "name,property,value",
+		strings.Join(keys, ","),
+		fmt.Sprintf("%s/%s", poolName, path))
 	if err != nil {
-		logger.Errorf("zfs snapshot failed: %s.", output)
-		return fmt.Errorf("Failed to create ZFS snapshot: %s", output)
+		logger.Errorf("zfs get failed: %s.", output)
+		return nil, zfsError("Failed to get ZFS config", fmt.Sprintf("%s/%s", poolName, path), output)
 	}
 
-	return nil
+	props, err := zfsParsePropertyList(output, poolName)
+	if err != nil {
+		return nil, err
+	}
+
+	if props[path] == nil {
+		return map[string]string{}, nil
+	}
+
+	return props[path], nil
 }
 
-func (s *storageZfs) zfsPoolVolumeSnapshotDestroy(path string, name string) error {
+func (s *storageZfs) zfsPoolVolumeSet(path string, key string, value string) error {
 	poolName := s.getOnDiskPoolName()
+	err := zfsNativePropertySet(fmt.Sprintf("%s/%s", poolName, path), key, value)
+	if err == nil {
+		return nil
+	}
+
 	output, err := shared.RunCommand(
 		"zfs",
-		"destroy",
-		"-r",
-		fmt.Sprintf("%s/%s@%s", poolName, path, name))
+		"set",
+		fmt.Sprintf("%s=%s", key, value),
+		fmt.Sprintf("%s/%s", poolName, path))
 	if err != nil {
-		logger.Errorf("zfs destroy failed: %s.", output)
-		return fmt.Errorf("Failed to destroy ZFS snapshot: %s", output)
+		logger.Errorf("zfs set failed: %s.", output)
+		return zfsError("Failed to set ZFS config", fmt.Sprintf("%s/%s", poolName, path), output)
 	}
 
 	return nil
 }
 
-func (s *storageZfs) zfsPoolVolumeSnapshotRestore(path string, name string) error {
-	poolName := s.getOnDiskPoolName()
-	output, err := shared.TryRunCommand(
-		"zfs",
-		"rollback",
-		fmt.Sprintf("%s/%s@%s", poolName, path, name))
-	if err != nil {
-		logger.Errorf("zfs rollback failed: %s.", output)
-		return fmt.Errorf("Failed to restore ZFS snapshot: %s", output)
+// zfsVolumeConfigGet returns the "zfs.<key>" setting of a storage volume,
+// falling back to the "volume.zfs.<key>" default of its storage pool.
+func (s *storageZfs) zfsVolumeConfigGet(volumeConfig map[string]string, key string) string {
+	value := volumeConfig[fmt.Sprintf("zfs.%s", key)]
+	if value == "" {
+		value = s.pool.Config[fmt.Sprintf("volume.zfs.%s", key)]
 	}
 
-	subvols, err := s.zfsPoolListSubvolumes(fmt.Sprintf("%s/%s", poolName, path))
+	return value
+}
+
+// zfsPoolVolumeDefaultsApply sets the properties of a newly created dataset
+// which follow the volume config or the pool defaults.
+func (s *storageZfs) zfsPoolVolumeDefaultsApply(path string, volumeConfig map[string]string) error {
+	err := s.zfsPoolVolumeTuningApply(path, volumeConfig, false)
 	if err != nil {
 		return err
 	}
 
-	for _, sub := range subvols {
-		snaps, err := s.zfsPoolListSnapshots(sub)
+	// Reserving the whole quota waits for a quota to be set.
+	if s.zfsVolumeConfigGet(volumeConfig, "reservation") != "" {
+		err := s.zfsPoolVolumeReservationApply(path, volumeConfig, 0)
 		if err != nil {
 			return err
 		}
+	}
+
+	return nil
+}
+
+// zfsMigrationProperties are the properties of a container's dataset which
+// migrations carry over, in the order they are set on the sink: quotas
+// before the reservations they bound.
+var zfsMigrationProperties = []string{"quota", "refquota", "reservation", "refreservation", "compression", "recordsize", "sync", "logbias", "primarycache", "secondarycache"}
+
+// zfsDatasetLocalProperties returns those of zfsMigrationProperties which are
+// set on the dataset itself rather than inherited.
+func zfsDatasetLocalProperties(dataset string) (map[string]string, error) {
+	output, err := shared.RunCommand("zfs", "get", "-H", "-p", "-s", "local", "-o", "property,value", strings.Join(zfsMigrationProperties, ","), dataset)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get the properties of %s: %s", dataset, output)
+	}
+
+	return zfsLocalPropertiesParse(output), nil
+}
+
+func zfsLocalPropertiesParse(output string) map[string]string {
+	properties := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 || !shared.StringInSlice(fields[0], zfsMigrationProperties) {
+			continue
+		}
+
+		properties[fields[0]] = strings.TrimSpace(fields[1])
+	}
+
+	return properties
+}
 
-		if !shared.StringInSlice(name, snaps) {
+// zfsPoolVolumePropertiesApply sets the properties a migration or a rebuild
+// carried over on the new dataset. A property which can't be set (e.g. a
+// recordsize needing a pool feature it lacks) only warns, the data being
+// there already.
+func (s *storageZfs) zfsPoolVolumePropertiesApply(path string, properties map[string]string) {
+	for _, property := range zfsMigrationProperties {
+		value, ok := properties[property]
+		if !ok {
 			continue
 		}
 
-		output, err := shared.TryRunCommand(
-			"zfs",
-			"rollback",
-			fmt.Sprintf("%s/%s@%s", poolName, sub, name))
+		err := s.zfsPoolVolumeSet(path, property, value)
 		if err != nil {
-			logger.Errorf("zfs rollback failed: %s.", output)
-			return fmt.Errorf("Failed to restore ZFS sub-volume snapshot: %s", output)
+			logger.Warnf("Failed to set the property %s=%s on %s: %s", property, value, path, err)
 		}
 	}
-
-	return nil
 }
 
-func (s *storageZfs) zfsPoolVolumeSnapshotRename(path string, oldName string, newName string) error {
-	poolName := s.getOnDiskPoolName()
-	output, err := shared.RunCommand(
-		"zfs",
-		"rename",
-		"-r",
-		fmt.Sprintf("%s/%s@%s", poolName, path, oldName),
-		fmt.Sprintf("%s/%s@%s", poolName, path, newName))
-	if err != nil {
-		logger.Errorf("zfs snapshot rename failed: %s.", output)
-		return fmt.Errorf("Failed to rename ZFS snapshot: %s", output)
+// zfsPoolVolumeTuningKeys are the ZFS properties which can be set for a
+// storage volume through "zfs.<property>" or for all volumes of a storage pool
+// through "volume.zfs.<property>".
+var zfsPoolVolumeTuningKeys = []string{"sync", "logbias", "primarycache", "secondarycache"}
+
+// zfsPoolVolumeTuningApply sets the tuning properties of a dataset from the
+// volume config, falling back to the pool defaults. When reset is true,
+// properties which aren't configured anymore are inherited again.
+func (s *storageZfs) zfsPoolVolumeTuningApply(path string, config map[string]string, reset bool) error {
+	for _, property := range zfsPoolVolumeTuningKeys {
+		value := s.zfsVolumeConfigGet(config, property)
+		if value == "" {
+			if !reset {
+				continue
+			}
+
+			err := s.zfsPoolVolumeInherit(path, property)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if property == "sync" && value == "disabled" {
+			logger.Warnf("Disabling synchronous writes on ZFS dataset \"%s\". Writes will be acknowledged before reaching stable storage and recent data will be lost on a crash or power failure.", path)
+		}
+
+		err := s.zfsPoolVolumeSet(path, property, value)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func zfsMount(poolName string, path string) error {
-	output, err := shared.TryRunCommand(
+func (s *storageZfs) zfsPoolVolumeInherit(path string, key string) error {
+	poolName := s.getOnDiskPoolName()
+	output, err := shared.RunCommand(
 		"zfs",
-		"mount",
+		"inherit",
+		key,
 		fmt.Sprintf("%s/%s", poolName, path))
 	if err != nil {
-		return fmt.Errorf("Failed to mount ZFS filesystem: %s", output)
+		logger.Errorf("zfs inherit failed: %s.", output)
+		return zfsError("Failed to reset ZFS config", fmt.Sprintf("%s/%s", poolName, path), output)
 	}
 
 	return nil
 }
 
-func (s *storageZfs) zfsPoolVolumeMount(path string) error {
-	return zfsMount(s.getOnDiskPoolName(), path)
-}
+func (s *storageZfs) zfsPoolVolumeSnapshotCreate(path string, name string) error {
+	defer s.zfsDatasetCacheInvalidate()
 
-func zfsUmount(poolName string, path string, mountpoint string) error {
+	poolName := s.getOnDiskPoolName()
+
+	// Recursive snapshots need the list of descendants. It's listed afresh
+	// rather than taken from the cache, which may miss the datasets created
+	// since or outside of LXD.
+	if zfsNativeAvailable() {
+		datasets, _ := zfsPoolListDatasets(fmt.Sprintf("%s/%s", poolName, path))
+
+		snapshots := []string{}
+		for _, dataset := range datasets {
+			snapshots = append(snapshots, fmt.Sprintf("%s@%s", dataset, name))
+		}
+
+		if len(snapshots) > 0 && zfsNativeSnapshotCreate(snapshots) == nil {
+			return nil
+		}
+	}
+
+	output, err := shared.RunCommand(
+		"zfs",
+		"snapshot",
+		"-r",
+		fmt.Sprintf("%s/%s@%s", poolName, path, name))
+	if err != nil {
+		logger.Errorf("zfs snapshot failed: %s.", output)
+		return zfsError("Failed to create ZFS snapshot", fmt.Sprintf("%s/%s@%s", poolName, path, name), output)
+	}
+
+	return nil
+}
+
+func (s *storageZfs) zfsPoolVolumeSnapshotDestroy(path string, name string) error {
+	defer s.zfsDatasetCacheInvalidate()
+
+	poolName := s.getOnDiskPoolName()
+
+	// Recursive destruction needs the list of descendants with that
+	// snapshot, listed afresh like for their creation.
+	snapshots := []string{}
+	if zfsNativeAvailable() {
+		snapshots, _ = zfsPoolListSnapshotsNamed(fmt.Sprintf("%s/%s", poolName, path), name)
+	}
+
+	if len(snapshots) > 0 {
+		err := zfsNativeSnapshotDestroy(snapshots)
+		if err == nil {
+			s.auditCommand("snapshot_destroy", fmt.Sprintf("%s/%s@%s", poolName, path, name), "lzc_destroy_snaps", snapshots...)(nil)
+			return nil
+		}
+
+		// The zfs tool gets to record the destruction in the audit.
+		logger.Debugf("libzfs_core failed to destroy snapshots, falling back to zfs: %s", err)
+	}
+
+	snapshot := fmt.Sprintf("%s/%s@%s", poolName, path, name)
+	audited := s.auditCommand("snapshot_destroy", snapshot, "zfs", "destroy", "-r", snapshot)
+	output, err := storageRunCommandRetry(
+		"zfs",
+		"destroy",
+		"-r",
+		snapshot)
+	audited(err)
+	if err != nil {
+		logger.Errorf("zfs destroy failed: %s.", output)
+		return zfsError("Failed to destroy ZFS snapshot", snapshot, output)
+	}
+
+	return nil
+}
+
+func (s *storageZfs) zfsPoolVolumeSnapshotRestore(path string, name string) error {
+	defer s.zfsDatasetCacheInvalidate()
+
+	poolName := s.getOnDiskPoolName()
 	output, err := shared.TryRunCommand(
+		"zfs",
+		"rollback",
+		fmt.Sprintf("%s/%s@%s", poolName, path, name))
+	if err != nil {
+		logger.Errorf("zfs rollback failed: %s.", output)
+		return zfsError("Failed to restore ZFS snapshot", fmt.Sprintf("%s/%s@%s", poolName, path, name), output)
+	}
+
+	subvols, err := s.zfsPoolListSubvolumes(fmt.Sprintf("%s/%s", poolName, path))
+	if err != nil {
+		return err
+	}
+
+	if len(subvols) == 0 {
+		return nil
+	}
+
+	datasets, err := s.zfsPoolVolumeGetAll(path, "type")
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range subvols {
+		if datasets[fmt.Sprintf("%s@%s", sub, name)] == nil {
+			continue
+		}
+
+		output, err := shared.TryRunCommand(
+			"zfs",
+			"rollback",
+			fmt.Sprintf("%s/%s@%s", poolName, sub, name))
+		if err != nil {
+			logger.Errorf("zfs rollback failed: %s.", output)
+			return zfsError("Failed to restore ZFS sub-volume snapshot", fmt.Sprintf("%s/%s@%s", poolName, sub, name), output)
+		}
+	}
+
+	return nil
+}
+
+func (s *storageZfs) zfsPoolVolumeSnapshotRename(path string, oldName string, newName string) error {
+	defer s.zfsDatasetCacheInvalidate()
+
+	poolName := s.getOnDiskPoolName()
+	output, err := shared.RunCommand(
+		"zfs",
+		"rename",
+		"-r",
+		fmt.Sprintf("%s/%s@%s", poolName, path, oldName),
+		fmt.Sprintf("%s/%s@%s", poolName, path, newName))
+	if err != nil {
+		logger.Errorf("zfs snapshot rename failed: %s.", output)
+		return zfsError("Failed to rename ZFS snapshot", fmt.Sprintf("%s/%s@%s", poolName, path, oldName), output)
+	}
+
+	return nil
+}
+
+func zfsMount(poolName string, path string) error {
+	output, err := storageRunCommandRetry(
+		"zfs",
+		"mount",
+		fmt.Sprintf("%s/%s", poolName, path))
+	if err != nil {
+		return zfsError("Failed to mount ZFS filesystem", fmt.Sprintf("%s/%s", poolName, path), output)
+	}
+
+	return nil
+}
+
+func (s *storageZfs) zfsPoolVolumeMount(path string) error {
+	return zfsMount(s.getOnDiskPoolName(), path)
+}
+
+func zfsUmount(poolName string, path string, mountpoint string) error {
+	output, err := storageRunCommandRetry(
 		"zfs",
 		"unmount",
 		fmt.Sprintf("%s/%s", poolName, path))
@@ -635,7 +1139,7 @@ func (s *storageZfs) zfsPoolListSubvolumes(path string) ([]string, error) {
 		"-r", path)
 	if err != nil {
 		logger.Errorf("zfs list failed: %s.", output)
-		return []string{}, fmt.Errorf("Failed to list ZFS filesystems: %s", output)
+		return []string{}, zfsError("Failed to list ZFS filesystems", path, output)
 	}
 
 	children := []string{}
@@ -663,6 +1167,11 @@ func (s *storageZfs) zfsPoolListSnapshots(path string) ([]string, error) {
 		fullPath = fmt.Sprintf("%s/%s", poolName, path)
 	}
 
+	entry := s.zfsDatasetCacheLookup(fullPath)
+	if entry != nil && entry.datasets[fullPath] {
+		return append([]string{}, entry.snapshots[fullPath]...), nil
+	}
+
 	output, err := shared.RunCommand(
 		"zfs",
 		"list",
@@ -674,7 +1183,7 @@ func (s *storageZfs) zfsPoolListSnapshots(path string) ([]string, error) {
 		"-r", fullPath)
 	if err != nil {
 		logger.Errorf("zfs list failed: %s.", output)
-		return []string{}, fmt.Errorf("Failed to list ZFS snapshots: %s", output)
+		return []string{}, zfsError("Failed to list ZFS snapshots", fullPath, output)
 	}
 
 	children := []string{}
@@ -693,6 +1202,121 @@ func (s *storageZfs) zfsPoolListSnapshots(path string) ([]string, error) {
 	return children, nil
 }
 
+// zfsRestoredDatasetPrefix is the prefix of the datasets which hold the
+// snapshots of a container newer than the one it was restored to.
+func zfsRestoredDatasetPrefix(cName string) string {
+	return fmt.Sprintf("containers/%s.restored-", cName)
+}
+
+// zfsContainerRestoredDatasets lists the datasets which hold snapshots of a
+// container newer than the ones it was restored to.
+func (s *storageZfs) zfsContainerRestoredDatasets(cName string) ([]string, error) {
+	poolName := s.getOnDiskPoolName()
+	subvols, err := s.zfsPoolListSubvolumes(fmt.Sprintf("%s/containers", poolName))
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := zfsRestoredDatasetPrefix(cName)
+	datasets := []string{}
+	for _, subvol := range subvols {
+		if strings.HasPrefix(subvol, prefix) && !strings.Contains(strings.TrimPrefix(subvol, prefix), "/") {
+			datasets = append(datasets, subvol)
+		}
+	}
+
+	return datasets, nil
+}
+
+// zfsContainerSnapshotDataset returns the dataset holding a snapshot of a
+// container, which is the container's own unless the container was restored
+// to an older snapshot since.
+func (s *storageZfs) zfsContainerSnapshotDataset(cName string, snapOnlyName string) (string, error) {
+	fs := fmt.Sprintf("containers/%s", cName)
+	if s.zfsFilesystemEntityExists(fmt.Sprintf("%s@snapshot-%s", fs, snapOnlyName), true) {
+		return fs, nil
+	}
+
+	restored, err := s.zfsContainerRestoredDatasets(cName)
+	if err != nil {
+		return "", err
+	}
+
+	for _, dataset := range restored {
+		if s.zfsFilesystemEntityExists(fmt.Sprintf("%s@snapshot-%s", dataset, snapOnlyName), true) {
+			return dataset, nil
+		}
+	}
+
+	return fs, nil
+}
+
+// zfsContainerRestoredCleanup destroys the datasets left behind by restores
+// which don't hold any snapshot anymore. When the container itself is being
+// deleted, all of them go and those still used by clones are moved to
+// "deleted/containers" instead.
+func (s *storageZfs) zfsContainerRestoredCleanup(cName string, all bool) error {
+	restored, err := s.zfsContainerRestoredDatasets(cName)
+	if err != nil {
+		return err
+	}
+
+	for _, dataset := range restored {
+		snapshots, err := s.zfsPoolListSnapshots(dataset)
+		if err != nil {
+			return err
+		}
+
+		if len(snapshots) > 0 && !all {
+			continue
+		}
+
+		removable := true
+		for _, snapshot := range snapshots {
+			removable, err = s.zfsPoolVolumeSnapshotRemovable(dataset, snapshot)
+			if err != nil {
+				return err
+			}
+
+			if !removable {
+				break
+			}
+		}
+
+		if removable {
+			err = s.zfsPoolVolumeDestroy(dataset)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		err = s.zfsPoolVolumeRename(dataset, fmt.Sprintf("deleted/containers/%s", uuid.NewRandom().String()))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// zfsContainerSnapshotsLinear fails if some snapshots of a container are
+// held by the datasets left behind by restores. Those can't be sent along
+// with the container as they don't descend from its current state.
+func (s *storageZfs) zfsContainerSnapshotsLinear(cName string) error {
+	restored, err := s.zfsContainerRestoredDatasets(cName)
+	if err != nil {
+		return err
+	}
+
+	if len(restored) > 0 {
+		return fmt.Errorf("Some snapshots of \"%s\" are newer than the one it was restored to, it can only be transferred without its snapshots", cName)
+	}
+
+	return nil
+}
+
 func (s *storageZfs) zfsPoolVolumeSnapshotRemovable(path string, name string) (bool, error) {
 	var snap string
 	if name == "" {
@@ -713,6 +1337,96 @@ func (s *storageZfs) zfsPoolVolumeSnapshotRemovable(path string, name string) (b
 	return false, nil
 }
 
+// zfsPromotionCandidate picks the clone to promote among the comma separated
+// clones of a snapshot, preferring those which aren't themselves deleted. It
+// returns the clone relative to the pool.
+func zfsPromotionCandidate(clones string, poolName string) string {
+	candidate := ""
+	for _, clone := range strings.Split(clones, ",") {
+		clone = strings.TrimPrefix(strings.TrimSpace(clone), fmt.Sprintf("%s/", poolName))
+		if clone == "" || clone == "-" {
+			continue
+		}
+
+		if !strings.HasPrefix(clone, "deleted/") {
+			return clone
+		}
+
+		if candidate == "" {
+			candidate = clone
+		}
+	}
+
+	return candidate
+}
+
+// zfsPoolVolumePromoteClones hands the snapshots of a dataset which have
+// clones over to one of those clones with "zfs promote", so that the dataset
+// itself can be destroyed rather than kept under "deleted/". The snapshots
+// without clones are destroyed first and those with clones are renamed to
+// "copy-<uuid>", so they can't conflict with the snapshots of the promoted
+// clone and get cleaned up along with their last clone. It returns whether
+// the dataset was left without snapshots.
+func (s *storageZfs) zfsPoolVolumePromoteClones(fs string) (bool, error) {
+	poolName := s.getOnDiskPoolName()
+
+	props, err := s.zfsPoolVolumeGetAll(fs, "clones")
+	if err != nil {
+		return false, err
+	}
+
+	// Promoting only moves the snapshots of the dataset itself, not those
+	// of its sub-volumes.
+	for name := range props {
+		if name != fs && !strings.HasPrefix(name, fmt.Sprintf("%s@", fs)) {
+			return false, nil
+		}
+	}
+
+	// Snapshots are listed oldest first, so the clone is picked among
+	// those of the latest cloned snapshot and promoting it moves all of
+	// the cloned snapshots at once.
+	snapshots, err := s.zfsPoolListSnapshots(fs)
+	if err != nil {
+		return false, err
+	}
+
+	clone := ""
+	for _, snapshot := range snapshots {
+		clones := props[fmt.Sprintf("%s@%s", fs, snapshot)]["clones"]
+		candidate := zfsPromotionCandidate(clones, poolName)
+		if candidate == "" {
+			err := s.zfsPoolVolumeSnapshotDestroy(fs, snapshot)
+			if err != nil {
+				return false, err
+			}
+
+			continue
+		}
+
+		clone = candidate
+		if strings.HasPrefix(snapshot, "copy-") {
+			continue
+		}
+
+		err := s.zfsPoolVolumeSnapshotRename(fs, snapshot, fmt.Sprintf("copy-%s", uuid.NewRandom().String()))
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if clone == "" {
+		return true, nil
+	}
+
+	err = s.zfsPoolVolumePromote(clone)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
 func (s *storageZfs) zfsPoolGetUsers() ([]string, error) {
 	poolName := s.getOnDiskPoolName()
 	subvols, err := s.zfsPoolListSubvolumes(poolName)
@@ -768,3 +1482,571 @@ func zfsFilesystemEntityExists(zfsEntity string) bool {
 
 	return true
 }
+
+// zfsManagedDatasets are the top-level datasets LXD creates on a ZFS storage
+// pool.
+var zfsManagedDatasets = []string{"containers", "custom", "deleted", "images", "snapshots"}
+
+// zfsDatasetRename describes a single step of a bulk dataset rename.
+type zfsDatasetRename struct {
+	Source string `json:"source" yaml:"source"`
+	Target string `json:"target" yaml:"target"`
+}
+
+// zfsDatasetRenamePlan computes the renames needed to move the LXD managed
+// datasets from one dataset root (e.g. "tank/lxd") to another (e.g.
+// "tank/projects/default/lxd"). Only the top-level datasets are renamed as
+// "zfs rename" moves all descendants (children and snapshots) along with
+// them. The returned slice is in execution order. Reversing it and swapping
+// source and target yields the rollback plan.
+func zfsDatasetRenamePlan(oldRoot string, newRoot string, existing []string) ([]zfsDatasetRename, error) {
+	oldRoot = strings.Trim(oldRoot, "/")
+	newRoot = strings.Trim(newRoot, "/")
+
+	if oldRoot == "" || newRoot == "" {
+		return nil, fmt.Errorf("both the old and the new dataset root must be set")
+	}
+
+	if oldRoot == newRoot {
+		return nil, fmt.Errorf("the old and the new dataset root are identical")
+	}
+
+	// Datasets can't be renamed across zpools.
+	if strings.Split(oldRoot, "/")[0] != strings.Split(newRoot, "/")[0] {
+		return nil, fmt.Errorf("datasets can't be renamed across ZFS pools")
+	}
+
+	plan := []zfsDatasetRename{}
+	for _, entry := range zfsManagedDatasets {
+		source := fmt.Sprintf("%s/%s", oldRoot, entry)
+		target := fmt.Sprintf("%s/%s", newRoot, entry)
+
+		// Moving the new root below one of our own datasets would
+		// result in a dataset being renamed into itself.
+		if newRoot == source || strings.HasPrefix(newRoot, source+"/") {
+			return nil, fmt.Errorf("the new dataset root \"%s\" can't be located inside of \"%s\"", newRoot, source)
+		}
+
+		if !shared.StringInSlice(source, existing) {
+			continue
+		}
+
+		if shared.StringInSlice(target, existing) {
+			return nil, fmt.Errorf("the target dataset \"%s\" already exists", target)
+		}
+
+		plan = append(plan, zfsDatasetRename{Source: source, Target: target})
+	}
+
+	return plan, nil
+}
+
+// zfsDatasetRenameRollback returns the plan undoing the given renames.
+func zfsDatasetRenameRollback(plan []zfsDatasetRename) []zfsDatasetRename {
+	rollback := make([]zfsDatasetRename, len(plan))
+	for i, step := range plan {
+		rollback[len(plan)-1-i] = zfsDatasetRename{Source: step.Target, Target: step.Source}
+	}
+
+	return rollback
+}
+
+// zfsDatasetRenameParents returns the parents of the targets of the plan which
+// don't exist yet and get created by "zfs rename -p". The targets being
+// siblings, those are the ancestors of the new root, listed deepest first so
+// that they can be destroyed in that order.
+func zfsDatasetRenameParents(plan []zfsDatasetRename, existing []string) []string {
+	parents := []string{}
+	for _, step := range plan {
+		parent := filepath.Dir(step.Target)
+		for strings.Contains(parent, "/") && !shared.StringInSlice(parent, existing) {
+			if !shared.StringInSlice(parent, parents) {
+				parents = append(parents, parent)
+			}
+
+			parent = filepath.Dir(parent)
+		}
+	}
+
+	return parents
+}
+
+// zfsDatasetRenameUndo reverts the renames of the plan which were performed
+// and destroys the parents they got created along with them.
+func zfsDatasetRenameUndo(plan []zfsDatasetRename, parents []string) {
+	defer zfsDatasetCacheInvalidate("")
+
+	for _, undo := range zfsDatasetRenameRollback(plan) {
+		output, err := shared.TryRunCommand("zfs", "rename", undo.Source, undo.Target)
+		if err != nil {
+			logger.Errorf("Failed to revert rename of \"%s\" to \"%s\": %s. Manual cleanup needed.", undo.Source, undo.Target, output)
+		}
+	}
+
+	// Without -r, a parent which still has children is left alone.
+	for _, parent := range parents {
+		output, err := shared.TryRunCommand("zfs", "destroy", parent)
+		if err != nil {
+			logger.Errorf("Failed to destroy the dataset \"%s\" created by the rename: %s. Manual cleanup needed.", parent, output)
+		}
+	}
+}
+
+// zfsDatasetRenameApply executes a bulk rename plan, creating the missing
+// parents of the targets. If any of the renames fail, the ones that were
+// already performed are reverted and the parents destroyed. The progress
+// callback, if any, is called after each successful step.
+func zfsDatasetRenameApply(plan []zfsDatasetRename, parents []string, progress func(done int, total int)) error {
+	defer zfsDatasetCacheInvalidate("")
+
+	for i, step := range plan {
+		output, err := shared.TryRunCommand("zfs", "rename", "-p", step.Source, step.Target)
+		if err != nil {
+			logger.Errorf("zfs rename failed: %s.", output)
+
+			zfsDatasetRenameUndo(plan[:i], parents)
+
+			return fmt.Errorf("Failed to rename ZFS dataset \"%s\" to \"%s\": %s", step.Source, step.Target, output)
+		}
+
+		if progress != nil {
+			progress(i+1, len(plan))
+		}
+	}
+
+	return nil
+}
+
+// zfsPoolListDatasets lists the names of all filesystems and volumes below
+// (and including) the given dataset.
+func zfsPoolListDatasets(dataset string) ([]string, error) {
+	output, err := shared.RunCommand(
+		"zfs",
+		"list",
+		"-t", "filesystem,volume",
+		"-o", "name",
+		"-H",
+		"-r", dataset)
+	if err != nil {
+		logger.Errorf("zfs list failed: %s.", output)
+		return []string{}, zfsError("Failed to list ZFS datasets", dataset, output)
+	}
+
+	datasets := []string{}
+	for _, entry := range strings.Split(output, "\n") {
+		if entry == "" {
+			continue
+		}
+
+		datasets = append(datasets, entry)
+	}
+
+	return datasets, nil
+}
+
+// zfsPoolListSnapshotsNamed returns the snapshots called name of dataset and of
+// its descendants.
+func zfsPoolListSnapshotsNamed(dataset string, name string) ([]string, error) {
+	output, err := shared.RunCommand(
+		"zfs",
+		"list",
+		"-t", "snapshot",
+		"-o", "name",
+		"-H",
+		"-r", dataset)
+	if err != nil {
+		logger.Errorf("zfs list failed: %s.", output)
+		return []string{}, zfsError("Failed to list ZFS snapshots", dataset, output)
+	}
+
+	return zfsFilterSnapshotsNamed(strings.Split(output, "\n"), name), nil
+}
+
+// zfsFilterSnapshotsNamed returns the snapshots of the list called name.
+func zfsFilterSnapshotsNamed(snapshots []string, name string) []string {
+	result := []string{}
+	for _, snapshot := range snapshots {
+		if strings.HasSuffix(snapshot, "@"+name) {
+			result = append(result, snapshot)
+		}
+	}
+
+	return result
+}
+
+// zfsUseRefquota returns whether the quota of a storage volume with the given
+// config is set through "refquota" rather than "quota".
+func (s *storageZfs) zfsUseRefquota(volumeConfig map[string]string) bool {
+	return shared.IsTrue(s.zfsVolumeConfigGet(volumeConfig, "use_refquota"))
+}
+
+// zfsPoolVolumeQuotaConvert moves the quota of a dataset from "quota" to
+// "refquota" or the other way around.
+func (s *storageZfs) zfsPoolVolumeQuotaConvert(path string, useRefquota bool) error {
+	from := "refquota"
+	to := "quota"
+	if useRefquota {
+		from, to = to, from
+	}
+
+	value, err := s.zfsFilesystemEntityPropertyGet(path, from, true)
+	if err != nil {
+		return err
+	}
+
+	// "zfs get -p" reports an unset quota as 0.
+	if value == "0" || value == "none" || value == "-" {
+		return nil
+	}
+
+	err = s.zfsPoolVolumeSet(path, to, value)
+	if err != nil {
+		return err
+	}
+
+	return s.zfsPoolVolumeSet(path, from, "none")
+}
+
+// zfsPoolQuotaConvert converts the quotas of the containers on the storage
+// pool which don't override "volume.zfs.use_refquota".
+func (s *storageZfs) zfsPoolQuotaConvert(useRefquota bool) error {
+	volumes, err := dbStoragePoolVolumesGet(s.d.db, s.poolID, []int{storagePoolVolumeTypeContainer})
+	if err != nil && err != NoSuchObjectError {
+		return err
+	}
+
+	for _, volume := range volumes {
+		if shared.IsSnapshot(volume.Name) || volume.Config["zfs.use_refquota"] != "" {
+			continue
+		}
+
+		fs := fmt.Sprintf("containers/%s", volume.Name)
+		if !s.zfsFilesystemEntityExists(fs, true) {
+			continue
+		}
+
+		err := s.zfsPoolVolumeQuotaConvert(fs, useRefquota)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// zfsPoolVolumeReservationConfigured tells whether the volume config or the
+// pool defaults ask for a "refreservation".
+func (s *storageZfs) zfsPoolVolumeReservationConfigured(volumeConfig map[string]string) bool {
+	return s.zfsVolumeConfigGet(volumeConfig, "reservation") != "" || s.zfsVolumeConfigGet(volumeConfig, "use_reserve") != ""
+}
+
+// zfsPoolVolumeReservationApply sets the "refreservation" of a dataset with
+// the given quota. "zfs.reservation" reserves a fixed amount of space while
+// "zfs.use_reserve" reserves the whole quota.
+func (s *storageZfs) zfsPoolVolumeReservationApply(path string, volumeConfig map[string]string, quota int64) error {
+	reservation := "none"
+
+	useReserve := s.zfsVolumeConfigGet(volumeConfig, "use_reserve")
+	fixed := s.zfsVolumeConfigGet(volumeConfig, "reservation")
+
+	if fixed != "" {
+		size, err := shared.ParseByteSizeString(fixed)
+		if err != nil {
+			return err
+		}
+
+		if size > 0 {
+			reservation = fmt.Sprintf("%d", size)
+		}
+	} else if shared.IsTrue(useReserve) && quota > 0 {
+		reservation = fmt.Sprintf("%d", quota)
+	}
+
+	return s.zfsPoolVolumeSet(path, "refreservation", reservation)
+}
+
+// zfsParseVdevs returns the paths of the devices listed by
+// "zpool list -v -H -P", skipping the pool itself and the vdev groups.
+func zfsParseVdevs(output string) []string {
+	paths := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+			continue
+		}
+
+		if shared.StringInSlice(fields[0], paths) {
+			continue
+		}
+
+		paths = append(paths, fields[0])
+	}
+
+	return paths
+}
+
+// zfsPoolVdevBlocks returns the block devices (major:minor) of the disks
+// backing the given zpool. Partitions are resolved to their disk and file
+// vdevs to the disks of the filesystem holding them.
+func zfsPoolVdevBlocks(zpool string) ([]string, error) {
+	output, err := shared.RunCommand("zpool", "list", "-v", "-H", "-P", zpool)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list the devices of the ZFS pool \"%s\": %s", zpool, output)
+	}
+
+	blocks := []string{}
+	for _, path := range zfsParseVdevs(output) {
+		vdevBlocks := []string{}
+
+		if shared.IsBlockdevPath(path) {
+			_, major, minor, err := deviceGetAttributes(path)
+			if err != nil {
+				return nil, err
+			}
+
+			vdevBlocks = append(vdevBlocks, fmt.Sprintf("%d:%d", major, minor))
+		} else if shared.PathExists(path) {
+			vdevBlocks, err = deviceGetParentBlocks(path)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			continue
+		}
+
+		for _, block := range vdevBlocks {
+			block = deviceGetBlockDisk(block)
+			if !shared.StringInSlice(block, blocks) {
+				blocks = append(blocks, block)
+			}
+		}
+	}
+
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("Unable to find backing block for zfs pool: %s", zpool)
+	}
+
+	return blocks, nil
+}
+
+// zfsSendReceive pipes "zfs send" into "zfs receive" and returns the output
+// of whichever of the two failed.
+func zfsSendReceive(sendArgs []string, receiveArgs []string) error {
+	zfsSendCmd := exec.Command("zfs", append([]string{"send"}, sendArgs...)...)
+	zfsRecvCmd := exec.Command("zfs", append([]string{"receive"}, receiveArgs...)...)
+
+	sendOutput := bytes.Buffer{}
+	zfsSendCmd.Stderr = &sendOutput
+
+	recvOutput := bytes.Buffer{}
+	zfsRecvCmd.Stdout = &recvOutput
+	zfsRecvCmd.Stderr = &recvOutput
+
+	var err error
+	zfsRecvCmd.Stdin, err = zfsSendCmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	err = zfsRecvCmd.Start()
+	if err != nil {
+		return err
+	}
+
+	err = zfsSendCmd.Run()
+	if err != nil {
+		zfsRecvCmd.Wait()
+		return commandErrorNew(zfsSendCmd, sendOutput.Bytes(), err)
+	}
+
+	err = zfsRecvCmd.Wait()
+	return commandErrorNew(zfsRecvCmd, recvOutput.Bytes(), err)
+}
+
+// zfsSendFile writes the stream of "zfs send" to a file.
+func zfsSendFile(sendArgs []string, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	output := bytes.Buffer{}
+	cmd := exec.Command("zfs", append([]string{"send"}, sendArgs...)...)
+	cmd.Stdout = f
+	cmd.Stderr = &output
+
+	err = cmd.Run()
+	if err != nil {
+		os.Remove(path)
+		return commandErrorNew(cmd, output.Bytes(), err)
+	}
+
+	return f.Sync()
+}
+
+// zfsReceiveFile feeds a stream written by zfsSendFile to "zfs receive".
+func zfsReceiveFile(receiveArgs []string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	output := bytes.Buffer{}
+	cmd := exec.Command("zfs", append([]string{"receive"}, receiveArgs...)...)
+	cmd.Stdin = f
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err = cmd.Run()
+	return commandErrorNew(cmd, output.Bytes(), err)
+}
+
+// Size of the chunks in which the output of "zfs send" is buffered when
+// several sends run ahead of their receive, and how many of them are kept
+// for each stream.
+const zfsSendBufferChunkSize = 1024 * 1024
+const zfsSendBufferChunks = 16
+
+// zfsTransfer is the arguments of a "zfs send" and of the "zfs receive" its
+// stream is piped into.
+type zfsTransfer struct {
+	send    []string
+	receive []string
+}
+
+// zfsSendStream is a "zfs send" whose output is buffered in memory until it
+// is read by the matching "zfs receive".
+type zfsSendStream struct {
+	cmd    *exec.Cmd
+	output bytes.Buffer
+	chunks chan []byte
+	chunk  []byte
+	err    error
+}
+
+func zfsSendStart(sendArgs []string) (*zfsSendStream, error) {
+	stream := &zfsSendStream{
+		cmd:    exec.Command("zfs", append([]string{"send"}, sendArgs...)...),
+		chunks: make(chan []byte, zfsSendBufferChunks),
+	}
+	stream.cmd.Stderr = &stream.output
+
+	stdout, err := stream.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = stream.cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for {
+			buf := make([]byte, zfsSendBufferChunkSize)
+			n, err := io.ReadFull(stdout, buf)
+			if n > 0 {
+				stream.chunks <- buf[:n]
+			}
+
+			if err != nil {
+				break
+			}
+		}
+
+		err := stream.cmd.Wait()
+		stream.err = commandErrorNew(stream.cmd, stream.output.Bytes(), err)
+		close(stream.chunks)
+	}()
+
+	return stream, nil
+}
+
+// Read implements io.Reader on top of the buffered chunks.
+func (stream *zfsSendStream) Read(p []byte) (int, error) {
+	for len(stream.chunk) == 0 {
+		chunk, ok := <-stream.chunks
+		if !ok {
+			return 0, io.EOF
+		}
+
+		stream.chunk = chunk
+	}
+
+	n := copy(p, stream.chunk)
+	stream.chunk = stream.chunk[n:]
+	return n, nil
+}
+
+// Wait stops reading the stream and returns the error of "zfs send".
+func (stream *zfsSendStream) Wait() error {
+	for range stream.chunks {
+	}
+
+	return stream.err
+}
+
+// Kill stops "zfs send" when its stream isn't needed anymore.
+func (stream *zfsSendStream) Kill() {
+	stream.cmd.Process.Kill()
+	stream.Wait()
+}
+
+// zfsSendReceiveMany runs the transfers, which must be received in order as
+// they're usually incremental. Up to parallelism sends are running at the
+// same time, with the ones ahead of the current receive being buffered.
+func zfsSendReceiveMany(transfers []zfsTransfer, parallelism int) error {
+	if parallelism <= 1 {
+		for _, transfer := range transfers {
+			err := zfsSendReceive(transfer.send, transfer.receive)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	streams := make([]*zfsSendStream, len(transfers))
+	started := 0
+	defer func() {
+		for _, stream := range streams[:started] {
+			if stream != nil {
+				stream.Kill()
+			}
+		}
+	}()
+
+	for i, transfer := range transfers {
+		for started < len(transfers) && started < i+parallelism {
+			stream, err := zfsSendStart(transfers[started].send)
+			if err != nil {
+				return err
+			}
+
+			streams[started] = stream
+			started++
+		}
+
+		zfsRecvCmd := exec.Command("zfs", append([]string{"receive"}, transfer.receive...)...)
+		recvOutput := bytes.Buffer{}
+		zfsRecvCmd.Stdin = streams[i]
+		zfsRecvCmd.Stdout = &recvOutput
+		zfsRecvCmd.Stderr = &recvOutput
+
+		err := zfsRecvCmd.Run()
+		if err != nil {
+			return commandErrorNew(zfsRecvCmd, recvOutput.Bytes(), err)
+		}
+
+		err = streams[i].Wait()
+		streams[i] = nil
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
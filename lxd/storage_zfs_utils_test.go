@@ -0,0 +1,368 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lxc/lxd/shared/api"
+)
+
+func TestZfsDatasetRenamePlan(t *testing.T) {
+	existing := []string{
+		"tank",
+		"tank/lxd",
+		"tank/lxd/containers",
+		"tank/lxd/containers/c1",
+		"tank/lxd/images",
+		"tank/lxd/deleted",
+	}
+
+	plan, err := zfsDatasetRenamePlan("tank/lxd", "tank/default/lxd", existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []zfsDatasetRename{
+		{Source: "tank/lxd/containers", Target: "tank/default/lxd/containers"},
+		{Source: "tank/lxd/deleted", Target: "tank/default/lxd/deleted"},
+		{Source: "tank/lxd/images", Target: "tank/default/lxd/images"},
+	}
+
+	if len(plan) != len(expected) {
+		t.Fatalf("Expected %d renames, got %d", len(expected), len(plan))
+	}
+
+	for i := range expected {
+		if plan[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected[i], plan[i])
+		}
+	}
+
+	rollback := zfsDatasetRenameRollback(plan)
+	if rollback[0].Source != "tank/default/lxd/images" || rollback[0].Target != "tank/lxd/images" {
+		t.Errorf("Unexpected first rollback step: %v", rollback[0])
+	}
+}
+
+func TestZfsDatasetRenameParents(t *testing.T) {
+	existing := []string{"tank", "tank/projects", "tank/lxd", "tank/lxd/containers", "tank/lxd/images"}
+
+	plan, err := zfsDatasetRenamePlan("tank/lxd", "tank/projects/default/lxd", existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parents := zfsDatasetRenameParents(plan, existing)
+	if !reflect.DeepEqual(parents, []string{"tank/projects/default/lxd", "tank/projects/default"}) {
+		t.Errorf("Unexpected parents: %v", parents)
+	}
+
+	// Nothing gets created under an existing root.
+	plan, err = zfsDatasetRenamePlan("tank/lxd", "tank/projects", existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parents = zfsDatasetRenameParents(plan, existing)
+	if len(parents) != 0 {
+		t.Errorf("Unexpected parents: %v", parents)
+	}
+}
+
+func TestZfsDatasetRenamePlanInvalid(t *testing.T) {
+	existing := []string{"tank/lxd/containers", "other/lxd/containers"}
+
+	_, err := zfsDatasetRenamePlan("tank/lxd", "other/lxd", existing)
+	if err == nil {
+		t.Error("Expected renaming across zpools to fail")
+	}
+
+	_, err = zfsDatasetRenamePlan("tank/lxd", "tank/lxd/containers/nested", existing)
+	if err == nil {
+		t.Error("Expected renaming into a managed dataset to fail")
+	}
+
+	_, err = zfsDatasetRenamePlan("tank/lxd", "tank/lxd", existing)
+	if err == nil {
+		t.Error("Expected renaming to the same root to fail")
+	}
+}
+
+func TestZfsParsePropertyList(t *testing.T) {
+	output := "tank/lxd/containers/c1\torigin\ttank/lxd/images/abc@readonly\n" +
+		"tank/lxd/containers/c1\tclones\t\n" +
+		"tank/lxd/containers/c1@snapshot-s1\torigin\t-\n" +
+		"tank/lxd/containers/c1@snapshot-s1\tclones\ttank/lxd/containers/c2\n"
+
+	props, err := zfsParsePropertyList(output, "tank/lxd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(props) != 2 {
+		t.Fatalf("Expected 2 datasets, got %d", len(props))
+	}
+
+	if props["containers/c1"]["origin"] != "tank/lxd/images/abc@readonly" {
+		t.Errorf("Unexpected origin: %q", props["containers/c1"]["origin"])
+	}
+
+	if props["containers/c1"]["clones"] != "" {
+		t.Errorf("Unexpected clones: %q", props["containers/c1"]["clones"])
+	}
+
+	if props["containers/c1@snapshot-s1"]["clones"] != "tank/lxd/containers/c2" {
+		t.Errorf("Unexpected snapshot clones: %q", props["containers/c1@snapshot-s1"]["clones"])
+	}
+
+	_, err = zfsParsePropertyList("tank/lxd/containers/c1 origin", "tank/lxd")
+	if err == nil {
+		t.Error("Expected malformed output to fail")
+	}
+}
+
+func TestZfsDatasetCacheParse(t *testing.T) {
+	output := "tank/lxd\n" +
+		"tank/lxd/containers\n" +
+		"tank/lxd/containers/c1\n" +
+		"tank/lxd/containers/c1@snapshot-s1\n" +
+		"tank/lxd/containers/c1@snapshot-s2\n"
+
+	entry := zfsDatasetCacheParse(output)
+	if !entry.datasets["tank/lxd/containers/c1"] || !entry.datasets["tank/lxd/containers/c1@snapshot-s2"] {
+		t.Errorf("Missing datasets in %v", entry.datasets)
+	}
+
+	if entry.datasets["tank/lxd/containers/c2"] {
+		t.Error("Unexpected dataset tank/lxd/containers/c2")
+	}
+
+	snapshots := entry.snapshots["tank/lxd/containers/c1"]
+	if len(snapshots) != 2 || snapshots[0] != "snapshot-s1" || snapshots[1] != "snapshot-s2" {
+		t.Errorf("Unexpected snapshots: %v", snapshots)
+	}
+}
+
+func TestZfsDatasetCacheInvalidate(t *testing.T) {
+	zfsDatasetCache["tank/lxd"] = zfsDatasetCacheParse("tank/lxd\n")
+	zfsDatasetCache["other"] = zfsDatasetCacheParse("other\n")
+
+	zfsDatasetCacheInvalidate("tank/lxd/containers/c1@snapshot-s1")
+	if zfsDatasetCache["tank/lxd"] != nil {
+		t.Error("Expected tank/lxd to be invalidated")
+	}
+
+	if zfsDatasetCache["other"] == nil {
+		t.Error("Expected other to be kept")
+	}
+
+	zfsDatasetCacheInvalidate("")
+	if len(zfsDatasetCache) != 0 {
+		t.Errorf("Expected an empty cache, got %v", zfsDatasetCache)
+	}
+}
+
+func TestZfsParsePoolStatus(t *testing.T) {
+	output := `  pool: tank
+ state: DEGRADED
+status: One or more devices could not be opened.
+config:
+
+	NAME        STATE     READ WRITE CKSUM
+	tank        DEGRADED     0     0     0
+	  mirror-0  DEGRADED     0     0     0
+
+errors: No known data errors
+
+  pool: backup
+ state: FAULTED
+status: One or more devices are faulted.
+`
+
+	states := zfsParsePoolStatus(output)
+	if len(states) != 2 {
+		t.Fatalf("Expected 2 unhealthy pools, got %d", len(states))
+	}
+
+	if states["tank"] != "DEGRADED" {
+		t.Errorf("Expected tank to be DEGRADED, got %q", states["tank"])
+	}
+
+	if states["backup"] != "FAULTED" {
+		t.Errorf("Expected backup to be FAULTED, got %q", states["backup"])
+	}
+
+	states = zfsParsePoolStatus("all pools are healthy\n")
+	if len(states) != 0 {
+		t.Errorf("Expected no unhealthy pools, got %v", states)
+	}
+}
+
+func TestZfsParseZpoolState(t *testing.T) {
+	state := zfsParseZpoolState("tank", "pool 'tank' is healthy\n")
+	if state != "ONLINE" {
+		t.Errorf("Expected tank to be ONLINE, got %q", state)
+	}
+
+	state = zfsParseZpoolState("tank", "  pool: tank\n state: DEGRADED\nstatus: One or more devices could not be opened.\n")
+	if state != "DEGRADED" {
+		t.Errorf("Expected tank to be DEGRADED, got %q", state)
+	}
+
+	// Another zpool being healthy says nothing about this one.
+	state = zfsParseZpoolState("tank", "pool 'tank2' is healthy\n")
+	if state != "UNAVAIL" {
+		t.Errorf("Expected tank to be UNAVAIL, got %q", state)
+	}
+}
+
+func TestZfsParseDiff(t *testing.T) {
+	mountpoint := "/var/lib/lxd/storage-pools/default/containers/c1"
+	output := "M\tF\t" + mountpoint + "/rootfs/etc/hosts\n"
+	output += "+\t/\t" + mountpoint + "/rootfs/tmp/new\n"
+	output += "R\tF\t" + mountpoint + "/rootfs/tmp/a\t" + mountpoint + "/rootfs/tmp/b\n"
+	output += "-\t@\t" + mountpoint + "/rootfs/usr/bin/link\n"
+	output += "garbage\n"
+
+	changes := zfsParseDiff(output, mountpoint)
+	if len(changes) != 4 {
+		t.Fatalf("Expected 4 changes, got %d", len(changes))
+	}
+
+	expected := []api.ContainerFileChange{
+		{Change: "modified", FileType: "file", Path: "rootfs/etc/hosts"},
+		{Change: "created", FileType: "directory", Path: "rootfs/tmp/new"},
+		{Change: "renamed", FileType: "file", Path: "rootfs/tmp/a", NewPath: "rootfs/tmp/b"},
+		{Change: "removed", FileType: "symlink", Path: "rootfs/usr/bin/link"},
+	}
+
+	for i, change := range changes {
+		if change != expected[i] {
+			t.Errorf("Expected %v, got %v", expected[i], change)
+		}
+	}
+}
+
+func TestZfsParseVdevs(t *testing.T) {
+	output := "tank\t19.9G\t1.2G\t18.7G\t-\t2%\t6%\t1.00x\tONLINE\t-\n"
+	output += "\tmirror\t9.94G\t600M\t9.35G\t-\t2%\t6%\n"
+	output += "\t/dev/sdb1\t-\t-\t-\t-\t-\t-\n"
+	output += "\t/dev/sdc1\t-\t-\t-\t-\t-\t-\n"
+	output += "\t/var/lib/lxd/disks/tank.img\t9.94G\t600M\t9.35G\t-\t2%\t6%\n"
+	output += "log\t-\t-\t-\t-\t-\t-\n"
+	output += "\t/dev/sdb1\t-\t-\t-\t-\t-\t-\n"
+
+	paths := zfsParseVdevs(output)
+
+	expected := []string{"/dev/sdb1", "/dev/sdc1", "/var/lib/lxd/disks/tank.img"}
+	if len(paths) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, paths)
+	}
+
+	for i, path := range paths {
+		if path != expected[i] {
+			t.Errorf("Expected %q, got %q", expected[i], path)
+		}
+	}
+}
+
+func TestZfsPromotionCandidate(t *testing.T) {
+	tests := map[string]string{
+		"":                       "",
+		"-":                      "",
+		"tank/lxd/containers/c2": "containers/c2",
+		"tank/lxd/deleted/containers/abc,tank/lxd/containers/c3":        "containers/c3",
+		"tank/lxd/deleted/containers/abc,tank/lxd/deleted/containers/d": "deleted/containers/abc",
+	}
+
+	for clones, expected := range tests {
+		candidate := zfsPromotionCandidate(clones, "tank/lxd")
+		if candidate != expected {
+			t.Errorf("Expected %q for %q, got %q", expected, clones, candidate)
+		}
+	}
+}
+
+func TestZfsDependentClones(t *testing.T) {
+	output := "tank\t-\n" +
+		"tank/lxd\t-\n" +
+		"tank/lxd/custom/v1\t-\n" +
+		"tank/lxd/custom/v1@snap0\t-\n" +
+		"tank/lxd/custom/v2\ttank/lxd/custom/v1@snap0\n" +
+		"tank/lxd/images/abc\t-\n" +
+		"tank/lxd/images/abc@readonly\t-\n" +
+		"tank/lxd/containers/c1\ttank/lxd/images/abc@readonly\n" +
+		"tank/backup\ttank/lxd/containers/c1@snapshot-snap0\n"
+
+	origins, err := zfsParseOrigins(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	datasets := zfsDestroyedDatasets(origins, "tank/lxd/custom/v1")
+	if len(datasets) != 2 || datasets[0] != "tank/lxd/custom/v1" || datasets[1] != "tank/lxd/custom/v1@snap0" {
+		t.Errorf("Unexpected destroyed datasets: %v", datasets)
+	}
+
+	clones := zfsDependentClones(origins, "tank/lxd/custom/v1")
+	if len(clones) != 1 || clones[0] != "tank/lxd/custom/v2" {
+		t.Errorf("Unexpected clones of the volume: %v", clones)
+	}
+
+	// Clones within the destroyed tree don't block it.
+	clones = zfsDependentClones(origins, "tank/lxd")
+	if len(clones) != 1 || clones[0] != "tank/backup" {
+		t.Errorf("Unexpected clones of the pool: %v", clones)
+	}
+
+	// "tank/lxd/custom/v10" isn't below "tank/lxd/custom/v1".
+	if zfsDatasetBelow("tank/lxd/custom/v10", "tank/lxd/custom/v1") {
+		t.Error("Expected a sibling with a common prefix not to be below the dataset")
+	}
+
+	_, err = zfsParseOrigins("tank/lxd\n")
+	if err == nil {
+		t.Error("Expected a line without origin to fail")
+	}
+}
+
+func TestZfsLocalPropertiesParse(t *testing.T) {
+	output := "quota\t10737418240\ncompression\tlz4\nmountpoint\tnone\nrecordsize\t16384\n"
+
+	properties := zfsLocalPropertiesParse(output)
+	expected := map[string]string{
+		"quota":       "10737418240",
+		"compression": "lz4",
+		"recordsize":  "16384",
+	}
+
+	if len(properties) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, properties)
+	}
+
+	for key, value := range expected {
+		if properties[key] != value {
+			t.Errorf("Expected %s=%s, got %q", key, value, properties[key])
+		}
+	}
+}
+
+func TestZfsFilterSnapshotsNamed(t *testing.T) {
+	snapshots := []string{
+		"tank/containers/c1@snapshot-snap0",
+		"tank/containers/c1@snapshot-snap01",
+		"tank/containers/c1/nested@snapshot-snap0",
+		"tank/containers/c1/nested@other",
+		"",
+	}
+
+	result := zfsFilterSnapshotsNamed(snapshots, "snapshot-snap0")
+	if !reflect.DeepEqual(result, []string{"tank/containers/c1@snapshot-snap0", "tank/containers/c1/nested@snapshot-snap0"}) {
+		t.Errorf("Unexpected snapshots: %v", result)
+	}
+
+	result = zfsFilterSnapshotsNamed(snapshots, "missing")
+	if len(result) != 0 {
+		t.Errorf("Unexpected snapshots: %v", result)
+	}
+}
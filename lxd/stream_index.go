@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/lxc/lxd/shared"
+)
+
+/* Exported streams, like container backups, carry an index of the size and
+ * SHA-256 checksum of each of their files. The index is checked before
+ * anything is created from an imported stream, so that a file corrupted in
+ * storage or in transit is caught rather than turned into a broken container.
+ */
+
+// streamIndexEntry is the size and checksum of one file of a stream.
+type streamIndexEntry struct {
+	Size   int64  `yaml:"size"`
+	SHA256 string `yaml:"sha256"`
+}
+
+// streamIndexFile returns the size and checksum of a file.
+func streamIndexFile(path string) (streamIndexEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return streamIndexEntry{}, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return streamIndexEntry{}, err
+	}
+
+	return streamIndexEntry{Size: size, SHA256: fmt.Sprintf("%x", hash.Sum(nil))}, nil
+}
+
+// streamIndexWalk calls fn with the path, relative to root, of each regular
+// file below root which isn't in skip.
+func streamIndexWalk(root string, skip []string, fn func(rel string, info os.FileInfo) error) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		if shared.StringInSlice(rel, skip) {
+			return nil
+		}
+
+		return fn(rel, info)
+	})
+}
+
+// streamIndexBuild returns the index of the regular files below root, keyed
+// by their path relative to it. The files in skip, like the index itself, are
+// left out.
+func streamIndexBuild(root string, skip ...string) (map[string]streamIndexEntry, error) {
+	index := map[string]streamIndexEntry{}
+
+	err := streamIndexWalk(root, skip, func(rel string, info os.FileInfo) error {
+		entry, err := streamIndexFile(filepath.Join(root, rel))
+		if err != nil {
+			return err
+		}
+
+		index[rel] = entry
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// streamIndexVerify checks the regular files below root against their index,
+// failing on files which are missing, unexpected, truncated or corrupted.
+func streamIndexVerify(root string, index map[string]streamIndexEntry, skip ...string) error {
+	seen := map[string]bool{}
+
+	err := streamIndexWalk(root, skip, func(rel string, info os.FileInfo) error {
+		expected, ok := index[rel]
+		if !ok {
+			return fmt.Errorf("Unexpected file \"%s\" isn't in the index", rel)
+		}
+		seen[rel] = true
+
+		// Comparing sizes first spares checksumming truncated files.
+		if info.Size() != expected.Size {
+			return fmt.Errorf("Size mismatch for \"%s\": expected %d bytes, got %d", rel, expected.Size, info.Size())
+		}
+
+		entry, err := streamIndexFile(filepath.Join(root, rel))
+		if err != nil {
+			return err
+		}
+
+		if entry.SHA256 != expected.SHA256 {
+			return fmt.Errorf("Checksum mismatch for \"%s\": expected %s, got %s", rel, expected.SHA256, entry.SHA256)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for rel := range index {
+		if !seen[rel] {
+			return fmt.Errorf("Missing file \"%s\"", rel)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStreamIndex(t *testing.T) {
+	root, err := ioutil.TempDir("", "lxd_stream_index_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	err = os.MkdirAll(filepath.Join(root, "snapshots"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"index.yaml":         "name: c1\n",
+		"container.bin":      "abc",
+		"snapshots/snap.bin": "",
+	}
+
+	for name, content := range files {
+		err = ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	index, err := streamIndexBuild(root, "index.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(index) != 2 {
+		t.Fatalf("Expected 2 files in the index, got %d", len(index))
+	}
+
+	expected := streamIndexEntry{Size: 3, SHA256: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"}
+	if index["container.bin"] != expected {
+		t.Errorf("Unexpected entry for container.bin: %v", index["container.bin"])
+	}
+
+	err = streamIndexVerify(root, index, "index.yaml")
+	if err != nil {
+		t.Errorf("Expected the files to match the index: %s", err)
+	}
+
+	// Same size, different content.
+	err = ioutil.WriteFile(filepath.Join(root, "container.bin"), []byte("abd"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = streamIndexVerify(root, index, "index.yaml")
+	if err == nil {
+		t.Error("Expected a corrupted file to fail the verification")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(root, "container.bin"), []byte("ab"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = streamIndexVerify(root, index, "index.yaml")
+	if err == nil {
+		t.Error("Expected a truncated file to fail the verification")
+	}
+
+	err = os.Remove(filepath.Join(root, "container.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = streamIndexVerify(root, index, "index.yaml")
+	if err == nil {
+		t.Error("Expected a missing file to fail the verification")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(root, "container.bin"), []byte("abc"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(root, "extra"), []byte("abc"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = streamIndexVerify(root, index, "index.yaml")
+	if err == nil {
+		t.Error("Expected an unexpected file to fail the verification")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// systemdNotify sends a state change (e.g. "READY=1") to systemd when LXD
+// runs as a Type=notify unit, it does nothing otherwise.
+func systemdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// Abstract sockets are given with a leading "@".
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// systemdWatchdogInterval returns how often systemd expects to hear from
+// the daemon, zero if the watchdog isn't enabled for it.
+func systemdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	pid := os.Getenv("WATCHDOG_PID")
+	if pid != "" && pid != fmt.Sprintf("%d", os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// systemdHealthCheck checks that the database and the REST API both still
+// answer within the timeout.
+func systemdHealthCheck(d *Daemon, timeout time.Duration) error {
+	_, err := d.db.Exec("SELECT 1")
+	if err != nil {
+		return err
+	}
+
+	if d.UnixSocket == nil {
+		return nil
+	}
+
+	path := d.UnixSocket.Socket.Addr().String()
+	client := http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Dial: func(network string, addr string) (net.Conn, error) {
+				return net.DialTimeout("unix", path, timeout)
+			},
+		},
+	}
+
+	resp, err := client.Get("http://unix.socket/1.0")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("The REST API returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// systemdWatchdog pings the systemd watchdog at half its interval for as
+// long as the daemon is healthy, so that systemd restarts it if it wedges.
+func systemdWatchdog(d *Daemon) {
+	interval := systemdWatchdogInterval()
+	if interval == 0 {
+		return
+	}
+
+	logger.Info("Pinging the systemd watchdog", log.Ctx{"interval": interval / 2})
+
+	for {
+		time.Sleep(interval / 2)
+
+		err := systemdHealthCheck(d, interval/4)
+		if err != nil {
+			logger.Error("Health check failed, not pinging the systemd watchdog", log.Ctx{"err": err})
+			continue
+		}
+
+		err = systemdNotify("WATCHDOG=1")
+		if err != nil {
+			logger.Debug("Failed to ping the systemd watchdog", log.Ctx{"err": err})
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_systemdNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lxd_systemd_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", path)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	err = systemdNotify("READY=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf[:n]) != "READY=1" {
+		t.Errorf("Unexpected notification: %s", string(buf[:n]))
+	}
+}
+
+func Test_systemdWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	os.Setenv("WATCHDOG_PID", fmt.Sprintf("%d", os.Getpid()))
+	if systemdWatchdogInterval() != 30*time.Second {
+		t.Errorf("Expected a 30s interval, got %s", systemdWatchdogInterval())
+	}
+
+	os.Setenv("WATCHDOG_PID", fmt.Sprintf("%d", os.Getpid()+1))
+	if systemdWatchdogInterval() != 0 {
+		t.Error("Expected the watchdog of another process to be ignored")
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pborman/uuid"
+
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/logger"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// The types of transfers.
+const (
+	transferMigrationSource = "migration-source"
+	transferMigrationTarget = "migration-target"
+	transferCopy            = "copy"
+	transferRelay           = "relay"
+)
+
+// transfers holds the migrations and copies of containers in flight, which a
+// shutdown of the host would leave half done.
+var transfers = map[string]api.Transfer{}
+var transfersLock sync.Mutex
+
+// transfersShuttingDown is set once LXD is shutting down, no transfer may
+// start from then on.
+var transfersShuttingDown bool
+
+// transfersInhibitor is the stdin of the systemd-inhibit process holding a
+// shutdown inhibitor lock for as long as there are transfers in flight.
+var transfersInhibitor io.WriteCloser
+
+// transferStart records the start of a transfer and returns the function to
+// call once it's done.
+func transferStart(type_ string, containerName string) (func(), error) {
+	transfersLock.Lock()
+	defer transfersLock.Unlock()
+
+	if transfersShuttingDown {
+		return nil, fmt.Errorf("LXD is shutting down")
+	}
+
+	t := api.Transfer{
+		ID:        uuid.NewRandom().String(),
+		Type:      type_,
+		Container: containerName,
+		StartedAt: time.Now(),
+	}
+
+	transfers[t.ID] = t
+	if len(transfers) == 1 {
+		transfersInhibit()
+	}
+
+	return func() {
+		transfersLock.Lock()
+		defer transfersLock.Unlock()
+
+		delete(transfers, t.ID)
+		if len(transfers) == 0 && transfersInhibitor != nil {
+			transfersInhibitor.Close()
+			transfersInhibitor = nil
+		}
+	}, nil
+}
+
+// transfersInhibit takes a systemd inhibitor lock of the mode set in
+// "core.shutdown_inhibit", so that the host isn't shut down or suspended in
+// the middle of a transfer. Root can still override it, for example with
+// "systemctl reboot -i".
+func transfersInhibit() {
+	mode := "block"
+	key, ok := daemonConfig["core.shutdown_inhibit"]
+	if ok {
+		mode = key.Get()
+	}
+
+	if mode == "none" {
+		return
+	}
+
+	path, err := exec.LookPath("systemd-inhibit")
+	if err != nil {
+		return
+	}
+
+	// The lock is held by cat, which exits once its stdin is closed.
+	cmd := exec.Command(path, "--what=shutdown:sleep", "--who=LXD", "--why=Containers are being migrated or copied", fmt.Sprintf("--mode=%s", mode), "cat")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		logger.Warn("Failed to take a shutdown inhibitor lock", log.Ctx{"err": err})
+		return
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		logger.Warn("Failed to take a shutdown inhibitor lock", log.Ctx{"err": err})
+		return
+	}
+
+	go cmd.Wait()
+	transfersInhibitor = stdin
+}
+
+// transfersList returns the transfers in flight, oldest first.
+func transfersList() []api.Transfer {
+	transfersLock.Lock()
+	defer transfersLock.Unlock()
+
+	list := []api.Transfer{}
+	for _, t := range transfers {
+		list = append(list, t)
+	}
+
+	sort.Sort(transfersByAge(list))
+	return list
+}
+
+type transfersByAge []api.Transfer
+
+func (a transfersByAge) Len() int           { return len(a) }
+func (a transfersByAge) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a transfersByAge) Less(i, j int) bool { return a[i].StartedAt.Before(a[j].StartedAt) }
+
+// transfersWait stops new transfers from starting and waits for the ones in
+// flight to be done, for up to "core.shutdown_transfers_timeout" seconds.
+func transfersWait() {
+	transfersLock.Lock()
+	transfersShuttingDown = true
+	transfersLock.Unlock()
+
+	timeout := time.Duration(daemonConfig["core.shutdown_transfers_timeout"].GetInt64()) * time.Second
+	deadline := time.Now().Add(timeout)
+
+	count := -1
+	for {
+		list := transfersList()
+		if len(list) == 0 {
+			return
+		}
+
+		if !time.Now().Before(deadline) {
+			logger.Warn("Shutting down with transfers still in flight", log.Ctx{"transfers": len(list)})
+			return
+		}
+
+		if len(list) != count {
+			count = len(list)
+			logger.Info("Waiting for transfers to be done before shutting down", log.Ctx{"transfers": count})
+			systemdNotify(fmt.Sprintf("STATUS=Waiting for %d transfers", count))
+		}
+
+		time.Sleep(time.Second)
+	}
+}
+
+// /1.0/transfers
+// List the migrations and copies of containers in flight.
+func transfersGet(d *Daemon, r *http.Request) Response {
+	return SyncResponse(true, transfersList())
+}
+
+// /1.0/transfers
+// Relay the migration of a container between two hosts which can't reach
+// each other, by connecting to the websockets of the migration operations of
+// both and passing the messages through as they are.
+func transfersPost(d *Daemon, r *http.Request) Response {
+	req := api.TransfersPost{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	if req.Source.Operation == "" || req.Target.Operation == "" {
+		return BadRequest(fmt.Errorf("The operations of the source and target are required"))
+	}
+
+	if len(req.Source.Websockets) != len(req.Target.Websockets) {
+		return BadRequest(fmt.Errorf("The source and target have different websockets"))
+	}
+
+	for name := range req.Source.Websockets {
+		_, ok := req.Target.Websockets[name]
+		if !ok {
+			return BadRequest(fmt.Errorf("The target is missing the %s websocket", name))
+		}
+	}
+
+	run := func(op *operation) error {
+		return transferRelayRun(req)
+	}
+
+	op, err := operationCreate(operationClassTask, nil, nil, run, nil, nil)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return OperationResponse(op)
+}
+
+// transferRelayRun connects to the source and target of a migration and
+// relays the websockets between them until they're all closed. Whether the
+// migration succeeded is up to the operations of the source and target.
+func transferRelayRun(req api.TransfersPost) error {
+	done, err := transferStart(transferRelay, req.Container)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	sourceConns, err := migrationDial(req.Source)
+	if err != nil {
+		return fmt.Errorf("Failed to connect to the source: %v", err)
+	}
+
+	targetConns, err := migrationDial(req.Target)
+	if err != nil {
+		for _, conn := range sourceConns {
+			conn.Close()
+		}
+
+		return fmt.Errorf("Failed to connect to the target: %v", err)
+	}
+
+	// The messages are passed through as they are, the zfs streams
+	// included, so the relay doesn't need to speak the protocol.
+	relays := []chan bool{}
+	for name, conn := range sourceConns {
+		relays = append(relays, shared.WebsocketProxy(conn, targetConns[name]))
+	}
+
+	for _, relay := range relays {
+		<-relay
+	}
+
+	return nil
+}
+
+var transfersCmd = Command{name: "transfers", get: transfersGet, post: transfersPost}
@@ -0,0 +1,201 @@
+// Package agent implements the protocol spoken between LXD and lxd-agent, the
+// guest agent which can run inside containers, over a unix socket.
+//
+// Everything sent over the socket is a frame: the channel it belongs to as a
+// byte, the length of the data as a big endian 32 bits integer and the data.
+// A connection carries a single request, sent as JSON on ChannelControl by
+// LXD, and is done with the response of the agent, sent as JSON on
+// ChannelControl too. The data of files and processes is sent on the other
+// channels, an empty frame marking the end of a stream.
+package agent
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// SocketPath is where the agent listens within the container.
+const SocketPath = "/dev/lxd-agent.sock"
+
+// The channels of the frames.
+const (
+	ChannelStdin   byte = 0
+	ChannelStdout  byte = 1
+	ChannelStderr  byte = 2
+	ChannelControl byte = 3
+)
+
+// MaxFrameSize is the largest amount of data sent in a single frame.
+const MaxFrameSize = 32 * 1024
+
+// The types of requests.
+const (
+	RequestPing    = "ping"
+	RequestExec    = "exec"
+	RequestFileGet = "file_get"
+	RequestFilePut = "file_put"
+)
+
+// Request is what LXD asks the agent to do.
+type Request struct {
+	Type string `json:"type"`
+
+	// For "exec", the stdin of the process is sent on ChannelStdin while
+	// its stdout and stderr come back on ChannelStdout and ChannelStderr.
+	Command     []string          `json:"command,omitempty"`
+	Environment map[string]string `json:"environment,omitempty"`
+	Cwd         string            `json:"cwd,omitempty"`
+
+	// For "file_get" and "file_put". The content of files and the target
+	// of symlinks are sent on ChannelStdout by "file_get", between a first
+	// response describing the file and the final one, and on ChannelStdin
+	// for "file_put".
+	Path string `json:"path,omitempty"`
+
+	// For "file_put", -1 leaves the owner and mode as they are.
+	FileType string `json:"file_type,omitempty"`
+	UID      int64  `json:"uid"`
+	GID      int64  `json:"gid"`
+	Mode     int    `json:"mode"`
+	Write    string `json:"write,omitempty"`
+}
+
+// Response is what the agent answers once done with a request.
+type Response struct {
+	Error    string `json:"error,omitempty"`
+	NotFound bool   `json:"not_found,omitempty"`
+
+	// For "ping".
+	Version string `json:"version,omitempty"`
+
+	// For "exec".
+	Return int `json:"return"`
+
+	// For "file_get", sent before the content of the file.
+	UID      int64    `json:"uid"`
+	GID      int64    `json:"gid"`
+	Mode     int      `json:"mode"`
+	FileType string   `json:"file_type,omitempty"`
+	Entries  []string `json:"entries,omitempty"`
+}
+
+// Conn sends and receives frames over a connection. Frames can be sent from
+// several goroutines at once but only received from one.
+type Conn struct {
+	rw   io.ReadWriter
+	lock sync.Mutex
+}
+
+// NewConn wraps a connection to or from the agent.
+func NewConn(rw io.ReadWriter) *Conn {
+	return &Conn{rw: rw}
+}
+
+// WriteFrame sends data on a channel, split in several frames if needed. No
+// data sends an empty frame, ending the stream of the channel.
+func (c *Conn) WriteFrame(channel byte, data []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for {
+		size := len(data)
+		if size > MaxFrameSize {
+			size = MaxFrameSize
+		}
+
+		header := make([]byte, 5)
+		header[0] = channel
+		binary.BigEndian.PutUint32(header[1:], uint32(size))
+
+		_, err := c.rw.Write(append(header, data[:size]...))
+		if err != nil {
+			return err
+		}
+
+		data = data[size:]
+		if len(data) == 0 {
+			return nil
+		}
+	}
+}
+
+// ReadFrame receives the next frame.
+func (c *Conn) ReadFrame() (byte, []byte, error) {
+	header := make([]byte, 5)
+	_, err := io.ReadFull(c.rw, header)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > MaxFrameSize {
+		return 0, nil, fmt.Errorf("Frame too large: %d bytes", size)
+	}
+
+	data := make([]byte, size)
+	_, err = io.ReadFull(c.rw, data)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return header[0], data, nil
+}
+
+// Send sends a request or a response.
+func (c *Conn) Send(msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	if len(data) > MaxFrameSize {
+		return fmt.Errorf("Message too large: %d bytes", len(data))
+	}
+
+	return c.WriteFrame(ChannelControl, data)
+}
+
+// Receive receives a request or a response, which must be the next frame.
+func (c *Conn) Receive(msg interface{}) error {
+	channel, data, err := c.ReadFrame()
+	if err != nil {
+		return err
+	}
+
+	if channel != ChannelControl {
+		return fmt.Errorf("Unexpected frame on channel %d", channel)
+	}
+
+	return json.Unmarshal(data, msg)
+}
+
+// Writer returns a writer sending what's written to it on a channel. Closing
+// it ends the stream of the channel.
+func (c *Conn) Writer(channel byte) io.WriteCloser {
+	return &channelWriter{conn: c, channel: channel}
+}
+
+type channelWriter struct {
+	conn    *Conn
+	channel byte
+}
+
+func (w *channelWriter) Write(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	err := w.conn.WriteFrame(w.channel, data)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+func (w *channelWriter) Close() error {
+	return w.conn.WriteFrame(w.channel, nil)
+}
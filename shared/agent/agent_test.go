@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestConnFrames(t *testing.T) {
+	buf := &bytes.Buffer{}
+	c := NewConn(buf)
+
+	data := bytes.Repeat([]byte("x"), MaxFrameSize+10)
+	err := c.WriteFrame(ChannelStdout, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := c.Writer(ChannelStderr)
+	w.Write([]byte("error"))
+	w.Close()
+
+	expected := []struct {
+		channel byte
+		size    int
+	}{
+		{ChannelStdout, MaxFrameSize},
+		{ChannelStdout, 10},
+		{ChannelStderr, 5},
+		{ChannelStderr, 0},
+	}
+
+	for _, e := range expected {
+		channel, data, err := c.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if channel != e.channel || len(data) != e.size {
+			t.Fatalf("Got %d bytes on channel %d, expected %d bytes on channel %d", len(data), channel, e.size, e.channel)
+		}
+	}
+}
+
+func TestConnMessages(t *testing.T) {
+	buf := &bytes.Buffer{}
+	c := NewConn(buf)
+
+	err := c.Send(Request{Type: RequestFileGet, Path: "/etc/hosts", UID: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := Request{}
+	err = c.Receive(&req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.Type != RequestFileGet || req.Path != "/etc/hosts" || req.UID != -1 {
+		t.Fatalf("Unexpected request: %+v", req)
+	}
+
+	c.WriteFrame(ChannelStdout, []byte("data"))
+	err = c.Receive(&req)
+	if err == nil {
+		t.Fatal("Receive accepted a frame which wasn't on the control channel")
+	}
+}
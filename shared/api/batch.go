@@ -0,0 +1,30 @@
+package api
+
+// BatchPost represents a set of profile and container changes applied as a
+// whole, all of them being reverted if any fails
+//
+// API extension: config_batch
+type BatchPost struct {
+	Profiles   []BatchProfile   `json:"profiles" yaml:"profiles"`
+	Containers []BatchContainer `json:"containers" yaml:"containers"`
+}
+
+// BatchProfile represents the new description, config and devices of an
+// existing profile within a batch
+//
+// API extension: config_batch
+type BatchProfile struct {
+	ProfilePut `yaml:",inline"`
+
+	Name string `json:"name" yaml:"name"`
+}
+
+// BatchContainer represents the config keys and devices to set on an
+// existing container within a batch, on top of its current ones
+//
+// API extension: config_batch
+type BatchContainer struct {
+	Name    string                       `json:"name" yaml:"name"`
+	Config  map[string]string            `json:"config" yaml:"config"`
+	Devices map[string]map[string]string `json:"devices" yaml:"devices"`
+}
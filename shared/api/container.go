@@ -28,6 +28,10 @@ type ContainerPost struct {
 
 	// API extension: container_push_target
 	Target *ContainerPostTarget `json:"target" yaml:"target"`
+
+	// Used for moves to another storage pool of the same server
+	// API extension: container_pool_move
+	Pool string `json:"pool,omitempty" yaml:"pool,omitempty"`
 }
 
 // ContainerPostTarget represents the migration target host and operation
@@ -39,6 +43,14 @@ type ContainerPostTarget struct {
 	Websockets  map[string]string `json:"secrets,omitempty" yaml:"secrets,omitempty"`
 }
 
+// ContainerRebuildPost represents the image the rootfs of a container is
+// rebuilt from
+//
+// API extension: container_rebuild
+type ContainerRebuildPost struct {
+	Source ContainerSource `json:"source" yaml:"source"`
+}
+
 // ContainerPut represents the modifiable fields of a LXD container
 type ContainerPut struct {
 	Architecture string                       `json:"architecture" yaml:"architecture"`
@@ -53,6 +65,9 @@ type ContainerPut struct {
 
 	// API extension: entity_description
 	Description string `json:"description" yaml:"description"`
+
+	// API extension: labels
+	Labels map[string]string `json:"labels" yaml:"labels"`
 }
 
 // Container represents a LXD container
@@ -116,4 +131,17 @@ type ContainerSource struct {
 
 	// API extension: container_only_migration
 	ContainerOnly bool `json:"container_only,omitempty" yaml:"container_only,omitempty"`
+
+	// API extension: container_refresh
+	Refresh bool `json:"refresh,omitempty" yaml:"refresh,omitempty"`
+
+	// API extension: container_copy_quiesce
+	Quiesce bool `json:"quiesce,omitempty" yaml:"quiesce,omitempty"`
+
+	// API extension: migration_conflict_policy
+	Conflict string `json:"conflict,omitempty" yaml:"conflict,omitempty"`
+
+	// For "copy" and "migration" types
+	// API extension: container_pool_move
+	Pool string `json:"pool,omitempty" yaml:"pool,omitempty"`
 }
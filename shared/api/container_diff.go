@@ -0,0 +1,11 @@
+package api
+
+// ContainerFileChange represents a file which changed between two states of a LXD container
+//
+// API extension: container_diff
+type ContainerFileChange struct {
+	Change   string `json:"change" yaml:"change"`
+	FileType string `json:"file_type" yaml:"file_type"`
+	Path     string `json:"path" yaml:"path"`
+	NewPath  string `json:"new_path,omitempty" yaml:"new_path,omitempty"`
+}
@@ -0,0 +1,17 @@
+package api
+
+import (
+	"time"
+)
+
+// ContainerReplication represents the replication status of a LXD container
+//
+// API extension: container_replication
+type ContainerReplication struct {
+	Target           string    `json:"target" yaml:"target"`
+	Schedule         string    `json:"schedule" yaml:"schedule"`
+	Running          bool      `json:"running" yaml:"running"`
+	LastSnapshot     string    `json:"last_snapshot" yaml:"last_snapshot"`
+	LastReplicatedAt time.Time `json:"last_replicated_at" yaml:"last_replicated_at"`
+	LastError        string    `json:"last_error" yaml:"last_error"`
+}
@@ -10,6 +10,38 @@ type ContainerSnapshotsPost struct {
 	Stateful bool   `json:"stateful" yaml:"stateful"`
 }
 
+// ContainerSnapshotsBulkPost represents a snapshot operation applied to all
+// the containers matching a filter
+//
+// API extension: container_snapshot_bulk
+type ContainerSnapshotsBulkPost struct {
+	Action   string                       `json:"action" yaml:"action"`
+	Name     string                       `json:"name" yaml:"name"`
+	Stateful bool                         `json:"stateful" yaml:"stateful"`
+	Filter   ContainerSnapshotsBulkFilter `json:"filter" yaml:"filter"`
+}
+
+// ContainerSnapshotsBulkFilter represents the criteria used to select the
+// containers of a bulk snapshot operation
+//
+// API extension: container_snapshot_bulk
+type ContainerSnapshotsBulkFilter struct {
+	Profile string `json:"profile" yaml:"profile"`
+	Name    string `json:"name" yaml:"name"`
+	All     bool   `json:"all" yaml:"all"`
+}
+
+// ContainerSnapshotsBulkResult represents the outcome of a bulk snapshot
+// operation for a single container
+//
+// API extension: container_snapshot_bulk
+type ContainerSnapshotsBulkResult struct {
+	Container string `json:"container" yaml:"container"`
+	Snapshot  string `json:"snapshot" yaml:"snapshot"`
+	Result    string `json:"result" yaml:"result"`
+	Error     string `json:"error" yaml:"error"`
+}
+
 // ContainerSnapshotPost represents the fields required to rename/move a LXD container snapshot
 type ContainerSnapshotPost struct {
 	Name      string               `json:"name" yaml:"name"`
@@ -30,4 +62,7 @@ type ContainerSnapshot struct {
 	Name            string                       `json:"name" yaml:"name"`
 	Profiles        []string                     `json:"profiles" yaml:"profiles"`
 	Stateful        bool                         `json:"stateful" yaml:"stateful"`
+
+	// API extension: snapshot_expiry
+	ExpiresAt time.Time `json:"expires_at" yaml:"expires_at"`
 }
@@ -20,11 +20,26 @@ type ContainerState struct {
 
 	// API extension: container_cpu_time
 	CPU ContainerStateCPU `json:"cpu" yaml:"cpu"`
+
+	// API extension: container_ready_state
+	Ready bool `json:"ready" yaml:"ready"`
+
+	// API extension: container_init_system
+	Init string `json:"init" yaml:"init"`
 }
 
 // ContainerStateDisk represents the disk information section of a LXD container's state
 type ContainerStateDisk struct {
 	Usage int64 `json:"usage" yaml:"usage"`
+
+	// API extension: container_disk_io
+	ReadBytes  int64 `json:"read_bytes" yaml:"read_bytes"`
+	WriteBytes int64 `json:"write_bytes" yaml:"write_bytes"`
+	ReadOps    int64 `json:"read_ops" yaml:"read_ops"`
+	WriteOps   int64 `json:"write_ops" yaml:"write_ops"`
+
+	// API extension: container_disk_alert
+	Alert bool `json:"alert" yaml:"alert"`
 }
 
 // ContainerStateCPU represents the cpu information section of a LXD container's state
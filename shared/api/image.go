@@ -34,6 +34,10 @@ type ImagesPostSource struct {
 	// For type "image"
 	Fingerprint string `json:"fingerprint" yaml:"fingerprint"`
 	Secret      string `json:"secret" yaml:"secret"`
+
+	// For type "build"
+	// API extension: image_build
+	Definition string `json:"definition" yaml:"definition"`
 }
 
 // ImagePut represents the modifiable fields of a LXD image
@@ -102,3 +106,19 @@ type ImageAliasesEntry struct {
 
 	Name string `json:"name" yaml:"name"`
 }
+
+// ImageServer represents a default image server and its statistics
+//
+// API extension: image_default_servers
+type ImageServer struct {
+	URL      string `json:"url" yaml:"url"`
+	Priority int    `json:"priority" yaml:"priority"`
+	Healthy  bool   `json:"healthy" yaml:"healthy"`
+
+	Requests            int64     `json:"requests" yaml:"requests"`
+	Failures            int64     `json:"failures" yaml:"failures"`
+	ConsecutiveFailures int64     `json:"consecutive_failures" yaml:"consecutive_failures"`
+	LastSuccess         time.Time `json:"last_success" yaml:"last_success"`
+	LastFailure         time.Time `json:"last_failure" yaml:"last_failure"`
+	LastError           string    `json:"last_error" yaml:"last_error"`
+}
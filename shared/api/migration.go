@@ -0,0 +1,54 @@
+package api
+
+// MigrationCapabilities represents what a LXD server supports as the target
+// of a migration
+//
+// API extension: migration_check
+type MigrationCapabilities struct {
+	CRIU             bool                             `json:"criu" yaml:"criu"`
+	UnprivilegedOnly bool                             `json:"unprivileged_only" yaml:"unprivileged_only"`
+	IdmapSize        int64                            `json:"idmap_size" yaml:"idmap_size"`
+	StoragePools     []MigrationStoragePoolCapability `json:"storage_pools" yaml:"storage_pools"`
+}
+
+// MigrationStoragePoolCapability represents how a storage pool receives
+// migrated containers
+//
+// API extension: migration_check
+type MigrationStoragePoolCapability struct {
+	Name            string   `json:"name" yaml:"name"`
+	Driver          string   `json:"driver" yaml:"driver"`
+	MigrationType   string   `json:"migration_type" yaml:"migration_type"`
+	BlockFilesystem string   `json:"block_filesystem,omitempty" yaml:"block_filesystem,omitempty"`
+	ZfsFeatures     []string `json:"zfs_features,omitempty" yaml:"zfs_features,omitempty"`
+}
+
+// ContainerMigrationCheckPost represents the target a migration is checked
+// against
+//
+// API extension: migration_check
+type ContainerMigrationCheckPost struct {
+	Target MigrationCapabilities `json:"target" yaml:"target"`
+	Pool   string                `json:"pool" yaml:"pool"`
+	Live   bool                  `json:"live" yaml:"live"`
+}
+
+// ContainerMigrationCheck represents whether a container can be migrated to
+// a target and how
+//
+// API extension: migration_check
+type ContainerMigrationCheck struct {
+	Compatible bool                            `json:"compatible" yaml:"compatible"`
+	Fs         string                          `json:"fs" yaml:"fs"`
+	Checks     []ContainerMigrationCheckResult `json:"checks" yaml:"checks"`
+}
+
+// ContainerMigrationCheckResult represents the result of one of the checks
+// of a migration
+//
+// API extension: migration_check
+type ContainerMigrationCheckResult struct {
+	Name    string `json:"name" yaml:"name"`
+	Status  string `json:"status" yaml:"status"`
+	Message string `json:"message" yaml:"message"`
+}
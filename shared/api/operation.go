@@ -17,3 +17,32 @@ type Operation struct {
 	MayCancel  bool                   `json:"may_cancel" yaml:"may_cancel"`
 	Err        string                 `json:"err" yaml:"err"`
 }
+
+// OperationPlan represents what a destructive operation would do, returned
+// instead of running it when "dry-run" is set
+//
+// API extension: dry_run
+type OperationPlan struct {
+	Actions  []string `json:"actions" yaml:"actions"`
+	Warnings []string `json:"warnings" yaml:"warnings"`
+}
+
+// OperationStage represents a stage of an operation made of several, in the
+// "stages" metadata of the operation
+//
+// API extension: operation_stages
+type OperationStage struct {
+	Name   string `json:"name" yaml:"name"`
+	Status string `json:"status" yaml:"status"`
+
+	// Bytes processed so far and expected in total, 0 if unknown
+	Processed int64 `json:"processed" yaml:"processed"`
+	Total     int64 `json:"total" yaml:"total"`
+
+	// Progress in percent and estimated seconds left, -1 if unknown
+	Percent int64 `json:"percent" yaml:"percent"`
+	ETA     int64 `json:"eta" yaml:"eta"`
+
+	// Bytes per second
+	Speed int64 `json:"speed" yaml:"speed"`
+}
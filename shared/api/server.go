@@ -1,5 +1,9 @@
 package api
 
+import (
+	"time"
+)
+
 // ServerEnvironment represents the read-only environment fields of a LXD server
 type ServerEnvironment struct {
 	Addresses              []string `json:"addresses" yaml:"addresses"`
@@ -16,6 +20,9 @@ type ServerEnvironment struct {
 	ServerVersion          string   `json:"server_version" yaml:"server_version"`
 	Storage                string   `json:"storage" yaml:"storage"`
 	StorageVersion         string   `json:"storage_version" yaml:"storage_version"`
+
+	// API extension: server_self_test
+	Capabilities []string `json:"capabilities" yaml:"capabilities"`
 }
 
 // ServerPut represents the modifiable fields of a LXD server configuration
@@ -44,3 +51,22 @@ type Server struct {
 func (srv *Server) Writable() ServerPut {
 	return srv.ServerPut
 }
+
+// ServerCheck represents the result of a single environment check
+//
+// API extension: server_self_test
+type ServerCheck struct {
+	Name      string `json:"name" yaml:"name"`
+	Category  string `json:"category" yaml:"category"`
+	Available bool   `json:"available" yaml:"available"`
+	Version   string `json:"version" yaml:"version"`
+	Message   string `json:"message" yaml:"message"`
+}
+
+// ServerSelfTest represents the capability report of a LXD server
+//
+// API extension: server_self_test
+type ServerSelfTest struct {
+	CheckedAt time.Time     `json:"checked_at" yaml:"checked_at"`
+	Checks    []ServerCheck `json:"checks" yaml:"checks"`
+}
@@ -0,0 +1,21 @@
+package api
+
+import (
+	"time"
+)
+
+// SignedURLPost represents the fields available to request a signed URL
+//
+// API extension: signed_urls
+type SignedURLPost struct {
+	ExpiresAt time.Time `json:"expires_at" yaml:"expires_at"`
+}
+
+// SignedURL represents a URL to download an image or a backup from without
+// a trusted client certificate, until it expires
+//
+// API extension: signed_urls
+type SignedURL struct {
+	URL       string    `json:"url" yaml:"url"`
+	ExpiresAt time.Time `json:"expires_at" yaml:"expires_at"`
+}
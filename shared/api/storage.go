@@ -1,5 +1,9 @@
 package api
 
+import (
+	"time"
+)
+
 // StoragePoolsPost represents the fields of a new LXD storage pool
 //
 // API extension: storage
@@ -19,6 +23,9 @@ type StoragePool struct {
 	Name   string   `json:"name" yaml:"name"`
 	Driver string   `json:"driver" yaml:"driver"`
 	UsedBy []string `json:"used_by" yaml:"used_by"`
+
+	// API extension: storage_zfs_health
+	Status string `json:"status" yaml:"status"`
 }
 
 // StoragePoolPut represents the modifiable fields of a LXD storage pool.
@@ -29,6 +36,9 @@ type StoragePoolPut struct {
 
 	// API extension: entity_description
 	Description string `json:"description" yaml:"description"`
+
+	// API extension: labels
+	Labels map[string]string `json:"labels" yaml:"labels"`
 }
 
 // StorageVolumesPost represents the fields of a new LXD storage pool volume
@@ -39,6 +49,26 @@ type StorageVolumesPost struct {
 
 	Name string `json:"name" yaml:"name"`
 	Type string `json:"type" yaml:"type"`
+
+	// API extension: storage_volume_copy
+	Source StorageVolumeSource `json:"source" yaml:"source"`
+}
+
+// StorageVolumeSource represents the custom storage volume a new one is copied
+// from
+//
+// API extension: storage_volume_copy
+type StorageVolumeSource struct {
+	Name    string `json:"name" yaml:"name"`
+	Flatten bool   `json:"flatten" yaml:"flatten"`
+}
+
+// StorageVolumePost represents the fields required to rename a LXD storage
+// volume
+//
+// API extension: storage_volume_rename
+type StorageVolumePost struct {
+	Name string `json:"name" yaml:"name"`
 }
 
 // StorageVolume represents the fields of a LXD storage volume.
@@ -59,6 +89,46 @@ type StorageVolumePut struct {
 
 	// API extension: entity_description
 	Description string `json:"description" yaml:"description"`
+
+	// API extension: storage_volume_snapshots
+	Restore string `json:"restore,omitempty" yaml:"restore,omitempty"`
+
+	// API extension: labels
+	Labels map[string]string `json:"labels" yaml:"labels"`
+}
+
+// StorageVolumeSnapshotsPost represents the fields available for a new LXD
+// storage volume snapshot
+//
+// API extension: storage_volume_snapshots
+type StorageVolumeSnapshotsPost struct {
+	Name string `json:"name" yaml:"name"`
+}
+
+// StorageVolumeSnapshotPost represents the fields required to rename a LXD
+// storage volume snapshot
+//
+// API extension: storage_volume_snapshots
+type StorageVolumeSnapshotPost struct {
+	Name string `json:"name" yaml:"name"`
+}
+
+// StorageVolumeSnapshot represents a LXD storage volume snapshot
+//
+// API extension: storage_volume_snapshots
+type StorageVolumeSnapshot struct {
+	Name   string            `json:"name" yaml:"name"`
+	Config map[string]string `json:"config" yaml:"config"`
+}
+
+// StorageVolumeState represents the space used by a LXD storage volume, in
+// bytes
+//
+// API extension: storage_volume_state
+type StorageVolumeState struct {
+	Usage          int64 `json:"usage" yaml:"usage"`
+	SnapshotsUsage int64 `json:"snapshots_usage" yaml:"snapshots_usage"`
+	Quota          int64 `json:"quota" yaml:"quota"`
 }
 
 // Writable converts a full StoragePool struct into a StoragePoolPut struct
@@ -72,3 +142,97 @@ func (storagePool *StoragePool) Writable() StoragePoolPut {
 func (storageVolume *StorageVolume) Writable() StorageVolumePut {
 	return storageVolume.StorageVolumePut
 }
+
+// StorageOperationRecord represents a completed storage operation
+//
+// API extension: storage_operation_history
+type StorageOperationRecord struct {
+	Pool      string    `json:"pool" yaml:"pool"`
+	Type      string    `json:"type" yaml:"type"`
+	Target    string    `json:"target" yaml:"target"`
+	StartedAt time.Time `json:"started_at" yaml:"started_at"`
+	Duration  int64     `json:"duration" yaml:"duration"`
+	Bytes     int64     `json:"bytes" yaml:"bytes"`
+	Result    string    `json:"result" yaml:"result"`
+	Error     string    `json:"error" yaml:"error"`
+}
+
+// StorageAuditEntry represents a destructive storage operation, who requested
+// it and the commands it ran
+//
+// API extension: storage_audit
+type StorageAuditEntry struct {
+	ID        int64     `json:"id" yaml:"id"`
+	Date      time.Time `json:"date" yaml:"date"`
+	Pool      string    `json:"pool" yaml:"pool"`
+	Action    string    `json:"action" yaml:"action"`
+	Target    string    `json:"target" yaml:"target"`
+	Requestor string    `json:"requestor" yaml:"requestor"`
+	Commands  []string  `json:"commands" yaml:"commands"`
+	Result    string    `json:"result" yaml:"result"`
+	Error     string    `json:"error" yaml:"error"`
+}
+
+// StoragePoolVerifyPost represents the fields of a storage pool verification
+//
+// API extension: storage_pool_verify
+type StoragePoolVerifyPost struct {
+	Fix bool `json:"fix" yaml:"fix"`
+}
+
+// StoragePoolDivergence represents a difference between the database records
+// of a storage pool and its on-disk state
+//
+// API extension: storage_pool_verify
+type StoragePoolDivergence struct {
+	Type     string `json:"type" yaml:"type"`
+	Entity   string `json:"entity" yaml:"entity"`
+	Name     string `json:"name" yaml:"name"`
+	Expected string `json:"expected" yaml:"expected"`
+	Found    string `json:"found" yaml:"found"`
+	Fixable  bool   `json:"fixable" yaml:"fixable"`
+	Fixed    bool   `json:"fixed" yaml:"fixed"`
+	Error    string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// StorageSource represents a block device, zpool or volume group a new storage
+// pool can be created on
+//
+// API extension: storage_sources
+type StorageSource struct {
+	Type    string   `json:"type" yaml:"type"`
+	Name    string   `json:"name" yaml:"name"`
+	Path    string   `json:"path" yaml:"path"`
+	Size    uint64   `json:"size" yaml:"size"`
+	Model   string   `json:"model" yaml:"model"`
+	Drivers []string `json:"drivers" yaml:"drivers"`
+}
+
+// StoragePoolResources represents the space used on a storage pool and the
+// forecast of its growth
+//
+// API extension: storage_pool_forecast
+type StoragePoolResources struct {
+	Space    StoragePoolResourcesSpace     `json:"space" yaml:"space"`
+	Forecast *StoragePoolResourcesForecast `json:"forecast" yaml:"forecast"`
+}
+
+// StoragePoolResourcesSpace represents the space used on a storage pool, in
+// bytes
+//
+// API extension: storage_pool_forecast
+type StoragePoolResourcesSpace struct {
+	Used  uint64 `json:"used" yaml:"used"`
+	Total uint64 `json:"total" yaml:"total"`
+}
+
+// StoragePoolResourcesForecast represents how fast a storage pool grows, as
+// estimated from its usage samples
+//
+// API extension: storage_pool_forecast
+type StoragePoolResourcesForecast struct {
+	Samples       int       `json:"samples" yaml:"samples"`
+	Since         time.Time `json:"since" yaml:"since"`
+	GrowthRate    int64     `json:"growth_rate" yaml:"growth_rate"`
+	DaysUntilFull float64   `json:"days_until_full" yaml:"days_until_full"`
+}
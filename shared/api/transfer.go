@@ -0,0 +1,25 @@
+package api
+
+import (
+	"time"
+)
+
+// Transfer represents a migration or copy of a container in flight
+//
+// API extension: shutdown_transfers
+type Transfer struct {
+	ID        string    `json:"id" yaml:"id"`
+	Type      string    `json:"type" yaml:"type"`
+	Container string    `json:"container" yaml:"container"`
+	StartedAt time.Time `json:"started_at" yaml:"started_at"`
+}
+
+// TransfersPost represents the fields required to relay the migration of a
+// container between two hosts which can't reach each other
+//
+// API extension: migration_relay
+type TransfersPost struct {
+	Container string              `json:"container" yaml:"container"`
+	Source    ContainerPostTarget `json:"source" yaml:"source"`
+	Target    ContainerPostTarget `json:"target" yaml:"target"`
+}
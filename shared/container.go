@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
 )
 
 type ContainerAction string
@@ -87,6 +90,50 @@ func IsAny(value string) error {
 	return nil
 }
 
+// cloudInitUserDataHeaders are the first lines cloud-init recognizes the
+// formats of user-data and vendor-data by.
+var cloudInitUserDataHeaders = []string{"#cloud-config", "#cloud-config-archive", "#cloud-boothook", "#include", "#include-once", "#part-handler", "#upstart-job", "#!", "Content-Type:"}
+
+// IsCloudInitYAML checks that the value is a YAML mapping, like cloud-init
+// meta-data, network-config and cloud-config.
+func IsCloudInitYAML(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	data := map[string]interface{}{}
+	err := yaml.Unmarshal([]byte(value), &data)
+	if err != nil {
+		return fmt.Errorf("Invalid YAML: %v", err)
+	}
+
+	return nil
+}
+
+// IsCloudInitUserData checks that the value is in one of the formats
+// cloud-init supports for user-data and vendor-data and, for cloud-config,
+// that it's valid YAML.
+func IsCloudInitUserData(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	header := strings.TrimRight(strings.SplitN(value, "\n", 2)[0], " \t\r")
+	for _, prefix := range cloudInitUserDataHeaders {
+		if !strings.HasPrefix(header, prefix) {
+			continue
+		}
+
+		if header == "#cloud-config" {
+			return IsCloudInitYAML(value)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("Unsupported cloud-init data, it should start with one of %s", cloudInitUserDataHeaders)
+}
+
 // KnownContainerConfigKeys maps all fully defined, well-known config keys
 // to an appropriate checker function, which validates whether or not a
 // given value is syntactically legal.
@@ -95,6 +142,66 @@ var KnownContainerConfigKeys = map[string]func(value string) error{
 	"boot.autostart.delay":       IsInt64,
 	"boot.autostart.priority":    IsInt64,
 	"boot.host_shutdown_timeout": IsInt64,
+	"boot.schedule.start": func(value string) error {
+		if value == "" {
+			return nil
+		}
+
+		_, _, err := ParseSchedule(value)
+		return err
+	},
+	"boot.schedule.stop": func(value string) error {
+		if value == "" {
+			return nil
+		}
+
+		_, _, err := ParseSchedule(value)
+		return err
+	},
+	"boot.schedule.timezone": func(value string) error {
+		_, err := time.LoadLocation(value)
+		return err
+	},
+
+	"backups.retention": IsUint32,
+	"backups.schedule": func(value string) error {
+		if value == "" {
+			return nil
+		}
+
+		_, _, err := ParseSchedule(value)
+		return err
+	},
+	"backups.schedule.timezone": func(value string) error {
+		_, err := time.LoadLocation(value)
+		return err
+	},
+
+	"replication.schedule": func(value string) error {
+		if value == "" {
+			return nil
+		}
+
+		_, _, err := ParseSchedule(value)
+		return err
+	},
+	"replication.schedule.timezone": func(value string) error {
+		_, err := time.LoadLocation(value)
+		return err
+	},
+	"replication.target": func(value string) error {
+		if value == "" {
+			return nil
+		}
+
+		fields := strings.Split(strings.TrimPrefix(value, "https://"), "/")
+		if len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+			return fmt.Errorf("Invalid replication target, expected <address>/<pool>")
+		}
+
+		return nil
+	},
+	"replication.target.fingerprint": IsAny,
 
 	"limits.cpu": IsAny,
 	"limits.cpu.allowance": func(value string) error {
@@ -132,6 +239,27 @@ var KnownContainerConfigKeys = map[string]func(value string) error{
 	},
 	"limits.cpu.priority": IsPriority,
 
+	"limits.disk.alert": func(value string) error {
+		if value == "" {
+			return nil
+		}
+
+		if strings.HasSuffix(value, "%") {
+			percent, err := strconv.ParseInt(strings.TrimSuffix(value, "%"), 10, 64)
+			if err != nil {
+				return err
+			}
+
+			if percent <= 0 || percent > 100 {
+				return fmt.Errorf("The disk alert percentage must be between 1 and 100")
+			}
+
+			return nil
+		}
+
+		_, err := ParseByteSizeString(value)
+		return err
+	},
 	"limits.disk.priority": IsPriority,
 
 	"limits.memory": func(value string) error {
@@ -167,31 +295,74 @@ var KnownContainerConfigKeys = map[string]func(value string) error{
 
 	"linux.kernel_modules": IsAny,
 
+	"migration.priority": IsPriority,
+
+	"migration.incremental.memory":            IsBool,
+	"migration.incremental.memory.iterations": IsUint32,
+	"migration.incremental.memory.goal": func(value string) error {
+		if value == "" {
+			return nil
+		}
+
+		valueInt, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("Invalid value for an integer: %s", value)
+		}
+
+		if valueInt < 0 || valueInt > 100 {
+			return fmt.Errorf("Invalid value for a percentage '%s'. Must be between 0 and 100.", value)
+		}
+
+		return nil
+	},
+
 	"security.nesting":    IsBool,
 	"security.privileged": IsBool,
 
-	"security.idmap.base":     IsUint32,
-	"security.idmap.isolated": IsBool,
-	"security.idmap.size":     IsUint32,
+	"security.agent": IsBool,
+
+	"security.file_monitor":          IsBool,
+	"security.file_monitor.interval": IsUint32,
+
+	"security.idmap.base":          IsUint32,
+	"security.idmap.isolated":      IsBool,
+	"security.idmap.shift_exclude": IsAny,
+	"security.idmap.size":          IsUint32,
 
 	"security.syscalls.blacklist_default": IsBool,
 	"security.syscalls.blacklist_compat":  IsBool,
 	"security.syscalls.blacklist":         IsAny,
 	"security.syscalls.whitelist":         IsAny,
 
+	"snapshots.expiry": func(value string) error {
+		_, err := GetSnapshotExpiry(time.Now(), value)
+		return err
+	},
+
+	"user.meta-data":      IsCloudInitYAML,
+	"user.network-config": IsCloudInitYAML,
+	"user.user-data":      IsCloudInitUserData,
+	"user.vendor-data":    IsCloudInitUserData,
+
 	// Caller is responsible for full validation of any raw.* value
 	"raw.apparmor": IsAny,
 	"raw.lxc":      IsAny,
 	"raw.seccomp":  IsAny,
 	"raw.idmap":    IsAny,
 
-	"volatile.apply_template":   IsAny,
-	"volatile.base_image":       IsAny,
-	"volatile.last_state.idmap": IsAny,
-	"volatile.last_state.power": IsAny,
-	"volatile.idmap.next":       IsAny,
-	"volatile.idmap.base":       IsAny,
-	"volatile.apply_quota":      IsAny,
+	"volatile.apply_template":                 IsAny,
+	"volatile.base_image":                     IsAny,
+	"volatile.last_state.idmap":               IsAny,
+	"volatile.last_state.idmap_shift_exclude": IsAny,
+	"volatile.last_state.power":               IsAny,
+	"volatile.last_state.ready":               IsBool,
+	"volatile.migration.partial":              IsBool,
+	"volatile.replication.last_error":         IsAny,
+	"volatile.replication.last_snapshot":      IsAny,
+	"volatile.replication.last_time":          IsAny,
+	"volatile.idmap.next":                     IsAny,
+	"volatile.idmap.base":                     IsAny,
+	"volatile.apply_quota":                    IsAny,
 }
 
 // ConfigKeyChecker returns a function that will check whether or not
@@ -0,0 +1,36 @@
+package shared
+
+import (
+	"testing"
+)
+
+func TestIsCloudInitUserData(t *testing.T) {
+	valid := []string{
+		"",
+		"#cloud-config\npackages:\n  - curl\n",
+		"#cloud-config\r\n",
+		"#!/bin/sh\necho hello\n",
+		"#include\nhttp://example.com/user-data\n",
+		"Content-Type: multipart/mixed; boundary=\"abc\"\n",
+	}
+
+	for _, value := range valid {
+		err := IsCloudInitUserData(value)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", value, err)
+		}
+	}
+
+	invalid := []string{
+		"packages:\n  - curl\n",
+		"#cloud-config\npackages: [curl\n",
+		"#cloud-config\n- curl\n",
+	}
+
+	for _, value := range invalid {
+		err := IsCloudInitUserData(value)
+		if err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
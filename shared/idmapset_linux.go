@@ -463,7 +463,10 @@ func (m IdmapSet) ShiftFromNs(uid int64, gid int64) (int64, int64) {
 	return m.doShiftIntoNs(uid, gid, "out")
 }
 
-func (set *IdmapSet) doUidshiftIntoContainer(dir string, testmode bool, how string) error {
+// doUidshiftIntoContainer shifts the ownership of the files under dir, except
+// for those skipper returns true for, along with their content if they're
+// directories.
+func (set *IdmapSet) doUidshiftIntoContainer(dir string, testmode bool, how string, skipper func(dir string, absPath string, fi os.FileInfo) bool) error {
 	// Expand any symlink before the final path component
 	tmp := filepath.Dir(dir)
 	tmp, err := filepath.EvalSymlinks(tmp)
@@ -478,6 +481,14 @@ func (set *IdmapSet) doUidshiftIntoContainer(dir string, testmode bool, how stri
 			return err
 		}
 
+		if skipper != nil && skipper(dir, path, fi) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
 		intUid, intGid, _, _, _, _, err := GetFileStat(path)
 		if err != nil {
 			return err
@@ -513,23 +524,23 @@ func (set *IdmapSet) doUidshiftIntoContainer(dir string, testmode bool, how stri
 }
 
 func (set *IdmapSet) UidshiftIntoContainer(dir string, testmode bool) error {
-	return set.doUidshiftIntoContainer(dir, testmode, "in")
+	return set.doUidshiftIntoContainer(dir, testmode, "in", nil)
 }
 
 func (set *IdmapSet) UidshiftFromContainer(dir string, testmode bool) error {
-	return set.doUidshiftIntoContainer(dir, testmode, "out")
+	return set.doUidshiftIntoContainer(dir, testmode, "out", nil)
 }
 
-func (set *IdmapSet) ShiftRootfs(p string) error {
-	return set.doUidshiftIntoContainer(p, false, "in")
+func (set *IdmapSet) ShiftRootfs(p string, skipper func(dir string, absPath string, fi os.FileInfo) bool) error {
+	return set.doUidshiftIntoContainer(p, false, "in", skipper)
 }
 
-func (set *IdmapSet) UnshiftRootfs(p string) error {
-	return set.doUidshiftIntoContainer(p, false, "out")
+func (set *IdmapSet) UnshiftRootfs(p string, skipper func(dir string, absPath string, fi os.FileInfo) bool) error {
+	return set.doUidshiftIntoContainer(p, false, "out", skipper)
 }
 
 func (set *IdmapSet) ShiftFile(p string) error {
-	return set.ShiftRootfs(p)
+	return set.ShiftRootfs(p, nil)
 }
 
 /*
@@ -0,0 +1,120 @@
+package shared
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Label names and values are up to 63 characters, starting and ending with
+// an alphanumeric character. Names may be prefixed by a DNS subdomain and a
+// slash, e.g. "example.com/tier".
+var labelNameRegexp = regexp.MustCompile(`^[A-Za-z0-9]([-_.A-Za-z0-9]{0,61}[A-Za-z0-9])?$`)
+var labelPrefixRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+// ValidLabel checks that a label has a valid key and value.
+func ValidLabel(key string, value string) error {
+	name := key
+	i := strings.LastIndex(key, "/")
+	if i >= 0 {
+		prefix := key[:i]
+		if len(prefix) > 253 || !labelPrefixRegexp.MatchString(prefix) {
+			return fmt.Errorf("Invalid label prefix: %s", key)
+		}
+
+		name = key[i+1:]
+	}
+
+	if !labelNameRegexp.MatchString(name) {
+		return fmt.Errorf("Invalid label name: %s", key)
+	}
+
+	if value != "" && !labelNameRegexp.MatchString(value) {
+		return fmt.Errorf("Invalid value for label '%s': %s", key, value)
+	}
+
+	return nil
+}
+
+// ValidLabels checks all the labels of a map.
+func ValidLabels(labels map[string]string) error {
+	for k, v := range labels {
+		err := ValidLabel(k, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type labelRequirement struct {
+	key    string
+	value  string
+	negate bool
+
+	// Whether the requirement only checks for the presence of the key.
+	exists bool
+}
+
+// LabelSelector is a list of requirements which must all be met by the
+// labels of an entity for it to be selected.
+type LabelSelector []labelRequirement
+
+// ParseLabelSelector parses a comma separated list of requirements, each of
+// which is one of "key=value" (or "key==value"), "key!=value", "key" (the
+// label is set) or "!key" (the label isn't set). An empty selector selects
+// everything.
+func ParseLabelSelector(selector string) (LabelSelector, error) {
+	result := LabelSelector{}
+
+	for _, field := range strings.Split(selector, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		req := labelRequirement{}
+		if strings.Contains(field, "!=") {
+			fields := strings.SplitN(field, "!=", 2)
+			req.key, req.value, req.negate = fields[0], fields[1], true
+		} else if strings.Contains(field, "=") {
+			fields := strings.SplitN(strings.Replace(field, "==", "=", 1), "=", 2)
+			req.key, req.value = fields[0], fields[1]
+		} else if strings.HasPrefix(field, "!") {
+			req.key, req.exists, req.negate = field[1:], true, true
+		} else {
+			req.key, req.exists = field, true
+		}
+
+		req.key = strings.TrimSpace(req.key)
+		req.value = strings.TrimSpace(req.value)
+
+		err := ValidLabel(req.key, req.value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid label selector '%s': %v", field, err)
+		}
+
+		result = append(result, req)
+	}
+
+	return result, nil
+}
+
+// Matches returns whether the labels meet all the requirements.
+func (selector LabelSelector) Matches(labels map[string]string) bool {
+	for _, req := range selector {
+		value, ok := labels[req.key]
+
+		matched := ok
+		if !req.exists {
+			matched = ok && value == req.value
+		}
+
+		if matched == req.negate {
+			return false
+		}
+	}
+
+	return true
+}
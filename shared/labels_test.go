@@ -0,0 +1,71 @@
+package shared
+
+import (
+	"testing"
+)
+
+func TestValidLabel(t *testing.T) {
+	valid := map[string]string{
+		"ssd":              "true",
+		"example.com/tier": "gold",
+		"env":              "",
+		"a_b.c-d":          "1.0",
+	}
+
+	for k, v := range valid {
+		err := ValidLabel(k, v)
+		if err != nil {
+			t.Errorf("Expected %s=%s to be valid: %v", k, v, err)
+		}
+	}
+
+	invalid := map[string]string{
+		"":            "true",
+		"-ssd":        "true",
+		"Example/ssd": "true",
+		"ssd":         "not valid",
+	}
+
+	for k, v := range invalid {
+		err := ValidLabel(k, v)
+		if err == nil {
+			t.Errorf("Expected %s=%s to be invalid", k, v)
+		}
+	}
+}
+
+func TestLabelSelector(t *testing.T) {
+	labels := map[string]string{"ssd": "true", "env": "prod"}
+
+	tests := map[string]bool{
+		"":                       true,
+		"ssd=true":               true,
+		"ssd==true":              true,
+		"ssd=false":              false,
+		"env!=dev":               true,
+		"env!=prod":              false,
+		"ssd":                    true,
+		"!ssd":                   false,
+		"!zone":                  true,
+		"ssd=true, env=prod":     true,
+		"ssd=true,env=prod,zone": false,
+		"zone!=a":                true,
+	}
+
+	for selector, expected := range tests {
+		s, err := ParseLabelSelector(selector)
+		if err != nil {
+			t.Errorf("Failed to parse '%s': %v", selector, err)
+			continue
+		}
+
+		if s.Matches(labels) != expected {
+			t.Errorf("Expected '%s' to return %v", selector, expected)
+		}
+	}
+
+	_, err := ParseLabelSelector("ssd=not valid")
+	if err == nil {
+		t.Error("Expected an invalid selector to fail")
+	}
+}
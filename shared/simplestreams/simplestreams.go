@@ -416,7 +416,49 @@ func (s *SimpleStreams) applyAliases(images []api.Image) ([]api.Image, map[strin
 		return &api.ImageAlias{Name: name}
 	}
 
+	// Short aliases point to the images of the host's architecture or,
+	// if there are none, to those of an architecture it can also run (e.g.
+	// i686 images on a x86_64 host).
+	architectures := []string{}
 	architectureName, _ := osarch.ArchitectureGetLocal()
+	architecture, err := osarch.ArchitectureId(architectureName)
+	if err == nil {
+		architectureName, _ = osarch.ArchitectureName(architecture)
+		architectures = append(architectures, architectureName)
+
+		personalities, _ := osarch.ArchitecturePersonalities(architecture)
+		for _, personality := range personalities {
+			name, err := osarch.ArchitectureName(personality)
+			if err == nil {
+				architectures = append(architectures, name)
+			}
+		}
+	}
+
+	architectureRank := func(name string) int {
+		for i, entry := range architectures {
+			if entry == name {
+				return i
+			}
+		}
+
+		return -1
+	}
+
+	shortRanks := map[string]int{}
+	for _, image := range images {
+		rank := architectureRank(image.Architecture)
+		if rank < 0 {
+			continue
+		}
+
+		for _, entry := range image.Aliases {
+			best, ok := shortRanks[entry.Name]
+			if !ok || rank < best {
+				shortRanks[entry.Name] = rank
+			}
+		}
+	}
 
 	newImages := []api.Image{}
 	for _, image := range images {
@@ -425,9 +467,11 @@ func (s *SimpleStreams) applyAliases(images []api.Image) ([]api.Image, map[strin
 			aliases := image.Aliases
 			image.Aliases = nil
 
+			rank := architectureRank(image.Architecture)
 			for _, entry := range aliases {
 				// Short
-				if image.Architecture == architectureName {
+				best, ok := shortRanks[entry.Name]
+				if rank >= 0 && ok && rank == best {
 					alias := addAlias(fmt.Sprintf("%s", entry.Name), image.Fingerprint)
 					if alias != nil {
 						image.Aliases = append(image.Aliases, *alias)
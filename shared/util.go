@@ -832,3 +832,149 @@ func Round(x float64) int64 {
 
 	return int64(math.Floor(x + 0.5))
 }
+
+// GetSnapshotExpiry returns the expiry date of a snapshot created at refDate
+// given an expression such as "1d 12H" or "4w". The supported units are M
+// (minutes), H (hours), d (days), w (weeks), m (months) and y (years).
+func GetSnapshotExpiry(refDate time.Time, s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	expr := strings.Fields(s)
+	expiry := refDate
+
+	for _, elem := range expr {
+		if len(elem) < 2 {
+			return time.Time{}, fmt.Errorf("Invalid expiry expression: %s", elem)
+		}
+
+		unit := elem[len(elem)-1:]
+		value, err := strconv.Atoi(elem[:len(elem)-1])
+		if err != nil || value < 0 {
+			return time.Time{}, fmt.Errorf("Invalid expiry expression: %s", elem)
+		}
+
+		switch unit {
+		case "M":
+			expiry = expiry.Add(time.Duration(value) * time.Minute)
+		case "H":
+			expiry = expiry.Add(time.Duration(value) * time.Hour)
+		case "d":
+			expiry = expiry.AddDate(0, 0, value)
+		case "w":
+			expiry = expiry.AddDate(0, 0, value*7)
+		case "m":
+			expiry = expiry.AddDate(0, value, 0)
+		case "y":
+			expiry = expiry.AddDate(value, 0, 0)
+		default:
+			return time.Time{}, fmt.Errorf("Invalid expiry unit: %s", unit)
+		}
+	}
+
+	return expiry, nil
+}
+
+var scheduleDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func scheduleParseDay(s string) (time.Weekday, error) {
+	for i, day := range scheduleDays {
+		if strings.ToLower(s) == day {
+			return time.Weekday(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("Invalid day of the week: %s", s)
+}
+
+// ParseSchedule parses a time of the day, optionally followed by the days of
+// the week it applies to, such as "20:00 mon-fri" or "06:00 sat,sun". The days
+// can also be "daily", the default, "weekdays" or "weekends". It returns the
+// time of the day as an offset from midnight and the days of the week.
+func ParseSchedule(s string) (time.Duration, []time.Weekday, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 1 || len(fields) > 2 {
+		return 0, nil, fmt.Errorf("Invalid schedule: %s", s)
+	}
+
+	clock, err := time.Parse("15:04", fields[0])
+	if err != nil {
+		return 0, nil, fmt.Errorf("Invalid time of the day: %s", fields[0])
+	}
+	offset := time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute
+
+	spec := "daily"
+	if len(fields) == 2 {
+		spec = strings.ToLower(fields[1])
+	}
+
+	switch spec {
+	case "daily":
+		spec = "sun-sat"
+	case "weekdays":
+		spec = "mon-fri"
+	case "weekends":
+		spec = "sat,sun"
+	}
+
+	days := []time.Weekday{}
+	for _, entry := range strings.Split(spec, ",") {
+		bounds := strings.SplitN(entry, "-", 2)
+
+		first, err := scheduleParseDay(bounds[0])
+		if err != nil {
+			return 0, nil, err
+		}
+
+		last := first
+		if len(bounds) == 2 {
+			last, err = scheduleParseDay(bounds[1])
+			if err != nil {
+				return 0, nil, err
+			}
+		}
+
+		// Ranges may wrap around the end of the week, like "fri-mon".
+		for day := first; ; day = (day + 1) % 7 {
+			days = append(days, day)
+			if day == last {
+				break
+			}
+		}
+	}
+
+	return offset, days, nil
+}
+
+// ScheduleDue returns whether a schedule as accepted by ParseSchedule falls
+// after from and no later than to, in the given location.
+func ScheduleDue(s string, loc *time.Location, from time.Time, to time.Time) (bool, error) {
+	offset, days, err := ParseSchedule(s)
+	if err != nil {
+		return false, err
+	}
+
+	from = from.In(loc)
+	to = to.In(loc)
+
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc)
+	for !day.After(to) {
+		// Build the time from the clock to stay right across DST changes.
+		hours := int(offset / time.Hour)
+		minutes := int((offset % time.Hour) / time.Minute)
+		at := time.Date(day.Year(), day.Month(), day.Day(), hours, minutes, 0, 0, loc)
+
+		if at.After(from) && !at.After(to) {
+			for _, weekday := range days {
+				if at.Weekday() == weekday {
+					return true, nil
+				}
+			}
+		}
+
+		day = day.AddDate(0, 0, 1)
+	}
+
+	return false, nil
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestURLEncode(t *testing.T) {
@@ -105,3 +106,106 @@ func TestReaderToChannel(t *testing.T) {
 		}
 	}
 }
+
+func TestGetSnapshotExpiry(t *testing.T) {
+	refDate := time.Date(2000, 1, 31, 12, 0, 0, 0, time.UTC)
+
+	tests := map[string]time.Time{
+		"":          time.Time{},
+		"30M":       time.Date(2000, 1, 31, 12, 30, 0, 0, time.UTC),
+		"1d 12H":    time.Date(2000, 2, 2, 0, 0, 0, 0, time.UTC),
+		"2w":        time.Date(2000, 2, 14, 12, 0, 0, 0, time.UTC),
+		"1m":        time.Date(2000, 3, 2, 12, 0, 0, 0, time.UTC),
+		"1y 1m 1d":  time.Date(2001, 3, 4, 12, 0, 0, 0, time.UTC),
+		"  7d   1H": time.Date(2000, 2, 7, 13, 0, 0, 0, time.UTC),
+	}
+
+	for expr, expected := range tests {
+		expiry, err := GetSnapshotExpiry(refDate, expr)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", expr, err)
+			continue
+		}
+
+		if !expiry.Equal(expected) {
+			t.Errorf("Expected %q to expire at %s, got %s", expr, expected, expiry)
+		}
+	}
+
+	for _, expr := range []string{"d", "7", "1x", "-1d", "1.5d", "d7"} {
+		_, err := GetSnapshotExpiry(refDate, expr)
+		if err == nil {
+			t.Errorf("Expected %q to be rejected", expr)
+		}
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	offset, days, err := ParseSchedule("20:30 fri-mon,wed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if offset != 20*time.Hour+30*time.Minute {
+		t.Errorf("Unexpected time of the day: %s", offset)
+	}
+
+	expected := []time.Weekday{time.Friday, time.Saturday, time.Sunday, time.Monday, time.Wednesday}
+	if fmt.Sprintf("%v", days) != fmt.Sprintf("%v", expected) {
+		t.Errorf("Expected %v, got %v", expected, days)
+	}
+
+	_, days, err = ParseSchedule("06:00")
+	if err != nil || len(days) != 7 {
+		t.Errorf("Expected every day, got %v (%v)", days, err)
+	}
+
+	for _, s := range []string{"", "6pm", "25:00", "06:00 mon-", "06:00 monday", "06:00 mon fri"} {
+		_, _, err := ParseSchedule(s)
+		if err == nil {
+			t.Errorf("Expected %q to be rejected", s)
+		}
+	}
+}
+
+func TestScheduleDue(t *testing.T) {
+	loc, err := time.LoadLocation("UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2017-06-30 is a Friday.
+	friday := time.Date(2017, 6, 30, 19, 59, 30, 0, loc)
+
+	tests := []struct {
+		schedule string
+		from     time.Time
+		to       time.Time
+		due      bool
+	}{
+		{"20:00 weekdays", friday, friday.Add(time.Minute), true},
+		{"20:00 weekdays", friday.Add(time.Minute), friday.Add(2 * time.Minute), false},
+		{"20:00 weekends", friday, friday.Add(time.Minute), false},
+		{"06:00 mon", friday, friday.AddDate(0, 0, 3), true},
+		{"06:00 mon", friday, friday.AddDate(0, 0, 2), false},
+	}
+
+	for _, test := range tests {
+		due, err := ScheduleDue(test.schedule, loc, test.from, test.to)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", test.schedule, err)
+			continue
+		}
+
+		if due != test.due {
+			t.Errorf("Expected %q between %s and %s to be due: %v", test.schedule, test.from, test.to, test.due)
+		}
+	}
+
+	// The location the schedule is in matters.
+	plus2 := time.FixedZone("UTC+2", 2*3600)
+	due, err := ScheduleDue("22:00", plus2, friday, friday.Add(time.Minute))
+	if err != nil || !due {
+		t.Errorf("Expected 22:00 UTC+2 to be due at 20:00 UTC, got %v (%v)", due, err)
+	}
+}
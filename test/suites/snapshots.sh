@@ -151,19 +151,19 @@ snap_restore() {
 
   ##########################################################
 
-  if [ "$lxd_backend" != "zfs" ]; then
-    # The problem here is that you can't `zfs rollback` to a snapshot with a
-    # parent, which snap0 has (snap1).
-    restore_and_compare_fs snap0
-
-    # Check container config has been restored (limits.cpu is unset)
-    cpus=$(lxc config get bar limits.cpu)
-    if [ -n "${cpus}" ]; then
-      echo "==> config didn't match expected value after restore (${cpus})"
-      false
-    fi
+  # On ZFS, snap0 isn't the latest snapshot so a clone of it gets swapped in.
+  restore_and_compare_fs snap0
+
+  # Check container config has been restored (limits.cpu is unset)
+  cpus=$(lxc config get bar limits.cpu)
+  if [ -n "${cpus}" ]; then
+    echo "==> config didn't match expected value after restore (${cpus})"
+    false
   fi
 
+  # The newer snapshot is kept
+  lxc info bar | grep snap1
+
   ##########################################################
 
   # test restore using full snapshot name
@@ -181,13 +181,10 @@ snap_restore() {
   # Start container and then restore snapshot to verify the running state after restore.
   lxc start bar
 
-  if [ "$lxd_backend" != "zfs" ]; then
-    # see comment above about snap0
-    restore_and_compare_fs snap0
+  restore_and_compare_fs snap0
 
-    # check container is running after restore
-    lxc list | grep bar | grep RUNNING
-  fi
+  # check container is running after restore
+  lxc list | grep bar | grep RUNNING
 
   lxc stop --force bar
 